@@ -0,0 +1,99 @@
+package epub
+
+import (
+	"fmt"
+	"html"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Layout of the publication date shown in the header of an article
+const articleDateLayout = "January 2, 2006"
+
+// Article is a news story or a blog post, see AddArticle.
+type Article struct {
+	Title     string    // Title of the article, also used as the title of the section
+	Author    string    // Byline of the article, optional
+	Published time.Time // Publication time of the article, optional
+	SourceURL string    // URL the article was taken from, optional
+	Body      string    // Content of the article, as the body of AddSection
+}
+
+// AddArticle adds an article as a section, e.g. for the digests of news feeds,
+// and returns its internal filename. The body of the section is the body of
+// the article preceded by a header with its title, byline, publication date
+// and source, so that all the articles of an EPUB look alike:
+//
+//	<article>
+//	  <header class="article-header">
+//	    <h1 class="article-title">Title</h1>
+//	    <p class="article-byline">By <span class="article-author">Author</span></p>
+//	    <p class="article-date"><time datetime="2006-01-02T15:04:05Z">January 2, 2006</time></p>
+//	    <p class="article-source"><a href="https://example.com/story">example.com</a></p>
+//	  </header>
+//	  Body
+//	</article>
+//
+// The elements of the fields left empty are omitted, and the classes can be
+// styled with the CSS file of the section. The author, publication time and
+// source URL are also written to the <head> element of the section as the
+// author and dcterms.created meta tags and a canonical link.
+//
+// The internal filename and the internal CSS path are handled as in
+// AddSection.
+func (e *Epub) AddArticle(article Article, internalFilename string, internalCSSPath string) (string, error) {
+	e.Lock()
+	defer e.Unlock()
+	filename, err := e.addSection("", articleBody(article), article.Title, internalFilename, internalCSSPath)
+	if err != nil {
+		return filename, err
+	}
+	if head := articleHead(article); head != "" {
+		findSection(e.sections, filename).xhtml.setHead(head)
+	}
+	return filename, nil
+}
+
+// articleBody returns the body of the section of an article
+func articleBody(a Article) string {
+	var b strings.Builder
+	b.WriteString(`<article><header class="article-header">`)
+	if a.Title != "" {
+		b.WriteString(`<h1 class="article-title">` + html.EscapeString(a.Title) + `</h1>`)
+	}
+	if a.Author != "" {
+		b.WriteString(`<p class="article-byline">By <span class="article-author">` + html.EscapeString(a.Author) + `</span></p>`)
+	}
+	if !a.Published.IsZero() {
+		b.WriteString(fmt.Sprintf(`<p class="article-date"><time datetime="%s">%s</time></p>`,
+			a.Published.Format(time.RFC3339), html.EscapeString(a.Published.Format(articleDateLayout))))
+	}
+	if a.SourceURL != "" {
+		label := a.SourceURL
+		if u, err := url.Parse(a.SourceURL); err == nil && u.Host != "" {
+			label = strings.TrimPrefix(u.Host, "www.")
+		}
+		b.WriteString(`<p class="article-source">` + creditsLink(label, a.SourceURL) + `</p>`)
+	}
+	b.WriteString(`</header>`)
+	b.WriteString(a.Body)
+	b.WriteString(`</article>`)
+	return b.String()
+}
+
+// articleHead returns the metadata of an article written to the <head>
+// element of its section
+func articleHead(a Article) string {
+	var head []string
+	if a.Author != "" {
+		head = append(head, `<meta name="author" content="`+html.EscapeString(a.Author)+`" />`)
+	}
+	if !a.Published.IsZero() {
+		head = append(head, `<meta name="dcterms.created" content="`+a.Published.Format(time.RFC3339)+`" />`)
+	}
+	if detectMediaType(a.SourceURL) == "URL" {
+		head = append(head, `<link rel="canonical" href="`+html.EscapeString(a.SourceURL)+`" />`)
+	}
+	return strings.Join(head, "\n")
+}
@@ -0,0 +1,60 @@
+package epub
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-shiori/go-epub/internal/storage"
+)
+
+func TestAddArticle(t *testing.T) {
+	e, err := NewEpub(testEpubTitle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	filename, err := e.AddArticle(Article{
+		Title:     "Gophers & Friends",
+		Author:    "Jane Doe",
+		Published: time.Date(2024, time.March, 5, 10, 30, 0, 0, time.UTC),
+		SourceURL: "https://www.example.com/news/gophers",
+		Body:      "<p>Story</p>",
+	}, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+	defer cleanup(testEpubFilename, tempDir)
+
+	contents, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, xhtmlFolderName, filename))
+	if err != nil {
+		t.Fatalf("Unexpected error reading article: %s", err)
+	}
+	for _, want := range []string{
+		`<meta name="author" content="Jane Doe" />`,
+		`<meta name="dcterms.created" content="2024-03-05T10:30:00Z" />`,
+		`<link rel="canonical" href="https://www.example.com/news/gophers" />`,
+		`<h1 class="article-title">Gophers &amp; Friends</h1>`,
+		`<p class="article-byline">By <span class="article-author">Jane Doe</span></p>`,
+		`<time datetime="2024-03-05T10:30:00Z">March 5, 2024</time>`,
+		`<a href="https://www.example.com/news/gophers">example.com</a>`,
+		`</header><p>Story</p></article>`,
+		`<title dir="auto">Gophers &amp; Friends</title>`,
+	} {
+		if !strings.Contains(string(contents), want) {
+			t.Errorf("Article doesn't contain %s\nGot: %s", want, contents)
+		}
+	}
+}
+
+func TestAddArticleOptionalFields(t *testing.T) {
+	body := articleBody(Article{Title: "Title", Body: "<p>Story</p>"})
+	if strings.Contains(body, "article-byline") || strings.Contains(body, "<time") || strings.Contains(body, "article-source") {
+		t.Errorf("Unexpected elements for empty fields: %s", body)
+	}
+	if head := articleHead(Article{Title: "Title"}); head != "" {
+		t.Errorf("Unexpected head for empty fields: %s", head)
+	}
+}
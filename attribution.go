@@ -0,0 +1,156 @@
+package epub
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+const (
+	creditsFilename      = "credits.xhtml"
+	creditsTitle         = "Credits"
+	creditsImagesTitle   = "Image credits"
+	pkgCreatorProperty   = "dcterms:creator"
+	pkgSourceProperty    = "dcterms:source"
+	pkgLicenseProperty   = "dcterms:license"
+	pkgRightsProperty    = "dcterms:rights"
+	attributionSeparator = ". "
+)
+
+// Attribution records where a third-party resource comes from and the terms
+// it is used under.
+type Attribution struct {
+	Author     string // Author of the resource
+	Source     string // Where the resource was taken from, e.g. the URL of its page
+	License    string // Name of the license, e.g. "CC BY-SA 4.0"
+	LicenseURL string // URL of the text of the license
+}
+
+// SetAttribution records the attribution of an already-added resource, given
+// the path returned by the Add* method, e.g. for images under a Creative
+// Commons license.
+//
+// When the EPUB is written, a credits page listing the attributions is added
+// at the end of the reading order, titled "Image credits" if all the resources
+// attributed are images, and the attributions are added to the package
+// metadata as dcterms:creator, dcterms:source, dcterms:license and
+// dcterms:rights refinements of the manifest items of the resources. A zero
+// Attribution removes the attribution of the resource. If no resource with
+// the path exists, ResourceDoesNotExistError will be returned.
+func (e *Epub) SetAttribution(internalPath string, attribution Attribution) error {
+	e.Lock()
+	defer e.Unlock()
+	mediaFolderName, filename := path.Split(strings.TrimPrefix(internalPath, "../"))
+	mediaFolderName = strings.TrimSuffix(mediaFolderName, "/")
+	if _, ok := e.mediaMap(mediaFolderName)[filename]; !ok {
+		return &ResourceDoesNotExistError{Filename: internalPath}
+	}
+	name := path.Join(mediaFolderName, filename)
+	if attribution == (Attribution{}) {
+		delete(e.attributions, name)
+		return nil
+	}
+	if e.attributions == nil {
+		e.attributions = make(map[string]Attribution)
+	}
+	e.attributions[name] = attribution
+	return nil
+}
+
+// attributed returns the folder and filename of the resources with an
+// attribution, in order
+func (e *Epub) attributed() []string {
+	var names []string
+	for name := range e.attributions {
+		mediaFolderName, filename := path.Split(name)
+		if _, ok := e.mediaMap(strings.TrimSuffix(mediaFolderName, "/"))[filename]; ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// writeAttributions adds the attributions to the package metadata and writes
+// the credits page at the end of the EPUB
+func (e *Epub) writeAttributions(rootEpubDir string) error {
+	e.pkg.unsetRefinements(pkgCreatorProperty, pkgSourceProperty, pkgLicenseProperty, pkgRightsProperty)
+	names := e.attributed()
+	if len(names) == 0 {
+		return nil
+	}
+
+	title := creditsImagesTitle
+	var b strings.Builder
+	for _, name := range names {
+		a := e.attributions[name]
+		mediaFolderName, filename := path.Split(name)
+		if strings.TrimSuffix(mediaFolderName, "/") != ImageFolderName {
+			title = creditsTitle
+		}
+
+		id, err := fixXMLId(filename)
+		if err != nil {
+			return fmt.Errorf("error creating xml id: %w", err)
+		}
+		e.pkg.setRefinement(id, pkgCreatorProperty, a.Author)
+		e.pkg.setRefinement(id, pkgSourceProperty, a.Source)
+		e.pkg.setRefinement(id, pkgLicenseProperty, a.LicenseURL)
+		e.pkg.setRefinement(id, pkgRightsProperty, a.License)
+
+		var parts []string
+		if a.Author != "" {
+			parts = append(parts, "By "+html.EscapeString(a.Author))
+		}
+		if a.Source != "" {
+			parts = append(parts, "Source: "+creditsLink(a.Source, a.Source))
+		}
+		if a.License != "" || a.LicenseURL != "" {
+			license := a.License
+			if license == "" {
+				license = a.LicenseURL
+			}
+			parts = append(parts, "License: "+creditsLink(license, a.LicenseURL))
+		}
+		b.WriteString(`<li epub:type="credit"><cite>` + html.EscapeString(filename) + `</cite>`)
+		if len(parts) > 0 {
+			b.WriteString(attributionSeparator + strings.Join(parts, attributionSeparator))
+		}
+		b.WriteString(`</li>`)
+	}
+
+	x, err := newXhtml(`<section epub:type="credits"><h1>` + title + `</h1><ul>` + b.String() + `</ul></section>`)
+	if err != nil {
+		return fmt.Errorf("can't create credits page: %w", err)
+	}
+	x.setTitle(title)
+	x.setXmlnsEpub(xmlnsEpub)
+	x.prependCSS(e.linkedCSS(b.String()))
+	if dir := e.direction(); dir != "" {
+		x.setDir(dir)
+	}
+	filename := creditsFilename
+	for i := 2; findSection(e.sections, filename) != nil; i++ {
+		filename = fmt.Sprintf("credits-%d.xhtml", i)
+	}
+	if err := x.write(filepath.Join(rootEpubDir, contentFolderName, xhtmlFolderName, filename)); err != nil {
+		return err
+	}
+
+	e.pkg.addToSpine(filename, true)
+	e.pkg.addToManifest(filename, filepath.Join(xhtmlFolderName, filename), mediaTypeXhtml, "")
+	return nil
+}
+
+// creditsLink returns the markup of text linking to href if it is a web URL,
+// or text alone otherwise
+func creditsLink(text string, href string) string {
+	if detectMediaType(href) != "URL" {
+		return html.EscapeString(text)
+	}
+	return `<a href="` + html.EscapeString(href) + `">` + html.EscapeString(text) + `</a>`
+}
@@ -0,0 +1,79 @@
+package epub
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-shiori/go-epub/internal/storage"
+)
+
+func TestSetAttribution(t *testing.T) {
+	e, err := NewEpub(testEpubTitle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	imagePath, err := e.AddImage(testImageFromFileSource, testImageFromFileFilename)
+	if err != nil {
+		t.Fatalf("Error adding image: %s", err)
+	}
+	err = e.SetAttribution(imagePath, Attribution{
+		Author:     "Renée French",
+		Source:     "https://go.dev/blog/gopher",
+		License:    "CC BY 4.0",
+		LicenseURL: "https://creativecommons.org/licenses/by/4.0/",
+	})
+	if err != nil {
+		t.Fatalf("Error setting attribution: %s", err)
+	}
+	err = e.SetAttribution("../"+ImageFolderName+"/missing.png", Attribution{Author: "Nobody"})
+	if _, ok := err.(*ResourceDoesNotExistError); !ok {
+		t.Errorf("Expected error ResourceDoesNotExistError not returned. Returned instead: %+v", err)
+	}
+
+	tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+	defer cleanup(testEpubFilename, tempDir)
+
+	credits, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, xhtmlFolderName, creditsFilename))
+	if err != nil {
+		t.Fatalf("Unexpected error reading credits page: %s", err)
+	}
+	expected := `<section epub:type="credits"><h1>Image credits</h1><ul><li epub:type="credit"><cite>` + testImageFromFileFilename + `</cite>. By Renée French. ` +
+		`Source: <a href="https://go.dev/blog/gopher">https://go.dev/blog/gopher</a>. ` +
+		`License: <a href="https://creativecommons.org/licenses/by/4.0/">CC BY 4.0</a></li></ul></section>`
+	if !strings.Contains(string(credits), expected) {
+		t.Errorf("Credits page doesn't match\nGot: %s\nExpected: %s", credits, expected)
+	}
+
+	pkg, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, pkgFilename))
+	if err != nil {
+		t.Fatalf("Unexpected error reading package file: %s", err)
+	}
+	id, err := fixXMLId(testImageFromFileFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		`<meta refines="#` + id + `" property="dcterms:creator">Renée French</meta>`,
+		`<meta refines="#` + id + `" property="dcterms:rights">CC BY 4.0</meta>`,
+		`<itemref idref="` + creditsFilename + `"></itemref>`,
+	} {
+		if !strings.Contains(string(pkg), expected) {
+			t.Errorf("Package file doesn't contain %s:\n%s", expected, pkg)
+		}
+	}
+
+	// Removing the attribution removes the page and the metadata
+	if err := e.SetAttribution(imagePath, Attribution{}); err != nil {
+		t.Errorf("Error removing attribution: %s", err)
+	}
+	tempDir2 := writeAndExtractEpub(t, e, testEpubFilename)
+	defer cleanup(testEpubFilename, tempDir2)
+	pkg, err = storage.ReadFile(filesystem, filepath.Join(tempDir2, contentFolderName, pkgFilename))
+	if err != nil {
+		t.Fatalf("Unexpected error reading package file: %s", err)
+	}
+	if strings.Contains(string(pkg), creditsFilename) || strings.Contains(string(pkg), "dcterms:creator") {
+		t.Errorf("Package file still lists the attribution:\n%s", pkg)
+	}
+}
@@ -0,0 +1,79 @@
+package epub
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"path"
+)
+
+const (
+	// id of the heading of a track section, synchronized with the whole track
+	audioTrackTitleID    = "track-title"
+	audioTrackBodyFormat = `<h1 id="` + audioTrackTitleID + `">%[1]s</h1>
+<audio id="track-audio" src="%[2]s" controls="controls">
+  <a href="%[2]s">%[1]s</a>
+</audio>`
+)
+
+// AddAudioTrack adds an audio file as a track of an audiobook or a podcast,
+// as AddAudio does, along with a section playing it. The section is added to
+// the reading order and to the table of contents with the title of the track,
+// so that the tracks of an audio-first publication are listed and played in
+// the order they were added. Text sections can be mixed with the tracks. If
+// the title is empty, the internal filename of the audio file is used.
+//
+// When the duration of the track is known, see SetDurationProber, a media
+// overlay synchronizing the title of the section with the whole track is
+// written, so that reading systems supporting media overlays play the tracks
+// one after the other, and the duration is added to the package metadata.
+//
+// The internal filename of the audio file is taken from the source. The
+// internal filename of the section is handled as in AddSection, which is
+// returned.
+func (e *Epub) AddAudioTrack(source string, trackTitle string, internalFilename string) (string, error) {
+	return e.AddAudioTrackContext(context.Background(), source, trackTitle, internalFilename)
+}
+
+// AddAudioTrackContext is like AddAudioTrack, stopping the retrieval of the
+// source when ctx is done.
+func (e *Epub) AddAudioTrackContext(ctx context.Context, source string, trackTitle string, internalFilename string) (string, error) {
+	e.Lock()
+	defer e.Unlock()
+	n := len(e.audios)
+	audioPath, err := e.addResource(ctx, source, "", audioFileFormat, AudioFolderName, e.audios)
+	if err != nil {
+		return "", err
+	}
+	audioFilename := path.Base(audioPath)
+	if trackTitle == "" {
+		trackTitle = audioFilename
+	}
+	body := fmt.Sprintf(audioTrackBodyFormat, html.EscapeString(trackTitle), html.EscapeString(audioPath))
+	filename, err := e.addSection("", body, trackTitle, internalFilename, "")
+	if err != nil {
+		// The audio file is kept if it was already added
+		if len(e.audios) > n {
+			e.forgetResource(AudioFolderName, audioFilename)
+		}
+		return "", err
+	}
+	findSection(e.sections, filename).track = audioFilename
+	return filename, nil
+}
+
+// trackOverlay returns the media overlay of a track section synchronizing its
+// title with the whole track, or nil if the duration of the track is unknown
+func (e *Epub) trackOverlay(section *epubSection) *mediaOverlay {
+	if section.track == "" || e.durationProber == nil {
+		return nil
+	}
+	d, ok := e.durations[section.track]
+	if !ok || d <= 0 {
+		return nil
+	}
+	return &mediaOverlay{
+		audioFilename: section.track,
+		syncPoints:    []SyncPoint{{TextFragmentID: audioTrackTitleID, ClipEnd: d}},
+	}
+}
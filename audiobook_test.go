@@ -0,0 +1,83 @@
+package epub
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-shiori/go-epub/internal/storage"
+)
+
+func TestAddAudioTrack(t *testing.T) {
+	e, err := NewEpub(testEpubTitle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.SetDurationProber(ProbeWAVDuration)
+	first, err := e.AddAudioTrack(testAudioFromFileSource, "Chapter <1>", "track1.xhtml")
+	if err != nil {
+		t.Fatalf("Error adding audio track: %s", err)
+	}
+	if _, err := e.AddAudioTrack(testAudioFromFileSource, "", "track2.xhtml"); err != nil {
+		t.Fatalf("Error adding audio track: %s", err)
+	}
+	if _, err := e.AddAudioTrack(testAudioFromFileSource, "Duplicate", first); err == nil {
+		t.Error("Expected an error adding a track with a section filename already used")
+	}
+	if audios := e.Audios(); len(audios) != 2 {
+		t.Errorf("Expected the audio of the failed track to be removed, got %+v", audios)
+	}
+
+	tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+	defer cleanup(testEpubFilename, tempDir)
+
+	section, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, xhtmlFolderName, first))
+	if err != nil {
+		t.Fatalf("Unexpected error reading section: %s", err)
+	}
+	if !strings.Contains(string(section), `<h1 id="track-title">Chapter &lt;1&gt;</h1>`) ||
+		!strings.Contains(string(section), `<audio id="track-audio" src="../audios/sample_audio.wav" controls="controls">`) {
+		t.Errorf("Unexpected track section:\n%s", section)
+	}
+
+	nav, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, "nav.xhtml"))
+	if err != nil {
+		t.Fatalf("Unexpected error reading nav: %s", err)
+	}
+	if !strings.Contains(string(nav), "Chapter &lt;1&gt;") || !strings.Contains(string(nav), "audio0002.wav") {
+		t.Errorf("Tracks missing from the nav:\n%s", nav)
+	}
+
+	smil, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, smilFolderName, "track1.smil"))
+	if err != nil {
+		t.Fatalf("Unexpected error reading media overlay: %s", err)
+	}
+	if !strings.Contains(string(smil), `<text src="../xhtml/track1.xhtml#track-title"></text>`) ||
+		!strings.Contains(string(smil), `clipBegin="0:00:00.000" clipEnd="0:00:00.190"`) {
+		t.Errorf("Unexpected media overlay:\n%s", smil)
+	}
+	pkg, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, pkgFilename))
+	if err != nil {
+		t.Fatalf("Unexpected error reading package file: %s", err)
+	}
+	if !strings.Contains(string(pkg), `<meta property="media:duration">0:00:00.379</meta>`) {
+		t.Errorf("Package file doesn't contain the total duration:\n%s", pkg)
+	}
+}
+
+func TestAddAudioTrackContext(t *testing.T) {
+	e, err := NewEpub(testEpubTitle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := e.AddAudioTrackContext(ctx, testAudioFromFileSource, "Chapter 1", ""); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+	if len(e.Audios()) != 0 || len(e.Sections()) != 0 {
+		t.Error("Track added after the context was canceled")
+	}
+}
@@ -0,0 +1,216 @@
+package epub
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// buildCache holds the compressed content of the files of the last build, so
+// that the next build only fetches and compresses what changed. See
+// SetIncrementalBuild.
+type buildCache struct {
+	// The key is the path of the file in the EPUB
+	entries map[string]*cacheEntry
+	// Resources of the current build whose fetching was skipped, by path
+	reused map[string]*cacheEntry
+	// Resources fetched during the current build, by path
+	fetched map[string]*cacheEntry
+	// Paths of the files written during the current build
+	written map[string]bool
+}
+
+// cacheEntry is a file of the EPUB as stored in the archive
+type cacheEntry struct {
+	// Identifies the content: the source of resources, a hash otherwise
+	key string
+	// Media type of resources
+	mediaType string
+	header    zip.FileHeader
+	// Compressed content
+	data []byte
+}
+
+// SetIncrementalBuild sets whether the compressed content of the files of the
+// EPUB is kept in memory between Write calls, so that rebuilding after small
+// edits, e.g. in an editor, only compresses the files that changed and doesn't
+// fetch again the resources whose source is unchanged. Local files are
+// fetched again when their size or modification time change, remote ones are
+// assumed not to change. Resources spooled to disk because of
+// SetMaxBufferSize are never kept.
+//
+// Disabling it releases the kept content.
+func (e *Epub) SetIncrementalBuild(enabled bool) {
+	e.Lock()
+	defer e.Unlock()
+	if !enabled {
+		e.cache = nil
+	} else if e.cache == nil {
+		e.cache = &buildCache{entries: make(map[string]*cacheEntry)}
+	}
+}
+
+// start prepares the cache for a new build
+func (c *buildCache) start() {
+	if c == nil {
+		return
+	}
+	c.reused = make(map[string]*cacheEntry)
+	c.fetched = make(map[string]*cacheEntry)
+	c.written = make(map[string]bool)
+}
+
+// finish ends the current build. If it is complete, the files that are not
+// part of the EPUB anymore are dropped.
+func (c *buildCache) finish(complete bool) {
+	if c == nil {
+		return
+	}
+	for name := range c.entries {
+		if complete && !c.written[name] {
+			delete(c.entries, name)
+		}
+	}
+	c.reused, c.fetched, c.written = nil, nil, nil
+}
+
+// mediaKey identifies the content of a resource from its source, local paths
+// being resolved in fsys if set
+func mediaKey(fsys fs.FS, source string) string {
+	switch detectMediaType(source) {
+	case "DataURL":
+		sum := sha256.Sum256([]byte(source))
+		return "data:" + hex.EncodeToString(sum[:])
+	case "URL":
+		return source
+	}
+	var info fs.FileInfo
+	var err error
+	if fsys != nil {
+		info, err = fs.Stat(fsys, filepath.ToSlash(localPath(source)))
+	} else {
+		info, err = os.Stat(localPath(source))
+	}
+	if err != nil {
+		return source
+	}
+	return fmt.Sprintf("%s\x00%d\x00%d", source, info.Size(), info.ModTime().UnixNano())
+}
+
+// reuseMedia returns the media type of the resource at name if the previous
+// build fetched it from the same source, in which case it doesn't need to be
+// fetched again.
+func (c *buildCache) reuseMedia(fsys fs.FS, name string, source string) (string, bool) {
+	if c == nil {
+		return "", false
+	}
+	entry, ok := c.entries[name]
+	if !ok || entry.key != mediaKey(fsys, source) {
+		return "", false
+	}
+	c.reused[name] = entry
+	return entry.mediaType, true
+}
+
+// addMedia records a resource fetched during the current build
+func (c *buildCache) addMedia(fsys fs.FS, name string, source string, mediaType string) {
+	if c == nil {
+		return
+	}
+	c.fetched[name] = &cacheEntry{key: mediaKey(fsys, source), mediaType: mediaType}
+}
+
+// entry returns the content of the file at name, read from r, compressed with
+// the zip method and deflate level given, and whether it comes from the
+// previous build because it is unchanged
+func (c *buildCache) entry(name string, r io.Reader, method uint16, level int) (*cacheEntry, bool, error) {
+	if entry, ok := c.reused[name]; ok {
+		return entry, true, nil
+	}
+
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return nil, false, err
+	}
+	entry := c.fetched[name]
+	if entry == nil {
+		sum := sha256.Sum256(content)
+		entry = &cacheEntry{key: hex.EncodeToString(sum[:])}
+	}
+	if previous, ok := c.entries[name]; ok && previous.key == entry.key && previous.header.Method == method {
+		return previous, true, nil
+	}
+
+	data := content
+	if method == zip.Deflate {
+		var b bytes.Buffer
+		w, err := flate.NewWriter(&b, level)
+		if err != nil {
+			return nil, false, err
+		}
+		if _, err := w.Write(content); err != nil {
+			return nil, false, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, false, err
+		}
+		data = b.Bytes()
+	}
+	entry.header = zip.FileHeader{
+		Name:               name,
+		Method:             method,
+		CRC32:              crc32.ChecksumIEEE(content),
+		CompressedSize64:   uint64(len(data)),
+		UncompressedSize64: uint64(len(content)),
+	}
+	entry.data = data
+	c.entries[name] = entry
+	return entry, false, nil
+}
+
+// open returns the content of the file at name if it comes from the previous
+// build, in which case it isn't in the storage
+func (c *buildCache) open(name string) (io.ReadCloser, bool) {
+	if c == nil {
+		return nil, false
+	}
+	entry, ok := c.reused[name]
+	if !ok {
+		return nil, false
+	}
+	c.written[name] = true
+	if entry.header.Method == zip.Store {
+		return io.NopCloser(bytes.NewReader(entry.data)), true
+	}
+	return flate.NewReader(bytes.NewReader(entry.data)), true
+}
+
+// write adds the file at name, read from r, to the archive with the zip method
+// and deflate level given, reusing its compressed content from the previous
+// build if it is unchanged. It returns the uncompressed size of the file and
+// whether it was reused.
+func (c *buildCache) write(z *zip.Writer, name string, r io.Reader, method uint16, level int) (int64, bool, error) {
+	entry, reused, err := c.entry(name, r, method, level)
+	if err != nil {
+		return 0, false, err
+	}
+	// CreateRaw may change the header
+	header := entry.header
+	w, err := z.CreateRaw(&header)
+	if err != nil {
+		return 0, false, fmt.Errorf("error creating zip writer: %w", err)
+	}
+	if _, err := w.Write(entry.data); err != nil {
+		return 0, false, err
+	}
+	c.written[name] = true
+	return int64(entry.header.UncompressedSize64), reused, nil
+}
@@ -0,0 +1,131 @@
+package epub
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWriteTwice(t *testing.T) {
+	e, err := NewEpub(testEpubTitle)
+	if err != nil {
+		t.Error(err)
+	}
+	if _, err := e.AddSection(testSectionBody, testSectionTitle, testSectionFilename, ""); err != nil {
+		t.Errorf("Error adding section: %s", err)
+	}
+	if err := e.SetLandmark(testSectionFilename, LandmarkBodyMatter); err != nil {
+		t.Errorf("Error setting landmark: %s", err)
+	}
+
+	var first, second bytes.Buffer
+	if _, err := e.WriteTo(&first); err != nil {
+		t.Fatal(err)
+	}
+	manifest := len(e.pkg.xml.ManifestItems)
+	if _, err := e.WriteTo(&second); err != nil {
+		t.Fatal(err)
+	}
+	if len(e.pkg.xml.ManifestItems) != manifest || len(e.pkg.xml.Spine.Items) != 1 ||
+		len(e.pkg.xml.Guide.References) != 1 || len(e.toc.navXML.Links) != 1 || len(e.toc.landmarksXML.Links) != 1 {
+		t.Errorf("Entries added again by the second write: %+v %+v", e.pkg.xml, e.toc.navXML)
+	}
+}
+
+func TestSetIncrementalBuild(t *testing.T) {
+	imageSource := filepath.Join(t.TempDir(), "image.png")
+	image, err := os.ReadFile(testImageFromFileSource)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(imageSource, image, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	e, err := NewEpub(testEpubTitle)
+	if err != nil {
+		t.Error(err)
+	}
+	e.SetIncrementalBuild(true)
+	imagePath, err := e.AddImage(imageSource, "image.png")
+	if err != nil {
+		t.Error(err)
+	}
+	for _, filename := range []string{"chapter1.xhtml", "chapter2.xhtml"} {
+		if _, err := e.AddSection(`<img src="`+imagePath+`" alt="" />`, filename, filename, ""); err != nil {
+			t.Errorf("Error adding section: %s", err)
+		}
+	}
+
+	reused := func() map[string]bool {
+		t.Helper()
+		var b bytes.Buffer
+		if _, err := e.WriteTo(&b); err != nil {
+			t.Fatal(err)
+		}
+		// The archive must be readable whatever was reused
+		z, err := zip.NewReader(bytes.NewReader(b.Bytes()), int64(b.Len()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, f := range z.File {
+			r, err := f.Open()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := io.Copy(io.Discard, r); err != nil {
+				t.Errorf("Error reading %s: %s", f.Name, err)
+			}
+			r.Close()
+		}
+		files := map[string]bool{}
+		for _, f := range e.BuildReport().Files {
+			files[f.Path] = f.Reused
+		}
+		return files
+	}
+
+	files := reused()
+	for name, r := range files {
+		if r {
+			t.Errorf("%s reused by the first build", name)
+		}
+	}
+
+	if err := e.UpdateSection("chapter2.xhtml", `<p>Changed</p>`); err != nil {
+		t.Errorf("Error updating section: %s", err)
+	}
+	files = reused()
+	for name, expected := range map[string]bool{
+		"EPUB/images/image.png":     true,
+		"EPUB/xhtml/chapter1.xhtml": true,
+		"EPUB/xhtml/chapter2.xhtml": false,
+		"EPUB/" + tocNavFilename:    true,
+		"META-INF/container.xml":    true,
+	} {
+		if files[name] != expected {
+			t.Errorf("Got reused %v for %s, expected %v", files[name], name, expected)
+		}
+	}
+	if len(e.BuildReport().FetchTimings) != 0 {
+		t.Errorf("Unchanged image fetched again: %v", e.BuildReport().FetchTimings)
+	}
+
+	// A local file is fetched again once changed
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(imageSource, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if files = reused(); files["EPUB/images/image.png"] {
+		t.Error("Changed image reused")
+	}
+
+	e.SetIncrementalBuild(false)
+	if files = reused(); files["EPUB/xhtml/chapter1.xhtml"] {
+		t.Error("Section reused after disabling incremental builds")
+	}
+}
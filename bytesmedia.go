@@ -0,0 +1,119 @@
+package epub
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/gabriel-vasile/mimetype"
+	"github.com/vincent-petithory/dataurl"
+)
+
+// AddCSSFromBytes adds a CSS file with the given content to the EPUB, as
+// AddCSS does, for generated stylesheets. If no filename is provided, one with
+// the .css extension will be generated.
+func (e *Epub) AddCSSFromBytes(content []byte, internalFilename string) (string, error) {
+	return e.AddResourceBytes(content, internalFilename, mediaTypeCSS)
+}
+
+// AddImageFromBytes adds an image with the given content to the EPUB, as
+// AddImage does, for generated images. The media type is detected from the
+// content.
+func (e *Epub) AddImageFromBytes(content []byte, imageFilename string) (string, error) {
+	mediaType := mimetype.Detect(content).String()
+	if !strings.HasPrefix(mediaType, "image/") {
+		return "", fmt.Errorf("can't add image %s: content of type %s", imageFilename, mediaType)
+	}
+	return e.AddResourceBytes(content, imageFilename, mediaType)
+}
+
+// AddResourceBytes adds a file with the given content to the EPUB and returns
+// a relative path to it that can be used in EPUB sections, sparing the caller
+// a temporary file; the content is kept in memory until the EPUB is written.
+// The media type selects whether the file is added as a CSS file (text/css), a
+// font (font/*), an image (image/*), a video (video/*) or an audio (audio/*),
+// as the matching Add* method does, and is written to the manifest as with
+// SetResourceMediaType; it is detected from the content if empty.
+//
+// The internal filename must be unique among the files of the same kind. If no
+// filename is provided, one will be generated with the extension of the media
+// type.
+func (e *Epub) AddResourceBytes(content []byte, internalFilename string, mediaType string) (string, error) {
+	return e.AddResourceBytesContext(context.Background(), content, internalFilename, mediaType)
+}
+
+// AddResourceBytesContext is like AddResourceBytes, stopping when ctx is done.
+func (e *Epub) AddResourceBytesContext(ctx context.Context, content []byte, internalFilename string, mediaType string) (string, error) {
+	detected := mediaType == ""
+	if detected {
+		mediaType = mimetype.Detect(content).String()
+	}
+	mediaType = strings.TrimSpace(strings.SplitN(mediaType, ";", 2)[0])
+
+	e.Lock()
+	defer e.Unlock()
+	var fileFormat string
+	var mediaMap map[string]string
+	folderName := resourceFolderName(mediaType)
+	switch folderName {
+	case CSSFolderName:
+		fileFormat, mediaMap = cssFileFormat, e.css
+	case FontFolderName:
+		fileFormat, mediaMap = fontFileFormat, e.fonts
+	case ImageFolderName:
+		fileFormat, mediaMap = imageFileFormat, e.images
+	case VideoFolderName:
+		fileFormat, mediaMap = videoFileFormat, e.videos
+	case AudioFolderName:
+		fileFormat, mediaMap = audioFileFormat, e.audios
+	default:
+		return "", fmt.Errorf("can't add %s: unsupported media type %s", internalFilename, mediaType)
+	}
+
+	if internalFilename == "" {
+		internalFilename = unusedFilename(fileFormat, mediaTypeExtension(mediaType), mediaMap)
+	}
+	// The content is checked as for the other sources, the media type only
+	// being written to the manifest
+	p, err := e.addResource(ctx, dataurl.New(content, "application/octet-stream").String(), internalFilename, fileFormat, folderName, mediaMap)
+	if err != nil || detected {
+		return p, err
+	}
+	if e.resourceMediaTypes == nil {
+		e.resourceMediaTypes = make(map[string]string)
+	}
+	e.resourceMediaTypes[path.Join(folderName, path.Base(p))] = mediaType
+	return p, nil
+}
+
+// resourceFolderName returns the folder of the resources of type mediaType,
+// or an empty string if it isn't a type of resource
+func resourceFolderName(mediaType string) string {
+	switch {
+	case mediaType == mediaTypeCSS:
+		return CSSFolderName
+	case strings.HasPrefix(mediaType, "font/"), strings.HasPrefix(mediaType, "application/font-"),
+		strings.HasPrefix(mediaType, "application/x-font-"), mediaType == "application/vnd.ms-opentype":
+		return FontFolderName
+	case strings.HasPrefix(mediaType, "image/"):
+		return ImageFolderName
+	case strings.HasPrefix(mediaType, "video/"):
+		return VideoFolderName
+	case strings.HasPrefix(mediaType, "audio/"):
+		return AudioFolderName
+	}
+	return ""
+}
+
+// mediaTypeExtension returns the usual file extension of a media type, with
+// the leading dot, or an empty string if it is unknown
+func mediaTypeExtension(mediaType string) string {
+	if mediaType == mediaTypeCSS {
+		return ".css"
+	}
+	if mime := mimetype.Lookup(mediaType); mime != nil {
+		return mime.Extension()
+	}
+	return ""
+}
@@ -0,0 +1,125 @@
+package epub
+
+import (
+	"bytes"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-shiori/go-epub/internal/storage"
+)
+
+func TestAddResourceBytes(t *testing.T) {
+	e, err := NewEpub(testEpubTitle)
+	if err != nil {
+		t.Error(err)
+	}
+	testImageContents, err := os.ReadFile(testImageFromFileSource)
+	if err != nil {
+		t.Errorf("Unexpected error reading testdata image file: %s", err)
+	}
+	testCSSContents := []byte("body { color: red; }\n")
+
+	imagePath, err := e.AddImageFromBytes(testImageContents, "")
+	if err != nil {
+		t.Errorf("Error adding image: %s", err)
+	}
+	if imagePath != "../"+ImageFolderName+"/image0001.png" {
+		t.Errorf("Unexpected image path: %s", imagePath)
+	}
+	cssPath, err := e.AddCSSFromBytes(testCSSContents, "")
+	if err != nil {
+		t.Errorf("Error adding CSS: %s", err)
+	}
+	if cssPath != "../"+CSSFolderName+"/css0001.css" {
+		t.Errorf("Unexpected CSS path: %s", cssPath)
+	}
+	fontContents, err := os.ReadFile(testFontFromFileSource)
+	if err != nil {
+		t.Errorf("Unexpected error reading testdata font file: %s", err)
+	}
+	fontPath, err := e.AddResourceBytes(fontContents, "font.ttf", "")
+	if err != nil {
+		t.Errorf("Error adding font: %s", err)
+	}
+	if fontPath != "../"+FontFolderName+"/font.ttf" {
+		t.Errorf("Unexpected font path: %s", fontPath)
+	}
+
+	otfPath, err := e.AddResourceBytes(fontContents, "font.bin", "font/otf")
+	if err != nil {
+		t.Errorf("Error adding font: %s", err)
+	}
+	if mediaType := e.resourceMediaType(FontFolderName, path.Base(otfPath), "font/ttf"); mediaType != "font/otf" {
+		t.Errorf("Media type %s not kept, got %s", "font/otf", mediaType)
+	}
+
+	if _, err := e.AddCSSFromBytes(testCSSContents, "css0001.css"); err == nil {
+		t.Error("Expected FilenameAlreadyUsedError adding a CSS file with a used filename")
+	} else if _, ok := err.(*FilenameAlreadyUsedError); !ok {
+		t.Errorf("Expected error FilenameAlreadyUsedError not returned. Returned instead: %+v", err)
+	}
+	if _, err := e.AddImageFromBytes(testCSSContents, ""); err == nil {
+		t.Error("Expected an error adding CSS as an image")
+	}
+	if _, err := e.AddResourceBytes([]byte("text"), "file.txt", "text/plain"); err == nil {
+		t.Error("Expected an error adding a resource of unsupported media type")
+	}
+
+	tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+	defer cleanup(testEpubFilename, tempDir)
+
+	for path, expected := range map[string][]byte{
+		imagePath: testImageContents,
+		cssPath:   testCSSContents,
+		fontPath:  fontContents,
+	} {
+		contents, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, xhtmlFolderName, path))
+		if err != nil {
+			t.Errorf("Unexpected error reading %s from EPUB: %s", path, err)
+		}
+		if !bytes.Equal(contents, expected) {
+			t.Errorf("Contents of %s don't match", path)
+		}
+	}
+	pkg, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, pkgFilename))
+	if err != nil {
+		t.Errorf("Unexpected error reading package file: %s", err)
+	}
+	if !bytes.Contains(pkg, []byte(`href="css/css0001.css" media-type="text/css"`)) {
+		t.Errorf("Package file doesn't list the CSS file as text/css:\n%s", pkg)
+	}
+}
+
+func TestGeneratedFilenameAfterRemove(t *testing.T) {
+	e, err := NewEpub(testEpubTitle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(testImageFromFileSource)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var paths []string
+	for i := 0; i < 2; i++ {
+		p, err := e.AddImageFromBytes(content, "")
+		if err != nil {
+			t.Fatalf("Error adding image: %s", err)
+		}
+		paths = append(paths, p)
+	}
+	if err := e.RemoveImage(paths[0]); err != nil {
+		t.Fatal(err)
+	}
+	// image0002.png is still used, counting the images must not reuse it
+	for _, expected := range []string{"../images/image0003.png", "../images/image0004.png"} {
+		p, err := e.AddImageFromBytes(content, "")
+		if err != nil {
+			t.Fatalf("Error adding image after a removal: %s", err)
+		}
+		if p != expected {
+			t.Errorf("Got path %s, expected %s", p, expected)
+		}
+	}
+}
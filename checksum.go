@@ -0,0 +1,78 @@
+package epub
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+// ChecksumMismatchError is thrown by Write when the content fetched for a
+// resource doesn't have the SHA-256 hash set with SetExpectedSHA256.
+type ChecksumMismatchError struct {
+	Source   string // The source of the resource
+	Expected string // The hexadecimal hash expected
+	Actual   string // The hexadecimal hash of the content fetched
+}
+
+func (e *ChecksumMismatchError) Error() string {
+	return fmt.Sprintf("SHA-256 of %s is %s, expected %s", e.Source, e.Actual, e.Expected)
+}
+
+// SetExpectedSHA256 sets the SHA-256 hash, in hexadecimal, that the content of
+// an already-added resource must have, given the path returned by the Add*
+// method. It is checked each time the resource is fetched by Write, which
+// fails with a ChecksumMismatchError if it differs, so that reproducible
+// pipelines can guarantee that the asset packaged is exactly the one reviewed,
+// even if the remote resource changed since.
+//
+// The hash is that of the content as fetched, before the images are optimized
+// or converted and the fonts obfuscated. Resources with an expected hash are
+// always fetched again, even if the previous build could be reused. An empty
+// hash removes the check. If no resource with the path exists,
+// ResourceDoesNotExistError will be returned.
+func (e *Epub) SetExpectedSHA256(internalPath string, sha256Hex string) error {
+	sha256Hex = strings.ToLower(sha256Hex)
+	if sha256Hex != "" {
+		if b, err := hex.DecodeString(sha256Hex); err != nil || len(b) != sha256.Size {
+			return fmt.Errorf("invalid SHA-256 hash %q", sha256Hex)
+		}
+	}
+	e.Lock()
+	defer e.Unlock()
+	mediaFolderName, filename := path.Split(strings.TrimPrefix(internalPath, "../"))
+	mediaFolderName = strings.TrimSuffix(mediaFolderName, "/")
+	if _, ok := e.mediaMap(mediaFolderName)[filename]; !ok {
+		return &ResourceDoesNotExistError{Filename: internalPath}
+	}
+	name := path.Join(mediaFolderName, filename)
+	if sha256Hex == "" {
+		delete(e.checksums, name)
+		return nil
+	}
+	if e.checksums == nil {
+		e.checksums = make(map[string]string)
+	}
+	e.checksums[name] = sha256Hex
+	return nil
+}
+
+// verifyChecksum checks that the content fetched from mediaSource to
+// mediaFilePath has the SHA-256 hash expected
+func (g grabber) verifyChecksum(mediaSource string, mediaFilePath string, expected string) error {
+	r, err := g.spool.open(mediaFilePath)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return fmt.Errorf("unable to hash %s: %w", mediaSource, err)
+	}
+	if actual := hex.EncodeToString(h.Sum(nil)); actual != expected {
+		return &ChecksumMismatchError{Source: mediaSource, Expected: expected, Actual: actual}
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package epub
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestSetExpectedSHA256(t *testing.T) {
+	image, err := os.ReadFile(testImageFromFileSource)
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := image
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(served)
+	}))
+	defer ts.Close()
+
+	e, err := NewEpub(testEpubTitle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	imagePath, err := e.AddImage(ts.URL+"/image.png", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(image)
+	if err := e.SetExpectedSHA256(imagePath, hex.EncodeToString(sum[:])); err != nil {
+		t.Fatalf("Error setting expected SHA-256: %s", err)
+	}
+	if _, err := e.WriteTo(io.Discard); err != nil {
+		t.Errorf("Error writing EPUB with the expected image: %s", err)
+	}
+
+	served = append([]byte(nil), image...)
+	served[len(served)-1]++
+	_, err = e.WriteTo(io.Discard)
+	var mismatchErr *ChecksumMismatchError
+	if !errors.As(err, &mismatchErr) {
+		t.Fatalf("Expected a ChecksumMismatchError, got %v", err)
+	}
+	if mismatchErr.Expected != hex.EncodeToString(sum[:]) || mismatchErr.Source != ts.URL+"/image.png" {
+		t.Errorf("Unexpected mismatch error: %+v", mismatchErr)
+	}
+
+	if err := e.SetExpectedSHA256(imagePath, ""); err != nil {
+		t.Errorf("Error removing expected SHA-256: %s", err)
+	}
+	if _, err := e.WriteTo(io.Discard); err != nil {
+		t.Errorf("Error writing EPUB without expected SHA-256: %s", err)
+	}
+
+	if err := e.SetExpectedSHA256(imagePath, "abc"); err == nil {
+		t.Error("Expected an error for an invalid hash")
+	}
+	var notExistErr *ResourceDoesNotExistError
+	if err := e.SetExpectedSHA256("../images/missing.png", hex.EncodeToString(sum[:])); !errors.As(err, &notExistErr) {
+		t.Errorf("Expected ResourceDoesNotExistError, got %v", err)
+	}
+}
@@ -0,0 +1,245 @@
+package epub
+
+import (
+	"path"
+	"sort"
+	"strings"
+)
+
+// Feature is an EPUB feature that reading systems support unevenly.
+type Feature string
+
+// Features listed by CompatibilityReport
+const (
+	FeatureMathML          Feature = "mathml"
+	FeatureScripts         Feature = "scripts"
+	FeatureSVG             Feature = "svg"
+	FeatureAudio           Feature = "audio"
+	FeatureVideo           Feature = "video"
+	FeatureRemoteResources Feature = "remote-resources"
+	FeatureMediaOverlays   Feature = "media-overlays"
+	FeatureFixedLayout     Feature = "fixed-layout"
+	FeatureRTL             Feature = "rtl"
+	FeatureEmbeddedFonts   Feature = "embedded-fonts"
+)
+
+// Order of the features in the reports
+var features = []Feature{
+	FeatureMathML,
+	FeatureScripts,
+	FeatureSVG,
+	FeatureAudio,
+	FeatureVideo,
+	FeatureRemoteResources,
+	FeatureMediaOverlays,
+	FeatureFixedLayout,
+	FeatureRTL,
+	FeatureEmbeddedFonts,
+}
+
+// ReadingSystem is a reading system of the capability matrix of
+// CompatibilityReport.
+type ReadingSystem string
+
+// Reading systems of the capability matrix
+const (
+	ReadingSystemAppleBooks           ReadingSystem = "Apple Books"
+	ReadingSystemKobo                 ReadingSystem = "Kobo"
+	ReadingSystemKindle               ReadingSystem = "Kindle"
+	ReadingSystemGooglePlayBooks      ReadingSystem = "Google Play Books"
+	ReadingSystemAdobeDigitalEditions ReadingSystem = "Adobe Digital Editions"
+	ReadingSystemThorium              ReadingSystem = "Thorium Reader"
+)
+
+// Support is how well a reading system supports a feature.
+type Support int
+
+const (
+	// The feature works as intended
+	SupportFull Support = iota
+	// The feature works in some cases or with a fallback, e.g. MathML
+	// rendered as images
+	SupportPartial
+	// The feature is ignored or the content using it is unusable
+	SupportNone
+)
+
+func (s Support) String() string {
+	switch s {
+	case SupportFull:
+		return "full"
+	case SupportPartial:
+		return "partial"
+	case SupportNone:
+		return "none"
+	}
+	return "unknown"
+}
+
+// capabilities is the support of the features by the current versions of the
+// reading systems, for books sideloaded or sold through their store. Kindle
+// stands for the conversion of EPUBs by Send to Kindle and KDP. Features
+// missing for a reading system are fully supported.
+var capabilities = map[ReadingSystem]map[Feature]Support{
+	ReadingSystemAppleBooks: {
+		FeatureRemoteResources: SupportPartial,
+		// Embedded fonts are ignored unless the display options say
+		// otherwise, see SetIBooksDisplayOptions
+		FeatureEmbeddedFonts: SupportPartial,
+	},
+	ReadingSystemKobo: {
+		FeatureMathML:          SupportPartial,
+		FeatureScripts:         SupportPartial,
+		FeatureAudio:           SupportPartial,
+		FeatureVideo:           SupportPartial,
+		FeatureRemoteResources: SupportNone,
+		FeatureMediaOverlays:   SupportPartial,
+		FeatureRTL:             SupportPartial,
+	},
+	ReadingSystemKindle: {
+		FeatureMathML:          SupportPartial,
+		FeatureScripts:         SupportNone,
+		FeatureSVG:             SupportPartial,
+		FeatureAudio:           SupportNone,
+		FeatureVideo:           SupportNone,
+		FeatureRemoteResources: SupportNone,
+		FeatureMediaOverlays:   SupportNone,
+		FeatureFixedLayout:     SupportPartial,
+		FeatureRTL:             SupportPartial,
+	},
+	ReadingSystemGooglePlayBooks: {
+		FeatureScripts:         SupportPartial,
+		FeatureAudio:           SupportPartial,
+		FeatureVideo:           SupportPartial,
+		FeatureRemoteResources: SupportNone,
+		FeatureMediaOverlays:   SupportPartial,
+	},
+	ReadingSystemAdobeDigitalEditions: {
+		FeatureMathML:          SupportPartial,
+		FeatureScripts:         SupportNone,
+		FeatureAudio:           SupportPartial,
+		FeatureVideo:           SupportPartial,
+		FeatureRemoteResources: SupportNone,
+		FeatureMediaOverlays:   SupportNone,
+	},
+	ReadingSystemThorium: {
+		FeatureRemoteResources: SupportPartial,
+	},
+}
+
+// CompatibilityReport lists the features used by an EPUB that some reading
+// systems don't fully support. See Epub.CompatibilityReport.
+type CompatibilityReport struct {
+	// Features used by the EPUB
+	Features []Feature
+	// The key is a feature used, the value is the paths of the sections and
+	// resources using it relative to the EPUB folder, e.g. xhtml/section0001.xhtml,
+	// or the URLs of remote resources. It is empty for the features of the
+	// whole EPUB such as FeatureRTL.
+	Files map[Feature][]string
+	// Features used that reading systems don't fully support, by reading
+	// system then feature
+	Issues []CompatibilityIssue
+}
+
+// CompatibilityIssue is a feature used by an EPUB that a reading system
+// doesn't fully support.
+type CompatibilityIssue struct {
+	ReadingSystem ReadingSystem
+	Feature       Feature
+	Support       Support
+}
+
+// CompatibilityReport returns the features used by the EPUB as it would be
+// written, and how popular reading systems support them, so that the content
+// that will degrade on some of them can be found before release.
+//
+// The capability matrix reflects the current versions of the reading systems
+// and is only a guide: test the EPUB on the reading systems that matter.
+func (e *Epub) CompatibilityReport() *CompatibilityReport {
+	e.Lock()
+	defer e.Unlock()
+
+	files := map[Feature][]string{}
+	used := map[Feature]bool{}
+	use := func(f Feature, filename string) {
+		used[f] = true
+		if filename != "" {
+			files[f] = append(files[f], filename)
+		}
+	}
+	walkSections(e.sections, func(s *epubSection) {
+		filename := path.Join(xhtmlFolderName, s.filename)
+		for _, p := range strings.Fields(e.sectionProperties(s)) {
+			switch p {
+			case "mathml":
+				use(FeatureMathML, filename)
+			case "scripted":
+				use(FeatureScripts, filename)
+			case "svg":
+				use(FeatureSVG, filename)
+			case remoteResourcesProperty:
+				use(FeatureRemoteResources, filename)
+			}
+		}
+		if e.sectionOverlay(s) != nil {
+			use(FeatureMediaOverlays, filename)
+		}
+		if strings.Contains(s.xhtml.xml.Head.Extra, `name="viewport"`) {
+			use(FeatureFixedLayout, filename)
+		}
+	})
+	for _, m := range []struct {
+		feature         Feature
+		mediaFolderName string
+		mediaMap        map[string]string
+	}{
+		{FeatureAudio, AudioFolderName, e.audios},
+		{FeatureVideo, VideoFolderName, e.videos},
+		{FeatureEmbeddedFonts, FontFolderName, e.fonts},
+	} {
+		for filename := range m.mediaMap {
+			use(m.feature, path.Join(m.mediaFolderName, filename))
+		}
+		for source, r := range e.remoteResources {
+			if r.mediaFolderName == m.mediaFolderName {
+				use(m.feature, source)
+			}
+		}
+	}
+	if e.ibooksOptions != nil && e.ibooksOptions.FixedLayout {
+		use(FeatureFixedLayout, "")
+	}
+	if e.direction() == "rtl" {
+		use(FeatureRTL, "")
+	}
+
+	report := &CompatibilityReport{Files: files}
+	for _, f := range features {
+		if used[f] {
+			report.Features = append(report.Features, f)
+			sort.Strings(files[f])
+		}
+	}
+	readingSystems := make([]string, 0, len(capabilities))
+	for rs := range capabilities {
+		readingSystems = append(readingSystems, string(rs))
+	}
+	sort.Strings(readingSystems)
+	for _, rs := range readingSystems {
+		for _, f := range report.Features {
+			support := capabilities[ReadingSystem(rs)][f]
+			if ReadingSystem(rs) == ReadingSystemAppleBooks && f == FeatureEmbeddedFonts && e.ibooksOptions != nil && e.ibooksOptions.SpecifiedFonts {
+				support = SupportFull
+			}
+			if support != SupportFull {
+				report.Issues = append(report.Issues, CompatibilityIssue{
+					ReadingSystem: ReadingSystem(rs),
+					Feature:       f,
+					Support:       support,
+				})
+			}
+		}
+	}
+	return report
+}
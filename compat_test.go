@@ -0,0 +1,65 @@
+package epub
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCompatibilityReport(t *testing.T) {
+	e, err := NewEpub(testEpubTitle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report := e.CompatibilityReport(); len(report.Features) != 0 || len(report.Issues) != 0 {
+		t.Errorf("Expected an empty report, got %+v", report)
+	}
+
+	if _, err := e.AddSection(`<p>Plain</p>`, "Plain", "plain.xhtml", ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.AddSection(`<math xmlns="http://www.w3.org/1998/Math/MathML"><mi>x</mi></math>`, "Math", "math.xhtml", ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.AddFont(testFontFromFileSource, ""); err != nil {
+		t.Fatal(err)
+	}
+	e.SetLang("ar")
+
+	report := e.CompatibilityReport()
+	if expected := []Feature{FeatureMathML, FeatureRTL, FeatureEmbeddedFonts}; !reflect.DeepEqual(report.Features, expected) {
+		t.Errorf("Expected features %v, got %v", expected, report.Features)
+	}
+	if files := report.Files[FeatureMathML]; !reflect.DeepEqual(files, []string{"xhtml/math.xhtml"}) {
+		t.Errorf("Unexpected files using MathML: %v", files)
+	}
+	if files := report.Files[FeatureEmbeddedFonts]; !reflect.DeepEqual(files, []string{"fonts/redacted-script-regular.ttf"}) {
+		t.Errorf("Unexpected files using embedded fonts: %v", files)
+	}
+	if files, ok := report.Files[FeatureRTL]; ok {
+		t.Errorf("Unexpected files for RTL: %v", files)
+	}
+
+	issues := map[ReadingSystem]map[Feature]Support{}
+	for _, issue := range report.Issues {
+		if issues[issue.ReadingSystem] == nil {
+			issues[issue.ReadingSystem] = map[Feature]Support{}
+		}
+		issues[issue.ReadingSystem][issue.Feature] = issue.Support
+	}
+	if s := issues[ReadingSystemKindle][FeatureMathML]; s != SupportPartial {
+		t.Errorf("Expected partial MathML support on Kindle, got %s", s)
+	}
+	if s, ok := issues[ReadingSystemAppleBooks][FeatureEmbeddedFonts]; !ok || s != SupportPartial {
+		t.Errorf("Expected partial font support on Apple Books, got %s", s)
+	}
+	if _, ok := issues[ReadingSystemThorium]; ok {
+		t.Errorf("Unexpected issues on Thorium: %v", issues[ReadingSystemThorium])
+	}
+
+	e.SetIBooksDisplayOptions(&IBooksDisplayOptions{SpecifiedFonts: true})
+	for _, issue := range e.CompatibilityReport().Issues {
+		if issue.ReadingSystem == ReadingSystemAppleBooks && issue.Feature == FeatureEmbeddedFonts {
+			t.Error("Embedded fonts reported on Apple Books with SpecifiedFonts set")
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package epub
+
+import (
+	"archive/zip"
+	"compress/flate"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+// Compression sets how the files of the EPUB are compressed in the archive.
+// See SetCompression.
+type Compression struct {
+	// Level of the deflate compression, from flate.BestSpeed to
+	// flate.BestCompression, or flate.HuffmanOnly. 0 and
+	// flate.DefaultCompression are the default level: files that mustn't be
+	// compressed are listed in Store instead.
+	Level int
+	// Media types of the files stored without compression, e.g. "image/jpeg",
+	// or "image/*" for all the images. See PrecompressedMediaTypes.
+	Store []string
+}
+
+// PrecompressedMediaTypes are the media types of the resources that are
+// already compressed, so that deflating them again wastes time for little or
+// no gain. It is meant for Compression.Store.
+var PrecompressedMediaTypes = []string{
+	"image/jpeg",
+	"image/png",
+	"image/gif",
+	"image/webp",
+	"font/woff",
+	"font/woff2",
+	"application/font-woff",
+	"audio/*",
+	"video/*",
+}
+
+// SetCompression sets how the files of the EPUB are compressed by Write, e.g.
+// the best level for the XHTML files with the images and fonts stored as they
+// are:
+//
+//	e.SetCompression(epub.Compression{
+//		Level: flate.BestCompression,
+//		Store: epub.PrecompressedMediaTypes,
+//	})
+//
+// The zero Compression, the default, deflates every file at the default
+// level. The mimetype file is always stored, as the EPUB specification
+// requires. The media type of a file is the one of its item in the manifest,
+// and files out of the manifest, such as the package document, are always
+// deflated. Changing the compression drops the content kept by
+// SetIncrementalBuild.
+func (e *Epub) SetCompression(c Compression) error {
+	if c.Level < flate.HuffmanOnly || c.Level > flate.BestCompression {
+		return fmt.Errorf("invalid compression level %d", c.Level)
+	}
+	for _, mediaType := range c.Store {
+		if !strings.Contains(mediaType, "/") {
+			return fmt.Errorf("invalid media type %q", mediaType)
+		}
+	}
+	e.Lock()
+	defer e.Unlock()
+	e.compression = Compression{Level: c.Level, Store: append([]string(nil), c.Store...)}
+	if e.cache != nil {
+		e.cache.entries = make(map[string]*cacheEntry)
+	}
+	return nil
+}
+
+// compressionLevel returns the deflate level of the files
+func (c Compression) compressionLevel() int {
+	if c.Level == 0 {
+		return flate.DefaultCompression
+	}
+	return c.Level
+}
+
+// method returns the zip method of a file of the given media type
+func (c Compression) method(mediaType string) uint16 {
+	mediaType, _, _ = strings.Cut(mediaType, ";")
+	mediaType = strings.TrimSpace(mediaType)
+	if mediaType == "" {
+		return zip.Deflate
+	}
+	major, _, _ := strings.Cut(mediaType, "/")
+	for _, stored := range c.Store {
+		if strings.EqualFold(stored, mediaType) || strings.EqualFold(stored, major+"/*") {
+			return zip.Store
+		}
+	}
+	return zip.Deflate
+}
+
+// registerCompressor makes z deflate at the level of the compression
+func (c Compression) registerCompressor(z *zip.Writer) {
+	level := c.compressionLevel()
+	if level == flate.DefaultCompression {
+		return
+	}
+	z.RegisterCompressor(zip.Deflate, func(w io.Writer) (io.WriteCloser, error) {
+		return flate.NewWriter(w, level)
+	})
+}
+
+// manifestMediaTypes returns the media types of the items of the manifest, by
+// path relative to the root of the EPUB
+func (e *Epub) manifestMediaTypes() map[string]string {
+	mediaTypes := make(map[string]string, len(e.pkg.xml.ManifestItems))
+	for _, item := range e.pkg.xml.ManifestItems {
+		mediaTypes[path.Join(contentFolderName, item.Href)] = item.MediaType
+	}
+	return mediaTypes
+}
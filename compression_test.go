@@ -0,0 +1,78 @@
+package epub
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"path"
+	"path/filepath"
+	"testing"
+)
+
+func TestSetCompression(t *testing.T) {
+	imageName := path.Join(contentFolderName, ImageFolderName, filepath.Base(testImageFromFileSource))
+	sectionName := path.Join(contentFolderName, xhtmlFolderName, testSectionFilename)
+
+	for _, incremental := range []bool{false, true} {
+		e, err := NewEpub(testEpubTitle)
+		if err != nil {
+			t.Fatal(err)
+		}
+		e.SetIncrementalBuild(incremental)
+		if _, err := e.AddImage(testImageFromFileSource, ""); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := e.AddSection(testSectionBody, testSectionTitle, testSectionFilename, ""); err != nil {
+			t.Fatal(err)
+		}
+
+		methods := func() map[string]uint16 {
+			t.Helper()
+			var b bytes.Buffer
+			if _, err := e.WriteTo(&b); err != nil {
+				t.Fatalf("Error writing EPUB: %s", err)
+			}
+			r, err := zip.NewReader(bytes.NewReader(b.Bytes()), int64(b.Len()))
+			if err != nil {
+				t.Fatalf("Error reading EPUB: %s", err)
+			}
+			methods := make(map[string]uint16)
+			for _, f := range r.File {
+				methods[f.Name] = f.Method
+			}
+			return methods
+		}
+
+		m := methods()
+		if m[imageName] != zip.Deflate || m[sectionName] != zip.Deflate || m[mimetypeFilename] != zip.Store {
+			t.Errorf("Unexpected default methods (incremental build %t): %v", incremental, m)
+		}
+
+		if err := e.SetCompression(Compression{Level: flate.BestCompression, Store: PrecompressedMediaTypes}); err != nil {
+			t.Fatalf("Error setting compression: %s", err)
+		}
+		m = methods()
+		if m[imageName] != zip.Store || m[sectionName] != zip.Deflate || m[mimetypeFilename] != zip.Store {
+			t.Errorf("Unexpected methods with precompressed types stored (incremental build %t): %v", incremental, m)
+		}
+
+		if err := e.SetCompression(Compression{Store: []string{"application/*"}}); err != nil {
+			t.Fatalf("Error setting compression: %s", err)
+		}
+		m = methods()
+		if m[imageName] != zip.Deflate || m[sectionName] != zip.Store {
+			t.Errorf("Unexpected methods with XHTML stored (incremental build %t): %v", incremental, m)
+		}
+	}
+
+	e, err := NewEpub(testEpubTitle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.SetCompression(Compression{Level: 10}); err == nil {
+		t.Error("Expected an error for an invalid level")
+	}
+	if err := e.SetCompression(Compression{Store: []string{"jpeg"}}); err == nil {
+		t.Error("Expected an error for an invalid media type")
+	}
+}
@@ -0,0 +1,109 @@
+package epub
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"path/filepath"
+)
+
+const (
+	contentHashesFilename  = "hashes.json"
+	contentHashesAlgorithm = "sha-256"
+)
+
+// contentHashesRoot holds the actual JSON for META-INF/hashes.json
+// Ex: {
+//
+//	  "algorithm": "sha-256",
+//	  "files": {
+//	    "EPUB/xhtml/section0001.xhtml": "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+//	  }
+//	}
+type contentHashesRoot struct {
+	Algorithm string            `json:"algorithm"`
+	Files     map[string]string `json:"files"`
+}
+
+// SetContentHashes sets whether the SHA-256 hash of every section and resource
+// is computed when the EPUB is written, so that reading platforms can download
+// only the files that changed between two editions of the EPUB.
+//
+// The hashes are written to META-INF/hashes.json, keyed by the path of the
+// file inside the EPUB, e.g. EPUB/xhtml/section0001.xhtml, and are also
+// available from BuildReport.ContentHashes. The hash of a file only depends on
+// its content, so it is stable across builds as long as the file doesn't
+// change. The package document is left out, as its modification date changes
+// on every build.
+func (e *Epub) SetContentHashes(enabled bool) {
+	e.Lock()
+	defer e.Unlock()
+	e.contentHashes = enabled
+}
+
+// writeContentHashes hashes the files of the content folder and writes
+// META-INF/hashes.json, if enabled
+func (e *Epub) writeContentHashes(rootEpubDir string) error {
+	if !e.contentHashes {
+		return nil
+	}
+	hashes := make(map[string]string)
+	pkgFilePath := filepath.Join(rootEpubDir, contentFolderName, pkgFilename)
+	err := fs.WalkDir(filesystem, filepath.Join(rootEpubDir, contentFolderName), func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() || filepath.FromSlash(p) == pkgFilePath {
+			return nil
+		}
+		relativePath, err := filepath.Rel(rootEpubDir, p)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(relativePath)
+		hash, err := e.hashFile(p, name)
+		if err != nil {
+			return err
+		}
+		hashes[name] = hash
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("Error hashing content: %w", err)
+	}
+	if e.report != nil {
+		e.report.ContentHashes = hashes
+	}
+
+	output, err := json.MarshalIndent(contentHashesRoot{Algorithm: contentHashesAlgorithm, Files: hashes}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("Error marshalling JSON for content hashes: %w", err)
+	}
+	output = append(output, "\n"...)
+	if err := filesystem.WriteFile(filepath.Join(rootEpubDir, metaInfFolderName, contentHashesFilename), output, filePermissions); err != nil {
+		return fmt.Errorf("Error writing content hashes: %w", err)
+	}
+	return nil
+}
+
+// hashFile returns the hexadecimal SHA-256 hash of the file at filePath,
+// stored at name in the EPUB. The content of a file reused from the previous
+// build is read from the build cache, as only a placeholder is at filePath.
+func (e *Epub) hashFile(filePath string, name string) (string, error) {
+	r, ok := e.cache.open(name)
+	if !ok {
+		var err error
+		if r, err = e.spool.open(filePath); err != nil {
+			return "", err
+		}
+	}
+	defer r.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
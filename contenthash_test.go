@@ -0,0 +1,104 @@
+package epub
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-shiori/go-epub/internal/storage"
+)
+
+func TestSetContentHashes(t *testing.T) {
+	e, err := NewEpub(testEpubTitle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.SetContentHashes(true)
+	imagePath, err := e.AddImage(testImageFromFileSource, testImageFromFileFilename)
+	if err != nil {
+		t.Fatalf("Error adding image: %s", err)
+	}
+	sectionFilename, err := e.AddSection("<p>Section</p>", "Section", "", "")
+	if err != nil {
+		t.Fatalf("Error adding section: %s", err)
+	}
+
+	tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+	defer cleanup(testEpubFilename, tempDir)
+
+	data, err := storage.ReadFile(filesystem, filepath.Join(tempDir, metaInfFolderName, contentHashesFilename))
+	if err != nil {
+		t.Fatalf("Unexpected error reading content hashes: %s", err)
+	}
+	var hashes contentHashesRoot
+	if err := json.Unmarshal(data, &hashes); err != nil {
+		t.Fatalf("Unexpected error parsing content hashes: %s", err)
+	}
+	if hashes.Algorithm != "sha-256" {
+		t.Errorf("Algorithm doesn't match\nGot: %s\nExpected: sha-256", hashes.Algorithm)
+	}
+	if _, ok := hashes.Files[path.Join(contentFolderName, pkgFilename)]; ok {
+		t.Error("Package document hashed")
+	}
+	for _, p := range []string{
+		path.Join(contentFolderName, xhtmlFolderName, sectionFilename),
+		path.Join(contentFolderName, ImageFolderName, path.Base(imagePath)),
+	} {
+		content, err := storage.ReadFile(filesystem, filepath.Join(tempDir, filepath.FromSlash(p)))
+		if err != nil {
+			t.Fatalf("Unexpected error reading %s: %s", p, err)
+		}
+		sum := sha256.Sum256(content)
+		if expected := hex.EncodeToString(sum[:]); hashes.Files[p] != expected {
+			t.Errorf("Hash of %s doesn't match\nGot: %s\nExpected: %s", p, hashes.Files[p], expected)
+		}
+	}
+	if report := e.BuildReport(); len(report.ContentHashes) != len(hashes.Files) {
+		t.Errorf("Content hashes of the build report don't match\nGot: %v\nExpected: %v", report.ContentHashes, hashes.Files)
+	}
+
+	// The hashes are stable across builds
+	first := hashes.Files
+	tempDir2 := writeAndExtractEpub(t, e, testEpubFilename)
+	defer cleanup(testEpubFilename, tempDir2)
+	for p, hash := range first {
+		if e.BuildReport().ContentHashes[p] != hash {
+			t.Errorf("Hash of %s changed between builds", p)
+		}
+	}
+}
+
+func TestSetContentHashesIncremental(t *testing.T) {
+	e, err := NewEpub(testEpubTitle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.SetContentHashes(true)
+	e.SetIncrementalBuild(true)
+	imagePath, err := e.AddImage(testImageFromFileSource, testImageFromFileFilename)
+	if err != nil {
+		t.Fatalf("Error adding image: %s", err)
+	}
+	content, err := os.ReadFile(testImageFromFileSource)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(content)
+	expected := hex.EncodeToString(sum[:])
+
+	// The image is reused from the first build by the second one
+	name := path.Join(contentFolderName, ImageFolderName, path.Base(imagePath))
+	for i := 1; i <= 2; i++ {
+		if _, err := e.WriteTo(io.Discard); err != nil {
+			t.Fatalf("Error writing EPUB: %s", err)
+		}
+		if got := e.BuildReport().ContentHashes[name]; got != expected {
+			t.Errorf("Build %d: hash of %s doesn't match\nGot: %s\nExpected: %s", i, name, got, expected)
+		}
+	}
+}
@@ -0,0 +1,605 @@
+package epub
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"html/template"
+	"image"
+	// Register the formats whose dimensions are checked
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"io/fs"
+	"mime"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/vincent-petithory/dataurl"
+	"golang.org/x/net/html"
+)
+
+// CoverRequirements are the thresholds the cover image is checked against by
+// SetCover. Zero values aren't checked.
+type CoverRequirements struct {
+	MinWidth  int
+	MinHeight int
+	MaxWidth  int
+	MaxHeight int
+	// Height divided by width, e.g. 1.6 for a 1600x2560 image
+	MinAspectRatio float64
+	MaxAspectRatio float64
+	// In bytes
+	MaxFileSize int64
+	// If true, SetCover fails with a CoverValidationError when the image
+	// doesn't meet the requirements. Otherwise the problems are reported as
+	// warnings, see Warnings.
+	Strict bool
+}
+
+// Cover requirements of common stores. Copy and adjust them to change Strict.
+var (
+	// Apple Books requires covers at least 1400 pixels wide
+	CoverProfileAppleBooks = CoverRequirements{
+		MinWidth: 1400,
+	}
+	// Kindle Direct Publishing requires covers of at least 625x1000 pixels
+	// and at most 10000x10000 pixels, smaller than 50MB
+	CoverProfileKindle = CoverRequirements{
+		MinWidth:    625,
+		MinHeight:   1000,
+		MaxWidth:    10000,
+		MaxHeight:   10000,
+		MaxFileSize: 50 << 20,
+	}
+)
+
+// CoverValidationError is thrown by SetCover if the cover image doesn't meet
+// the requirements set with SetCoverRequirements in strict mode.
+type CoverValidationError struct {
+	Filename string   // The internal filename of the cover image
+	Problems []string // The requirements the image doesn't meet
+}
+
+func (e *CoverValidationError) Error() string {
+	return fmt.Sprintf("Cover image %s doesn't meet the requirements: %s", e.Filename, strings.Join(e.Problems, "; "))
+}
+
+// SetCoverRequirements sets the requirements the cover image is checked
+// against by the following calls to SetCover, e.g. CoverProfileAppleBooks. A
+// nil requirements disables the checks, the default. The requirements are
+// copied, so changing them afterwards has no effect.
+func (e *Epub) SetCoverRequirements(requirements *CoverRequirements) {
+	e.Lock()
+	defer e.Unlock()
+	if requirements == nil {
+		e.coverRequirements = nil
+		return
+	}
+	r := *requirements
+	e.coverRequirements = &r
+}
+
+// validateCover checks the image at internalImagePath against the cover
+// requirements
+func (e *Epub) validateCover(ctx context.Context, internalImagePath string) error {
+	r := e.coverRequirements
+	if r == nil {
+		return nil
+	}
+
+	filename := filepath.Base(internalImagePath)
+	problems, err := e.coverProblems(ctx, filename)
+	if err != nil {
+		problems = append(problems, err.Error())
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	validationErr := &CoverValidationError{Filename: filename, Problems: problems}
+	if r.Strict {
+		return validationErr
+	}
+	e.addWarning(WarningCoverRequirements, filename, "%s", validationErr)
+	return nil
+}
+
+func (e *Epub) coverProblems(ctx context.Context, filename string) ([]string, error) {
+	source, ok := e.images[filename]
+	if !ok {
+		return nil, fmt.Errorf("image not found")
+	}
+	f, err := e.grabber(ctx).open(source)
+	if err != nil {
+		return nil, fmt.Errorf("can't read image: %w", err)
+	}
+	defer f.Close()
+
+	cr := &countingReader{r: f}
+	config, _, err := image.DecodeConfig(cr)
+	if err != nil {
+		return nil, fmt.Errorf("can't read image dimensions: %w", err)
+	}
+	if _, err := io.Copy(io.Discard, cr); err != nil {
+		return nil, fmt.Errorf("can't read image: %w", err)
+	}
+
+	r := e.coverRequirements
+	var problems []string
+	check := func(failed bool, format string, a ...interface{}) {
+		if failed {
+			problems = append(problems, fmt.Sprintf(format, a...))
+		}
+	}
+	check(r.MinWidth > 0 && config.Width < r.MinWidth, "width %dpx is less than %dpx", config.Width, r.MinWidth)
+	check(r.MinHeight > 0 && config.Height < r.MinHeight, "height %dpx is less than %dpx", config.Height, r.MinHeight)
+	check(r.MaxWidth > 0 && config.Width > r.MaxWidth, "width %dpx is more than %dpx", config.Width, r.MaxWidth)
+	check(r.MaxHeight > 0 && config.Height > r.MaxHeight, "height %dpx is more than %dpx", config.Height, r.MaxHeight)
+	if config.Width > 0 {
+		ratio := float64(config.Height) / float64(config.Width)
+		check(r.MinAspectRatio > 0 && ratio < r.MinAspectRatio, "aspect ratio %.2f is less than %.2f", ratio, r.MinAspectRatio)
+		check(r.MaxAspectRatio > 0 && ratio > r.MaxAspectRatio, "aspect ratio %.2f is more than %.2f", ratio, r.MaxAspectRatio)
+	}
+	check(r.MaxFileSize > 0 && cr.n > r.MaxFileSize, "file size %d bytes is more than %d bytes", cr.n, r.MaxFileSize)
+	return problems, nil
+}
+
+// countingReader counts the bytes read from r
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// SetCoverPosition sets the position of the cover page in the reading order:
+// index 0, the default, places it first, index n places it after the first n
+// sections in reading order. The position doesn't depend on the order in which
+// SetCover and the Add* methods are called.
+func (e *Epub) SetCoverPosition(index int) {
+	e.Lock()
+	defer e.Unlock()
+	e.cover.index = index
+	e.cover.after = ""
+}
+
+// SetCoverAfter places the cover page in the reading order right after an
+// already-added section, e.g. a promotional page.
+func (e *Epub) SetCoverAfter(internalFilename string) error {
+	e.Lock()
+	defer e.Unlock()
+	if findSection(e.sections, internalFilename) == nil {
+		return &SectionDoesNotExistError{Filename: internalFilename}
+	}
+	e.cover.after = internalFilename
+	e.cover.index = 0
+	return nil
+}
+
+// coverSpineIndex returns the index of the cover page in the spine, once the
+// other sections are added to it
+func (e *Epub) coverSpineIndex() int {
+	if e.cover.after == "" {
+		return e.cover.index
+	}
+	for i, item := range e.pkg.xml.Spine.Items {
+		if item.Idref == e.cover.after {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// CoverNotSetError is thrown by ReplaceCover when the EPUB has no cover.
+type CoverNotSetError struct{}
+
+func (e *CoverNotSetError) Error() string {
+	return "no cover is set"
+}
+
+// ReplaceCover replaces the image and CSS of the cover set with SetCover,
+// keeping its page, so that its internal filename, position and the sections
+// added under it are unchanged. The CSS path is optional as in SetCover.
+//
+// The previous image is removed from the EPUB unless it is still used by a
+// section, and so is the default CSS if it isn't used anymore.
+func (e *Epub) ReplaceCover(internalImagePath string, internalCSSPath string) error {
+	return e.ReplaceCoverContext(context.Background(), internalImagePath, internalCSSPath)
+}
+
+// ReplaceCoverContext is like ReplaceCover, stopping the retrieval of the
+// image checked against the requirements when ctx is done.
+func (e *Epub) ReplaceCoverContext(ctx context.Context, internalImagePath string, internalCSSPath string) error {
+	e.Lock()
+	defer e.Unlock()
+	if e.cover.xhtmlFilename == "" {
+		return &CoverNotSetError{}
+	}
+	if err := e.validateCover(ctx, internalImagePath); err != nil {
+		return err
+	}
+	coverBody, err := e.coverBody(internalImagePath)
+	if err != nil {
+		return err
+	}
+	return e.setCover(ctx, internalImagePath, "", internalCSSPath, coverBody)
+}
+
+// RemoveCover removes the cover set with SetCover. Sections added under the
+// cover page take its place. The cover image is removed from the EPUB unless
+// it is still used by a section, and so is the default CSS.
+func (e *Epub) RemoveCover() {
+	e.Lock()
+	defer e.Unlock()
+	if e.cover.xhtmlFilename == "" {
+		return
+	}
+
+	for i, section := range e.sections {
+		if section.filename == e.cover.xhtmlFilename {
+			sections := append([]*epubSection{}, e.sections[:i]...)
+			sections = append(sections, section.children...)
+			e.sections = append(sections, e.sections[i+1:]...)
+			break
+		}
+	}
+	e.removeCoverImage(e.cover.imageFilename)
+	if e.cover.svgFilename != "" {
+		e.removeCoverImage(e.cover.svgFilename)
+	}
+	if e.cover.defaultCSS {
+		e.forgetResource(CSSFolderName, e.cover.cssFilename)
+	}
+	e.pkg.unsetCover()
+
+	e.cover.cssFilename = ""
+	e.cover.defaultCSS = false
+	e.cover.imageFilename = ""
+	e.cover.svgFilename = ""
+	e.cover.xhtmlFilename = ""
+}
+
+// SetCoverFromSource adds the image at source to the EPUB and sets it as the
+// cover in one step, as AddImage followed by SetCover with the default CSS
+// would. The image source is a URL, a path to a local file or an embedded
+// data URL, as for AddImage; it is stored as cover with the extension of the
+// source, e.g. cover.jpg, if that filename is free.
+//
+// If the cover can't be set, e.g. because the image doesn't meet the
+// requirements set with SetCoverRequirements, the image isn't kept. If a cover
+// is already set, it is replaced.
+func (e *Epub) SetCoverFromSource(source string) error {
+	return e.SetCoverFromSourceContext(context.Background(), source)
+}
+
+// SetCoverFromSourceContext is like SetCoverFromSource, stopping the retrieval
+// of the source when ctx is done.
+func (e *Epub) SetCoverFromSourceContext(ctx context.Context, source string) error {
+	e.Lock()
+	defer e.Unlock()
+	return e.setCoverFromSource(ctx, source, coverImageFilename(source))
+}
+
+// setCoverFromSource adds the image at source and sets it as the cover. The
+// image is stored as filename, unless it is empty or already used.
+func (e *Epub) setCoverFromSource(ctx context.Context, source string, filename string) error {
+	if _, ok := e.images[filename]; ok {
+		filename = ""
+	}
+	n := len(e.images)
+	internalImagePath, err := e.addResource(ctx, source, filename, imageFileFormat, ImageFolderName, e.images)
+	if err != nil {
+		return err
+	}
+	// An image already added from the same source is reused, and kept
+	added := len(e.images) > n
+	if err := e.validateCover(ctx, internalImagePath); err != nil {
+		if added {
+			e.forgetResource(ImageFolderName, path.Base(internalImagePath))
+		}
+		return err
+	}
+	coverBody, err := e.coverBody(internalImagePath)
+	if err == nil {
+		err = e.setCover(ctx, internalImagePath, "", "", coverBody)
+	}
+	if err != nil && added {
+		e.forgetResource(ImageFolderName, path.Base(internalImagePath))
+	}
+	return err
+}
+
+// coverImageFilename returns the internal filename of the cover image added
+// from source, or an empty string if its extension is unknown
+func coverImageFilename(source string) string {
+	var ext string
+	switch detectMediaType(source) {
+	case "URL":
+		if u, err := url.Parse(source); err == nil {
+			ext = path.Ext(u.Path)
+		}
+	case "DataURL":
+		mediaType, _, _ := strings.Cut(strings.TrimPrefix(source, "data:"), ",")
+		mediaType, _, _ = strings.Cut(mediaType, ";")
+		if exts, err := mime.ExtensionsByType(mediaType); err == nil && len(exts) > 0 {
+			ext = exts[0]
+			if mediaType == mediaTypeJpeg {
+				ext = ".jpg"
+			}
+		}
+	case "File":
+		ext = path.Ext(localBase(source))
+	}
+	ext = strings.ToLower(ext)
+	if ext == "" || !fs.ValidPath("cover"+ext) {
+		return ""
+	}
+	return fmt.Sprintf(defaultCoverImgFormat, ext)
+}
+
+// Body of the cover page of SetSVGCover, scaling the SVG image to the page
+// from its width and height
+const svgCoverBody = `<svg xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink" version="1.1" width="100%%" height="100%%" viewBox="0 0 %[1]s %[2]s" preserveAspectRatio="xMidYMid meet"><image width="%[1]s" height="%[2]s" xlink:href="%[3]s"/></svg>`
+
+// SetSVGCover sets the cover page for the EPUB showing an SVG image, as
+// SetCover does for other images. The internal paths to the already-added SVG
+// image and to a raster image, such as a JPEG or PNG rendering of the SVG,
+// are required; the CSS path is optional as in SetCover.
+//
+// The SVG image is wrapped in an <svg> element sized from its viewBox, or its
+// width and height, so that it fills the page keeping its aspect ratio, and
+// the manifest item of the cover page gets the svg property. The raster image
+// is the cover image of the manifest, for the reading systems showing it in
+// their library, and is the one checked against the requirements set with
+// SetCoverRequirements. If a cover is already set, it is replaced.
+func (e *Epub) SetSVGCover(internalSVGPath string, internalImagePath string, internalCSSPath string) error {
+	return e.SetSVGCoverContext(context.Background(), internalSVGPath, internalImagePath, internalCSSPath)
+}
+
+// SetSVGCoverContext is like SetSVGCover, stopping the retrieval of the
+// images when ctx is done.
+func (e *Epub) SetSVGCoverContext(ctx context.Context, internalSVGPath string, internalImagePath string, internalCSSPath string) error {
+	e.Lock()
+	defer e.Unlock()
+	if internalImagePath == "" {
+		return fmt.Errorf("can't set SVG cover %s: no raster image", internalSVGPath)
+	}
+	if err := e.validateCover(ctx, internalImagePath); err != nil {
+		return err
+	}
+	source, ok := e.images[path.Base(internalSVGPath)]
+	if !ok {
+		return &ResourceDoesNotExistError{Filename: path.Base(internalSVGPath)}
+	}
+	width, height, err := e.svgSize(ctx, source)
+	if err != nil {
+		return fmt.Errorf("can't set SVG cover %s: %w", internalSVGPath, err)
+	}
+	coverBody := fmt.Sprintf(svgCoverBody, width, height, html.EscapeString(internalSVGPath))
+	return e.setCover(ctx, internalImagePath, internalSVGPath, internalCSSPath, coverBody)
+}
+
+// svgSize returns the width and height of the SVG image at source, in user
+// units
+func (e *Epub) svgSize(ctx context.Context, source string) (string, string, error) {
+	f, err := e.grabber(ctx).open(source)
+	if err != nil {
+		return "", "", fmt.Errorf("can't read image: %w", err)
+	}
+	defer f.Close()
+
+	d := xml.NewDecoder(f)
+	d.Strict = false
+	for {
+		t, err := d.Token()
+		if err != nil {
+			return "", "", fmt.Errorf("can't read SVG root element: %w", err)
+		}
+		se, ok := t.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		if se.Name.Local != "svg" {
+			return "", "", fmt.Errorf("not an SVG image")
+		}
+		var width, height, viewBox string
+		for _, a := range se.Attr {
+			switch a.Name.Local {
+			case "width":
+				width = strings.TrimSuffix(strings.TrimSpace(a.Value), "px")
+			case "height":
+				height = strings.TrimSuffix(strings.TrimSpace(a.Value), "px")
+			case "viewBox":
+				viewBox = a.Value
+			}
+		}
+		if box := strings.Fields(strings.ReplaceAll(viewBox, ",", " ")); len(box) == 4 && validSVGLength(box[2]) && validSVGLength(box[3]) {
+			return box[2], box[3], nil
+		}
+		if validSVGLength(width) && validSVGLength(height) {
+			return width, height, nil
+		}
+		return "", "", fmt.Errorf("no viewBox nor width and height in pixels")
+	}
+}
+
+// validSVGLength returns whether s is a positive length in user units
+func validSVGLength(s string) bool {
+	f, err := strconv.ParseFloat(s, 64)
+	return err == nil && f > 0
+}
+
+// CoverTemplateData is the data the template set with SetCoverTemplate is
+// executed with.
+type CoverTemplateData struct {
+	ImagePath string // Internal path of the cover image, relative to the cover page
+	Title     string // Title of the EPUB
+	Author    string // Author of the EPUB
+}
+
+// SetCoverTemplate sets an html/template generating the body of the cover page
+// in place of the image alone, so that covers can include title text
+// overlays, SVG wrappers or publisher branding. It is executed with a
+// CoverTemplateData by SetCover and ReplaceCover, e.g.:
+//
+//	<div class="cover">
+//	  <img src="{{.ImagePath}}" alt="{{.Title}}" />
+//	  <p class="title">{{.Title}}</p>
+//	</div>
+//
+// The fields are escaped according to their context. A template without
+// actions is used as the body as it is. The template isn't used by
+// SetSVGCover. The body of the cover page already set, if any, is updated;
+// the title and author are the ones set at that time. An empty template
+// restores the default body.
+func (e *Epub) SetCoverTemplate(tmpl string) error {
+	e.Lock()
+	defer e.Unlock()
+	var t *template.Template
+	if tmpl != "" {
+		var err error
+		t, err = template.New("cover").Parse(tmpl)
+		if err != nil {
+			return fmt.Errorf("invalid cover template: %w", err)
+		}
+	}
+	previous := e.coverTemplate
+	e.coverTemplate = t
+
+	cover := findSection(e.sections, e.cover.xhtmlFilename)
+	if cover == nil || e.cover.svgFilename != "" {
+		return nil
+	}
+	body, err := e.coverBody(path.Join("..", ImageFolderName, e.cover.imageFilename))
+	if err != nil {
+		e.coverTemplate = previous
+		return err
+	}
+	cover.xhtml.setBody(body)
+	cover.properties = propertiesFromBody(body)
+	return nil
+}
+
+// coverBody returns the body of the cover page showing the image at
+// internalImagePath
+func (e *Epub) coverBody(internalImagePath string) (string, error) {
+	if e.coverTemplate == nil {
+		return fmt.Sprintf(defaultCoverBody, internalImagePath), nil
+	}
+	var b bytes.Buffer
+	data := CoverTemplateData{
+		ImagePath: internalImagePath,
+		Title:     e.title,
+		Author:    e.author,
+	}
+	if err := e.coverTemplate.Execute(&b, data); err != nil {
+		return "", fmt.Errorf("can't execute cover template: %w", err)
+	}
+	return b.String(), nil
+}
+
+// replaceCover updates the cover page in place
+func (e *Epub) replaceCover(ctx context.Context, internalImagePath string, internalCSSPath string, body string) error {
+	cover := findSection(e.sections, e.cover.xhtmlFilename)
+	if cover == nil {
+		return &SectionDoesNotExistError{Filename: e.cover.xhtmlFilename}
+	}
+	var err error
+
+	switch {
+	case internalCSSPath == "" && e.cover.defaultCSS:
+		internalCSSPath = path.Join("..", CSSFolderName, e.cover.cssFilename)
+	case internalCSSPath == "":
+		internalCSSPath, err = e.addDefaultCoverCSS(ctx)
+		if err != nil {
+			return err
+		}
+	case e.cover.defaultCSS:
+		e.forgetResource(CSSFolderName, e.cover.cssFilename)
+		e.cover.defaultCSS = false
+	}
+	e.cover.cssFilename = filepath.Base(internalCSSPath)
+	cover.xhtml.setCSS(internalCSSPath)
+	cover.xhtml.setBody(body)
+	cover.properties = propertiesFromBody(body)
+
+	oldImageFilename := e.cover.imageFilename
+	e.cover.imageFilename = filepath.Base(internalImagePath)
+	e.pkg.setCover(e.cover.imageFilename)
+	if oldImageFilename != e.cover.imageFilename {
+		e.removeCoverImage(oldImageFilename)
+	}
+	return nil
+}
+
+// addDefaultCoverCSS adds the default cover CSS and returns its internal path
+func (e *Epub) addDefaultCoverCSS(ctx context.Context) (string, error) {
+	// The CSS is embedded in a data URL, so no temporary file is needed
+	source := dataurl.EncodeBytes([]byte(defaultCoverCSSContent))
+	internalCSSPath, err := e.addCSS(ctx, source, defaultCoverCSSFilename)
+	// If that doesn't work, generate a filename
+	if _, ok := err.(*FilenameAlreadyUsedError); ok {
+		coverCSSFilename := unusedFilename(cssFileFormat, ".css", e.css)
+
+		internalCSSPath, err = e.addCSS(ctx, source, coverCSSFilename)
+		if _, ok := err.(*FilenameAlreadyUsedError); ok {
+			// This shouldn't cause an error
+			return "", fmt.Errorf("Error adding default cover CSS file: %w", err)
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+	e.cover.defaultCSS = true
+	return internalCSSPath, nil
+}
+
+// removeCoverImage removes a former cover image unless a section other than
+// the cover page uses it
+func (e *Epub) removeCoverImage(filename string) {
+	ref := path.Join(ImageFolderName, filename)
+	used := false
+	walkSections(e.sections, func(s *epubSection) {
+		used = used || (s.filename != e.cover.xhtmlFilename && sectionLinksTo(s, ref))
+	})
+	if !used {
+		e.forgetResource(ImageFolderName, filename)
+	}
+}
+
+// sectionLinksTo returns whether an element of the section s links to the
+// file at ref, relative to the content folder, e.g. images/image1.png
+func sectionLinksTo(s *epubSection, ref string) bool {
+	body := s.raw
+	if body == "" {
+		body = s.xhtml.body()
+	}
+	if !strings.Contains(body, path.Base(ref)) {
+		return false
+	}
+	root, err := parseBody(body)
+	if err != nil {
+		// Kept in doubt
+		return true
+	}
+	base := path.Join(xhtmlFolderName, s.filename)
+	found := false
+	walk(root, func(n *html.Node) bool {
+		for _, a := range n.Attr {
+			if (a.Key == "src" || a.Key == "href" || a.Key == "poster") && resolveRef(base, a.Val) == ref {
+				found = true
+			}
+		}
+		return !found
+	})
+	return found
+}
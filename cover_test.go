@@ -0,0 +1,580 @@
+package epub
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/vincent-petithory/dataurl"
+)
+
+func TestSetCoverRequirements(t *testing.T) {
+	tests := []struct {
+		name         string
+		requirements *CoverRequirements
+		problems     []string
+	}{
+		{
+			"no requirements",
+			nil,
+			nil,
+		},
+		{
+			"met",
+			&CoverRequirements{MinWidth: 16, MaxHeight: 16, MinAspectRatio: 0.9, MaxFileSize: 1 << 20, Strict: true},
+			nil,
+		},
+		{
+			"dimensions",
+			&CoverRequirements{MinWidth: 1400, MinHeight: 20, MaxWidth: 10, Strict: true},
+			[]string{"width 16px is less than 1400px", "height 15px is less than 20px", "width 16px is more than 10px"},
+		},
+		{
+			"aspect ratio and size",
+			&CoverRequirements{MinAspectRatio: 1.6, MaxFileSize: 100, Strict: true},
+			[]string{"aspect ratio 0.94 is less than 1.60", "file size 739 bytes is more than 100 bytes"},
+		},
+		{
+			"not strict",
+			&CoverRequirements{MinWidth: 1400},
+			nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, err := NewEpub(testEpubTitle)
+			if err != nil {
+				t.Error(err)
+			}
+			imagePath, err := e.AddImage(testImageFromFileSource, testImageFromFileFilename)
+			if err != nil {
+				t.Fatalf("Error adding image: %s", err)
+			}
+			e.SetCoverRequirements(tt.requirements)
+
+			err = e.SetCover(imagePath, "")
+			if tt.problems == nil {
+				if err != nil {
+					t.Errorf("Unexpected error setting cover: %s", err)
+				}
+				return
+			}
+			validationErr, ok := err.(*CoverValidationError)
+			if !ok {
+				t.Fatalf("Expected error CoverValidationError not returned. Returned instead: %+v", err)
+			}
+			if !reflect.DeepEqual(validationErr.Problems, tt.problems) {
+				t.Errorf("Got problems %q, expected %q", validationErr.Problems, tt.problems)
+			}
+			if e.cover.imageFilename != "" {
+				t.Error("Cover shouldn't be set when the validation fails")
+			}
+		})
+	}
+
+	e, err := NewEpub(testEpubTitle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	imagePath, err := e.AddImage(testImageFromFileSource, testImageFromFileFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The requirements are copied
+	requirements := &CoverRequirements{MinWidth: 1400}
+	e.SetCoverRequirements(requirements)
+	requirements.Strict = true
+	if err := e.SetCover(imagePath, ""); err != nil {
+		t.Errorf("Unexpected error setting cover: %s", err)
+	}
+	// Outside of strict mode the problems are reported as warnings
+	warnings := e.Warnings()
+	if len(warnings) != 1 || warnings[0].Kind != WarningCoverRequirements || warnings[0].Source != testImageFromFileFilename {
+		t.Errorf("Expected a cover requirements warning, got %v", warnings)
+	}
+}
+
+func TestSetCoverPosition(t *testing.T) {
+	tests := []struct {
+		name     string
+		position func(e *Epub) error
+		spine    []string
+	}{
+		{
+			"first by default",
+			func(e *Epub) error { return nil },
+			[]string{"cover.xhtml", "promo.xhtml", "chapter1.xhtml", "chapter2.xhtml"},
+		},
+		{
+			"index",
+			func(e *Epub) error {
+				e.SetCoverPosition(2)
+				return nil
+			},
+			[]string{"promo.xhtml", "chapter1.xhtml", "cover.xhtml", "chapter2.xhtml"},
+		},
+		{
+			"index out of range",
+			func(e *Epub) error {
+				e.SetCoverPosition(10)
+				return nil
+			},
+			[]string{"promo.xhtml", "chapter1.xhtml", "chapter2.xhtml", "cover.xhtml"},
+		},
+		{
+			"after section",
+			func(e *Epub) error {
+				return e.SetCoverAfter("promo.xhtml")
+			},
+			[]string{"promo.xhtml", "cover.xhtml", "chapter1.xhtml", "chapter2.xhtml"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, err := NewEpub(testEpubTitle)
+			if err != nil {
+				t.Error(err)
+			}
+			for _, filename := range []string{"promo.xhtml", "chapter1.xhtml"} {
+				if _, err := e.AddSection(testSectionBody, filename, filename, ""); err != nil {
+					t.Errorf("Error adding section: %s", err)
+				}
+			}
+			imagePath, err := e.AddImage(testImageFromFileSource, testImageFromFileFilename)
+			if err != nil {
+				t.Fatalf("Error adding image: %s", err)
+			}
+			if err := e.SetCover(imagePath, ""); err != nil {
+				t.Fatalf("Error setting cover: %s", err)
+			}
+			// Sections added after the cover don't change its position
+			if _, err := e.AddSection(testSectionBody, "chapter2.xhtml", "chapter2.xhtml", ""); err != nil {
+				t.Errorf("Error adding section: %s", err)
+			}
+			if err := tt.position(e); err != nil {
+				t.Fatalf("Error setting cover position: %s", err)
+			}
+
+			if _, err := e.WriteTo(io.Discard); err != nil {
+				t.Fatalf("Error writing EPUB: %s", err)
+			}
+			var spine []string
+			for _, item := range e.pkg.xml.Spine.Items {
+				spine = append(spine, item.Idref)
+			}
+			if !reflect.DeepEqual(spine, tt.spine) {
+				t.Errorf("Got spine %v, expected %v", spine, tt.spine)
+			}
+		})
+	}
+
+	e, err := NewEpub(testEpubTitle)
+	if err != nil {
+		t.Error(err)
+	}
+	err = e.SetCoverAfter("doesNotExist.xhtml")
+	if _, ok := err.(*SectionDoesNotExistError); !ok {
+		t.Errorf("Expected error SectionDoesNotExistError not returned. Returned instead: %+v", err)
+	}
+}
+
+func TestReplaceCover(t *testing.T) {
+	e, err := NewEpub(testEpubTitle)
+	if err != nil {
+		t.Error(err)
+	}
+	err = e.ReplaceCover("../images/doesNotExist.png", "")
+	if _, ok := err.(*CoverNotSetError); !ok {
+		t.Errorf("Expected error CoverNotSetError not returned. Returned instead: %+v", err)
+	}
+
+	oldImagePath, err := e.AddImage(testImageFromFileSource, "old.png")
+	if err != nil {
+		t.Error(err)
+	}
+	newImagePath, err := e.AddImage(testImageFromFileSource, "new.png")
+	if err != nil {
+		t.Error(err)
+	}
+	cssPath, err := e.AddCSS(testCoverCSSSource, testCoverCSSFilename)
+	if err != nil {
+		t.Error(err)
+	}
+	if err := e.SetCover(oldImagePath, ""); err != nil {
+		t.Fatalf("Error setting cover: %s", err)
+	}
+	defaultCSSFilename := e.cover.cssFilename
+	if _, err := e.AddSubSection(e.cover.xhtmlFilename, testSectionBody, testSectionTitle, testSectionFilename, ""); err != nil {
+		t.Errorf("Error adding section: %s", err)
+	}
+
+	if err := e.ReplaceCover(newImagePath, cssPath); err != nil {
+		t.Fatalf("Error replacing cover: %s", err)
+	}
+	cover := findSection(e.sections, defaultCoverXhtmlFilename)
+	if cover == nil || len(cover.children) != 1 {
+		t.Fatalf("Cover page not kept with its children: %+v", e.sections)
+	}
+	if !strings.Contains(cover.xhtml.body(), newImagePath) || cover.xhtml.cssPaths()[0] != cssPath {
+		t.Errorf("Cover page not updated: %+v", cover.xhtml.xml)
+	}
+	if _, ok := e.images["old.png"]; ok {
+		t.Error("Previous cover image not removed")
+	}
+	if _, ok := e.css[defaultCSSFilename]; ok {
+		t.Error("Default cover CSS not removed")
+	}
+	var coverMetas int
+	for _, meta := range e.pkg.xml.Metadata.Meta {
+		if meta.Name == "cover" {
+			coverMetas++
+		}
+	}
+	if coverMetas != 1 {
+		t.Errorf("Got %d cover metadata elements, expected 1", coverMetas)
+	}
+
+	// An image used by a section is kept
+	if _, err := e.AddSection(`<img src="`+newImagePath+`" alt="" />`, "Gallery", "gallery.xhtml", ""); err != nil {
+		t.Errorf("Error adding section: %s", err)
+	}
+	oldImagePath, err = e.AddImage(testImageFromFileSource, "old.png")
+	if err != nil {
+		t.Error(err)
+	}
+	if err := e.SetCover(oldImagePath, ""); err != nil {
+		t.Fatalf("Error setting cover: %s", err)
+	}
+	if _, ok := e.images["new.png"]; !ok {
+		t.Error("Cover image used by a section removed")
+	}
+	if !e.cover.defaultCSS {
+		t.Error("Default cover CSS not restored")
+	}
+}
+
+func TestReplaceCoverSimilarFilename(t *testing.T) {
+	e, err := NewEpub(testEpubTitle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var paths []string
+	for _, filename := range []string{"image1.png", "image10.png", "new.png"} {
+		p, err := e.AddImage(testImageFromFileSource, filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, p)
+	}
+	if err := e.SetCover(paths[0], ""); err != nil {
+		t.Fatalf("Error setting cover: %s", err)
+	}
+	if _, err := e.AddSection(`<img src="`+paths[1]+`" alt="" />`, "Gallery", "gallery.xhtml", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.SetExpectedSHA256(paths[0], strings.Repeat("0", 64)); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.SetAttribution(paths[0], Attribution{Author: "Author"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.ReplaceCover(paths[2], ""); err != nil {
+		t.Fatalf("Error replacing cover: %s", err)
+	}
+	if _, ok := e.images["image1.png"]; ok {
+		t.Error("Previous cover image kept because a section uses image10.png")
+	}
+	if _, ok := e.images["image10.png"]; !ok {
+		t.Error("Image used by a section removed")
+	}
+
+	// An image added with the filename of the removed one doesn't inherit its
+	// expected SHA-256 and attribution
+	if _, err := e.AddImage(testImageFromFileSource, "image1.png"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := e.attributions[ImageFolderName+"/image1.png"]; ok {
+		t.Error("Attribution of the removed cover image kept")
+	}
+	if _, err := e.WriteTo(io.Discard); err != nil {
+		t.Errorf("Error writing EPUB: %s", err)
+	}
+}
+
+func TestReplaceCoverManifest(t *testing.T) {
+	e, err := NewEpub(testEpubTitle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldImagePath, err := e.AddImage(testImageFromFileSource, "old.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	newImagePath, err := e.AddImage(testImageFromFileSource, "new.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The previous cover image stays in the EPUB, used by a section
+	if _, err := e.AddSection(`<img src="`+oldImagePath+`" alt="" />`, "Gallery", "gallery.xhtml", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.SetCover(oldImagePath, ""); err != nil {
+		t.Fatalf("Error setting cover: %s", err)
+	}
+	if _, err := e.WriteTo(io.Discard); err != nil {
+		t.Fatalf("Error writing EPUB: %s", err)
+	}
+	if err := e.SetCover(newImagePath, ""); err != nil {
+		t.Fatalf("Error replacing cover: %s", err)
+	}
+	if _, err := e.WriteTo(io.Discard); err != nil {
+		t.Fatalf("Error writing EPUB: %s", err)
+	}
+
+	properties := make(map[string]string)
+	for _, item := range e.pkg.xml.ManifestItems {
+		properties[item.Href] = item.Properties
+	}
+	if got := properties["images/old.png"]; got != "" {
+		t.Errorf("Previous cover image has properties %q, expected none", got)
+	}
+	if got := properties["images/new.png"]; got != coverImageProperties {
+		t.Errorf("Cover image has properties %q, expected %q", got, coverImageProperties)
+	}
+	if len(e.css) != 1 {
+		t.Errorf("Got %d CSS files, expected the default cover CSS only", len(e.css))
+	}
+}
+
+func TestRemoveCover(t *testing.T) {
+	e, err := NewEpub(testEpubTitle)
+	if err != nil {
+		t.Error(err)
+	}
+	if _, err := e.AddSection(testSectionBody, "Chapter 1", "chapter1.xhtml", ""); err != nil {
+		t.Errorf("Error adding section: %s", err)
+	}
+	imagePath, err := e.AddImage(testImageFromFileSource, testImageFromFileFilename)
+	if err != nil {
+		t.Error(err)
+	}
+	if err := e.SetCover(imagePath, ""); err != nil {
+		t.Fatalf("Error setting cover: %s", err)
+	}
+	if _, err := e.AddSubSection(e.cover.xhtmlFilename, testSectionBody, "Chapter 2", "chapter2.xhtml", ""); err != nil {
+		t.Errorf("Error adding section: %s", err)
+	}
+	// Removing twice is harmless
+	e.RemoveCover()
+	e.RemoveCover()
+
+	var filenames []string
+	for _, s := range e.sections {
+		filenames = append(filenames, s.filename)
+	}
+	if !reflect.DeepEqual(filenames, []string{"chapter1.xhtml", "chapter2.xhtml"}) {
+		t.Errorf("Got sections %v, expected the cover page replaced by its children", filenames)
+	}
+	if len(e.images) != 0 || len(e.css) != 0 {
+		t.Errorf("Cover resources not removed: %v %v", e.images, e.css)
+	}
+
+	if _, err := e.WriteTo(io.Discard); err != nil {
+		t.Fatalf("Error writing EPUB: %s", err)
+	}
+	for _, meta := range e.pkg.xml.Metadata.Meta {
+		if meta.Name == "cover" {
+			t.Errorf("Cover metadata not removed: %+v", meta)
+		}
+	}
+	for _, item := range e.pkg.xml.ManifestItems {
+		if item.Properties == "cover-image" {
+			t.Errorf("Cover image still in the manifest: %+v", item)
+		}
+	}
+
+	// A new cover can be set afterwards
+	imagePath, err = e.AddImage(testImageFromFileSource, testImageFromFileFilename)
+	if err != nil {
+		t.Error(err)
+	}
+	if err := e.SetCover(imagePath, ""); err != nil {
+		t.Errorf("Error setting cover: %s", err)
+	}
+}
+
+func TestSetCoverTemplate(t *testing.T) {
+	e, err := NewEpub(testEpubTitle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.SetAuthor("Ann & Bob")
+	if err := e.SetCoverTemplate("{{.Title"); err == nil {
+		t.Error("Expected an error for an invalid template")
+	}
+	if err := e.SetCoverTemplate(`<div class="cover"><img src="{{.ImagePath}}" alt="" /><p>{{.Author}}</p></div>`); err != nil {
+		t.Fatalf("Error setting cover template: %s", err)
+	}
+	imagePath, err := e.AddImage(testImageFromFileSource, "cover.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.SetCover(imagePath, ""); err != nil {
+		t.Fatalf("Error setting cover: %s", err)
+	}
+	cover := findSection(e.sections, e.cover.xhtmlFilename)
+	want := `<div class="cover"><img src="` + imagePath + `" alt="" /><p>Ann &amp; Bob</p></div>`
+	if got := cover.xhtml.body(); got != want {
+		t.Errorf("Cover body %q, expected %q", got, want)
+	}
+
+	// The cover page already set is updated
+	if err := e.SetCoverTemplate(`<svg xmlns="http://www.w3.org/2000/svg"><text>Branding</text></svg>`); err != nil {
+		t.Fatalf("Error setting cover template: %s", err)
+	}
+	if got := cover.xhtml.body(); !strings.Contains(got, "Branding") {
+		t.Errorf("Cover page not updated: %q", got)
+	}
+	if err := e.SetCoverTemplate("{{.Missing}}"); err == nil {
+		t.Error("Expected an error executing the template")
+	}
+	if got := cover.xhtml.body(); !strings.Contains(got, "Branding") {
+		t.Errorf("Cover page changed by a failing template: %q", got)
+	}
+	if err := e.SetCoverTemplate(""); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := cover.xhtml.body(), fmt.Sprintf(defaultCoverBody, imagePath); got != want {
+		t.Errorf("Cover body %q, expected the default %q", got, want)
+	}
+}
+
+func TestSetSVGCover(t *testing.T) {
+	e, err := NewEpub(testEpubTitle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	svgPath, err := e.AddImageFromBytes([]byte(`<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 600 800"><rect width="600" height="800" fill="navy"/></svg>`), "cover.svg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	unsizedPath, err := e.AddImageFromBytes([]byte(`<svg xmlns="http://www.w3.org/2000/svg" width="100%"></svg>`), "unsized.svg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	imagePath, err := e.AddImage(testImageFromFileSource, "cover.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.SetSVGCover(svgPath, "", ""); err == nil {
+		t.Error("Expected an error without a raster image")
+	}
+	if err := e.SetSVGCover(unsizedPath, imagePath, ""); err == nil {
+		t.Error("Expected an error for an SVG image without dimensions")
+	}
+	if len(e.sections) != 0 {
+		t.Fatalf("Cover page added by a failing call: %+v", e.sections)
+	}
+	if err := e.SetSVGCover(svgPath, imagePath, ""); err != nil {
+		t.Fatalf("Error setting SVG cover: %s", err)
+	}
+	if err := e.RemoveImage(svgPath); err == nil {
+		t.Error("Expected an error removing the SVG cover image")
+	}
+
+	cover := findSection(e.sections, e.cover.xhtmlFilename)
+	body := cover.xhtml.body()
+	if !strings.Contains(body, `viewBox="0 0 600 800"`) || !strings.Contains(body, `xlink:href="`+svgPath+`"`) {
+		t.Errorf("Unexpected cover page body:\n%s", body)
+	}
+	if _, err := e.WriteTo(io.Discard); err != nil {
+		t.Fatalf("Error writing EPUB: %s", err)
+	}
+	properties := make(map[string]string)
+	for _, item := range e.pkg.xml.ManifestItems {
+		properties[item.Href] = item.Properties
+	}
+	if got := properties["xhtml/"+e.cover.xhtmlFilename]; got != "svg" {
+		t.Errorf("Cover page has properties %q, expected svg", got)
+	}
+	if got := properties["images/cover.png"]; got != coverImageProperties {
+		t.Errorf("Raster image has properties %q, expected %q", got, coverImageProperties)
+	}
+	if got := properties["images/cover.svg"]; got != "" {
+		t.Errorf("SVG image has properties %q, expected none", got)
+	}
+
+	// Replacing the SVG cover with a raster one removes the SVG image
+	if err := e.SetCover(imagePath, ""); err != nil {
+		t.Fatalf("Error setting cover: %s", err)
+	}
+	if _, ok := e.images["cover.svg"]; ok {
+		t.Error("SVG cover image not removed")
+	}
+	if cover.properties != "" {
+		t.Errorf("Cover page has properties %q, expected none", cover.properties)
+	}
+}
+
+func TestSetCoverFromSource(t *testing.T) {
+	e, err := NewEpub(testEpubTitle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.SetCoverFromSource(testImageFromFileSource); err != nil {
+		t.Fatalf("Error setting cover: %s", err)
+	}
+	if e.cover.imageFilename != "cover.png" || e.images["cover.png"] != testImageFromFileSource {
+		t.Errorf("Cover image %q not added as cover.png: %v", e.cover.imageFilename, e.images)
+	}
+	cover := findSection(e.sections, e.cover.xhtmlFilename)
+	if cover == nil || !strings.Contains(cover.xhtml.body(), "../images/cover.png") {
+		t.Fatalf("Cover page not added: %+v", e.sections)
+	}
+	if !e.cover.defaultCSS {
+		t.Error("Default cover CSS not used")
+	}
+
+	// Replacing the cover removes the previous image
+	data, err := os.ReadFile(testImageFromFileSource)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dataURL := dataurl.New(data, "image/png").String()
+	if err := e.SetCoverFromSource(dataURL); err != nil {
+		t.Fatalf("Error replacing cover: %s", err)
+	}
+	if _, ok := e.images["cover.png"]; ok || len(e.images) != 1 {
+		t.Errorf("Previous cover image not removed: %v", e.images)
+	}
+
+	// An image that can't be the cover isn't kept
+	e.SetCoverRequirements(&CoverRequirements{MinWidth: 1400, Strict: true})
+	if err := e.SetCoverFromSource("testdata/gophercolor16x16withoutextention"); err == nil {
+		t.Error("Expected an error for an image not meeting the requirements")
+	}
+	if len(e.images) != 1 {
+		t.Errorf("Rejected cover image kept: %v", e.images)
+	}
+	if err := e.SetCoverFromSource("testdata/doesNotExist.png"); err == nil {
+		t.Error("Expected an error for a missing image")
+	}
+}
+
+func TestCoverImageFilename(t *testing.T) {
+	tests := map[string]string{
+		"testdata/photo.JPEG":                     "cover.jpeg",
+		"https://example.com/covers/1.png?size=l": "cover.png",
+		"data:image/jpeg;base64,AAAA":             "cover.jpg",
+		"https://example.com/cover":               "",
+		"data:,text":                              "",
+	}
+	for source, want := range tests {
+		if got := coverImageFilename(source); got != want {
+			t.Errorf("coverImageFilename(%q) = %q, expected %q", source, got, want)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package epub
+
+import (
+	"fmt"
+	"path"
+
+	"github.com/vincent-petithory/dataurl"
+)
+
+// CSSReset is a baseline stylesheet tuned for e-readers, see SetCSSReset.
+type CSSReset string
+
+const (
+	// No baseline stylesheet, the default
+	CSSResetNone CSSReset = ""
+	// Margins removed and media fitted to the page
+	CSSResetMinimal CSSReset = "minimal"
+	// CSSResetMinimal plus the typesetting of novels: indented paragraphs
+	// without spacing, hyphenation, widows and orphans, and headings kept
+	// with the text following them
+	CSSResetFiction CSSReset = "fiction"
+	// CSSResetMinimal plus the typesetting of documentation and articles:
+	// spaced paragraphs without indentation, hyphenation only in paragraphs,
+	// widows and orphans, headings kept with the text following them and
+	// preformatted text wrapped
+	CSSResetNonFiction CSSReset = "nonfiction"
+)
+
+// cssResetFileFormat is the internal filename of the stylesheet of a CSS
+// reset profile
+const cssResetFileFormat = "reset-%s.css"
+
+const cssResetMinimal = `html, body {
+  margin: 0;
+  padding: 0;
+}
+img, svg, video {
+  max-width: 100%;
+  height: auto;
+}
+figure {
+  margin: 1em 0;
+}
+`
+
+// Rules shared by the typesetting profiles
+const cssResetTypesetting = `body {
+  widows: 2;
+  orphans: 2;
+}
+h1, h2, h3, h4, h5, h6 {
+  text-indent: 0;
+  -webkit-hyphens: none;
+  -epub-hyphens: none;
+  hyphens: none;
+  page-break-after: avoid;
+  break-after: avoid;
+}
+img, svg, video, figure, table {
+  page-break-inside: avoid;
+  break-inside: avoid;
+}
+`
+
+// Stylesheets of the CSS reset profiles
+var cssResets = map[CSSReset]string{
+	CSSResetMinimal: cssResetMinimal,
+	CSSResetFiction: cssResetMinimal + cssResetTypesetting + `p {
+  margin: 0;
+  text-indent: 1.5em;
+  text-align: justify;
+  -webkit-hyphens: auto;
+  -epub-hyphens: auto;
+  hyphens: auto;
+}
+h1 + p, h2 + p, h3 + p, h4 + p, h5 + p, h6 + p, hr + p {
+  text-indent: 0;
+}
+`,
+	CSSResetNonFiction: cssResetMinimal + cssResetTypesetting + `p {
+  margin: 0 0 1em 0;
+  text-indent: 0;
+  -webkit-hyphens: auto;
+  -epub-hyphens: auto;
+  hyphens: auto;
+}
+pre {
+  white-space: pre-wrap;
+  -webkit-hyphens: none;
+  -epub-hyphens: none;
+  hyphens: none;
+}
+`,
+}
+
+// SetCSSReset links a baseline stylesheet tuned for e-readers from all the
+// sections, except the cover, so that converted content renders consistently
+// across reading systems. It is linked before any other CSS file, so that the
+// rules of the CSS files added with AddCSS take precedence.
+//
+// An unknown profile returns an error. CSSResetNone removes the stylesheet,
+// the default.
+func (e *Epub) SetCSSReset(profile CSSReset) error {
+	if _, ok := cssResets[profile]; !ok && profile != CSSResetNone {
+		return fmt.Errorf("unknown CSS reset profile %q", profile)
+	}
+	e.Lock()
+	defer e.Unlock()
+	e.cssReset = profile
+	return nil
+}
+
+// cssResetPath returns the internal path of the stylesheet of the CSS reset
+// profile, or an empty string if there is none
+func (e *Epub) cssResetPath() string {
+	if e.cssReset == CSSResetNone {
+		return ""
+	}
+	return path.Join("..", CSSFolderName, fmt.Sprintf(cssResetFileFormat, e.cssReset))
+}
+
+// cssResetStylesheet returns the filename and the source of the stylesheet of
+// the CSS reset profile, or empty strings if there is none. A CSS file added
+// with the same filename is kept.
+func (e *Epub) cssResetStylesheet() (string, string) {
+	if e.cssReset == CSSResetNone {
+		return "", ""
+	}
+	filename := fmt.Sprintf(cssResetFileFormat, e.cssReset)
+	if _, ok := e.css[filename]; ok {
+		return "", ""
+	}
+	return filename, dataurl.EncodeBytes([]byte(cssResets[e.cssReset]))
+}
+
+// linkedCSS returns the internal paths of the stylesheets linked from all the
+// sections with the given body before their own: the CSS reset, the font
+// fallbacks and the global CSS files
+func (e *Epub) linkedCSS(body string) []string {
+	var paths []string
+	if p := e.cssResetPath(); p != "" {
+		paths = append(paths, p)
+	}
+	paths = append(paths, e.fontFallbackCSS(body)...)
+	return append(paths, e.globalCSS...)
+}
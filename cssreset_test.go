@@ -0,0 +1,63 @@
+package epub
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-shiori/go-epub/internal/storage"
+)
+
+func TestSetCSSReset(t *testing.T) {
+	e, err := NewEpub(testEpubTitle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.SetCSSReset("unknown"); err == nil {
+		t.Error("Expected error setting an unknown CSS reset profile")
+	}
+	if err := e.SetCSSReset(CSSResetFiction); err != nil {
+		t.Fatalf("Error setting CSS reset: %s", err)
+	}
+	cssPath, err := e.AddCSS(testCoverCSSSource, testCoverCSSFilename)
+	if err != nil {
+		t.Fatalf("Error adding CSS: %s", err)
+	}
+	e.SetGlobalCSS(cssPath)
+	sectionFilename, err := e.AddSection("<p>Section</p>", "Section", "", "")
+	if err != nil {
+		t.Fatalf("Error adding section: %s", err)
+	}
+
+	tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+
+	reset, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, CSSFolderName, "reset-fiction.css"))
+	if err != nil {
+		t.Fatalf("Unexpected error reading CSS reset: %s", err)
+	}
+	for _, expected := range []string{"max-width: 100%", "orphans: 2", "widows: 2", "hyphens: auto", "-epub-hyphens: auto"} {
+		if !strings.Contains(string(reset), expected) {
+			t.Errorf("CSS reset doesn't contain %s:\n%s", expected, reset)
+		}
+	}
+
+	section, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, xhtmlFolderName, sectionFilename))
+	if err != nil {
+		t.Fatalf("Unexpected error reading section file: %s", err)
+	}
+	resetLink := strings.Index(string(section), `href="../css/reset-fiction.css"`)
+	globalLink := strings.Index(string(section), `href="`+cssPath+`"`)
+	if resetLink < 0 || globalLink < 0 || resetLink > globalLink {
+		t.Errorf("CSS reset not linked before the global CSS:\n%s", section)
+	}
+
+	pkg, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, pkgFilename))
+	if err != nil {
+		t.Fatalf("Unexpected error reading package file: %s", err)
+	}
+	if !strings.Contains(string(pkg), `href="css/reset-fiction.css"`) {
+		t.Errorf("CSS reset not in the manifest:\n%s", pkg)
+	}
+
+	cleanup(testEpubFilename, tempDir)
+}
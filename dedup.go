@@ -0,0 +1,82 @@
+package epub
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"path"
+)
+
+// SetResourceDeduplication sets whether a resource added twice is stored once
+// in the EPUB. When enabled, the Add* methods return the path of the resource
+// already added, whatever the internal filename given, if it has the same
+// source or, for local files and data URLs, the same content. This avoids
+// duplicating images in the archive, e.g. when EmbedImages finds the same
+// image in many sections or AddImageFromBytes is called with identical bytes.
+//
+// Remote resources are compared by URL only, as their content isn't retrieved
+// until Write, and resources added from readers are never deduplicated. It is
+// disabled by default.
+func (e *Epub) SetResourceDeduplication(enabled bool) {
+	e.Lock()
+	defer e.Unlock()
+	e.deduplicate = enabled
+}
+
+// duplicateResource returns the path of the resource of mediaMap with the same
+// source or content as source, or an empty string if there is none
+func (e *Epub) duplicateResource(g grabber, source string, mediaFolderName string, mediaMap map[string]string) string {
+	kind := detectMediaType(source)
+	if !e.deduplicate || kind == "Reader" {
+		return ""
+	}
+	// Resources with the same content are compared by hash only when it is
+	// cheap to compute
+	hashed := (kind == "File" || kind == "DataURL") && g.fetcher(source) == nil
+	hash := ""
+	if hashed {
+		hash = e.sourceHash(g, source)
+	}
+
+	duplicate := ""
+	for filename, s := range mediaMap {
+		same := s == source
+		if !same && hashed && hash != "" {
+			if k := detectMediaType(s); (k == "File" || k == "DataURL") && g.fetcher(s) == nil {
+				same = e.sourceHash(g, s) == hash
+			}
+		}
+		// The first filename is used if the resource was added several times
+		// before deduplication was enabled
+		if same && (duplicate == "" || filename < duplicate) {
+			duplicate = filename
+		}
+	}
+	if duplicate == "" {
+		return ""
+	}
+	return path.Join("..", mediaFolderName, duplicate)
+}
+
+// sourceHash returns the SHA-256 hash of the content of source, or an empty
+// string if it can't be read. Hashes are cached by source.
+func (e *Epub) sourceHash(g grabber, source string) string {
+	if hash, ok := e.sourceHashes[source]; ok {
+		return hash
+	}
+	r, err := g.open(source)
+	if err != nil {
+		return ""
+	}
+	defer r.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return ""
+	}
+	hash := hex.EncodeToString(h.Sum(nil))
+	if e.sourceHashes == nil {
+		e.sourceHashes = make(map[string]string)
+	}
+	e.sourceHashes[source] = hash
+	return hash
+}
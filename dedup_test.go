@@ -0,0 +1,62 @@
+package epub
+
+import (
+	"os"
+	"testing"
+)
+
+func TestSetResourceDeduplication(t *testing.T) {
+	content, err := os.ReadFile(testImageFromFileSource)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e, err := NewEpub(testEpubTitle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, err := e.AddImage(testImageFromFileSource, "first.png")
+	if err != nil {
+		t.Fatalf("Error adding image: %s", err)
+	}
+	second, err := e.AddImage(testImageFromFileSource, "second.png")
+	if err != nil {
+		t.Fatalf("Error adding image: %s", err)
+	}
+	if first == second {
+		t.Errorf("Image deduplicated while disabled: %s", first)
+	}
+
+	e.SetResourceDeduplication(true)
+	paths := map[string]func() (string, error){
+		"same source": func() (string, error) {
+			return e.AddImage(testImageFromFileSource, "third.png")
+		},
+		"same source and filename": func() (string, error) {
+			return e.AddImage(testImageFromFileSource, "first.png")
+		},
+		"same bytes": func() (string, error) {
+			return e.AddImageFromBytes(content, "fourth.png")
+		},
+	}
+	for name, add := range paths {
+		p, err := add()
+		if err != nil {
+			t.Errorf("%s: unexpected error adding image: %s", name, err)
+		}
+		if p != first {
+			t.Errorf("%s: image not deduplicated\nGot: %s\nExpected: %s", name, p, first)
+		}
+	}
+	if n := len(e.Images()); n != 2 {
+		t.Errorf("Number of images doesn't match\nGot: %d\nExpected: 2", n)
+	}
+
+	other, err := e.AddImageFromBytes(append(content, 0), "other.png")
+	if err != nil {
+		t.Fatalf("Error adding image: %s", err)
+	}
+	if other == first {
+		t.Error("Different image deduplicated")
+	}
+}
@@ -0,0 +1,126 @@
+package epub
+
+import (
+	"context"
+	"errors"
+	"path"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// SetDownloadConcurrency sets how many resources are fetched at the same time
+// when the EPUB is written, 1 by default. Books with hundreds of remote images
+// are written much faster with a few concurrent downloads.
+//
+// The resources are processed in the order of their internal filenames once
+// fetched, so the manifest and the archive are the same whatever the number of
+// concurrent downloads. When it is over 1, the Client, the middlewares and the
+// Tracer are called from several goroutines and must be safe for concurrent
+// use.
+func (e *Epub) SetDownloadConcurrency(n int) error {
+	if n < 1 {
+		return errors.New("invalid download concurrency: must be at least 1")
+	}
+	e.Lock()
+	defer e.Unlock()
+	e.downloadConcurrency = n
+	return nil
+}
+
+// mediaDownload is the fetch of a resource by a download worker
+type mediaDownload struct {
+	mediaType string
+	// Duration of the fetch, for the report
+	duration time.Duration
+	err      error
+	// Value of the panic of the fetch, raised again by wait
+	panicked any
+	// Closed once the fetch is done
+	done chan struct{}
+}
+
+// downloadMedia starts fetching the sources of mediaMap with the given
+// internal filenames into mediaFolderPath, in their order, with up to
+// downloadConcurrency fetches at a time. Only the media type of the streamed
+// ones is detected. The returned function cancels the fetches still pending
+// and waits for the workers to return.
+func (e *Epub) downloadMedia(mediaMap map[string]string, filenames []string, streamed map[string]bool, mediaFolderPath string) (map[string]*mediaDownload, func()) {
+	ctx := e.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	g := e.grabber(ctx)
+
+	downloads := make(map[string]*mediaDownload, len(filenames))
+	jobs := make(chan string, len(filenames))
+	for _, filename := range filenames {
+		downloads[filename] = &mediaDownload{done: make(chan struct{})}
+		jobs <- filename
+	}
+	close(jobs)
+
+	var wg sync.WaitGroup
+	for i := 0; i < max(1, min(e.downloadConcurrency, len(filenames))); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for filename := range jobs {
+				d := downloads[filename]
+				if ctx.Err() == nil {
+					if streamed[filename] {
+						d.sniff(e, g, mediaMap[filename], mediaFolderPath, filename)
+					} else {
+						d.fetch(e, g, mediaMap[filename], mediaFolderPath, filename, e.checksums[path.Join(filepath.Base(mediaFolderPath), filename)])
+					}
+				} else {
+					d.err = &FileRetrievalError{Source: mediaMap[filename], Err: ctx.Err()}
+					close(d.done)
+				}
+			}
+		}()
+	}
+	return downloads, func() {
+		cancel()
+		wg.Wait()
+	}
+}
+
+// fetch fetches mediaSource, checking its SHA-256 hash if checksum is set, and
+// records a panic so that it is raised again in the goroutine writing the EPUB
+func (d *mediaDownload) fetch(e *Epub, g grabber, mediaSource string, mediaFolderPath string, mediaFilename string, checksum string) {
+	defer close(d.done)
+	defer func() {
+		d.panicked = recover()
+	}()
+	start := time.Now()
+	span := e.startSpan(SpanFetch, map[string]string{"source": mediaSource})
+	d.mediaType, d.err = g.fetchMedia(mediaSource, mediaFolderPath, mediaFilename)
+	if d.err == nil && checksum != "" {
+		d.err = g.verifyChecksum(mediaSource, filepath.Join(mediaFolderPath, mediaFilename), checksum)
+	}
+	span.End(d.err)
+	d.duration = time.Since(start)
+}
+
+// sniff detects the media type of the streamed mediaSource like fetch
+func (d *mediaDownload) sniff(e *Epub, g grabber, mediaSource string, mediaFolderPath string, mediaFilename string) {
+	defer close(d.done)
+	defer func() {
+		d.panicked = recover()
+	}()
+	start := time.Now()
+	span := e.startSpan(SpanFetch, map[string]string{"source": mediaSource})
+	d.mediaType, d.err = g.sniffMedia(mediaSource, filepath.Base(mediaFolderPath), mediaFilename)
+	span.End(d.err)
+	d.duration = time.Since(start)
+}
+
+// wait waits for the fetch to be done
+func (d *mediaDownload) wait() {
+	<-d.done
+	if d.panicked != nil {
+		panic(d.panicked)
+	}
+}
@@ -0,0 +1,83 @@
+package epub
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-shiori/go-epub/internal/storage"
+)
+
+func TestSetDownloadConcurrency(t *testing.T) {
+	image, err := os.ReadFile(testImageFromFileSource)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mu sync.Mutex
+	running, maxRunning := 0, 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			mu.Lock()
+			running++
+			maxRunning = max(maxRunning, running)
+			mu.Unlock()
+			time.Sleep(20 * time.Millisecond)
+			mu.Lock()
+			running--
+			mu.Unlock()
+		}
+		w.Write(image)
+	}))
+	defer ts.Close()
+
+	var packages []string
+	for _, concurrency := range []int{1, 4} {
+		e, err := NewEpub(testEpubTitle)
+		if err != nil {
+			t.Fatal(err)
+		}
+		e.SetIdentifier("urn:uuid:51b7c9ea-b2a2-49c6-9d8c-522790786d15")
+		if err := e.SetDownloadConcurrency(concurrency); err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 8; i++ {
+			if _, err := e.AddImage(fmt.Sprintf("%s/%d.png", ts.URL, i), ""); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		maxRunning = 0
+		tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+		pkg, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, pkgFilename))
+		cleanup(testEpubFilename, tempDir)
+		if err != nil {
+			t.Fatalf("Unexpected error reading package file: %s", err)
+		}
+		// The modification date changes between the builds
+		modified := regexp.MustCompile(`<meta property="dcterms:modified">[^<]*</meta>`)
+		packages = append(packages, modified.ReplaceAllString(string(pkg), ""))
+		if concurrency == 1 && maxRunning != 1 {
+			t.Errorf("Expected 1 download at a time, got %d", maxRunning)
+		}
+		if concurrency > 1 && maxRunning < 2 {
+			t.Errorf("Expected concurrent downloads, got %d at most", maxRunning)
+		}
+	}
+	if packages[0] != packages[1] {
+		t.Errorf("Package file depends on the download concurrency\nGot: %s\nExpected: %s", packages[1], packages[0])
+	}
+
+	e, err := NewEpub(testEpubTitle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.SetDownloadConcurrency(0); err == nil {
+		t.Error("Expected an error for a concurrency of 0")
+	}
+}
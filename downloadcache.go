@@ -0,0 +1,195 @@
+package epub
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// downloadCache keeps the remote resources on the local disk between builds,
+// see SetDownloadCache
+type downloadCache struct {
+	dir string
+}
+
+// downloadCacheEntry is the metadata of a cached resource, stored next to its
+// content
+type downloadCacheEntry struct {
+	URL          string `json:"url"`
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"lastModified,omitempty"`
+}
+
+// SetDownloadCache sets a directory of the local disk where the remote
+// resources are kept between builds, e.g. for a nightly job building the same
+// book, so that they are downloaded again only when they changed. An empty
+// directory disables the cache, the default.
+//
+// Resources are cached by URL and ETag, or Last-Modified date if the server
+// doesn't send an ETag; resources with neither aren't cached. When a cached
+// resource is fetched, the server is asked whether it changed, and the cached
+// content is used if it didn't. The directory is created if needed and can
+// be shared by several EPUBs and processes.
+func (e *Epub) SetDownloadCache(dir string) error {
+	if dir != "" {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return fmt.Errorf("can't set download cache: %w", err)
+		}
+	}
+	e.Lock()
+	defer e.Unlock()
+	if dir == "" {
+		e.downloadCache = nil
+		return nil
+	}
+	e.downloadCache = &downloadCache{dir: dir}
+	return nil
+}
+
+// hash returns the hexadecimal SHA-256 hash of parts, separated so that they
+// can't be confused
+func (c *downloadCache) hash(parts ...string) string {
+	h := sha256.New()
+	for _, part := range parts {
+		fmt.Fprintf(h, "%d:%s\n", len(part), part)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// entryPath returns the path of the metadata of the resource at url
+func (c *downloadCache) entryPath(url string) string {
+	return filepath.Join(c.dir, c.hash(url)+".json")
+}
+
+// contentPath returns the path of the content of a cached resource
+func (c *downloadCache) contentPath(entry *downloadCacheEntry) string {
+	return filepath.Join(c.dir, c.hash(entry.URL, entry.ETag, entry.LastModified))
+}
+
+// lookup returns the metadata of the cached resource at url, or nil if it
+// isn't cached
+func (c *downloadCache) lookup(url string) *downloadCacheEntry {
+	data, err := os.ReadFile(c.entryPath(url))
+	if err != nil {
+		return nil
+	}
+	var entry downloadCacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil || entry.URL != url {
+		return nil
+	}
+	if _, err := os.Stat(c.contentPath(&entry)); err != nil {
+		return nil
+	}
+	return &entry
+}
+
+// setConditional makes req conditional on the cached resource having changed
+func (entry *downloadCacheEntry) setConditional(req *http.Request) {
+	if entry.ETag != "" {
+		req.Header.Set("If-None-Match", entry.ETag)
+	}
+	if entry.LastModified != "" {
+		req.Header.Set("If-Modified-Since", entry.LastModified)
+	}
+}
+
+// open returns the content of a cached resource
+func (c *downloadCache) open(entry *downloadCacheEntry) (io.ReadCloser, error) {
+	return os.Open(c.contentPath(entry))
+}
+
+// store returns a reader of the body of resp, caching it once read entirely if
+// the response has validators
+func (c *downloadCache) store(url string, resp *http.Response) io.ReadCloser {
+	entry := &downloadCacheEntry{
+		URL:          url,
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+	}
+	if entry.ETag == "" && entry.LastModified == "" {
+		return resp.Body
+	}
+	temp, err := os.CreateTemp(c.dir, "download-*.tmp")
+	if err != nil {
+		return resp.Body
+	}
+	return &cachingReader{cache: c, entry: entry, body: resp.Body, temp: temp}
+}
+
+// cachingReader copies the body of a response to a temporary file, moved to
+// the cache once the body is read entirely
+type cachingReader struct {
+	cache *downloadCache
+	entry *downloadCacheEntry
+	body  io.ReadCloser
+	temp  *os.File
+	// Set once the content is cached or can't be
+	done bool
+}
+
+func (r *cachingReader) Read(p []byte) (int, error) {
+	n, err := r.body.Read(p)
+	if !r.done && n > 0 {
+		if _, werr := r.temp.Write(p[:n]); werr != nil {
+			r.discard()
+		}
+	}
+	if !r.done && errors.Is(err, io.EOF) {
+		r.commit()
+	}
+	return n, err
+}
+
+func (r *cachingReader) Close() error {
+	if !r.done {
+		// The body wasn't read entirely
+		r.discard()
+	}
+	return r.body.Close()
+}
+
+// commit moves the content to the cache and writes its metadata, so that the
+// metadata never points to a partial content
+func (r *cachingReader) commit() {
+	r.done = true
+	if err := r.temp.Close(); err != nil {
+		os.Remove(r.temp.Name())
+		return
+	}
+	if err := os.Rename(r.temp.Name(), r.cache.contentPath(r.entry)); err != nil {
+		os.Remove(r.temp.Name())
+		return
+	}
+	data, err := json.Marshal(r.entry)
+	if err != nil {
+		return
+	}
+	entryPath := r.cache.entryPath(r.entry.URL)
+	temp, err := os.CreateTemp(r.cache.dir, "entry-*.tmp")
+	if err != nil {
+		return
+	}
+	_, err = temp.Write(data)
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(temp.Name(), entryPath)
+	}
+	if err != nil {
+		os.Remove(temp.Name())
+	}
+}
+
+// discard drops the temporary file
+func (r *cachingReader) discard() {
+	r.done = true
+	r.temp.Close()
+	os.Remove(r.temp.Name())
+}
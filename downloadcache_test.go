@@ -0,0 +1,80 @@
+package epub
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/go-shiori/go-epub/internal/storage"
+)
+
+func TestSetDownloadCache(t *testing.T) {
+	image, err := os.ReadFile(testImageFromFileSource)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var etag atomic.Value
+	etag.Store(`"v1"`)
+	var full, notModified atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/image.png" {
+			w.Header().Set("ETag", etag.Load().(string))
+			if r.Header.Get("If-None-Match") == etag.Load().(string) {
+				notModified.Add(1)
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		}
+		if r.Method == http.MethodGet {
+			full.Add(1)
+		}
+		w.Write(image)
+	}))
+	defer ts.Close()
+	dir := t.TempDir()
+
+	// build writes an EPUB with the remote images and checks its content
+	build := func() {
+		e, err := NewEpub(testEpubTitle)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := e.SetDownloadCache(dir); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := e.AddImage(ts.URL+"/image.png", "image.png"); err != nil {
+			t.Fatal(err)
+		}
+		// Without validators, the resource isn't cached
+		if _, err := e.AddImage(ts.URL+"/other.png", "other.png"); err != nil {
+			t.Fatal(err)
+		}
+		tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+		defer cleanup(testEpubFilename, tempDir)
+		contents, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, ImageFolderName, "image.png"))
+		if err != nil {
+			t.Fatalf("Unexpected error reading image: %s", err)
+		}
+		if !bytes.Equal(contents, image) {
+			t.Error("Image content differs from the served one")
+		}
+	}
+
+	build()
+	if full.Load() != 2 || notModified.Load() != 0 {
+		t.Errorf("First build: expected 2 downloads, got %d and %d not modified", full.Load(), notModified.Load())
+	}
+	build()
+	if full.Load() != 3 || notModified.Load() != 1 {
+		t.Errorf("Second build: expected 3 downloads, got %d and %d not modified", full.Load(), notModified.Load())
+	}
+	etag.Store(`"v2"`)
+	build()
+	if full.Load() != 5 || notModified.Load() != 1 {
+		t.Errorf("Changed resource: expected 5 downloads, got %d and %d not modified", full.Load(), notModified.Load())
+	}
+}
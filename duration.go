@@ -0,0 +1,128 @@
+package epub
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Property of the meta elements holding the duration of the media overlays
+const pkgDurationProperty = "media:duration"
+
+// DurationProber returns the playing time of an audio file, read from r.
+// mediaType is the media type detected from its content, e.g. "audio/mpeg".
+type DurationProber func(r io.Reader, mediaType string) (time.Duration, error)
+
+// SetDurationProber sets the function reading the duration of the audio files
+// when the EPUB is written, to emit the media:duration metadata required by
+// media overlays and audiobooks instead of entering it by hand. The total
+// duration of the audio files is added to the package metadata:
+//
+//	<meta property="media:duration">1:02:03.500</meta>
+//
+// go-epub only provides ProbeWAVDuration: for compressed formats, the prober
+// can use a decoding library or an external tool such as ffprobe. The audio
+// files it fails on are left out of the total and reported as warnings. A nil
+// prober disables the metadata, the default.
+func (e *Epub) SetDurationProber(prober DurationProber) {
+	e.Lock()
+	defer e.Unlock()
+	e.durationProber = prober
+}
+
+// ProbeWAVDuration is a DurationProber for uncompressed WAV files.
+func ProbeWAVDuration(r io.Reader, mediaType string) (time.Duration, error) {
+	var header [12]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return 0, fmt.Errorf("can't read WAV header: %w", err)
+	}
+	if string(header[:4]) != "RIFF" || string(header[8:]) != "WAVE" {
+		return 0, errors.New("not a WAV file")
+	}
+
+	var byteRate uint32
+	for {
+		var chunk [8]byte
+		if _, err := io.ReadFull(r, chunk[:]); err != nil {
+			return 0, fmt.Errorf("can't read WAV chunk: %w", err)
+		}
+		id := string(chunk[:4])
+		size := binary.LittleEndian.Uint32(chunk[4:])
+		switch id {
+		case "fmt ":
+			if size < 16 {
+				return 0, errors.New("invalid WAV format chunk")
+			}
+			format := make([]byte, size)
+			if _, err := io.ReadFull(r, format); err != nil {
+				return 0, fmt.Errorf("can't read WAV format chunk: %w", err)
+			}
+			byteRate = binary.LittleEndian.Uint32(format[8:12])
+		case "data":
+			if byteRate == 0 {
+				return 0, errors.New("WAV data chunk before format chunk")
+			}
+			return time.Duration(float64(size) / float64(byteRate) * float64(time.Second)), nil
+		default:
+			// Chunks are padded to an even size
+			if _, err := io.CopyN(io.Discard, r, int64(size+size%2)); err != nil {
+				return 0, fmt.Errorf("can't read WAV chunk %q: %w", id, err)
+			}
+		}
+	}
+}
+
+// probeDuration reads the duration of the audio file just written at
+// mediaFilePath
+func (e *Epub) probeDuration(mediaFilename string, mediaFilePath string, mediaType string) {
+	if e.durationProber == nil {
+		return
+	}
+	delete(e.durations, mediaFilename)
+	r, err := e.spool.open(mediaFilePath)
+	if err != nil {
+		e.warn("can't read duration of %s: %s", mediaFilename, err)
+		return
+	}
+	defer r.Close()
+	d, err := e.durationProber(r, mediaType)
+	if err != nil {
+		e.warn("can't read duration of %s: %s", mediaFilename, err)
+		return
+	}
+	if e.durations == nil {
+		e.durations = make(map[string]time.Duration)
+	}
+	e.durations[mediaFilename] = d
+}
+
+// writeDurations adds the total duration of the audio files to the package
+// metadata
+func (e *Epub) writeDurations() {
+	if e.durationProber == nil {
+		e.pkg.setDuration("", "")
+		return
+	}
+	probed := false
+	var total time.Duration
+	for filename := range e.audios {
+		if d, ok := e.durations[filename]; ok {
+			probed = true
+			total += d
+		}
+	}
+	if !probed {
+		e.pkg.setDuration("", "")
+		return
+	}
+	e.pkg.setDuration("", clockValue(total))
+}
+
+// clockValue formats d as a SMIL clock value, e.g. 1:02:03.500
+func clockValue(d time.Duration) string {
+	d = d.Round(time.Millisecond)
+	return fmt.Sprintf("%d:%02d:%02d.%03d",
+		int(d/time.Hour), int(d/time.Minute)%60, int(d/time.Second)%60, int(d/time.Millisecond)%1000)
+}
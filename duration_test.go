@@ -0,0 +1,96 @@
+package epub
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-shiori/go-epub/internal/storage"
+)
+
+func TestProbeWAVDuration(t *testing.T) {
+	f, err := os.Open(testAudioFromFileSource)
+	if err != nil {
+		t.Fatalf("Unexpected error opening testdata audio file: %s", err)
+	}
+	defer f.Close()
+	d, err := ProbeWAVDuration(f, "audio/wav")
+	if err != nil {
+		t.Errorf("Error probing WAV duration: %s", err)
+	}
+	if d.Round(time.Millisecond) != 190*time.Millisecond {
+		t.Errorf("Unexpected WAV duration: %s", d)
+	}
+	if _, err := ProbeWAVDuration(strings.NewReader("not audio"), "text/plain"); err == nil {
+		t.Error("Expected an error probing a file that isn't WAV")
+	}
+}
+
+func TestSetDurationProber(t *testing.T) {
+	e, err := NewEpub(testEpubTitle)
+	if err != nil {
+		t.Error(err)
+	}
+	_, err = e.AddAudio(testAudioFromFileSource, "a.wav")
+	if err != nil {
+		t.Errorf("Error adding audio: %s", err)
+	}
+	_, err = e.AddAudio(testAudioFromFileSource, "b.wav")
+	if err != nil {
+		t.Errorf("Error adding audio: %s", err)
+	}
+	_, err = e.AddAudio(testAudioFromFileSource, "c.wav")
+	if err != nil {
+		t.Errorf("Error adding audio: %s", err)
+	}
+	e.SetDurationProber(func(r io.Reader, mediaType string) (time.Duration, error) {
+		if mediaType != "audio/wav" {
+			t.Errorf("Unexpected media type: %s", mediaType)
+		}
+		if len(e.durations) == 2 {
+			return 0, errors.New("unreadable")
+		}
+		return 61*time.Minute + 500*time.Millisecond, nil
+	})
+
+	tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+	defer cleanup(testEpubFilename, tempDir)
+
+	pkg, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, pkgFilename))
+	if err != nil {
+		t.Errorf("Unexpected error reading package file: %s", err)
+	}
+	if !strings.Contains(string(pkg), `<meta property="media:duration">2:02:01.000</meta>`) {
+		t.Errorf("Package file doesn't contain the total duration:\n%s", pkg)
+	}
+	if len(e.BuildReport().Warnings) != 1 {
+		t.Errorf("Expected a warning for the audio file that can't be probed, got %v", e.BuildReport().Warnings)
+	}
+
+	e.SetDurationProber(nil)
+	tempDir2 := writeAndExtractEpub(t, e, testEpubFilename)
+	defer cleanup(testEpubFilename, tempDir2)
+	pkg, err = storage.ReadFile(filesystem, filepath.Join(tempDir2, contentFolderName, pkgFilename))
+	if err != nil {
+		t.Errorf("Unexpected error reading package file: %s", err)
+	}
+	if strings.Contains(string(pkg), "media:duration") {
+		t.Errorf("Package file contains a duration with no prober:\n%s", pkg)
+	}
+}
+
+func TestClockValue(t *testing.T) {
+	for d, expected := range map[time.Duration]string{
+		0:                       "0:00:00.000",
+		1500 * time.Millisecond: "0:00:01.500",
+		time.Hour + 2*time.Minute + 3*time.Second: "1:02:03.000",
+	} {
+		if got := clockValue(d); got != expected {
+			t.Errorf("clockValue(%s): got %s, expected %s", d, got, expected)
+		}
+	}
+}
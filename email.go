@@ -0,0 +1,252 @@
+package epub
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"path"
+	"strings"
+
+	"github.com/gabriel-vasile/mimetype"
+	"github.com/vincent-petithory/dataurl"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/charset"
+)
+
+// A leaf part of a MIME message
+type mimePart struct {
+	mediaType string
+	params    map[string]string
+	header    textproto.MIMEHeader
+	data      []byte
+}
+
+// AddSectionFromEmail adds the content of a raw RFC 822 email or of an MHTML
+// file as a section, e.g. to build a digest of newsletters. The HTML part of
+// the message is used as the body of the section, or its plain text part if it
+// has none. The images embedded in the message and referenced by the HTML part
+// (with cid: URLs, or by their Content-Location in MHTML files) are added to
+// the EPUB; the ones that can't be added are reported as warnings, see
+// Warnings.
+//
+// The title of the section is the subject of the message, or the title of its
+// HTML part. The internal filename and the internal CSS path are handled as
+// in AddSection. If the section can't be added, the images of the message
+// aren't kept either.
+func (e *Epub) AddSectionFromEmail(r io.Reader, internalFilename string, internalCSSPath string) (string, error) {
+	return e.AddSectionFromEmailContext(context.Background(), r, internalFilename, internalCSSPath)
+}
+
+// AddSectionFromEmailContext is like AddSectionFromEmail, stopping when ctx is
+// done.
+func (e *Epub) AddSectionFromEmailContext(ctx context.Context, r io.Reader, internalFilename string, internalCSSPath string) (string, error) {
+	msg, err := mail.ReadMessage(r)
+	if err != nil {
+		return "", fmt.Errorf("can't read message: %w", err)
+	}
+	var parts []*mimePart
+	if err := readMIMEParts(textproto.MIMEHeader(msg.Header), msg.Body, &parts); err != nil {
+		return "", fmt.Errorf("can't read message: %w", err)
+	}
+
+	decoder := &mime.WordDecoder{CharsetReader: charset.NewReaderLabel}
+	title, err := decoder.DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil {
+		title = msg.Header.Get("Subject")
+	}
+
+	// The images and the section are added in one step, so that the images
+	// are named against the resources added meanwhile and can be removed if
+	// the section can't be added
+	e.Lock()
+	defer e.Unlock()
+	var body string
+	var images []string
+	if part := findMIMEPart(parts, "text/html"); part != nil {
+		doc, root, err := parseDocument(part.data)
+		if err != nil {
+			return "", fmt.Errorf("can't parse HTML part: %w", err)
+		}
+		if title == "" {
+			title = documentTitle(doc)
+		}
+		images = e.embedMIMEImages(ctx, root, parts)
+		body, err = renderBody(root)
+		if err != nil {
+			e.forgetImages(images)
+			return "", err
+		}
+	} else if part := findMIMEPart(parts, "text/plain"); part != nil {
+		body = textToHTML(string(part.data))
+	} else {
+		return "", fmt.Errorf("can't read message: no text part found")
+	}
+
+	filename, err := e.addSection("", body, title, internalFilename, internalCSSPath)
+	if err != nil {
+		e.forgetImages(images)
+		return "", err
+	}
+	return filename, nil
+}
+
+// readMIMEParts appends the leaf parts of a MIME entity to parts, with their
+// content decoded to UTF-8 for text parts
+func readMIMEParts(header textproto.MIMEHeader, body io.Reader, parts *[]*mimePart) error {
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		mediaType, params = "text/plain", map[string]string{}
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		mr := multipart.NewReader(body, params["boundary"])
+		for {
+			p, err := mr.NextPart()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if err := readMIMEParts(p.Header, p, parts); err != nil {
+				return err
+			}
+		}
+	}
+
+	switch strings.ToLower(strings.TrimSpace(header.Get("Content-Transfer-Encoding"))) {
+	case "base64":
+		body = base64.NewDecoder(base64.StdEncoding, body)
+	case "quoted-printable":
+		body = quotedprintable.NewReader(body)
+	}
+	if strings.HasPrefix(mediaType, "text/") && params["charset"] != "" {
+		body, err = charset.NewReaderLabel(params["charset"], body)
+		if err != nil {
+			return err
+		}
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return err
+	}
+	*parts = append(*parts, &mimePart{
+		mediaType: mediaType,
+		params:    params,
+		header:    header,
+		data:      data,
+	})
+	return nil
+}
+
+func findMIMEPart(parts []*mimePart, mediaType string) *mimePart {
+	for _, part := range parts {
+		if part.mediaType == mediaType {
+			return part
+		}
+	}
+	return nil
+}
+
+// embedMIMEImages adds the image parts referenced by the HTML part of a
+// message to the EPUB, and points the references to them. It returns the
+// internal filenames of the images added, the ones already in the EPUB left
+// out.
+func (e *Epub) embedMIMEImages(ctx context.Context, root *html.Node, parts []*mimePart) []string {
+	images := map[string]*mimePart{}
+	for _, part := range parts {
+		if !strings.HasPrefix(part.mediaType, "image/") {
+			continue
+		}
+		if id := strings.Trim(part.header.Get("Content-ID"), " <>"); id != "" {
+			images["cid:"+id] = part
+		}
+		if location := part.header.Get("Content-Location"); location != "" {
+			images[location] = part
+		}
+	}
+	if len(images) == 0 {
+		return nil
+	}
+
+	var filenames []string
+	added := map[*mimePart]string{}
+	// Images that can't be added, reported once
+	failed := map[*mimePart]bool{}
+	walk(root, func(n *html.Node) bool {
+		for i, a := range n.Attr {
+			if a.Key != "src" && a.Key != "background" {
+				continue
+			}
+			part, ok := images[a.Val]
+			if !ok || failed[part] {
+				continue
+			}
+			if _, ok := added[part]; !ok {
+				count := len(e.images)
+				imagePath, err := e.addMIMEImage(ctx, part)
+				if err != nil {
+					e.addWarning(WarningMissingImage, a.Val, "can't add image %s: %s", a.Val, err)
+					failed[part] = true
+					continue
+				}
+				if len(e.images) > count {
+					filenames = append(filenames, path.Base(imagePath))
+				}
+				added[part] = imagePath
+			}
+			n.Attr[i].Val = added[part]
+		}
+		return true
+	})
+	return filenames
+}
+
+// addMIMEImage adds an image part of a message to the EPUB, named after its
+// filename if it is free
+func (e *Epub) addMIMEImage(ctx context.Context, part *mimePart) (string, error) {
+	source := dataurl.New(part.data, part.mediaType).String()
+
+	_, params, _ := mime.ParseMediaType(part.header.Get("Content-Disposition"))
+	filename := path.Base(params["filename"])
+	if filename == "." || filename == "/" {
+		filename = ""
+	}
+	if filename != "" {
+		imagePath, err := e.addResource(ctx, source, filename, imageFileFormat, ImageFolderName, e.images)
+		if _, ok := err.(*FilenameAlreadyUsedError); !ok {
+			return imagePath, err
+		}
+	}
+
+	extension := ""
+	if m := mimetype.Lookup(part.mediaType); m != nil {
+		extension = m.Extension()
+	}
+	return e.addResource(ctx, source, unusedFilename(imageFileFormat, extension, e.images), imageFileFormat, ImageFolderName, e.images)
+}
+
+// textToHTML converts plain text to HTML paragraphs, separated by blank lines
+func textToHTML(text string) string {
+	var b bytes.Buffer
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	for _, paragraph := range strings.Split(text, "\n\n") {
+		paragraph = strings.TrimSpace(paragraph)
+		if paragraph == "" {
+			continue
+		}
+		lines := strings.Split(paragraph, "\n")
+		for i, line := range lines {
+			lines[i] = html.EscapeString(line)
+		}
+		b.WriteString("<p>" + strings.Join(lines, "<br/>") + "</p>")
+	}
+	return b.String()
+}
@@ -0,0 +1,147 @@
+package epub
+
+import (
+	"context"
+	"encoding/base64"
+	"os"
+	"strings"
+	"testing"
+)
+
+const testEmail = "From: newsletter@example.com\r\n" +
+	"Subject: =?ISO-8859-1?Q?Caf=E9_weekly?=\r\n" +
+	"MIME-Version: 1.0\r\n" +
+	"Content-Type: multipart/related; boundary=\"related\"\r\n" +
+	"\r\n" +
+	"--related\r\n" +
+	"Content-Type: multipart/alternative; boundary=\"alternative\"\r\n" +
+	"\r\n" +
+	"--alternative\r\n" +
+	"Content-Type: text/plain; charset=utf-8\r\n" +
+	"\r\n" +
+	"Plain text version\r\n" +
+	"--alternative\r\n" +
+	"Content-Type: text/html; charset=ISO-8859-1\r\n" +
+	"Content-Transfer-Encoding: quoted-printable\r\n" +
+	"\r\n" +
+	"<html><body><p>Caf=E9</p><img src=3D\"cid:logo@example.com\"><img src=3D\"cid:logo@example.com\"></body></html>\r\n" +
+	"--alternative--\r\n" +
+	"--related\r\n" +
+	"Content-Type: image/png\r\n" +
+	"Content-Transfer-Encoding: base64\r\n" +
+	"Content-ID: <logo@example.com>\r\n" +
+	"Content-Disposition: inline; filename=\"logo.png\"\r\n" +
+	"\r\n" +
+	"%s\r\n" +
+	"--related--\r\n"
+
+const testMHTML = "Subject: \r\n" +
+	"MIME-Version: 1.0\r\n" +
+	"Content-Type: multipart/related; type=\"text/html\"; boundary=\"mhtml\"\r\n" +
+	"\r\n" +
+	"--mhtml\r\n" +
+	"Content-Type: text/html\r\n" +
+	"Content-Location: https://example.com/page.html\r\n" +
+	"\r\n" +
+	"<html><head><title>Saved page</title></head><body><img src=\"https://example.com/image.png\"></body></html>\r\n" +
+	"--mhtml\r\n" +
+	"Content-Type: image/png\r\n" +
+	"Content-Transfer-Encoding: base64\r\n" +
+	"Content-Location: https://example.com/image.png\r\n" +
+	"\r\n" +
+	"%s\r\n" +
+	"--mhtml--\r\n"
+
+func TestAddSectionFromEmail(t *testing.T) {
+	image, err := os.ReadFile(testImageFromFileSource)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encodedImage := base64.StdEncoding.EncodeToString(image)
+
+	tests := []struct {
+		name    string
+		message string
+		title   string
+		body    string
+		image   string
+	}{
+		{
+			"email",
+			strings.Replace(testEmail, "%s", encodedImage, 1),
+			"Café weekly",
+			`<p>Café</p><img src="../images/logo.png"/><img src="../images/logo.png"/>`,
+			"logo.png",
+		},
+		{
+			"MHTML",
+			strings.Replace(testMHTML, "%s", encodedImage, 1),
+			"Saved page",
+			`<img src="../images/image0001.png"/>`,
+			"image0001.png",
+		},
+		{
+			"plain text",
+			"Subject: Plain\r\nContent-Type: text/plain\r\n\r\nFirst <line>\r\nSecond line\r\n\r\nNext paragraph\r\n",
+			"Plain",
+			`<p>First &lt;line&gt;<br/>Second line</p><p>Next paragraph</p>`,
+			"",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, err := NewEpub(testEpubTitle)
+			if err != nil {
+				t.Error(err)
+			}
+			filename, err := e.AddSectionFromEmail(strings.NewReader(tt.message), "", "")
+			if err != nil {
+				t.Fatalf("Error adding section: %s", err)
+			}
+			s := findSection(e.sections, filename)
+			if s.xhtml.Title() != tt.title {
+				t.Errorf("Got title %q, expected %q", s.xhtml.Title(), tt.title)
+			}
+			if strings.TrimSpace(s.xhtml.body()) != tt.body {
+				t.Errorf("Got body %s, expected %s", s.xhtml.body(), tt.body)
+			}
+			if tt.image != "" && len(e.images) != 1 {
+				t.Errorf("Expected image %s to be added once, got %v", tt.image, e.images)
+			}
+			if _, ok := e.images[tt.image]; tt.image != "" && !ok {
+				t.Errorf("Image %s not added", tt.image)
+			}
+		})
+	}
+
+	e, err := NewEpub(testEpubTitle)
+	if err != nil {
+		t.Error(err)
+	}
+	if _, err := e.AddSectionFromEmail(strings.NewReader("Subject: Empty\r\nContent-Type: image/png\r\n\r\n"), "", ""); err == nil {
+		t.Error("Expected an error adding a message without text part")
+	}
+
+	// The images aren't kept if the section can't be added
+	if _, err := e.AddSection("<p>Taken</p>", "Taken", "taken.xhtml", ""); err != nil {
+		t.Fatal(err)
+	}
+	message := strings.Replace(testEmail, "%s", encodedImage, 1)
+	if _, err := e.AddSectionFromEmail(strings.NewReader(message), "taken.xhtml", ""); err == nil {
+		t.Error("Expected an error adding a message with a used filename")
+	}
+	if len(e.images) != 0 {
+		t.Errorf("Images of a message that couldn't be added kept: %v", e.images)
+	}
+
+	// The images that can't be added are reported
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := e.AddSectionFromEmailContext(ctx, strings.NewReader(message), "", ""); err != nil {
+		t.Errorf("Error adding section: %s", err)
+	}
+	warnings := e.Warnings()
+	if len(warnings) != 1 || warnings[0].Kind != WarningMissingImage {
+		t.Errorf("Expected a missing image warning, got %v", warnings)
+	}
+}
@@ -0,0 +1,133 @@
+package epub
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+const (
+	embedPlaceholderClass = "embed-placeholder"
+	youTubeThumbnailURL   = "https://img.youtube.com/vi/%s/hqdefault.jpg"
+	youTubeWatchURL       = "https://www.youtube.com/watch?v="
+)
+
+// EmbedPlaceholders is a Transform replacing <iframe>, <embed> and <object>
+// elements, which most reading systems ignore, with a static placeholder:
+//
+//	<figure class="embed-placeholder">
+//	  <a href="..."><img src="..." alt="..." /></a>
+//	  <figcaption><a href="...">...</a></figcaption>
+//	</figure>
+//
+// The link points to the embedded page (e.g. the video on YouTube). The
+// thumbnail image is only added for known providers (YouTube); use
+// EmbedImages to store it in the EPUB. The caption is taken from the title
+// attribute of the element, or from the name of the provider.
+func EmbedPlaceholders(body string) (string, error) {
+	return transformNodes(body, embedPlaceholders)
+}
+
+// embedPlaceholders applies EmbedPlaceholders to the parsed <body> element body
+func embedPlaceholders(body *html.Node) error {
+	walk(body, func(n *html.Node) bool {
+		var src string
+		switch n.DataAtom {
+		case atom.Iframe, atom.Embed:
+			src = getAttr(n, "src")
+		case atom.Object:
+			src = getAttr(n, "data")
+		default:
+			return true
+		}
+		if src == "" {
+			n.Parent.RemoveChild(n)
+			return false
+		}
+		link, thumbnail, caption := describeEmbed(src)
+		if title := strings.TrimSpace(getAttr(n, "title")); title != "" {
+			caption = title
+		}
+		n.Parent.InsertBefore(newEmbedPlaceholder(link, thumbnail, caption), n)
+		n.Parent.RemoveChild(n)
+		return false
+	})
+	return nil
+}
+
+// describeEmbed returns the link to the page shown by an embed, the URL of a
+// thumbnail for it if one is known, and a default caption
+func describeEmbed(src string) (link string, thumbnail string, caption string) {
+	if strings.HasPrefix(src, "//") {
+		src = "https:" + src
+	}
+	u, err := url.Parse(src)
+	if err != nil || u.Host == "" {
+		return src, "", src
+	}
+	host := strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+
+	switch {
+	case host == "youtube.com" || host == "youtube-nocookie.com" || host == "youtu.be":
+		id := path.Base(u.Path)
+		if id == "" || id == "/" || id == "." {
+			break
+		}
+		return youTubeWatchURL + url.QueryEscape(id), fmt.Sprintf(youTubeThumbnailURL, url.PathEscape(id)), "YouTube video"
+	case host == "player.vimeo.com":
+		if id := path.Base(u.Path); id != "" && id != "/" && id != "." {
+			return "https://vimeo.com/" + url.PathEscape(id), "", "Vimeo video"
+		}
+	case host == "platform.twitter.com" || host == "platform.x.com":
+		if id := u.Query().Get("id"); id != "" {
+			return "https://twitter.com/i/status/" + url.PathEscape(id), "", "Post on X (Twitter)"
+		}
+	}
+	return u.String(), "", "Embedded content from " + host
+}
+
+// newEmbedPlaceholder creates the <figure> element replacing an embed
+func newEmbedPlaceholder(link string, thumbnail string, caption string) *html.Node {
+	figure := &html.Node{
+		Type:     html.ElementNode,
+		Data:     "figure",
+		DataAtom: atom.Figure,
+		Attr:     []html.Attribute{{Key: "class", Val: embedPlaceholderClass}},
+	}
+	if thumbnail != "" {
+		a := newLink(link)
+		a.AppendChild(&html.Node{
+			Type:     html.ElementNode,
+			Data:     "img",
+			DataAtom: atom.Img,
+			Attr: []html.Attribute{
+				{Key: "src", Val: thumbnail},
+				{Key: "alt", Val: caption},
+			},
+		})
+		figure.AppendChild(a)
+	}
+	figcaption := &html.Node{
+		Type:     html.ElementNode,
+		Data:     "figcaption",
+		DataAtom: atom.Figcaption,
+	}
+	a := newLink(link)
+	a.AppendChild(&html.Node{Type: html.TextNode, Data: caption})
+	figcaption.AppendChild(a)
+	figure.AppendChild(figcaption)
+	return figure
+}
+
+func newLink(href string) *html.Node {
+	return &html.Node{
+		Type:     html.ElementNode,
+		Data:     "a",
+		DataAtom: atom.A,
+		Attr:     []html.Attribute{{Key: "href", Val: href}},
+	}
+}
@@ -0,0 +1,50 @@
+package epub
+
+import (
+	"testing"
+)
+
+func TestEmbedPlaceholders(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		expected string
+	}{
+		{
+			"YouTube",
+			`<iframe src="https://www.youtube.com/embed/dQw4w9WgXcQ" allowfullscreen></iframe>`,
+			`<figure class="embed-placeholder"><a href="https://www.youtube.com/watch?v=dQw4w9WgXcQ"><img src="https://img.youtube.com/vi/dQw4w9WgXcQ/hqdefault.jpg" alt="YouTube video"/></a><figcaption><a href="https://www.youtube.com/watch?v=dQw4w9WgXcQ">YouTube video</a></figcaption></figure>`,
+		},
+		{
+			"title as caption",
+			`<iframe src="//player.vimeo.com/video/123" title="My video"></iframe>`,
+			`<figure class="embed-placeholder"><figcaption><a href="https://vimeo.com/123">My video</a></figcaption></figure>`,
+		},
+		{
+			"tweet",
+			`<iframe src="https://platform.twitter.com/embed/Tweet.html?id=42"></iframe>`,
+			`<figure class="embed-placeholder"><figcaption><a href="https://twitter.com/i/status/42">Post on X (Twitter)</a></figcaption></figure>`,
+		},
+		{
+			"other embed",
+			`<p>Before</p><embed src="https://example.com/widget"/><object data="https://www.example.org/x.swf"></object>`,
+			`<p>Before</p><figure class="embed-placeholder"><figcaption><a href="https://example.com/widget">Embedded content from example.com</a></figcaption></figure><figure class="embed-placeholder"><figcaption><a href="https://www.example.org/x.swf">Embedded content from example.org</a></figcaption></figure>`,
+		},
+		{
+			"no source",
+			`<iframe></iframe><p>Text</p>`,
+			`<p>Text</p>`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := EmbedPlaceholders(tt.body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.expected {
+				t.Errorf("EmbedPlaceholders() = %s, want %s", got, tt.expected)
+			}
+		})
+	}
+}
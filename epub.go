@@ -32,22 +32,26 @@ package epub
 
 import (
 	"bytes"
+	"context"
 	"encoding/xml"
 	"fmt"
+	htmltemplate "html/template"
+	"io"
 	"io/fs"
 	"log"
 	"mime"
 	"net/http"
 	"net/url"
-	"os"
 	"path"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strings"
 	"sync"
+	"text/template"
+	"time"
 
 	"github.com/gofrs/uuid/v5"
-	"github.com/vincent-petithory/dataurl"
 )
 
 // FilenameAlreadyUsedError is thrown by AddCSS, AddFont, AddImage, or AddSection
@@ -61,7 +65,8 @@ func (e *FilenameAlreadyUsedError) Error() string {
 }
 
 // FileRetrievalError is thrown by AddCSS, AddFont, AddImage, or Write if there was a
-// problem retrieving the source file that was provided.
+// problem retrieving the source file that was provided, including the
+// cancellation of the context given to the *Context methods.
 type FileRetrievalError struct {
 	Source string // The source of the file whose retrieval failed
 	Err    error  // The underlying error that was thrown
@@ -71,6 +76,10 @@ func (e *FileRetrievalError) Error() string {
 	return fmt.Sprintf("Error retrieving %q from source: %+v", e.Source, e.Err)
 }
 
+func (e *FileRetrievalError) Unwrap() error {
+	return e.Err
+}
+
 // ParentDoesNotExistError is thrown by AddSubSection if the parent with the
 // previously defined internal filename does not exist.
 type ParentDoesNotExistError struct {
@@ -81,6 +90,37 @@ func (e *ParentDoesNotExistError) Error() string {
 	return fmt.Sprintf("Parent with the internal filename %s does not exist", e.Filename)
 }
 
+// SectionDoesNotExistError is thrown by UpdateSection, MoveSection or the
+// SetSection* methods if no section with the given internal filename exists.
+type SectionDoesNotExistError struct {
+	Filename string // Filename that caused the error
+}
+
+func (e *SectionDoesNotExistError) Error() string {
+	return fmt.Sprintf("Section with the internal filename %s does not exist", e.Filename)
+}
+
+// ResourceDoesNotExistError is thrown by the Remove* methods if no resource
+// with the given internal filename exists.
+type ResourceDoesNotExistError struct {
+	Filename string // Filename that caused the error
+}
+
+func (e *ResourceDoesNotExistError) Error() string {
+	return fmt.Sprintf("Resource with the internal filename %s does not exist", e.Filename)
+}
+
+// RawSectionError is thrown by the methods changing the markup of a section,
+// like AddPageBreak or SetSectionHead, if the section was added with
+// AddRawSection, since its document is written as is.
+type RawSectionError struct {
+	Filename string // Filename that caused the error
+}
+
+func (e *RawSectionError) Error() string {
+	return fmt.Sprintf("Section with the internal filename %s is a raw section", e.Filename)
+}
+
 // Folder names used for resources inside the EPUB
 const (
 	CSSFolderName   = "css"
@@ -120,6 +160,11 @@ img {
 )
 
 // Epub implements an EPUB file.
+//
+// The methods adding or changing the content of an Epub, EmbedImages and
+// Write can be called from multiple goroutines: each of them holds the lock
+// of the Epub while it runs, so it sees the changes of the calls that
+// completed before it and none of the ones running concurrently.
 type Epub struct {
 	sync.Mutex
 	*http.Client
@@ -140,6 +185,9 @@ type Epub struct {
 	lang string
 	// Description
 	desc string
+	// Edition and version, set with SetEdition and SetVersion
+	edition string
+	version string
 	// Page progression direction
 	ppd string
 	// The package file (package.opf)
@@ -148,13 +196,140 @@ type Epub struct {
 	title    string
 	// Table of contents
 	toc *toc
+	// Resources bigger than this size are not held in memory during Write
+	maxBufferSize int64
+	// Only set during Write
+	spool *spool
+	// Context of the running Write
+	ctx context.Context
+	// Content of the last build, nil unless SetIncrementalBuild is enabled
+	cache *buildCache
+	// Apple Books display options
+	ibooksOptions *IBooksDisplayOptions
+	// Report of the last Write
+	report *BuildReport
+	tracer Tracer
+	// Profile used to clean up section bodies
+	sanitizeProfile SanitizeProfile
+	// Transforms applied to section bodies
+	transforms []Transform
+	// Table of contents imported with ImportTOC, replacing the one generated
+	// from the sections
+	customTOC []*TOCEntry
+	// Landmarks in the order they were set
+	landmarks []landmark
+	// Options of the build provenance page, nil if there is none
+	provenance *ProvenanceOptions
+	// Requirements checked by SetCover, nil if there are none
+	coverRequirements *CoverRequirements
+	// Template of the body of the cover page, set with SetCoverTemplate
+	coverTemplate *htmltemplate.Template
+	// Whether the EPUB v2 TOC file is left out
+	noNcx bool
+	// Where the notes added with AddFootnote are placed
+	notePlacement NotePlacement
+	// Number of the last note added with AddFootnote
+	noteCount int
+	// CSS files linked from every section, set with SetGlobalCSS
+	globalCSS []string
+	// Fonts of the scripts detected in the sections, nil if disabled
+	fontFallbacks *FontFallbackOptions
+	// Template of the section XHTML files, nil for the default markup
+	sectionTemplate *template.Template
+	// Limits set with SetLimits, nil if there are none
+	limits *Limits
+	// Labels of the page breaks added without one
+	pageLabelPolicy PageLabelPolicy
+	// Number of page breaks labelled by pageLabelPolicy
+	pageNumber int
+	// Reads the duration of the audio files, nil if disabled
+	durationProber DurationProber
+	// Durations of the audio files read by the last builds, by filename
+	durations map[string]time.Duration
+	// Content of the resources added from a reader, by source
+	readers map[string]*readerSource
+	// File system of the local sources, nil for the OS one
+	fsys fs.FS
+	// Whether Write goes on when a resource can't be retrieved
+	tolerant bool
+	// Images replaced by a placeholder during the current build, by filename
+	placeholders map[string]bool
+	// Pattern of the name of the temporary file Write writes to, empty for
+	// the default one
+	tempFilePattern string
+	// Middlewares of the outbound HTTP requests, in order
+	middlewares []Middleware
+	// User-Agent header of the outbound HTTP requests, empty for the default
+	userAgent string
+	// Media types registered on the EPUB, by extension
+	extMediaTypes map[string]string
+	// Media types set for single resources, by folder and filename
+	resourceMediaTypes map[string]string
+	// Attributions of the resources, by folder and filename
+	attributions map[string]Attribution
+	// Algorithm obfuscating the fonts
+	fontObfuscation FontObfuscation
+	// Paths of the fonts obfuscated by the current build
+	obfuscatedFonts []string
+	// Baseline stylesheet linked from all the sections
+	cssReset CSSReset
+	// Whether META-INF/hashes.json is written
+	contentHashes bool
+	// Processing of the images when the EPUB is written
+	imageOptimization ImageOptimization
+	// Decoder of the images converted when the EPUB is written, nil if
+	// disabled
+	imageDecoder ImageDecoder
+	// Whether resources added twice are stored once
+	deduplicate bool
+	// SHA-256 hashes of the content of the sources, by source
+	sourceHashes map[string]string
+	// Audio and video files left on the web, by URL
+	remoteResources map[string]remoteResource
+	// How the fetches of remote resources are retried
+	retryPolicy RetryPolicy
+	// Number of resources fetched at the same time by Write
+	downloadConcurrency int
+	// Problems found while adding content, see Warnings
+	warnings       []Warning
+	warningHandler func(Warning)
+	// Called with the progress of Write, and the progress of the current one
+	progressHandler func(Progress)
+	progress        Progress
+	// Fetchers of the resources, by URL scheme
+	fetchers map[string]Fetcher
+	// Remote resources kept on the local disk between builds, nil if disabled
+	downloadCache *downloadCache
+	// Expected SHA-256 hashes of the resources, by folder and filename
+	checksums map[string]string
+	// How the files are compressed in the archive
+	compression Compression
+	// Whether the resources are copied from their sources into the archive,
+	// and the ones streamed during the current build, by path in the storage
+	streamingWrite bool
+	streamed       map[string]streamedMedia
+	// Whether Write checks the links of the sections first
+	linkCheck bool
+	// Whether section bodies are checked to be well-formed XHTML when added
+	strictXHTML bool
+	// Whether write only builds the package and the TOC, without fetching the
+	// resources, see Manifest
+	dryRun bool
 }
 
 type epubCover struct {
-	cssFilename   string
-	cssTempFile   string
+	cssFilename string
+	// Whether the CSS is the default one, added with the cover
+	defaultCSS    bool
 	imageFilename string
+	// SVG image shown by the cover page in place of imageFilename, set by
+	// SetSVGCover
+	svgFilename   string
 	xhtmlFilename string
+	// Position of the cover page in the reading order: after the section
+	// with the internal filename after if set, at index otherwise
+	index int
+	after string
 }
 
 type epubSection struct {
@@ -162,6 +337,47 @@ type epubSection struct {
 	xhtml      *xhtml
 	children   []*epubSection
 	properties string
+	// Auxiliary content outside of the reading order
+	nonLinear bool
+	// Whether the section is left out of the TOC
+	excludeFromTOC bool
+	// Entries pointing to fragments of the section, listed under its entry in
+	// the TOC
+	tocEntries []*TOCEntry
+	// Page breaks of the print equivalent of the section
+	pageBreaks []pageBreak
+	// Notes referenced from the section
+	notes []note
+	// Language and base direction of the section, set with SetSectionLang
+	lang string
+	dir  string
+	// Document written in place of the XHTML, set by AddRawSection
+	raw string
+	// Whether the section is left out of the spine
+	notInSpine bool
+	// Narration of the section, set with AddMediaOverlay
+	overlay *mediaOverlay
+	// Internal filename of the audio file played by the section, set by
+	// AddAudioTrack
+	track string
+	// Manifest properties set with SetSectionProperty, taking precedence
+	// over the ones detected from the body
+	propertyOverrides map[string]bool
+}
+
+// Section describes a section (chapter, etc) added to the EPUB.
+type Section struct {
+	Filename string // Internal filename of the section
+	Title    string // Title of the section
+	Body     string // Body of the section, as it will be written to the EPUB
+	Parent   string // Internal filename of the parent section, empty for top level sections
+}
+
+// Resource is a CSS file, font, image, video or audio added to an EPUB.
+type Resource struct {
+	Filename string // Internal filename of the resource
+	Source   string // Source of the resource, as passed to the Add* method
+	Path     string // Relative path to the resource from the sections, as returned by the Add* method
 }
 
 // NewEpub returns a new Epub.
@@ -170,7 +386,7 @@ func NewEpub(title string) (*Epub, error) {
 	e := &Epub{}
 	e.cover = &epubCover{
 		cssFilename:   "",
-		cssTempFile:   "",
+		defaultCSS:    false,
 		imageFilename: "",
 		xhtmlFilename: "",
 	}
@@ -208,13 +424,47 @@ func NewEpub(title string) (*Epub, error) {
 // than once, FilenameAlreadyUsedError will be returned. The internal filename is
 // optional; if no filename is provided, one will be generated.
 func (e *Epub) AddCSS(source string, internalFilename string) (string, error) {
+	return e.AddCSSContext(context.Background(), source, internalFilename)
+}
+
+// AddCSSContext is like AddCSS, stopping the retrieval of the source when ctx
+// is done.
+func (e *Epub) AddCSSContext(ctx context.Context, source string, internalFilename string) (string, error) {
 	e.Lock()
 	defer e.Unlock()
-	return e.addCSS(source, internalFilename)
+	return e.addResource(ctx, source, internalFilename, cssFileFormat, CSSFolderName, e.css)
 }
 
-func (e *Epub) addCSS(source string, internalFilename string) (string, error) {
-	return addMedia(e.Client, source, internalFilename, cssFileFormat, CSSFolderName, e.css)
+func (e *Epub) addCSS(ctx context.Context, source string, internalFilename string) (string, error) {
+	return e.addResource(ctx, source, internalFilename, cssFileFormat, CSSFolderName, e.css)
+}
+
+// AddSectionCSS links an already-added CSS file (as returned by AddCSS) to a
+// section, after the CSS files already linked to it, so that stylesheets can be
+// layered without concatenating them. Linking the same CSS file twice has no
+// effect.
+func (e *Epub) AddSectionCSS(internalFilename string, internalCSSPath string) error {
+	e.Lock()
+	defer e.Unlock()
+	section := findSection(e.sections, internalFilename)
+	if section == nil {
+		return &SectionDoesNotExistError{Filename: internalFilename}
+	}
+	if section.raw != "" {
+		return &RawSectionError{Filename: internalFilename}
+	}
+	section.xhtml.addCSS(internalCSSPath)
+	return nil
+}
+
+// SetGlobalCSS sets the already-added CSS files (as returned by AddCSS) linked
+// from every section but the cover, including the sections added afterwards.
+// They are linked before the CSS files of each section, so that these take
+// precedence. Calling it with no paths removes the global CSS files.
+func (e *Epub) SetGlobalCSS(internalCSSPaths ...string) {
+	e.Lock()
+	defer e.Unlock()
+	e.globalCSS = append([]string(nil), internalCSSPaths...)
 }
 
 // AddFont adds a font file to the EPUB and returns a relative path to the font
@@ -229,9 +479,15 @@ func (e *Epub) addCSS(source string, internalFilename string) (string, error) {
 // than once, FilenameAlreadyUsedError will be returned. The internal filename is
 // optional; if no filename is provided, one will be generated.
 func (e *Epub) AddFont(source string, internalFilename string) (string, error) {
+	return e.AddFontContext(context.Background(), source, internalFilename)
+}
+
+// AddFontContext is like AddFont, stopping the retrieval of the source when ctx
+// is done.
+func (e *Epub) AddFontContext(ctx context.Context, source string, internalFilename string) (string, error) {
 	e.Lock()
 	defer e.Unlock()
-	return addMedia(e.Client, source, internalFilename, fontFileFormat, FontFolderName, e.fonts)
+	return e.addResource(ctx, source, internalFilename, fontFileFormat, FontFolderName, e.fonts)
 }
 
 // AddImage adds an image to the EPUB and returns a relative path to the image
@@ -246,9 +502,15 @@ func (e *Epub) AddFont(source string, internalFilename string) (string, error) {
 // than once, FilenameAlreadyUsedError will be returned. The internal filename is
 // optional; if no filename is provided, one will be generated.
 func (e *Epub) AddImage(source string, imageFilename string) (string, error) {
+	return e.AddImageContext(context.Background(), source, imageFilename)
+}
+
+// AddImageContext is like AddImage, stopping the retrieval of the source when ctx
+// is done.
+func (e *Epub) AddImageContext(ctx context.Context, source string, imageFilename string) (string, error) {
 	e.Lock()
 	defer e.Unlock()
-	return addMedia(e.Client, source, imageFilename, imageFileFormat, ImageFolderName, e.images)
+	return e.addResource(ctx, source, imageFilename, imageFileFormat, ImageFolderName, e.images)
 }
 
 // AddVideo adds an video to the EPUB and returns a relative path to the video
@@ -263,9 +525,15 @@ func (e *Epub) AddImage(source string, imageFilename string) (string, error) {
 // than once, FilenameAlreadyUsedError will be returned. The internal filename is
 // optional; if no filename is provided, one will be generated.
 func (e *Epub) AddVideo(source string, videoFilename string) (string, error) {
+	return e.AddVideoContext(context.Background(), source, videoFilename)
+}
+
+// AddVideoContext is like AddVideo, stopping the retrieval of the source when ctx
+// is done.
+func (e *Epub) AddVideoContext(ctx context.Context, source string, videoFilename string) (string, error) {
 	e.Lock()
 	defer e.Unlock()
-	return addMedia(e.Client, source, videoFilename, videoFileFormat, VideoFolderName, e.videos)
+	return e.addResource(ctx, source, videoFilename, videoFileFormat, VideoFolderName, e.videos)
 }
 
 // AddAudio adds an audio to the EPUB and returns a relative path to the audio
@@ -280,9 +548,131 @@ func (e *Epub) AddVideo(source string, videoFilename string) (string, error) {
 // than once, FilenameAlreadyUsedError will be returned. The internal filename is
 // optional; if no filename is provided, one will be generated.
 func (e *Epub) AddAudio(source string, audioFilename string) (string, error) {
+	return e.AddAudioContext(context.Background(), source, audioFilename)
+}
+
+// AddAudioContext is like AddAudio, stopping the retrieval of the source when ctx
+// is done.
+func (e *Epub) AddAudioContext(ctx context.Context, source string, audioFilename string) (string, error) {
+	e.Lock()
+	defer e.Unlock()
+	return e.addResource(ctx, source, audioFilename, audioFileFormat, AudioFolderName, e.audios)
+}
+
+// RemoveCSS removes a CSS file added with AddCSS, given its internal filename
+// or the path AddCSS returned, so that builders adding speculative resources
+// can prune the unused ones before Write. The sections linking it are left
+// unchanged. The CSS file of the cover can't be removed, see RemoveCover.
+func (e *Epub) RemoveCSS(internalFilename string) error {
+	return e.removeResource(internalFilename, CSSFolderName, e.css)
+}
+
+// RemoveFont removes a font file added with AddFont, as RemoveCSS does.
+func (e *Epub) RemoveFont(internalFilename string) error {
+	return e.removeResource(internalFilename, FontFolderName, e.fonts)
+}
+
+// RemoveImage removes an image added with AddImage, as RemoveCSS does. The
+// cover image can't be removed, see RemoveCover.
+func (e *Epub) RemoveImage(imageFilename string) error {
+	return e.removeResource(imageFilename, ImageFolderName, e.images)
+}
+
+// RemoveVideo removes a video added with AddVideo, as RemoveCSS does.
+func (e *Epub) RemoveVideo(videoFilename string) error {
+	return e.removeResource(videoFilename, VideoFolderName, e.videos)
+}
+
+// RemoveAudio removes an audio added with AddAudio, as RemoveCSS does.
+func (e *Epub) RemoveAudio(audioFilename string) error {
+	return e.removeResource(audioFilename, AudioFolderName, e.audios)
+}
+
+// CSS returns the CSS files added to the EPUB, sorted by internal filename, so
+// that callers can inspect what will end up in the manifest and avoid adding
+// the same source twice. The sources of the files added from bytes or from a
+// reader aren't meaningful.
+func (e *Epub) CSS() []Resource {
+	return e.resources(CSSFolderName, e.css)
+}
+
+// Fonts returns the fonts added to the EPUB, as CSS does.
+func (e *Epub) Fonts() []Resource {
+	return e.resources(FontFolderName, e.fonts)
+}
+
+// Images returns the images added to the EPUB, as CSS does.
+func (e *Epub) Images() []Resource {
+	return e.resources(ImageFolderName, e.images)
+}
+
+// Videos returns the videos added to the EPUB, as CSS does.
+func (e *Epub) Videos() []Resource {
+	return e.resources(VideoFolderName, e.videos)
+}
+
+// Audios returns the audios added to the EPUB, as CSS does.
+func (e *Epub) Audios() []Resource {
+	return e.resources(AudioFolderName, e.audios)
+}
+
+// resources returns the resources of mediaMap sorted by internal filename
+func (e *Epub) resources(mediaFolderName string, mediaMap map[string]string) []Resource {
 	e.Lock()
 	defer e.Unlock()
-	return addMedia(e.Client, source, audioFilename, audioFileFormat, AudioFolderName, e.audios)
+	resources := make([]Resource, 0, len(mediaMap))
+	for filename, source := range mediaMap {
+		resources = append(resources, Resource{
+			Filename: filename,
+			Source:   source,
+			Path:     path.Join("..", mediaFolderName, filename),
+		})
+	}
+	sort.Slice(resources, func(i, j int) bool {
+		return resources[i].Filename < resources[j].Filename
+	})
+	return resources
+}
+
+// removeResource removes the resource internalFilename from mediaMap
+func (e *Epub) removeResource(internalFilename string, mediaFolderName string, mediaMap map[string]string) error {
+	e.Lock()
+	defer e.Unlock()
+	internalFilename = strings.TrimPrefix(internalFilename, "../"+mediaFolderName+"/")
+	if _, ok := mediaMap[internalFilename]; !ok {
+		return &ResourceDoesNotExistError{Filename: internalFilename}
+	}
+	if (mediaFolderName == ImageFolderName && (internalFilename == e.cover.imageFilename || internalFilename == e.cover.svgFilename)) ||
+		(mediaFolderName == CSSFolderName && internalFilename == e.cover.cssFilename) {
+		return fmt.Errorf("can't remove %s: used by the cover", internalFilename)
+	}
+	e.forgetResource(mediaFolderName, internalFilename)
+	return nil
+}
+
+// forgetResource removes the resource internalFilename from the folder
+// mediaFolderName, along with its reader, media type, attribution, expected
+// SHA-256 and duration, so that a resource added later with the same filename
+// doesn't inherit them
+func (e *Epub) forgetResource(mediaFolderName string, internalFilename string) {
+	mediaMap := e.mediaMap(mediaFolderName)
+	delete(e.readers, mediaMap[internalFilename])
+	delete(mediaMap, internalFilename)
+	name := path.Join(mediaFolderName, internalFilename)
+	delete(e.resourceMediaTypes, name)
+	delete(e.attributions, name)
+	delete(e.checksums, name)
+	if mediaFolderName == AudioFolderName {
+		delete(e.durations, internalFilename)
+	}
+}
+
+// forgetImages removes the images filenames, added for a section that couldn't
+// be added
+func (e *Epub) forgetImages(filenames []string) {
+	for _, filename := range filenames {
+		e.forgetResource(ImageFolderName, filename)
+	}
 }
 
 // AddSection adds a new section (chapter, etc) to the EPUB and returns a
@@ -290,7 +680,9 @@ func (e *Epub) AddAudio(source string, audioFilename string) (string, error) {
 // links).
 //
 // The body must be valid XHTML that will go between the <body> tags of the
-// section XHTML file. The content will not be validated.
+// section XHTML file. The content will not be validated unless strict mode is
+// enabled with SetStrictXHTML, but it can be fixed with
+// SetSanitizeProfile(SanitizeTidy).
 //
 // The title will be used for the table of contents. The section will be shown
 // in the table of contents in the same order it was added to the EPUB. The
@@ -303,7 +695,8 @@ func (e *Epub) AddAudio(source string, audioFilename string) (string, error) {
 // optional; if no filename is provided, one will be generated.
 //
 // The internal path to an already-added CSS file (as returned by AddCSS) to be
-// used for the section is optional.
+// used for the section is optional. More CSS files can be linked with
+// AddSectionCSS and SetGlobalCSS.
 func (e *Epub) AddSection(body string, sectionTitle string, internalFilename string, internalCSSPath string) (string, error) {
 	e.Lock()
 	defer e.Unlock()
@@ -317,7 +710,9 @@ func (e *Epub) AddSection(body string, sectionTitle string, internalFilename str
 // The parent filename must be a valid filename from another section already added.
 //
 // The body must be valid XHTML that will go between the <body> tags of the
-// section XHTML file. The content will not be validated.
+// section XHTML file. The content will not be validated unless strict mode is
+// enabled with SetStrictXHTML, but it can be fixed with
+// SetSanitizeProfile(SanitizeTidy).
 //
 // The title will be used for the table of contents. The section will be shown
 // as a nested entry of the parent section in the table of contents. The
@@ -337,23 +732,214 @@ func (e *Epub) AddSubSection(parentFilename string, body string, sectionTitle st
 	return e.addSection(parentFilename, body, sectionTitle, internalFilename, internalCSSPath)
 }
 
+// AddSectionFromReader adds a section like AddSection, reading its body from
+// r, so bodies generated by templates or decompressed on the fly don't have to
+// be built as a string first.
+//
+// The body is checked to be well-formed XML while it is read: an error
+// holding the line of the first mistake is returned if it isn't. HTML named
+// entities such as &nbsp;, which XHTML doesn't define, are mistakes; numeric
+// character references such as &#160; must be used instead.
+func (e *Epub) AddSectionFromReader(r io.Reader, sectionTitle string, internalFilename string, internalCSSPath string) (string, error) {
+	body, err := readBody(r)
+	if err != nil {
+		return "", fmt.Errorf("can't add section: %w", err)
+	}
+	e.Lock()
+	defer e.Unlock()
+	return e.addSection("", body, sectionTitle, internalFilename, internalCSSPath)
+}
+
+// AddSubSectionFromReader adds a nested section like AddSubSection, reading its
+// body from r as AddSectionFromReader does.
+func (e *Epub) AddSubSectionFromReader(parentFilename string, r io.Reader, sectionTitle string, internalFilename string, internalCSSPath string) (string, error) {
+	body, err := readBody(r)
+	if err != nil {
+		return "", fmt.Errorf("can't add section: %w", err)
+	}
+	e.Lock()
+	defer e.Unlock()
+	return e.addSection(parentFilename, body, sectionTitle, internalFilename, internalCSSPath)
+}
+
+// AddRawSection adds a complete XHTML document, written to the EPUB as is, as
+// a top level section, for carefully crafted documents that wrapping a body in
+// the default markup would mangle. It returns a relative path to the section
+// like AddSection.
+//
+// The document must be well-formed XML with an <html> root element; its
+// <title> is used in the table of contents. The transforms, stylesheets,
+// notes, section template and translations don't apply to raw sections, and
+// the methods changing the markup of a section, like AddPageBreak, return a
+// RawSectionError.
+//
+// If addToSpine is false, the section is only listed in the manifest, out of
+// the reading order, e.g. for a document shown in a popup; addToTOC must then
+// be false too. If addToTOC is false, it is left out of the table of contents.
+func (e *Epub) AddRawSection(document string, internalFilename string, addToSpine bool, addToTOC bool) (string, error) {
+	if !addToSpine && addToTOC {
+		return "", fmt.Errorf("can't add raw section: a section out of the spine can't be in the table of contents")
+	}
+	title, err := rawSectionTitle(document)
+	if err != nil {
+		return "", fmt.Errorf("can't add raw section: %w", err)
+	}
+	e.Lock()
+	defer e.Unlock()
+	// The section is added empty, the document replacing it when written
+	filename, err := e.addSection("", "", title, internalFilename, "")
+	if err != nil {
+		return filename, err
+	}
+	s := findSection(e.sections, filename)
+	s.raw = document
+	s.properties = propertiesFromBody(document)
+	s.notInSpine = !addToSpine
+	s.excludeFromTOC = !addToTOC
+	return filename, nil
+}
+
+// rawSectionTitle checks that document is a well-formed XHTML document and
+// returns its title
+func rawSectionTitle(document string) (string, error) {
+	d := xml.NewDecoder(strings.NewReader(document))
+	var title strings.Builder
+	root, inTitle := "", false
+	for {
+		t, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", fmt.Errorf("invalid document: %w", err)
+		}
+		switch t := t.(type) {
+		case xml.StartElement:
+			if root == "" {
+				root = t.Name.Local
+			}
+			inTitle = t.Name.Local == "title" && title.Len() == 0
+		case xml.EndElement:
+			inTitle = false
+		case xml.CharData:
+			if inTitle {
+				title.Write(t)
+			}
+		}
+	}
+	if root != "html" {
+		return "", fmt.Errorf("invalid document: the root element is <%s>, not <html>", root)
+	}
+	return strings.Join(strings.Fields(title.String()), " "), nil
+}
+
+// InsertSectionAt adds a new top level section like AddSection, but at the
+// given index among the top level sections instead of after the last one. An
+// index equal to the number of top level sections appends the section. The
+// cover page isn't counted, its position being set with SetCoverPosition.
+func (e *Epub) InsertSectionAt(index int, body string, sectionTitle string, internalFilename string, internalCSSPath string) (string, error) {
+	e.Lock()
+	defer e.Unlock()
+	if n := e.countSections(e.sections); index < 0 || index > n {
+		return "", fmt.Errorf("can't insert section at index %d: out of range [0, %d]", index, n)
+	}
+	filename, err := e.addSection("", body, sectionTitle, internalFilename, internalCSSPath)
+	if err != nil {
+		return filename, err
+	}
+	return filename, e.moveSection(filename, index)
+}
+
+// MoveSection moves an already-added section to the given index among its
+// sibling sections: top level sections for a section, or the subsections of
+// the same parent for a subsection. Its subsections are moved along with it.
+// The cover page isn't counted, and can't be moved: its position is set with
+// SetCoverPosition.
+//
+// If no section with the internal filename exists, SectionDoesNotExistError
+// will be returned.
+func (e *Epub) MoveSection(internalFilename string, newIndex int) error {
+	e.Lock()
+	defer e.Unlock()
+	return e.moveSection(internalFilename, newIndex)
+}
+
+func (e *Epub) moveSection(internalFilename string, newIndex int) error {
+	siblings := findSiblings(&e.sections, internalFilename)
+	if siblings == nil {
+		return &SectionDoesNotExistError{Filename: internalFilename}
+	}
+	if internalFilename == e.cover.xhtmlFilename {
+		return fmt.Errorf("can't move the cover page %s, see SetCoverPosition", internalFilename)
+	}
+	if n := e.countSections(*siblings); newIndex < 0 || newIndex >= n {
+		return fmt.Errorf("can't move section %s to index %d: out of range [0, %d]", internalFilename, newIndex, n-1)
+	}
+	var s *epubSection
+	for i, sibling := range *siblings {
+		if sibling.filename == internalFilename {
+			s = sibling
+			*siblings = append((*siblings)[:i], (*siblings)[i+1:]...)
+			break
+		}
+	}
+	// Position in siblings of the section at newIndex, the cover page skipped
+	pos, n := len(*siblings), 0
+	for i, sibling := range *siblings {
+		if sibling.filename == e.cover.xhtmlFilename {
+			continue
+		}
+		if n == newIndex {
+			pos = i
+			break
+		}
+		n++
+	}
+	*siblings = append((*siblings)[:pos], append([]*epubSection{s}, (*siblings)[pos:]...)...)
+	return nil
+}
+
+// countSections returns the number of sections, the cover page excluded
+func (e *Epub) countSections(sections []*epubSection) int {
+	n := 0
+	for _, s := range sections {
+		if s.filename != e.cover.xhtmlFilename {
+			n++
+		}
+	}
+	return n
+}
+
 func (e *Epub) addSection(parentFilename string, body string, sectionTitle string, internalFilename string, internalCSSPath string) (string, error) {
+	filename, err := e.insertSection(parentFilename, body, sectionTitle, internalFilename, internalCSSPath)
+	if err == nil {
+		e.checkTitle(filename, sectionTitle)
+	}
+	return filename, err
+}
+
+// insertSection adds a section like addSection, without checking its title,
+// e.g. for the cover page which is left out of the table of contents
+func (e *Epub) insertSection(parentFilename string, body string, sectionTitle string, internalFilename string, internalCSSPath string) (string, error) {
 
-	// get list of all xhtml filename inside of epub
-	filenamelist := getFilenames(e.sections)
-	parentIndex := filenamelist[parentFilename] - 1
+	// get all the sections of the epub by filename
+	index := indexSections(e.sections)
+	parent := index[parentFilename]
 
-	if parentFilename != "" && parentIndex == -1 {
+	if parentFilename != "" && parent == nil {
 		return "", &ParentDoesNotExistError{Filename: parentFilename}
 	}
+	if err := e.checkSectionLimits(parentFilename); err != nil {
+		return "", err
+	}
 
 	// Generate a filename if one isn't provided
 	if internalFilename == "" {
-		index := 1
+		i := 1
 		for internalFilename == "" {
-			internalFilename = fmt.Sprintf(sectionFileFormat, index)
-			if keyExists(filenamelist, internalFilename) {
-				internalFilename, index = "", index+1
+			internalFilename = fmt.Sprintf(sectionFileFormat, i)
+			if _, ok := index[internalFilename]; ok {
+				internalFilename, i = "", i+1
 			}
 		}
 	} else {
@@ -363,11 +949,21 @@ func (e *Epub) addSection(parentFilename string, body string, sectionTitle strin
 		if filepath.Ext(internalFilename) != ".xhtml" {
 			internalFilename += ".xhtml"
 		}
-		if keyExists(filenamelist, internalFilename) {
+		if _, ok := index[internalFilename]; ok {
 			return "", &FilenameAlreadyUsedError{Filename: internalFilename}
 		}
 	}
 
+	if e.strictXHTML {
+		if err := checkXHTML(internalFilename, body); err != nil {
+			return internalFilename, err
+		}
+	}
+	body, err := e.processBody(body)
+	if err != nil {
+		return internalFilename, fmt.Errorf("can't add section, unable to process body: %w", err)
+	}
+
 	x, err := newXhtml(body)
 	if err != nil {
 		return internalFilename, fmt.Errorf("can't add section we cant create xhtml: %w", err)
@@ -386,21 +982,255 @@ func (e *Epub) addSection(parentFilename string, body string, sectionTitle strin
 		properties: propertiesFromBody(body),
 	}
 
-	// section have parentIndex -1 and subsection have parrentindex != -1
-	if parentIndex == -1 {
-		// if it is section append to the root
+	// section have no parent and subsection are appended to their parent
+	if parent == nil {
 		e.sections = append(e.sections, s)
 	} else {
-		// find parent section and append subsection to that
-		err := sectionAppender(e.sections, parentFilename, s)
-		if err != nil {
-			return "", err
-		}
+		parent.children = append(parent.children, s)
 	}
 
 	return internalFilename, nil
 }
 
+// Sections returns all the sections of the EPUB in reading order, with
+// subsections following their parent section.
+func (e *Epub) Sections() []Section {
+	e.Lock()
+	defer e.Unlock()
+	var sections []Section
+	add := func(s *epubSection, parent string) {
+		sections = append(sections, Section{
+			Filename: s.filename,
+			Title:    s.xhtml.Title(),
+			Body:     s.xhtml.body(),
+			Parent:   parent,
+		})
+	}
+	// The cover is always first in the reading order
+	if cover := findSection(e.sections, e.cover.xhtmlFilename); cover != nil {
+		add(cover, "")
+	}
+	var f func(sections []*epubSection, parent string)
+	f = func(sections []*epubSection, parent string) {
+		for _, s := range sections {
+			if s.filename != e.cover.xhtmlFilename {
+				add(s, parent)
+			}
+			f(s.children, s.filename)
+		}
+	}
+	f(e.sections, "")
+	return sections
+}
+
+// UpdateSection replaces the body of an already-added section, keeping its
+// title, position and CSS. This allows sections to be rewritten once all the
+// sections of the EPUB are known, e.g. to add links to sections added later.
+//
+// The body is processed like in AddSection; a section added with AddRawSection
+// becomes a regular one. If no section with the internal filename exists,
+// SectionDoesNotExistError will be returned.
+func (e *Epub) UpdateSection(internalFilename string, body string) error {
+	e.Lock()
+	defer e.Unlock()
+	s := findSection(e.sections, internalFilename)
+	if s == nil {
+		return &SectionDoesNotExistError{Filename: internalFilename}
+	}
+	if e.strictXHTML {
+		if err := checkXHTML(internalFilename, body); err != nil {
+			return err
+		}
+	}
+	body, err := e.processBody(body)
+	if err != nil {
+		return fmt.Errorf("can't update section, unable to process body: %w", err)
+	}
+	s.xhtml.setBody(body)
+	s.properties = propertiesFromBody(body)
+	s.raw = ""
+	return nil
+}
+
+// SetSectionLinear sets whether an already-added section is part of the
+// default reading order. Sections are linear by default; a non-linear section
+// is written with linear="no" in the spine, for auxiliary content like pop-up
+// notes, answer keys or image descriptions that is reached through links
+// instead of by turning pages.
+func (e *Epub) SetSectionLinear(internalFilename string, linear bool) error {
+	e.Lock()
+	defer e.Unlock()
+	s := findSection(e.sections, internalFilename)
+	if s == nil {
+		return &SectionDoesNotExistError{Filename: internalFilename}
+	}
+	s.nonLinear = !linear
+	return nil
+}
+
+// SetSectionExcludeFromTOC sets whether an already-added section is left out
+// of the table of contents. The section keeps its title and its place in the
+// reading order. The subsections of an excluded section are listed under its
+// nearest ancestor shown in the table of contents.
+func (e *Epub) SetSectionExcludeFromTOC(internalFilename string, exclude bool) error {
+	e.Lock()
+	defer e.Unlock()
+	s := findSection(e.sections, internalFilename)
+	if s == nil {
+		return &SectionDoesNotExistError{Filename: internalFilename}
+	}
+	s.excludeFromTOC = exclude
+	return nil
+}
+
+// SetSectionLang sets the language and the base direction of an
+// already-added section, e.g. "ar" and "rtl" for an Arabic poem in an English
+// book. They are written as the lang, xml:lang and dir attributes of the root
+// element of the section. An empty language falls back to the language of the
+// EPUB; an empty direction leaves it to the reading system. The direction must
+// be "ltr", "rtl", "auto" or empty.
+func (e *Epub) SetSectionLang(internalFilename string, lang string, dir string) error {
+	e.Lock()
+	defer e.Unlock()
+	s := findSection(e.sections, internalFilename)
+	if s == nil {
+		return &SectionDoesNotExistError{Filename: internalFilename}
+	}
+	if s.raw != "" {
+		return &RawSectionError{Filename: internalFilename}
+	}
+	switch dir {
+	case "", "ltr", "rtl", "auto":
+	default:
+		return fmt.Errorf("invalid direction %q", dir)
+	}
+	s.lang = lang
+	s.dir = dir
+	return nil
+}
+
+// Manifest properties of XHTML content documents
+// Spec: https://www.w3.org/TR/epub-33/#app-item-properties-vocab
+var sectionManifestProperties = map[string]bool{
+	"mathml":           true,
+	"remote-resources": true,
+	"scripted":         true,
+	"svg":              true,
+	"switch":           true,
+}
+
+// SetSectionProperty overrides whether the manifest item of an already-added
+// section has a property, e.g. "scripted" for a section whose scripts are
+// added with SetSectionHead, or no "svg" for a section whose only SVG element
+// is commented out. The properties are otherwise detected from the body of the
+// section; the overrides are kept when the body is replaced with
+// UpdateSection.
+//
+// The property must be one of "mathml", "remote-resources", "scripted", "svg"
+// and "switch". ClearSectionProperty restores the detection.
+func (e *Epub) SetSectionProperty(internalFilename string, property string, enabled bool) error {
+	e.Lock()
+	defer e.Unlock()
+	s := findSection(e.sections, internalFilename)
+	if s == nil {
+		return &SectionDoesNotExistError{Filename: internalFilename}
+	}
+	if !sectionManifestProperties[property] {
+		return fmt.Errorf("invalid section property %q", property)
+	}
+	if s.propertyOverrides == nil {
+		s.propertyOverrides = make(map[string]bool)
+	}
+	s.propertyOverrides[property] = enabled
+	return nil
+}
+
+// ClearSectionProperty removes the override set with SetSectionProperty, so
+// that whether the manifest item of the section has the property is detected
+// from its body again.
+func (e *Epub) ClearSectionProperty(internalFilename string, property string) error {
+	e.Lock()
+	defer e.Unlock()
+	s := findSection(e.sections, internalFilename)
+	if s == nil {
+		return &SectionDoesNotExistError{Filename: internalFilename}
+	}
+	delete(s.propertyOverrides, property)
+	return nil
+}
+
+// sectionProperties returns the manifest properties of a section, the ones
+// detected from its body with the overrides applied, in order
+func (e *Epub) sectionProperties(s *epubSection) string {
+	props := map[string]bool{}
+	for _, p := range strings.Fields(s.properties) {
+		props[p] = true
+	}
+	if e.referencesRemoteResources(s) {
+		props[remoteResourcesProperty] = true
+	}
+	for p, enabled := range s.propertyOverrides {
+		props[p] = enabled
+	}
+	var ret []string
+	for p, enabled := range props {
+		if enabled {
+			ret = append(ret, p)
+		}
+	}
+	sort.Strings(ret)
+	return strings.Join(ret, " ")
+}
+
+// SetSectionHead sets markup inserted in the <head> element of an
+// already-added section, after the title and the stylesheets, e.g. a viewport
+// meta tag for fixed-layout content or <link rel="next"> elements. The markup
+// must be well-formed XHTML; an empty string removes it.
+func (e *Epub) SetSectionHead(internalFilename string, extraHead string) error {
+	e.Lock()
+	defer e.Unlock()
+	s := findSection(e.sections, internalFilename)
+	if s == nil {
+		return &SectionDoesNotExistError{Filename: internalFilename}
+	}
+	if s.raw != "" {
+		return &RawSectionError{Filename: internalFilename}
+	}
+	if _, err := readBody(strings.NewReader(extraHead)); err != nil {
+		return fmt.Errorf("can't set section head: %w", err)
+	}
+	s.xhtml.setHead(extraHead)
+	return nil
+}
+
+// AddTOCEntry adds an entry pointing to a fragment of an already-added section
+// to the table of contents, e.g. to navigate within a long chapter. The entry
+// is listed under the entry of the section, after the entries added before it
+// and before the subsections. fragment is the id of an element of the section,
+// with or without the leading "#"; HeadingIDs can be used to give ids to the
+// headings of a section.
+func (e *Epub) AddTOCEntry(internalFilename string, fragment string, title string) error {
+	e.Lock()
+	defer e.Unlock()
+	s := findSection(e.sections, internalFilename)
+	if s == nil {
+		return &SectionDoesNotExistError{Filename: internalFilename}
+	}
+	fragment = strings.TrimPrefix(fragment, "#")
+	if fragment == "" {
+		return fmt.Errorf("can't add TOC entry to %s: empty fragment", internalFilename)
+	}
+	if err := e.checkTOCDepth(sectionDepth(e.sections, internalFilename) + 1); err != nil {
+		return err
+	}
+	s.tocEntries = append(s.tocEntries, &TOCEntry{
+		Title:    title,
+		Filename: internalFilename,
+		Fragment: fragment,
+	})
+	return nil
+}
+
 // supports mathml, svg, scripted
 // does not support remote-sources, switch (deprecated)
 func propertiesFromBody(body string) string {
@@ -455,6 +1285,16 @@ func (e *Epub) Description() string {
 	return e.desc
 }
 
+// Edition returns the edition of the EPUB.
+func (e *Epub) Edition() string {
+	return e.edition
+}
+
+// Version returns the version of the EPUB.
+func (e *Epub) Version() string {
+	return e.version
+}
+
 // Ppd returns the page progression direction of the EPUB.
 func (e *Epub) Ppd() string {
 	return e.ppd
@@ -469,7 +1309,8 @@ func (e *Epub) SetAuthor(author string) {
 }
 
 // SetCover sets the cover page for the EPUB using the provided image source and
-// optional CSS.
+// optional CSS. The page shows the image alone, unless a template is set with
+// SetCoverTemplate.
 //
 // The internal path to an already-added image file (as returned by AddImage) is
 // required.
@@ -477,74 +1318,84 @@ func (e *Epub) SetAuthor(author string) {
 // The internal path to an already-added CSS file (as returned by AddCSS) to be
 // used for the cover is optional. If the CSS path isn't provided, default CSS
 // will be used.
+//
+// The image is checked against the requirements set with
+// SetCoverRequirements, if any. If a cover is already set, it is replaced as
+// with ReplaceCover.
 func (e *Epub) SetCover(internalImagePath string, internalCSSPath string) error {
+	return e.SetCoverContext(context.Background(), internalImagePath, internalCSSPath)
+}
+
+// SetCoverContext is like SetCover, stopping the retrieval of the image
+// checked against the requirements when ctx is done.
+func (e *Epub) SetCoverContext(ctx context.Context, internalImagePath string, internalCSSPath string) error {
 	e.Lock()
 	defer e.Unlock()
-	// If a cover already exists
+	if err := e.validateCover(ctx, internalImagePath); err != nil {
+		return err
+	}
+	coverBody, err := e.coverBody(internalImagePath)
+	if err != nil {
+		return err
+	}
+	return e.setCover(ctx, internalImagePath, "", internalCSSPath, coverBody)
+}
+
+// setCover sets the cover page showing coverBody, replacing the current one if
+// any. internalSVGPath is the SVG image shown by the cover page of
+// SetSVGCover, empty otherwise.
+func (e *Epub) setCover(ctx context.Context, internalImagePath string, internalSVGPath string, internalCSSPath string, coverBody string) error {
+	oldSVGFilename := e.cover.svgFilename
+	defer func() {
+		if oldSVGFilename != "" && oldSVGFilename != e.cover.svgFilename {
+			e.removeCoverImage(oldSVGFilename)
+		}
+	}()
 	if e.cover.xhtmlFilename != "" {
-		// Remove the xhtml file
-		for i, section := range e.sections {
-			if section.filename == e.cover.xhtmlFilename {
-				e.sections = append(e.sections[:i], e.sections[i+1:]...)
-				break
-			}
+		if err := e.replaceCover(ctx, internalImagePath, internalCSSPath, coverBody); err != nil {
+			return err
 		}
-
-		// Remove the image
-		delete(e.images, e.cover.imageFilename)
-
-		// Remove the CSS
-		delete(e.css, e.cover.cssFilename)
-
-		if e.cover.cssTempFile != "" {
-			os.Remove(e.cover.cssTempFile)
+		e.cover.svgFilename = path.Base(internalSVGPath)
+		if internalSVGPath == "" {
+			e.cover.svgFilename = ""
 		}
+		return nil
 	}
 
-	e.cover.imageFilename = filepath.Base(internalImagePath)
-	e.pkg.setCover(e.cover.imageFilename)
-
 	// Use default cover stylesheet if one isn't provided
+	var err error
 	if internalCSSPath == "" {
-		// Encode the default CSS
-		e.cover.cssTempFile = dataurl.EncodeBytes([]byte(defaultCoverCSSContent))
-		var err error
-		internalCSSPath, err = e.addCSS(e.cover.cssTempFile, defaultCoverCSSFilename)
-		// If that doesn't work, generate a filename
-		if _, ok := err.(*FilenameAlreadyUsedError); ok {
-			coverCSSFilename := fmt.Sprintf(
-				cssFileFormat,
-				len(e.css)+1,
-				".css",
-			)
-
-			internalCSSPath, err = e.addCSS(e.cover.cssTempFile, coverCSSFilename)
-			if _, ok := err.(*FilenameAlreadyUsedError); ok {
-				// This shouldn't cause an error
-				return fmt.Errorf("Error adding default cover CSS file: %w", err)
-			}
-		}
+		internalCSSPath, err = e.addDefaultCoverCSS(ctx)
 		if err != nil {
-			if _, ok := err.(*FilenameAlreadyUsedError); !ok {
-				return err
-			}
+			return err
 		}
 	}
-	e.cover.cssFilename = filepath.Base(internalCSSPath)
 
-	coverBody := fmt.Sprintf(defaultCoverBody, internalImagePath)
 	// Title won't be used since the cover won't be added to the TOC
 	// First try to use the default cover filename
-	coverPath, err := e.addSection("", coverBody, "", defaultCoverXhtmlFilename, internalCSSPath)
+	coverPath, err := e.insertSection("", coverBody, "", defaultCoverXhtmlFilename, internalCSSPath)
 	// If that doesn't work, generate a filename
 	if _, ok := err.(*FilenameAlreadyUsedError); ok {
-		coverPath, err = e.addSection("", coverBody, "", "", internalCSSPath)
+		coverPath, err = e.insertSection("", coverBody, "", "", internalCSSPath)
 		if _, ok := err.(*FilenameAlreadyUsedError); ok {
 			// This shouldn't cause an error since we're not specifying a filename
 			return fmt.Errorf("Error adding default cover XHTML file: %w", err)
 		}
 	}
+	if err != nil {
+		if e.cover.defaultCSS {
+			e.forgetResource(CSSFolderName, filepath.Base(internalCSSPath))
+			e.cover.defaultCSS = false
+		}
+		return err
+	}
+	e.cover.imageFilename = filepath.Base(internalImagePath)
+	e.pkg.setCover(e.cover.imageFilename)
+	e.cover.cssFilename = filepath.Base(internalCSSPath)
 	e.cover.xhtmlFilename = filepath.Base(coverPath)
+	if internalSVGPath != "" {
+		e.cover.svgFilename = path.Base(internalSVGPath)
+	}
 	return nil
 }
 
@@ -575,7 +1426,115 @@ func (e *Epub) SetDescription(desc string) {
 	e.pkg.setDescription(desc)
 }
 
-// SetPpd sets the page progression direction of the EPUB.
+// SetEdition sets the edition of the EPUB, e.g. "2nd edition", so that
+// catalogs can distinguish revised editions of a publication sharing the same
+// title. It is written as schema:bookEdition metadata. An empty string, the
+// default, removes it.
+func (e *Epub) SetEdition(edition string) {
+	e.Lock()
+	defer e.Unlock()
+	e.edition = edition
+	e.pkg.setProperty(pkgEditionProperty, edition)
+}
+
+// SetVersion sets the version of the EPUB, e.g. "1.2.0", for publications
+// updated in place, such as documentation. It is written as schema:version
+// metadata. An empty string, the default, removes it.
+func (e *Epub) SetVersion(version string) {
+	e.Lock()
+	defer e.Unlock()
+	e.version = version
+	e.pkg.setProperty(pkgVersionProperty, version)
+}
+
+// SetMaxBufferSize sets the maximum size in bytes a single resource can take in
+// memory while the EPUB is written. Resources bigger than size are spooled to
+// temporary files on the local disk during Write instead. This only has an
+// effect when the memory filesystem is in use (see Use); with the local
+// filesystem, resources are always written to disk.
+//
+// A size of zero, the default, disables the limit.
+func (e *Epub) SetMaxBufferSize(size int64) {
+	e.Lock()
+	defer e.Unlock()
+	e.maxBufferSize = size
+}
+
+// SetFS sets the file system the sources that are local paths are read from,
+// e.g. an embed.FS, so that assets embedded in the binary can be added
+// without extracting them to disk first:
+//
+//	//go:embed assets
+//	var assets embed.FS
+//
+//	e.SetFS(assets)
+//	e.AddImage("assets/cover.png", "")
+//
+// The paths must then be slash-separated and unrooted, as fs.ValidPath
+// requires. URLs and data URLs are retrieved as before. It applies to the
+// following calls to the Add* methods and to Write, so it must not change in
+// between. A nil fsys restores the OS file system, the default.
+func (e *Epub) SetFS(fsys fs.FS) {
+	e.Lock()
+	defer e.Unlock()
+	e.fsys = fsys
+}
+
+// SetNcx sets whether the EPUB v2 table of contents (toc.ncx) is written. It
+// is written by default, for reading systems that only support EPUB v2; it can
+// be disabled for pure EPUB 3 output, where it is deprecated.
+func (e *Epub) SetNcx(enabled bool) {
+	e.Lock()
+	defer e.Unlock()
+	e.noNcx = !enabled
+	e.pkg.setNcx(enabled)
+}
+
+// SetNavTemplate sets the body of the navigation document (nav.xhtml), so the
+// table of contents page can be styled. The list of TOC entries is appended to
+// the <nav epub:type="toc"> element of the body, which is added with the
+// default heading if missing; the landmarks and page list navs are inserted
+// after it. An empty body restores the default markup.
+//
+// The internal CSS path is the value returned by AddCSS, or an empty string
+// for no CSS.
+func (e *Epub) SetNavTemplate(body string, internalCSSPath string) error {
+	e.Lock()
+	defer e.Unlock()
+	if body != "" {
+		if _, err := readBody(strings.NewReader(body)); err != nil {
+			return err
+		}
+	}
+	e.toc.navTemplate = body
+	e.toc.navCSSPath = ""
+	if internalCSSPath != "" {
+		// The CSS path is relative to the sections, while the navigation
+		// document is in the parent folder
+		e.toc.navCSSPath = path.Join(xhtmlFolderName, internalCSSPath)
+	}
+	return nil
+}
+
+// SetTOCFlattenDepth sets the number of nested levels of the table of
+// contents generated from the sections. The entries of the sections nested
+// deeper are added to their ancestor at the last level, after its other
+// children, so that books with deeply nested sections keep a table of
+// contents that reading systems can display. 0 means no limit, the default.
+//
+// Unlike Limits.MaxTOCDepth, sections can still be nested at any depth.
+func (e *Epub) SetTOCFlattenDepth(depth int) {
+	e.Lock()
+	defer e.Unlock()
+	if depth < 0 {
+		depth = 0
+	}
+	e.toc.flattenDepth = depth
+}
+
+// SetPpd sets the page progression direction of the EPUB. If it is "rtl",
+// the generated pages (cover, table of contents, notes) are laid out right to
+// left, as they are when the language of the EPUB is written right to left.
 func (e *Epub) SetPpd(direction string) {
 	e.Lock()
 	defer e.Unlock()
@@ -583,6 +1542,36 @@ func (e *Epub) SetPpd(direction string) {
 	e.pkg.setPpd(direction)
 }
 
+// rtlLanguages are the primary language subtags of the languages written
+// right to left
+var rtlLanguages = map[string]bool{
+	"ar":  true, // Arabic
+	"ckb": true, // Central Kurdish
+	"dv":  true, // Divehi
+	"fa":  true, // Persian
+	"he":  true, // Hebrew
+	"iw":  true, // Hebrew, deprecated code
+	"ps":  true, // Pashto
+	"sd":  true, // Sindhi
+	"ug":  true, // Uyghur
+	"ur":  true, // Urdu
+	"yi":  true, // Yiddish
+}
+
+// direction returns the base direction of the generated pages: "rtl" if the
+// page progression direction or the language is right to left, empty
+// otherwise, leaving the direction to the reading system
+func (e *Epub) direction() string {
+	primary := strings.ToLower(e.lang)
+	if i := strings.IndexAny(primary, "-_"); i >= 0 {
+		primary = primary[:i]
+	}
+	if e.ppd == "rtl" || rtlLanguages[primary] {
+		return "rtl"
+	}
+	return ""
+}
+
 // SetTitle sets the title of the EPUB.
 func (e *Epub) SetTitle(title string) {
 	e.Lock()
@@ -590,6 +1579,35 @@ func (e *Epub) SetTitle(title string) {
 	e.title = title
 	e.pkg.setTitle(title)
 	e.toc.setTitle(title)
+	e.checkTitle("", title)
+}
+
+// SetTitleFileAs sets the form of the title used to sort the EPUB in
+// libraries, e.g. "Hobbit, The" for "The Hobbit", see TitleFileAs. It is
+// written as a file-as refinement of the title and as the calibre:title_sort
+// metadata used by Calibre. An empty string, the default, removes it.
+func (e *Epub) SetTitleFileAs(fileAs string) {
+	e.Lock()
+	defer e.Unlock()
+	e.pkg.setTitleFileAs(fileAs)
+}
+
+// titleArticles are the leading articles moved to the end of the title by
+// TitleFileAs
+var titleArticles = []string{"The", "An", "A"}
+
+// TitleFileAs returns the form of an English title used for sorting, with the
+// leading article moved to the end, e.g. "Hobbit, The" for "The Hobbit". The
+// title is returned unchanged if it doesn't start with an article.
+func TitleFileAs(title string) string {
+	title = strings.TrimSpace(title)
+	for _, article := range titleArticles {
+		n := len(article)
+		if len(title) > n+1 && strings.EqualFold(title[:n], article) && title[n] == ' ' {
+			return strings.TrimSpace(title[n+1:]) + ", " + title[:n]
+		}
+	}
+	return title
 }
 
 // Title returns the title of the EPUB.
@@ -611,18 +1629,37 @@ func (e *Epub) Title() string {
 // if go-epub can't download image it keep it untoch and not return any error just log that
 
 // Just call EmbedImages() after section added
+//
+// The images of the subsections are embedded too. The EPUB is locked while
+// the images are retrieved, so sections added concurrently are processed by
+// the next call.
 func (e *Epub) EmbedImages() {
+	e.EmbedImagesContext(context.Background())
+}
+
+// EmbedImagesContext is like EmbedImages, stopping the retrieval of the images
+// when ctx is done. The images retrieved until then are embedded, the others
+// are left untouched, and the error of ctx is returned.
+func (e *Epub) EmbedImagesContext(ctx context.Context) error {
+	e.Lock()
+	defer e.Unlock()
 	imageTagRegex := regexp.MustCompile(`<img.*?src="(.*?)".*?>`)
-	for i, section := range e.sections {
+	walkSections(e.sections, func(section *epubSection) {
+		if ctx.Err() != nil {
+			return
+		}
 		imageTagMatches := imageTagRegex.FindAllStringSubmatch(section.xhtml.xml.Body.XML, -1)
 
 		// Check if imageTagMatches is empty
 		if len(imageTagMatches) == 0 {
-			continue // Skip to the next section
+			return // Skip to the next section
 		}
 		images := make(map[string]string)
 
 		for _, match := range imageTagMatches {
+			if ctx.Err() != nil {
+				return
+			}
 			imageURL := match[1]
 			if !strings.HasPrefix(imageURL, "data:image/") {
 				// Check if the image exists somewhere else in the document, to avoid processing it several times
@@ -648,7 +1685,7 @@ func (e *Epub) EmbedImages() {
 				}
 				extension := filepath.Ext(parsedImageURL.Path)
 				if extension == "" {
-					res, err := http.Head(imageURL)
+					res, err := e.headImage(ctx, imageURL)
 					if err != nil {
 						log.Printf("can't get image headers: %s", err)
 					} else {
@@ -661,23 +1698,39 @@ func (e *Epub) EmbedImages() {
 						}
 					}
 				}
-				filename := fmt.Sprintf("image%04d%s", len(e.images)+1, extension)
-				filePath, err := e.AddImage(string(imageURL), filename)
+				filename := unusedFilename(imageFileFormat, extension, e.images)
+				filePath, err := e.addResource(ctx, imageURL, filename, imageFileFormat, ImageFolderName, e.images)
 				if err != nil {
 					log.Printf("can't add image to the epub: %s", err)
 					continue
 				}
 				newImgTag := strings.ReplaceAll(match[0], imageURL, filePath)
-				e.sections[i].xhtml.xml.Body.XML = strings.ReplaceAll(section.xhtml.xml.Body.XML, originalImgTag, newImgTag)
+				section.xhtml.xml.Body.XML = strings.ReplaceAll(section.xhtml.xml.Body.XML, originalImgTag, newImgTag)
 			}
 		}
+	})
+	return ctx.Err()
+}
+
+// headImage returns the headers of the response to a HEAD request for
+// imageURL
+func (e *Epub) headImage(ctx context.Context, imageURL string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, imageURL, nil)
+	if err != nil {
+		return nil, err
 	}
+	res, err := e.httpClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	res.Body.Close()
+	return res, nil
 }
 
 // Add a media file to the EPUB and return the path relative to the EPUB section
 // files
-func addMedia(client *http.Client, source string, internalFilename string, mediaFileFormat string, mediaFolderName string, mediaMap map[string]string) (string, error) {
-	err := grabber{client}.checkMedia(source)
+func addMedia(g grabber, source string, internalFilename string, mediaFileFormat string, mediaFolderName string, mediaMap map[string]string) (string, error) {
+	err := g.checkMedia(source)
 	if err != nil {
 		return "", &FileRetrievalError{
 			Source: source,
@@ -687,14 +1740,13 @@ func addMedia(client *http.Client, source string, internalFilename string, media
 	if internalFilename == "" {
 		// If a filename isn't provided, use the filename from the source
 		internalFilename = filepath.Base(source)
+		if detectMediaType(source) == "File" {
+			internalFilename = localBase(source)
+		}
 		_, ok := mediaMap[internalFilename]
 		// if filename is too long, invalid or already used, try to generate a unique filename
 		if len(internalFilename) > 255 || !fs.ValidPath(internalFilename) || ok {
-			internalFilename = fmt.Sprintf(
-				mediaFileFormat,
-				len(mediaMap)+1,
-				strings.ToLower(filepath.Ext(source)),
-			)
+			internalFilename = unusedFilename(mediaFileFormat, strings.ToLower(filepath.Ext(internalFilename)), mediaMap)
 		}
 	}
 
@@ -711,31 +1763,87 @@ func addMedia(client *http.Client, source string, internalFilename string, media
 	), nil
 }
 
-// getFilenames returns a map of section filenames and index numbers within an ebook
+// unusedFilename returns the filename generated from mediaFileFormat, e.g.
+// image%04d%s, with the extension ext and the first index past the number of
+// files of mediaMap that isn't used, since files may have been removed
+func unusedFilename(mediaFileFormat string, ext string, mediaMap map[string]string) string {
+	for i := len(mediaMap) + 1; ; i++ {
+		filename := fmt.Sprintf(mediaFileFormat, i, ext)
+		if _, ok := mediaMap[filename]; !ok {
+			return filename
+		}
+	}
+}
+
+// getFilenames returns a map of section filenames and index numbers within an
+// ebook, in reading order
 func getFilenames(sections []*epubSection) map[string]int {
 	filenames := make(map[string]int)
-	index := 1
+	walkSections(sections, func(section *epubSection) {
+		filenames[section.filename] = len(filenames) + 1
+	})
+	return filenames
+}
 
-	for _, section := range sections {
-		filenames[section.filename] = index
-		index++
-
-		if section.children != nil {
-			childFilenames := getFilenames(section.children)
-			for filename := range childFilenames {
-				filenames[filename] = index
-				index++
-			}
+// indexSections returns a map of the sections and subsections by filename, so
+// that looking up many of them doesn't require walking the sections each time
+func indexSections(sections []*epubSection) map[string]*epubSection {
+	index := make(map[string]*epubSection)
+	walkSections(sections, func(section *epubSection) {
+		index[section.filename] = section
+	})
+	return index
+}
+
+// walkSections calls f for the sections and their subsections, in reading
+// order, without recursion so that deeply nested sections don't grow the stack
+func walkSections(sections []*epubSection, f func(section *epubSection)) {
+	// Sections left to walk at each level
+	stack := [][]*epubSection{sections}
+	for len(stack) > 0 {
+		top := stack[len(stack)-1]
+		if len(top) == 0 {
+			stack = stack[:len(stack)-1]
+			continue
+		}
+		section := top[0]
+		stack[len(stack)-1] = top[1:]
+		f(section)
+		if len(section.children) > 0 {
+			stack = append(stack, section.children)
 		}
 	}
+}
 
-	return filenames
+// findSection returns the section with the given filename, or nil if it doesn't
+// exist
+func findSection(sections []*epubSection, filename string) *epubSection {
+	if filename == "" {
+		return nil
+	}
+	for _, section := range sections {
+		if section.filename == filename {
+			return section
+		}
+		if s := findSection(section.children, filename); s != nil {
+			return s
+		}
+	}
+	return nil
 }
 
-// get filenamelist and return true if filename exist inside epub
-func keyExists(m map[string]int, key string) bool {
-	_, ok := m[key]
-	return ok
+// findSiblings returns the slice holding the section with the given filename,
+// or nil if it doesn't exist
+func findSiblings(sections *[]*epubSection, filename string) *[]*epubSection {
+	for _, section := range *sections {
+		if section.filename == filename {
+			return sections
+		}
+		if siblings := findSiblings(&section.children, filename); siblings != nil {
+			return siblings
+		}
+	}
+	return nil
 }
 
 // Find parent section and append epubSection to it
@@ -753,3 +1861,151 @@ func sectionAppender(sections []*epubSection, parentFilename string, targetSecti
 
 	return fmt.Errorf("parent section not found")
 }
+
+// clone returns a copy of the EPUB that can be changed independently. The
+// sources of the resources are shared, the content of the sections isn't.
+func (e *Epub) clone() *Epub {
+	c := &Epub{
+		Client:              e.Client,
+		author:              e.author,
+		css:                 copyMap(e.css),
+		fonts:               copyMap(e.fonts),
+		identifier:          e.identifier,
+		images:              copyMap(e.images),
+		videos:              copyMap(e.videos),
+		audios:              copyMap(e.audios),
+		lang:                e.lang,
+		desc:                e.desc,
+		edition:             e.edition,
+		version:             e.version,
+		ppd:                 e.ppd,
+		pkg:                 e.pkg.clone(),
+		sections:            cloneSections(e.sections),
+		title:               e.title,
+		toc:                 e.toc.clone(),
+		maxBufferSize:       e.maxBufferSize,
+		tracer:              e.tracer,
+		sanitizeProfile:     e.sanitizeProfile,
+		transforms:          append([]Transform(nil), e.transforms...),
+		customTOC:           cloneTOCEntries(e.customTOC),
+		landmarks:           append([]landmark(nil), e.landmarks...),
+		noNcx:               e.noNcx,
+		notePlacement:       e.notePlacement,
+		noteCount:           e.noteCount,
+		globalCSS:           append([]string(nil), e.globalCSS...),
+		fontFallbacks:       e.fontFallbacks,
+		sectionTemplate:     e.sectionTemplate,
+		coverTemplate:       e.coverTemplate,
+		limits:              e.limits,
+		pageLabelPolicy:     e.pageLabelPolicy,
+		pageNumber:          e.pageNumber,
+		durationProber:      e.durationProber,
+		fsys:                e.fsys,
+		tolerant:            e.tolerant,
+		tempFilePattern:     e.tempFilePattern,
+		middlewares:         append([]Middleware(nil), e.middlewares...),
+		userAgent:           e.userAgent,
+		extMediaTypes:       copyMap(e.extMediaTypes),
+		resourceMediaTypes:  copyMap(e.resourceMediaTypes),
+		fontObfuscation:     e.fontObfuscation,
+		cssReset:            e.cssReset,
+		contentHashes:       e.contentHashes,
+		imageOptimization:   e.imageOptimization,
+		imageDecoder:        e.imageDecoder,
+		deduplicate:         e.deduplicate,
+		sourceHashes:        copyMap(e.sourceHashes),
+		retryPolicy:         e.retryPolicy,
+		downloadConcurrency: e.downloadConcurrency,
+		warnings:            append([]Warning(nil), e.warnings...),
+		warningHandler:      e.warningHandler,
+		progressHandler:     e.progressHandler,
+		downloadCache:       e.downloadCache,
+		checksums:           copyMap(e.checksums),
+		compression:         Compression{Level: e.compression.Level, Store: append([]string(nil), e.compression.Store...)},
+		streamingWrite:      e.streamingWrite,
+		linkCheck:           e.linkCheck,
+		strictXHTML:         e.strictXHTML,
+	}
+	if e.fetchers != nil {
+		c.fetchers = make(map[string]Fetcher, len(e.fetchers))
+		for scheme, f := range e.fetchers {
+			c.fetchers[scheme] = f
+		}
+	}
+	if e.attributions != nil {
+		c.attributions = make(map[string]Attribution, len(e.attributions))
+		for name, a := range e.attributions {
+			c.attributions[name] = a
+		}
+	}
+	if e.readers != nil {
+		// The readers are shared, each one being read once by any of the
+		// copies, but removing a resource from a copy keeps it in e
+		c.readers = make(map[string]*readerSource, len(e.readers))
+		for source, r := range e.readers {
+			c.readers[source] = r
+		}
+	}
+	if e.durations != nil {
+		c.durations = make(map[string]time.Duration, len(e.durations))
+		for filename, d := range e.durations {
+			c.durations[filename] = d
+		}
+	}
+	if e.remoteResources != nil {
+		c.remoteResources = make(map[string]remoteResource, len(e.remoteResources))
+		for source, r := range e.remoteResources {
+			c.remoteResources[source] = r
+		}
+	}
+	cover := *e.cover
+	c.cover = &cover
+	if e.cache != nil {
+		c.cache = &buildCache{entries: make(map[string]*cacheEntry)}
+	}
+	if e.ibooksOptions != nil {
+		options := *e.ibooksOptions
+		c.ibooksOptions = &options
+	}
+	if e.provenance != nil {
+		provenance := *e.provenance
+		provenance.Fields = append([]ProvenanceField(nil), provenance.Fields...)
+		c.provenance = &provenance
+	}
+	if e.coverRequirements != nil {
+		requirements := *e.coverRequirements
+		c.coverRequirements = &requirements
+	}
+	return c
+}
+
+func copyMap(m map[string]string) map[string]string {
+	c := make(map[string]string, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
+
+func cloneSections(sections []*epubSection) []*epubSection {
+	if sections == nil {
+		return nil
+	}
+	clones := make([]*epubSection, len(sections))
+	for i, s := range sections {
+		c := *s
+		c.xhtml = s.xhtml.copy()
+		c.children = cloneSections(s.children)
+		c.tocEntries = cloneTOCEntries(s.tocEntries)
+		c.pageBreaks = append([]pageBreak(nil), s.pageBreaks...)
+		c.notes = append([]note(nil), s.notes...)
+		if s.propertyOverrides != nil {
+			c.propertyOverrides = make(map[string]bool, len(s.propertyOverrides))
+			for k, v := range s.propertyOverrides {
+				c.propertyOverrides[k] = v
+			}
+		}
+		clones[i] = &c
+	}
+	return clones
+}
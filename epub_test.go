@@ -3,6 +3,8 @@ package epub
 import (
 	"archive/zip"
 	"bytes"
+	"context"
+	"encoding/xml"
 	"errors"
 	"fmt"
 	"io"
@@ -14,7 +16,9 @@ import (
 	"path/filepath"
 	"sort"
 	"strings"
+	"sync"
 	"testing"
+	"testing/fstest"
 	"time"
 
 	"github.com/go-shiori/go-epub/internal/storage"
@@ -753,6 +757,57 @@ func TestEpubTitle(t *testing.T) {
 	cleanup(testEpubFilename, tempDir)
 }
 
+func TestSetTitleFileAs(t *testing.T) {
+	e, err := NewEpub("The Hobbit")
+	if err != nil {
+		t.Error(err)
+	}
+	e.SetTitleFileAs(TitleFileAs(e.Title()))
+
+	tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+	defer cleanup(testEpubFilename, tempDir)
+
+	contents, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, pkgFilename))
+	if err != nil {
+		t.Errorf("Unexpected error reading package file: %s", err)
+	}
+	for _, expected := range []string{
+		`<dc:title id="title">The Hobbit</dc:title>`,
+		`<meta refines="#title" property="file-as">Hobbit, The</meta>`,
+		`<meta name="calibre:title_sort" content="Hobbit, The"></meta>`,
+	} {
+		if !strings.Contains(string(contents), expected) {
+			t.Errorf("Package file doesn't contain %s:\n%s", expected, contents)
+		}
+	}
+
+	e.SetTitleFileAs("")
+	if e.pkg.xml.Metadata.Title.ID != "" {
+		t.Error("Title id not removed")
+	}
+	for _, meta := range e.pkg.xml.Metadata.Meta {
+		if meta.Data == "Hobbit, The" || meta.Content == "Hobbit, The" {
+			t.Errorf("Title sort not removed: %+v", meta)
+		}
+	}
+}
+
+func TestTitleFileAs(t *testing.T) {
+	for title, expected := range map[string]string{
+		"The Hobbit":           "Hobbit, The",
+		"a Tale of Two Cities": "Tale of Two Cities, a",
+		"An Instance":          "Instance, An",
+		"Anathem":              "Anathem",
+		"Theory":               "Theory",
+		"The":                  "The",
+		"  The  Road ":         "Road, The",
+	} {
+		if got := TitleFileAs(title); got != expected {
+			t.Errorf("TitleFileAs(%q) = %q, expected %q", title, got, expected)
+		}
+	}
+}
+
 func TestEpubDescription(t *testing.T) {
 	e, err := NewEpub(testEpubTitle)
 	if err != nil {
@@ -790,6 +845,46 @@ func TestEpubDescription(t *testing.T) {
 	cleanup(testEpubFilename, tempDir)
 }
 
+func TestEpubEditionAndVersion(t *testing.T) {
+	e, err := NewEpub(testEpubTitle)
+	if err != nil {
+		t.Error(err)
+	}
+	e.SetEdition("2nd edition")
+	e.SetVersion("1.2.0")
+	if e.Edition() != "2nd edition" || e.Version() != "1.2.0" {
+		t.Errorf("Unexpected edition %q and version %q", e.Edition(), e.Version())
+	}
+
+	tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+	defer cleanup(testEpubFilename, tempDir)
+
+	contents, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, pkgFilename))
+	if err != nil {
+		t.Errorf("Unexpected error reading package file: %s", err)
+	}
+	for _, expected := range []string{
+		`prefix="schema: http://schema.org/"`,
+		`<meta property="schema:bookEdition">2nd edition</meta>`,
+		`<meta property="schema:version">1.2.0</meta>`,
+	} {
+		if !strings.Contains(string(contents), expected) {
+			t.Errorf("Package file doesn't contain %s:\n%s", expected, contents)
+		}
+	}
+
+	e.SetEdition("")
+	e.SetVersion("")
+	if e.pkg.xml.Prefix != "" {
+		t.Errorf("Prefix not removed: %s", e.pkg.xml.Prefix)
+	}
+	for _, meta := range e.pkg.xml.Metadata.Meta {
+		if meta.Property == pkgEditionProperty || meta.Property == pkgVersionProperty {
+			t.Errorf("Edition or version not removed: %+v", meta)
+		}
+	}
+}
+
 func TestEpubIdentifier(t *testing.T) {
 	e, err := NewEpub(testEpubTitle)
 	if err != nil {
@@ -1138,6 +1233,73 @@ func TestEmbedImage(t *testing.T) {
 	cleanup(testEpubFilename, tempDir)
 }
 
+func TestEmbedImagesConcurrent(t *testing.T) {
+	e, err := NewEpub(testEpubTitle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parent, err := e.AddSection(`<p>Parent</p>`, "Parent", "", "")
+	if err != nil {
+		t.Fatalf("Error adding section: %s", err)
+	}
+	body := `<p><img src="` + testImageFromFileSource + `" alt="Gopher" /></p>`
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				if _, err := e.AddSubSection(parent, body, "Section", "", ""); err != nil {
+					t.Errorf("Error adding section: %s", err)
+				}
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			e.EmbedImages()
+		}()
+	}
+	wg.Wait()
+	e.EmbedImages()
+
+	for _, s := range e.Sections() {
+		if strings.Contains(s.Body, testImageFromFileSource) {
+			t.Errorf("Image of section %s not embedded:\n%s", s.Filename, s.Body)
+		}
+	}
+}
+
+func TestEmbedImagesContext(t *testing.T) {
+	ts := httptest.NewServer(http.FileServer(http.Dir("./testdata/")))
+	defer ts.Close()
+
+	e, err := NewEpub(testEpubTitle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := `<p><img src="` + ts.URL + `/gophercolor16x16.png" alt="Gopher" /></p>`
+	if _, err := e.AddSection(body, "Section", "", ""); err != nil {
+		t.Fatalf("Error adding section: %s", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := e.EmbedImagesContext(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+	if s := e.Sections()[0]; !strings.Contains(s.Body, ts.URL) {
+		t.Errorf("Image embedded after the context was canceled:\n%s", s.Body)
+	}
+
+	if err := e.EmbedImagesContext(context.Background()); err != nil {
+		t.Errorf("Unexpected error embedding images: %s", err)
+	}
+	if s := e.Sections()[0]; strings.Contains(s.Body, ts.URL) {
+		t.Errorf("Image not embedded:\n%s", s.Body)
+	}
+}
+
 func testEpubValidity(t testing.TB) {
 	fs := http.FileServer(http.Dir("./testdata/"))
 
@@ -1533,3 +1695,1095 @@ func TestSectionProperties(t *testing.T) {
 		}
 	}
 }
+
+func TestSections(t *testing.T) {
+	e, err := NewEpub(testEpubTitle)
+	if err != nil {
+		t.Error(err)
+	}
+
+	section1Path, err := e.AddSection(testSectionBody, testSectionTitle, testSectionFilename, "")
+	if err != nil {
+		t.Errorf("Error adding section: %s", err)
+	}
+	_, err = e.AddSubSection(section1Path, "<p>Sub</p>", "Subsection", "subsection.xhtml", "")
+	if err != nil {
+		t.Errorf("Error adding subsection: %s", err)
+	}
+	testImagePath, _ := e.AddImage(testImageFromFileSource, testImageFromFileFilename)
+	if err := e.SetCover(testImagePath, ""); err != nil {
+		t.Errorf("Error setting cover: %s", err)
+	}
+
+	sections := e.Sections()
+	expected := []Section{
+		{Filename: defaultCoverXhtmlFilename, Body: fmt.Sprintf(defaultCoverBody, testImagePath)},
+		{Filename: testSectionFilename, Title: testSectionTitle, Body: testSectionBody},
+		{Filename: "subsection.xhtml", Title: "Subsection", Body: "<p>Sub</p>", Parent: testSectionFilename},
+	}
+	if len(sections) != len(expected) {
+		t.Fatalf("Expected %d sections, got %d: %+v", len(expected), len(sections), sections)
+	}
+	for i := range expected {
+		if sections[i] != expected[i] {
+			t.Errorf("Section %d doesn't match\nGot: %+v\nExpected: %+v", i, sections[i], expected[i])
+		}
+	}
+}
+
+func TestUpdateSection(t *testing.T) {
+	e, err := NewEpub(testEpubTitle)
+	if err != nil {
+		t.Error(err)
+	}
+
+	section1Path, err := e.AddSection(testSectionBody, testSectionTitle, testSectionFilename, "")
+	if err != nil {
+		t.Errorf("Error adding section: %s", err)
+	}
+	section2Path, err := e.AddSubSection(section1Path, testSectionBody, "Figures", "", "")
+	if err != nil {
+		t.Errorf("Error adding subsection: %s", err)
+	}
+
+	updatedBody := `<h1>Figures</h1><svg xmlns="http://www.w3.org/2000/svg"></svg>`
+	if err := e.UpdateSection(section2Path, updatedBody); err != nil {
+		t.Errorf("Error updating section: %s", err)
+	}
+	err = e.UpdateSection("doesNotExist.xhtml", updatedBody)
+	if _, ok := err.(*SectionDoesNotExistError); !ok {
+		t.Errorf("Expected error SectionDoesNotExistError not returned. Returned instead: %+v", err)
+	}
+
+	tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+
+	contents, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, xhtmlFolderName, section2Path))
+	if err != nil {
+		t.Errorf("Unexpected error reading section file: %s", err)
+	}
+	testSectionContents := fmt.Sprintf(testSectionContentTemplate, "Figures", updatedBody)
+	if trimAllSpace(string(contents)) != trimAllSpace(testSectionContents) {
+		t.Errorf(
+			"Section file contents don't match\n"+
+				"Got: %s\n"+
+				"Expected: %s",
+			contents,
+			testSectionContents)
+	}
+	if got := e.BuildReport().SectionProperties[section2Path]; got != "svg" {
+		t.Errorf("Expected updated section properties %q, got %q", "svg", got)
+	}
+
+	cleanup(testEpubFilename, tempDir)
+}
+
+func TestInsertSectionAtAndMoveSection(t *testing.T) {
+	e, err := NewEpub(testEpubTitle)
+	if err != nil {
+		t.Error(err)
+	}
+
+	for _, filename := range []string{"chapter1.xhtml", "chapter2.xhtml", "chapter3.xhtml"} {
+		if _, err := e.AddSection(testSectionBody, filename, filename, ""); err != nil {
+			t.Errorf("Error adding section: %s", err)
+		}
+	}
+	for _, filename := range []string{"chapter2a.xhtml", "chapter2b.xhtml"} {
+		if _, err := e.AddSubSection("chapter2.xhtml", testSectionBody, filename, filename, ""); err != nil {
+			t.Errorf("Error adding subsection: %s", err)
+		}
+	}
+
+	// Front matter assembled after the main body
+	if _, err := e.InsertSectionAt(0, testSectionBody, "Preface", "preface.xhtml", ""); err != nil {
+		t.Errorf("Error inserting section: %s", err)
+	}
+	if _, err := e.InsertSectionAt(10, testSectionBody, "Out of range", "", ""); err == nil {
+		t.Error("Expected an error inserting a section out of range")
+	}
+
+	if err := e.MoveSection("chapter3.xhtml", 1); err != nil {
+		t.Errorf("Error moving section: %s", err)
+	}
+	if err := e.MoveSection("chapter2b.xhtml", 0); err != nil {
+		t.Errorf("Error moving subsection: %s", err)
+	}
+	if err := e.MoveSection("chapter2b.xhtml", 2); err == nil {
+		t.Error("Expected an error moving a subsection out of range")
+	}
+	err = e.MoveSection("doesNotExist.xhtml", 0)
+	if _, ok := err.(*SectionDoesNotExistError); !ok {
+		t.Errorf("Expected error SectionDoesNotExistError not returned. Returned instead: %+v", err)
+	}
+
+	var got []string
+	for _, s := range e.Sections() {
+		got = append(got, s.Filename)
+	}
+	expected := []string{"preface.xhtml", "chapter3.xhtml", "chapter1.xhtml", "chapter2.xhtml", "chapter2b.xhtml", "chapter2a.xhtml"}
+	if strings.Join(got, ",") != strings.Join(expected, ",") {
+		t.Errorf("Sections order doesn't match\nGot: %v\nExpected: %v", got, expected)
+	}
+}
+
+func TestInsertSectionAtWithCover(t *testing.T) {
+	e, err := NewEpub(testEpubTitle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	imagePath, err := e.AddImage(testImageFromFileSource, "cover.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.SetCover(imagePath, ""); err != nil {
+		t.Fatal(err)
+	}
+	for _, filename := range []string{"chapter1.xhtml", "chapter2.xhtml"} {
+		if _, err := e.AddSection(testSectionBody, filename, filename, ""); err != nil {
+			t.Fatalf("Error adding section: %s", err)
+		}
+	}
+
+	if _, err := e.InsertSectionAt(1, testSectionBody, "Interlude", "interlude.xhtml", ""); err != nil {
+		t.Errorf("Error inserting section: %s", err)
+	}
+	if _, err := e.InsertSectionAt(4, testSectionBody, "Out of range", "", ""); err == nil {
+		t.Error("Expected an error inserting a section out of range")
+	}
+	if err := e.MoveSection("chapter2.xhtml", 0); err != nil {
+		t.Errorf("Error moving section: %s", err)
+	}
+	if err := e.MoveSection("chapter1.xhtml", 3); err == nil {
+		t.Error("Expected an error moving a section out of range")
+	}
+	if err := e.MoveSection(e.cover.xhtmlFilename, 1); err == nil {
+		t.Error("Expected an error moving the cover page")
+	}
+
+	var got []string
+	for _, s := range e.Sections() {
+		if s.Filename != e.cover.xhtmlFilename {
+			got = append(got, s.Filename)
+		}
+	}
+	expected := []string{"chapter2.xhtml", "chapter1.xhtml", "interlude.xhtml"}
+	if strings.Join(got, ",") != strings.Join(expected, ",") {
+		t.Errorf("Sections order doesn't match\nGot: %v\nExpected: %v", got, expected)
+	}
+}
+
+func TestSetSectionLinear(t *testing.T) {
+	e, err := NewEpub(testEpubTitle)
+	if err != nil {
+		t.Error(err)
+	}
+
+	_, err = e.AddSection(testSectionBody, testSectionTitle, testSectionFilename, "")
+	if err != nil {
+		t.Errorf("Error adding section: %s", err)
+	}
+	notesPath, err := e.AddSection("<p>Answers</p>", "Answer key", "answers.xhtml", "")
+	if err != nil {
+		t.Errorf("Error adding section: %s", err)
+	}
+	if err := e.SetSectionLinear(notesPath, false); err != nil {
+		t.Errorf("Error setting section linear: %s", err)
+	}
+	err = e.SetSectionLinear("doesNotExist.xhtml", false)
+	if _, ok := err.(*SectionDoesNotExistError); !ok {
+		t.Errorf("Expected error SectionDoesNotExistError not returned. Returned instead: %+v", err)
+	}
+
+	tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+
+	contents, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, pkgFilename))
+	if err != nil {
+		t.Errorf("Unexpected error reading package file: %s", err)
+	}
+	for _, itemref := range []string{
+		`<itemref idref="section0001.xhtml"></itemref>`,
+		`<itemref idref="answers.xhtml" linear="no"></itemref>`,
+	} {
+		if !strings.Contains(string(contents), itemref) {
+			t.Errorf("Spine item %s not found in package file:\n%s", itemref, contents)
+		}
+	}
+
+	cleanup(testEpubFilename, tempDir)
+}
+
+func TestSetSectionExcludeFromTOC(t *testing.T) {
+	e, err := NewEpub(testEpubTitle)
+	if err != nil {
+		t.Error(err)
+	}
+
+	_, err = e.AddSection(testSectionBody, "Chapter 1", "chapter1.xhtml", "")
+	if err != nil {
+		t.Errorf("Error adding section: %s", err)
+	}
+	_, err = e.AddSubSection("chapter1.xhtml", testSectionBody, "Epigraph", "epigraph.xhtml", "")
+	if err != nil {
+		t.Errorf("Error adding subsection: %s", err)
+	}
+	_, err = e.AddSubSection("epigraph.xhtml", testSectionBody, "Quote", "quote.xhtml", "")
+	if err != nil {
+		t.Errorf("Error adding subsection: %s", err)
+	}
+	if err := e.SetSectionExcludeFromTOC("epigraph.xhtml", true); err != nil {
+		t.Errorf("Error excluding section from TOC: %s", err)
+	}
+	err = e.SetSectionExcludeFromTOC("doesNotExist.xhtml", true)
+	if _, ok := err.(*SectionDoesNotExistError); !ok {
+		t.Errorf("Expected error SectionDoesNotExistError not returned. Returned instead: %+v", err)
+	}
+
+	tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+
+	nav, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, tocNavFilename))
+	if err != nil {
+		t.Errorf("Unexpected error reading nav file: %s", err)
+	}
+	if strings.Contains(string(nav), "Epigraph") {
+		t.Errorf("Excluded section found in nav file:\n%s", nav)
+	}
+	expectedNav := `<li><ahref="xhtml/chapter1.xhtml">Chapter1</a><ol><li><ahref="xhtml/quote.xhtml">Quote</a></li></ol></li>`
+	if !strings.Contains(strings.Join(strings.Fields(string(nav)), ""), expectedNav) {
+		t.Errorf("Subsection of the excluded section not moved to its parent:\n%s", nav)
+	}
+
+	// The section keeps its title and its place in the spine
+	section, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, xhtmlFolderName, "epigraph.xhtml"))
+	if err != nil {
+		t.Errorf("Unexpected error reading section file: %s", err)
+	}
+	if !strings.Contains(string(section), ">Epigraph</title>") {
+		t.Errorf("Excluded section lost its title:\n%s", section)
+	}
+	pkg, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, pkgFilename))
+	if err != nil {
+		t.Errorf("Unexpected error reading package file: %s", err)
+	}
+	if !strings.Contains(string(pkg), `<itemref idref="epigraph.xhtml"></itemref>`) {
+		t.Errorf("Excluded section not found in spine:\n%s", pkg)
+	}
+
+	cleanup(testEpubFilename, tempDir)
+}
+
+func TestSetSectionProperty(t *testing.T) {
+	e, err := NewEpub(testEpubTitle)
+	if err != nil {
+		t.Error(err)
+	}
+	filename, err := e.AddSection(`<p>Figure</p><svg xmlns="http://www.w3.org/2000/svg"></svg>`, "Section", "", "")
+	if err != nil {
+		t.Errorf("Error adding section: %s", err)
+	}
+	if err := e.SetSectionProperty(filename, "scripted", true); err != nil {
+		t.Errorf("Error setting section property: %s", err)
+	}
+	if err := e.SetSectionProperty(filename, "svg", false); err != nil {
+		t.Errorf("Error setting section property: %s", err)
+	}
+	if err := e.SetSectionProperty(filename, "cover-image", true); err == nil {
+		t.Error("Expected error setting an invalid section property")
+	}
+	err = e.SetSectionProperty("doesNotExist.xhtml", "svg", true)
+	if _, ok := err.(*SectionDoesNotExistError); !ok {
+		t.Errorf("Expected error SectionDoesNotExistError not returned. Returned instead: %+v", err)
+	}
+
+	// The overrides are kept when the body is replaced
+	if err := e.UpdateSection(filename, `<p>Formula</p><math xmlns="http://www.w3.org/1998/Math/MathML"></math><svg xmlns="http://www.w3.org/2000/svg"></svg>`); err != nil {
+		t.Errorf("Error updating section: %s", err)
+	}
+
+	tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+	pkg, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, pkgFilename))
+	if err != nil {
+		t.Errorf("Unexpected error reading package file: %s", err)
+	}
+	expected := `href="xhtml/` + filename + `" media-type="application/xhtml+xml" properties="mathml scripted"`
+	if !strings.Contains(string(pkg), expected) {
+		t.Errorf("Section properties not overridden, expected %s:\n%s", expected, pkg)
+	}
+	cleanup(testEpubFilename, tempDir)
+
+	if err := e.ClearSectionProperty(filename, "svg"); err != nil {
+		t.Errorf("Error clearing section property: %s", err)
+	}
+	tempDir = writeAndExtractEpub(t, e, testEpubFilename)
+	if got := e.BuildReport().SectionProperties[filename]; got != "mathml scripted svg" {
+		t.Errorf("Section properties don't match\nGot: %s\nExpected: mathml scripted svg", got)
+	}
+	cleanup(testEpubFilename, tempDir)
+}
+
+func TestSetTOCFlattenDepth(t *testing.T) {
+	e, err := NewEpub(testEpubTitle)
+	if err != nil {
+		t.Error(err)
+	}
+	e.SetTOCFlattenDepth(2)
+
+	// A chain of 1000 nested sections
+	parent := ""
+	for i := 1; i <= 1000; i++ {
+		filename := fmt.Sprintf("level%d.xhtml", i)
+		if parent == "" {
+			_, err = e.AddSection(testSectionBody, fmt.Sprintf("Level %d", i), filename, "")
+		} else {
+			_, err = e.AddSubSection(parent, testSectionBody, fmt.Sprintf("Level %d", i), filename, "")
+		}
+		if err != nil {
+			t.Fatalf("Error adding section: %s", err)
+		}
+		parent = filename
+	}
+
+	tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+
+	nav, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, tocNavFilename))
+	if err != nil {
+		t.Errorf("Unexpected error reading nav file: %s", err)
+	}
+	expectedNav := `<li><ahref="xhtml/level1.xhtml">Level1</a><ol><li><ahref="xhtml/level2.xhtml">Level2</a></li><li><ahref="xhtml/level3.xhtml">Level3</a></li>`
+	if !strings.Contains(strings.Join(strings.Fields(string(nav)), ""), expectedNav) {
+		t.Errorf("Deep sections not flattened:\n%.1000s", nav)
+	}
+	if strings.Count(string(nav), "<ol>") != 2 {
+		t.Errorf("Nav file has more than 2 levels:\n%.1000s", nav)
+	}
+
+	ncx, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, tocNcxFilename))
+	if err != nil {
+		t.Errorf("Unexpected error reading NCX file: %s", err)
+	}
+	for _, expected := range []string{
+		`<meta name="dtb:uid" content="` + e.Identifier() + `"></meta>`,
+		`<meta name="dtb:depth" content="2"></meta>`,
+		`<navPoint id="navPoint-1000">`,
+	} {
+		if !strings.Contains(string(ncx), expected) {
+			t.Errorf("NCX file doesn't contain %s:\n%.1000s", expected, ncx)
+		}
+	}
+
+	cleanup(testEpubFilename, tempDir)
+}
+
+func TestSetTOCFlattenDepthOne(t *testing.T) {
+	e, err := NewEpub(testEpubTitle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.SetTOCFlattenDepth(1)
+	if _, err := e.AddSection(testSectionBody, "Chapter", "chapter.xhtml", ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.AddSubSection("chapter.xhtml", testSectionBody, "Part", "part.xhtml", ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.WriteTo(io.Discard); err != nil {
+		t.Fatalf("Error writing EPUB: %s", err)
+	}
+
+	var titles []string
+	for _, l := range e.toc.navXML.Links {
+		titles = append(titles, l.A.Data)
+		if len(l.Children) != 0 {
+			t.Errorf("Entry %s has children at flatten depth 1", l.A.Data)
+		}
+	}
+	if strings.Join(titles, ", ") != "Chapter, Part" {
+		t.Errorf("Got top level entries %v, expected Chapter and Part", titles)
+	}
+}
+
+func TestAddTOCEntry(t *testing.T) {
+	e, err := NewEpub(testEpubTitle)
+	if err != nil {
+		t.Error(err)
+	}
+
+	_, err = e.AddSection(`<h1>Chapter 1</h1><h2 id="start">Start</h2><h2 id="end">End</h2>`, "Chapter 1", "chapter1.xhtml", "")
+	if err != nil {
+		t.Errorf("Error adding section: %s", err)
+	}
+	_, err = e.AddSubSection("chapter1.xhtml", testSectionBody, "Appendix", "appendix.xhtml", "")
+	if err != nil {
+		t.Errorf("Error adding subsection: %s", err)
+	}
+	if err := e.AddTOCEntry("chapter1.xhtml", "#start", "Start"); err != nil {
+		t.Errorf("Error adding TOC entry: %s", err)
+	}
+	if err := e.AddTOCEntry("chapter1.xhtml", "end", "End"); err != nil {
+		t.Errorf("Error adding TOC entry: %s", err)
+	}
+	err = e.AddTOCEntry("doesNotExist.xhtml", "start", "Start")
+	if _, ok := err.(*SectionDoesNotExistError); !ok {
+		t.Errorf("Expected error SectionDoesNotExistError not returned. Returned instead: %+v", err)
+	}
+	if err := e.AddTOCEntry("chapter1.xhtml", "#", "Empty"); err == nil {
+		t.Error("Expected an error adding a TOC entry without fragment")
+	}
+
+	tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+
+	nav, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, tocNavFilename))
+	if err != nil {
+		t.Errorf("Unexpected error reading nav file: %s", err)
+	}
+	expectedNav := `<li><ahref="xhtml/chapter1.xhtml">Chapter1</a><ol>` +
+		`<li><ahref="xhtml/chapter1.xhtml#start">Start</a></li>` +
+		`<li><ahref="xhtml/chapter1.xhtml#end">End</a></li>` +
+		`<li><ahref="xhtml/appendix.xhtml">Appendix</a></li></ol></li>`
+	if !strings.Contains(strings.Join(strings.Fields(string(nav)), ""), expectedNav) {
+		t.Errorf("Nav file doesn't contain the fragment entries\nGot: %s\nExpected: %s", nav, expectedNav)
+	}
+
+	ncx, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, tocNcxFilename))
+	if err != nil {
+		t.Errorf("Unexpected error reading NCX file: %s", err)
+	}
+	if !strings.Contains(string(ncx), `<content src="xhtml/chapter1.xhtml#end"></content>`) {
+		t.Errorf("NCX file doesn't contain the fragment entries:\n%s", ncx)
+	}
+
+	cleanup(testEpubFilename, tempDir)
+}
+
+func TestSetLandmark(t *testing.T) {
+	e, err := NewEpub(testEpubTitle)
+	if err != nil {
+		t.Error(err)
+	}
+
+	_, err = e.AddSection(testSectionBody, "Title page", "title.xhtml", "")
+	if err != nil {
+		t.Errorf("Error adding section: %s", err)
+	}
+	_, err = e.AddSection(testSectionBody, "Chapter 1", "chapter1.xhtml", "")
+	if err != nil {
+		t.Errorf("Error adding section: %s", err)
+	}
+	for _, l := range []struct {
+		filename string
+		epubType string
+	}{
+		{"title.xhtml", LandmarkTitlePage},
+		{"chapter1.xhtml", LandmarkIndex},
+		{"", LandmarkTOC},
+		// Replaces the previous landmark of the section
+		{"chapter1.xhtml", LandmarkBodyMatter},
+		{"title.xhtml", "other-matter"},
+		{"title.xhtml", ""},
+	} {
+		if err := e.SetLandmark(l.filename, l.epubType); err != nil {
+			t.Errorf("Error setting landmark: %s", err)
+		}
+	}
+	err = e.SetLandmark("doesNotExist.xhtml", LandmarkCover)
+	if _, ok := err.(*SectionDoesNotExistError); !ok {
+		t.Errorf("Expected error SectionDoesNotExistError not returned. Returned instead: %+v", err)
+	}
+	if err := e.SetLandmark("chapter1.xhtml", "custom"); err != nil {
+		t.Errorf("Error setting landmark: %s", err)
+	}
+
+	tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+
+	nav, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, tocNavFilename))
+	if err != nil {
+		t.Errorf("Unexpected error reading nav file: %s", err)
+	}
+	expectedLandmarks := `<nav epub:type="landmarks" hidden="hidden">` +
+		`<h1>Landmarks</h1><ol>` +
+		`<li><a epub:type="toc" href="nav.xhtml">Table of Contents</a></li>` +
+		`<li><a epub:type="custom" href="xhtml/chapter1.xhtml">Chapter 1</a></li>` +
+		`</ol></nav>`
+	if !strings.Contains(strings.ReplaceAll(trimAllSpace(string(nav)), "\n", ""), expectedLandmarks) {
+		t.Errorf("Nav file doesn't contain the landmarks\nGot: %s\nExpected: %s", nav, expectedLandmarks)
+	}
+
+	pkg, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, pkgFilename))
+	if err != nil {
+		t.Errorf("Unexpected error reading package file: %s", err)
+	}
+	expectedGuide := `<guide>` +
+		`<reference type="toc" title="Table of Contents" href="nav.xhtml"></reference>` +
+		`<reference type="other.custom" title="Chapter 1" href="xhtml/chapter1.xhtml"></reference>` +
+		`</guide>`
+	if !strings.Contains(strings.ReplaceAll(trimAllSpace(string(pkg)), "\n", ""), expectedGuide) {
+		t.Errorf("Package file doesn't contain the guide\nGot: %s\nExpected: %s", pkg, expectedGuide)
+	}
+
+	cleanup(testEpubFilename, tempDir)
+}
+
+func TestAddSectionFromReader(t *testing.T) {
+	e, err := NewEpub(testEpubTitle)
+	if err != nil {
+		t.Error(err)
+	}
+
+	body := "<h1>Title</h1>\n<p>One&#160;<em>two</em></p>\n<p>Three</p>"
+	filename, err := e.AddSectionFromReader(strings.NewReader(body), testSectionTitle, "", "")
+	if err != nil {
+		t.Errorf("Error adding section: %s", err)
+	}
+	s := findSection(e.sections, filename)
+	if s == nil || strings.TrimSpace(s.xhtml.body()) != body || s.xhtml.Title() != testSectionTitle {
+		t.Errorf("Section not added from reader: %+v", s)
+	}
+
+	for _, invalid := range []string{
+		"<p>One</p>\n<p>Two<em></p>",
+		"<p>One &unknown;</p>",
+		"<p>One&nbsp;two</p>",
+		"<p>One</p>\n</div>",
+	} {
+		_, err = e.AddSectionFromReader(strings.NewReader(invalid), testSectionTitle, "", "")
+		var syntaxErr *xml.SyntaxError
+		if !errors.As(err, &syntaxErr) {
+			t.Errorf("Expected a syntax error adding %q, got %v", invalid, err)
+		} else if strings.Contains(invalid, "\n") && syntaxErr.Line != 2 {
+			t.Errorf("Expected a syntax error on line 2 adding %q, got %v", invalid, err)
+		}
+	}
+	if len(e.sections) != 1 {
+		t.Errorf("Invalid sections shouldn't be added, got %d sections", len(e.sections))
+	}
+
+	subFilename, err := e.AddSubSectionFromReader(filename, strings.NewReader(body), testSectionTitle, "", "")
+	if err != nil {
+		t.Errorf("Error adding subsection: %s", err)
+	}
+	if len(s.children) != 1 || s.children[0].filename != subFilename {
+		t.Errorf("Subsection not added from reader: %+v", s.children)
+	}
+	_, err = e.AddSubSectionFromReader("doesNotExist.xhtml", strings.NewReader(body), testSectionTitle, "", "")
+	if _, ok := err.(*ParentDoesNotExistError); !ok {
+		t.Errorf("Expected error ParentDoesNotExistError not returned. Returned instead: %+v", err)
+	}
+}
+
+func TestSetNcx(t *testing.T) {
+	e, err := NewEpub(testEpubTitle)
+	if err != nil {
+		t.Error(err)
+	}
+	_, err = e.AddSection(testSectionBody, testSectionTitle, testSectionFilename, "")
+	if err != nil {
+		t.Errorf("Error adding section: %s", err)
+	}
+	e.SetNcx(false)
+
+	tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+
+	if _, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, tocNcxFilename)); err == nil {
+		t.Error("NCX file shouldn't be written when disabled")
+	}
+	if _, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, tocNavFilename)); err != nil {
+		t.Errorf("Unexpected error reading nav file: %s", err)
+	}
+	pkg, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, pkgFilename))
+	if err != nil {
+		t.Errorf("Unexpected error reading package file: %s", err)
+	}
+	if strings.Contains(string(pkg), tocNcxFilename) || !strings.Contains(string(pkg), "<spine>") {
+		t.Errorf("Package file shouldn't reference the NCX file:\n%s", pkg)
+	}
+
+	cleanup(testEpubFilename, tempDir)
+}
+
+func TestSetNavTemplate(t *testing.T) {
+	e, err := NewEpub(testEpubTitle)
+	if err != nil {
+		t.Error(err)
+	}
+	_, err = e.AddSection(testSectionBody, testSectionTitle, testSectionFilename, "")
+	if err != nil {
+		t.Errorf("Error adding section: %s", err)
+	}
+	cssPath, err := e.AddCSS(testCoverCSSSource, testCoverCSSFilename)
+	if err != nil {
+		t.Errorf("Error adding CSS: %s", err)
+	}
+	err = e.SetLandmark(testSectionFilename, LandmarkBodyMatter)
+	if err != nil {
+		t.Errorf("Error setting landmark: %s", err)
+	}
+
+	if err := e.SetNavTemplate(`<div class="toc"><nav epub:type="toc">`, cssPath); err == nil {
+		t.Error("Expected an error for a malformed template")
+	}
+	err = e.SetNavTemplate(`<div class="toc"><nav epub:type="toc" id="toc"><h2>Contents</h2></nav></div><p>Footer</p>`, cssPath)
+	if err != nil {
+		t.Errorf("Error setting nav template: %s", err)
+	}
+
+	tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+
+	contents, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, tocNavFilename))
+	if err != nil {
+		t.Errorf("Unexpected error reading nav file: %s", err)
+	}
+	nav := strings.Join(strings.Fields(string(contents)), "")
+	for _, expected := range []string{
+		`<linkrel="stylesheet"type="text/css"href="css/` + testCoverCSSFilename + `"`,
+		`<divclass="toc"><navepub:type="toc"id="toc"><h2>Contents</h2><ol><li><ahref="xhtml/` + testSectionFilename + `">` + testSectionTitle + `</a></li></ol></nav><navepub:type="landmarks"hidden="hidden">`,
+		`</nav></div><p>Footer</p>`,
+	} {
+		if !strings.Contains(nav, strings.Join(strings.Fields(expected), "")) {
+			t.Errorf("Nav file doesn't contain %q:\n%s", expected, contents)
+		}
+	}
+	cleanup(testEpubFilename, tempDir)
+
+	// A template without a toc nav gets the default one
+	err = e.SetNavTemplate(`<p>Header</p>`, "")
+	if err != nil {
+		t.Errorf("Error setting nav template: %s", err)
+	}
+	tempDir = writeAndExtractEpub(t, e, testEpubFilename)
+	contents, err = storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, tocNavFilename))
+	if err != nil {
+		t.Errorf("Unexpected error reading nav file: %s", err)
+	}
+	nav = strings.Join(strings.Fields(string(contents)), "")
+	if !strings.Contains(nav, `<p>Header</p><navepub:type="toc"><h1>TableofContents</h1><ol>`) || strings.Contains(nav, "stylesheet") {
+		t.Errorf("Nav file doesn't contain the default toc nav:\n%s", contents)
+	}
+	cleanup(testEpubFilename, tempDir)
+}
+
+func TestAddSectionCSS(t *testing.T) {
+	e, err := NewEpub(testEpubTitle)
+	if err != nil {
+		t.Error(err)
+	}
+	var cssPaths []string
+	for _, filename := range []string{"global.css", "section.css", "theme.css"} {
+		cssPath, err := e.AddCSS(testCoverCSSSource, filename)
+		if err != nil {
+			t.Errorf("Error adding CSS: %s", err)
+		}
+		cssPaths = append(cssPaths, cssPath)
+	}
+	_, err = e.AddSection(testSectionBody, testSectionTitle, testSectionFilename, cssPaths[1])
+	if err != nil {
+		t.Errorf("Error adding section: %s", err)
+	}
+	if err := e.AddSectionCSS(testSectionFilename, cssPaths[2]); err != nil {
+		t.Errorf("Error adding section CSS: %s", err)
+	}
+	if err := e.AddSectionCSS(testSectionFilename, cssPaths[2]); err != nil {
+		t.Errorf("Error adding section CSS: %s", err)
+	}
+	err = e.AddSectionCSS("doesNotExist.xhtml", cssPaths[2])
+	if _, ok := err.(*SectionDoesNotExistError); !ok {
+		t.Errorf("Expected error SectionDoesNotExistError not returned. Returned instead: %+v", err)
+	}
+	e.SetGlobalCSS(cssPaths[0])
+	if err := e.SetCover(testImageFromFileSource, ""); err != nil {
+		t.Errorf("Error setting cover: %s", err)
+	}
+
+	tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+	defer cleanup(testEpubFilename, tempDir)
+
+	contents, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, xhtmlFolderName, testSectionFilename))
+	if err != nil {
+		t.Errorf("Unexpected error reading section file: %s", err)
+	}
+	var links []string
+	for _, cssPath := range cssPaths {
+		links = append(links, `<link rel="stylesheet" type="text/css" href="`+cssPath+`"></link>`)
+	}
+	if !strings.Contains(string(contents), strings.Join(links, "\n    ")) {
+		t.Errorf("Section doesn't link its CSS files in order:\n%s", contents)
+	}
+
+	contents, err = storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, xhtmlFolderName, defaultCoverXhtmlFilename))
+	if err != nil {
+		t.Errorf("Unexpected error reading cover file: %s", err)
+	}
+	if strings.Contains(string(contents), cssPaths[0]) {
+		t.Errorf("Cover links the global CSS:\n%s", contents)
+	}
+}
+
+func TestRTLGeneratedPages(t *testing.T) {
+	e, err := NewEpub(testEpubTitle)
+	if err != nil {
+		t.Error(err)
+	}
+	_, err = e.AddSection(`<p id="p1">Text</p>`, testSectionTitle, testSectionFilename, "")
+	if err != nil {
+		t.Errorf("Error adding section: %s", err)
+	}
+	if err := e.AddFootnote(testSectionFilename, "p1", "Note"); err != nil {
+		t.Errorf("Error adding note: %s", err)
+	}
+	e.SetNotePlacement(NotesAsEndnotes)
+	if err := e.SetCover(testImageFromFileSource, ""); err != nil {
+		t.Errorf("Error setting cover: %s", err)
+	}
+	generated := []string{
+		filepath.Join(xhtmlFolderName, defaultCoverXhtmlFilename),
+		filepath.Join(xhtmlFolderName, endnotesFilename),
+		tocNavFilename,
+	}
+
+	testDirection := func(expected bool) {
+		tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+		defer cleanup(testEpubFilename, tempDir)
+		for _, filename := range generated {
+			contents, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, filename))
+			if err != nil {
+				t.Errorf("Unexpected error reading %s: %s", filename, err)
+			}
+			if rtl := strings.Contains(string(contents), `<body dir="rtl">`); rtl != expected {
+				t.Errorf("%s right to left: got %v, expected %v (lang %q, ppd %q):\n%s", filename, rtl, expected, e.lang, e.ppd, contents)
+			}
+		}
+		contents, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, xhtmlFolderName, testSectionFilename))
+		if err != nil {
+			t.Errorf("Unexpected error reading section file: %s", err)
+		}
+		if strings.Contains(string(contents), `dir="rtl"`) {
+			t.Errorf("Section direction changed:\n%s", contents)
+		}
+	}
+
+	testDirection(false)
+	e.SetLang("he-IL")
+	testDirection(true)
+	e.SetLang("ar")
+	testDirection(true)
+	e.SetLang("ja")
+	e.SetPpd("rtl")
+	testDirection(true)
+}
+
+func TestSetSectionHead(t *testing.T) {
+	e, err := NewEpub(testEpubTitle)
+	if err != nil {
+		t.Error(err)
+	}
+	_, err = e.AddSection(testSectionBody, testSectionTitle, testSectionFilename, "")
+	if err != nil {
+		t.Errorf("Error adding section: %s", err)
+	}
+	head := `<meta name="viewport" content="width=1200, height=1600"/>`
+	if err := e.SetSectionHead(testSectionFilename, head); err != nil {
+		t.Errorf("Error setting section head: %s", err)
+	}
+	if err := e.SetSectionHead(testSectionFilename, `<meta name="viewport">`); err == nil {
+		t.Error("Expected an error for a malformed head")
+	}
+	err = e.SetSectionHead("doesNotExist.xhtml", head)
+	if _, ok := err.(*SectionDoesNotExistError); !ok {
+		t.Errorf("Expected error SectionDoesNotExistError not returned. Returned instead: %+v", err)
+	}
+
+	tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+	contents, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, xhtmlFolderName, testSectionFilename))
+	if err != nil {
+		t.Errorf("Unexpected error reading section file: %s", err)
+	}
+	expected := `<title dir="auto">` + testSectionTitle + `</title>` + head + `</head>`
+	if !strings.Contains(strings.Join(strings.Fields(string(contents)), ""), strings.Join(strings.Fields(expected), "")) {
+		t.Errorf("Section head doesn't contain %q:\n%s", head, contents)
+	}
+	cleanup(testEpubFilename, tempDir)
+
+	if err := e.SetSectionHead(testSectionFilename, ""); err != nil {
+		t.Errorf("Error removing section head: %s", err)
+	}
+	var b bytes.Buffer
+	if err := e.WriteSection(testSectionFilename, &b); err != nil {
+		t.Errorf("Error writing section: %s", err)
+	}
+	if strings.Contains(b.String(), "viewport") {
+		t.Errorf("Section head not removed:\n%s", b.String())
+	}
+}
+
+func TestSetSectionLang(t *testing.T) {
+	e, err := NewEpub(testEpubTitle)
+	if err != nil {
+		t.Error(err)
+	}
+	_, err = e.AddSection(testSectionBody, testSectionTitle, testSectionFilename, "")
+	if err != nil {
+		t.Errorf("Error adding section: %s", err)
+	}
+	_, err = e.AddSection(`<p>قصيدة</p>`, "Poem", "poem.xhtml", "")
+	if err != nil {
+		t.Errorf("Error adding section: %s", err)
+	}
+	if err := e.SetSectionLang("poem.xhtml", "ar", "rtl"); err != nil {
+		t.Errorf("Error setting section language: %s", err)
+	}
+	if err := e.SetSectionLang("poem.xhtml", "ar", "right"); err == nil {
+		t.Error("Expected an error for an invalid direction")
+	}
+	err = e.SetSectionLang("doesNotExist.xhtml", "ar", "")
+	if _, ok := err.(*SectionDoesNotExistError); !ok {
+		t.Errorf("Expected error SectionDoesNotExistError not returned. Returned instead: %+v", err)
+	}
+
+	tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+	defer cleanup(testEpubFilename, tempDir)
+
+	contents, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, xhtmlFolderName, "poem.xhtml"))
+	if err != nil {
+		t.Errorf("Unexpected error reading section file: %s", err)
+	}
+	for _, expected := range []string{
+		`lang="ar" xml:lang="ar" dir="rtl">`,
+		`<body dir="rtl">`,
+	} {
+		if !strings.Contains(string(contents), expected) {
+			t.Errorf("Section doesn't contain %q:\n%s", expected, contents)
+		}
+	}
+	contents, err = storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, xhtmlFolderName, testSectionFilename))
+	if err != nil {
+		t.Errorf("Unexpected error reading section file: %s", err)
+	}
+	if strings.Contains(string(contents), `lang=`) || strings.Contains(string(contents), `dir="rtl"`) {
+		t.Errorf("Section without language has one:\n%s", contents)
+	}
+}
+
+func TestAddRawSection(t *testing.T) {
+	e, err := NewEpub(testEpubTitle)
+	if err != nil {
+		t.Error(err)
+	}
+	document := `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops" class="crafted">
+<head><title>Raw  section</title><meta name="viewport" content="width=600, height=800"/></head>
+<body><svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 10 10"><rect width="10" height="10"/></svg></body>
+</html>
+`
+	rawPath, err := e.AddRawSection(document, "raw", true, true)
+	if err != nil {
+		t.Errorf("Error adding raw section: %s", err)
+	}
+	if rawPath != "raw.xhtml" {
+		t.Errorf("Unexpected raw section path: %s", rawPath)
+	}
+	popupPath, err := e.AddRawSection(document, "", false, false)
+	if err != nil {
+		t.Errorf("Error adding raw section: %s", err)
+	}
+	if _, err := e.AddRawSection(`<html><body><p>Unclosed</body></html>`, "", true, true); err == nil {
+		t.Error("Expected an error for a malformed document")
+	}
+	if _, err := e.AddRawSection(`<body></body>`, "", true, true); err == nil {
+		t.Error("Expected an error for a document without <html> root")
+	}
+	if _, err := e.AddRawSection(document, "", false, true); err == nil {
+		t.Error("Expected an error for a section in the TOC but out of the spine")
+	}
+	var rawErr *RawSectionError
+	for name, err := range map[string]error{
+		"AddPageBreak":   e.AddPageBreak(rawPath, "1", ""),
+		"AddFootnote":    e.AddFootnote(rawPath, "note", "Note"),
+		"SetSectionHead": e.SetSectionHead(rawPath, `<meta name="x" content="y"/>`),
+		"SetSectionLang": e.SetSectionLang(rawPath, "fr", "ltr"),
+		"AddSectionCSS":  e.AddSectionCSS(rawPath, "../css/style.css"),
+	} {
+		if !errors.As(err, &rawErr) {
+			t.Errorf("Expected RawSectionError from %s, got %v", name, err)
+		}
+	}
+
+	tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+	defer cleanup(testEpubFilename, tempDir)
+
+	contents, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, xhtmlFolderName, rawPath))
+	if err != nil {
+		t.Errorf("Unexpected error reading section file: %s", err)
+	}
+	if string(contents) != document {
+		t.Errorf("Raw section changed:\n%s", contents)
+	}
+
+	contents, err = storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, pkgFilename))
+	if err != nil {
+		t.Errorf("Unexpected error reading package file: %s", err)
+	}
+	for _, expected := range []string{
+		`<item id="raw.xhtml" href="xhtml/raw.xhtml" media-type="application/xhtml+xml" properties="svg"></item>`,
+		`<item id="` + popupPath + `" href="xhtml/` + popupPath + `" media-type="application/xhtml+xml" properties="svg"></item>`,
+		`<itemref idref="raw.xhtml"></itemref>`,
+	} {
+		if !strings.Contains(string(contents), expected) {
+			t.Errorf("Package file doesn't contain %q:\n%s", expected, contents)
+		}
+	}
+	if strings.Contains(string(contents), `<itemref idref="`+popupPath+`"`) {
+		t.Errorf("Raw section out of the spine added to it:\n%s", contents)
+	}
+
+	contents, err = storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, tocNavFilename))
+	if err != nil {
+		t.Errorf("Unexpected error reading nav file: %s", err)
+	}
+	if !strings.Contains(string(contents), `<a href="xhtml/raw.xhtml">Raw section</a>`) || strings.Contains(string(contents), popupPath) {
+		t.Errorf("Unexpected TOC for raw sections:\n%s", contents)
+	}
+}
+
+func TestSetFS(t *testing.T) {
+	testCSS := []byte("body { color: red; }\n")
+	fsys := fstest.MapFS{
+		"assets/style.css": &fstest.MapFile{Data: testCSS},
+	}
+	e, err := NewEpub(testEpubTitle)
+	if err != nil {
+		t.Error(err)
+	}
+	e.SetFS(fsys)
+	cssPath, err := e.AddCSS("assets/style.css", "")
+	if err != nil {
+		t.Errorf("Error adding CSS from fs.FS: %s", err)
+	}
+	if cssPath != "../"+CSSFolderName+"/style.css" {
+		t.Errorf("Unexpected CSS path: %s", cssPath)
+	}
+	if _, err := e.AddImage(testImageFromFileSource, ""); err == nil {
+		t.Error("Expected an error adding an image missing from the fs.FS")
+	} else if _, ok := err.(*FileRetrievalError); !ok {
+		t.Errorf("Expected error FileRetrievalError not returned. Returned instead: %+v", err)
+	}
+
+	tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+	defer cleanup(testEpubFilename, tempDir)
+
+	contents, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, xhtmlFolderName, cssPath))
+	if err != nil {
+		t.Errorf("Unexpected error reading CSS file from EPUB: %s", err)
+	}
+	if !bytes.Equal(contents, testCSS) {
+		t.Errorf("CSS file contents don't match: %q", contents)
+	}
+
+	e.SetFS(nil)
+	if _, err := e.AddImage(testImageFromFileSource, ""); err != nil {
+		t.Errorf("Error adding image from the OS file system: %s", err)
+	}
+}
+
+func TestRemoveResources(t *testing.T) {
+	e, err := NewEpub(testEpubTitle)
+	if err != nil {
+		t.Error(err)
+	}
+	imagePath, err := e.AddImage(testImageFromFileSource, testImageFromFileFilename)
+	if err != nil {
+		t.Errorf("Error adding image: %s", err)
+	}
+	_, err = e.AddImage(testImageFromFileSource, "kept.png")
+	if err != nil {
+		t.Errorf("Error adding image: %s", err)
+	}
+	_, err = e.AddFont(testFontFromFileSource, "redacted-script-regular.ttf")
+	if err != nil {
+		t.Errorf("Error adding font: %s", err)
+	}
+	coverImagePath, err := e.AddImage(testImageFromFileSource, "cover.png")
+	if err != nil {
+		t.Errorf("Error adding image: %s", err)
+	}
+	if err := e.SetCover(coverImagePath, ""); err != nil {
+		t.Errorf("Error setting cover: %s", err)
+	}
+
+	if err := e.RemoveImage(imagePath); err != nil {
+		t.Errorf("Error removing image by path: %s", err)
+	}
+	if err := e.RemoveFont("redacted-script-regular.ttf"); err != nil {
+		t.Errorf("Error removing font by filename: %s", err)
+	}
+	err = e.RemoveImage(imagePath)
+	if _, ok := err.(*ResourceDoesNotExistError); !ok {
+		t.Errorf("Expected error ResourceDoesNotExistError not returned. Returned instead: %+v", err)
+	}
+	if err := e.RemoveImage(coverImagePath); err == nil {
+		t.Error("Expected an error removing the cover image")
+	}
+	// The filename can be used again
+	if _, err := e.AddImage(testImageFromFileSource, testImageFromFileFilename); err != nil {
+		t.Errorf("Error adding image with the filename of a removed one: %s", err)
+	}
+	if err := e.RemoveImage(testImageFromFileFilename); err != nil {
+		t.Errorf("Error removing image: %s", err)
+	}
+
+	tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+	defer cleanup(testEpubFilename, tempDir)
+
+	pkg, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, pkgFilename))
+	if err != nil {
+		t.Errorf("Unexpected error reading package file: %s", err)
+	}
+	for _, removed := range []string{testImageFromFileFilename, "redacted-script-regular.ttf"} {
+		if strings.Contains(string(pkg), removed) {
+			t.Errorf("Package file lists the removed resource %s:\n%s", removed, pkg)
+		}
+	}
+	if !strings.Contains(string(pkg), "kept.png") {
+		t.Errorf("Package file doesn't list the resource kept:\n%s", pkg)
+	}
+}
+
+func TestResources(t *testing.T) {
+	e, err := NewEpub(testEpubTitle)
+	if err != nil {
+		t.Error(err)
+	}
+	if images := e.Images(); len(images) != 0 {
+		t.Errorf("Unexpected images in a new EPUB: %+v", images)
+	}
+	imagePath, err := e.AddImage(testImageFromFileSource, "b.png")
+	if err != nil {
+		t.Errorf("Error adding image: %s", err)
+	}
+	if _, err := e.AddImage(testImageFromFileSource, "a.png"); err != nil {
+		t.Errorf("Error adding image: %s", err)
+	}
+	cssPath, err := e.AddCSS(testCoverCSSSource, testCoverCSSFilename)
+	if err != nil {
+		t.Errorf("Error adding CSS: %s", err)
+	}
+
+	images := e.Images()
+	expected := []Resource{
+		{Filename: "a.png", Source: testImageFromFileSource, Path: "../images/a.png"},
+		{Filename: "b.png", Source: testImageFromFileSource, Path: imagePath},
+	}
+	if len(images) != len(expected) {
+		t.Fatalf("Images doesn't match\nGot: %+v\nExpected: %+v", images, expected)
+	}
+	for i := range expected {
+		if images[i] != expected[i] {
+			t.Errorf("Image doesn't match\nGot: %+v\nExpected: %+v", images[i], expected[i])
+		}
+	}
+	css := e.CSS()
+	if len(css) != 1 || css[0].Filename != testCoverCSSFilename || css[0].Path != cssPath {
+		t.Errorf("Unexpected CSS files: %+v", css)
+	}
+	if len(e.Fonts()) != 0 || len(e.Videos()) != 0 || len(e.Audios()) != 0 {
+		t.Error("Unexpected fonts, videos or audios")
+	}
+}
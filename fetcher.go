@@ -0,0 +1,72 @@
+package epub
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+)
+
+// Fetcher retrieves the content of the resources whose source has a given URL
+// scheme, e.g. s3://bucket/key, in place of go-epub. See RegisterFetcher.
+//
+// Fetch returns the content of source and its media type, or an empty string
+// to have it detected from the content as for the other resources. It should
+// stop when ctx is done.
+type Fetcher interface {
+	Fetch(ctx context.Context, source string) (io.ReadCloser, string, error)
+}
+
+// FetcherFunc is a function used as a Fetcher.
+type FetcherFunc func(ctx context.Context, source string) (io.ReadCloser, string, error)
+
+// Fetch calls f(ctx, source).
+func (f FetcherFunc) Fetch(ctx context.Context, source string) (io.ReadCloser, string, error) {
+	return f(ctx, source)
+}
+
+// RegisterFetcher sets the Fetcher retrieving the resources whose source has
+// the URL scheme scheme, e.g. "s3" for s3://bucket/key, so that they can be
+// added with AddImage and the like. Schemes are case insensitive; registering
+// a fetcher for "http" or "https" replaces the HTTP client for them. A nil
+// fetcher restores the default handling of the scheme.
+//
+// The fetcher is called when the resource is added, to check that it exists,
+// and when the EPUB is written. The content of its resources isn't read when
+// they are added, e.g. to deduplicate them. When SetDownloadConcurrency is
+// over 1, it must be safe for concurrent use.
+func (e *Epub) RegisterFetcher(scheme string, fetcher Fetcher) error {
+	scheme = strings.ToLower(scheme)
+	u, err := url.Parse(scheme + ":")
+	if err != nil || u.Scheme != scheme || len(scheme) < 2 {
+		return fmt.Errorf("can't register fetcher: invalid scheme %q", scheme)
+	}
+	if scheme == "data" || scheme+":" == readerSourcePrefix {
+		return fmt.Errorf("can't register fetcher: scheme %q is reserved", scheme)
+	}
+	e.Lock()
+	defer e.Unlock()
+	if fetcher == nil {
+		delete(e.fetchers, scheme)
+		return nil
+	}
+	if e.fetchers == nil {
+		e.fetchers = make(map[string]Fetcher)
+	}
+	e.fetchers[scheme] = fetcher
+	return nil
+}
+
+// fetcher returns the Fetcher registered for the scheme of mediaSource, or nil
+// if there is none
+func (g grabber) fetcher(mediaSource string) Fetcher {
+	if len(g.fetchers) == 0 || isWindowsPath(mediaSource) {
+		return nil
+	}
+	scheme, _, ok := strings.Cut(mediaSource, ":")
+	if !ok {
+		return nil
+	}
+	return g.fetchers[strings.ToLower(scheme)]
+}
@@ -0,0 +1,100 @@
+package epub
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-shiori/go-epub/internal/storage"
+)
+
+func TestRegisterFetcher(t *testing.T) {
+	image, err := os.ReadFile(testImageFromFileSource)
+	if err != nil {
+		t.Fatal(err)
+	}
+	objects := map[string][]byte{
+		"s3://bucket/gopher.png":      image,
+		"S3://bucket/gopher-copy.png": image,
+		"https://example.com/a.png":   image,
+	}
+	var fetched []string
+	fetcher := FetcherFunc(func(ctx context.Context, source string) (io.ReadCloser, string, error) {
+		fetched = append(fetched, source)
+		content, ok := objects[source]
+		if !ok {
+			return nil, "", errors.New("no such object")
+		}
+		mediaType := ""
+		if strings.HasSuffix(source, "copy.png") {
+			mediaType = "image/x-gopher"
+		}
+		return io.NopCloser(bytes.NewReader(content)), mediaType, nil
+	})
+
+	e, err := NewEpub(testEpubTitle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, scheme := range []string{"", "c", "data", "reader", "bad scheme"} {
+		if err := e.RegisterFetcher(scheme, fetcher); err == nil {
+			t.Errorf("Expected an error registering a fetcher for %q", scheme)
+		}
+	}
+	if err := e.RegisterFetcher("S3", fetcher); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.RegisterFetcher("https", fetcher); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := e.AddImage("s3://bucket/missing.png", ""); err == nil {
+		t.Error("Expected an error adding a missing object")
+	}
+	for _, source := range []string{"s3://bucket/gopher.png", "S3://bucket/gopher-copy.png", "https://example.com/a.png"} {
+		if _, err := e.AddImage(source, ""); err != nil {
+			t.Fatalf("Error adding %s: %s", source, err)
+		}
+	}
+
+	fetched = nil
+	tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+	defer cleanup(testEpubFilename, tempDir)
+	if len(fetched) != 3 {
+		t.Errorf("Expected 3 fetches while writing, got %v", fetched)
+	}
+
+	contents, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, ImageFolderName, "gopher.png"))
+	if err != nil {
+		t.Fatalf("Unexpected error reading image: %s", err)
+	}
+	if !bytes.Equal(contents, image) {
+		t.Error("Image content differs from the fetched one")
+	}
+	pkg, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, pkgFilename))
+	if err != nil {
+		t.Fatalf("Unexpected error reading package file: %s", err)
+	}
+	for _, item := range []string{
+		`href="images/gopher.png" media-type="image/png"`,
+		`href="images/gopher-copy.png" media-type="image/x-gopher"`,
+		`href="images/a.png" media-type="image/png"`,
+	} {
+		if !strings.Contains(string(pkg), item) {
+			t.Errorf("Package file doesn't contain %s\nGot: %s", item, pkg)
+		}
+	}
+
+	// Removing the fetcher restores the default handling
+	if err := e.RegisterFetcher("s3", nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.AddImage("s3://bucket/gopher.png", "other.png"); err == nil {
+		t.Error("Expected an error adding an object without fetcher")
+	}
+}
@@ -2,10 +2,13 @@ package epub
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
@@ -18,10 +21,63 @@ import (
 // if onlyChecl is true, the methods will not perform actual grab to spare memory and bandwidth
 type grabber struct {
 	*http.Client
+	// spool, if set, keeps big resources out of the storage while fetching
+	spool *spool
+	// ctx, if set, cancels the retrieval when it is done
+	ctx context.Context
+	// maxSize, if set, is the size in bytes beyond which fetchMedia fails
+	maxSize int64
+	// readers holds the content of the resources added from a reader, by
+	// source
+	readers map[string]*readerSource
+	// fsys, if set, holds the local files in place of the OS file system
+	fsys fs.FS
+	// retryPolicy sets how the fetches of URLs are retried
+	retryPolicy RetryPolicy
+	// fetchers retrieve the resources in place of the handlers, by URL scheme
+	fetchers map[string]Fetcher
+	// downloadCache, if set, keeps the remote resources between builds
+	downloadCache *downloadCache
+	// verifyMediaTypes, if set, makes fetchMedia fail for the resources whose
+	// content doesn't match their media type
+	verifyMediaTypes bool
+}
+
+// grabber returns a grabber retrieving the resources of the EPUB, stopping when
+// ctx is done
+func (e *Epub) grabber(ctx context.Context) grabber {
+	g := grabber{
+		Client:        e.httpClient(),
+		spool:         e.spool,
+		ctx:           ctx,
+		readers:       e.readers,
+		fsys:          e.fsys,
+		retryPolicy:   e.retryPolicy,
+		fetchers:      e.fetchers,
+		downloadCache: e.downloadCache,
+	}
+	if e.limits != nil {
+		g.maxSize = e.limits.MaxResourceSize
+		g.verifyMediaTypes = e.limits.VerifyMediaTypes
+	}
+	return g
+}
+
+// context returns the context of the retrieval
+func (g grabber) context() context.Context {
+	if g.ctx == nil {
+		return context.Background()
+	}
+	return g.ctx
 }
 
 func detectMediaType(mediaSource string) string {
-	if strings.HasPrefix(mediaSource, "http://") || strings.HasPrefix(mediaSource, "https://") {
+	if isWindowsPath(mediaSource) {
+		// Checked first, as a drive letter looks like a URL scheme
+		return "File"
+	}
+
+	if hasPrefixFold(mediaSource, "http://") || hasPrefixFold(mediaSource, "https://") {
 		return "URL"
 	}
 
@@ -29,10 +85,91 @@ func detectMediaType(mediaSource string) string {
 		return "DataURL"
 	}
 
+	if strings.HasPrefix(mediaSource, readerSourcePrefix) {
+		return "Reader"
+	}
+
 	return "File"
 }
 
+// isWindowsPath returns whether mediaSource is an absolute Windows path, with
+// a drive letter (C:\dir\file or C:/dir/file) or UNC (\\server\share\file),
+// whatever the OS
+func isWindowsPath(mediaSource string) bool {
+	if strings.HasPrefix(mediaSource, `\\`) {
+		return true
+	}
+	return len(mediaSource) >= 3 && isDriveLetter(mediaSource[0]) && mediaSource[1] == ':' &&
+		(mediaSource[2] == '\\' || mediaSource[2] == '/')
+}
+
+func isDriveLetter(c byte) bool {
+	return ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z')
+}
+
+// hasPrefixFold is strings.HasPrefix ignoring case, for URL schemes
+func hasPrefixFold(s string, prefix string) bool {
+	return len(s) >= len(prefix) && strings.EqualFold(s[:len(prefix)], prefix)
+}
+
+// isFileURL returns whether mediaSource is a file:// URL
+func isFileURL(mediaSource string) bool {
+	return hasPrefixFold(mediaSource, "file:")
+}
+
+// localPath returns the path of a local source on the local file system: file
+// URLs are converted to paths, e.g. file:///C:/dir/file%201.png to
+// C:\dir\file 1.png and file://server/share/file to \\server\share\file on
+// Windows; other sources are returned unchanged.
+func localPath(mediaSource string) string {
+	if !isFileURL(mediaSource) {
+		return mediaSource
+	}
+	u, err := url.Parse(mediaSource)
+	if err != nil {
+		return mediaSource
+	}
+	if u.Opaque != "" {
+		// file:dir/file, relative
+		p, err := url.PathUnescape(u.Opaque)
+		if err != nil {
+			return mediaSource
+		}
+		return filepath.FromSlash(p)
+	}
+	p := u.Path
+	if u.Host != "" && !strings.EqualFold(u.Host, "localhost") {
+		// UNC path
+		return filepath.FromSlash("//" + u.Host + p)
+	}
+	if len(p) >= 3 && p[0] == '/' && isDriveLetter(p[1]) && p[2] == ':' {
+		// The leading slash of file:///C:/dir/file
+		p = p[1:]
+	}
+	return filepath.FromSlash(p)
+}
+
+// localBase returns the last element of the path of a local source, the
+// separators of Windows paths being recognized whatever the OS
+func localBase(mediaSource string) string {
+	p := localPath(mediaSource)
+	if isWindowsPath(p) {
+		p = strings.ReplaceAll(p, `\`, "/")
+	}
+	return filepath.Base(p)
+}
+
 func (g grabber) checkMedia(mediaSource string) error {
+	if err := g.context().Err(); err != nil {
+		return &FileRetrievalError{Source: mediaSource, Err: err}
+	}
+	if fetcher := g.fetcher(mediaSource); fetcher != nil {
+		source, _, err := g.fetch(fetcher, mediaSource)
+		if err != nil {
+			return err
+		}
+		return source.Close()
+	}
 	var fetchErrors []error // Declare fetchErrors variable
 	var f func(string, bool) (io.ReadCloser, error)
 	switch detectMediaType(mediaSource) {
@@ -40,6 +177,8 @@ func (g grabber) checkMedia(mediaSource string) error {
 		f = g.httpHandler
 	case "DataURL":
 		f = g.dataURLHandler
+	case "Reader":
+		f = g.readerHandler
 	default:
 		f = g.localHandler
 	}
@@ -64,50 +203,50 @@ func (g grabber) fetchMedia(mediaSource, mediaFolderPath, mediaFilename string)
 		mediaFolderPath,
 		mediaFilename,
 	)
-	// failfast, create the output file handler at the begining, if we cannot write the file, bail out
-	w, err := filesystem.Create(mediaFilePath)
+	// The whole download is retried, so the requests of each attempt aren't
+	// retried themselves
+	once := g
+	once.retryPolicy.MaxRetries = 0
+	var declared, served string
+	err = g.retry(mediaSource, func() (err error) {
+		declared, served, err = once.download(mediaSource, mediaFilePath)
+		return err
+	})
 	if err != nil {
-		return "", fmt.Errorf("unable to create file %s: %s", mediaFilePath, err)
-	}
-	defer w.Close()
-	var source io.ReadCloser
-	fetchErrors := make([]error, 0)
-	for _, f := range []func(string, bool) (io.ReadCloser, error){
-		g.localHandler,
-		g.httpHandler,
-		g.dataURLHandler,
-	} {
-		var err error
-		source, err = f(mediaSource, false)
-		if err != nil {
-			fetchErrors = append(fetchErrors, err)
-			continue
-		}
-		break
-	}
-	if source == nil {
-		return "", &FileRetrievalError{Source: mediaSource, Err: fetchError(fetchErrors)}
-
+		return "", err
 	}
-	defer source.Close()
-
-	_, err = io.Copy(w, source)
-	if err != nil {
-		// There shouldn't be any problem with the writer, but the reader
-		// might have an issue
-		return "", &FileRetrievalError{Source: mediaSource, Err: err}
+	if declared != "" && !g.verifyMediaTypes {
+		return declared, nil
 	}
 
 	// Detect the mediaType
-	r, err := filesystem.Open(mediaFilePath)
+	r, err := g.spool.open(mediaFilePath)
 	if err != nil {
 		return "", err
 	}
 	defer r.Close()
+	return g.detectMedia(mediaSource, filepath.Base(mediaFolderPath), mediaFilename, r, declared, served)
+}
+
+// detectMedia returns the media type of the resource of mediaSource, whose
+// content is read from r, given the media type declared by its Fetcher and
+// the one served with it, if any
+func (g grabber) detectMedia(mediaSource string, mediaFolderName string, mediaFilename string, r io.Reader, declared string, served string) (string, error) {
 	mime, err := mimetype.DetectReader(r)
 	if err != nil {
 		return "", fmt.Errorf("unable to detect media type: %w", err)
 	}
+	if g.verifyMediaTypes {
+		if declared != "" {
+			served = declared
+		}
+		if err := verifyMediaType(mediaSource, mediaFolderName, mime, served); err != nil {
+			return "", err
+		}
+	}
+	if declared != "" {
+		return declared, nil
+	}
 
 	// Is it CSS?
 	mtype := mime.String()
@@ -119,28 +258,191 @@ func (g grabber) fetchMedia(mediaSource, mediaFolderPath, mediaFilename string)
 	return mtype, nil
 }
 
+// download copies the content of mediaSource to the file at mediaFilePath. It
+// returns the media type given by the Fetcher of mediaSource, if any, and the
+// one declared by the server of a URL, if any.
+func (g grabber) download(mediaSource, mediaFilePath string) (string, string, error) {
+	// A previous attempt may have been spooled
+	g.spool.remove(mediaFilePath)
+	// failfast, create the output file handler at the begining, if we cannot write the file, bail out
+	w, err := filesystem.Create(mediaFilePath)
+	if err != nil {
+		return "", "", fmt.Errorf("unable to create file %s: %s", mediaFilePath, err)
+	}
+	defer w.Close()
+	source, declared, served, err := g.openMedia(mediaSource)
+	if err != nil {
+		return "", "", err
+	}
+	defer source.Close()
+	var src io.Reader = source
+	if g.maxSize > 0 {
+		src = &limitedReader{r: source, max: g.maxSize, source: mediaSource}
+	}
+
+	if g.spool != nil {
+		// Large resources are moved out of the storage while being copied
+		sw := g.spool.writer(mediaFilePath, w)
+		_, err = io.Copy(sw, src)
+		if closeErr := sw.Close(); err == nil {
+			err = closeErr
+		}
+	} else {
+		_, err = io.Copy(w, src)
+	}
+	if err != nil {
+		// There shouldn't be any problem with the writer, but the reader
+		// might have an issue
+		return "", "", &FileRetrievalError{Source: mediaSource, Err: err}
+	}
+	return declared, served, nil
+}
+
+// openMedia returns a reader for the content of mediaSource like open, the
+// media type given by its Fetcher, if any, and the one declared by the server
+// of a URL, if any
+func (g grabber) openMedia(mediaSource string) (io.ReadCloser, string, string, error) {
+	if fetcher := g.fetcher(mediaSource); fetcher != nil {
+		source, declared, err := g.fetch(fetcher, mediaSource)
+		return source, declared, "", err
+	}
+	source, err := g.open(mediaSource)
+	if err != nil {
+		return nil, "", "", err
+	}
+	served := ""
+	if r, ok := source.(cancelReadCloser); ok {
+		served = r.contentType
+	}
+	return source, "", served, nil
+}
+
+// open returns a reader for the content of mediaSource, which can be a URL, a
+// local path or an inline dataurl
+func (g grabber) open(mediaSource string) (io.ReadCloser, error) {
+	if err := g.context().Err(); err != nil {
+		return nil, &FileRetrievalError{Source: mediaSource, Err: err}
+	}
+	if fetcher := g.fetcher(mediaSource); fetcher != nil {
+		source, _, err := g.fetch(fetcher, mediaSource)
+		return source, err
+	}
+	fetchErrors := make([]error, 0)
+	if detectMediaType(mediaSource) == "Reader" {
+		source, err := g.readerHandler(mediaSource, false)
+		if err != nil {
+			return nil, &FileRetrievalError{Source: mediaSource, Err: err}
+		}
+		return source, nil
+	}
+	for _, f := range []func(string, bool) (io.ReadCloser, error){
+		g.localHandler,
+		g.httpHandler,
+		g.dataURLHandler,
+	} {
+		source, err := f(mediaSource, false)
+		if err != nil {
+			fetchErrors = append(fetchErrors, err)
+			continue
+		}
+		return source, nil
+	}
+	return nil, &FileRetrievalError{Source: mediaSource, Err: fetchError(fetchErrors)}
+}
+
+// fetch retrieves mediaSource with fetcher
+func (g grabber) fetch(fetcher Fetcher, mediaSource string) (io.ReadCloser, string, error) {
+	source, mediaType, err := fetcher.Fetch(g.context(), mediaSource)
+	if err != nil {
+		return nil, "", &FileRetrievalError{Source: mediaSource, Err: err}
+	}
+	if source == nil {
+		return nil, "", &FileRetrievalError{Source: mediaSource, Err: errors.New("no content returned by fetcher")}
+	}
+	return source, mediaType, nil
+}
+
 func (g grabber) httpHandler(mediaSource string, onlyCheck bool) (io.ReadCloser, error) {
-	var resp *http.Response
-	var err error
+	var body io.ReadCloser
+	err := g.retry(mediaSource, func() (err error) {
+		body, err = g.request(mediaSource, onlyCheck)
+		return err
+	})
+	return body, err
+}
+
+// request sends a single request for mediaSource and returns the body of the
+// response, which must be closed
+func (g grabber) request(mediaSource string, onlyCheck bool) (io.ReadCloser, error) {
+	method := http.MethodGet
 	if onlyCheck {
-		resp, err = g.Head(mediaSource)
-	} else {
-		resp, err = g.Get(mediaSource)
+		method = http.MethodHead
+	}
+	ctx, cancel := g.context(), context.CancelFunc(func() {})
+	if g.retryPolicy.Timeout > 0 {
+		// The timeout covers the download of the body too
+		ctx, cancel = context.WithTimeout(ctx, g.retryPolicy.Timeout)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, mediaSource, nil)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	var cached *downloadCacheEntry
+	if g.downloadCache != nil && !onlyCheck {
+		if cached = g.downloadCache.lookup(mediaSource); cached != nil {
+			cached.setConditional(req)
+		}
 	}
+	resp, err := g.Do(req)
 	if err != nil {
+		cancel()
 		return nil, err
 	}
+	if cached != nil && resp.StatusCode == http.StatusNotModified {
+		resp.Body.Close()
+		cancel()
+		return g.downloadCache.open(cached)
+	}
 	if resp.StatusCode > 400 {
-		return nil, errors.New("cannot get file, bad return code")
+		resp.Body.Close()
+		cancel()
+		return nil, &httpStatusError{StatusCode: resp.StatusCode}
+	}
+	if g.maxSize > 0 && resp.ContentLength > g.maxSize {
+		// The resource is known to be too big before being downloaded
+		resp.Body.Close()
+		cancel()
+		return nil, &LimitExceededError{Limit: "MaxResourceSize", Max: g.maxSize, Name: mediaSource}
 	}
-	return resp.Body, nil
+	body := resp.Body
+	if g.downloadCache != nil && !onlyCheck && resp.StatusCode == http.StatusOK {
+		body = g.downloadCache.store(mediaSource, resp)
+	}
+	return cancelReadCloser{ReadCloser: body, cancel: cancel, contentType: resp.Header.Get("Content-Type")}, nil
 }
 
 func (g grabber) localHandler(mediaSource string, onlyCheck bool) (io.ReadCloser, error) {
+	mediaSource = localPath(mediaSource)
+	if g.fsys != nil {
+		name := filepath.ToSlash(mediaSource)
+		if onlyCheck {
+			info, err := fs.Stat(g.fsys, name)
+			if err != nil {
+				return nil, err
+			}
+			return nil, g.checkSize(mediaSource, info.Size())
+		}
+		return g.fsys.Open(name)
+	}
 	if onlyCheck {
-		if _, err := os.Stat(mediaSource); os.IsNotExist(err) {
+		info, err := os.Stat(mediaSource)
+		if os.IsNotExist(err) {
 			return nil, err
 		}
+		if err == nil {
+			return nil, g.checkSize(mediaSource, info.Size())
+		}
 		return nil, nil
 	}
 	return os.Open(mediaSource)
@@ -167,3 +469,9 @@ func (f fetchError) Error() string {
 	}
 	return message
 }
+
+// Unwrap returns the errors of the handlers, so that errors.Is finds e.g.
+// context.Canceled among them
+func (f fetchError) Unwrap() []error {
+	return f
+}
@@ -1,15 +1,19 @@
 package epub
 
 import (
+	"bytes"
 	"fmt"
 	"io"
 	"io/fs"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
 	"testing"
+
+	"github.com/go-shiori/go-epub/internal/storage"
 )
 
 var golangFavicon = strings.Replace(`AAABAAEAEBAAAAEAIABoBAAAFgAAACgAAAAQAAAAIAAAAAEAIAAAAAAAAAAAAAAAAAAAAAAAAAAA
@@ -165,7 +169,7 @@ func testFetchMedia(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			g := &grabber{http.DefaultClient}
+			g := &grabber{Client: http.DefaultClient}
 			gotMediaType, err := g.fetchMedia(tt.args.mediaSource, tt.args.mediaFolderPath, tt.args.mediaFilename)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("fetchMedia() error = %v, wantErr %v", err, tt.wantErr)
@@ -184,3 +188,99 @@ func testFetchMedia(t *testing.T) {
 		})
 	}
 }
+
+func TestDetectMediaType(t *testing.T) {
+	tests := []struct {
+		source string
+		want   string
+	}{
+		{"http://example.com/image.png", "URL"},
+		{"HTTPS://example.com/image.png", "URL"},
+		{"data:image/png;base64,AAAA", "DataURL"},
+		{"reader:images/image.png", "Reader"},
+		{"testdata/image.png", "File"},
+		{"/home/user/image.png", "File"},
+		{`C:\Users\user\image.png`, "File"},
+		{"c:/Users/user/image.png", "File"},
+		{`\\server\share\image.png`, "File"},
+		{"file:///home/user/image.png", "File"},
+		{"FILE:///C:/Users/user/image.png", "File"},
+	}
+	for _, tt := range tests {
+		if got := detectMediaType(tt.source); got != tt.want {
+			t.Errorf("detectMediaType(%q) = %s, want %s", tt.source, got, tt.want)
+		}
+	}
+}
+
+func TestLocalPath(t *testing.T) {
+	tests := []struct {
+		source string
+		want   string
+	}{
+		{"testdata/image.png", "testdata/image.png"},
+		{`C:\Users\user\image.png`, `C:\Users\user\image.png`},
+		{"http://example.com/image.png", "http://example.com/image.png"},
+		{"file:///home/user/my%20image.png", filepath.FromSlash("/home/user/my image.png")},
+		{"file://localhost/home/user/image.png", filepath.FromSlash("/home/user/image.png")},
+		{"file:///C:/Users/user/image.png", filepath.FromSlash("C:/Users/user/image.png")},
+		{"file://server/share/image.png", filepath.FromSlash("//server/share/image.png")},
+		{"file:testdata/image.png", filepath.FromSlash("testdata/image.png")},
+	}
+	for _, tt := range tests {
+		if got := localPath(tt.source); got != tt.want {
+			t.Errorf("localPath(%q) = %s, want %s", tt.source, got, tt.want)
+		}
+	}
+
+	for source, want := range map[string]string{
+		`C:\Users\user\image.png`:              "image.png",
+		`\\server\share\image.png`:             "image.png",
+		"file:///C:/Users/user/my%20image.png": "my image.png",
+		"testdata/image.png":                   "image.png",
+	} {
+		if got := localBase(source); got != want {
+			t.Errorf("localBase(%q) = %s, want %s", source, got, want)
+		}
+	}
+}
+
+func TestAddImageFromFileURL(t *testing.T) {
+	e, err := NewEpub(testEpubTitle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	abs, err := filepath.Abs(testImageFromFileSource)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := filepath.ToSlash(abs)
+	if !strings.HasPrefix(p, "/") {
+		// file:///C:/...
+		p = "/" + p
+	}
+	source := (&url.URL{Scheme: "file", Path: p}).String()
+
+	imagePath, err := e.AddImage(source, "")
+	if err != nil {
+		t.Fatalf("Error adding image from %s: %s", source, err)
+	}
+	if want := "../" + ImageFolderName + "/" + filepath.Base(testImageFromFileSource); imagePath != want {
+		t.Errorf("Unexpected image path %s, want %s", imagePath, want)
+	}
+
+	tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+	defer cleanup(testEpubFilename, tempDir)
+
+	got, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, ImageFolderName, filepath.Base(testImageFromFileSource)))
+	if err != nil {
+		t.Fatalf("Unexpected error reading image file: %s", err)
+	}
+	want, err := os.ReadFile(testImageFromFileSource)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Error("Image file contents don't match")
+	}
+}
@@ -0,0 +1,175 @@
+package epub
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"unicode"
+
+	"github.com/vincent-petithory/dataurl"
+)
+
+// Script is a writing system detected in the sections to add font fallbacks
+// for, see SetFontFallbacks.
+type Script string
+
+const (
+	ScriptArabic     Script = "arabic"
+	ScriptCJK        Script = "cjk"
+	ScriptDevanagari Script = "devanagari"
+)
+
+// fontFallbackFileFormat is the internal filename of the stylesheet generated
+// for a script
+const fontFallbackFileFormat = "font-fallback-%s.css"
+
+// Scripts in the order their stylesheets are linked
+var scripts = []Script{ScriptArabic, ScriptCJK, ScriptDevanagari}
+
+// Unicode ranges of each script
+var scriptRanges = map[Script][]*unicode.RangeTable{
+	ScriptArabic:     {unicode.Arabic},
+	ScriptCJK:        {unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul, unicode.Bopomofo},
+	ScriptDevanagari: {unicode.Devanagari},
+}
+
+// DefaultFontFallbacks are the font families used for the scripts which
+// FontFallbackOptions has no families for: the fonts commonly installed on
+// reading systems, then a generic family.
+var DefaultFontFallbacks = map[Script][]string{
+	ScriptArabic:     {"Noto Naskh Arabic", "Geeza Pro", "Traditional Arabic", "serif"},
+	ScriptCJK:        {"Noto Serif CJK SC", "Source Han Serif", "Songti SC", "Hiragino Mincho ProN", "SimSun", "serif"},
+	ScriptDevanagari: {"Noto Serif Devanagari", "Kohinoor Devanagari", "Mangal", "serif"},
+}
+
+// FontFallbackOptions holds the fonts used by SetFontFallbacks.
+type FontFallbackOptions struct {
+	// Font families for each script, DefaultFontFallbacks is used for the
+	// scripts missing. An empty list leaves the script out unless a font is
+	// embedded for it.
+	Families map[Script][]string
+	// Internal paths of already-added fonts (as returned by AddFont) embedded
+	// for each script, tried before the families
+	Fonts map[Script]string
+}
+
+// SetFontFallbacks makes the sections written in Arabic, CJK or Devanagari
+// script link a stylesheet setting the font families for it, so that the
+// glyphs don't render as boxes on reading systems whose default font lacks
+// them. The scripts are detected in the text of each section when the EPUB
+// is written.
+//
+// The stylesheets are linked before the CSS files of the section, so that a
+// font-family set by these takes precedence. A nil options disables the
+// fallbacks, the default.
+func (e *Epub) SetFontFallbacks(options *FontFallbackOptions) {
+	e.Lock()
+	defer e.Unlock()
+	if options == nil {
+		e.fontFallbacks = nil
+		return
+	}
+	o := &FontFallbackOptions{
+		Families: make(map[Script][]string),
+		Fonts:    make(map[Script]string),
+	}
+	for _, script := range scripts {
+		o.Families[script] = DefaultFontFallbacks[script]
+		if families, ok := options.Families[script]; ok {
+			o.Families[script] = append([]string(nil), families...)
+		}
+		if font, ok := options.Fonts[script]; ok {
+			o.Fonts[script] = font
+		}
+	}
+	e.fontFallbacks = o
+}
+
+// detectScripts returns the scripts with font fallbacks used in the text of
+// body, skipping the markup
+func detectScripts(body string) []Script {
+	found := make(map[Script]bool)
+	inTag := false
+	for _, r := range body {
+		switch {
+		case r == '<':
+			inTag = true
+		case r == '>':
+			inTag = false
+		case inTag || r < unicode.MaxLatin1:
+		default:
+			for script, ranges := range scriptRanges {
+				if unicode.In(r, ranges...) {
+					found[script] = true
+				}
+			}
+		}
+	}
+	var detected []Script
+	for _, script := range scripts {
+		if found[script] {
+			detected = append(detected, script)
+		}
+	}
+	return detected
+}
+
+// fontFallbackCSS returns the internal paths of the stylesheets with the font
+// fallbacks for the scripts used in body
+func (e *Epub) fontFallbackCSS(body string) []string {
+	if e.fontFallbacks == nil {
+		return nil
+	}
+	var paths []string
+	for _, script := range detectScripts(body) {
+		if len(e.fontFallbacks.Families[script]) == 0 && e.fontFallbacks.Fonts[script] == "" {
+			continue
+		}
+		paths = append(paths, path.Join("..", CSSFolderName, fmt.Sprintf(fontFallbackFileFormat, script)))
+	}
+	return paths
+}
+
+// fontFallbackStylesheets returns the CSS files to write along with the ones
+// added with AddCSS, the key being the filename and the value the source. A
+// CSS file added with the same filename is kept.
+func (e *Epub) fontFallbackStylesheets() map[string]string {
+	if e.fontFallbacks == nil {
+		return nil
+	}
+	stylesheets := make(map[string]string)
+	for _, script := range scripts {
+		filename := fmt.Sprintf(fontFallbackFileFormat, script)
+		if _, ok := e.css[filename]; ok {
+			continue
+		}
+		var b strings.Builder
+		var families []string
+		if font, ok := e.fontFallbacks.Fonts[script]; ok {
+			family := "epub-fallback-" + string(script)
+			// The CSS folder is next to the font folder, so the path relative
+			// to the sections works for the stylesheet too
+			fmt.Fprintf(&b, "@font-face {\n  font-family: %q;\n  src: url(%q);\n}\n", family, font)
+			families = append(families, fmt.Sprintf("%q", family))
+		}
+		for _, family := range e.fontFallbacks.Families[script] {
+			families = append(families, cssFontFamily(family))
+		}
+		if len(families) == 0 {
+			// The script is left out
+			continue
+		}
+		fmt.Fprintf(&b, "body {\n  font-family: %s;\n}\n", strings.Join(families, ", "))
+		stylesheets[filename] = dataurl.EncodeBytes([]byte(b.String()))
+	}
+	return stylesheets
+}
+
+// cssFontFamily quotes a font family name unless it is a generic family
+func cssFontFamily(family string) string {
+	switch family {
+	case "serif", "sans-serif", "monospace", "cursive", "fantasy", "system-ui":
+		return family
+	}
+	return fmt.Sprintf("%q", family)
+}
@@ -0,0 +1,99 @@
+package epub
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-shiori/go-epub/internal/storage"
+)
+
+func TestDetectScripts(t *testing.T) {
+	tests := []struct {
+		body     string
+		expected []Script
+	}{
+		{`<p>Plain text, café</p>`, nil},
+		{`<p title="中文">Text</p>`, nil},
+		{`<p>中文 and ひらがな</p>`, []Script{ScriptCJK}},
+		{`<p>नमस्ते</p><p>مرحبا</p>`, []Script{ScriptArabic, ScriptDevanagari}},
+	}
+	for _, test := range tests {
+		detected := detectScripts(test.body)
+		if strings.Join(scriptNames(detected), ",") != strings.Join(scriptNames(test.expected), ",") {
+			t.Errorf("Scripts of %q: got %v, expected %v", test.body, detected, test.expected)
+		}
+	}
+}
+
+func scriptNames(scripts []Script) []string {
+	var names []string
+	for _, script := range scripts {
+		names = append(names, string(script))
+	}
+	return names
+}
+
+func TestSetFontFallbacks(t *testing.T) {
+	e, err := NewEpub(testEpubTitle)
+	if err != nil {
+		t.Error(err)
+	}
+	fontPath, err := e.AddFont(testFontFromFileSource, "")
+	if err != nil {
+		t.Errorf("Error adding font: %s", err)
+	}
+	cssPath, err := e.AddCSS(testCoverCSSSource, testCoverCSSFilename)
+	if err != nil {
+		t.Errorf("Error adding CSS: %s", err)
+	}
+	_, err = e.AddSection(`<p>中文</p>`, testSectionTitle, testSectionFilename, cssPath)
+	if err != nil {
+		t.Errorf("Error adding section: %s", err)
+	}
+	_, err = e.AddSection(testSectionBody, testSectionTitle, "latin.xhtml", "")
+	if err != nil {
+		t.Errorf("Error adding section: %s", err)
+	}
+	e.SetFontFallbacks(&FontFallbackOptions{
+		Families: map[Script][]string{ScriptArabic: nil},
+		Fonts:    map[Script]string{ScriptCJK: fontPath},
+	})
+
+	tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+	defer cleanup(testEpubFilename, tempDir)
+
+	cjkCSSPath := "../" + CSSFolderName + "/font-fallback-cjk.css"
+	contents, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, xhtmlFolderName, testSectionFilename))
+	if err != nil {
+		t.Errorf("Unexpected error reading section file: %s", err)
+	}
+	if !strings.Contains(string(contents), `href="`+cjkCSSPath+`"></link>`+"\n"+`    <link rel="stylesheet" type="text/css" href="`+cssPath+`">`) {
+		t.Errorf("Section doesn't link the font fallbacks before its CSS:\n%s", contents)
+	}
+	contents, err = storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, xhtmlFolderName, "latin.xhtml"))
+	if err != nil {
+		t.Errorf("Unexpected error reading section file: %s", err)
+	}
+	if strings.Contains(string(contents), "font-fallback") {
+		t.Errorf("Section without CJK links the font fallbacks:\n%s", contents)
+	}
+
+	contents, err = storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, xhtmlFolderName, cjkCSSPath))
+	if err != nil {
+		t.Errorf("Unexpected error reading font fallback CSS: %s", err)
+	}
+	for _, expected := range []string{
+		`font-family: "epub-fallback-cjk";`,
+		`src: url("` + fontPath + `");`,
+		`font-family: "epub-fallback-cjk", "Noto Serif CJK SC",`,
+		`serif;`,
+	} {
+		if !strings.Contains(string(contents), expected) {
+			t.Errorf("Font fallback CSS doesn't contain %q:\n%s", expected, contents)
+		}
+	}
+	if _, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, CSSFolderName, "font-fallback-arabic.css")); err == nil {
+		t.Error("Font fallback CSS written for a script left out")
+	}
+}
@@ -0,0 +1,181 @@
+package epub
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"strings"
+
+	"github.com/vincent-petithory/dataurl"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+// Size of the images made by SetGeneratedCover unless set, the 1:1.6 ratio
+// recommended by most stores
+const (
+	defaultGeneratedCoverWidth  = 1600
+	defaultGeneratedCoverHeight = 2560
+)
+
+// Colors of the images made by SetGeneratedCover unless set
+var (
+	defaultGeneratedCoverBackground = color.RGBA{R: 0x2b, G: 0x3a, B: 0x55, A: 0xff}
+	defaultGeneratedCoverForeground = color.White
+)
+
+// GeneratedCover configures the cover image made by SetGeneratedCover. The
+// zero value makes a 1600x2560 image with white text on a dark blue
+// background.
+type GeneratedCover struct {
+	// Size of the image in pixels, 1600x2560 if 0
+	Width  int
+	Height int
+	// Color of the background, behind BackgroundImage if set
+	Background color.Color
+	// Image scaled to fill the cover behind the text, e.g. the artwork of a
+	// series. It should leave room for the text to be readable.
+	BackgroundImage image.Image
+	// Color of the title and author
+	Foreground color.Color
+}
+
+// SetGeneratedCover sets as cover an image showing the title and the author of
+// the EPUB, for EPUBs without cover art, which many reading systems show as
+// a blank tile in their library. The cover is set as with SetCover and the
+// default CSS, with the image stored as cover.jpg; the text is rendered with
+// the Go fonts, so scripts they don't cover, such as CJK, aren't shown.
+//
+// The title and author must be set first. If a cover is already set, nothing
+// is done, so that it can be called unconditionally before writing.
+func (e *Epub) SetGeneratedCover(opts GeneratedCover) error {
+	return e.SetGeneratedCoverContext(context.Background(), opts)
+}
+
+// SetGeneratedCoverContext is like SetGeneratedCover, stopping when ctx is
+// done.
+func (e *Epub) SetGeneratedCoverContext(ctx context.Context, opts GeneratedCover) error {
+	if opts.Width < 0 || opts.Height < 0 {
+		return errors.New("invalid generated cover: negative size")
+	}
+	e.Lock()
+	defer e.Unlock()
+	if e.cover.xhtmlFilename != "" {
+		return nil
+	}
+
+	img, err := generateCover(e.title, e.author, opts)
+	if err != nil {
+		return fmt.Errorf("can't generate cover: %w", err)
+	}
+	var b bytes.Buffer
+	if err := jpeg.Encode(&b, img, &jpeg.Options{Quality: 90}); err != nil {
+		return fmt.Errorf("can't generate cover: %w", err)
+	}
+	source := dataurl.New(b.Bytes(), mediaTypeJpeg).String()
+	return e.setCoverFromSource(ctx, source, fmt.Sprintf(defaultCoverImgFormat, ".jpg"))
+}
+
+// generateCover draws the cover image of SetGeneratedCover
+func generateCover(title string, author string, opts GeneratedCover) (image.Image, error) {
+	width, height := opts.Width, opts.Height
+	if width == 0 {
+		width = defaultGeneratedCoverWidth
+	}
+	if height == 0 {
+		height = defaultGeneratedCoverHeight
+	}
+	background := opts.Background
+	if background == nil {
+		background = defaultGeneratedCoverBackground
+	}
+	foreground := opts.Foreground
+	if foreground == nil {
+		foreground = defaultGeneratedCoverForeground
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(img, img.Bounds(), image.NewUniform(background), image.Point{}, draw.Src)
+	if opts.BackgroundImage != nil {
+		draw.Draw(img, img.Bounds(), scaleImage(opts.BackgroundImage, width, height), image.Point{}, draw.Over)
+	}
+
+	// The text is kept within margins of a tenth of the width, the title in
+	// the upper half and the author in the bottom sixth
+	margin := width / 10
+	maxWidth := width - 2*margin
+	if err := drawText(img, gobold.TTF, title, foreground, float64(width)/10, maxWidth, height/2-margin, margin); err != nil {
+		return nil, err
+	}
+	if err := drawText(img, goregular.TTF, author, foreground, float64(width)/18, maxWidth, height/6-margin/2, height*5/6); err != nil {
+		return nil, err
+	}
+	return img, nil
+}
+
+// drawText draws text centered horizontally on img, below top, wrapped to
+// maxWidth and within maxHeight, with the font ttf at size or smaller
+func drawText(img draw.Image, ttf []byte, text string, c color.Color, size float64, maxWidth int, maxHeight int, top int) error {
+	words := strings.Fields(text)
+	if len(words) == 0 {
+		return nil
+	}
+	f, err := opentype.Parse(ttf)
+	if err != nil {
+		return err
+	}
+
+	var face font.Face
+	var lines []string
+	for ; ; size *= 0.9 {
+		face, err = opentype.NewFace(f, &opentype.FaceOptions{Size: size, DPI: 72, Hinting: font.HintingFull})
+		if err != nil {
+			return err
+		}
+		lines = wrapText(face, words, maxWidth)
+		fits := len(lines)*face.Metrics().Height.Ceil() <= maxHeight
+		for _, line := range lines {
+			fits = fits && font.MeasureString(face, line).Ceil() <= maxWidth
+		}
+		// Below 12 pixels the text is cut rather than unreadable
+		if fits || size < 12 {
+			break
+		}
+		face.Close()
+	}
+	defer face.Close()
+
+	lineHeight := face.Metrics().Height.Ceil()
+	d := &font.Drawer{Dst: img, Src: image.NewUniform(c), Face: face}
+	for i, line := range lines {
+		x := (img.Bounds().Dx() - font.MeasureString(face, line).Ceil()) / 2
+		y := top + i*lineHeight + face.Metrics().Ascent.Ceil()
+		d.Dot = fixed.P(x, y)
+		d.DrawString(line)
+	}
+	return nil
+}
+
+// wrapText splits words into lines no wider than maxWidth, except for the
+// words that are wider alone
+func wrapText(face font.Face, words []string, maxWidth int) []string {
+	var lines []string
+	line := words[0]
+	for _, word := range words[1:] {
+		if font.MeasureString(face, line+" "+word).Ceil() <= maxWidth {
+			line += " " + word
+			continue
+		}
+		lines = append(lines, line)
+		line = word
+	}
+	return append(lines, line)
+}
@@ -0,0 +1,64 @@
+package epub
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+
+	"github.com/vincent-petithory/dataurl"
+)
+
+func TestSetGeneratedCover(t *testing.T) {
+	e, err := NewEpub("A Rather Long Title for a Generated Cover Image")
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.SetAuthor(testEpubAuthor)
+	if err := e.SetGeneratedCover(GeneratedCover{Width: -1}); err == nil {
+		t.Error("Expected an error for a negative size")
+	}
+	if err := e.SetGeneratedCover(GeneratedCover{Width: 400, Height: 640, Background: color.Black}); err != nil {
+		t.Fatalf("Error generating cover: %s", err)
+	}
+	if e.cover.imageFilename != "cover.jpg" || e.cover.xhtmlFilename == "" {
+		t.Fatalf("Generated cover not set: %+v", e.cover)
+	}
+
+	u, err := dataurl.DecodeString(e.images["cover.jpg"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := jpeg.Decode(bytes.NewReader(u.Data))
+	if err != nil {
+		t.Fatalf("Generated cover isn't a JPEG image: %s", err)
+	}
+	if got := img.Bounds().Size(); got != image.Pt(400, 640) {
+		t.Errorf("Generated cover of size %v, expected 400x640", got)
+	}
+	if r, g, b, _ := img.At(2, 2).RGBA(); r > 0x1000 || g > 0x1000 || b > 0x1000 {
+		t.Errorf("Background color %v, expected black", img.At(2, 2))
+	}
+	// Both the title and the author are drawn
+	for _, area := range []image.Rectangle{image.Rect(0, 0, 400, 320), image.Rect(0, 533, 400, 640)} {
+		drawn := false
+		for y := area.Min.Y; y < area.Max.Y && !drawn; y++ {
+			for x := area.Min.X; x < area.Max.X && !drawn; x++ {
+				r, _, _, _ := img.At(x, y).RGBA()
+				drawn = r > 0xc000
+			}
+		}
+		if !drawn {
+			t.Errorf("No text drawn in %v", area)
+		}
+	}
+
+	// An existing cover is kept
+	if err := e.SetGeneratedCover(GeneratedCover{}); err != nil {
+		t.Fatal(err)
+	}
+	if len(e.images) != 1 {
+		t.Errorf("Cover generated again: %v", e.images)
+	}
+}
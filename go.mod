@@ -6,6 +6,9 @@ require (
 	github.com/gabriel-vasile/mimetype v1.4.4
 	github.com/gofrs/uuid/v5 v5.2.0
 	github.com/vincent-petithory/dataurl v1.0.0
+	github.com/yuin/goldmark v1.7.8
+	golang.org/x/image v0.18.0
+	golang.org/x/net v0.25.0
 )
 
-require golang.org/x/net v0.25.0 // indirect
+require golang.org/x/text v0.16.0 // indirect
@@ -0,0 +1,131 @@
+package epub
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// NormalizeHeadings is a Transform rebalancing the heading levels of a
+// section, which is often needed when aggregating content from different
+// sources. The first heading becomes <h1> and levels are never skipped:
+//
+//	<h2>Title</h2><h4>Part</h4><h3>Other part</h3><h5>Note</h5>
+//
+// becomes
+//
+//	<h1>Title</h1><h2>Part</h2><h2>Other part</h2><h3>Note</h3>
+//
+// A heading of a higher rank than the first one, e.g. an <h1> following an
+// <h2>, becomes <h1> as well.
+func NormalizeHeadings(body string) (string, error) {
+	return transformNodes(body, normalizeHeadings)
+}
+
+// normalizeHeadings applies NormalizeHeadings to the parsed <body> element body
+func normalizeHeadings(body *html.Node) error {
+	// Original levels of the headings enclosing the current one
+	var stack []int
+	walk(body, func(n *html.Node) bool {
+		level := headingLevel(n)
+		if level == 0 {
+			return true
+		}
+		for len(stack) > 0 && stack[len(stack)-1] >= level {
+			stack = stack[:len(stack)-1]
+		}
+		stack = append(stack, level)
+
+		newLevel := len(stack)
+		if newLevel > 6 {
+			newLevel = 6
+		}
+		n.Data = "h" + strconv.Itoa(newLevel)
+		n.DataAtom = atom.Lookup([]byte(n.Data))
+		return true
+	})
+	return nil
+}
+
+// headingLevel returns the level of a heading element, or 0 if n isn't one
+func headingLevel(n *html.Node) int {
+	if n.Namespace != "" {
+		return 0
+	}
+	switch n.DataAtom {
+	case atom.H1:
+		return 1
+	case atom.H2:
+		return 2
+	case atom.H3:
+		return 3
+	case atom.H4:
+		return 4
+	case atom.H5:
+		return 5
+	case atom.H6:
+		return 6
+	}
+	return 0
+}
+
+// HeadingIDs is a Transform giving an id to the headings of a section that
+// don't have one, so they can be linked to from the TOC or from other
+// sections. The id is a slug of the text of the heading, e.g. "Chapter 1: The
+// Café" becomes "chapter-1-the-café". Ids already used in the section get a
+// numeric suffix ("notes", "notes-2", ...). Since slugs only depend on the
+// content, links to them remain valid across rebuilds.
+func HeadingIDs(body string) (string, error) {
+	return transformNodes(body, headingIDs)
+}
+
+// headingIDs applies HeadingIDs to the parsed <body> element body
+func headingIDs(body *html.Node) error {
+	used := map[string]bool{}
+	walk(body, func(n *html.Node) bool {
+		if id := getAttr(n, "id"); id != "" {
+			used[id] = true
+		}
+		return true
+	})
+
+	walk(body, func(n *html.Node) bool {
+		if headingLevel(n) == 0 || getAttr(n, "id") != "" {
+			return true
+		}
+		base := slugify(textContent(n))
+		id := base
+		for i := 2; used[id]; i++ {
+			id = base + "-" + strconv.Itoa(i)
+		}
+		used[id] = true
+		n.Attr = append(n.Attr, html.Attribute{Key: "id", Val: id})
+		return false
+	})
+	return nil
+}
+
+// slugify returns a lower case version of text where letters and digits are
+// kept, and every other run of characters is replaced by a hyphen
+func slugify(text string) string {
+	var b strings.Builder
+	hyphen := false
+	for _, r := range strings.ToLower(text) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.Is(unicode.Mn, r) {
+			if hyphen && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			hyphen = false
+			b.WriteRune(r)
+		} else {
+			hyphen = true
+		}
+	}
+	if b.Len() == 0 {
+		return "section"
+	}
+	return b.String()
+}
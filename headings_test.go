@@ -0,0 +1,90 @@
+package epub
+
+import (
+	"testing"
+)
+
+func TestNormalizeHeadings(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		expected string
+	}{
+		{
+			"demote to h1",
+			`<h3>Title</h3><p>Text</p><h4>Part</h4>`,
+			`<h1>Title</h1><p>Text</p><h2>Part</h2>`,
+		},
+		{
+			"skipped levels",
+			`<h2>Title</h2><h4>Part</h4><h3>Other part</h3><h5>Note</h5><h2>Next</h2>`,
+			`<h1>Title</h1><h2>Part</h2><h2>Other part</h2><h3>Note</h3><h1>Next</h1>`,
+		},
+		{
+			"nested headings",
+			`<section><h2 id="a">Title</h2><section><h6>Part</h6></section></section>`,
+			`<section><h1 id="a">Title</h1><section><h2>Part</h2></section></section>`,
+		},
+		{
+			"already normalized",
+			`<h1>Title</h1><h2>Part</h2><h3>Note</h3>`,
+			`<h1>Title</h1><h2>Part</h2><h3>Note</h3>`,
+		},
+		{
+			"no headings",
+			`<p>Text</p>`,
+			`<p>Text</p>`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NormalizeHeadings(tt.body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.expected {
+				t.Errorf("NormalizeHeadings() = %s, want %s", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestHeadingIDs(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		expected string
+	}{
+		{
+			"slug",
+			`<h1>Chapter 1: The  Café!</h1><p id="x">Text</p>`,
+			`<h1 id="chapter-1-the-café">Chapter 1: The  Café!</h1><p id="x">Text</p>`,
+		},
+		{
+			"unicode",
+			`<h2>Глава <em>первая</em></h2><h2>第一章</h2>`,
+			`<h2 id="глава-первая">Глава <em>первая</em></h2><h2 id="第一章">第一章</h2>`,
+		},
+		{
+			"duplicates",
+			`<p id="notes">Text</p><h2>Notes</h2><h2>Notes</h2>`,
+			`<p id="notes">Text</p><h2 id="notes-2">Notes</h2><h2 id="notes-3">Notes</h2>`,
+		},
+		{
+			"existing id",
+			`<h2 id="keep">Title</h2><h3>?!</h3>`,
+			`<h2 id="keep">Title</h2><h3 id="section">?!</h3>`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := HeadingIDs(tt.body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.expected {
+				t.Errorf("HeadingIDs() = %s, want %s", got, tt.expected)
+			}
+		})
+	}
+}
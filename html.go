@@ -0,0 +1,167 @@
+package epub
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+const (
+	xmlnsMathML = "http://www.w3.org/1998/Math/MathML"
+	xmlnsSVG    = "http://www.w3.org/2000/svg"
+)
+
+// parseBody parses the content of a section body with an HTML5 parser. The
+// returned node is a synthetic <body> element holding the parsed content.
+func parseBody(body string) (*html.Node, error) {
+	context := &html.Node{
+		Type:     html.ElementNode,
+		Data:     "body",
+		DataAtom: atom.Body,
+	}
+	nodes, err := html.ParseFragment(strings.NewReader(body), context)
+	if err != nil {
+		return nil, fmt.Errorf("can't parse body: %w", err)
+	}
+	for _, n := range nodes {
+		context.AppendChild(n)
+	}
+	return context, nil
+}
+
+// renderBody serializes the children of a node returned by parseBody as
+// well-formed XHTML.
+func renderBody(body *html.Node) (string, error) {
+	var b strings.Builder
+	for c := body.FirstChild; c != nil; c = c.NextSibling {
+		addForeignNamespaces(c)
+		if err := html.Render(&b, c); err != nil {
+			return "", fmt.Errorf("can't render body: %w", err)
+		}
+	}
+	return b.String(), nil
+}
+
+// addForeignNamespaces declares the namespace of the SVG and MathML elements
+// found at the root of foreign content, since the HTML syntax doesn't require
+// it but XHTML does.
+func addForeignNamespaces(n *html.Node) {
+	if n.Type == html.ElementNode && n.Namespace != "" && (n.Parent == nil || n.Parent.Namespace != n.Namespace) {
+		xmlns := ""
+		switch n.Namespace {
+		case "svg":
+			xmlns = xmlnsSVG
+		case "math":
+			xmlns = xmlnsMathML
+		}
+		if xmlns != "" && getAttr(n, "xmlns") == "" {
+			n.Attr = append(n.Attr, html.Attribute{Key: "xmlns", Val: xmlns})
+		}
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		addForeignNamespaces(c)
+	}
+}
+
+// getAttr returns the value of the attribute key of n, or an empty string if
+// it isn't set.
+func getAttr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Namespace == "" && a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+// setAttr sets the attribute key of n to val, adding it if it isn't set.
+func setAttr(n *html.Node, key string, val string) {
+	for i, a := range n.Attr {
+		if a.Namespace == "" && a.Key == key {
+			n.Attr[i].Val = val
+			return
+		}
+	}
+	n.Attr = append(n.Attr, html.Attribute{Key: key, Val: val})
+}
+
+// removeAttr removes the attributes of n for which remove returns true.
+func removeAttr(n *html.Node, remove func(a html.Attribute) bool) {
+	attrs := n.Attr[:0]
+	for _, a := range n.Attr {
+		if !remove(a) {
+			attrs = append(attrs, a)
+		}
+	}
+	n.Attr = attrs
+}
+
+// walk calls f for every element below n in document order. If f returns
+// false, the children of the element are skipped. The element may be removed
+// from the tree by f.
+func walk(n *html.Node, f func(n *html.Node) bool) {
+	for c := n.FirstChild; c != nil; {
+		next := c.NextSibling
+		if c.Type == html.ElementNode {
+			if f(c) && c.Parent != nil {
+				walk(c, f)
+			}
+		} else {
+			walk(c, f)
+		}
+		c = next
+	}
+}
+
+// textContent returns the text of n and its children, with whitespace
+// collapsed.
+func textContent(n *html.Node) string {
+	var b strings.Builder
+	var f func(*html.Node)
+	f = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			b.WriteString(n.Data)
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			f(c)
+		}
+	}
+	f(n)
+	return strings.Join(strings.Fields(b.String()), " ")
+}
+
+// findElement returns the first element below n for which match returns true,
+// or nil.
+func findElement(n *html.Node, match func(n *html.Node) bool) *html.Node {
+	var found *html.Node
+	walk(n, func(c *html.Node) bool {
+		if found != nil {
+			return false
+		}
+		if match(c) {
+			found = c
+			return false
+		}
+		return true
+	})
+	return found
+}
+
+// insertMarkup parses markup and inserts the resulting nodes as children of
+// parent before oldChild, or at the end if oldChild is nil. It returns the last
+// inserted node.
+func insertMarkup(parent, oldChild *html.Node, markup string) (*html.Node, error) {
+	nodes, err := parseBody(markup)
+	if err != nil {
+		return nil, err
+	}
+	var last *html.Node
+	for c := nodes.FirstChild; c != nil; c = nodes.FirstChild {
+		nodes.RemoveChild(c)
+		parent.InsertBefore(c, oldChild)
+		last = c
+	}
+	return last, nil
+}
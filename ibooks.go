@@ -0,0 +1,91 @@
+package epub
+
+import (
+	"encoding/xml"
+	"fmt"
+	"path/filepath"
+	"strconv"
+)
+
+const (
+	ibooksDisplayOptionsFilename = "com.apple.ibooks.display-options.xml"
+	ibooksPlatformAll            = "*"
+)
+
+// IBooksDisplayOptions holds the options written to the Apple Books specific
+// META-INF/com.apple.ibooks.display-options.xml file.
+//
+// Apple Books ignores the fonts embedded in an EPUB unless SpecifiedFonts is
+// set.
+type IBooksDisplayOptions struct {
+	// Use the fonts embedded in the EPUB instead of the reader's default font
+	SpecifiedFonts bool
+	// The EPUB is a fixed layout book
+	FixedLayout bool
+	// The EPUB contains interactive (scripted) content
+	Interactive bool
+}
+
+// ibooksDisplayOptions holds the actual XML for the display options file
+type ibooksDisplayOptions struct {
+	XMLName  xml.Name       `xml:"display_options"`
+	Platform ibooksPlatform `xml:"platform"`
+}
+
+type ibooksPlatform struct {
+	Name    string         `xml:"name,attr"`
+	Options []ibooksOption `xml:"option"`
+}
+
+// <option> elements, one per display option
+// Ex: <option name="specified-fonts">true</option>
+type ibooksOption struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:",chardata"`
+}
+
+// SetIBooksDisplayOptions sets the options written to
+// META-INF/com.apple.ibooks.display-options.xml. If options is nil, which is
+// the default, the file is not written.
+func (e *Epub) SetIBooksDisplayOptions(options *IBooksDisplayOptions) {
+	e.Lock()
+	defer e.Unlock()
+	if options == nil {
+		e.ibooksOptions = nil
+		return
+	}
+	o := *options
+	e.ibooksOptions = &o
+}
+
+// Write the Apple Books display options file if display options were set
+func (e *Epub) writeIBooksDisplayOptions(rootEpubDir string) error {
+	if e.ibooksOptions == nil {
+		return nil
+	}
+
+	d := ibooksDisplayOptions{
+		Platform: ibooksPlatform{
+			Name: ibooksPlatformAll,
+			Options: []ibooksOption{
+				{Name: "specified-fonts", Value: strconv.FormatBool(e.ibooksOptions.SpecifiedFonts)},
+				{Name: "fixed-layout", Value: strconv.FormatBool(e.ibooksOptions.FixedLayout)},
+				{Name: "interactive", Value: strconv.FormatBool(e.ibooksOptions.Interactive)},
+			},
+		},
+	}
+	output, err := xml.MarshalIndent(d, "", "  ")
+	if err != nil {
+		return fmt.Errorf("Error marshalling XML for display options file: %w", err)
+	}
+	// Add the xml header to the output
+	content := append([]byte(xml.Header), output...)
+	// It's generally nice to have files end with a newline
+	content = append(content, "\n"...)
+
+	displayOptionsFilePath := filepath.Join(rootEpubDir, metaInfFolderName, ibooksDisplayOptionsFilename)
+	if err := filesystem.WriteFile(displayOptionsFilePath, content, filePermissions); err != nil {
+		return fmt.Errorf("Error writing display options file: %w", err)
+	}
+	return nil
+}
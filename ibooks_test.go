@@ -0,0 +1,53 @@
+package epub
+
+import (
+	"io/fs"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-shiori/go-epub/internal/storage"
+)
+
+const testIBooksDisplayOptionsContents = `<?xml version="1.0" encoding="UTF-8"?>
+<display_options>
+  <platform name="*">
+    <option name="specified-fonts">true</option>
+    <option name="fixed-layout">false</option>
+    <option name="interactive">true</option>
+  </platform>
+</display_options>`
+
+func TestSetIBooksDisplayOptions(t *testing.T) {
+	e, err := NewEpub(testEpubTitle)
+	if err != nil {
+		t.Error(err)
+	}
+	e.SetIBooksDisplayOptions(&IBooksDisplayOptions{
+		SpecifiedFonts: true,
+		Interactive:    true,
+	})
+
+	tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+
+	contents, err := storage.ReadFile(filesystem, filepath.Join(tempDir, metaInfFolderName, ibooksDisplayOptionsFilename))
+	if err != nil {
+		t.Errorf("Unexpected error reading display options file: %s", err)
+	}
+	if trimAllSpace(string(contents)) != trimAllSpace(testIBooksDisplayOptionsContents) {
+		t.Errorf(
+			"Display options file contents don't match\n"+
+				"Got: %s\n"+
+				"Expected: %s",
+			contents,
+			testIBooksDisplayOptionsContents)
+	}
+	cleanup(testEpubFilename, tempDir)
+
+	// Removing the options must not write the file
+	e.SetIBooksDisplayOptions(nil)
+	tempDir = writeAndExtractEpub(t, e, testEpubFilename)
+	if _, err := fs.Stat(filesystem, filepath.Join(tempDir, metaInfFolderName, ibooksDisplayOptionsFilename)); err == nil {
+		t.Error("Display options file should not be written when no options are set")
+	}
+	cleanup(testEpubFilename, tempDir)
+}
@@ -0,0 +1,121 @@
+package epub
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ImageDecoder decodes an image in a format that go-epub can't decode, read
+// from r. mediaType is the media type detected from its content, e.g.
+// "image/webp".
+type ImageDecoder func(r io.Reader, mediaType string) (image.Image, error)
+
+// Media types of the images converted by the ImageDecoder, which many reading
+// systems can't render
+var convertedImageMediaTypes = map[string]bool{
+	"image/webp": true,
+	"image/avif": true,
+	"image/heic": true,
+	"image/heif": true,
+}
+
+// SetImageDecoder sets the function decoding the WebP, AVIF and HEIC images
+// added with AddImage, so that they are converted when the EPUB is written for
+// the reading systems that can't render them.
+//
+// The converted image is written next to the original one, as a PNG image if
+// it has transparent pixels and as a JPEG image otherwise, and is set as the
+// manifest fallback of the original one:
+//
+//	<item id="..." href="images/photo.webp" media-type="image/webp" fallback="idconverted"></item>
+//	<item id="idconverted" href="images/photo.jpg" media-type="image/jpeg"></item>
+//
+// The sections still link the original image, so reading systems supporting
+// its format use it. The converted image is processed as set by
+// SetImageOptimization.
+//
+// go-epub doesn't decode these formats itself: the decoder can use a library
+// such as golang.org/x/image/webp, or an external tool converting the image
+// to PNG which is then decoded with image/png. The images it fails on are
+// written without a fallback and reported as warnings. A nil decoder disables
+// the conversion, the default.
+func (e *Epub) SetImageDecoder(decoder ImageDecoder) {
+	e.Lock()
+	defer e.Unlock()
+	e.imageDecoder = decoder
+}
+
+// convertsImage returns whether a resource of the folder mediaFolderName with
+// the given media type is converted
+func (e *Epub) convertsImage(mediaFolderName string, mediaType string) bool {
+	return mediaFolderName == ImageFolderName && e.imageDecoder != nil && convertedImageMediaTypes[mediaType]
+}
+
+// convertImage converts the image just written at mediaFilePath and writes the
+// result next to it. It returns the internal filename and the media type of
+// the converted image.
+func (e *Epub) convertImage(mediaFilename string, mediaFilePath string, mediaType string) (string, string, error) {
+	r, err := e.spool.open(mediaFilePath)
+	if err != nil {
+		return "", "", fmt.Errorf("can't convert image %s: %w", mediaFilename, err)
+	}
+	data, err := io.ReadAll(r)
+	r.Close()
+	if err != nil {
+		return "", "", fmt.Errorf("can't convert image %s: %w", mediaFilename, err)
+	}
+	img, err := e.imageDecoder(bytes.NewReader(data), mediaType)
+	if err != nil {
+		return "", "", fmt.Errorf("can't convert image %s: %w", mediaFilename, err)
+	}
+
+	var b bytes.Buffer
+	convertedType, extension := mediaTypeJpeg, ".jpg"
+	if opaque, ok := img.(interface{ Opaque() bool }); ok && !opaque.Opaque() {
+		convertedType, extension = mediaTypePng, ".png"
+		err = png.Encode(&b, img)
+	} else {
+		quality := e.imageOptimization.JPEGQuality
+		if quality == 0 {
+			quality = jpeg.DefaultQuality
+		}
+		err = jpeg.Encode(&b, img, &jpeg.Options{Quality: quality})
+	}
+	if err != nil {
+		return "", "", fmt.Errorf("can't convert image %s: %w", mediaFilename, err)
+	}
+
+	base := strings.TrimSuffix(mediaFilename, path.Ext(mediaFilename))
+	filename := base + extension
+	for i := 2; e.imageFileExists(filepath.Dir(mediaFilePath), filename); i++ {
+		filename = fmt.Sprintf("%s-%d%s", base, i, extension)
+	}
+	convertedPath := filepath.Join(filepath.Dir(mediaFilePath), filename)
+	if err := filesystem.WriteFile(convertedPath, b.Bytes(), filePermissions); err != nil {
+		return "", "", fmt.Errorf("can't convert image %s: %w", mediaFilename, err)
+	}
+	if e.optimizesMedia(ImageFolderName) {
+		if err := e.optimizeImage(filename, convertedPath, convertedType); err != nil {
+			e.warn("%s", err)
+		}
+	}
+	return filename, convertedType, nil
+}
+
+// imageFileExists returns whether an image with the internal filename was
+// added or already written to the folder mediaFolderPath
+func (e *Epub) imageFileExists(mediaFolderPath string, filename string) bool {
+	if _, ok := e.images[filename]; ok {
+		return true
+	}
+	_, err := fs.Stat(filesystem, filepath.Join(mediaFolderPath, filename))
+	return err == nil
+}
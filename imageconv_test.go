@@ -0,0 +1,76 @@
+package epub
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"io"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"github.com/go-shiori/go-epub/internal/storage"
+)
+
+// testWebP is the start of a WebP image, enough for its media type to be
+// detected
+var testWebP = []byte("RIFF\x24\x00\x00\x00WEBPVP8 \x18\x00\x00\x00\x30\x01\x00\x9d\x01\x2a\x01\x00\x01\x00\x02\x00\x34\x25\xa4\x00\x03\x70\x00\xfe\xfb\x94\x00\x00")
+
+func TestSetImageDecoder(t *testing.T) {
+	tests := []struct {
+		alpha     uint8
+		filename  string
+		mediaType string
+		format    string
+	}{
+		{0xff, "photo.jpg", "image/jpeg", "jpeg"},
+		{0x80, "photo.png", "image/png", "png"},
+	}
+	for _, test := range tests {
+		e, err := NewEpub(testEpubTitle)
+		if err != nil {
+			t.Fatal(err)
+		}
+		e.SetImageDecoder(func(r io.Reader, mediaType string) (image.Image, error) {
+			if mediaType != "image/webp" {
+				return nil, errors.New("unexpected media type " + mediaType)
+			}
+			img := image.NewNRGBA(image.Rect(0, 0, 8, 8))
+			for y := 0; y < 8; y++ {
+				for x := 0; x < 8; x++ {
+					img.Set(x, y, color.NRGBA{R: 0xff, A: test.alpha})
+				}
+			}
+			return img, nil
+		})
+		if _, err := e.AddImageFromBytes(testWebP, "photo.webp"); err != nil {
+			t.Fatalf("Error adding image: %s", err)
+		}
+
+		tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+
+		converted, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, ImageFolderName, test.filename))
+		if err != nil {
+			t.Fatalf("Unexpected error reading converted image: %s", err)
+		}
+		if _, format, err := image.DecodeConfig(bytes.NewReader(converted)); err != nil || format != test.format {
+			t.Errorf("Converted image isn't %s: %s %v", test.format, format, err)
+		}
+
+		pkg, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, pkgFilename))
+		if err != nil {
+			t.Fatalf("Unexpected error reading package file: %s", err)
+		}
+		match := regexp.MustCompile(`href="images/photo.webp" media-type="image/webp" fallback="([^"]+)"`).FindSubmatch(pkg)
+		if match == nil {
+			t.Fatalf("Original image has no fallback:\n%s", pkg)
+		}
+		expected := `id="` + string(match[1]) + `" href="images/` + test.filename + `" media-type="` + test.mediaType + `"`
+		if !bytes.Contains(pkg, []byte(expected)) {
+			t.Errorf("Fallback item not found: %s\n%s", expected, pkg)
+		}
+
+		cleanup(testEpubFilename, tempDir)
+	}
+}
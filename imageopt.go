@@ -0,0 +1,398 @@
+package epub
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"math"
+)
+
+const mediaTypePng = "image/png"
+
+// ImageOptimization configures the processing of the images added with
+// AddImage when the EPUB is written, to keep it under the size limits of the
+// stores and reading systems. The zero value leaves the images untouched.
+//
+// Only JPEG and PNG images are processed, other images are written as they
+// are. An image is re-encoded only when it is scaled down or when the
+// re-encoded image is smaller.
+type ImageOptimization struct {
+	// Images wider or taller are scaled down to fit, keeping their aspect
+	// ratio. 0 means no limit.
+	MaxWidth  int
+	MaxHeight int
+	// Images with more pixels are scaled down to fit, keeping their aspect
+	// ratio, e.g. 4000000 for 4 megapixels. 0 means no limit.
+	MaxPixels int
+	// Quality, from 1 to 100, of the re-encoded JPEG images. If 0, JPEG images
+	// that aren't scaled down are left as they are, and scaled down ones use
+	// the default quality of image/jpeg.
+	JPEGQuality int
+	// Whether PNG images are re-encoded with the best compression
+	RecompressPNG bool
+	// Whether the metadata of the images, such as EXIF, XMP and comments, is
+	// removed. Images that are re-encoded never keep their metadata. The EXIF
+	// orientation is applied to the pixels of the images whose metadata is
+	// removed, which are re-encoded.
+	StripMetadata bool
+}
+
+// SetImageOptimization sets the processing of the images added with AddImage
+// when the EPUB is written, see ImageOptimization. Images that can't be
+// processed are written as they are and reported as warnings.
+func (e *Epub) SetImageOptimization(o ImageOptimization) error {
+	if o.MaxWidth < 0 || o.MaxHeight < 0 || o.MaxPixels < 0 {
+		return errors.New("invalid image optimization: negative maximum size")
+	}
+	if o.JPEGQuality < 0 || o.JPEGQuality > 100 {
+		return fmt.Errorf("invalid image optimization: JPEG quality %d out of range 1-100", o.JPEGQuality)
+	}
+	e.Lock()
+	defer e.Unlock()
+	e.imageOptimization = o
+	return nil
+}
+
+// optimizesMedia returns whether the resources of the folder mediaFolderName
+// are processed by the image optimization
+func (e *Epub) optimizesMedia(mediaFolderName string) bool {
+	return mediaFolderName == ImageFolderName && e.imageOptimization != (ImageOptimization{})
+}
+
+// optimizeImage applies the image optimization to the image just written at
+// mediaFilePath
+func (e *Epub) optimizeImage(mediaFilename string, mediaFilePath string, mediaType string) error {
+	if mediaType != mediaTypeJpeg && mediaType != mediaTypePng {
+		return nil
+	}
+	r, err := e.spool.open(mediaFilePath)
+	if err != nil {
+		return fmt.Errorf("can't optimize image %s: %w", mediaFilename, err)
+	}
+	data, err := io.ReadAll(r)
+	r.Close()
+	if err != nil {
+		return fmt.Errorf("can't optimize image %s: %w", mediaFilename, err)
+	}
+	optimized, err := optimizeImage(data, mediaType, e.imageOptimization)
+	if err != nil {
+		return fmt.Errorf("can't optimize image %s: %w", mediaFilename, err)
+	}
+	if optimized == nil {
+		return nil
+	}
+	e.spool.remove(mediaFilePath)
+	if err := filesystem.WriteFile(mediaFilePath, optimized, filePermissions); err != nil {
+		return fmt.Errorf("can't optimize image %s: %w", mediaFilename, err)
+	}
+	return nil
+}
+
+// optimizeImage returns the JPEG or PNG image data processed as set by o, or
+// nil if it is unchanged
+func optimizeImage(data []byte, mediaType string, o ImageOptimization) ([]byte, error) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	orientation := imageOrientation(data, mediaType)
+	imageWidth, imageHeight := config.Width, config.Height
+	if orientation >= 5 {
+		imageWidth, imageHeight = imageHeight, imageWidth
+	}
+	width, height := fitImage(imageWidth, imageHeight, o)
+	scaled := width != imageWidth || height != imageHeight
+	reencode := scaled ||
+		(mediaType == mediaTypeJpeg && o.JPEGQuality > 0) ||
+		(mediaType == mediaTypePng && o.RecompressPNG)
+	// Re-encoding and stripping the metadata drop the EXIF orientation, so it
+	// is applied to the pixels
+	rotated := orientation > 1 && (reencode || o.StripMetadata)
+
+	if reencode || rotated {
+		img, _, err := image.Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		if rotated {
+			img = orientImage(img, orientation)
+		}
+		if scaled {
+			img = scaleImage(img, width, height)
+		}
+		var b bytes.Buffer
+		if mediaType == mediaTypeJpeg {
+			quality := o.JPEGQuality
+			if quality == 0 {
+				quality = jpeg.DefaultQuality
+			}
+			err = jpeg.Encode(&b, img, &jpeg.Options{Quality: quality})
+		} else {
+			encoder := png.Encoder{CompressionLevel: png.DefaultCompression}
+			if o.RecompressPNG {
+				encoder.CompressionLevel = png.BestCompression
+			}
+			err = encoder.Encode(&b, img)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if scaled || rotated || b.Len() < len(data) {
+			return b.Bytes(), nil
+		}
+	}
+
+	if !o.StripMetadata {
+		return nil, nil
+	}
+	if mediaType == mediaTypeJpeg {
+		return stripJPEGMetadata(data)
+	}
+	return stripPNGMetadata(data)
+}
+
+// fitImage returns the size of an image of the given size scaled down to fit
+// the limits of o, keeping its aspect ratio
+func fitImage(width int, height int, o ImageOptimization) (int, int) {
+	scale := 1.0
+	if o.MaxWidth > 0 && width > o.MaxWidth {
+		scale = math.Min(scale, float64(o.MaxWidth)/float64(width))
+	}
+	if o.MaxHeight > 0 && height > o.MaxHeight {
+		scale = math.Min(scale, float64(o.MaxHeight)/float64(height))
+	}
+	if o.MaxPixels > 0 && width*height > o.MaxPixels {
+		scale = math.Min(scale, math.Sqrt(float64(o.MaxPixels)/float64(width*height)))
+	}
+	if scale == 1 {
+		return width, height
+	}
+	return max(1, int(float64(width)*scale)), max(1, int(float64(height)*scale))
+}
+
+// scaleImage scales img down to the given size, averaging the source pixels
+// covered by each pixel of the result
+func scaleImage(img image.Image, width int, height int) image.Image {
+	bounds := img.Bounds()
+	src := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(src, src.Bounds(), img, bounds.Min, draw.Src)
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		y0 := y * bounds.Dy() / height
+		y1 := max(y0+1, (y+1)*bounds.Dy()/height)
+		for x := 0; x < width; x++ {
+			x0 := x * bounds.Dx() / width
+			x1 := max(x0+1, (x+1)*bounds.Dx()/width)
+			var sum [4]int
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					i := src.PixOffset(sx, sy)
+					for c := 0; c < 4; c++ {
+						sum[c] += int(src.Pix[i+c])
+					}
+				}
+			}
+			n := (y1 - y0) * (x1 - x0)
+			i := dst.PixOffset(x, y)
+			for c := 0; c < 4; c++ {
+				dst.Pix[i+c] = uint8(sum[c] / n)
+			}
+		}
+	}
+	return dst
+}
+
+// imageOrientation returns the EXIF orientation of the JPEG or PNG image data,
+// from 1 to 8, 1 meaning that the image is displayed as it is stored
+func imageOrientation(data []byte, mediaType string) int {
+	if mediaType == mediaTypeJpeg {
+		i := 2
+		for i+4 <= len(data) && data[i] == 0xff && data[i+1] != 0xda {
+			end := i + 2 + int(binary.BigEndian.Uint16(data[i+2:]))
+			if end > len(data) {
+				break
+			}
+			if data[i+1] == 0xe1 && bytes.HasPrefix(data[i+4:end], []byte("Exif\x00\x00")) {
+				return exifOrientation(data[i+10 : end])
+			}
+			i = end
+		}
+		return 1
+	}
+	i := 8
+	for i+8 <= len(data) {
+		end := i + 12 + int(binary.BigEndian.Uint32(data[i:]))
+		if end > len(data) || end < i {
+			break
+		}
+		if string(data[i+4:i+8]) == "eXIf" {
+			return exifOrientation(data[i+8 : end-4])
+		}
+		i = end
+	}
+	return 1
+}
+
+// exifOrientation returns the orientation tag of the EXIF data tiff, or 1 if
+// it has none
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 1
+	}
+	for i := 0; i < int(order.Uint16(tiff[ifd:])); i++ {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(tiff) {
+			break
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			// A SHORT, stored at the start of the value field
+			orientation := int(order.Uint16(tiff[entry+8:]))
+			if orientation < 1 || orientation > 8 {
+				return 1
+			}
+			return orientation
+		}
+	}
+	return 1
+}
+
+// orientImage returns img as it is displayed with the EXIF orientation
+// orientation: mirrored, rotated or both
+func orientImage(img image.Image, orientation int) image.Image {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	src := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(src, src.Bounds(), img, bounds.Min, draw.Src)
+	dstWidth, dstHeight := width, height
+	if orientation >= 5 {
+		dstWidth, dstHeight = height, width
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dstWidth, dstHeight))
+	for y := 0; y < dstHeight; y++ {
+		for x := 0; x < dstWidth; x++ {
+			sx, sy := x, y
+			switch orientation {
+			case 2:
+				sx = width - 1 - x
+			case 3:
+				sx, sy = width-1-x, height-1-y
+			case 4:
+				sy = height - 1 - y
+			case 5:
+				sx, sy = y, x
+			case 6:
+				sx, sy = y, height-1-x
+			case 7:
+				sx, sy = width-1-y, height-1-x
+			case 8:
+				sx, sy = width-1-y, x
+			}
+			copy(dst.Pix[dst.PixOffset(x, y):dst.PixOffset(x, y)+4], src.Pix[src.PixOffset(sx, sy):])
+		}
+	}
+	return dst
+}
+
+// JPEG markers of the segments holding metadata: APP1 (EXIF and XMP), APP13
+// (IPTC) and COM (comments). APP0 (JFIF), APP2 (ICC profile) and APP14 (Adobe)
+// affect the rendering and are kept.
+var jpegMetadataMarkers = map[byte]bool{
+	0xe1: true,
+	0xed: true,
+	0xfe: true,
+}
+
+// stripJPEGMetadata returns the JPEG image data without its metadata segments,
+// or nil if it has none
+func stripJPEGMetadata(data []byte) ([]byte, error) {
+	if len(data) < 2 || data[0] != 0xff || data[1] != 0xd8 {
+		return nil, errors.New("not a JPEG image")
+	}
+	out := append([]byte(nil), data[:2]...)
+	stripped := false
+	i := 2
+	for i+4 <= len(data) {
+		if data[i] != 0xff {
+			return nil, errors.New("invalid JPEG segment")
+		}
+		marker := data[i+1]
+		// The entropy-coded data follows the start of scan segment
+		if marker == 0xda {
+			break
+		}
+		end := i + 2 + int(binary.BigEndian.Uint16(data[i+2:]))
+		if end > len(data) {
+			return nil, errors.New("truncated JPEG segment")
+		}
+		if jpegMetadataMarkers[marker] {
+			stripped = true
+		} else {
+			out = append(out, data[i:end]...)
+		}
+		i = end
+	}
+	if !stripped {
+		return nil, nil
+	}
+	return append(out, data[i:]...), nil
+}
+
+// PNG chunks holding metadata
+var pngMetadataChunks = map[string]bool{
+	"tEXt": true,
+	"zTXt": true,
+	"iTXt": true,
+	"eXIf": true,
+	"tIME": true,
+}
+
+// stripPNGMetadata returns the PNG image data without its metadata chunks, or
+// nil if it has none
+func stripPNGMetadata(data []byte) ([]byte, error) {
+	const signature = "\x89PNG\r\n\x1a\n"
+	if !bytes.HasPrefix(data, []byte(signature)) {
+		return nil, errors.New("not a PNG image")
+	}
+	out := append([]byte(nil), signature...)
+	stripped := false
+	i := len(signature)
+	for i < len(data) {
+		if i+8 > len(data) {
+			return nil, errors.New("truncated PNG chunk")
+		}
+		// Length, type, data and CRC
+		end := i + 12 + int(binary.BigEndian.Uint32(data[i:]))
+		if end > len(data) || end < i {
+			return nil, errors.New("truncated PNG chunk")
+		}
+		if pngMetadataChunks[string(data[i+4:i+8])] {
+			stripped = true
+		} else {
+			out = append(out, data[i:end]...)
+		}
+		i = end
+	}
+	if !stripped {
+		return nil, nil
+	}
+	return out, nil
+}
@@ -0,0 +1,138 @@
+package epub
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"path"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-shiori/go-epub/internal/storage"
+)
+
+// testImage returns an image of the given size with a gradient
+func testImage(width int, height int) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, color.RGBA{R: uint8(x), G: uint8(y), B: 0x80, A: 0xff})
+		}
+	}
+	return img
+}
+
+func TestSetImageOptimization(t *testing.T) {
+	var b bytes.Buffer
+	if err := png.Encode(&b, testImage(400, 300)); err != nil {
+		t.Fatal(err)
+	}
+
+	e, err := NewEpub(testEpubTitle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.SetImageOptimization(ImageOptimization{JPEGQuality: 101}); err == nil {
+		t.Error("Expected error setting an out of range JPEG quality")
+	}
+	if err := e.SetImageOptimization(ImageOptimization{MaxWidth: 200, MaxPixels: 10000}); err != nil {
+		t.Fatalf("Error setting image optimization: %s", err)
+	}
+	imagePath, err := e.AddImageFromBytes(b.Bytes(), "image.png")
+	if err != nil {
+		t.Fatalf("Error adding image: %s", err)
+	}
+
+	tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+	defer cleanup(testEpubFilename, tempDir)
+
+	data, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, ImageFolderName, path.Base(imagePath)))
+	if err != nil {
+		t.Fatalf("Unexpected error reading image: %s", err)
+	}
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Unexpected error decoding image: %s", err)
+	}
+	if format != "png" || config.Width != 115 || config.Height != 86 {
+		t.Errorf("Image not scaled down\nGot: %s %dx%d\nExpected: png 115x86", format, config.Width, config.Height)
+	}
+}
+
+func TestStripImageMetadata(t *testing.T) {
+	var b bytes.Buffer
+	if err := jpeg.Encode(&b, testImage(16, 16), nil); err != nil {
+		t.Fatal(err)
+	}
+	exif := []byte("\xff\xe1\x00\x10Exif\x00\x00testdata")
+	withExif := append(append(append([]byte(nil), b.Bytes()[:2]...), exif...), b.Bytes()[2:]...)
+
+	stripped, err := optimizeImage(withExif, mediaTypeJpeg, ImageOptimization{StripMetadata: true})
+	if err != nil {
+		t.Fatalf("Unexpected error stripping JPEG metadata: %s", err)
+	}
+	if !bytes.Equal(stripped, b.Bytes()) {
+		t.Error("JPEG metadata not stripped")
+	}
+	if unchanged, _ := optimizeImage(b.Bytes(), mediaTypeJpeg, ImageOptimization{StripMetadata: true}); unchanged != nil {
+		t.Error("JPEG without metadata changed")
+	}
+
+	b.Reset()
+	if err := png.Encode(&b, testImage(16, 16)); err != nil {
+		t.Fatal(err)
+	}
+	// tEXt chunk with its CRC, inserted after IHDR
+	text := []byte("\x00\x00\x00\x07tEXtKey\x00val\x00\x00\x00\x00")
+	ihdrEnd := 8 + 25
+	withText := append(append(append([]byte(nil), b.Bytes()[:ihdrEnd]...), text...), b.Bytes()[ihdrEnd:]...)
+
+	stripped, err = optimizeImage(withText, mediaTypePng, ImageOptimization{StripMetadata: true})
+	if err != nil {
+		t.Fatalf("Unexpected error stripping PNG metadata: %s", err)
+	}
+	if !bytes.Equal(stripped, b.Bytes()) {
+		t.Error("PNG metadata not stripped")
+	}
+}
+
+func TestImageOrientation(t *testing.T) {
+	// A red pixel left of a blue one
+	img := image.NewRGBA(image.Rect(0, 0, 2, 1))
+	img.Set(0, 0, color.RGBA{R: 0xff, A: 0xff})
+	img.Set(1, 0, color.RGBA{B: 0xff, A: 0xff})
+	var b bytes.Buffer
+	if err := png.Encode(&b, img); err != nil {
+		t.Fatal(err)
+	}
+	// eXIf chunk with a big-endian IFD holding the orientation 6 (rotated 90°
+	// clockwise)
+	exif := []byte("\x00\x00\x00\x1aeXIfMM\x00\x2a\x00\x00\x00\x08\x00\x01\x01\x12\x00\x03\x00\x00\x00\x01\x00\x06\x00\x00\x00\x00\x00\x00")
+	exif = binary.BigEndian.AppendUint32(exif, crc32.ChecksumIEEE(exif[4:]))
+	ihdrEnd := 8 + 25
+	withExif := append(append(append([]byte(nil), b.Bytes()[:ihdrEnd]...), exif...), b.Bytes()[ihdrEnd:]...)
+	if orientation := imageOrientation(withExif, mediaTypePng); orientation != 6 {
+		t.Fatalf("Got orientation %d, expected 6", orientation)
+	}
+
+	for _, o := range []ImageOptimization{{StripMetadata: true}, {RecompressPNG: true}} {
+		optimized, err := optimizeImage(withExif, mediaTypePng, o)
+		if err != nil {
+			t.Fatalf("Unexpected error optimizing image: %s", err)
+		}
+		rotated, err := png.Decode(bytes.NewReader(optimized))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rotated.Bounds().Dx() != 1 || rotated.Bounds().Dy() != 2 {
+			t.Fatalf("Got a %v image, expected 1x2", rotated.Bounds())
+		}
+		if r, _, bl, _ := rotated.At(0, 0).RGBA(); r != 0xffff || bl != 0 {
+			t.Errorf("Expected the red pixel at the top, got %v", rotated.At(0, 0))
+		}
+	}
+}
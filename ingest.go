@@ -0,0 +1,183 @@
+package epub
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Extensions of the files added by IngestFile and WatchDir
+var ingestExtensions = map[string]bool{
+	".htm":      true,
+	".html":     true,
+	".xhtml":    true,
+	".markdown": true,
+	".md":       true,
+}
+
+// Extensions of the Markdown files added by IngestFile and WatchDir
+var markdownExtensions = map[string]bool{
+	".markdown": true,
+	".md":       true,
+}
+
+// IngestFile adds the HTML file at path as a section and returns its internal
+// filename. The content of its <body> is used as the body of the section; the
+// title of the section is taken from its <title>, its first <h1> or its
+// filename. The internal filename is the filename of the file with an .xhtml
+// extension, or a generated one if it is already used.
+//
+// Files with a .md or .markdown extension are converted from Markdown as with
+// AddSectionFromMarkdown, and the images they reference are embedded.
+//
+// IngestFile can be called from the handler of a file system notification
+// library; WatchDir uses it to add the files dropped in a directory.
+func (e *Epub) IngestFile(path string) (string, error) {
+	return e.IngestFileContext(context.Background(), path)
+}
+
+// IngestFileContext is like IngestFile, stopping the retrieval of the images
+// of Markdown files when ctx is done.
+func (e *Epub) IngestFileContext(ctx context.Context, path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", &FileRetrievalError{Source: path, Err: err}
+	}
+
+	// The images of a Markdown file and the section are added in one step, so
+	// that the images can be removed if the section can't be added
+	e.Lock()
+	defer e.Unlock()
+	var images []string
+	if markdownExtensions[strings.ToLower(filepath.Ext(path))] {
+		var body string
+		body, images, err = e.markdownBody(ctx, data, &MarkdownOptions{EmbedImages: true, BaseDir: filepath.Dir(path)})
+		if err != nil {
+			return "", fmt.Errorf("can't convert %s: %w", path, err)
+		}
+		data = []byte("<body>" + body + "</body>")
+	}
+	doc, body, err := parseDocument(data)
+	if err != nil {
+		e.forgetImages(images)
+		return "", fmt.Errorf("can't parse %s: %w", path, err)
+	}
+	content, err := renderBody(body)
+	if err != nil {
+		e.forgetImages(images)
+		return "", err
+	}
+
+	base := filepath.Base(path)
+	name := strings.TrimSuffix(base, filepath.Ext(base))
+	title := documentTitle(doc)
+	if title == "" {
+		title = name
+	}
+
+	filename, err := e.addSection("", content, title, name+".xhtml", "")
+	if _, ok := err.(*FilenameAlreadyUsedError); ok {
+		filename, err = e.addSection("", content, title, "", "")
+	}
+	if err != nil {
+		e.forgetImages(images)
+	}
+	return filename, err
+}
+
+// parseDocument parses a whole HTML document and returns it along with its
+// <body> element
+func parseDocument(data []byte) (doc *html.Node, body *html.Node, err error) {
+	doc, err = html.Parse(bytes.NewReader(data))
+	if err != nil {
+		return nil, nil, err
+	}
+	body = findElement(doc, func(n *html.Node) bool {
+		return n.DataAtom == atom.Body
+	})
+	if body == nil {
+		body = doc
+	}
+	return doc, body, nil
+}
+
+// documentTitle returns the text of the <title> of an HTML document, or of its
+// first <h1> if it has no title
+func documentTitle(doc *html.Node) string {
+	for _, a := range []atom.Atom{atom.Title, atom.H1} {
+		if n := findElement(doc, func(n *html.Node) bool {
+			return n.DataAtom == a
+		}); n != nil && textContent(n) != "" {
+			return textContent(n)
+		}
+	}
+	return ""
+}
+
+// WatchDir adds the HTML files dropped in dir as sections, in the order of
+// their filenames, until ctx is done. It is meant for long-running ingestion,
+// like archiving serialized fiction as it is published.
+//
+// dir is scanned every interval. A file is added once its size and
+// modification time didn't change between two scans, so files still being
+// written aren't added; the files already in dir are added as well. Each file
+// is added once, even if it is later modified. onAdded, if not nil, is called
+// after each file is added with the internal filename of the section, or with
+// the error that prevented adding it.
+//
+// WatchDir returns nil when ctx is done, or an error if dir can't be read or
+// interval isn't positive.
+func (e *Epub) WatchDir(ctx context.Context, dir string, interval time.Duration, onAdded func(path string, internalFilename string, err error)) error {
+	if interval <= 0 {
+		return fmt.Errorf("can't watch %s: non-positive interval %s", dir, interval)
+	}
+	// Files seen in the previous scan but not added yet
+	pending := map[string]os.FileInfo{}
+	added := map[string]bool{}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return fmt.Errorf("can't watch %s: %w", dir, err)
+		}
+		for _, entry := range entries {
+			name := entry.Name()
+			if added[name] || !entry.Type().IsRegular() || !ingestExtensions[strings.ToLower(filepath.Ext(name))] {
+				continue
+			}
+			info, err := entry.Info()
+			if err != nil {
+				// The file was removed since the directory was read
+				continue
+			}
+			previous, ok := pending[name]
+			if !ok || previous.Size() != info.Size() || !previous.ModTime().Equal(info.ModTime()) {
+				pending[name] = info
+				continue
+			}
+
+			delete(pending, name)
+			added[name] = true
+			path := filepath.Join(dir, name)
+			filename, err := e.IngestFileContext(ctx, path)
+			if onAdded != nil {
+				onAdded(path, filename, err)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package epub
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestIngestFile(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"titled.html":   `<html><head><title>Page title</title></head><body><h1>Heading</h1><p>One<br>Two</p></body></html>`,
+		"heading.htm":   `<p>Intro</p><h1>First <em>heading</em></h1>`,
+		"untitled.html": `<p>Text</p>`,
+		"notes.md":      "# Notes\n\nSome *text*\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	e, err := NewEpub(testEpubTitle)
+	if err != nil {
+		t.Error(err)
+	}
+	tests := []struct {
+		file     string
+		filename string
+		title    string
+		body     string
+	}{
+		{"titled.html", "titled.xhtml", "Page title", `<h1>Heading</h1><p>One<br/>Two</p>`},
+		{"heading.htm", "heading.xhtml", "First heading", `<p>Intro</p><h1>First <em>heading</em></h1>`},
+		{"untitled.html", "untitled.xhtml", "untitled", `<p>Text</p>`},
+		{"notes.md", "notes.xhtml", "Notes", "<h1>Notes</h1>\n<p>Some <em>text</em></p>"},
+		// The filename is already used
+		{"untitled.html", "section0001.xhtml", "untitled", `<p>Text</p>`},
+	}
+	for _, tt := range tests {
+		filename, err := e.IngestFile(filepath.Join(dir, tt.file))
+		if err != nil {
+			t.Errorf("Error ingesting %s: %s", tt.file, err)
+			continue
+		}
+		if filename != tt.filename {
+			t.Errorf("Got internal filename %s for %s, expected %s", filename, tt.file, tt.filename)
+		}
+		s := findSection(e.sections, filename)
+		if s.xhtml.Title() != tt.title {
+			t.Errorf("Got title %q for %s, expected %q", s.xhtml.Title(), tt.file, tt.title)
+		}
+		if strings.TrimSpace(s.xhtml.body()) != tt.body {
+			t.Errorf("Got body %s for %s, expected %s", s.xhtml.body(), tt.file, tt.body)
+		}
+	}
+
+	_, err = e.IngestFile(filepath.Join(dir, "doesNotExist.html"))
+	if _, ok := err.(*FileRetrievalError); !ok {
+		t.Errorf("Expected error FileRetrievalError not returned. Returned instead: %+v", err)
+	}
+	// The images of a Markdown file are removed if the section can't be added
+	md := filepath.Join(dir, "image.md")
+	abs, err := filepath.Abs(testImageFromFileSource)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(md, []byte("![Gopher]("+filepath.ToSlash(abs)+")\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	transformErr := errors.New("transform error")
+	e.AddTransforms(func(body string) (string, error) {
+		return "", transformErr
+	})
+	if _, err := e.IngestFile(md); !errors.Is(err, transformErr) {
+		t.Errorf("Expected the transform error to be returned, got %v", err)
+	}
+	if len(e.images) != 0 {
+		t.Errorf("Images of a Markdown file not added kept: %v", e.images)
+	}
+}
+
+func TestWatchDir(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"02.html":   `<h1>Second</h1>`,
+		"01.html":   `<h1>First</h1>`,
+		"notes.txt": `Not a section`,
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	e, err := NewEpub(testEpubTitle)
+	if err != nil {
+		t.Error(err)
+	}
+
+	var mu sync.Mutex
+	var added []string
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- e.WatchDir(ctx, dir, 10*time.Millisecond, func(path string, internalFilename string, err error) {
+			if err != nil {
+				t.Errorf("Error adding %s: %s", path, err)
+			}
+			mu.Lock()
+			added = append(added, internalFilename)
+			mu.Unlock()
+		})
+	}()
+
+	waitFor := func(n int) {
+		for i := 0; i < 200; i++ {
+			mu.Lock()
+			l := len(added)
+			mu.Unlock()
+			if l >= n {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatalf("Timed out waiting for %d sections", n)
+	}
+	waitFor(2)
+	if err := os.WriteFile(filepath.Join(dir, "03.html"), []byte(`<h1>Third</h1>`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(3)
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("Unexpected error watching directory: %s", err)
+	}
+
+	var titles []string
+	for _, s := range e.Sections() {
+		titles = append(titles, s.Title)
+	}
+	if strings.Join(added, ",") != "01.xhtml,02.xhtml,03.xhtml" || strings.Join(titles, ",") != "First,Second,Third" {
+		t.Errorf("Unexpected sections added: %v %v", added, titles)
+	}
+
+	if err := e.WatchDir(context.Background(), filepath.Join(dir, "doesNotExist"), time.Millisecond, nil); err == nil {
+		t.Error("Expected an error watching a non-existent directory")
+	}
+	if err := e.WatchDir(context.Background(), dir, 0, nil); err == nil {
+		t.Error("Expected an error watching with a zero interval")
+	}
+}
@@ -4,12 +4,16 @@ import (
 	"io/fs"
 	"path"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/go-shiori/go-epub/internal/storage"
 )
 
+// Memory is a storage holding the files in memory. It is safe for concurrent
+// use, but each file must be written by a single goroutine.
 type Memory struct {
+	mu sync.RWMutex
 	fs map[string]*file
 }
 
@@ -35,6 +39,8 @@ func NewMemory() *Memory {
 // ValidPath(name), returning a *PathError with Err set to
 // ErrInvalid or ErrNotExist.
 func (m *Memory) Open(name string) (fs.File, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
 	var f fs.File
 	var ok bool
 	if f, ok = m.fs[name]; !ok {
@@ -54,6 +60,8 @@ func (m *Memory) WriteFile(name string, data []byte, perm fs.FileMode) error {
 		mode:    (perm),
 		content: data,
 	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	m.fs[name] = f
 	return nil
 }
@@ -68,12 +76,16 @@ func (m *Memory) Mkdir(name string, perm fs.FileMode) error {
 		modTime: time.Now(),
 		mode:    fs.ModeDir | (perm),
 	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	m.fs[name] = f
 	return nil
 }
 
 // RemoveAll removes path and any children it contains. It removes everything it can but returns the first error it encounters. If the path does not exist, RemoveAll returns nil (no error). If there is an error, it will be of type *PathError.
 func (m *Memory) RemoveAll(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	for k := range m.fs {
 		if strings.HasPrefix(k, name) {
 			delete(m.fs, k)
@@ -92,6 +104,8 @@ func (m *Memory) Create(name string) (storage.File, error) {
 		modTime: time.Now(),
 		mode:    0666,
 	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	m.fs[name] = f
 	return f, nil
 }
@@ -99,6 +113,8 @@ func (m *Memory) Create(name string) (storage.File, error) {
 // ReadDir reads the named directory
 // and returns a list of directory entries sorted by filename.
 func (m *Memory) ReadDir(name string) ([]fs.DirEntry, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
 	output := make([]fs.DirEntry, 0)
 	for k, v := range m.fs {
 		if path.Dir(k) == name {
@@ -112,6 +128,8 @@ func (m *Memory) ReadDir(name string) ([]fs.DirEntry, error) {
 // If there is an error, it should be of type *PathError.
 // This makes Memory compatible with the StatFS interface
 func (m *Memory) Stat(name string) (fs.FileInfo, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
 	f, ok := m.fs[name]
 	if !ok {
 		return nil, &fs.PathError{
@@ -0,0 +1,289 @@
+package epub
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Largest number of pixels of the images of the EPUBs made by Kindle, unless
+// KindleOptions.MaxImagePixels is set
+const defaultKindleImagePixels = 4000000
+
+// CSS declarations that Kindle readers ignore or render badly, by property,
+// with the values concerned or nil for any value
+var kindleUnsupportedCSS = map[string][]string{
+	"animation":    nil,
+	"column-count": nil,
+	"columns":      nil,
+	"display":      {"flex", "grid", "inline-flex", "inline-grid"},
+	"position":     {"fixed", "sticky"},
+	"transform":    nil,
+	"transition":   nil,
+}
+
+// cssDeclarationRegex matches the declarations of a stylesheet or a style
+// attribute, capturing the property and the value
+var cssDeclarationRegex = regexp.MustCompile(`(?i)([a-z-]+)\s*:\s*([^;{}]+)`)
+
+// KindleOptions configures Kindle and WriteKindle.
+type KindleOptions struct {
+	// Images with more pixels are scaled down to fit, keeping their aspect
+	// ratio. If 0, 4 megapixels; a stricter limit set with
+	// SetImageOptimization is kept.
+	MaxImagePixels int
+	// Program converting the EPUB to KF8, called by WriteKindle with the
+	// paths of the EPUB and of the KF8 file as arguments, e.g. ebook-convert
+	// of Calibre. If empty, WriteKindle writes the EPUB.
+	Converter string
+}
+
+// Kindle returns a copy of the EPUB meeting the constraints of Send to Kindle
+// and KDP, so that one build can target both the EPUB stores and Kindle:
+//
+//   - the audio and video files are removed, and the audio and video elements
+//     of the sections are replaced by their fallback content; the media
+//     overlays and audio tracks of the sections are dropped
+//   - the images are scaled down to opts.MaxImagePixels
+//   - the EPUB v2 table of contents (NCX) is written, see SetNcx
+//   - the cover page is listed in the guide, see SetLandmark
+//
+// The declarations of the stylesheets and sections that Kindle readers don't
+// support, such as flexible layouts and animations, are reported as warnings
+// of the copy, see Warnings. The stylesheets are fetched to check them.
+func (e *Epub) Kindle(opts KindleOptions) (*Epub, error) {
+	return e.KindleContext(context.Background(), opts)
+}
+
+// KindleContext is like Kindle, stopping the check of the stylesheets when
+// ctx is done.
+func (e *Epub) KindleContext(ctx context.Context, opts KindleOptions) (*Epub, error) {
+	if opts.MaxImagePixels < 0 {
+		return nil, fmt.Errorf("can't make Kindle EPUB: negative maximum image size")
+	}
+	e.Lock()
+	c := e.clone()
+	e.Unlock()
+
+	for _, folder := range []string{AudioFolderName, VideoFolderName} {
+		for filename := range c.mediaMap(folder) {
+			c.forgetResource(folder, filename)
+		}
+	}
+	for source, r := range c.remoteResources {
+		if r.mediaFolderName == AudioFolderName || r.mediaFolderName == VideoFolderName {
+			delete(c.remoteResources, source)
+		}
+	}
+	var err error
+	walkSections(c.sections, func(s *epubSection) {
+		s.overlay = nil
+		s.track = ""
+		if s.raw != "" {
+			c.checkKindleCSS(s.filename, sectionStyles(s.raw))
+			return
+		}
+		body, bodyErr := removeKindleMedia(s.xhtml.body())
+		if bodyErr != nil {
+			if err == nil {
+				err = fmt.Errorf("can't make Kindle EPUB: section %s: %w", s.filename, bodyErr)
+			}
+			return
+		}
+		s.xhtml.setBody(body)
+		c.checkKindleCSS(s.filename, sectionStyles(body))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	pixels := opts.MaxImagePixels
+	if pixels == 0 {
+		pixels = defaultKindleImagePixels
+	}
+	if c.imageOptimization.MaxPixels == 0 || c.imageOptimization.MaxPixels > pixels {
+		c.imageOptimization.MaxPixels = pixels
+	}
+	c.noNcx = false
+	if c.cover.xhtmlFilename != "" {
+		listed := false
+		for _, l := range c.landmarks {
+			listed = listed || l.epubType == LandmarkCover
+		}
+		if !listed {
+			c.landmarks = append([]landmark{{
+				filename: c.cover.xhtmlFilename,
+				epubType: LandmarkCover,
+			}}, c.landmarks...)
+		}
+	}
+
+	filenames := make([]string, 0, len(c.css))
+	for filename := range c.css {
+		filenames = append(filenames, filename)
+	}
+	sort.Strings(filenames)
+	g := c.grabber(ctx)
+	for _, filename := range filenames {
+		css, err := g.readMedia(c.css[filename])
+		if err != nil {
+			return nil, fmt.Errorf("can't make Kindle EPUB: %w", err)
+		}
+		c.checkKindleCSS(filename, string(css))
+	}
+	return c, nil
+}
+
+// WriteKindle writes the EPUB returned by Kindle to destFilePath. If
+// opts.Converter is set, the EPUB is converted to KF8 and destFilePath is the
+// KF8 file, e.g. book.azw3.
+func (e *Epub) WriteKindle(destFilePath string, opts KindleOptions) error {
+	return e.WriteKindleContext(context.Background(), destFilePath, opts)
+}
+
+// WriteKindleContext is like WriteKindle, stopping when ctx is done, the
+// converter included.
+func (e *Epub) WriteKindleContext(ctx context.Context, destFilePath string, opts KindleOptions) error {
+	c, err := e.KindleContext(ctx, opts)
+	if err != nil {
+		return err
+	}
+	if opts.Converter == "" {
+		return c.WriteContext(ctx, destFilePath)
+	}
+
+	dir, err := os.MkdirTemp(filepath.Dir(destFilePath), "go-epub-kindle")
+	if err != nil {
+		return fmt.Errorf("can't create temporary directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+	epubPath := filepath.Join(dir, strings.TrimSuffix(filepath.Base(destFilePath), filepath.Ext(destFilePath))+".epub")
+	if err := c.WriteContext(ctx, epubPath); err != nil {
+		return err
+	}
+	var output bytes.Buffer
+	cmd := exec.CommandContext(ctx, opts.Converter, epubPath, destFilePath)
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("can't convert %s to KF8: %w: %s", epubPath, err, strings.TrimSpace(output.String()))
+	}
+	return nil
+}
+
+// removeKindleMedia replaces the audio and video elements of body by their
+// fallback content
+func removeKindleMedia(body string) (string, error) {
+	if !strings.Contains(body, "<audio") && !strings.Contains(body, "<video") {
+		return body, nil
+	}
+	root, err := parseBody(body)
+	if err != nil {
+		return "", err
+	}
+	var media []*html.Node
+	walk(root, func(n *html.Node) bool {
+		if n.Type == html.ElementNode && (n.DataAtom == atom.Audio || n.DataAtom == atom.Video) {
+			media = append(media, n)
+			return false
+		}
+		return true
+	})
+	for _, n := range media {
+		for child := n.FirstChild; child != nil; {
+			next := child.NextSibling
+			n.RemoveChild(child)
+			if child.DataAtom != atom.Source && child.DataAtom != atom.Track {
+				n.Parent.InsertBefore(child, n)
+			}
+			child = next
+		}
+		n.Parent.RemoveChild(n)
+	}
+	return renderBody(root)
+}
+
+// sectionStyles returns the style elements and attributes of the body of a
+// section, one declaration block per line
+func sectionStyles(body string) string {
+	if !strings.Contains(body, "style") {
+		return ""
+	}
+	root, err := parseBody(body)
+	if err != nil {
+		return ""
+	}
+	var styles []string
+	walk(root, func(n *html.Node) bool {
+		if n.Type != html.ElementNode {
+			return true
+		}
+		if n.DataAtom == atom.Style {
+			styles = append(styles, textContent(n))
+		}
+		if style := getAttr(n, "style"); style != "" {
+			styles = append(styles, style)
+		}
+		return true
+	})
+	return strings.Join(styles, "\n")
+}
+
+// checkKindleCSS reports the declarations of css, a stylesheet or the styles
+// of a section from source, that Kindle readers don't support
+func (e *Epub) checkKindleCSS(source string, css string) {
+	reported := make(map[string]bool)
+	for _, m := range cssDeclarationRegex.FindAllStringSubmatch(css, -1) {
+		property := strings.ToLower(m[1])
+		value := strings.ToLower(strings.TrimSpace(m[2]))
+		values, ok := kindleUnsupportedCSS[property]
+		if !ok {
+			continue
+		}
+		declaration := property
+		if values != nil {
+			supported := true
+			for _, v := range values {
+				supported = supported && v != value
+			}
+			if supported {
+				continue
+			}
+			declaration += ": " + value
+		}
+		if !reported[declaration] {
+			reported[declaration] = true
+			e.addWarning(WarningUnsupportedCSS, source, "%s isn't supported by Kindle readers", declaration)
+		}
+	}
+}
+
+// readMedia returns the content of mediaSource
+func (g grabber) readMedia(mediaSource string) ([]byte, error) {
+	once := g
+	once.retryPolicy.MaxRetries = 0
+	var content []byte
+	err := g.retry(mediaSource, func() error {
+		source, _, _, err := once.openMedia(mediaSource)
+		if err != nil {
+			return err
+		}
+		defer source.Close()
+		content, err = io.ReadAll(source)
+		if err != nil {
+			return &FileRetrievalError{Source: mediaSource, Err: err}
+		}
+		return nil
+	})
+	return content, err
+}
@@ -0,0 +1,167 @@
+package epub
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestEpubKindle(t *testing.T) {
+	e, err := NewEpub(testEpubTitle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.SetNcx(false)
+	coverPath, err := e.AddImage(testImageFromFileSource, "cover.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.SetCover(coverPath, ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.AddCSSFromBytes([]byte("p { text-transform: uppercase; }\nnav { display: flex; }\n.box { display: block; transition: color 1s; }"), "style.css"); err != nil {
+		t.Fatal(err)
+	}
+	audioPath, err := e.AddAudio(testAudioFromFileSource, "audio.wav")
+	if err != nil {
+		t.Fatal(err)
+	}
+	remotePath, err := e.AddRemoteVideo("https://example.com/video.mp4", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	chapter, err := e.AddSection(`<p>Listen</p><audio controls="controls" src="`+audioPath+`"><p>No audio</p></audio>`+
+		`<video><source src="`+remotePath+`" type="video/mp4" />Fallback</video><p style="position: fixed">End</p>`, "Chapter", "chapter.xhtml", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	k, err := e.Kindle(KindleOptions{})
+	if err != nil {
+		t.Fatalf("Error making Kindle EPUB: %s", err)
+	}
+	if len(e.Audios()) != 1 || len(e.Warnings()) != 0 {
+		t.Error("Kindle modified the original EPUB")
+	}
+	if len(k.Audios()) != 0 {
+		t.Errorf("Audios not removed: %v", k.Audios())
+	}
+	body := findSection(k.sections, chapter).xhtml.body()
+	if strings.Contains(body, "<audio") || strings.Contains(body, "<video") || strings.Contains(body, remotePath) {
+		t.Errorf("Audio and video elements not removed:\n%s", body)
+	}
+	if !strings.Contains(body, "<p>No audio</p>") || !strings.Contains(body, "Fallback") {
+		t.Errorf("Fallback content not kept:\n%s", body)
+	}
+	if k.imageOptimization.MaxPixels != defaultKindleImagePixels {
+		t.Errorf("Image size cap %d, expected %d", k.imageOptimization.MaxPixels, defaultKindleImagePixels)
+	}
+
+	var got []string
+	for _, w := range k.Warnings() {
+		if w.Kind == WarningUnsupportedCSS {
+			got = append(got, w.Source+": "+w.Message)
+		}
+	}
+	want := []string{
+		chapter + ": position: fixed isn't supported by Kindle readers",
+		"style.css: display: flex isn't supported by Kindle readers",
+		"style.css: transition isn't supported by Kindle readers",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("CSS warnings:\n%s\nexpected:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	var buf bytes.Buffer
+	if _, err := k.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	opf, err := readOpenedFile(buf.Bytes(), "EPUB/package.opf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(opf, "audio.wav") || strings.Contains(opf, "video.mp4") {
+		t.Errorf("Audio and video listed in the manifest:\n%s", opf)
+	}
+	if !strings.Contains(opf, `<reference type="cover"`) {
+		t.Errorf("Cover not listed in the guide:\n%s", opf)
+	}
+	if _, err := readOpenedFile(buf.Bytes(), "EPUB/toc.ncx"); err != nil {
+		t.Errorf("NCX not written: %s", err)
+	}
+
+	if _, err := e.Kindle(KindleOptions{MaxImagePixels: -1}); err == nil {
+		t.Error("Expected an error for a negative image size")
+	}
+}
+
+func TestEpubKindleReaderAudio(t *testing.T) {
+	e, err := NewEpub(testEpubTitle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(testAudioFromFileSource)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := e.AddAudioFromReader(f, "audio.mp3"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.Kindle(KindleOptions{}); err != nil {
+		t.Fatalf("Error making Kindle EPUB: %s", err)
+	}
+	// Removing the audio file from the copy keeps it in the EPUB
+	if err := e.Write(filepath.Join(t.TempDir(), testEpubFilename)); err != nil {
+		t.Errorf("Error writing EPUB after Kindle: %s", err)
+	}
+}
+
+func TestEpubWriteKindle(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the converter is a shell script")
+	}
+	e, err := NewEpub(testEpubTitle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.AddSection(testSectionBody, testSectionTitle, "", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	converter := filepath.Join(dir, "convert")
+	if err := os.WriteFile(converter, []byte("#!/bin/sh\ncase \"$1\" in *.epub) cp \"$1\" \"$2\";; *) exit 1;; esac\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	dest := filepath.Join(dir, "book.azw3")
+	if err := e.WriteKindle(dest, KindleOptions{Converter: converter}); err != nil {
+		t.Fatalf("Error writing Kindle book: %s", err)
+	}
+	data, err := os.ReadFile(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readOpenedFile(data, "EPUB/package.opf"); err != nil {
+		t.Errorf("Converter not given the EPUB: %s", err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("Temporary files left in %s: %d entries", dir, len(entries))
+	}
+
+	failing := filepath.Join(dir, "fail")
+	if err := os.WriteFile(failing, []byte("#!/bin/sh\necho conversion failed\nexit 1\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	err = e.WriteKindle(filepath.Join(dir, "other.azw3"), KindleOptions{Converter: failing})
+	if err == nil || !strings.Contains(err.Error(), "conversion failed") {
+		t.Errorf("Expected the output of the failing converter, got %v", err)
+	}
+}
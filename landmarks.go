@@ -0,0 +1,109 @@
+package epub
+
+import "path/filepath"
+
+// Structural semantics commonly used as landmarks. Reading systems use them
+// for shortcuts like "Go to beginning" or "Go to table of contents".
+//
+// Spec: https://www.w3.org/TR/epub-ssv-11/
+const (
+	LandmarkAcknowledgments = "acknowledgments"
+	LandmarkBibliography    = "bibliography"
+	LandmarkBodyMatter      = "bodymatter"
+	LandmarkColophon        = "colophon"
+	LandmarkCopyrightPage   = "copyright-page"
+	LandmarkCover           = "cover"
+	LandmarkDedication      = "dedication"
+	LandmarkEpigraph        = "epigraph"
+	LandmarkForeword        = "foreword"
+	LandmarkGlossary        = "glossary"
+	LandmarkIndex           = "index"
+	LandmarkLoi             = "loi"
+	LandmarkLot             = "lot"
+	LandmarkPreface         = "preface"
+	LandmarkTitlePage       = "titlepage"
+	LandmarkTOC             = "toc"
+)
+
+// Types of the EPUB v2 guide references differing from the EPUB v3 structural
+// semantics. Other semantics without an equivalent are written as
+// "other.<semantic>".
+var guideTypes = map[string]string{
+	LandmarkAcknowledgments: "acknowledgements",
+	LandmarkBibliography:    "bibliography",
+	LandmarkBodyMatter:      "text",
+	LandmarkColophon:        "colophon",
+	LandmarkCopyrightPage:   "copyright-page",
+	LandmarkCover:           "cover",
+	LandmarkDedication:      "dedication",
+	LandmarkEpigraph:        "epigraph",
+	LandmarkForeword:        "foreword",
+	LandmarkGlossary:        "glossary",
+	LandmarkIndex:           "index",
+	LandmarkLoi:             "loi",
+	LandmarkLot:             "lot",
+	LandmarkPreface:         "preface",
+	LandmarkTitlePage:       "title-page",
+	LandmarkTOC:             "toc",
+}
+
+type landmark struct {
+	filename string // Internal filename of the section, empty for the TOC
+	epubType string
+}
+
+// SetLandmark marks an already-added section as a landmark of the given type,
+// one of the Landmark* constants or another structural semantic. The
+// landmarks are written in the navigation document and in the EPUB v2 guide,
+// in the order they are set.
+//
+// An empty internal filename points the landmark to the table of contents
+// generated by go-epub, typically with LandmarkTOC. An empty epubType removes
+// the landmark of the section.
+func (e *Epub) SetLandmark(internalFilename string, epubType string) error {
+	e.Lock()
+	defer e.Unlock()
+	if internalFilename != "" && findSection(e.sections, internalFilename) == nil {
+		return &SectionDoesNotExistError{Filename: internalFilename}
+	}
+
+	for i, l := range e.landmarks {
+		if l.filename == internalFilename {
+			e.landmarks = append(e.landmarks[:i], e.landmarks[i+1:]...)
+			break
+		}
+	}
+	if epubType != "" {
+		e.landmarks = append(e.landmarks, landmark{
+			filename: internalFilename,
+			epubType: epubType,
+		})
+	}
+	return nil
+}
+
+// writeLandmarks adds the landmarks to the TOC and to the package file
+func (e *Epub) writeLandmarks() {
+	for _, l := range e.landmarks {
+		relativePath := tocNavFilename
+		title := e.toc.navXML.H1
+		if l.filename != "" {
+			section := findSection(e.sections, l.filename)
+			if section == nil {
+				continue
+			}
+			relativePath = filepath.Join(xhtmlFolderName, l.filename)
+			title = section.xhtml.Title()
+		}
+		if title == "" {
+			title = l.epubType
+		}
+
+		guideType, ok := guideTypes[l.epubType]
+		if !ok {
+			guideType = "other." + l.epubType
+		}
+		e.toc.addLandmark(l.epubType, title, relativePath)
+		e.pkg.addToGuide(guideType, title, relativePath)
+	}
+}
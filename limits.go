@@ -0,0 +1,228 @@
+package epub
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/gabriel-vasile/mimetype"
+)
+
+// Limits bounds what can be added to an EPUB, so that services building EPUBs
+// for untrusted callers can bound the resources these use. Zero values aren't
+// checked.
+type Limits struct {
+	// Number of sections, subsections included
+	MaxSections int
+	// Number of CSS, font, image, video and audio files
+	MaxResources int
+	// Size in bytes of a single resource, checked when it is added if its
+	// size is known, e.g. from the Content-Length of a URL, and by Write while
+	// it is retrieved
+	MaxResourceSize int64
+	// Size in bytes of all the files of the EPUB before compression, checked
+	// by Write
+	MaxTotalSize int64
+	// Number of nested levels of the table of contents
+	MaxTOCDepth int
+	// Make Write fail with a MediaTypeMismatchError when the content of a
+	// resource doesn't match its kind, e.g. an HTML error page added with
+	// AddImage, or the media type declared by its server or Fetcher
+	VerifyMediaTypes bool
+}
+
+// LimitExceededError is thrown by the Add* methods, the TOC methods and Write
+// when a limit set with SetLimits is exceeded.
+type LimitExceededError struct {
+	Limit string // Name of the Limits field, e.g. "MaxSections"
+	Max   int64  // Value of the limit
+	Name  string // File or source exceeding the limit, if any
+}
+
+func (e *LimitExceededError) Error() string {
+	if e.Name != "" {
+		return fmt.Sprintf("Limit %s of %d exceeded by %s", e.Limit, e.Max, e.Name)
+	}
+	return fmt.Sprintf("Limit %s of %d exceeded", e.Limit, e.Max)
+}
+
+// MediaTypeMismatchError is thrown by Write when the content of a resource
+// doesn't match its media type and Limits.VerifyMediaTypes is set.
+type MediaTypeMismatchError struct {
+	Source   string // The source of the resource
+	Expected string // The media type declared, or the folder of the resource, e.g. "images"
+	Detected string // The media type detected from the content
+}
+
+func (e *MediaTypeMismatchError) Error() string {
+	return fmt.Sprintf("Content of %s detected as %s doesn't match %s", e.Source, e.Detected, e.Expected)
+}
+
+// SetLimits sets the limits enforced by the following calls to the Add*
+// methods and Write. A nil limits removes them, the default.
+func (e *Epub) SetLimits(limits *Limits) {
+	e.Lock()
+	defer e.Unlock()
+	if limits == nil {
+		e.limits = nil
+		return
+	}
+	l := *limits
+	e.limits = &l
+}
+
+// checkSectionLimits checks that a section can be added under the section
+// parentFilename, or at the top level if it is empty
+func (e *Epub) checkSectionLimits(parentFilename string) error {
+	if e.limits == nil {
+		return nil
+	}
+	if max := e.limits.MaxSections; max > 0 && len(getFilenames(e.sections)) >= max {
+		return &LimitExceededError{Limit: "MaxSections", Max: int64(max)}
+	}
+	depth := 1
+	if parentFilename != "" {
+		depth += sectionDepth(e.sections, parentFilename)
+	}
+	return e.checkTOCDepth(depth)
+}
+
+// checkTOCDepth checks that the table of contents can have depth levels
+func (e *Epub) checkTOCDepth(depth int) error {
+	if e.limits == nil {
+		return nil
+	}
+	if max := e.limits.MaxTOCDepth; max > 0 && depth > max {
+		return &LimitExceededError{Limit: "MaxTOCDepth", Max: int64(max)}
+	}
+	return nil
+}
+
+// addResource adds a resource to mediaMap like addMedia, checking the limits,
+// unless it duplicates a resource already added
+func (e *Epub) addResource(ctx context.Context, source string, internalFilename string, mediaFileFormat string, mediaFolderName string, mediaMap map[string]string) (string, error) {
+	g := e.grabber(ctx)
+	if p := e.duplicateResource(g, source, mediaFolderName, mediaMap); p != "" {
+		return p, nil
+	}
+	if e.limits != nil && e.limits.MaxResources > 0 {
+		n := len(e.css) + len(e.fonts) + len(e.images) + len(e.videos) + len(e.audios)
+		if n >= e.limits.MaxResources {
+			return "", &LimitExceededError{Limit: "MaxResources", Max: int64(e.limits.MaxResources), Name: source}
+		}
+	}
+	e.checkDuplicateLink(source, mediaFolderName, mediaMap)
+	p, err := addMedia(g, source, internalFilename, mediaFileFormat, mediaFolderName, mediaMap)
+	if err != nil {
+		return "", err
+	}
+	e.checkResource(g, source, path.Join(mediaFolderName, path.Base(p)), mediaFolderName)
+	return p, nil
+}
+
+// sectionDepth returns the nesting level of a section, 1 for the top level
+// sections, or 0 if it doesn't exist
+func sectionDepth(sections []*epubSection, filename string) int {
+	for _, s := range sections {
+		if s.filename == filename {
+			return 1
+		}
+		if depth := sectionDepth(s.children, filename); depth > 0 {
+			return depth + 1
+		}
+	}
+	return 0
+}
+
+// tocEntryDepth returns the nesting level of a TOC entry, 1 for the top level
+// entries, or 0 if it isn't in entries
+func tocEntryDepth(entries []*TOCEntry, entry *TOCEntry) int {
+	for _, e := range entries {
+		if e == entry {
+			return 1
+		}
+		if depth := tocEntryDepth(e.children, entry); depth > 0 {
+			return depth + 1
+		}
+	}
+	return 0
+}
+
+// tocEntryHeight returns the number of levels of a TOC entry and its children
+func tocEntryHeight(entry *TOCEntry) int {
+	height := 0
+	for _, c := range entry.children {
+		if h := tocEntryHeight(c); h > height {
+			height = h
+		}
+	}
+	return height + 1
+}
+
+// limitedReader reads from r, failing with a LimitExceededError once more
+// than max bytes are read
+type limitedReader struct {
+	r      io.Reader
+	max    int64
+	n      int64
+	source string
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	l.n += int64(n)
+	if l.n > l.max {
+		return n, &LimitExceededError{Limit: "MaxResourceSize", Max: l.max, Name: l.source}
+	}
+	return n, err
+}
+
+// checkSize checks the size of a resource known before it is retrieved
+func (g grabber) checkSize(mediaSource string, size int64) error {
+	if g.maxSize > 0 && size > g.maxSize {
+		return &LimitExceededError{Limit: "MaxResourceSize", Max: g.maxSize, Name: mediaSource}
+	}
+	return nil
+}
+
+// verifyMediaType checks that the content of mediaSource, detected as
+// detected, fits the folder it is added to and the media type declared for it
+// by its server or Fetcher, if any
+func verifyMediaType(mediaSource string, mediaFolderName string, detected *mimetype.MIME, declared string) error {
+	if !mediaTypeFits(detected, mediaFolderName) {
+		return &MediaTypeMismatchError{
+			Source:   mediaSource,
+			Expected: mediaFolderName,
+			Detected: detected.String(),
+		}
+	}
+	declaredKind := mediaKind(declared)
+	if declaredKind == "" {
+		return nil
+	}
+	for m := detected; m != nil; m = m.Parent() {
+		if mediaKind(m.String()) == declaredKind {
+			return nil
+		}
+	}
+	return &MediaTypeMismatchError{Source: mediaSource, Expected: declared, Detected: detected.String()}
+}
+
+// mediaKind returns the kind of the media type mediaType, as far as it can be
+// told apart by content sniffing, or an empty string for any kind
+func mediaKind(mediaType string) string {
+	mediaType, _, _ = strings.Cut(mediaType, ";")
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+	major, _, _ := strings.Cut(mediaType, "/")
+	switch {
+	case mediaType == "" || mediaType == "application/octet-stream" || mediaType == "binary/octet-stream":
+		return ""
+	case strings.Contains(mediaType, "font"):
+		return "font"
+	case major == "audio" || major == "video" || mediaType == "application/ogg":
+		return "media"
+	}
+	return major
+}
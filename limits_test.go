@@ -0,0 +1,171 @@
+package epub
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/gabriel-vasile/mimetype"
+)
+
+func TestSetLimits(t *testing.T) {
+	e, err := NewEpub(testEpubTitle)
+	if err != nil {
+		t.Error(err)
+	}
+	e.SetLimits(&Limits{
+		MaxSections:  2,
+		MaxResources: 1,
+		MaxTOCDepth:  2,
+	})
+
+	expectLimit := func(err error, limit string) {
+		t.Helper()
+		var limitErr *LimitExceededError
+		if !errors.As(err, &limitErr) || limitErr.Limit != limit {
+			t.Errorf("Expected %s to be exceeded, got %v", limit, err)
+		}
+	}
+
+	if _, err := e.AddImage(testImageFromFileSource, ""); err != nil {
+		t.Errorf("Error adding image: %s", err)
+	}
+	_, err = e.AddCSS(testCoverCSSSource, "")
+	expectLimit(err, "MaxResources")
+
+	if _, err := e.AddSection(testSectionBody, testSectionTitle, testSectionFilename, ""); err != nil {
+		t.Errorf("Error adding section: %s", err)
+	}
+	if _, err := e.AddSubSection(testSectionFilename, testSectionBody, testSectionTitle, "sub.xhtml", ""); err != nil {
+		t.Errorf("Error adding subsection: %s", err)
+	}
+	_, err = e.AddSection(testSectionBody, testSectionTitle, "", "")
+	expectLimit(err, "MaxSections")
+	expectLimit(e.AddTOCEntry("sub.xhtml", "fragment", "Fragment"), "MaxTOCDepth")
+	if err := e.AddTOCEntry(testSectionFilename, "fragment", "Fragment"); err != nil {
+		t.Errorf("Error adding TOC entry: %s", err)
+	}
+
+	toc := e.TOC()
+	parent, err := toc.AddEntry(nil, "Part", testSectionFilename, "")
+	if err != nil {
+		t.Errorf("Error adding TOC entry: %s", err)
+	}
+	child, err := toc.AddEntry(parent, "Chapter", "sub.xhtml", "")
+	if err != nil {
+		t.Errorf("Error adding TOC entry: %s", err)
+	}
+	_, err = toc.AddEntry(child, "Fragment", "sub.xhtml", "fragment")
+	expectLimit(err, "MaxTOCDepth")
+	other, err := toc.AddEntry(nil, "Other", testSectionFilename, "")
+	if err != nil {
+		t.Errorf("Error adding TOC entry: %s", err)
+	}
+	expectLimit(toc.Nest(parent, other), "MaxTOCDepth")
+	if len(toc.Entries()) != 2 {
+		t.Errorf("Entry removed by a failed Nest: %v", toc.Entries())
+	}
+
+	if _, err := e.WriteTo(io.Discard); err != nil {
+		t.Errorf("Error writing EPUB: %s", err)
+	}
+	e.SetLimits(&Limits{MaxResourceSize: 100})
+	_, err = e.WriteTo(io.Discard)
+	expectLimit(err, "MaxResourceSize")
+	e.SetLimits(&Limits{MaxTotalSize: 1000})
+	_, err = e.WriteTo(io.Discard)
+	expectLimit(err, "MaxTotalSize")
+
+	e.SetLimits(nil)
+	if _, err := e.AddCSS(testCoverCSSSource, ""); err != nil {
+		t.Errorf("Error adding CSS: %s", err)
+	}
+}
+
+func TestMaxResourceSizeOnAdd(t *testing.T) {
+	image, err := os.ReadFile(testImageFromFileSource)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(image)
+	}))
+	defer ts.Close()
+
+	e, err := NewEpub(testEpubTitle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.SetLimits(&Limits{MaxResourceSize: 100})
+	for _, source := range []string{testImageFromFileSource, ts.URL + "/image.png"} {
+		_, err := e.AddImage(source, "")
+		var limitErr *LimitExceededError
+		if !errors.As(err, &limitErr) || limitErr.Limit != "MaxResourceSize" {
+			t.Errorf("Expected MaxResourceSize to be exceeded adding %s, got %v", source, err)
+		}
+	}
+	if len(e.images) != 0 {
+		t.Errorf("Images added over the limit: %v", e.images)
+	}
+}
+
+func TestVerifyMediaTypes(t *testing.T) {
+	image, err := os.ReadFile(testImageFromFileSource)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/image.png":
+			w.Header().Set("Content-Type", "image/png")
+			w.Write(image)
+		case "/error.png":
+			w.Header().Set("Content-Type", "image/png")
+			w.Write([]byte("<!DOCTYPE html><html><body>Not found</body></html>"))
+		case "/font.ttf":
+			w.Header().Set("Content-Type", "font/ttf")
+			w.Write(image)
+		}
+	}))
+	defer ts.Close()
+
+	addImage := func(e *Epub, source string) (string, error) { return e.AddImage(source, "") }
+	addFont := func(e *Epub, source string) (string, error) { return e.AddFont(source, "") }
+	tests := []struct {
+		source  string
+		add     func(e *Epub, source string) (string, error)
+		wantErr bool
+	}{
+		{ts.URL + "/image.png", addImage, false},
+		{ts.URL + "/error.png", addImage, true},
+		{ts.URL + "/font.ttf", addFont, true},
+	}
+	for _, test := range tests {
+		e, err := NewEpub(testEpubTitle)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := test.add(e, test.source); err != nil {
+			t.Fatalf("Error adding %s: %s", test.source, err)
+		}
+		if _, err := e.WriteTo(io.Discard); err != nil {
+			t.Errorf("Error writing EPUB with %s without verification: %s", test.source, err)
+		}
+		e.SetLimits(&Limits{VerifyMediaTypes: true})
+		_, err = e.WriteTo(io.Discard)
+		var mismatchErr *MediaTypeMismatchError
+		if isMismatch := errors.As(err, &mismatchErr); isMismatch != test.wantErr {
+			t.Errorf("Writing EPUB with %s returned %v, expected a MediaTypeMismatchError: %t", test.source, err, test.wantErr)
+		}
+	}
+
+	if err := verifyMediaType("style.css", CSSFolderName, mimetype.Detect([]byte("p { margin: 0 }")), "text/css; charset=utf-8"); err != nil {
+		t.Errorf("CSS served as text/css rejected: %s", err)
+	}
+	if err := verifyMediaType("font.woff", FontFolderName, mimetype.Detect([]byte("wOFF\x00\x01\x00\x00")), "application/octet-stream"); err != nil {
+		t.Errorf("Font served as application/octet-stream rejected: %s", err)
+	}
+}
@@ -0,0 +1,158 @@
+package epub
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// BrokenLink is a link of a section that doesn't resolve, found by
+// CheckLinks.
+type BrokenLink struct {
+	// Internal filename of the section
+	Section string
+	// Value of the href or src attribute, e.g. "../images/missing.png"
+	Link   string
+	Reason string
+}
+
+func (l BrokenLink) String() string {
+	return fmt.Sprintf("%s: %s: %s", l.Section, l.Link, l.Reason)
+}
+
+// BrokenLinksError is thrown by Write when links of the sections don't resolve
+// and the link check is enabled with SetLinkCheck.
+type BrokenLinksError struct {
+	Links []BrokenLink
+}
+
+func (e *BrokenLinksError) Error() string {
+	links := make([]string, len(e.Links))
+	for i, l := range e.Links {
+		links[i] = l.String()
+	}
+	return fmt.Sprintf("Broken links: %s", strings.Join(links, "; "))
+}
+
+// CheckLinks returns the links of the section bodies that don't resolve: the
+// href and src attributes pointing to a section or resource that wasn't added,
+// to a fragment missing from its section, or to an invalid URL. Broken
+// internal links are the most common cause of rejected uploads. Links are
+// resolved from the folder of the sections, e.g. "../images/image0001.png" or
+// "section0002.xhtml#part2"; external URLs aren't fetched.
+func (e *Epub) CheckLinks() []BrokenLink {
+	e.Lock()
+	defer e.Unlock()
+	return e.checkLinks()
+}
+
+// SetLinkCheck sets whether Write checks the links of the sections first, as
+// CheckLinks does, failing with a BrokenLinksError if any doesn't resolve.
+func (e *Epub) SetLinkCheck(enabled bool) {
+	e.Lock()
+	defer e.Unlock()
+	e.linkCheck = enabled
+}
+
+func (e *Epub) checkLinks() []BrokenLink {
+	bodies := make(map[string]*html.Node)
+	ids := make(map[string]map[string]bool)
+	var sections []*epubSection
+	walkSections(e.sections, func(s *epubSection) {
+		ids[s.filename] = make(map[string]bool)
+		var doc *html.Node
+		var err error
+		if s.raw != "" {
+			doc, err = html.Parse(strings.NewReader(s.raw))
+		} else {
+			// The footnotes are part of the body once written
+			doc, err = parseBody(e.placeNotes(s, s.xhtml).body())
+		}
+		if err != nil {
+			return
+		}
+		sections = append(sections, s)
+		bodies[s.filename] = doc
+		walk(doc, func(n *html.Node) bool {
+			if id := getAttr(n, "id"); id != "" {
+				ids[s.filename][id] = true
+			}
+			return true
+		})
+	})
+	if e.notePlacement == NotesAsEndnotes {
+		endnotes := make(map[string]bool)
+		walkSections(e.sections, func(s *epubSection) {
+			for _, n := range sectionNotes(s) {
+				endnotes[n.id()] = true
+			}
+		})
+		ids[e.endnotesFilename()] = endnotes
+	}
+
+	var broken []BrokenLink
+	for _, s := range sections {
+		walk(bodies[s.filename], func(n *html.Node) bool {
+			for _, key := range []string{"href", "src", "poster"} {
+				link := getAttr(n, key)
+				if link == "" {
+					continue
+				}
+				if reason := e.checkLink(s.filename, link, ids); reason != "" {
+					broken = append(broken, BrokenLink{Section: s.filename, Link: link, Reason: reason})
+				}
+			}
+			return true
+		})
+	}
+	return broken
+}
+
+// checkLink returns why link, found in the section sectionFilename, doesn't
+// resolve, or an empty string if it does. ids are the IDs of the sections, by
+// internal filename.
+func (e *Epub) checkLink(sectionFilename string, link string, ids map[string]map[string]bool) string {
+	u, err := url.Parse(strings.TrimSpace(link))
+	if err != nil {
+		return "invalid URL"
+	}
+	if u.Scheme != "" {
+		if (u.Scheme == "http" || u.Scheme == "https") && u.Host == "" {
+			return "URL without host"
+		}
+		return ""
+	}
+	if u.Host != "" || strings.HasPrefix(u.Path, "/") {
+		return "absolute path outside of the EPUB"
+	}
+
+	target := sectionFilename
+	if u.Path != "" {
+		// Sections are in the xhtml folder, next to the resource folders
+		p := path.Join(xhtmlFolderName, u.Path)
+		folder, filename := path.Split(p)
+		folder = strings.TrimSuffix(folder, "/")
+		switch {
+		case p == tocNavFilename:
+			return ""
+		case folder == xhtmlFolderName:
+			if _, ok := ids[filename]; !ok {
+				return "no section with this filename"
+			}
+			target = filename
+		default:
+			mediaMap := e.mediaMap(folder)
+			if _, ok := mediaMap[filename]; !ok {
+				return "no resource with this path"
+			}
+			return ""
+		}
+	}
+	if u.Fragment != "" && !ids[target][u.Fragment] {
+		return fmt.Sprintf("no element with ID %q in %s", u.Fragment, target)
+	}
+	return ""
+}
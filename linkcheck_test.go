@@ -0,0 +1,67 @@
+package epub
+
+import (
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestCheckLinks(t *testing.T) {
+	e, err := NewEpub(testEpubTitle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	imagePath, err := e.AddImage(testImageFromFileSource, "image.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := `<h1 id="top">One</h1>
+<p><img src="` + imagePath + `" alt="" /> <a href="section0002.xhtml#part">Next</a> <a href="#top">Top</a> <a href="../nav.xhtml">Contents</a></p>
+<p><a href="https://example.com/page">Web</a> <a href="mailto:author@example.com">Mail</a></p>`
+	if _, err := e.AddSection(body, "One", "section0001.xhtml", ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.AddSection(`<p id="part">Part</p>`, "Two", "section0002.xhtml", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.AddFootnote("section0002.xhtml", "part", "A note"); err != nil {
+		t.Fatal(err)
+	}
+	for _, placement := range []NotePlacement{NotesAsFootnotes, NotesAsEndnotes} {
+		e.SetNotePlacement(placement)
+		if links := e.CheckLinks(); len(links) != 0 {
+			t.Errorf("Unexpected broken links with placement %d: %v", placement, links)
+		}
+	}
+
+	if _, err := e.AddSection(`<p><a href="missing.xhtml">Missing</a> <a href="section0001.xhtml#nowhere">Nowhere</a> <img src="../images/missing.png" alt="" /> <a href="http:///path">Bad</a></p>`, "Three", "section0003.xhtml", ""); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]bool{
+		"missing.xhtml":             true,
+		"section0001.xhtml#nowhere": true,
+		"../images/missing.png":     true,
+		"http:///path":              true,
+	}
+	for _, l := range e.CheckLinks() {
+		if l.Section == "section0003.xhtml" {
+			if !want[l.Link] {
+				t.Errorf("Unexpected broken link: %s", l)
+			}
+			delete(want, l.Link)
+		}
+	}
+	for link := range want {
+		t.Errorf("Broken link %s not found", link)
+	}
+
+	if _, err := e.WriteTo(io.Discard); err != nil {
+		t.Errorf("Error writing EPUB without link check: %s", err)
+	}
+	e.SetLinkCheck(true)
+	_, err = e.WriteTo(io.Discard)
+	var linksErr *BrokenLinksError
+	if !errors.As(err, &linksErr) || len(linksErr.Links) < 4 {
+		t.Errorf("Expected BrokenLinksError, got %v", err)
+	}
+}
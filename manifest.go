@@ -0,0 +1,105 @@
+package epub
+
+import (
+	"context"
+	"mime"
+	"path/filepath"
+	"strings"
+)
+
+// Manifest describes the files Write packages into the EPUB, as returned by
+// Epub.Manifest.
+type Manifest struct {
+	// Items of the package document manifest, in the order they are written
+	Items []ManifestItem
+	// Items of the reading order
+	Spine []SpineItem
+	// Entries at the root of the table of contents
+	TOC []*TOCEntry
+}
+
+// ManifestItem is a file of the EPUB listed in the package document.
+type ManifestItem struct {
+	ID string
+	// Path relative to the package document, e.g. xhtml/section0001.xhtml,
+	// or URL of a remote resource
+	Href       string
+	MediaType  string
+	Properties string // Space separated, e.g. "nav" or "cover-image"
+	Fallback   string // ID of the item used if this one isn't supported
+}
+
+// SpineItem is an entry of the reading order.
+type SpineItem struct {
+	ID     string // ID of the manifest item
+	Linear bool
+}
+
+// Manifest returns a description of everything Write would package, so that
+// the EPUB can be audited before the expensive Write step: the manifest items
+// with their media types and properties, the reading order and the table of
+// contents. The sections are rendered but the resources aren't fetched, so
+// their media types are guessed from their filenames instead of being
+// detected from their content, and the images converted for an ImageDecoder
+// aren't listed.
+func (e *Epub) Manifest() (*Manifest, error) {
+	e.Lock()
+	c := e.clone()
+	e.Unlock()
+	c.dryRun = true
+	c.linkCheck = false
+	c.cache = nil
+	c.tracer = nil
+	c.progressHandler = nil
+	var m *Manifest
+	_, err := c.write(context.Background(), func(string) (int64, error) {
+		m = c.manifest()
+		return 0, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// manifest returns the description of the package and the TOC built by write
+func (e *Epub) manifest() *Manifest {
+	m := &Manifest{}
+	for _, item := range e.pkg.xml.ManifestItems {
+		m.Items = append(m.Items, ManifestItem{
+			ID:         item.ID,
+			Href:       filepath.ToSlash(item.Href),
+			MediaType:  item.MediaType,
+			Properties: item.Properties,
+			Fallback:   item.Fallback,
+		})
+	}
+	for _, ref := range e.pkg.xml.Spine.Items {
+		m.Spine = append(m.Spine, SpineItem{ID: ref.Idref, Linear: ref.Linear != "no"})
+	}
+	var convert func(items []*tocNavItem) []*TOCEntry
+	convert = func(items []*tocNavItem) []*TOCEntry {
+		var entries []*TOCEntry
+		for _, item := range items {
+			entry := &TOCEntry{
+				Title:    item.A.Data,
+				children: convert(item.Children),
+			}
+			entry.Filename, entry.Fragment = splitHref(item.A.Href)
+			entries = append(entries, entry)
+		}
+		return entries
+	}
+	m.TOC = convert(e.toc.navXML.Links)
+	return m
+}
+
+// guessMediaType returns the media type of the resource mediaFilename of the
+// folder mediaFolderName from its extension, for the dry run of Manifest
+func (e *Epub) guessMediaType(mediaFolderName string, mediaFilename string) string {
+	detected, _, _ := strings.Cut(mime.TypeByExtension(filepath.Ext(mediaFilename)), ";")
+	if detected == "" {
+		detected = "application/octet-stream"
+	}
+	return e.resourceMediaType(mediaFolderName, mediaFilename, detected)
+}
@@ -0,0 +1,120 @@
+package epub
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"sync/atomic"
+	"testing"
+)
+
+func TestEpubManifest(t *testing.T) {
+	image, err := os.ReadFile(testImageFromFileSource)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var gets int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			atomic.AddInt32(&gets, 1)
+		}
+		w.Write(image)
+	}))
+	defer ts.Close()
+
+	e, err := NewEpub(testEpubTitle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.AddCSS(testCoverCSSSource, "style.css"); err != nil {
+		t.Fatal(err)
+	}
+	imagePath, err := e.AddImage(ts.URL+"/image.png", "image.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.SetCover(imagePath, ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.AddSection(`<h1 id="one">One</h1><h2 id="part">Part</h2>`, "One", "one.xhtml", ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.AddSubSection("one.xhtml", "<p>Two</p>", "Two", "two.xhtml", ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.AddSection("<p>Note</p>", "Note", "note.xhtml", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.SetSectionLinear("note.xhtml", false); err != nil {
+		t.Fatal(err)
+	}
+	atomic.StoreInt32(&gets, 0)
+
+	m, err := e.Manifest()
+	if err != nil {
+		t.Fatalf("Error building manifest: %s", err)
+	}
+	if gets != 0 {
+		t.Errorf("Resources fetched %d times by Manifest", gets)
+	}
+
+	items := make(map[string]ManifestItem)
+	for _, item := range m.Items {
+		items[item.Href] = item
+	}
+	expected := []ManifestItem{
+		{Href: "css/style.css", MediaType: mediaTypeCSS},
+		{Href: "images/image.png", MediaType: "image/png", Properties: coverImageProperties},
+		{ID: "one.xhtml", Href: "xhtml/one.xhtml", MediaType: mediaTypeXhtml},
+		{ID: tocNavItemID, Href: tocNavFilename, MediaType: mediaTypeXhtml, Properties: tocNavItemProperties},
+		{ID: tocNcxItemID, Href: tocNcxFilename, MediaType: mediaTypeNcx},
+	}
+	for _, want := range expected {
+		got := items[want.Href]
+		if got.ID == "" {
+			t.Errorf("Manifest item %s missing", want.Href)
+			continue
+		}
+		if want.ID == "" {
+			want.ID = got.ID
+		}
+		if got != want {
+			t.Errorf("Manifest item %s = %+v, expected %+v", want.Href, got, want)
+		}
+	}
+
+	var spine []SpineItem
+	for _, item := range m.Spine {
+		if item.ID != e.cover.xhtmlFilename {
+			spine = append(spine, item)
+		}
+	}
+	expectedSpine := []SpineItem{{"one.xhtml", true}, {"two.xhtml", true}, {"note.xhtml", false}}
+	if !reflect.DeepEqual(spine, expectedSpine) {
+		t.Errorf("Spine = %+v, expected %+v", spine, expectedSpine)
+	}
+	if len(m.Spine) == 0 || m.Spine[0].ID != e.cover.xhtmlFilename {
+		t.Errorf("Cover page not first in the spine: %+v", m.Spine)
+	}
+
+	if len(m.TOC) != 2 || m.TOC[1].Title != "Note" || m.TOC[0].Title != "One" || m.TOC[0].Filename != "one.xhtml" {
+		for _, entry := range m.TOC {
+			t.Logf("%+v", *entry)
+		}
+		t.Fatalf("Unexpected TOC: %+v", m.TOC)
+	}
+	children := m.TOC[0].Children()
+	if len(children) != 1 || children[0].Title != "Two" || children[0].Filename != "two.xhtml" {
+		t.Errorf("Unexpected TOC children: %+v", children)
+	}
+
+	// The EPUB itself is left untouched
+	if _, err := e.WriteTo(io.Discard); err != nil {
+		t.Errorf("Error writing EPUB after Manifest: %s", err)
+	}
+	if gets == 0 {
+		t.Error("Image not fetched by Write")
+	}
+}
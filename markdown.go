@@ -0,0 +1,139 @@
+package epub
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+	gmhtml "github.com/yuin/goldmark/renderer/html"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Converts CommonMark, with the GitHub extensions (tables, strikethrough,
+// autolinks and task lists), to HTML. Raw HTML is kept.
+var markdown = goldmark.New(
+	goldmark.WithExtensions(extension.GFM),
+	goldmark.WithRendererOptions(gmhtml.WithXHTML(), gmhtml.WithUnsafe()),
+)
+
+// MarkdownOptions sets how AddSectionFromMarkdown converts Markdown.
+type MarkdownOptions struct {
+	// EmbedImages adds the images referenced by the Markdown to the EPUB, as
+	// AddImage does, and points the <img> elements to them. Images that can't
+	// be retrieved are left unchanged and reported as warnings, see Warnings.
+	EmbedImages bool
+	// BaseDir is the directory the relative paths of the images are resolved
+	// against, the current directory if empty.
+	BaseDir string
+}
+
+// AddSectionFromMarkdown adds a section like AddSection, converting its body
+// from CommonMark. Headings, code blocks, images and raw HTML are supported,
+// as are the GitHub extensions such as tables. The result is serialized as
+// well-formed XHTML.
+//
+// opts is optional; by default the images are left unchanged.
+func (e *Epub) AddSectionFromMarkdown(md string, sectionTitle string, internalFilename string, internalCSSPath string, opts *MarkdownOptions) (string, error) {
+	return e.AddSectionFromMarkdownContext(context.Background(), md, sectionTitle, internalFilename, internalCSSPath, opts)
+}
+
+// AddSectionFromMarkdownContext is like AddSectionFromMarkdown, stopping the
+// retrieval of the embedded images when ctx is done.
+func (e *Epub) AddSectionFromMarkdownContext(ctx context.Context, md string, sectionTitle string, internalFilename string, internalCSSPath string, opts *MarkdownOptions) (string, error) {
+	e.Lock()
+	defer e.Unlock()
+	body, images, err := e.markdownBody(ctx, []byte(md), opts)
+	if err != nil {
+		return "", err
+	}
+	filename, err := e.addSection("", body, sectionTitle, internalFilename, internalCSSPath)
+	if err != nil {
+		e.forgetImages(images)
+	}
+	return filename, err
+}
+
+// markdownBody converts Markdown to the body of a section, and returns it with
+// the internal filenames of the images it added to the EPUB
+func (e *Epub) markdownBody(ctx context.Context, md []byte, opts *MarkdownOptions) (string, []string, error) {
+	var b bytes.Buffer
+	if err := markdown.Convert(md, &b); err != nil {
+		return "", nil, fmt.Errorf("can't convert Markdown: %w", err)
+	}
+	root, err := parseBody(b.String())
+	if err != nil {
+		return "", nil, err
+	}
+	var images []string
+	if opts != nil && opts.EmbedImages {
+		images = e.embedMarkdownImages(ctx, root, opts.BaseDir)
+	}
+	body, err := renderBody(root)
+	if err != nil {
+		e.forgetImages(images)
+		return "", nil, err
+	}
+	return body, images, nil
+}
+
+// embedMarkdownImages adds the images of body to the EPUB, and returns the
+// internal filenames of the ones added, the ones already in the EPUB left out
+func (e *Epub) embedMarkdownImages(ctx context.Context, body *html.Node, baseDir string) []string {
+	// Images already in the EPUB, by source
+	added := make(map[string]string)
+	for filename, source := range e.images {
+		added[source] = "../" + ImageFolderName + "/" + filename
+	}
+
+	var images []string
+	walk(body, func(n *html.Node) bool {
+		if n.DataAtom != atom.Img {
+			return true
+		}
+		src := getAttr(n, "src")
+		if src == "" || strings.HasPrefix(src, "../"+ImageFolderName+"/") {
+			return true
+		}
+		source := src
+		if detectMediaType(src) == "File" && !isFileURL(src) {
+			// Markdown destinations are URLs, e.g. with spaces escaped
+			if unescaped, err := url.PathUnescape(src); err == nil {
+				source = unescaped
+			}
+			if !filepath.IsAbs(source) && !isWindowsPath(source) {
+				source = filepath.Join(baseDir, filepath.FromSlash(source))
+			}
+		}
+
+		internalPath, ok := added[source]
+		if !ok {
+			var err error
+			// An image with the same content may be reused, see
+			// SetResourceDeduplication
+			count := len(e.images)
+			internalPath, err = e.addResource(ctx, source, "", imageFileFormat, ImageFolderName, e.images)
+			if err != nil {
+				e.addWarning(WarningMissingImage, source, "can't add image to the epub: %s", err)
+				return true
+			}
+			added[source] = internalPath
+			if len(e.images) > count {
+				images = append(images, path.Base(internalPath))
+			}
+		}
+		for i, a := range n.Attr {
+			if a.Namespace == "" && a.Key == "src" {
+				n.Attr[i].Val = internalPath
+			}
+		}
+		return true
+	})
+	return images
+}
@@ -0,0 +1,70 @@
+package epub
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAddSectionFromMarkdown(t *testing.T) {
+	e, err := NewEpub(testEpubTitle)
+	if err != nil {
+		t.Error(err)
+	}
+
+	md := "# Title\n\n" +
+		"Line one  \nline <span class=\"x\">two</span>\n\n" +
+		"```go\nif a < b {\n}\n```\n\n" +
+		"| A | B |\n| - | - |\n| 1 | 2 |\n\n" +
+		"![Gopher](gophercolor16x16.png) ![Again](gophercolor16x16.png) ![Missing](doesNotExist.png)\n"
+	filename, err := e.AddSectionFromMarkdown(md, testSectionTitle, "", "", &MarkdownOptions{EmbedImages: true, BaseDir: "testdata"})
+	if err != nil {
+		t.Fatalf("Error adding section: %s", err)
+	}
+	body := findSection(e.sections, filename).xhtml.body()
+	for _, expected := range []string{
+		`<h1>Title</h1>`,
+		`<p>Line one<br/>` + "\n" + `line <span class="x">two</span></p>`,
+		`<pre><code class="language-go">if a &lt; b {` + "\n}\n</code></pre>",
+		`<table>`,
+		`<td>1</td>`,
+		`<img src="../images/gophercolor16x16.png" alt="Gopher"/> <img src="../images/gophercolor16x16.png" alt="Again"/> <img src="doesNotExist.png" alt="Missing"/>`,
+	} {
+		if !strings.Contains(body, expected) {
+			t.Errorf("Body doesn't contain %q:\n%s", expected, body)
+		}
+	}
+	if len(e.images) != 1 {
+		t.Errorf("Got %d images, expected 1: %v", len(e.images), e.images)
+	}
+	warnings := e.Warnings()
+	if len(warnings) != 1 || warnings[0].Kind != WarningMissingImage {
+		t.Errorf("Expected a missing image warning, got %v", warnings)
+	}
+
+	// Images are left unchanged by default
+	filename, err = e.AddSectionFromMarkdown("![Gopher](gophercolor16x16.png)", "", "", "", nil)
+	if err != nil {
+		t.Fatalf("Error adding section: %s", err)
+	}
+	if body := findSection(e.sections, filename).xhtml.body(); !strings.Contains(body, `src="gophercolor16x16.png"`) {
+		t.Errorf("Image changed without EmbedImages:\n%s", body)
+	}
+
+	// The images are removed if the section can't be added
+	_, err = e.AddSectionFromMarkdown("![Gopher](gophercolor16x16withoutextention)", "", filename, "", &MarkdownOptions{EmbedImages: true, BaseDir: "testdata"})
+	if _, ok := err.(*FilenameAlreadyUsedError); !ok {
+		t.Errorf("Expected error FilenameAlreadyUsedError not returned. Returned instead: %+v", err)
+	}
+	if len(e.images) != 1 {
+		t.Errorf("Got %d images after a failed section, expected 1: %v", len(e.images), e.images)
+	}
+	// An image already in the EPUB and reused is kept
+	e.SetResourceDeduplication(true)
+	_, err = e.AddSectionFromMarkdown("![Gopher](gophercolor16x16withoutextention)", "", filename, "", &MarkdownOptions{EmbedImages: true, BaseDir: "testdata"})
+	if _, ok := err.(*FilenameAlreadyUsedError); !ok {
+		t.Errorf("Expected error FilenameAlreadyUsedError not returned. Returned instead: %+v", err)
+	}
+	if len(e.images) != 1 {
+		t.Errorf("Reused image removed after a failed section: %v", e.images)
+	}
+}
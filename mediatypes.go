@@ -0,0 +1,160 @@
+package epub
+
+import (
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Media types of the extensions whose content isn't recognized, used when
+// the detected media type is a generic one
+var defaultMediaTypes = map[string]string{
+	".css":   mediaTypeCSS,
+	".otf":   "font/otf",
+	".ttf":   "font/ttf",
+	".woff":  "font/woff",
+	".m4a":   "audio/mp4",
+	".m4b":   "audio/mp4",
+	".opus":  "audio/ogg; codecs=opus",
+	".jxl":   "image/jxl",
+	".avif":  "image/avif",
+	".woff2": "font/woff2",
+	".js":    "application/javascript",
+	".smil":  "application/smil+xml",
+	".pls":   "application/pls+xml",
+}
+
+// Media types registered with RegisterMediaType
+var (
+	mediaTypesMu sync.RWMutex
+	mediaTypes   = make(map[string]string)
+)
+
+// RegisterMediaType sets the media type written to the manifest for the
+// resources whose internal filename has the extension ext, e.g.
+//
+//	epub.RegisterMediaType(".m4a", "audio/mp4")
+//
+// in place of the one detected from their content, for the formats that
+// aren't recognized or are recognized with a media type that reading systems
+// don't expect. The extension is case-insensitive and the leading dot is
+// optional. An empty media type removes the registration. It applies to all
+// the EPUBs written afterwards.
+func RegisterMediaType(ext string, mediaType string) {
+	ext = normalizeExt(ext)
+	mediaTypesMu.Lock()
+	defer mediaTypesMu.Unlock()
+	if mediaType == "" {
+		delete(mediaTypes, ext)
+		return
+	}
+	mediaTypes[ext] = mediaType
+}
+
+// RegisterMediaType is like the package-level RegisterMediaType, for this EPUB
+// only. The media types registered on the EPUB take precedence over the
+// package-level ones.
+func (e *Epub) RegisterMediaType(ext string, mediaType string) {
+	ext = normalizeExt(ext)
+	e.Lock()
+	defer e.Unlock()
+	if mediaType == "" {
+		delete(e.extMediaTypes, ext)
+		return
+	}
+	if e.extMediaTypes == nil {
+		e.extMediaTypes = make(map[string]string)
+	}
+	e.extMediaTypes[ext] = mediaType
+}
+
+// SetResourceMediaType sets the media type written to the manifest for an
+// already-added resource, given the path returned by the Add* method, in place
+// of the one detected from its content or registered for its extension, e.g.
+// for fonts whose format the detection confuses. An empty media type restores
+// the detection. If no resource with the path exists,
+// ResourceDoesNotExistError will be returned.
+func (e *Epub) SetResourceMediaType(internalPath string, mediaType string) error {
+	e.Lock()
+	defer e.Unlock()
+	mediaFolderName, filename := path.Split(strings.TrimPrefix(internalPath, "../"))
+	mediaFolderName = strings.TrimSuffix(mediaFolderName, "/")
+	mediaMap := e.mediaMap(mediaFolderName)
+	if _, ok := mediaMap[filename]; !ok {
+		return &ResourceDoesNotExistError{Filename: internalPath}
+	}
+	name := path.Join(mediaFolderName, filename)
+	if mediaType == "" {
+		delete(e.resourceMediaTypes, name)
+		return nil
+	}
+	if e.resourceMediaTypes == nil {
+		e.resourceMediaTypes = make(map[string]string)
+	}
+	e.resourceMediaTypes[name] = mediaType
+	return nil
+}
+
+// mediaMap returns the resources of the folder mediaFolderName, or nil if it
+// isn't a resource folder
+func (e *Epub) mediaMap(mediaFolderName string) map[string]string {
+	switch mediaFolderName {
+	case CSSFolderName:
+		return e.css
+	case FontFolderName:
+		return e.fonts
+	case ImageFolderName:
+		return e.images
+	case VideoFolderName:
+		return e.videos
+	case AudioFolderName:
+		return e.audios
+	}
+	return nil
+}
+
+// resourceMediaType returns the media type of the resource mediaFilename of
+// the folder mediaFolderName to write to the manifest, given the one detected
+// from its content
+func (e *Epub) resourceMediaType(mediaFolderName string, mediaFilename string, detected string) string {
+	if mediaType, ok := e.resourceMediaTypes[path.Join(mediaFolderName, mediaFilename)]; ok {
+		return mediaType
+	}
+	if mediaType, ok := e.extMediaTypes[normalizeExt(filepath.Ext(mediaFilename))]; ok {
+		return mediaType
+	}
+	return manifestMediaType(mediaFilename, detected)
+}
+
+// manifestMediaType returns the media type of the resource mediaFilename to
+// write to the manifest, given the one detected from its content
+func manifestMediaType(mediaFilename string, detected string) string {
+	ext := normalizeExt(filepath.Ext(mediaFilename))
+	mediaTypesMu.RLock()
+	mediaType, ok := mediaTypes[ext]
+	mediaTypesMu.RUnlock()
+	if ok {
+		return mediaType
+	}
+	if mediaType, ok := defaultMediaTypes[ext]; ok && isGenericMediaType(detected) {
+		return mediaType
+	}
+	return detected
+}
+
+// isGenericMediaType returns whether mediaType is what mimetype detects for
+// content it doesn't recognize
+func isGenericMediaType(mediaType string) bool {
+	mediaType, _, _ = strings.Cut(mediaType, ";")
+	return mediaType == "application/octet-stream" || mediaType == "text/plain"
+}
+
+// normalizeExt returns ext lowercase, with a leading dot
+func normalizeExt(ext string) string {
+	ext = strings.ToLower(ext)
+	if !strings.HasPrefix(ext, ".") {
+		ext = "." + ext
+	}
+	return ext
+}
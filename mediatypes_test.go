@@ -0,0 +1,96 @@
+package epub
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-shiori/go-epub/internal/storage"
+)
+
+func TestManifestMediaType(t *testing.T) {
+	RegisterMediaType("WAV", "audio/x-custom")
+	defer RegisterMediaType(".wav", "")
+
+	tests := []struct {
+		filename string
+		detected string
+		expected string
+	}{
+		{"audio.m4a", "application/octet-stream", "audio/mp4"},
+		{"audio.M4A", "application/octet-stream", "audio/mp4"},
+		{"image.png", "image/png", "image/png"},
+		{"image.jxl", "image/png", "image/png"},
+		{"noext", "application/octet-stream", "application/octet-stream"},
+		{"audio.wav", "audio/wav", "audio/x-custom"},
+	}
+	for _, test := range tests {
+		if got := manifestMediaType(test.filename, test.detected); got != test.expected {
+			t.Errorf("Media type of %s detected as %s: got %s, expected %s", test.filename, test.detected, got, test.expected)
+		}
+	}
+}
+
+func TestRegisterMediaType(t *testing.T) {
+	RegisterMediaType(".wav", "audio/x-custom")
+	defer RegisterMediaType(".wav", "")
+
+	e, err := NewEpub(testEpubTitle)
+	if err != nil {
+		t.Error(err)
+	}
+	_, err = e.AddAudio(testAudioFromFileSource, testAudioFromFileFilename)
+	if err != nil {
+		t.Errorf("Error adding audio: %s", err)
+	}
+
+	tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+	defer cleanup(testEpubFilename, tempDir)
+
+	pkg, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, pkgFilename))
+	if err != nil {
+		t.Errorf("Unexpected error reading package file: %s", err)
+	}
+	if !strings.Contains(string(pkg), `href="`+AudioFolderName+`/`+testAudioFromFileFilename+`" media-type="audio/x-custom"`) {
+		t.Errorf("Package file doesn't use the registered media type:\n%s", pkg)
+	}
+}
+
+func TestResourceMediaTypeOverrides(t *testing.T) {
+	e, err := NewEpub(testEpubTitle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.RegisterMediaType("wav", "audio/x-epub")
+	audioPath, err := e.AddAudio(testAudioFromFileSource, testAudioFromFileFilename)
+	if err != nil {
+		t.Fatalf("Error adding audio: %s", err)
+	}
+	fontPath, err := e.AddFont(testFontFromFileSource, "font.otf")
+	if err != nil {
+		t.Fatalf("Error adding font: %s", err)
+	}
+	if err := e.SetResourceMediaType(fontPath, "font/otf"); err != nil {
+		t.Errorf("Error setting media type: %s", err)
+	}
+	err = e.SetResourceMediaType("../"+FontFolderName+"/missing.otf", "font/otf")
+	if _, ok := err.(*ResourceDoesNotExistError); !ok {
+		t.Errorf("Expected error ResourceDoesNotExistError not returned. Returned instead: %+v", err)
+	}
+
+	tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+	defer cleanup(testEpubFilename, tempDir)
+
+	pkg, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, pkgFilename))
+	if err != nil {
+		t.Errorf("Unexpected error reading package file: %s", err)
+	}
+	for _, expected := range []string{
+		`href="` + strings.TrimPrefix(audioPath, "../") + `" media-type="audio/x-epub"`,
+		`href="` + strings.TrimPrefix(fontPath, "../") + `" media-type="font/otf"`,
+	} {
+		if !strings.Contains(string(pkg), expected) {
+			t.Errorf("Package file doesn't contain %s:\n%s", expected, pkg)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package epub
+
+import (
+	"net/http"
+)
+
+// Middleware wraps the transport of the outbound HTTP requests of an Epub,
+// e.g. to sign them, log them or serve them from a cache, without replacing
+// the whole client. It returns a RoundTripper calling next to send the
+// request on, or answering it itself:
+//
+//	e.AddMiddleware(func(next http.RoundTripper) http.RoundTripper {
+//		return epub.RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+//			req = req.Clone(req.Context())
+//			req.Header.Set("Authorization", "Bearer "+token)
+//			return next.RoundTrip(req)
+//		})
+//	})
+//
+// As required by http.RoundTripper, the request must be cloned before being
+// changed.
+type Middleware func(next http.RoundTripper) http.RoundTripper
+
+// RoundTripperFunc is a function used as an http.RoundTripper.
+type RoundTripperFunc func(req *http.Request) (*http.Response, error)
+
+// RoundTrip calls f(req).
+func (f RoundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// AddMiddleware adds middlewares to the chain the outbound HTTP requests go
+// through, those of remote resources and web pages. The first middleware
+// added sees the request first and the response last; the transport of Client
+// sends the request after the last one.
+func (e *Epub) AddMiddleware(middlewares ...Middleware) {
+	e.Lock()
+	defer e.Unlock()
+	e.middlewares = append(e.middlewares, middlewares...)
+}
+
+// SetUserAgent sets the User-Agent header of the outbound HTTP requests, in
+// place of the Go default, for the servers rejecting unknown clients. The
+// header is set before the middlewares see the request. An empty string
+// restores the default.
+func (e *Epub) SetUserAgent(userAgent string) {
+	e.Lock()
+	defer e.Unlock()
+	e.userAgent = userAgent
+}
+
+// httpClient returns Client with its transport wrapped in the middlewares
+func (e *Epub) httpClient() *http.Client {
+	client := e.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	if len(e.middlewares) == 0 && e.userAgent == "" {
+		return client
+	}
+	transport := client.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	for i := len(e.middlewares) - 1; i >= 0; i-- {
+		transport = e.middlewares[i](transport)
+	}
+	if e.userAgent != "" {
+		userAgent, next := e.userAgent, transport
+		transport = RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			req = req.Clone(req.Context())
+			req.Header.Set("User-Agent", userAgent)
+			return next.RoundTrip(req)
+		})
+	}
+	c := *client
+	c.Transport = transport
+	return &c
+}
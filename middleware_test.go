@@ -0,0 +1,84 @@
+package epub
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestAddMiddleware(t *testing.T) {
+	image, err := os.ReadFile(testImageFromFileSource)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mu sync.Mutex
+	var userAgents, authorizations []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		userAgents = append(userAgents, r.UserAgent())
+		authorizations = append(authorizations, r.Header.Get("Authorization"))
+		mu.Unlock()
+		w.Write(image)
+	}))
+	defer ts.Close()
+
+	e, err := NewEpub(testEpubTitle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.SetUserAgent("go-epub-test")
+	var order []string
+	var seenUserAgent string
+	e.AddMiddleware(func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			mu.Lock()
+			order = append(order, "first")
+			seenUserAgent = req.UserAgent()
+			mu.Unlock()
+			req = req.Clone(req.Context())
+			req.Header.Set("Authorization", "secret")
+			return next.RoundTrip(req)
+		})
+	}, func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			mu.Lock()
+			order = append(order, "second")
+			mu.Unlock()
+			resp, err := next.RoundTrip(req)
+			if err == nil && resp.StatusCode != http.StatusOK {
+				t.Errorf("Unexpected status %d", resp.StatusCode)
+			}
+			return resp, err
+		})
+	})
+
+	if _, err := e.AddImage(ts.URL+"/image.png", testImageFromFileFilename); err != nil {
+		t.Fatalf("Error adding image: %s", err)
+	}
+	if strings.Join(order, ",") != "first,second" {
+		t.Errorf("Middlewares called in the wrong order: %v", order)
+	}
+	if seenUserAgent != "go-epub-test" {
+		t.Errorf("Middleware saw the User-Agent %q", seenUserAgent)
+	}
+
+	tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+	defer cleanup(testEpubFilename, tempDir)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(userAgents) < 2 {
+		t.Fatalf("Expected the image to be requested when added and when written, got %d requests", len(userAgents))
+	}
+	for i := range userAgents {
+		if userAgents[i] != "go-epub-test" || authorizations[i] != "secret" {
+			t.Errorf("Request %d sent with User-Agent %q and Authorization %q", i, userAgents[i], authorizations[i])
+		}
+	}
+	if e.Client.Transport != nil {
+		t.Error("Client was changed")
+	}
+}
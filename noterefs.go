@@ -0,0 +1,224 @@
+package epub
+
+import (
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Hints found in the class or id of links to notes, e.g. class="footnote-ref"
+// (Pandoc) or id="cite_ref-1" (Wikipedia)
+var noterefHints = []string{"footnote", "fnref", "noteref", "cite_ref", "cite_note"}
+
+// DetectFootnotes is a Transform recognizing the common footnote markup of
+// scraped content and rewriting it into EPUB noteref and footnote pairs, which
+// reading systems can show as popups:
+//
+//	<p>Text<sup id="fnref1"><a href="#fn1">1</a></sup></p>
+//	<ol><li id="fn1">Note <a href="#fnref1">↩</a></li></ol>
+//
+// becomes
+//
+//	<p>Text<sup id="fnref1"><a href="#fn1" epub:type="noteref" role="doc-noteref">1</a></sup></p>
+//	<aside id="fn1" epub:type="footnote" role="doc-footnote">1. Note <a href="#fnref1" role="doc-backlink">↩</a></aside>
+//
+// A link is taken as a note reference if it points to an element of the
+// section and is in or holds a <sup> element, or if its class or id mentions
+// a footnote, as in Pandoc, Markdown and Wikipedia ("cite_note") output. Notes
+// that are list items are moved after their list, numbered, and the list is
+// removed once empty; <div> and <p> notes become <aside> elements.
+func DetectFootnotes(body string) (string, error) {
+	return transformNodes(body, detectFootnotes)
+}
+
+// detectFootnotes applies DetectFootnotes to the parsed <body> element body
+func detectFootnotes(body *html.Node) error {
+	ids := make(map[string]*html.Node)
+	walk(body, func(n *html.Node) bool {
+		if id := getAttr(n, "id"); id != "" {
+			if _, ok := ids[id]; !ok {
+				ids[id] = n
+			}
+		}
+		return true
+	})
+
+	var refs []*html.Node
+	var notes []*html.Node
+	isNote := make(map[*html.Node]bool)
+	// Ids of the references, which the backlinks of the notes point to
+	refIDs := make(map[string]bool)
+	walk(body, func(n *html.Node) bool {
+		if n.DataAtom != atom.A || !isNoteref(n) {
+			return true
+		}
+		note := ids[strings.TrimPrefix(getAttr(n, "href"), "#")]
+		if note == nil || !isNoteElement(note) || isAncestor(note, n) || isAncestor(n, note) {
+			return true
+		}
+		refs = append(refs, n)
+		if !isNote[note] {
+			isNote[note] = true
+			notes = append(notes, note)
+		}
+		for r := n; r != nil && (r == n || r.DataAtom == atom.Sup); r = r.Parent {
+			if id := getAttr(r, "id"); id != "" {
+				refIDs[id] = true
+			}
+		}
+		return true
+	})
+
+	for _, ref := range refs {
+		setAttr(ref, "epub:type", "noteref")
+		setAttr(ref, "role", "doc-noteref")
+	}
+	// Asides replacing the notes of each list, in order
+	listNotes := make(map[*html.Node][]*html.Node)
+	var lists []*html.Node
+	for _, note := range notes {
+		walk(note, func(n *html.Node) bool {
+			if n.DataAtom == atom.A && refIDs[strings.TrimPrefix(getAttr(n, "href"), "#")] {
+				setAttr(n, "role", "doc-backlink")
+			}
+			return true
+		})
+
+		switch {
+		case note.DataAtom == atom.Li && note.Parent != nil && (note.Parent.DataAtom == atom.Ol || note.Parent.DataAtom == atom.Ul):
+			list := note.Parent
+			aside := &html.Node{Type: html.ElementNode, Data: "aside", DataAtom: atom.Aside}
+			for _, a := range note.Attr {
+				if a.Key != "value" {
+					aside.Attr = append(aside.Attr, a)
+				}
+			}
+			if list.DataAtom == atom.Ol {
+				prependText(note, strconv.Itoa(listItemNumber(note))+". ")
+			}
+			for c := note.FirstChild; c != nil; c = note.FirstChild {
+				note.RemoveChild(c)
+				aside.AppendChild(c)
+			}
+			if _, ok := listNotes[list]; !ok {
+				lists = append(lists, list)
+			}
+			listNotes[list] = append(listNotes[list], aside)
+			note = aside
+		case note.DataAtom == atom.Div || note.DataAtom == atom.P:
+			note.Data = "aside"
+			note.DataAtom = atom.Aside
+		}
+		setAttr(note, "epub:type", "footnote")
+		setAttr(note, "role", "doc-footnote")
+	}
+
+	for _, list := range lists {
+		next := list.NextSibling
+		for _, aside := range listNotes[list] {
+			list.Parent.InsertBefore(aside, next)
+		}
+		// Remove the items emptied of their notes
+		for c := list.FirstChild; c != nil; {
+			nextChild := c.NextSibling
+			if c.DataAtom == atom.Li && !hasElementOrText(c) {
+				list.RemoveChild(c)
+			}
+			c = nextChild
+		}
+		if !hasElementOrText(list) {
+			list.Parent.RemoveChild(list)
+		}
+	}
+	return nil
+}
+
+// isNoteref returns whether the link a looks like a reference to a note
+func isNoteref(a *html.Node) bool {
+	if !strings.HasPrefix(getAttr(a, "href"), "#") {
+		return false
+	}
+	if a.Parent != nil && a.Parent.DataAtom == atom.Sup {
+		return true
+	}
+	if findElement(a, func(n *html.Node) bool { return n.DataAtom == atom.Sup }) != nil {
+		return true
+	}
+	hints := strings.ToLower(getAttr(a, "class") + " " + getAttr(a, "id"))
+	for _, hint := range noterefHints {
+		if strings.Contains(hints, hint) {
+			// Backlinks from the notes also mention footnotes, but point to
+			// the references
+			return !strings.Contains(hints, "back")
+		}
+	}
+	return false
+}
+
+// isNoteElement returns whether n can hold a note, unlike the links and
+// headings, which the backlinks and the tables of contents point to
+func isNoteElement(n *html.Node) bool {
+	switch n.DataAtom {
+	case atom.A, atom.Sup, atom.Span, atom.Body, atom.Section, atom.Article,
+		atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+		return false
+	}
+	return true
+}
+
+// isAncestor returns whether a is an ancestor of n
+func isAncestor(a *html.Node, n *html.Node) bool {
+	for p := n.Parent; p != nil; p = p.Parent {
+		if p == a {
+			return true
+		}
+	}
+	return false
+}
+
+// listItemNumber returns the number of the list item li in its ordered list
+func listItemNumber(li *html.Node) int {
+	if value, err := strconv.Atoi(getAttr(li, "value")); err == nil {
+		return value
+	}
+	number := 1
+	if start, err := strconv.Atoi(getAttr(li.Parent, "start")); err == nil {
+		number = start
+	}
+	for c := li.Parent.FirstChild; c != nil && c != li; c = c.NextSibling {
+		if c.DataAtom == atom.Li {
+			number++
+			if value, err := strconv.Atoi(getAttr(c, "value")); err == nil {
+				number = value + 1
+			}
+		}
+	}
+	return number
+}
+
+// prependText adds text at the start of n, inside its first paragraph if it
+// starts with one
+func prependText(n *html.Node, text string) {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.TextNode && strings.TrimSpace(c.Data) == "" {
+			continue
+		}
+		if c.DataAtom == atom.P {
+			n = c
+		}
+		break
+	}
+	n.InsertBefore(&html.Node{Type: html.TextNode, Data: text}, n.FirstChild)
+}
+
+// hasElementOrText returns whether n holds an element or some text
+func hasElementOrText(n *html.Node) bool {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.ElementNode || (c.Type == html.TextNode && strings.TrimSpace(c.Data) != "") {
+			return true
+		}
+	}
+	return false
+}
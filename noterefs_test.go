@@ -0,0 +1,54 @@
+package epub
+
+import (
+	"testing"
+)
+
+func TestDetectFootnotes(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		expected string
+	}{
+		{
+			"Plain",
+			`<p>Text<sup id="fnref1"><a href="#fn1">1</a></sup></p><ol><li id="fn1">Note <a href="#fnref1">↩</a></li></ol>`,
+			`<p>Text<sup id="fnref1"><a href="#fn1" epub:type="noteref" role="doc-noteref">1</a></sup></p><aside id="fn1" epub:type="footnote" role="doc-footnote">1. Note <a href="#fnref1" role="doc-backlink">↩</a></aside>`,
+		},
+		{
+			"Pandoc",
+			`<p>Text<a href="#fn1" class="footnote-ref" id="fnref1"><sup>1</sup></a></p>` +
+				`<section class="footnotes"><hr/><ol><li id="fn1"><p>Note<a href="#fnref1" class="footnote-back">↩</a></p></li></ol></section>`,
+			`<p>Text<a href="#fn1" class="footnote-ref" id="fnref1" epub:type="noteref" role="doc-noteref"><sup>1</sup></a></p>` +
+				`<section class="footnotes"><hr/><aside id="fn1" epub:type="footnote" role="doc-footnote"><p>1. Note<a href="#fnref1" class="footnote-back" role="doc-backlink">↩</a></p></aside></section>`,
+		},
+		{
+			"Wikipedia",
+			`<p>Text<sup id="cite_ref-1" class="reference"><a href="#cite_note-1">[1]</a></sup></p>` +
+				`<ol class="references"><li>Kept</li><li id="cite_note-1"><span class="mw-cite-backlink"><a href="#cite_ref-1">^</a></span> Note</li></ol>`,
+			`<p>Text<sup id="cite_ref-1" class="reference"><a href="#cite_note-1" epub:type="noteref" role="doc-noteref">[1]</a></sup></p>` +
+				`<ol class="references"><li>Kept</li></ol><aside id="cite_note-1" epub:type="footnote" role="doc-footnote">2. <span class="mw-cite-backlink"><a href="#cite_ref-1" role="doc-backlink">^</a></span> Note</aside>`,
+		},
+		{
+			"Div",
+			`<p>Text<a href="#note" class="noteref">*</a></p><div id="note">Note</div>`,
+			`<p>Text<a href="#note" class="noteref" epub:type="noteref" role="doc-noteref">*</a></p><aside id="note" epub:type="footnote" role="doc-footnote">Note</aside>`,
+		},
+		{
+			"Not notes",
+			`<p><a href="#part">Part</a><sup><a href="#missing">1</a></sup></p><h2 id="part">Part</h2>`,
+			`<p><a href="#part">Part</a><sup><a href="#missing">1</a></sup></p><h2 id="part">Part</h2>`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			body, err := DetectFootnotes(test.body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if body != test.expected {
+				t.Errorf("Got\n%s\nexpected\n%s", body, test.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,229 @@
+package epub
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// NotePlacement is where the notes added with AddFootnote are placed.
+type NotePlacement int
+
+const (
+	// NotesAsFootnotes places each note in an aside at the end of the section
+	// referencing it, which reading systems can show as a pop-up.
+	NotesAsFootnotes NotePlacement = iota
+	// NotesAsEndnotes collects the notes of all the sections in a notes
+	// section at the end of the EPUB.
+	NotesAsEndnotes
+)
+
+const (
+	endnotesFilename = "endnotes.xhtml"
+	endnotesTitle    = "Notes"
+)
+
+// A note referenced from a section
+type note struct {
+	number int
+	body   string
+}
+
+func (n note) id() string {
+	return "note-" + strconv.Itoa(n.number)
+}
+
+func (n note) refID() string {
+	return "noteref-" + strconv.Itoa(n.number)
+}
+
+// AddFootnote adds a note to an already-added section. A note reference
+//
+//	<sup><a epub:type="noteref" role="doc-noteref" id="noteref-1" href="#note-1">1</a></sup>
+//
+// is appended to the element with the id refID, and the note, with a link
+// back to the reference, is placed according to SetNotePlacement when the
+// EPUB is written. The notes are numbered in the order they are added.
+//
+// The note body must be valid XHTML that will go between the opening and
+// closing <aside> or <li> tags. The body of the section is parsed with an
+// HTML5 parser and serialized back as well-formed XHTML. Notes are dropped if
+// UpdateSection later removes their reference.
+func (e *Epub) AddFootnote(sectionFilename string, refID string, noteBody string) error {
+	e.Lock()
+	defer e.Unlock()
+	s := findSection(e.sections, sectionFilename)
+	if s == nil {
+		return &SectionDoesNotExistError{Filename: sectionFilename}
+	}
+	if s.raw != "" {
+		return &RawSectionError{Filename: sectionFilename}
+	}
+	noteBody, err := readBody(strings.NewReader(noteBody))
+	if err != nil {
+		return fmt.Errorf("can't add note to %s: %w", sectionFilename, err)
+	}
+
+	root, err := parseBody(s.xhtml.body())
+	if err != nil {
+		return fmt.Errorf("can't add note to %s: %w", sectionFilename, err)
+	}
+	used := map[string]bool{}
+	var ref *html.Node
+	walk(root, func(n *html.Node) bool {
+		if id := getAttr(n, "id"); id != "" {
+			used[id] = true
+			if id == refID && ref == nil {
+				ref = n
+			}
+		}
+		return true
+	})
+	if ref == nil {
+		return fmt.Errorf("can't add note to %s: no element with id %q", sectionFilename, refID)
+	}
+
+	n := note{number: e.noteCount + 1, body: noteBody}
+	for used[n.id()] || used[n.refID()] {
+		n.number++
+	}
+	link := &html.Node{
+		Type:     html.ElementNode,
+		Data:     "a",
+		DataAtom: atom.A,
+		Attr: []html.Attribute{
+			{Key: "epub:type", Val: "noteref"},
+			{Key: "role", Val: "doc-noteref"},
+			{Key: "id", Val: n.refID()},
+			{Key: "href", Val: "#" + n.id()},
+		},
+	}
+	link.AppendChild(&html.Node{Type: html.TextNode, Data: strconv.Itoa(n.number)})
+	sup := &html.Node{Type: html.ElementNode, Data: "sup", DataAtom: atom.Sup}
+	sup.AppendChild(link)
+	ref.AppendChild(sup)
+
+	body, err := renderBody(root)
+	if err != nil {
+		return fmt.Errorf("can't add note to %s: %w", sectionFilename, err)
+	}
+	s.xhtml.setBody(body)
+	s.xhtml.setXmlnsEpub(xmlnsEpub)
+	s.notes = append(s.notes, n)
+	e.noteCount = n.number
+	return nil
+}
+
+// SetNotePlacement sets where the notes added with AddFootnote are placed,
+// NotesAsFootnotes by default.
+func (e *Epub) SetNotePlacement(placement NotePlacement) {
+	e.Lock()
+	defer e.Unlock()
+	e.notePlacement = placement
+}
+
+// sectionNotes returns the notes whose reference is still in the section, in
+// reading order
+func sectionNotes(section *epubSection) []note {
+	body := section.xhtml.body()
+	position := map[int]int{}
+	var notes []note
+	for _, n := range section.notes {
+		// The reference was removed by UpdateSection
+		if i := strings.Index(body, `id="`+n.refID()+`"`); i >= 0 {
+			position[n.number] = i
+			notes = append(notes, n)
+		}
+	}
+	sort.SliceStable(notes, func(i, j int) bool {
+		return position[notes[i].number] < position[notes[j].number]
+	})
+	return notes
+}
+
+// placeNotes places the notes of a section in x, its XHTML to write
+func (e *Epub) placeNotes(section *epubSection, x *xhtml) *xhtml {
+	notes := sectionNotes(section)
+	if len(notes) == 0 {
+		return x
+	}
+
+	body := x.body()
+	if e.notePlacement == NotesAsEndnotes {
+		filename := e.endnotesFilename()
+		for _, n := range notes {
+			body = strings.Replace(body, `href="#`+n.id()+`"`, `href="`+filename+`#`+n.id()+`"`, 1)
+		}
+		return x.withBody(body)
+	}
+
+	var b strings.Builder
+	b.WriteString(body)
+	for _, n := range notes {
+		fmt.Fprintf(&b, "\n"+`<aside epub:type="footnote" role="doc-footnote" id="%s"><a href="#%s" role="doc-backlink">%d.</a> %s</aside>`,
+			n.id(), n.refID(), n.number, n.body)
+	}
+	return x.withBody(b.String())
+}
+
+// endnotesFilename returns the internal filename of the notes section, which
+// doesn't clash with the sections
+func (e *Epub) endnotesFilename() string {
+	filename := endnotesFilename
+	for i := 2; findSection(e.sections, filename) != nil; i++ {
+		filename = fmt.Sprintf("endnotes-%d.xhtml", i)
+	}
+	return filename
+}
+
+// writeEndnotes writes the notes section if the notes are placed as endnotes,
+// and adds it to the package and the TOC
+func (e *Epub) writeEndnotes(rootEpubDir string, tocIndex int) error {
+	if e.notePlacement != NotesAsEndnotes {
+		return nil
+	}
+
+	var b strings.Builder
+	var appendNotes func(sections []*epubSection)
+	appendNotes = func(sections []*epubSection) {
+		for _, section := range sections {
+			for _, n := range sectionNotes(section) {
+				fmt.Fprintf(&b, `<li id="%s" value="%d" epub:type="endnote" role="doc-endnote">%s <a href="%s#%s" role="doc-backlink">↩</a></li>`,
+					n.id(), n.number, n.body, section.filename, n.refID())
+			}
+			appendNotes(section.children)
+		}
+	}
+	appendNotes(e.sections)
+	if b.Len() == 0 {
+		return nil
+	}
+
+	x, err := newXhtml(`<section epub:type="endnotes" role="doc-endnotes"><h1>` + endnotesTitle + `</h1><ol>` + b.String() + `</ol></section>`)
+	if err != nil {
+		return fmt.Errorf("can't create notes section: %w", err)
+	}
+	x.setTitle(endnotesTitle)
+	x.setXmlnsEpub(xmlnsEpub)
+	x.prependCSS(e.linkedCSS(b.String()))
+	if dir := e.direction(); dir != "" {
+		x.setDir(dir)
+	}
+	filename := e.endnotesFilename()
+	relativePath := filepath.Join(xhtmlFolderName, filename)
+	if err := x.write(filepath.Join(rootEpubDir, contentFolderName, relativePath)); err != nil {
+		return err
+	}
+
+	e.pkg.addToSpine(filename, true)
+	e.pkg.addToManifest(filename, relativePath, mediaTypeXhtml, "")
+	if e.customTOC == nil {
+		e.toc.addSubSection("-1", tocIndex, endnotesTitle, relativePath)
+	}
+	return nil
+}
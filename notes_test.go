@@ -0,0 +1,119 @@
+package epub
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-shiori/go-epub/internal/storage"
+)
+
+func TestAddFootnote(t *testing.T) {
+	e, err := NewEpub(testEpubTitle)
+	if err != nil {
+		t.Error(err)
+	}
+	_, err = e.AddSection(`<p id="first">First</p><p id="second">Second</p>`, "Chapter 1", "chapter1.xhtml", "")
+	if err != nil {
+		t.Errorf("Error adding section: %s", err)
+	}
+
+	if err := e.AddFootnote("chapter1.xhtml", "second", "<p>Second note</p>"); err != nil {
+		t.Errorf("Error adding note: %s", err)
+	}
+	if err := e.AddFootnote("chapter1.xhtml", "first", "First note"); err != nil {
+		t.Errorf("Error adding note: %s", err)
+	}
+	if err := e.AddFootnote("chapter1.xhtml", "doesNotExist", "Note"); err == nil {
+		t.Error("Expected an error adding a note to a non-existent element")
+	}
+	if err := e.AddFootnote("chapter1.xhtml", "first", "<p>Unclosed"); err == nil {
+		t.Error("Expected an error adding a malformed note")
+	}
+	err = e.AddFootnote("doesNotExist.xhtml", "first", "Note")
+	if _, ok := err.(*SectionDoesNotExistError); !ok {
+		t.Errorf("Expected error SectionDoesNotExistError not returned. Returned instead: %+v", err)
+	}
+
+	expectedBody := `<p id="first">First<sup><a epub:type="noteref" role="doc-noteref" id="noteref-2" href="#note-2">2</a></sup></p>` +
+		`<p id="second">Second<sup><a epub:type="noteref" role="doc-noteref" id="noteref-1" href="#note-1">1</a></sup></p>`
+	if body := findSection(e.sections, "chapter1.xhtml").xhtml.body(); strings.TrimSpace(body) != expectedBody {
+		t.Errorf("Note references not inserted\nGot: %s\nExpected: %s", body, expectedBody)
+	}
+
+	tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+	contents, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, xhtmlFolderName, "chapter1.xhtml"))
+	if err != nil {
+		t.Errorf("Unexpected error reading section file: %s", err)
+	}
+	expectedAsides := `<aside epub:type="footnote" role="doc-footnote" id="note-2"><a href="#noteref-2" role="doc-backlink">2.</a> First note</aside>` + "\n" +
+		`<aside epub:type="footnote" role="doc-footnote" id="note-1"><a href="#noteref-1" role="doc-backlink">1.</a> <p>Second note</p></aside>`
+	if !strings.Contains(string(contents), expectedAsides) {
+		t.Errorf("Section file doesn't contain the footnotes\nGot: %s\nExpected: %s", contents, expectedAsides)
+	}
+	if _, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, xhtmlFolderName, endnotesFilename)); err == nil {
+		t.Error("Notes section shouldn't be written for footnotes")
+	}
+	cleanup(testEpubFilename, tempDir)
+	// The footnotes are only added to the written file
+	if body := findSection(e.sections, "chapter1.xhtml").xhtml.body(); strings.Contains(body, "aside") {
+		t.Errorf("Footnotes added to the section body: %s", body)
+	}
+}
+
+func TestSetNotePlacement(t *testing.T) {
+	e, err := NewEpub(testEpubTitle)
+	if err != nil {
+		t.Error(err)
+	}
+	_, err = e.AddSection(`<p id="p1">Text</p>`, "Chapter 1", "chapter1.xhtml", "")
+	if err != nil {
+		t.Errorf("Error adding section: %s", err)
+	}
+	_, err = e.AddSubSection("chapter1.xhtml", `<p id="p2">Text</p>`, "Chapter 1.1", "chapter1-1.xhtml", "")
+	if err != nil {
+		t.Errorf("Error adding section: %s", err)
+	}
+	if err := e.AddFootnote("chapter1-1.xhtml", "p2", "Second note"); err != nil {
+		t.Errorf("Error adding note: %s", err)
+	}
+	if err := e.AddFootnote("chapter1.xhtml", "p1", "First note"); err != nil {
+		t.Errorf("Error adding note: %s", err)
+	}
+	e.SetNotePlacement(NotesAsEndnotes)
+
+	tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+	contents, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, xhtmlFolderName, "chapter1.xhtml"))
+	if err != nil {
+		t.Errorf("Unexpected error reading section file: %s", err)
+	}
+	if !strings.Contains(string(contents), `href="endnotes.xhtml#note-2"`) || strings.Contains(string(contents), "<aside") {
+		t.Errorf("Note reference doesn't point to the notes section:\n%s", contents)
+	}
+
+	contents, err = storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, xhtmlFolderName, endnotesFilename))
+	if err != nil {
+		t.Errorf("Unexpected error reading notes section: %s", err)
+	}
+	expectedNotes := `<ol><li id="note-2" value="2" epub:type="endnote" role="doc-endnote">First note <a href="chapter1.xhtml#noteref-2" role="doc-backlink">↩</a></li>` +
+		`<li id="note-1" value="1" epub:type="endnote" role="doc-endnote">Second note <a href="chapter1-1.xhtml#noteref-1" role="doc-backlink">↩</a></li></ol>`
+	if !strings.Contains(string(contents), expectedNotes) {
+		t.Errorf("Notes section doesn't contain the notes in reading order\nGot: %s\nExpected: %s", contents, expectedNotes)
+	}
+
+	pkg, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, pkgFilename))
+	if err != nil {
+		t.Errorf("Unexpected error reading package file: %s", err)
+	}
+	if !strings.Contains(string(pkg), `<itemref idref="`+endnotesFilename+`"></itemref>`) {
+		t.Errorf("Notes section not in the spine:\n%s", pkg)
+	}
+	nav, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, tocNavFilename))
+	if err != nil {
+		t.Errorf("Unexpected error reading nav file: %s", err)
+	}
+	if !strings.Contains(string(nav), `<a href="xhtml/`+endnotesFilename+`">`+endnotesTitle+`</a>`) {
+		t.Errorf("Notes section not in the TOC:\n%s", nav)
+	}
+	cleanup(testEpubFilename, tempDir)
+}
@@ -0,0 +1,170 @@
+package epub
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// FontObfuscation is an algorithm obfuscating the embedded fonts, see
+// SetFontObfuscation.
+type FontObfuscation int
+
+const (
+	// The fonts are embedded as they are, the default
+	FontObfuscationNone FontObfuscation = iota
+	// The algorithm of the EPUB specification, supported by all the EPUB 3
+	// reading systems
+	FontObfuscationIDPF
+	// The algorithm of Adobe, for older reading systems such as Adobe Digital
+	// Editions. It requires a UUID as the identifier of the EPUB.
+	FontObfuscationAdobe
+)
+
+const (
+	encryptionFilename     = "encryption.xml"
+	encryptionNamespace    = "urn:oasis:names:tc:opendocument:xmlns:container"
+	xmlencNamespace        = "http://www.w3.org/2001/04/xmlenc#"
+	idpfObfuscationMethod  = "http://www.idpf.org/2008/embedding"
+	adobeObfuscationMethod = "http://ns.adobe.com/pdf/enc#RC"
+	// Number of bytes obfuscated at the start of a font
+	idpfObfuscatedLength  = 1040
+	adobeObfuscatedLength = 1024
+)
+
+// encryptionRoot holds the actual XML for META-INF/encryption.xml
+// Spec: https://www.w3.org/TR/epub-33/#sec-font-obfuscation
+type encryptionRoot struct {
+	XMLName       xml.Name             `xml:"urn:oasis:names:tc:opendocument:xmlns:container encryption"`
+	XmlnsEnc      string               `xml:"xmlns:enc,attr"`
+	EncryptedData []encryptedDataEntry `xml:"enc:EncryptedData"`
+}
+
+// <enc:EncryptedData> elements, one per obfuscated font
+// Ex: <enc:EncryptedData>
+//
+//	  <enc:EncryptionMethod Algorithm="http://www.idpf.org/2008/embedding" />
+//	  <enc:CipherData>
+//	    <enc:CipherReference URI="EPUB/fonts/font.otf" />
+//	  </enc:CipherData>
+//	</enc:EncryptedData>
+type encryptedDataEntry struct {
+	Method struct {
+		Algorithm string `xml:"Algorithm,attr"`
+	} `xml:"enc:EncryptionMethod"`
+	Reference struct {
+		URI string `xml:"URI,attr"`
+	} `xml:"enc:CipherData>enc:CipherReference"`
+}
+
+// SetFontObfuscation sets the algorithm obfuscating the fonts added with
+// AddFont when the EPUB is written, as the licenses of many commercial fonts
+// require, and lists them in META-INF/encryption.xml so that reading systems
+// can restore them. The key is derived from the identifier of the EPUB, see
+// SetIdentifier. Fonts that can't be obfuscated are embedded as they are and
+// reported as warnings.
+func (e *Epub) SetFontObfuscation(method FontObfuscation) {
+	e.Lock()
+	defer e.Unlock()
+	e.fontObfuscation = method
+}
+
+// obfuscatesMedia returns whether the resources of the folder mediaFolderName
+// are obfuscated
+func (e *Epub) obfuscatesMedia(mediaFolderName string) bool {
+	return mediaFolderName == FontFolderName && e.fontObfuscation != FontObfuscationNone
+}
+
+// obfuscateFont obfuscates the font just written at mediaFilePath and records
+// it for encryption.xml
+func (e *Epub) obfuscateFont(mediaFilename string, mediaFilePath string) error {
+	key, length, err := obfuscationKey(e.fontObfuscation, e.identifier)
+	if err != nil {
+		return fmt.Errorf("can't obfuscate font %s: %w", mediaFilename, err)
+	}
+	r, err := e.spool.open(mediaFilePath)
+	if err != nil {
+		return fmt.Errorf("can't obfuscate font %s: %w", mediaFilename, err)
+	}
+	data, err := io.ReadAll(r)
+	r.Close()
+	if err != nil {
+		return fmt.Errorf("can't obfuscate font %s: %w", mediaFilename, err)
+	}
+	obfuscate(data, key, length)
+	e.spool.remove(mediaFilePath)
+	if err := filesystem.WriteFile(mediaFilePath, data, filePermissions); err != nil {
+		return fmt.Errorf("can't obfuscate font %s: %w", mediaFilename, err)
+	}
+	e.obfuscatedFonts = append(e.obfuscatedFonts, path.Join(contentFolderName, FontFolderName, mediaFilename))
+	return nil
+}
+
+// obfuscationKey returns the key of the obfuscation algorithm method for the
+// identifier of the EPUB, along with the number of bytes it obfuscates
+func obfuscationKey(method FontObfuscation, identifier string) ([]byte, int, error) {
+	switch method {
+	case FontObfuscationIDPF:
+		// SHA-1 of the identifier without whitespace
+		stripped := strings.Map(func(r rune) rune {
+			if r == ' ' || r == '\t' || r == '\r' || r == '\n' {
+				return -1
+			}
+			return r
+		}, identifier)
+		key := sha1.Sum([]byte(stripped))
+		return key[:], idpfObfuscatedLength, nil
+	case FontObfuscationAdobe:
+		// The bytes of the UUID
+		uuid := strings.ReplaceAll(strings.TrimPrefix(strings.TrimSpace(identifier), "urn:uuid:"), "-", "")
+		key, err := hex.DecodeString(uuid)
+		if err != nil || len(key) != 16 {
+			return nil, 0, fmt.Errorf("identifier %q isn't a UUID", identifier)
+		}
+		return key, adobeObfuscatedLength, nil
+	}
+	return nil, 0, errors.New("unknown obfuscation algorithm")
+}
+
+// obfuscate XORs the first length bytes of data with key, repeated. Applying
+// it twice restores the data.
+func obfuscate(data []byte, key []byte, length int) {
+	for i := 0; i < length && i < len(data); i++ {
+		data[i] ^= key[i%len(key)]
+	}
+}
+
+// writeEncryption writes META-INF/encryption.xml if fonts were obfuscated
+func (e *Epub) writeEncryption(rootEpubDir string) error {
+	if len(e.obfuscatedFonts) == 0 {
+		return nil
+	}
+	algorithm := idpfObfuscationMethod
+	if e.fontObfuscation == FontObfuscationAdobe {
+		algorithm = adobeObfuscationMethod
+	}
+	root := encryptionRoot{XmlnsEnc: xmlencNamespace}
+	for _, uri := range e.obfuscatedFonts {
+		var d encryptedDataEntry
+		d.Method.Algorithm = algorithm
+		d.Reference.URI = uri
+		root.EncryptedData = append(root.EncryptedData, d)
+	}
+	output, err := xml.MarshalIndent(root, "", "  ")
+	if err != nil {
+		return fmt.Errorf("Error marshalling XML for encryption file: %w", err)
+	}
+	content := append([]byte(xml.Header), output...)
+	content = append(content, "\n"...)
+
+	if err := filesystem.WriteFile(filepath.Join(rootEpubDir, metaInfFolderName, encryptionFilename), content, filePermissions); err != nil {
+		return fmt.Errorf("Error writing encryption file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package epub
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-shiori/go-epub/internal/storage"
+)
+
+func TestSetFontObfuscation(t *testing.T) {
+	font, err := os.ReadFile(testFontFromFileSource)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		method    FontObfuscation
+		key       func(identifier string) []byte
+		length    int
+		algorithm string
+	}{
+		{
+			FontObfuscationIDPF,
+			func(identifier string) []byte {
+				key := sha1.Sum([]byte(identifier))
+				return key[:]
+			},
+			1040,
+			"http://www.idpf.org/2008/embedding",
+		},
+		{
+			FontObfuscationAdobe,
+			func(identifier string) []byte {
+				return []byte{0x12, 0x34, 0x56, 0x78, 0x9a, 0xbc, 0xde, 0xf0, 0x12, 0x34, 0x56, 0x78, 0x9a, 0xbc, 0xde, 0xf0}
+			},
+			1024,
+			"http://ns.adobe.com/pdf/enc#RC",
+		},
+	}
+	for _, test := range tests {
+		e, err := NewEpub(testEpubTitle)
+		if err != nil {
+			t.Fatal(err)
+		}
+		e.SetIdentifier("urn:uuid:12345678-9abc-def0-1234-56789abcdef0")
+		fontPath, err := e.AddFont(testFontFromFileSource, "")
+		if err != nil {
+			t.Fatalf("Error adding font: %s", err)
+		}
+		e.SetFontObfuscation(test.method)
+
+		tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+
+		encryption, err := storage.ReadFile(filesystem, filepath.Join(tempDir, metaInfFolderName, encryptionFilename))
+		if err != nil {
+			t.Fatalf("Unexpected error reading encryption file: %s", err)
+		}
+		fontURI := contentFolderName + "/" + strings.TrimPrefix(fontPath, "../")
+		for _, expected := range []string{
+			`<enc:EncryptionMethod Algorithm="` + test.algorithm + `"></enc:EncryptionMethod>`,
+			`<enc:CipherReference URI="` + fontURI + `"></enc:CipherReference>`,
+		} {
+			if !strings.Contains(string(encryption), expected) {
+				t.Errorf("Encryption file doesn't contain %s:\n%s", expected, encryption)
+			}
+		}
+
+		obfuscated, err := storage.ReadFile(filesystem, filepath.Join(tempDir, filepath.FromSlash(fontURI)))
+		if err != nil {
+			t.Fatalf("Unexpected error reading font file: %s", err)
+		}
+		if bytes.Equal(obfuscated[:test.length], font[:test.length]) {
+			t.Error("Font not obfuscated")
+		}
+		if !bytes.Equal(obfuscated[test.length:], font[test.length:]) {
+			t.Errorf("Font obfuscated beyond the first %d bytes", test.length)
+		}
+		obfuscate(obfuscated, test.key(e.Identifier()), test.length)
+		if !bytes.Equal(obfuscated, font) {
+			t.Error("Font not restored by the key")
+		}
+		cleanup(testEpubFilename, tempDir)
+	}
+
+	// The Adobe algorithm requires a UUID
+	e, err := NewEpub(testEpubTitle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.SetIdentifier("isbn:9780000000000")
+	if _, err := e.AddFont(testFontFromFileSource, ""); err != nil {
+		t.Fatalf("Error adding font: %s", err)
+	}
+	e.SetFontObfuscation(FontObfuscationAdobe)
+	tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+	defer cleanup(testEpubFilename, tempDir)
+	if _, err := storage.ReadFile(filesystem, filepath.Join(tempDir, metaInfFolderName, encryptionFilename)); err == nil {
+		t.Error("Encryption file written for a font that can't be obfuscated")
+	}
+	if len(e.BuildReport().Warnings) != 1 {
+		t.Errorf("Expected a warning for the font that can't be obfuscated, got %v", e.BuildReport().Warnings)
+	}
+}
@@ -0,0 +1,461 @@
+package epub
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// References to other files in stylesheets
+var cssReferenceRegex = regexp.MustCompile(`url\(\s*(['"]?)([^'")]+)(['"]?)\s*\)|@import\s+(['"])([^'"]+)(['"])`)
+
+// The parts of META-INF/encryption.xml needed to restore obfuscated fonts
+type readEncryption struct {
+	EncryptedData []struct {
+		Method struct {
+			Algorithm string `xml:"Algorithm,attr"`
+		} `xml:"EncryptionMethod"`
+		Reference struct {
+			URI string `xml:"URI,attr"`
+		} `xml:"CipherData>CipherReference"`
+	} `xml:"EncryptedData"`
+}
+
+// openedFile is where a file of an EPUB read by Open is stored in the Epub
+type openedFile struct {
+	folder   string
+	filename string
+}
+
+// Open reads the EPUB file at epubPath so that it can be modified, e.g. to add
+// a section, fix the metadata or replace the cover, and written again.
+//
+// The title, author, language, description, identifier and page progression
+// direction are read from the package document. The XHTML documents become
+// sections, in the order of the spine, and the stylesheets, fonts, images,
+// videos and audio files become resources; the files are renamed after their
+// base name, in the folders of this package, and the links between them are
+// updated. Only the body, the title, the stylesheets and the language of the
+// documents are kept. Fonts obfuscated as described by the EPUB specification
+// are obfuscated again when written.
+//
+// The table of contents of the EPUB is kept as if imported with ImportTOC:
+// entries for sections added afterwards must be added with TOC. The cover
+// image, if any, is set as with SetCover, its page replacing the original one
+// when the first document of the spine only shows the cover image; the
+// stylesheet linked from the original page is kept.
+//
+// The other files, such as scripts and media overlays, are left out and
+// reported by Warnings. An error is returned if the EPUB can't be read or is
+// encrypted.
+func Open(epubPath string) (*Epub, error) {
+	f, err := os.Open(epubPath)
+	if err != nil {
+		return nil, fmt.Errorf("can't open EPUB: %w", err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("can't open EPUB: %w", err)
+	}
+	er, err := newEpubReader(f, info.Size())
+	if err != nil {
+		return nil, err
+	}
+	return er.epub()
+}
+
+// epub returns the EPUB read by er as an Epub
+func (er *epubReader) epub() (*Epub, error) {
+	meta := er.pkg.Metadata
+	title := ""
+	if len(meta.Titles) > 0 {
+		title = strings.TrimSpace(meta.Titles[0])
+	}
+	e, err := NewEpub(title)
+	if err != nil {
+		return nil, err
+	}
+	if len(meta.Creators) > 0 {
+		e.SetAuthor(strings.TrimSpace(meta.Creators[0]))
+	}
+	if len(meta.Languages) > 0 {
+		e.SetLang(strings.TrimSpace(meta.Languages[0]))
+	}
+	if len(meta.Descriptions) > 0 {
+		e.SetDescription(strings.TrimSpace(meta.Descriptions[0]))
+	}
+	for i, id := range meta.Identifiers {
+		if id.ID == er.pkg.UniqueIdentifier || (i == 0 && er.pkg.UniqueIdentifier == "") {
+			e.SetIdentifier(strings.TrimSpace(id.Data))
+		}
+	}
+	if er.pkg.Spine.Ppd != "" {
+		e.SetPpd(er.pkg.Spine.Ppd)
+	}
+	obfuscated, err := er.obfuscatedFonts()
+	if err != nil {
+		return nil, err
+	}
+
+	// Where the files are stored, by path relative to the root of the EPUB
+	files := make(map[string]openedFile)
+	used := make(map[string]bool)
+	addFile := func(p string, folder string) {
+		filename := path.Base(p)
+		if folder == xhtmlFolderName {
+			filename = strings.TrimSuffix(filename, path.Ext(filename)) + ".xhtml"
+		}
+		ext := path.Ext(filename)
+		for i := 2; used[path.Join(folder, filename)]; i++ {
+			filename = strings.TrimSuffix(path.Base(p), path.Ext(p)) + "-" + strconv.Itoa(i) + ext
+		}
+		used[path.Join(folder, filename)] = true
+		files[p] = openedFile{folder: folder, filename: filename}
+	}
+
+	var sections, resources []pkgItem
+	coverID := ""
+	for _, m := range er.pkg.Metadata.Meta {
+		if m.Name == "cover" {
+			coverID = m.Content
+		}
+	}
+	items := make(map[string]pkgItem)
+	for _, item := range er.pkg.ManifestItems {
+		items[item.ID] = item
+		if hasProperty(item.Properties, coverImageProperties) {
+			coverID = item.ID
+		}
+	}
+	inSpine := make(map[string]bool)
+	for _, ref := range er.pkg.Spine.Items {
+		item, ok := items[ref.Idref]
+		if !ok || inSpine[ref.Idref] {
+			continue
+		}
+		inSpine[ref.Idref] = true
+		if item.MediaType != mediaTypeXhtml {
+			e.addWarning(WarningSkippedFile, item.Href, "spine item %s of type %s left out", item.Href, item.MediaType)
+			continue
+		}
+		sections = append(sections, item)
+	}
+	for _, item := range er.pkg.ManifestItems {
+		switch {
+		case hasProperty(item.Properties, tocNavItemProperties), item.ID == er.pkg.Spine.Toc, item.MediaType == mediaTypeNcx:
+			// The navigation documents are generated again
+		case item.MediaType == mediaTypeXhtml:
+			if !inSpine[item.ID] {
+				sections = append(sections, item)
+			}
+		case isRemote(item.Href):
+			var err error
+			switch resourceFolderName(item.MediaType) {
+			case AudioFolderName:
+				_, err = e.AddRemoteAudio(item.Href, item.MediaType)
+			case VideoFolderName:
+				_, err = e.AddRemoteVideo(item.Href, item.MediaType)
+			default:
+				e.addWarning(WarningSkippedFile, item.Href, "remote resource %s of type %s left out", item.Href, item.MediaType)
+			}
+			if err != nil {
+				return nil, err
+			}
+		case resourceFolderName(item.MediaType) != "":
+			resources = append(resources, item)
+			addFile(er.itemPath(item), resourceFolderName(item.MediaType))
+		default:
+			e.addWarning(WarningSkippedFile, item.Href, "file %s of type %s left out", item.Href, item.MediaType)
+		}
+	}
+	for _, item := range sections {
+		addFile(er.itemPath(item), xhtmlFolderName)
+	}
+
+	// Resources, the stylesheets pointing to the new paths
+	for _, item := range resources {
+		p := er.itemPath(item)
+		data, err := er.readFile(p)
+		if err != nil {
+			return nil, err
+		}
+		if method, ok := obfuscated[p]; ok {
+			key, length, err := obfuscationKey(method, e.identifier)
+			if err != nil {
+				return nil, fmt.Errorf("can't restore font %s: %w", p, err)
+			}
+			obfuscate(data, key, length)
+			e.SetFontObfuscation(method)
+		}
+		f := files[p]
+		if f.folder == CSSFolderName {
+			data = cssReferenceRegex.ReplaceAllFunc(data, func(ref []byte) []byte {
+				m := cssReferenceRegex.FindSubmatch(ref)
+				if len(m[2]) > 0 {
+					return []byte("url(" + string(m[1]) + openedRef(files, p, f.folder, string(m[2])) + string(m[3]) + ")")
+				}
+				return []byte("@import " + string(m[4]) + openedRef(files, p, f.folder, string(m[5])) + string(m[6]))
+			})
+		}
+		if _, err := e.AddResourceBytes(data, f.filename, item.MediaType); err != nil {
+			return nil, err
+		}
+	}
+
+	// The cover page is generated again if the first document only shows the
+	// cover image
+	coverPath := ""
+	if item, ok := items[coverID]; ok && files[er.itemPath(item)].folder == ImageFolderName {
+		coverPath = er.itemPath(item)
+	}
+	coverPage, coverCSS := "", ""
+	if coverPath != "" && len(sections) > 0 && inSpine[sections[0].ID] {
+		if data, err := er.readFile(er.itemPath(sections[0])); err == nil && isCoverPage(data, er.itemPath(sections[0]), coverPath) {
+			coverPage = er.itemPath(sections[0])
+			// The stylesheet of the cover page is kept, instead of adding the
+			// default one each time the EPUB is opened and written again
+			if doc, body, err := parseDocument(data); err == nil {
+				if stylesheets := openedStylesheets(doc, body, coverPage, files); len(stylesheets) > 0 {
+					coverCSS = stylesheets[0]
+				}
+			}
+		}
+	}
+
+	// Sections
+	entries, tocErr := er.toc()
+	titles := make(map[string]string)
+	var collectTitles func(entries []*TOCEntry)
+	collectTitles = func(entries []*TOCEntry) {
+		for _, entry := range entries {
+			if _, ok := titles[entry.Filename]; !ok && entry.Fragment == "" {
+				titles[entry.Filename] = entry.Title
+			}
+			collectTitles(entry.children)
+		}
+	}
+	collectTitles(entries)
+	// Internal filenames of the sections, by base name of the original file
+	filenames := make(map[string]string)
+	for _, item := range sections {
+		p := er.itemPath(item)
+		if p == coverPage {
+			continue
+		}
+		if err := er.addSection(e, item, inSpine[item.ID], files, titles); err != nil {
+			return nil, err
+		}
+		if _, ok := filenames[path.Base(p)]; !ok {
+			filenames[path.Base(p)] = files[p].filename
+		}
+	}
+	for _, ref := range er.pkg.Spine.Items {
+		if ref.Linear == "no" {
+			if item, ok := items[ref.Idref]; ok && er.itemPath(item) != coverPage {
+				if err := e.SetSectionLinear(files[er.itemPath(item)].filename, false); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	if coverPage != "" {
+		cover := files[coverPath]
+		if err := e.SetCover("../"+cover.folder+"/"+cover.filename, coverCSS); err != nil {
+			return nil, err
+		}
+	} else if coverPath != "" {
+		// The cover image is marked as such, without a cover page
+		e.cover.imageFilename = files[coverPath].filename
+		e.pkg.setCover(e.cover.imageFilename)
+	}
+
+	if tocErr == nil {
+		var rename func(entries []*TOCEntry)
+		rename = func(entries []*TOCEntry) {
+			for _, entry := range entries {
+				if filename, ok := filenames[entry.Filename]; ok {
+					entry.Filename = filename
+				}
+				rename(entry.children)
+			}
+		}
+		rename(entries)
+		e.customTOC = append([]*TOCEntry{}, entries...)
+	}
+	return e, nil
+}
+
+// addSection adds the XHTML document of the manifest item to e as a section,
+// out of the reading order unless inSpine is true
+func (er *epubReader) addSection(e *Epub, item pkgItem, inSpine bool, files map[string]openedFile, titles map[string]string) error {
+	p := er.itemPath(item)
+	data, err := er.readFile(p)
+	if err != nil {
+		return err
+	}
+	// The HTML parser moves the whitespace following the body into it
+	if i := bytes.LastIndex(data, []byte("</body>")); i >= 0 {
+		data = data[:i+len("</body>")]
+	}
+	doc, body, err := parseDocument(data)
+	if err != nil {
+		return fmt.Errorf("can't parse %s from EPUB: %w", p, err)
+	}
+	stylesheets := openedStylesheets(doc, body, p, files)
+	walk(body, func(n *html.Node) bool {
+		for i, a := range n.Attr {
+			if a.Key == "href" || a.Key == "src" || a.Key == "poster" {
+				n.Attr[i].Val = openedRef(files, p, xhtmlFolderName, a.Val)
+			}
+		}
+		return true
+	})
+	markup, err := renderBody(body)
+	if err != nil {
+		return err
+	}
+	// The line breaks around the body are the ones added when written
+	markup = strings.TrimSuffix(strings.TrimPrefix(markup, "\n"), "\n")
+
+	filename := files[p].filename
+	title, ok := titles[path.Base(p)]
+	if !ok {
+		title = documentTitle(doc)
+	}
+	css := ""
+	if len(stylesheets) > 0 {
+		css = stylesheets[0]
+	}
+	if _, err := e.AddSection(markup, title, filename, css); err != nil {
+		return err
+	}
+	for i := 1; i < len(stylesheets); i++ {
+		if err := e.AddSectionCSS(filename, stylesheets[i]); err != nil {
+			return err
+		}
+	}
+	root := findElement(doc, func(n *html.Node) bool {
+		return n.DataAtom == atom.Html
+	})
+	if root != nil {
+		lang := getAttr(root, "lang")
+		if lang == "" {
+			lang = getAttr(root, "xml:lang")
+		}
+		if dir := getAttr(root, "dir"); lang != "" || dir != "" {
+			if err := e.SetSectionLang(filename, lang, dir); err != nil {
+				e.addWarning(WarningSkippedFile, filename, "language of %s left out: %s", p, err)
+			}
+		}
+	}
+	if !inSpine {
+		e.Lock()
+		findSection(e.sections, filename).notInSpine = true
+		e.Unlock()
+	}
+	return nil
+}
+
+// openedStylesheets returns the paths of the stylesheets linked from the head
+// of doc, the document at docPath of the EPUB read by Open, as stored
+func openedStylesheets(doc *html.Node, body *html.Node, docPath string, files map[string]openedFile) []string {
+	var stylesheets []string
+	walk(doc, func(n *html.Node) bool {
+		if n.DataAtom == atom.Link && hasProperty(getAttr(n, "rel"), "stylesheet") {
+			if f, ok := files[resolveRef(docPath, getAttr(n, "href"))]; ok && f.folder == CSSFolderName {
+				stylesheets = append(stylesheets, "../"+CSSFolderName+"/"+f.filename)
+			}
+		}
+		return n != body
+	})
+	return stylesheets
+}
+
+// obfuscatedFonts returns the obfuscation algorithms of the fonts listed in
+// META-INF/encryption.xml, by path relative to the root of the EPUB. It
+// returns an error if other files are encrypted.
+func (er *epubReader) obfuscatedFonts() (map[string]FontObfuscation, error) {
+	name := path.Join(metaInfFolderName, encryptionFilename)
+	if _, err := er.zip.Open(name); err != nil {
+		return nil, nil
+	}
+	var enc readEncryption
+	if err := er.readXML(name, &enc); err != nil {
+		return nil, err
+	}
+	obfuscated := make(map[string]FontObfuscation)
+	for _, d := range enc.EncryptedData {
+		uri, _ := url.PathUnescape(d.Reference.URI)
+		switch d.Method.Algorithm {
+		case idpfObfuscationMethod:
+			obfuscated[uri] = FontObfuscationIDPF
+		case adobeObfuscationMethod:
+			obfuscated[uri] = FontObfuscationAdobe
+		default:
+			return nil, fmt.Errorf("can't read EPUB: %s is encrypted", uri)
+		}
+	}
+	return obfuscated, nil
+}
+
+// isCoverPage returns whether the XHTML document at docPath holds no text and
+// shows the image at imagePath
+func isCoverPage(data []byte, docPath string, imagePath string) bool {
+	_, body, err := parseDocument(data)
+	if err != nil || strings.TrimSpace(textContent(body)) != "" {
+		return false
+	}
+	found := false
+	walk(body, func(n *html.Node) bool {
+		for _, a := range n.Attr {
+			if (a.Key == "src" || a.Key == "href") && resolveRef(docPath, a.Val) == imagePath {
+				found = true
+			}
+		}
+		return !found
+	})
+	return found
+}
+
+// resolveRef returns the path relative to the root of the EPUB of the file
+// ref points to from the file at base, or an empty string if it isn't a file
+// of the EPUB
+func resolveRef(base string, ref string) string {
+	target, _, ok := resolveLink(base, ref)
+	if !ok {
+		return ""
+	}
+	if unescaped, err := url.PathUnescape(target); err == nil {
+		target = unescaped
+	}
+	return target
+}
+
+// openedRef returns ref, found in the file at base of the EPUB read by Open,
+// pointing to where the file it points to is stored. folder is where the file
+// at base is stored. References to files that aren't stored are unchanged.
+func openedRef(files map[string]openedFile, base string, folder string, ref string) string {
+	f, ok := files[resolveRef(base, ref)]
+	if !ok || strings.HasPrefix(ref, "#") {
+		return ref
+	}
+	_, fragment, _ := strings.Cut(ref, "#")
+	newRef := f.filename
+	if f.folder != folder {
+		newRef = "../" + f.folder + "/" + f.filename
+	}
+	if fragment != "" {
+		newRef += "#" + fragment
+	}
+	return newRef
+}
@@ -0,0 +1,277 @@
+package epub
+
+import (
+	"archive/zip"
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/vincent-petithory/dataurl"
+)
+
+const testOpenPackage = `<?xml version="1.0" encoding="UTF-8"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="bookid">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+    <dc:identifier id="isbn">9780000000000</dc:identifier>
+    <dc:identifier id="bookid">urn:uuid:3bdbbbbd-7f43-4cd7-9c3d-7e0d2d7b1c2a</dc:identifier>
+    <dc:title>Opened Book</dc:title>
+    <dc:creator>Jane Doe</dc:creator>
+    <dc:language>fr</dc:language>
+    <dc:description>A description</dc:description>
+    <meta name="cover" content="cover-image"/>
+  </metadata>
+  <manifest>
+    <item id="nav" href="Text/nav.xhtml" media-type="application/xhtml+xml" properties="nav"/>
+    <item id="style" href="Styles/style.css" media-type="text/css"/>
+    <item id="cover-image" href="Images/cover.png" media-type="image/png"/>
+    <item id="cover" href="Text/cover.xhtml" media-type="application/xhtml+xml"/>
+    <item id="one" href="Text/one.html" media-type="application/xhtml+xml"/>
+    <item id="notes" href="Text/notes.xhtml" media-type="application/xhtml+xml"/>
+    <item id="script" href="Misc/script.js" media-type="application/javascript"/>
+  </manifest>
+  <spine page-progression-direction="ltr">
+    <itemref idref="cover"/>
+    <itemref idref="one"/>
+    <itemref idref="notes" linear="no"/>
+  </spine>
+</package>`
+
+const testOpenNav = `<?xml version="1.0" encoding="UTF-8"?>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops">
+<head><title>Contents</title></head>
+<body>
+<nav epub:type="toc"><ol>
+  <li><a href="one.html">Chapter One</a><ol>
+    <li><a href="one.html#part">Part</a></li>
+  </ol></li>
+</ol></nav>
+</body>
+</html>`
+
+const testOpenCover = `<?xml version="1.0" encoding="UTF-8"?>
+<html xmlns="http://www.w3.org/1999/xhtml">
+<head><title>Cover</title></head>
+<body><div><img src="../Images/cover.png" alt=""/></div></body>
+</html>`
+
+const testOpenSection = `<?xml version="1.0" encoding="UTF-8"?>
+<html xmlns="http://www.w3.org/1999/xhtml" lang="fr">
+<head>
+  <title>One</title>
+  <link rel="stylesheet" type="text/css" href="../Styles/style.css"/>
+</head>
+<body>
+<h1 id="part">Un</h1>
+<p><img src="../Images/cover.png" alt=""/> <a href="notes.xhtml#n1">1</a> <a href="https://example.com/">Web</a></p>
+</body>
+</html>`
+
+const testOpenNotes = `<?xml version="1.0" encoding="UTF-8"?>
+<html xmlns="http://www.w3.org/1999/xhtml">
+<head><title>Notes</title></head>
+<body><p id="n1"><a href="one.html#part">Back</a></p></body>
+</html>`
+
+func TestOpen(t *testing.T) {
+	image, err := os.ReadFile(testImageFromFileSource)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sourcePath := filepath.Join(t.TempDir(), "source.epub")
+	f, err := os.Create(sourcePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	z := zip.NewWriter(f)
+	for _, file := range []struct {
+		name    string
+		content string
+	}{
+		{"mimetype", mediaTypeEpub},
+		{"META-INF/container.xml", testNcxOnlyContainer},
+		{"OEBPS/content.opf", testOpenPackage},
+		{"OEBPS/Text/nav.xhtml", testOpenNav},
+		{"OEBPS/Text/cover.xhtml", testOpenCover},
+		{"OEBPS/Text/one.html", testOpenSection},
+		{"OEBPS/Text/notes.xhtml", testOpenNotes},
+		{"OEBPS/Styles/style.css", `body { background: url("../Images/cover.png"); }`},
+		{"OEBPS/Images/cover.png", string(image)},
+		{"OEBPS/Misc/script.js", "alert(1);"},
+	} {
+		w, err := z.Create(file.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(file.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := z.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	e, err := Open(sourcePath)
+	if err != nil {
+		t.Fatalf("Error opening EPUB: %s", err)
+	}
+	if e.Title() != "Opened Book" || e.Author() != "Jane Doe" || e.Lang() != "fr" || e.Description() != "A description" {
+		t.Errorf("Unexpected metadata: %q, %q, %q, %q", e.Title(), e.Author(), e.Lang(), e.Description())
+	}
+	if e.Identifier() != "urn:uuid:3bdbbbbd-7f43-4cd7-9c3d-7e0d2d7b1c2a" {
+		t.Errorf("Unexpected identifier %q", e.Identifier())
+	}
+
+	sections := e.Sections()
+	var filenames []string
+	for _, s := range sections {
+		filenames = append(filenames, s.Filename)
+	}
+	if strings.Join(filenames, " ") != defaultCoverXhtmlFilename+" one.xhtml notes.xhtml" {
+		t.Errorf("Unexpected sections %v", filenames)
+	}
+	one := sections[1]
+	for _, want := range []string{`src="../images/cover.png"`, `href="notes.xhtml#n1"`, `href="https://example.com/"`} {
+		if !strings.Contains(one.Body, want) {
+			t.Errorf("Body of one.xhtml doesn't contain %s:\n%s", want, one.Body)
+		}
+	}
+	if !strings.Contains(sections[2].Body, `href="one.xhtml#part"`) {
+		t.Errorf("Link of notes.xhtml not updated:\n%s", sections[2].Body)
+	}
+
+	found := false
+	for _, w := range e.Warnings() {
+		if w.Kind == WarningSkippedFile && strings.Contains(w.Message, "script.js") {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("Script not reported as left out: %v", e.Warnings())
+	}
+
+	if _, err := e.AddSection("<p>Added</p>", "Added", "added.xhtml", ""); err != nil {
+		t.Fatal(err)
+	}
+	var b bytes.Buffer
+	if _, err := e.WriteTo(&b); err != nil {
+		t.Fatalf("Error writing opened EPUB: %s", err)
+	}
+	r, err := zip.NewReader(bytes.NewReader(b.Bytes()), int64(b.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	contents := make(map[string]string)
+	for _, f := range r.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var content bytes.Buffer
+		content.ReadFrom(rc)
+		rc.Close()
+		contents[f.Name] = content.String()
+	}
+	if css := contents["EPUB/css/style.css"]; !strings.Contains(css, `url("../images/cover.png")`) {
+		t.Errorf("Stylesheet reference not updated: %s", css)
+	}
+	if section := contents["EPUB/xhtml/one.xhtml"]; !strings.Contains(section, `href="../css/style.css"`) || !strings.Contains(section, `lang="fr"`) {
+		t.Errorf("Stylesheet or language of one.xhtml not kept:\n%s", section)
+	}
+	pkg := contents["EPUB/package.opf"]
+	for _, want := range []string{`<itemref idref="notes.xhtml" linear="no"`, `properties="cover-image"`} {
+		if !strings.Contains(pkg, want) {
+			t.Errorf("Package doesn't contain %s:\n%s", want, pkg)
+		}
+	}
+	nav := contents["EPUB/nav.xhtml"]
+	if !strings.Contains(nav, `href="xhtml/one.xhtml#part"`) || !strings.Contains(nav, "Chapter One") {
+		t.Errorf("TOC not kept:\n%s", nav)
+	}
+}
+
+func TestOpenRoundTrip(t *testing.T) {
+	source, err := NewEpub(testEpubTitle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	source.SetAuthor(testEpubAuthor)
+	source.SetFontObfuscation(FontObfuscationIDPF)
+	fontPath, err := source.AddFont(testFontFromFileSource, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := source.AddSection(testSectionBody, "Chapter 1", "one.xhtml", ""); err != nil {
+		t.Fatal(err)
+	}
+	sourcePath := filepath.Join(t.TempDir(), "source.epub")
+	if err := source.Write(sourcePath); err != nil {
+		t.Fatal(err)
+	}
+
+	e, err := Open(sourcePath)
+	if err != nil {
+		t.Fatalf("Error opening EPUB: %s", err)
+	}
+	if e.Title() != testEpubTitle || e.Author() != testEpubAuthor || e.Identifier() != source.Identifier() {
+		t.Errorf("Unexpected metadata: %q, %q, %q", e.Title(), e.Author(), e.Identifier())
+	}
+	if e.fontObfuscation != FontObfuscationIDPF {
+		t.Error("Font obfuscation not kept")
+	}
+	font, err := dataurl.DecodeString(e.fonts[filepath.Base(fontPath)])
+	if err != nil {
+		t.Fatal(err)
+	}
+	original, err := os.ReadFile(testFontFromFileSource)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(font.Data, original) {
+		t.Error("Obfuscated font not restored")
+	}
+	findings, err := e.Validate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range findings {
+		if f.Severity == SeverityError {
+			t.Errorf("Opened EPUB invalid: %s", f)
+		}
+	}
+}
+
+func TestOpenRoundTripTwice(t *testing.T) {
+	e, err := NewEpub(testEpubTitle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	imagePath, err := e.AddImage(testImageFromFileSource, "cover.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.SetCover(imagePath, ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.AddSection(testSectionBody, "Chapter 1", "one.xhtml", ""); err != nil {
+		t.Fatal(err)
+	}
+	body := findSection(e.sections, "one.xhtml").xhtml.body()
+	for i := 0; i < 2; i++ {
+		epubPath := filepath.Join(t.TempDir(), "book.epub")
+		if err := e.Write(epubPath); err != nil {
+			t.Fatal(err)
+		}
+		if e, err = Open(epubPath); err != nil {
+			t.Fatalf("Error opening EPUB: %s", err)
+		}
+		if len(e.css) != 1 {
+			t.Errorf("Expected the cover stylesheet only after %d round trips, got %v", i+1, e.css)
+		}
+		if got := findSection(e.sections, "one.xhtml").xhtml.body(); got != body {
+			t.Errorf("Section body changed after %d round trips:\n%q\nexpected:\n%q", i+1, got, body)
+		}
+	}
+}
@@ -0,0 +1,257 @@
+package epub
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// Output receives the files of the EPUB when it is written with
+// WriteToOutput, e.g. to upload them to a remote store. WriteTo writes to a
+// zip archive, WriteDir to a directory.
+type Output interface {
+	// CreateFile returns a writer for the file at name, a slash-separated
+	// path relative to the root of the EPUB, e.g. EPUB/package.opf. The
+	// mimetype file is created first. Each writer is closed before the next
+	// file is created; the file is complete once it is closed without error.
+	CreateFile(name string) (io.WriteCloser, error)
+}
+
+// MapOutput is an Output keeping the files of the EPUB in memory, by name.
+type MapOutput map[string][]byte
+
+// CreateFile implements Output.
+func (m MapOutput) CreateFile(name string) (io.WriteCloser, error) {
+	return &mapFile{m: m, name: name}, nil
+}
+
+// mapFile is a file of a MapOutput, stored once closed
+type mapFile struct {
+	bytes.Buffer
+	m    MapOutput
+	name string
+}
+
+func (f *mapFile) Close() error {
+	f.m[f.name] = f.Bytes()
+	return nil
+}
+
+// NewDirOutput returns an Output writing the files of the EPUB to the
+// directory at dirPath of the local filesystem, creating the folders as
+// needed. Unlike WriteDir, it writes in place and fails if a file already
+// exists.
+func NewDirOutput(dirPath string) Output {
+	return dirOutput(dirPath)
+}
+
+type dirOutput string
+
+func (d dirOutput) CreateFile(name string) (io.WriteCloser, error) {
+	p := filepath.Join(string(d), filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(p), dirPermissions); err != nil {
+		return nil, err
+	}
+	return os.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_EXCL, filePermissions)
+}
+
+// WriteToOutput writes the files of the EPUB to out instead of an archive.
+func (e *Epub) WriteToOutput(out Output) error {
+	return e.WriteToOutputContext(context.Background(), out)
+}
+
+// WriteToOutputContext is like WriteToOutput, stopping when ctx is done as
+// WriteToContext does.
+func (e *Epub) WriteToOutputContext(ctx context.Context, out Output) error {
+	e.Lock()
+	defer e.Unlock()
+	_, err := e.write(ctx, func(rootEpubDir string) (int64, error) {
+		return e.writeOutput(rootEpubDir, out)
+	})
+	return err
+}
+
+// countingOutput is an Output that knows how many bytes it wrote, e.g. once
+// compressed
+type countingOutput interface {
+	bytesWritten() int64
+}
+
+// zipOutput is the Output of WriteTo, adding the files to a zip archive
+type zipOutput struct {
+	z       *zip.Writer
+	counter *writeCounter
+	// Compression of the files, by media type
+	compression Compression
+	mediaTypes  map[string]string
+	cache       *buildCache
+}
+
+func newZipOutput(e *Epub, dst io.Writer) *zipOutput {
+	counter := &writeCounter{}
+	z := zip.NewWriter(io.MultiWriter(counter, dst))
+	e.compression.registerCompressor(z)
+	return &zipOutput{
+		z:           z,
+		counter:     counter,
+		compression: e.compression,
+		mediaTypes:  e.manifestMediaTypes(),
+		cache:       e.cache,
+	}
+}
+
+func (o *zipOutput) CreateFile(name string) (io.WriteCloser, error) {
+	method := o.compression.method(o.mediaTypes[name])
+	if name == mimetypeFilename {
+		// The mimetype file must be uncompressed according to the EPUB spec
+		method = zip.Store
+	}
+	w, err := o.z.CreateHeader(&zip.FileHeader{
+		Name:   name,
+		Method: method,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error creating zip writer: %w", err)
+	}
+	return nopWriteCloser{w}, nil
+}
+
+// createCached adds the file at name, read from r, through the build cache.
+// It returns the size of the file and whether it was reused from the previous
+// build.
+func (o *zipOutput) createCached(name string, r io.Reader) (int64, bool, error) {
+	return o.cache.write(o.z, name, r, o.compression.method(o.mediaTypes[name]), o.compression.compressionLevel())
+}
+
+func (o *zipOutput) bytesWritten() int64 {
+	return o.counter.Total
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+// writeOutput writes the files of the EPUB from rootEpubDir in the storage to
+// out, the mimetype file first. The return value is the number of bytes
+// written.
+func (e *Epub) writeOutput(rootEpubDir string, out Output) (int64, error) {
+	g := e.grabber(e.ctx)
+	// Size of the files added so far, before compression
+	checkTotalSize := e.totalSizeChecker()
+	var total int64
+	written := func() int64 {
+		if c, ok := out.(countingOutput); ok {
+			return c.bytesWritten()
+		}
+		return total
+	}
+	zo, _ := out.(*zipOutput)
+	mimetypeWritten := false
+	mimetypeFilePath := filepath.Join(rootEpubDir, mimetypeFilename)
+
+	// addFile adds the file present at path to out. The path is relative to
+	// the rootEpubDir
+	addFile := func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if e.ctx != nil && e.ctx.Err() != nil {
+			return e.ctx.Err()
+		}
+
+		// Get the path of the file relative to the folder we're writing
+		relativePath, err := filepath.Rel(rootEpubDir, path)
+		if err != nil {
+			// tempDir and path are both internal, so we shouldn't get here
+			return err
+		}
+		relativePath = filepath.ToSlash(relativePath)
+
+		// Only include regular files, not directories
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		isMimetype := filepath.FromSlash(path) == mimetypeFilePath
+		if isMimetype && mimetypeWritten {
+			return nil
+		}
+
+		var n int64
+		reused := false
+		_, streamed := e.streamed[filepath.FromSlash(path)]
+		cached := zo != nil && zo.cache != nil && !streamed && !isMimetype && !e.spool.spooled(path)
+		if cached {
+			r, err := e.spool.open(path)
+			if err != nil {
+				return fmt.Errorf("error opening file %v being added to EPUB: %w", path, err)
+			}
+			n, reused, err = zo.createCached(relativePath, r)
+			r.Close()
+			if err != nil {
+				return fmt.Errorf("error copying contents of file being added EPUB: %w", err)
+			}
+		} else {
+			w, err := out.CreateFile(relativePath)
+			if err != nil {
+				return err
+			}
+			n, err = e.copyFile(g, w, path, relativePath)
+			if closeErr := w.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				return fmt.Errorf("error copying contents of file being added EPUB: %w", err)
+			}
+		}
+		total += n
+		e.report.addFile(relativePath, n, reused)
+		e.fileZipped(relativePath, written())
+		return checkTotalSize(n)
+	}
+
+	// Add the mimetype file first
+	mimetypeInfo, err := fs.Stat(filesystem, mimetypeFilePath)
+	if err != nil {
+		return written(), fmt.Errorf("unable to get FileInfo for mimetype file: %w", err)
+	}
+	err = addFile(mimetypeFilePath, fileInfoToDirEntry(mimetypeInfo), nil)
+	if err != nil {
+		return written(), fmt.Errorf("unable to add mimetype file to EPUB: %w", err)
+	}
+	mimetypeWritten = true
+
+	err = fs.WalkDir(filesystem, rootEpubDir, addFile)
+	if err != nil {
+		return written(), fmt.Errorf("unable to add file to EPUB: %w", err)
+	}
+	return written(), nil
+}
+
+// Write the EPUB file itself by zipping up everything from a temp directory
+// The return value is the number of bytes written. Any error encountered during the write is also returned.
+func (e *Epub) writeEpub(rootEpubDir string, dst io.Writer) (int64, error) {
+	zo := newZipOutput(e, dst)
+	n, err := e.writeOutput(rootEpubDir, zo)
+	if err != nil {
+		if err := zo.z.Close(); err != nil {
+			log.Println(err)
+		}
+		return n, err
+	}
+	err = zo.z.Close()
+	return zo.bytesWritten(), err
+}
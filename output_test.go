@@ -0,0 +1,74 @@
+package epub
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteToOutput(t *testing.T) {
+	image, err := os.ReadFile(testImageFromFileSource)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := NewEpub(testEpubTitle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.AddImage(testImageFromFileSource, ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.AddSection(testSectionBody, testSectionTitle, testSectionFilename, ""); err != nil {
+		t.Fatal(err)
+	}
+	imageName := path.Join(contentFolderName, ImageFolderName, filepath.Base(testImageFromFileSource))
+
+	out := &recordingOutput{MapOutput: make(MapOutput)}
+	if err := e.WriteToOutput(out); err != nil {
+		t.Fatalf("Error writing EPUB to map: %s", err)
+	}
+	if string(out.MapOutput[mimetypeFilename]) != mediaTypeEpub {
+		t.Errorf("Unexpected mimetype file: %q", out.MapOutput[mimetypeFilename])
+	}
+	if out.order[0] != mimetypeFilename {
+		t.Errorf("Expected the mimetype file first, got %v", out.order)
+	}
+	if !bytes.Equal(out.MapOutput[imageName], image) {
+		t.Errorf("Unexpected content of the image: %d bytes", len(out.MapOutput[imageName]))
+	}
+	for _, name := range []string{
+		path.Join(metaInfFolderName, containerFilename),
+		path.Join(contentFolderName, pkgFilename),
+		path.Join(contentFolderName, xhtmlFolderName, testSectionFilename),
+	} {
+		if _, ok := out.MapOutput[name]; !ok {
+			t.Errorf("Expected %s in the output, got %v", name, out.order)
+		}
+	}
+
+	dirPath := t.TempDir()
+	if err := e.WriteToOutput(NewDirOutput(dirPath)); err != nil {
+		t.Fatalf("Error writing EPUB to directory: %s", err)
+	}
+	content, err := os.ReadFile(filepath.Join(dirPath, filepath.FromSlash(imageName)))
+	if err != nil || !bytes.Equal(content, image) {
+		t.Errorf("Unexpected content of the image: %d bytes, %v", len(content), err)
+	}
+	if err := e.WriteToOutput(NewDirOutput(dirPath)); err == nil {
+		t.Error("Expected an error overwriting the files of a directory")
+	}
+}
+
+// recordingOutput is a MapOutput recording the order of the files
+type recordingOutput struct {
+	MapOutput
+	order []string
+}
+
+func (o *recordingOutput) CreateFile(name string) (io.WriteCloser, error) {
+	o.order = append(o.order, name)
+	return o.MapOutput.CreateFile(name)
+}
@@ -0,0 +1,207 @@
+package epub
+
+import (
+	"encoding/xml"
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	mediaTypeSmil         = "application/smil+xml"
+	smilFolderName        = "smil"
+	smilVersion           = "3.0"
+	smilEpubNamespace     = "http://www.idpf.org/2007/ops"
+	smilFileExtension     = ".smil"
+	smilParagraphIDFormat = "par%d"
+)
+
+// SyncPoint synchronizes a fragment of a section with a clip of an audio file,
+// read aloud while the fragment is highlighted.
+type SyncPoint struct {
+	TextFragmentID string        // id of the element of the section, without the leading #
+	ClipBegin      time.Duration // Start of the clip in the audio file
+	ClipEnd        time.Duration // End of the clip in the audio file
+}
+
+// mediaOverlay is the narration of a section
+type mediaOverlay struct {
+	audioFilename string
+	syncPoints    []SyncPoint
+}
+
+// duration returns the total playing time of the clips of the overlay
+func (o *mediaOverlay) duration() time.Duration {
+	var d time.Duration
+	for _, p := range o.syncPoints {
+		d += p.ClipEnd - p.ClipBegin
+	}
+	return d
+}
+
+// smilRoot holds the actual XML for a media overlay document
+// Spec: https://www.w3.org/TR/epub-33/#sec-media-overlays
+type smilRoot struct {
+	XMLName   xml.Name `xml:"http://www.w3.org/ns/SMIL smil"`
+	XmlnsEpub string   `xml:"xmlns:epub,attr"`
+	Version   string   `xml:"version,attr"`
+	Body      smilBody `xml:"body"`
+}
+
+// The <body> element, referencing the section
+type smilBody struct {
+	Textref string    `xml:"epub:textref,attr"`
+	Pars    []smilPar `xml:"par"`
+}
+
+// <par> elements, one per sync point
+// Ex: <par id="par1">
+//
+//	  <text src="../xhtml/section0001.xhtml#p1" />
+//	  <audio src="../audios/audio0001.mp3" clipBegin="0:00:00.000" clipEnd="0:00:05.250" />
+//	</par>
+type smilPar struct {
+	ID    string    `xml:"id,attr"`
+	Text  smilText  `xml:"text"`
+	Audio smilAudio `xml:"audio"`
+}
+
+type smilText struct {
+	Src string `xml:"src,attr"`
+}
+
+type smilAudio struct {
+	Src       string `xml:"src,attr"`
+	ClipBegin string `xml:"clipBegin,attr"`
+	ClipEnd   string `xml:"clipEnd,attr"`
+}
+
+// AddMediaOverlay synchronizes an already-added section with the narration in
+// an audio file added with AddAudio, for read-aloud EPUBs. Each sync point
+// associates the element of the section with the given id to a clip of the
+// audio, in reading order.
+//
+// A SMIL media overlay document is written for the section, linked to it from
+// the manifest, and its duration is added to the package metadata along with
+// the total duration of the overlays, which takes the place of the one set by
+// SetDurationProber. Adding an overlay to a section that already has one
+// replaces it.
+//
+// The audio path is the one returned by AddAudio, or its internal filename. If
+// no section or audio with the internal filename exists,
+// SectionDoesNotExistError or ResourceDoesNotExistError will be returned.
+func (e *Epub) AddMediaOverlay(sectionFilename string, audioPath string, syncPoints []SyncPoint) error {
+	e.Lock()
+	defer e.Unlock()
+	section := findSection(e.sections, sectionFilename)
+	if section == nil {
+		return &SectionDoesNotExistError{Filename: sectionFilename}
+	}
+	audioFilename := strings.TrimPrefix(audioPath, "../"+AudioFolderName+"/")
+	if _, ok := e.audios[audioFilename]; !ok {
+		return &ResourceDoesNotExistError{Filename: audioFilename}
+	}
+	if len(syncPoints) == 0 {
+		return fmt.Errorf("can't add media overlay to %s: no sync points", sectionFilename)
+	}
+	for _, p := range syncPoints {
+		if p.TextFragmentID == "" {
+			return fmt.Errorf("can't add media overlay to %s: empty text fragment id", sectionFilename)
+		}
+		if p.ClipBegin < 0 || p.ClipEnd <= p.ClipBegin {
+			return fmt.Errorf("can't add media overlay to %s: invalid clip %s-%s for #%s", sectionFilename, p.ClipBegin, p.ClipEnd, p.TextFragmentID)
+		}
+	}
+	section.overlay = &mediaOverlay{
+		audioFilename: audioFilename,
+		syncPoints:    append([]SyncPoint(nil), syncPoints...),
+	}
+	return nil
+}
+
+// sectionOverlay returns the media overlay of section, either added with
+// AddMediaOverlay or synchronizing an audio track, or nil if it has none
+func (e *Epub) sectionOverlay(section *epubSection) *mediaOverlay {
+	if section.overlay != nil {
+		return section.overlay
+	}
+	return e.trackOverlay(section)
+}
+
+// smilFilename returns the internal filename of the media overlay document of
+// the section sectionFilename
+func smilFilename(sectionFilename string) string {
+	return strings.TrimSuffix(sectionFilename, path.Ext(sectionFilename)) + smilFileExtension
+}
+
+// writeMediaOverlay writes the media overlay document of section, if it has
+// one, and links it from the manifest item of the section
+func (e *Epub) writeMediaOverlay(rootEpubDir string, section *epubSection) error {
+	o := e.sectionOverlay(section)
+	if o == nil {
+		return nil
+	}
+	if _, ok := e.audios[o.audioFilename]; !ok {
+		return fmt.Errorf("media overlay of %s not written: audio %s was removed", section.filename, o.audioFilename)
+	}
+
+	sectionPath := path.Join("..", xhtmlFolderName, section.filename)
+	s := smilRoot{
+		XmlnsEpub: smilEpubNamespace,
+		Version:   smilVersion,
+		Body:      smilBody{Textref: sectionPath},
+	}
+	for i, p := range o.syncPoints {
+		s.Body.Pars = append(s.Body.Pars, smilPar{
+			ID:   fmt.Sprintf(smilParagraphIDFormat, i+1),
+			Text: smilText{Src: sectionPath + "#" + p.TextFragmentID},
+			Audio: smilAudio{
+				Src:       path.Join("..", AudioFolderName, o.audioFilename),
+				ClipBegin: clockValue(p.ClipBegin),
+				ClipEnd:   clockValue(p.ClipEnd),
+			},
+		})
+	}
+	output, err := xml.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("Error marshalling XML for media overlay of %s: %w", section.filename, err)
+	}
+	content := append([]byte(xml.Header), output...)
+	content = append(content, "\n"...)
+
+	filename := smilFilename(section.filename)
+	if err := filesystem.WriteFile(filepath.Join(rootEpubDir, contentFolderName, smilFolderName, filename), content, filePermissions); err != nil {
+		return fmt.Errorf("Error writing media overlay of %s: %w", section.filename, err)
+	}
+	id, err := fixXMLId(path.Join(smilFolderName, filename))
+	if err != nil {
+		return fmt.Errorf("error creating xml id: %w", err)
+	}
+	e.pkg.addToManifest(id, path.Join(smilFolderName, filename), mediaTypeSmil, "")
+	e.pkg.setMediaOverlay(section.filename, id)
+	e.pkg.setDuration(id, clockValue(o.duration()))
+	return nil
+}
+
+// mediaOverlaysDuration returns the total duration of the media overlays, and
+// whether there are any to write
+func (e *Epub) mediaOverlaysDuration() (time.Duration, bool) {
+	var total time.Duration
+	found := false
+	var f func(sections []*epubSection)
+	f = func(sections []*epubSection) {
+		for _, s := range sections {
+			if o := e.sectionOverlay(s); o != nil {
+				if _, ok := e.audios[o.audioFilename]; ok {
+					found = true
+					total += o.duration()
+				}
+			}
+			f(s.children)
+		}
+	}
+	f(e.sections)
+	return total, found
+}
@@ -0,0 +1,88 @@
+package epub
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-shiori/go-epub/internal/storage"
+)
+
+func TestAddMediaOverlay(t *testing.T) {
+	e, err := NewEpub(testEpubTitle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sectionFilename, err := e.AddSection(`<p id="p1">First</p><p id="p2">Second</p>`, "Chapter", "chapter.xhtml", "")
+	if err != nil {
+		t.Fatalf("Error adding section: %s", err)
+	}
+	audioPath, err := e.AddAudio(testAudioFromFileSource, testAudioFromFileFilename)
+	if err != nil {
+		t.Fatalf("Error adding audio: %s", err)
+	}
+	syncPoints := []SyncPoint{
+		{TextFragmentID: "p1", ClipBegin: 0, ClipEnd: 100 * time.Millisecond},
+		{TextFragmentID: "p2", ClipBegin: 100 * time.Millisecond, ClipEnd: 190 * time.Millisecond},
+	}
+
+	err = e.AddMediaOverlay("missing.xhtml", audioPath, syncPoints)
+	if _, ok := err.(*SectionDoesNotExistError); !ok {
+		t.Errorf("Expected error SectionDoesNotExistError not returned. Returned instead: %+v", err)
+	}
+	err = e.AddMediaOverlay(sectionFilename, "../"+AudioFolderName+"/missing.wav", syncPoints)
+	if _, ok := err.(*ResourceDoesNotExistError); !ok {
+		t.Errorf("Expected error ResourceDoesNotExistError not returned. Returned instead: %+v", err)
+	}
+	if err := e.AddMediaOverlay(sectionFilename, audioPath, []SyncPoint{{TextFragmentID: "p1", ClipBegin: time.Second, ClipEnd: time.Second}}); err == nil {
+		t.Error("Expected an error adding an empty clip")
+	}
+	if err := e.AddMediaOverlay(sectionFilename, audioPath, syncPoints); err != nil {
+		t.Fatalf("Error adding media overlay: %s", err)
+	}
+
+	tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+	defer cleanup(testEpubFilename, tempDir)
+
+	smil, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, smilFolderName, "chapter.smil"))
+	if err != nil {
+		t.Fatalf("Unexpected error reading media overlay: %s", err)
+	}
+	expectedSmil := `<?xml version="1.0" encoding="UTF-8"?>
+<smil xmlns="http://www.w3.org/ns/SMIL" xmlns:epub="http://www.idpf.org/2007/ops" version="3.0">
+  <body epub:textref="../xhtml/chapter.xhtml">
+    <par id="par1">
+      <text src="../xhtml/chapter.xhtml#p1"></text>
+      <audio src="../audios/` + testAudioFromFileFilename + `" clipBegin="0:00:00.000" clipEnd="0:00:00.100"></audio>
+    </par>
+    <par id="par2">
+      <text src="../xhtml/chapter.xhtml#p2"></text>
+      <audio src="../audios/` + testAudioFromFileFilename + `" clipBegin="0:00:00.100" clipEnd="0:00:00.190"></audio>
+    </par>
+  </body>
+</smil>
+`
+	if trimAllSpace(string(smil)) != trimAllSpace(expectedSmil) {
+		t.Errorf("Media overlay doesn't match\nGot: %s\nExpected: %s", smil, expectedSmil)
+	}
+
+	pkg, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, pkgFilename))
+	if err != nil {
+		t.Fatalf("Unexpected error reading package file: %s", err)
+	}
+	id, err := fixXMLId(smilFolderName + "/chapter.smil")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		`<item id="` + id + `" href="smil/chapter.smil" media-type="application/smil+xml"></item>`,
+		`href="xhtml/chapter.xhtml" media-type="application/xhtml+xml" media-overlay="` + id + `"`,
+		`<meta refines="#` + id + `" property="media:duration">0:00:00.190</meta>`,
+		`<meta property="media:duration">0:00:00.190</meta>`,
+	} {
+		if !strings.Contains(string(pkg), expected) {
+			t.Errorf("Package file doesn't contain %s:\n%s", expected, pkg)
+		}
+	}
+}
@@ -0,0 +1,228 @@
+package epub
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+var romanNumeral = regexp.MustCompile(`^(?i)[ivxlcdm]+$`)
+
+// A page break of the print equivalent of a section
+type pageBreak struct {
+	label string
+	id    string // id of the marker in the section
+	// Type and value of the NCX page target, guessed from the label if empty
+	kind  string
+	value int
+}
+
+// PageLabelStyle is the numbering style of the page labels generated by a
+// PageLabelPolicy.
+type PageLabelStyle int
+
+const (
+	// Arabic numbers: 1, 2, 3...
+	PageLabelDecimal PageLabelStyle = iota
+	// Lowercase roman numerals: i, ii, iii...
+	PageLabelLowerRoman
+	// Uppercase roman numerals: I, II, III...
+	PageLabelUpperRoman
+)
+
+// PageLabelPolicy defines the labels of the page breaks added with an empty
+// label, see SetPageLabelPolicy.
+type PageLabelPolicy struct {
+	Style PageLabelStyle
+	// Text prepended to the number, e.g. "A-" for A-1, A-2...
+	Prefix string
+	// Number of the first page, 1 if zero or less
+	Start int
+	// Format returns the label of the page with number n, replacing Style and
+	// Prefix, e.g. for labels such as "Plate 3"
+	Format func(n int) string
+}
+
+// SetPageLabelPolicy sets how the following calls to AddPageBreak with an
+// empty label label their page, and restarts the numbering at policy.Start.
+// As in print books, the front matter is usually numbered with lowercase
+// roman numerals, then the numbering restarts at 1 with the first chapter:
+//
+//	e.SetPageLabelPolicy(PageLabelPolicy{Style: PageLabelLowerRoman})
+//	e.AddPageBreak("preface.xhtml", "", "") // i
+//	e.AddPageBreak("preface.xhtml", "", "p2") // ii
+//	e.SetPageLabelPolicy(PageLabelPolicy{})
+//	e.AddPageBreak("chapter1.xhtml", "", "") // 1
+//
+// The pages labelled with roman numerals are listed as front matter in the
+// NCX page list, the ones labelled by Format as special pages. By default the
+// pages are numbered 1, 2, 3... The page breaks added with a label don't
+// advance the numbering.
+func (e *Epub) SetPageLabelPolicy(policy PageLabelPolicy) {
+	e.Lock()
+	defer e.Unlock()
+	e.pageLabelPolicy = policy
+	e.pageNumber = 0
+}
+
+// nextPageBreak returns the page break following the last one labelled by the
+// page label policy, without its id
+func (e *Epub) nextPageBreak() pageBreak {
+	policy := e.pageLabelPolicy
+	n := e.pageNumber + 1
+	if policy.Start > 0 {
+		n = e.pageNumber + policy.Start
+	}
+	if policy.Format != nil {
+		return pageBreak{label: policy.Format(n), kind: "special"}
+	}
+	switch policy.Style {
+	case PageLabelLowerRoman:
+		return pageBreak{label: policy.Prefix + strings.ToLower(toRoman(n)), kind: "front", value: n}
+	case PageLabelUpperRoman:
+		return pageBreak{label: policy.Prefix + toRoman(n), kind: "front", value: n}
+	}
+	return pageBreak{label: policy.Prefix + strconv.Itoa(n), kind: "normal", value: n}
+}
+
+// toRoman returns n in uppercase roman numerals, or in arabic numbers if it is
+// out of their range
+func toRoman(n int) string {
+	if n <= 0 || n >= 4000 {
+		return strconv.Itoa(n)
+	}
+	numerals := []struct {
+		value  int
+		symbol string
+	}{
+		{1000, "M"}, {900, "CM"}, {500, "D"}, {400, "CD"}, {100, "C"}, {90, "XC"},
+		{50, "L"}, {40, "XL"}, {10, "X"}, {9, "IX"}, {5, "V"}, {4, "IV"}, {1, "I"},
+	}
+	var b strings.Builder
+	for _, numeral := range numerals {
+		for ; n >= numeral.value; n -= numeral.value {
+			b.WriteString(numeral.symbol)
+		}
+	}
+	return b.String()
+}
+
+// AddPageBreak marks the start of a page of the print equivalent of the EPUB,
+// e.g. to allow citations by page number. A marker
+//
+//	<span epub:type="pagebreak" role="doc-pagebreak" id="page-12" aria-label="12"></span>
+//
+// is inserted in the already-added section, before the element with the id
+// anchor, or at the start of the section if anchor is empty. An empty
+// pageLabel is generated following the policy set with SetPageLabelPolicy.
+// The page breaks are listed in a page-list nav in the navigation document
+// and in the page list of the NCX, in reading order.
+//
+// The body of the section is parsed with an HTML5 parser and serialized back
+// as well-formed XHTML. Page breaks are dropped if UpdateSection later removes
+// their marker.
+func (e *Epub) AddPageBreak(internalFilename string, pageLabel string, anchor string) error {
+	e.Lock()
+	defer e.Unlock()
+	s := findSection(e.sections, internalFilename)
+	if s == nil {
+		return &SectionDoesNotExistError{Filename: internalFilename}
+	}
+	if s.raw != "" {
+		return &RawSectionError{Filename: internalFilename}
+	}
+	p := pageBreak{label: pageLabel}
+	if pageLabel == "" {
+		p = e.nextPageBreak()
+		if p.label == "" {
+			return fmt.Errorf("can't add page break to %s: empty page label", internalFilename)
+		}
+	}
+
+	root, err := parseBody(s.xhtml.body())
+	if err != nil {
+		return fmt.Errorf("can't add page break to %s: %w", internalFilename, err)
+	}
+	used := map[string]bool{}
+	var next *html.Node
+	walk(root, func(n *html.Node) bool {
+		if id := getAttr(n, "id"); id != "" {
+			used[id] = true
+			if anchor != "" && id == anchor && next == nil {
+				next = n
+			}
+		}
+		return true
+	})
+	if anchor == "" {
+		next = root.FirstChild
+	} else if next == nil {
+		return fmt.Errorf("can't add page break to %s: no element with id %q", internalFilename, anchor)
+	}
+
+	base := "page-" + slugify(p.label)
+	id := base
+	for i := 2; used[id]; i++ {
+		id = fmt.Sprintf("%s-%d", base, i)
+	}
+	marker := &html.Node{
+		Type:     html.ElementNode,
+		Data:     "span",
+		DataAtom: atom.Span,
+		Attr: []html.Attribute{
+			{Key: "epub:type", Val: "pagebreak"},
+			{Key: "role", Val: "doc-pagebreak"},
+			{Key: "id", Val: id},
+			{Key: "aria-label", Val: p.label},
+		},
+	}
+	if next == nil {
+		root.AppendChild(marker)
+	} else {
+		next.Parent.InsertBefore(marker, next)
+	}
+
+	body, err := renderBody(root)
+	if err != nil {
+		return fmt.Errorf("can't add page break to %s: %w", internalFilename, err)
+	}
+	s.xhtml.setBody(body)
+	s.xhtml.setXmlnsEpub(xmlnsEpub)
+	p.id = id
+	s.pageBreaks = append(s.pageBreaks, p)
+	if pageLabel == "" {
+		e.pageNumber++
+	}
+	return nil
+}
+
+// writePageList adds the page breaks of the sections to the TOC, in reading
+// order
+func (e *Epub) writePageList(sections []*epubSection) {
+	for _, section := range sections {
+		body := section.xhtml.body()
+		position := map[string]int{}
+		var pageBreaks []pageBreak
+		for _, p := range section.pageBreaks {
+			// The marker was removed by UpdateSection
+			if i := strings.Index(body, `id="`+p.id+`"`); i >= 0 {
+				position[p.id] = i
+				pageBreaks = append(pageBreaks, p)
+			}
+		}
+		sort.SliceStable(pageBreaks, func(i, j int) bool {
+			return position[pageBreaks[i].id] < position[pageBreaks[j].id]
+		})
+
+		for _, p := range pageBreaks {
+			e.toc.addPage(p.label, p.kind, p.value, filepath.Join(xhtmlFolderName, section.filename)+"#"+p.id)
+		}
+		e.writePageList(section.children)
+	}
+}
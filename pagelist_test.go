@@ -0,0 +1,154 @@
+package epub
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-shiori/go-epub/internal/storage"
+)
+
+func TestAddPageBreak(t *testing.T) {
+	e, err := NewEpub(testEpubTitle)
+	if err != nil {
+		t.Error(err)
+	}
+
+	_, err = e.AddSection(`<p id="first">First</p><p id="second">Second</p>`, "Chapter 1", "chapter1.xhtml", "")
+	if err != nil {
+		t.Errorf("Error adding section: %s", err)
+	}
+	_, err = e.AddSection(`<p>Text</p>`, "Chapter 2", "chapter2.xhtml", "")
+	if err != nil {
+		t.Errorf("Error adding section: %s", err)
+	}
+	for _, p := range []struct {
+		filename string
+		label    string
+		anchor   string
+	}{
+		{"chapter2.xhtml", "3", ""},
+		{"chapter1.xhtml", "2", "second"},
+		{"chapter1.xhtml", "xi", ""},
+	} {
+		if err := e.AddPageBreak(p.filename, p.label, p.anchor); err != nil {
+			t.Errorf("Error adding page break: %s", err)
+		}
+	}
+	if err := e.AddPageBreak("chapter1.xhtml", "4", "doesNotExist"); err == nil {
+		t.Error("Expected an error adding a page break before a non-existent element")
+	}
+	err = e.AddPageBreak("doesNotExist.xhtml", "1", "")
+	if _, ok := err.(*SectionDoesNotExistError); !ok {
+		t.Errorf("Expected error SectionDoesNotExistError not returned. Returned instead: %+v", err)
+	}
+
+	expectedBody := `<span epub:type="pagebreak" role="doc-pagebreak" id="page-xi" aria-label="xi"></span>` +
+		`<p id="first">First</p>` +
+		`<span epub:type="pagebreak" role="doc-pagebreak" id="page-2" aria-label="2"></span>` +
+		`<p id="second">Second</p>`
+	if body := findSection(e.sections, "chapter1.xhtml").xhtml.body(); strings.TrimSpace(body) != expectedBody {
+		t.Errorf("Page break markers not inserted\nGot: %s\nExpected: %s", body, expectedBody)
+	}
+
+	tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+
+	nav, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, tocNavFilename))
+	if err != nil {
+		t.Errorf("Unexpected error reading nav file: %s", err)
+	}
+	expectedPageList := `<nav epub:type="page-list" hidden="hidden"><h1>Pages</h1><ol>` +
+		`<li><a href="xhtml/chapter1.xhtml#page-xi">xi</a></li>` +
+		`<li><a href="xhtml/chapter1.xhtml#page-2">2</a></li>` +
+		`<li><a href="xhtml/chapter2.xhtml#page-3">3</a></li>` +
+		`</ol></nav>`
+	if !strings.Contains(strings.ReplaceAll(trimAllSpace(string(nav)), "\n", ""), expectedPageList) {
+		t.Errorf("Nav file doesn't contain the page list\nGot: %s\nExpected: %s", nav, expectedPageList)
+	}
+
+	ncx, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, tocNcxFilename))
+	if err != nil {
+		t.Errorf("Unexpected error reading NCX file: %s", err)
+	}
+	expectedNcx := `<pageList><navLabel><text>Pages</text></navLabel>` +
+		`<pageTarget id="pageTarget-1" type="front"><navLabel><text>xi</text></navLabel><content src="xhtml/chapter1.xhtml#page-xi"></content></pageTarget>` +
+		`<pageTarget id="pageTarget-2" type="normal" value="2"><navLabel><text>2</text></navLabel><content src="xhtml/chapter1.xhtml#page-2"></content></pageTarget>` +
+		`<pageTarget id="pageTarget-3" type="normal" value="3"><navLabel><text>3</text></navLabel><content src="xhtml/chapter2.xhtml#page-3"></content></pageTarget>` +
+		`</pageList>`
+	if !strings.Contains(strings.ReplaceAll(trimAllSpace(string(ncx)), "\n", ""), expectedNcx) {
+		t.Errorf("NCX file doesn't contain the page list\nGot: %s\nExpected: %s", ncx, expectedNcx)
+	}
+
+	section, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, xhtmlFolderName, "chapter1.xhtml"))
+	if err != nil {
+		t.Errorf("Unexpected error reading section file: %s", err)
+	}
+	if !strings.Contains(string(section), `xmlns:epub="http://www.idpf.org/2007/ops"`) {
+		t.Errorf("Section with page breaks doesn't declare the epub namespace:\n%s", section)
+	}
+
+	cleanup(testEpubFilename, tempDir)
+}
+
+func TestSetPageLabelPolicy(t *testing.T) {
+	e, err := NewEpub(testEpubTitle)
+	if err != nil {
+		t.Error(err)
+	}
+	_, err = e.AddSection(`<p id="p1">One</p><p id="p2">Two</p>`, "Preface", "preface.xhtml", "")
+	if err != nil {
+		t.Errorf("Error adding section: %s", err)
+	}
+	_, err = e.AddSection(`<p id="p1">One</p><p id="p2">Two</p><p id="p3">Three</p>`, "Chapter 1", "chapter1.xhtml", "")
+	if err != nil {
+		t.Errorf("Error adding section: %s", err)
+	}
+
+	e.SetPageLabelPolicy(PageLabelPolicy{Style: PageLabelLowerRoman, Start: 3})
+	for _, anchor := range []string{"p1", "p2"} {
+		if err := e.AddPageBreak("preface.xhtml", "", anchor); err != nil {
+			t.Errorf("Error adding page break: %s", err)
+		}
+	}
+	e.SetPageLabelPolicy(PageLabelPolicy{})
+	if err := e.AddPageBreak("chapter1.xhtml", "", "p1"); err != nil {
+		t.Errorf("Error adding page break: %s", err)
+	}
+	if err := e.AddPageBreak("chapter1.xhtml", "", "doesNotExist"); err == nil {
+		t.Error("Expected an error adding a page break before a non-existent element")
+	}
+	if err := e.AddPageBreak("chapter1.xhtml", "", "p2"); err != nil {
+		t.Errorf("Error adding page break: %s", err)
+	}
+	e.SetPageLabelPolicy(PageLabelPolicy{Format: func(n int) string { return fmt.Sprintf("Plate %d", n) }})
+	if err := e.AddPageBreak("chapter1.xhtml", "", "p3"); err != nil {
+		t.Errorf("Error adding page break: %s", err)
+	}
+
+	tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+	defer cleanup(testEpubFilename, tempDir)
+
+	ncx, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, tocNcxFilename))
+	if err != nil {
+		t.Errorf("Unexpected error reading NCX file: %s", err)
+	}
+	expectedNcx := `<pageList><navLabel><text>Pages</text></navLabel>` +
+		`<pageTarget id="pageTarget-1" type="front" value="3"><navLabel><text>iii</text></navLabel><content src="xhtml/preface.xhtml#page-iii"></content></pageTarget>` +
+		`<pageTarget id="pageTarget-2" type="front" value="4"><navLabel><text>iv</text></navLabel><content src="xhtml/preface.xhtml#page-iv"></content></pageTarget>` +
+		`<pageTarget id="pageTarget-3" type="normal" value="1"><navLabel><text>1</text></navLabel><content src="xhtml/chapter1.xhtml#page-1"></content></pageTarget>` +
+		`<pageTarget id="pageTarget-4" type="normal" value="2"><navLabel><text>2</text></navLabel><content src="xhtml/chapter1.xhtml#page-2"></content></pageTarget>` +
+		`<pageTarget id="pageTarget-5" type="special"><navLabel><text>Plate 1</text></navLabel><content src="xhtml/chapter1.xhtml#page-plate-1"></content></pageTarget>` +
+		`</pageList>`
+	if !strings.Contains(strings.ReplaceAll(trimAllSpace(string(ncx)), "\n", ""), expectedNcx) {
+		t.Errorf("NCX file doesn't contain the page list\nGot: %s\nExpected: %s", ncx, expectedNcx)
+	}
+}
+
+func TestToRoman(t *testing.T) {
+	for n, expected := range map[int]string{1: "I", 4: "IV", 9: "IX", 14: "XIV", 40: "XL", 1994: "MCMXCIV", 0: "0"} {
+		if got := toRoman(n); got != expected {
+			t.Errorf("toRoman(%d): got %s, expected %s", n, got, expected)
+		}
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"encoding/xml"
 	"fmt"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -29,7 +31,21 @@ const (
 </package>
 `
 	pkgModifiedProperty = "dcterms:modified"
+	pkgEditionProperty  = "schema:bookEdition"
+	pkgVersionProperty  = "schema:version"
+	// Reserved from EPUB 3.1 only, so declared in the package
+	pkgSchemaPrefix     = "schema: http://schema.org/"
+	pkgTitleID          = "title"
+	pkgFileAsProperty   = "file-as"
+	pkgCalibreTitleSort = "calibre:title_sort"
 	pkgUniqueIdentifier = "pub-id"
+	// Series the EPUB is part of, e.g. the volumes returned by Split
+	pkgCollectionID           = "collection"
+	pkgCollectionProperty     = "belongs-to-collection"
+	pkgCollectionTypeProperty = "collection-type"
+	pkgGroupPositionProperty  = "group-position"
+	pkgCalibreSeries          = "calibre:series"
+	pkgCalibreSeriesIndex     = "calibre:series_index"
 
 	xmlnsDc = "http://purl.org/dc/elements/1.1/"
 )
@@ -47,12 +63,16 @@ type pkg struct {
 
 // This holds the actual XML for the package file
 type pkgRoot struct {
-	XMLName          xml.Name    `xml:"http://www.idpf.org/2007/opf package"`
-	UniqueIdentifier string      `xml:"unique-identifier,attr"`
-	Version          string      `xml:"version,attr"`
-	Metadata         pkgMetadata `xml:"metadata"`
-	ManifestItems    []pkgItem   `xml:"manifest>item"`
-	Spine            pkgSpine    `xml:"spine"`
+	XMLName          xml.Name `xml:"http://www.idpf.org/2007/opf package"`
+	UniqueIdentifier string   `xml:"unique-identifier,attr"`
+	Version          string   `xml:"version,attr"`
+	// Declaration of the metadata prefixes that aren't reserved in EPUB 3.0
+	Prefix        string      `xml:"prefix,attr,omitempty"`
+	Metadata      pkgMetadata `xml:"metadata"`
+	ManifestItems []pkgItem   `xml:"manifest>item"`
+	Spine         pkgSpine    `xml:"spine"`
+	// EPUB v2 equivalent of the landmarks
+	Guide *pkgGuide `xml:"guide,omitempty"`
 }
 
 // <dc:creator>, e.g. the author
@@ -79,12 +99,25 @@ type pkgItem struct {
 	Href       string `xml:"href,attr"`
 	MediaType  string `xml:"media-type,attr"`
 	Properties string `xml:"properties,attr,omitempty"`
+	// id of the item of the media overlay document of the section
+	MediaOverlay string `xml:"media-overlay,attr,omitempty"`
+	// id of the item used by reading systems that don't support this one
+	Fallback string `xml:"fallback,attr,omitempty"`
 }
 
 // <itemref> elements, which define the reading order
 // Ex: <itemref idref="section0001.xhtml" />
+//
+//	<itemref idref="notes.xhtml" linear="no" />
 type pkgItemref struct {
-	Idref string `xml:"idref,attr"`
+	Idref  string `xml:"idref,attr"`
+	Linear string `xml:"linear,attr,omitempty"`
+}
+
+// <dc:title>, with an id if it is refined
+type pkgTitle struct {
+	ID   string `xml:"id,attr,omitempty"`
+	Data string `xml:",chardata"`
 }
 
 // The <meta> element, which contains modified date, role of the creator (e.g.
@@ -107,7 +140,7 @@ type pkgMetadata struct {
 	XmlnsDc    string        `xml:"xmlns:dc,attr"`
 	Identifier pkgIdentifier `xml:"dc:identifier"`
 	// Ex: <dc:title>Your title here</dc:title>
-	Title string `xml:"dc:title"`
+	Title pkgTitle `xml:"dc:title"`
 	// Ex: <dc:language>en</dc:language>
 	Language    string `xml:"dc:language"`
 	Description string `xml:"dc:description,omitempty"`
@@ -118,10 +151,22 @@ type pkgMetadata struct {
 // The <spine> element
 type pkgSpine struct {
 	Items []pkgItemref `xml:"itemref"`
-	Toc   string       `xml:"toc,attr"`
+	Toc   string       `xml:"toc,attr,omitempty"`
 	Ppd   string       `xml:"page-progression-direction,attr,omitempty"`
 }
 
+// The <guide> element
+type pkgGuide struct {
+	References []pkgReference `xml:"reference"`
+}
+
+// The <reference> element of the guide
+type pkgReference struct {
+	Type  string `xml:"type,attr"`
+	Title string `xml:"title,attr"`
+	Href  string `xml:"href,attr"`
+}
+
 // Constructor for pkg
 func newPackage() (*pkg, error) {
 	p := &pkg{
@@ -143,6 +188,22 @@ func newPackage() (*pkg, error) {
 	return p, nil
 }
 
+// clone returns a copy of the package that can be changed independently
+func (p *pkg) clone() *pkg {
+	root := *p.xml
+	root.Metadata.Meta = append([]pkgMeta(nil), root.Metadata.Meta...)
+	root.ManifestItems = append([]pkgItem(nil), root.ManifestItems...)
+	root.Spine.Items = append([]pkgItemref(nil), root.Spine.Items...)
+	if root.Metadata.Creator != nil {
+		creator := *root.Metadata.Creator
+		root.Metadata.Creator = &creator
+	}
+	root.Guide = nil
+	c := *p
+	c.xml = &root
+	return &c
+}
+
 func (p *pkg) addToManifest(id string, href string, mediaType string, properties string) {
 	href = filepath.ToSlash(href)
 	i := &pkgItem{
@@ -154,14 +215,69 @@ func (p *pkg) addToManifest(id string, href string, mediaType string, properties
 	p.xml.ManifestItems = append(p.xml.ManifestItems, *i)
 }
 
-func (p *pkg) addToSpine(id string) {
+// setMediaOverlay links the item with the given id to the media overlay
+// document with the id overlayID
+func (p *pkg) setMediaOverlay(id string, overlayID string) {
+	for i := range p.xml.ManifestItems {
+		if p.xml.ManifestItems[i].ID == id {
+			p.xml.ManifestItems[i].MediaOverlay = overlayID
+		}
+	}
+}
+
+// setFallback sets the item with the id fallbackID as the fallback of the item
+// with the given id
+func (p *pkg) setFallback(id string, fallbackID string) {
+	for i := range p.xml.ManifestItems {
+		if p.xml.ManifestItems[i].ID == id {
+			p.xml.ManifestItems[i].Fallback = fallbackID
+		}
+	}
+}
+
+// reset removes the items added while writing the EPUB, so that it can be
+// written again
+func (p *pkg) reset() {
+	p.xml.ManifestItems = nil
+	p.xml.Spine.Items = nil
+	p.xml.Guide = nil
+}
+
+func (p *pkg) addToSpine(id string, linear bool) {
 	i := &pkgItemref{
 		Idref: id,
 	}
+	if !linear {
+		i.Linear = "no"
+	}
 
 	p.xml.Spine.Items = append(p.xml.Spine.Items, *i)
 }
 
+// insertIntoSpine adds an item to the spine at index, or at the end if index
+// is out of range
+func (p *pkg) insertIntoSpine(id string, index int, linear bool) {
+	p.addToSpine(id, linear)
+	items := p.xml.Spine.Items
+	if index < 0 || index >= len(items) {
+		return
+	}
+	item := items[len(items)-1]
+	copy(items[index+1:], items[index:len(items)-1])
+	items[index] = item
+}
+
+func (p *pkg) addToGuide(referenceType string, title string, href string) {
+	if p.xml.Guide == nil {
+		p.xml.Guide = &pkgGuide{}
+	}
+	p.xml.Guide.References = append(p.xml.Guide.References, pkgReference{
+		Type:  referenceType,
+		Title: title,
+		Href:  filepath.ToSlash(href),
+	})
+}
+
 func (p *pkg) setAuthor(author string) {
 	p.xml.Metadata.Creator = &pkgCreator{
 		Data: author,
@@ -181,6 +297,9 @@ func (p *pkg) setAuthor(author string) {
 // Add an EPUB 2 cover meta element for backward compatibility (http://idpf.org/forum/topic-715)
 func (p *pkg) setCover(coverRef string) {
 	coverRef, _ = fixXMLId(coverRef)
+	// Replace the previous cover meta, which updateMeta can't match since its
+	// content changes
+	p.unsetCover()
 	p.coverMeta = &pkgMeta{
 		Name:    "cover",
 		Content: coverRef,
@@ -188,6 +307,19 @@ func (p *pkg) setCover(coverRef string) {
 	p.xml.Metadata.Meta = updateMeta(p.xml.Metadata.Meta, p.coverMeta)
 }
 
+func (p *pkg) unsetCover() {
+	if p.coverMeta == nil {
+		return
+	}
+	for i, meta := range p.xml.Metadata.Meta {
+		if meta == *p.coverMeta {
+			p.xml.Metadata.Meta = append(p.xml.Metadata.Meta[:i], p.xml.Metadata.Meta[i+1:]...)
+			break
+		}
+	}
+	p.coverMeta = nil
+}
+
 func (p *pkg) setIdentifier(identifier string) {
 	p.xml.Metadata.Identifier.Data = identifier
 }
@@ -200,6 +332,15 @@ func (p *pkg) setDescription(desc string) {
 	p.xml.Metadata.Description = desc
 }
 
+// setNcx sets whether the spine references the EPUB v2 TOC file
+func (p *pkg) setNcx(enabled bool) {
+	if enabled {
+		p.xml.Spine.Toc = tocNcxItemID
+	} else {
+		p.xml.Spine.Toc = ""
+	}
+}
+
 func (p *pkg) setPpd(direction string) {
 	p.xml.Spine.Ppd = direction
 }
@@ -213,8 +354,157 @@ func (p *pkg) setModified(timestamp string) {
 	p.xml.Metadata.Meta = updateMeta(p.xml.Metadata.Meta, p.modifiedMeta)
 }
 
+// setProperty sets the meta element of the publication with the given
+// property. An empty value removes it.
+func (p *pkg) setProperty(property string, value string) {
+	metas := p.xml.Metadata.Meta[:0]
+	for _, meta := range p.xml.Metadata.Meta {
+		if meta.Property != property || meta.Refines != "" {
+			metas = append(metas, meta)
+		}
+	}
+	p.xml.Metadata.Meta = metas
+	if value != "" {
+		p.xml.Metadata.Meta = append(p.xml.Metadata.Meta, pkgMeta{
+			Property: property,
+			Data:     value,
+		})
+	}
+	p.xml.Prefix = ""
+	for _, meta := range p.xml.Metadata.Meta {
+		if strings.HasPrefix(meta.Property, "schema:") {
+			p.xml.Prefix = pkgSchemaPrefix
+		}
+	}
+}
+
+// setDuration sets the media:duration meta element of the element with the id
+// refines, or of the whole publication if it is empty. An empty duration
+// removes it.
+func (p *pkg) setDuration(refines string, duration string) {
+	if refines != "" && !strings.HasPrefix(refines, "#") {
+		refines = "#" + refines
+	}
+	metas := p.xml.Metadata.Meta[:0]
+	for _, meta := range p.xml.Metadata.Meta {
+		if meta.Property != pkgDurationProperty || meta.Refines != refines {
+			metas = append(metas, meta)
+		}
+	}
+	p.xml.Metadata.Meta = metas
+	if duration != "" {
+		p.xml.Metadata.Meta = append(p.xml.Metadata.Meta, pkgMeta{
+			Refines:  refines,
+			Property: pkgDurationProperty,
+			Data:     duration,
+		})
+	}
+}
+
+// setRefinement adds a meta element with the given property refining the
+// element with the id refines. An empty value adds nothing.
+func (p *pkg) setRefinement(refines string, property string, value string) {
+	if value == "" {
+		return
+	}
+	p.xml.Metadata.Meta = append(p.xml.Metadata.Meta, pkgMeta{
+		Refines:  "#" + refines,
+		Property: property,
+		Data:     value,
+	})
+}
+
+// unsetRefinements removes the meta elements with the given properties
+// refining other elements
+func (p *pkg) unsetRefinements(properties ...string) {
+	metas := p.xml.Metadata.Meta[:0]
+	for _, meta := range p.xml.Metadata.Meta {
+		keep := true
+		for _, property := range properties {
+			if meta.Property == property && meta.Refines != "" {
+				keep = false
+			}
+		}
+		if keep {
+			metas = append(metas, meta)
+		}
+	}
+	p.xml.Metadata.Meta = metas
+}
+
+// unsetRefinedDurations removes the media:duration meta elements refining
+// other elements, e.g. the media overlays of the last build
+func (p *pkg) unsetRefinedDurations() {
+	p.unsetRefinements(pkgDurationProperty)
+}
+
 func (p *pkg) setTitle(title string) {
-	p.xml.Metadata.Title = title
+	p.xml.Metadata.Title.Data = title
+}
+
+// setTitleFileAs sets the form of the title used for sorting, both as a
+// file-as refinement of the title and as the calibre:title_sort meta element
+// used by Calibre. An empty string removes them.
+func (p *pkg) setTitleFileAs(fileAs string) {
+	metas := p.xml.Metadata.Meta[:0]
+	for _, meta := range p.xml.Metadata.Meta {
+		if (meta.Property != pkgFileAsProperty || meta.Refines != "#"+pkgTitleID) && meta.Name != pkgCalibreTitleSort {
+			metas = append(metas, meta)
+		}
+	}
+	p.xml.Metadata.Meta = metas
+	if fileAs == "" {
+		p.xml.Metadata.Title.ID = ""
+		return
+	}
+	p.xml.Metadata.Title.ID = pkgTitleID
+	p.xml.Metadata.Meta = append(p.xml.Metadata.Meta,
+		pkgMeta{
+			Refines:  "#" + pkgTitleID,
+			Property: pkgFileAsProperty,
+			Data:     fileAs,
+		},
+		pkgMeta{
+			Name:    pkgCalibreTitleSort,
+			Content: fileAs,
+		})
+}
+
+// setCollection sets the series the EPUB is part of and its position in the
+// series, both as a belongs-to-collection meta element and as the
+// calibre:series meta elements used by Calibre
+func (p *pkg) setCollection(name string, position int) {
+	metas := p.xml.Metadata.Meta[:0]
+	for _, meta := range p.xml.Metadata.Meta {
+		if meta.Property != pkgCollectionProperty && meta.Refines != "#"+pkgCollectionID &&
+			meta.Name != pkgCalibreSeries && meta.Name != pkgCalibreSeriesIndex {
+			metas = append(metas, meta)
+		}
+	}
+	p.xml.Metadata.Meta = append(metas,
+		pkgMeta{
+			ID:       pkgCollectionID,
+			Property: pkgCollectionProperty,
+			Data:     name,
+		},
+		pkgMeta{
+			Refines:  "#" + pkgCollectionID,
+			Property: pkgCollectionTypeProperty,
+			Data:     "series",
+		},
+		pkgMeta{
+			Refines:  "#" + pkgCollectionID,
+			Property: pkgGroupPositionProperty,
+			Data:     strconv.Itoa(position),
+		},
+		pkgMeta{
+			Name:    pkgCalibreSeries,
+			Content: name,
+		},
+		pkgMeta{
+			Name:    pkgCalibreSeriesIndex,
+			Content: strconv.Itoa(position),
+		})
 }
 
 // Update the <meta> element
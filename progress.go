@@ -0,0 +1,72 @@
+package epub
+
+import "path"
+
+// Stages of the Progress of Write
+const (
+	// The resources are retrieved and processed
+	ProgressStageFetch = "fetch"
+	// The files are added to the archive
+	ProgressStageZip = "zip"
+)
+
+// Progress is the state of a Write, passed to the handler set with
+// SetProgressHandler.
+type Progress struct {
+	// ProgressStageFetch or ProgressStageZip
+	Stage string
+	// Path inside the EPUB of the file just processed, e.g.
+	// EPUB/images/image0001.png
+	File string
+	// Number of resources retrieved so far, and total number of resources to
+	// retrieve, generated stylesheets included
+	Resources      int
+	TotalResources int
+	// Number of bytes of the EPUB written so far
+	BytesWritten int64
+}
+
+// SetProgressHandler sets a function called during Write and WriteTo each time
+// a resource is retrieved and each time a file is added to the archive, e.g.
+// to show a progress bar for large books. The handler is called with the Epub
+// locked, from the goroutine writing it, and must not call its methods. A nil
+// handler removes it.
+func (e *Epub) SetProgressHandler(handler func(Progress)) {
+	e.Lock()
+	defer e.Unlock()
+	e.progressHandler = handler
+}
+
+// startProgress resets the progress for a new Write
+func (e *Epub) startProgress() {
+	e.progress = Progress{}
+	if e.progressHandler == nil {
+		return
+	}
+	total := len(e.css) + len(e.fontFallbackStylesheets()) + len(e.fonts) + len(e.images) + len(e.videos) + len(e.audios)
+	if filename, _ := e.cssResetStylesheet(); filename != "" {
+		total++
+	}
+	e.progress.TotalResources = total
+}
+
+// resourceDone reports a resource of the folder mediaFolderName retrieved
+func (e *Epub) resourceDone(mediaFolderName string, mediaFilename string) {
+	e.progress.Resources++
+	e.reportProgress(ProgressStageFetch, path.Join(contentFolderName, mediaFolderName, mediaFilename))
+}
+
+// fileZipped reports a file added to the archive
+func (e *Epub) fileZipped(relativePath string, bytesWritten int64) {
+	e.progress.BytesWritten = bytesWritten
+	e.reportProgress(ProgressStageZip, relativePath)
+}
+
+func (e *Epub) reportProgress(stage string, file string) {
+	if e.progressHandler == nil {
+		return
+	}
+	e.progress.Stage = stage
+	e.progress.File = file
+	e.progressHandler(e.progress)
+}
@@ -0,0 +1,75 @@
+package epub
+
+import (
+	"io"
+	"testing"
+)
+
+func TestSetProgressHandler(t *testing.T) {
+	e, err := NewEpub(testEpubTitle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.AddCSS(testCoverCSSSource, ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.AddImage(testImageFromFileSource, ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.AddFont(testFontFromFileSource, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.SetCSSReset(CSSResetMinimal); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.AddSection(testSectionBody, "Section", "", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	var progress []Progress
+	e.SetProgressHandler(func(p Progress) {
+		progress = append(progress, p)
+	})
+	n, err := e.WriteTo(io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var fetched []string
+	zipped := map[string]bool{}
+	var lastBytes int64
+	for _, p := range progress {
+		if p.TotalResources != 4 {
+			t.Errorf("Expected 4 resources in total, got %d", p.TotalResources)
+		}
+		switch p.Stage {
+		case ProgressStageFetch:
+			fetched = append(fetched, p.File)
+			if p.Resources != len(fetched) {
+				t.Errorf("Expected %d resources retrieved, got %d", len(fetched), p.Resources)
+			}
+			if len(zipped) > 0 {
+				t.Errorf("Resource %s retrieved after the archive was started", p.File)
+			}
+		case ProgressStageZip:
+			zipped[p.File] = true
+			if p.BytesWritten < lastBytes || p.BytesWritten > n {
+				t.Errorf("Unexpected bytes written %d after %d, total %d", p.BytesWritten, lastBytes, n)
+			}
+			lastBytes = p.BytesWritten
+		default:
+			t.Errorf("Unexpected stage %q", p.Stage)
+		}
+	}
+	if len(fetched) != 4 {
+		t.Errorf("Expected 4 resources retrieved, got %v", fetched)
+	}
+	for _, file := range []string{mimetypeFilename, "EPUB/package.opf", "EPUB/images/gophercolor16x16.png", "EPUB/xhtml/section0001.xhtml"} {
+		if !zipped[file] {
+			t.Errorf("No progress reported for %s", file)
+		}
+	}
+	if len(zipped) != len(e.BuildReport().Files) {
+		t.Errorf("Expected progress for the %d files of the report, got %d", len(e.BuildReport().Files), len(zipped))
+	}
+}
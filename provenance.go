@@ -0,0 +1,159 @@
+package epub
+
+import (
+	"fmt"
+	"path/filepath"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+const (
+	provenanceFilename = "provenance.xhtml"
+	provenanceTitle    = "Build information"
+	modulePath         = "github.com/go-shiori/go-epub"
+)
+
+// ProvenanceOptions configures the build provenance page added by
+// SetProvenancePage. Empty fields take their default value.
+type ProvenanceOptions struct {
+	// Heading of the page. Defaults to "Build information".
+	Title string
+	// Tool generating the EPUB. Defaults to go-epub and its version.
+	ToolVersion string
+	// Revision of the sources of the EPUB. Defaults to the VCS revision the
+	// running program was built from, if known.
+	SourceCommit string
+	// Defaults to the time the EPUB is written.
+	BuildTime time.Time
+	// Additional fields, listed after the others
+	Fields []ProvenanceField
+}
+
+// ProvenanceField is a field of the build provenance page.
+type ProvenanceField struct {
+	Name  string
+	Value string
+}
+
+// SetProvenancePage adds a page at the end of the EPUB listing the title,
+// author and identifier of the EPUB along with how it was built: the tool
+// version, the build time and the source commit. It is meant for generated
+// documentation, where the origin of an artifact must be traceable.
+//
+// The page isn't listed in the table of contents. A nil options removes the
+// page.
+func (e *Epub) SetProvenancePage(options *ProvenanceOptions) {
+	e.Lock()
+	defer e.Unlock()
+	if options == nil {
+		e.provenance = nil
+		return
+	}
+	o := *options
+	o.Fields = append([]ProvenanceField(nil), options.Fields...)
+	e.provenance = &o
+}
+
+// provenanceFields returns the fields of the provenance page, with the
+// default values filled in
+func (e *Epub) provenanceFields() []ProvenanceField {
+	o := *e.provenance
+	if o.ToolVersion == "" || o.SourceCommit == "" {
+		toolVersion, sourceCommit := buildInfo()
+		if o.ToolVersion == "" {
+			o.ToolVersion = toolVersion
+		}
+		if o.SourceCommit == "" {
+			o.SourceCommit = sourceCommit
+		}
+	}
+	if o.BuildTime.IsZero() {
+		o.BuildTime = time.Now()
+	}
+
+	fields := []ProvenanceField{
+		{"Title", e.title},
+		{"Author", e.author},
+		{"Identifier", e.identifier},
+		{"Generated by", o.ToolVersion},
+		{"Build time", o.BuildTime.UTC().Format(time.RFC3339)},
+		{"Source commit", o.SourceCommit},
+	}
+	fields = append(fields, o.Fields...)
+
+	// Leave out the fields without value
+	filled := fields[:0]
+	for _, f := range fields {
+		if f.Value != "" {
+			filled = append(filled, f)
+		}
+	}
+	return filled
+}
+
+// buildInfo returns the version of go-epub and the VCS revision of the running
+// program, as recorded by the Go toolchain
+func buildInfo() (toolVersion string, sourceCommit string) {
+	toolVersion = "go-epub"
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return toolVersion, ""
+	}
+	modules := append([]*debug.Module{&bi.Main}, bi.Deps...)
+	for _, m := range modules {
+		if m.Path == modulePath && m.Version != "" && m.Version != "(devel)" {
+			toolVersion += " " + m.Version
+			break
+		}
+	}
+	for _, s := range bi.Settings {
+		if s.Key == "vcs.revision" {
+			sourceCommit = s.Value
+		}
+	}
+	return toolVersion, sourceCommit
+}
+
+// writeProvenancePage writes the provenance page at the end of the EPUB
+func (e *Epub) writeProvenancePage(rootEpubDir string) error {
+	if e.provenance == nil {
+		return nil
+	}
+
+	filename := provenanceFilename
+	for i := 2; findSection(e.sections, filename) != nil; i++ {
+		filename = fmt.Sprintf("provenance-%d.xhtml", i)
+	}
+
+	title := e.provenance.Title
+	if title == "" {
+		title = provenanceTitle
+	}
+	var b strings.Builder
+	b.WriteString(`<section epub:type="colophon"><h1>` + html.EscapeString(title) + `</h1><dl>`)
+	for _, f := range e.provenanceFields() {
+		b.WriteString(`<dt>` + html.EscapeString(f.Name) + `</dt><dd>` + html.EscapeString(f.Value) + `</dd>`)
+	}
+	b.WriteString(`</dl></section>`)
+
+	x, err := newXhtml(b.String())
+	if err != nil {
+		return fmt.Errorf("can't create provenance page: %w", err)
+	}
+	x.setTitle(title)
+	x.setXmlnsEpub(xmlnsEpub)
+	x.prependCSS(e.linkedCSS(b.String()))
+	if dir := e.direction(); dir != "" {
+		x.setDir(dir)
+	}
+	if err := x.write(filepath.Join(rootEpubDir, contentFolderName, xhtmlFolderName, filename)); err != nil {
+		return err
+	}
+
+	e.pkg.addToSpine(filename, true)
+	e.pkg.addToManifest(filename, filepath.Join(xhtmlFolderName, filename), mediaTypeXhtml, "")
+	return nil
+}
@@ -0,0 +1,104 @@
+package epub
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-shiori/go-epub/internal/storage"
+)
+
+func TestSetProvenancePage(t *testing.T) {
+	e, err := NewEpub(testEpubTitle)
+	if err != nil {
+		t.Error(err)
+	}
+	e.SetAuthor(testEpubAuthor)
+	_, err = e.AddSection(testSectionBody, testSectionTitle, provenanceFilename, "")
+	if err != nil {
+		t.Errorf("Error adding section: %s", err)
+	}
+	e.SetProvenancePage(&ProvenanceOptions{
+		ToolVersion:  "docs-builder 1.0",
+		SourceCommit: "0123abc",
+		BuildTime:    time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC),
+		Fields:       []ProvenanceField{{"Pipeline", "<nightly>"}},
+	})
+
+	tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+
+	// The filename of the page doesn't clash with the one of the section
+	page, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, xhtmlFolderName, "provenance-2.xhtml"))
+	if err != nil {
+		t.Fatalf("Unexpected error reading provenance page: %s", err)
+	}
+	expected := `<section epub:type="colophon"><h1>Build information</h1><dl>` +
+		`<dt>Title</dt><dd>` + testEpubTitle + `</dd>` +
+		`<dt>Author</dt><dd>` + testEpubAuthor + `</dd>` +
+		`<dt>Identifier</dt><dd>` + e.Identifier() + `</dd>` +
+		`<dt>Generated by</dt><dd>docs-builder 1.0</dd>` +
+		`<dt>Build time</dt><dd>2024-05-01T10:00:00Z</dd>` +
+		`<dt>Source commit</dt><dd>0123abc</dd>` +
+		`<dt>Pipeline</dt><dd>&lt;nightly&gt;</dd>` +
+		`</dl></section>`
+	if !strings.Contains(string(page), expected) {
+		t.Errorf("Provenance page contents don't match\nGot: %s\nExpected: %s", page, expected)
+	}
+
+	pkg, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, pkgFilename))
+	if err != nil {
+		t.Errorf("Unexpected error reading package file: %s", err)
+	}
+	if !strings.Contains(string(pkg), `<itemref idref="provenance-2.xhtml"></itemref>`) {
+		t.Errorf("Provenance page not found in spine:\n%s", pkg)
+	}
+	nav, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, tocNavFilename))
+	if err != nil {
+		t.Errorf("Unexpected error reading nav file: %s", err)
+	}
+	if strings.Contains(string(nav), "provenance-2.xhtml") {
+		t.Errorf("Provenance page shouldn't be in the TOC:\n%s", nav)
+	}
+
+	cleanup(testEpubFilename, tempDir)
+}
+
+func TestSetProvenancePageCopy(t *testing.T) {
+	e, err := NewEpub(testEpubTitle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	options := &ProvenanceOptions{
+		ToolVersion: "docs-builder 1.0",
+		Fields:      []ProvenanceField{{"Pipeline", "nightly"}},
+	}
+	e.SetProvenancePage(options)
+	options.ToolVersion = "changed"
+	options.Fields[0].Value = "changed"
+	if e.provenance.ToolVersion != "docs-builder 1.0" || e.provenance.Fields[0].Value != "nightly" {
+		t.Errorf("Options changed after being set: %+v", e.provenance)
+	}
+}
+
+func TestProvenanceDefaults(t *testing.T) {
+	e, err := NewEpub(testEpubTitle)
+	if err != nil {
+		t.Error(err)
+	}
+	e.SetProvenancePage(&ProvenanceOptions{})
+
+	fields := map[string]string{}
+	for _, f := range e.provenanceFields() {
+		fields[f.Name] = f.Value
+	}
+	if !strings.HasPrefix(fields["Generated by"], "go-epub") {
+		t.Errorf("Expected go-epub as default tool, got %q", fields["Generated by"])
+	}
+	if _, err := time.Parse(time.RFC3339, fields["Build time"]); err != nil {
+		t.Errorf("Expected the current time as default build time, got %q", fields["Build time"])
+	}
+	if _, ok := fields["Author"]; ok {
+		t.Errorf("Fields without value should be left out, got %v", fields)
+	}
+}
@@ -0,0 +1,257 @@
+package epub
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// epubReader reads the files of an existing EPUB
+type epubReader struct {
+	zip *zip.Reader
+	// Path of the package file inside the EPUB
+	pkgPath string
+	pkg     *readPkg
+}
+
+// The parts of the container file needed to find the package file
+type readContainer struct {
+	Rootfiles []struct {
+		FullPath string `xml:"full-path,attr"`
+	} `xml:"rootfiles>rootfile"`
+}
+
+// The parts of the package file needed to read an EPUB
+type readPkg struct {
+	UniqueIdentifier string `xml:"unique-identifier,attr"`
+	Metadata         struct {
+		Titles      []string `xml:"title"`
+		Creators    []string `xml:"creator"`
+		Identifiers []struct {
+			ID   string `xml:"id,attr"`
+			Data string `xml:",chardata"`
+		} `xml:"identifier"`
+		Languages    []string `xml:"language"`
+		Descriptions []string `xml:"description"`
+		Meta         []struct {
+			Name    string `xml:"name,attr"`
+			Content string `xml:"content,attr"`
+		} `xml:"meta"`
+	} `xml:"metadata"`
+	ManifestItems []pkgItem `xml:"manifest>item"`
+	Spine         struct {
+		Toc   string       `xml:"toc,attr"`
+		Ppd   string       `xml:"page-progression-direction,attr"`
+		Items []pkgItemref `xml:"itemref"`
+	} `xml:"spine"`
+}
+
+// ImportTOC replaces the table of contents generated from the sections with
+// the one of the EPUB file at epubPath, to ease migrations from other
+// toolchains. The navigation document of the EPUB is used if it has one, its
+// NCX otherwise.
+//
+// Entries are mapped to the sections of this EPUB by filename: an entry
+// pointing to "Text/chapter1.xhtml#part2" points to "#part2" in the section
+// with the internal filename "chapter1.xhtml". Entries without a matching
+// section when the EPUB is written are left out, their children taking their
+// place. Like the one built with TOC, the imported table of contents is used
+// even if it's empty, until TOC().Reset is called.
+func (e *Epub) ImportTOC(epubPath string) error {
+	f, err := os.Open(epubPath)
+	if err != nil {
+		return fmt.Errorf("can't open EPUB: %w", err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("can't open EPUB: %w", err)
+	}
+
+	er, err := newEpubReader(f, info.Size())
+	if err != nil {
+		return err
+	}
+	entries, err := er.toc()
+	if err != nil {
+		return err
+	}
+
+	e.Lock()
+	defer e.Unlock()
+	// An empty, non-nil TOC still replaces the generated one
+	e.customTOC = append([]*TOCEntry{}, entries...)
+	return nil
+}
+
+// newEpubReader reads the container and package files of the EPUB held by r
+func newEpubReader(r io.ReaderAt, size int64) (*epubReader, error) {
+	z, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, fmt.Errorf("can't read EPUB: %w", err)
+	}
+	er := &epubReader{zip: z}
+
+	var c readContainer
+	if err := er.readXML(path.Join(metaInfFolderName, containerFilename), &c); err != nil {
+		return nil, err
+	}
+	if len(c.Rootfiles) == 0 || c.Rootfiles[0].FullPath == "" {
+		return nil, fmt.Errorf("can't read EPUB: no package file in %s", containerFilename)
+	}
+	er.pkgPath = c.Rootfiles[0].FullPath
+
+	er.pkg = &readPkg{}
+	if err := er.readXML(er.pkgPath, er.pkg); err != nil {
+		return nil, err
+	}
+	return er, nil
+}
+
+// readFile returns the content of the file at name, relative to the root of
+// the EPUB
+func (er *epubReader) readFile(name string) ([]byte, error) {
+	f, err := er.zip.Open(name)
+	if err != nil {
+		return nil, fmt.Errorf("can't read %s from EPUB: %w", name, err)
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
+
+func (er *epubReader) readXML(name string, v interface{}) error {
+	data, err := er.readFile(name)
+	if err != nil {
+		return err
+	}
+	if err := xml.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("can't parse %s from EPUB: %w", name, err)
+	}
+	return nil
+}
+
+// itemPath returns the path relative to the root of the EPUB of a manifest
+// item
+func (er *epubReader) itemPath(item pkgItem) string {
+	href, _ := url.PathUnescape(item.Href)
+	return path.Join(path.Dir(er.pkgPath), href)
+}
+
+// toc returns the table of contents of the EPUB, from its navigation document
+// if it has one, or from its NCX otherwise. The filename of the entries is
+// the base name of the file they point to.
+func (er *epubReader) toc() ([]*TOCEntry, error) {
+	for _, item := range er.pkg.ManifestItems {
+		if hasProperty(item.Properties, tocNavItemProperties) {
+			return er.navTOC(er.itemPath(item))
+		}
+	}
+	for _, item := range er.pkg.ManifestItems {
+		if item.ID == er.pkg.Spine.Toc || item.MediaType == mediaTypeNcx {
+			return er.ncxTOC(er.itemPath(item))
+		}
+	}
+	return nil, fmt.Errorf("can't read TOC: EPUB has neither a navigation document nor an NCX")
+}
+
+func (er *epubReader) navTOC(navPath string) ([]*TOCEntry, error) {
+	data, err := er.readFile(navPath)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := html.Parse(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("can't parse %s from EPUB: %w", navPath, err)
+	}
+	nav := findElement(doc, func(n *html.Node) bool {
+		return n.DataAtom == atom.Nav && hasProperty(getAttr(n, "epub:type"), tocNavEpubType)
+	})
+	if nav == nil {
+		return nil, fmt.Errorf("can't read TOC: no toc nav in %s", navPath)
+	}
+	ol := findElement(nav, func(n *html.Node) bool {
+		return n.DataAtom == atom.Ol
+	})
+	if ol == nil {
+		return nil, nil
+	}
+	return navListEntries(ol), nil
+}
+
+// navListEntries converts the <li> elements of a navigation document <ol>
+func navListEntries(ol *html.Node) []*TOCEntry {
+	var entries []*TOCEntry
+	for li := ol.FirstChild; li != nil; li = li.NextSibling {
+		if li.DataAtom != atom.Li {
+			continue
+		}
+		entry := &TOCEntry{}
+		for c := li.FirstChild; c != nil; c = c.NextSibling {
+			switch c.DataAtom {
+			case atom.A:
+				entry.Title = textContent(c)
+				entry.Filename, entry.Fragment = splitHref(getAttr(c, "href"))
+			case atom.Span:
+				entry.Title = textContent(c)
+			case atom.Ol:
+				entry.children = navListEntries(c)
+			}
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func (er *epubReader) ncxTOC(ncxPath string) ([]*TOCEntry, error) {
+	n := &tocNcxRoot{}
+	if err := er.readXML(ncxPath, n); err != nil {
+		return nil, err
+	}
+	var convert func(points []*tocNcxNavPoint) []*TOCEntry
+	convert = func(points []*tocNcxNavPoint) []*TOCEntry {
+		var entries []*TOCEntry
+		for _, point := range points {
+			entry := &TOCEntry{
+				Title:    strings.Join(strings.Fields(point.Text), " "),
+				children: convert(point.Children),
+			}
+			entry.Filename, entry.Fragment = splitHref(point.Content.Src)
+			entries = append(entries, entry)
+		}
+		return entries
+	}
+	return convert(n.NavMap), nil
+}
+
+// splitHref returns the base name of the file an href points to and its
+// fragment identifier
+func splitHref(href string) (filename string, fragment string) {
+	href, fragment, _ = strings.Cut(href, "#")
+	if href == "" {
+		return "", fragment
+	}
+	if unescaped, err := url.PathUnescape(href); err == nil {
+		href = unescaped
+	}
+	return path.Base(href), fragment
+}
+
+// hasProperty returns true if the space separated list of properties contains
+// property
+func hasProperty(properties string, property string) bool {
+	for _, p := range strings.Fields(properties) {
+		if p == property {
+			return true
+		}
+	}
+	return false
+}
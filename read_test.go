@@ -0,0 +1,164 @@
+package epub
+
+import (
+	"archive/zip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-shiori/go-epub/internal/storage"
+)
+
+const testNcxOnlyContainer = `<?xml version="1.0" encoding="UTF-8"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+  <rootfiles>
+    <rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/>
+  </rootfiles>
+</container>`
+
+const testNcxOnlyPackage = `<?xml version="1.0" encoding="UTF-8"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="2.0">
+  <manifest>
+    <item id="toc" href="toc.ncx" media-type="application/x-dtbncx+xml"/>
+    <item id="one" href="Text/one.xhtml" media-type="application/xhtml+xml"/>
+  </manifest>
+  <spine toc="toc">
+    <itemref idref="one"/>
+  </spine>
+</package>`
+
+const testNcxOnlyNcx = `<?xml version="1.0" encoding="UTF-8"?>
+<ncx xmlns="http://www.daisy.org/z3986/2005/ncx/" version="2005-1">
+  <navMap>
+    <navPoint id="p1">
+      <navLabel><text>
+        Part One
+      </text></navLabel>
+      <content src="Text/one.xhtml"/>
+      <navPoint id="p2">
+        <navLabel><text>Section</text></navLabel>
+        <content src="Text/one%20two.xhtml#s1"/>
+      </navPoint>
+    </navPoint>
+  </navMap>
+</ncx>`
+
+func TestImportTOC(t *testing.T) {
+	source, err := NewEpub(testEpubTitle)
+	if err != nil {
+		t.Error(err)
+	}
+	one, err := source.AddSection(testSectionBody, "Chapter 1", "one.xhtml", "")
+	if err != nil {
+		t.Errorf("Error adding section: %s", err)
+	}
+	_, err = source.AddSubSection(one, testSectionBody, "Chapter 1.1", "missing.xhtml", "")
+	if err != nil {
+		t.Errorf("Error adding subsection: %s", err)
+	}
+	_, err = source.AddSubSection("missing.xhtml", testSectionBody, "Chapter 1.1.1", "two.xhtml", "")
+	if err != nil {
+		t.Errorf("Error adding subsection: %s", err)
+	}
+	sourcePath := filepath.Join(t.TempDir(), "source.epub")
+	if err := source.Write(sourcePath); err != nil {
+		t.Fatal(err)
+	}
+
+	e, err := NewEpub(testEpubTitle)
+	if err != nil {
+		t.Error(err)
+	}
+	for _, filename := range []string{"two.xhtml", "one.xhtml"} {
+		_, err = e.AddSection(testSectionBody, "Generated "+filename, filename, "")
+		if err != nil {
+			t.Errorf("Error adding section: %s", err)
+		}
+	}
+	if err := e.ImportTOC(sourcePath); err != nil {
+		t.Fatalf("Error importing TOC: %s", err)
+	}
+
+	tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+	defer cleanup(testEpubFilename, tempDir)
+
+	nav, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, tocNavFilename))
+	if err != nil {
+		t.Fatalf("Unexpected error reading nav file: %s", err)
+	}
+	expectedNav := `<ol><li><ahref="xhtml/one.xhtml">Chapter1</a><ol><li><ahref="xhtml/two.xhtml">Chapter1.1.1</a></li></ol></li></ol>`
+	if !strings.Contains(strings.Join(strings.Fields(string(nav)), ""), expectedNav) {
+		t.Errorf("Nav file doesn't contain the imported TOC\nGot: %s\nExpected: %s", nav, expectedNav)
+	}
+	if strings.Contains(string(nav), "Generated") {
+		t.Errorf("Nav file shouldn't contain the generated TOC: %s", nav)
+	}
+
+	ncx, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, tocNcxFilename))
+	if err != nil {
+		t.Fatalf("Unexpected error reading NCX file: %s", err)
+	}
+	expectedNcx := `<navPointid="navPoint-1"><navLabel><text>Chapter1</text></navLabel><contentsrc="xhtml/one.xhtml"></content><navPointid="navPoint-2"><navLabel><text>Chapter1.1.1</text></navLabel><contentsrc="xhtml/two.xhtml"></content></navPoint></navPoint>`
+	if !strings.Contains(strings.Join(strings.Fields(string(ncx)), ""), expectedNcx) {
+		t.Errorf("NCX file doesn't contain the imported TOC\nGot: %s\nExpected: %s", ncx, expectedNcx)
+	}
+}
+
+func TestImportTOCFromNcx(t *testing.T) {
+	sourcePath := filepath.Join(t.TempDir(), "source.epub")
+	f, err := os.Create(sourcePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	z := zip.NewWriter(f)
+	for name, content := range map[string]string{
+		"META-INF/container.xml": testNcxOnlyContainer,
+		"OEBPS/content.opf":      testNcxOnlyPackage,
+		"OEBPS/toc.ncx":          testNcxOnlyNcx,
+	} {
+		w, err := z.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := z.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	e, err := NewEpub(testEpubTitle)
+	if err != nil {
+		t.Error(err)
+	}
+	if err := e.ImportTOC(sourcePath); err != nil {
+		t.Fatalf("Error importing TOC: %s", err)
+	}
+
+	if len(e.customTOC) != 1 || len(e.customTOC[0].children) != 1 {
+		t.Fatalf("Unexpected imported TOC structure: %+v", e.customTOC)
+	}
+	part, section := e.customTOC[0], e.customTOC[0].children[0]
+	if part.Title != "Part One" || part.Filename != "one.xhtml" || part.Fragment != "" {
+		t.Errorf("Unexpected entry: %+v", part)
+	}
+	if section.Title != "Section" || section.Filename != "one two.xhtml" || section.Fragment != "s1" {
+		t.Errorf("Unexpected entry: %+v", section)
+	}
+}
+
+func TestImportTOCError(t *testing.T) {
+	e, err := NewEpub(testEpubTitle)
+	if err != nil {
+		t.Error(err)
+	}
+	if err := e.ImportTOC("doesNotExist.epub"); err == nil {
+		t.Error("Expected an error importing the TOC of a non-existent file")
+	}
+	if e.customTOC != nil {
+		t.Error("A failed import shouldn't replace the TOC")
+	}
+}
@@ -0,0 +1,130 @@
+package epub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+// Prefix of the sources of the resources added from a reader
+const readerSourcePrefix = "reader:"
+
+// readerSource is the content of a resource added from a reader
+type readerSource struct {
+	r io.Reader
+	// Whether the reader was already read by a previous Write
+	read bool
+}
+
+// errReaderAlreadyRead is returned when a resource added from a reader is
+// written again
+var errReaderAlreadyRead = errors.New("reader already read by a previous Write")
+
+// AddImageFromReader adds an image with the content read from r, e.g. a file
+// in object storage or a pipe, to the EPUB, as AddImage does, without loading
+// it in memory or writing it to a temporary file first.
+//
+// The reader is only read when the EPUB is written, and only once: a later
+// Write fails unless SetIncrementalBuild keeps the content of the image. If r
+// is an io.Closer, it is closed once read. The internal filename is required,
+// since there is no source to take it from.
+//
+// The media type is detected from the content, as for the other sources.
+// SetCover can't check the requirements of an image added from a reader.
+func (e *Epub) AddImageFromReader(r io.Reader, imageFilename string) (string, error) {
+	return e.AddImageFromReaderContext(context.Background(), r, imageFilename)
+}
+
+// AddImageFromReaderContext is like AddImageFromReader, stopping when ctx is done.
+func (e *Epub) AddImageFromReaderContext(ctx context.Context, r io.Reader, imageFilename string) (string, error) {
+	return e.addFromReader(ctx, r, imageFilename, imageFileFormat, ImageFolderName, e.images)
+}
+
+// AddCSSFromReader adds a CSS file with the content read from r to the EPUB,
+// as AddCSS does, see AddImageFromReader. The internal filename must have the
+// .css extension, and WriteSection can't embed the CSS file.
+func (e *Epub) AddCSSFromReader(r io.Reader, internalFilename string) (string, error) {
+	return e.AddCSSFromReaderContext(context.Background(), r, internalFilename)
+}
+
+// AddCSSFromReaderContext is like AddCSSFromReader, stopping when ctx is done.
+func (e *Epub) AddCSSFromReaderContext(ctx context.Context, r io.Reader, internalFilename string) (string, error) {
+	return e.addFromReader(ctx, r, internalFilename, cssFileFormat, CSSFolderName, e.css)
+}
+
+// AddFontFromReader adds a font file with the content read from r to the
+// EPUB, as AddFont does, see AddImageFromReader.
+func (e *Epub) AddFontFromReader(r io.Reader, internalFilename string) (string, error) {
+	return e.AddFontFromReaderContext(context.Background(), r, internalFilename)
+}
+
+// AddFontFromReaderContext is like AddFontFromReader, stopping when ctx is done.
+func (e *Epub) AddFontFromReaderContext(ctx context.Context, r io.Reader, internalFilename string) (string, error) {
+	return e.addFromReader(ctx, r, internalFilename, fontFileFormat, FontFolderName, e.fonts)
+}
+
+// AddVideoFromReader adds a video with the content read from r to the EPUB,
+// as AddVideo does, see AddImageFromReader.
+func (e *Epub) AddVideoFromReader(r io.Reader, videoFilename string) (string, error) {
+	return e.AddVideoFromReaderContext(context.Background(), r, videoFilename)
+}
+
+// AddVideoFromReaderContext is like AddVideoFromReader, stopping when ctx is done.
+func (e *Epub) AddVideoFromReaderContext(ctx context.Context, r io.Reader, videoFilename string) (string, error) {
+	return e.addFromReader(ctx, r, videoFilename, videoFileFormat, VideoFolderName, e.videos)
+}
+
+// AddAudioFromReader adds an audio with the content read from r to the EPUB,
+// as AddAudio does, see AddImageFromReader.
+func (e *Epub) AddAudioFromReader(r io.Reader, audioFilename string) (string, error) {
+	return e.AddAudioFromReaderContext(context.Background(), r, audioFilename)
+}
+
+// AddAudioFromReaderContext is like AddAudioFromReader, stopping when ctx is done.
+func (e *Epub) AddAudioFromReaderContext(ctx context.Context, r io.Reader, audioFilename string) (string, error) {
+	return e.addFromReader(ctx, r, audioFilename, audioFileFormat, AudioFolderName, e.audios)
+}
+
+// addFromReader adds a resource read from r to mediaMap
+func (e *Epub) addFromReader(ctx context.Context, r io.Reader, internalFilename string, mediaFileFormat string, mediaFolderName string, mediaMap map[string]string) (string, error) {
+	e.Lock()
+	defer e.Unlock()
+	if internalFilename == "" {
+		return "", fmt.Errorf("can't add resource to %s from reader: empty filename", mediaFolderName)
+	}
+	source := readerSourcePrefix + path.Join(mediaFolderName, internalFilename)
+	p, err := e.addResource(ctx, source, internalFilename, mediaFileFormat, mediaFolderName, mediaMap)
+	if err != nil {
+		return "", err
+	}
+	if e.readers == nil {
+		e.readers = make(map[string]*readerSource)
+	}
+	e.readers[source] = &readerSource{r: r}
+	return p, nil
+}
+
+// readerHandler returns the content of a resource added from a reader
+func (g grabber) readerHandler(mediaSource string, onlyCheck bool) (io.ReadCloser, error) {
+	if !strings.HasPrefix(mediaSource, readerSourcePrefix) {
+		return nil, errors.New("not a reader source")
+	}
+	if onlyCheck {
+		return nil, nil
+	}
+	s, ok := g.readers[mediaSource]
+	if !ok {
+		return nil, errors.New("reader not found")
+	}
+	if s.read {
+		return nil, errReaderAlreadyRead
+	}
+	s.read = true
+	if rc, ok := s.r.(io.ReadCloser); ok {
+		return rc, nil
+	}
+	return io.NopCloser(s.r), nil
+}
@@ -0,0 +1,88 @@
+package epub
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-shiori/go-epub/internal/storage"
+)
+
+func TestAddFromReader(t *testing.T) {
+	e, err := NewEpub(testEpubTitle)
+	if err != nil {
+		t.Error(err)
+	}
+	f, err := os.Open(testImageFromFileSource)
+	if err != nil {
+		t.Fatalf("Unexpected error opening testdata image file: %s", err)
+	}
+	imagePath, err := e.AddImageFromReader(f, testImageFromFileFilename)
+	if err != nil {
+		t.Errorf("Error adding image: %s", err)
+	}
+	testCSS := "body { color: red; }\n"
+	cssPath, err := e.AddCSSFromReader(strings.NewReader(testCSS), testCoverCSSFilename)
+	if err != nil {
+		t.Errorf("Error adding CSS: %s", err)
+	}
+	if _, err := e.AddImageFromReader(strings.NewReader(""), ""); err == nil {
+		t.Error("Expected an error adding an image from a reader without filename")
+	}
+	if _, err := e.AddCSSFromReader(strings.NewReader(testCSS), testCoverCSSFilename); err == nil {
+		t.Error("Expected FilenameAlreadyUsedError adding a CSS file with a used filename")
+	} else if _, ok := err.(*FilenameAlreadyUsedError); !ok {
+		t.Errorf("Expected error FilenameAlreadyUsedError not returned. Returned instead: %+v", err)
+	}
+
+	tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+	defer cleanup(testEpubFilename, tempDir)
+
+	contents, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, xhtmlFolderName, imagePath))
+	if err != nil {
+		t.Errorf("Unexpected error reading image file from EPUB: %s", err)
+	}
+	testImageContents, err := os.ReadFile(testImageFromFileSource)
+	if err != nil {
+		t.Errorf("Unexpected error reading testdata image file: %s", err)
+	}
+	if !bytes.Equal(contents, testImageContents) {
+		t.Errorf("Image file contents don't match")
+	}
+	contents, err = storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, xhtmlFolderName, cssPath))
+	if err != nil {
+		t.Errorf("Unexpected error reading CSS file from EPUB: %s", err)
+	}
+	if string(contents) != testCSS {
+		t.Errorf("CSS file contents don't match: %q", contents)
+	}
+	if err := f.Close(); err == nil {
+		t.Error("Reader not closed once read")
+	}
+
+	err = e.Write(testEpubFilename)
+	if !errors.Is(err, errReaderAlreadyRead) {
+		t.Errorf("Expected an error writing again a resource added from a reader, got %v", err)
+	}
+}
+
+func TestAddFromReaderIncrementalBuild(t *testing.T) {
+	e, err := NewEpub(testEpubTitle)
+	if err != nil {
+		t.Error(err)
+	}
+	defer os.Remove(testEpubFilename)
+	e.SetIncrementalBuild(true)
+	_, err = e.AddCSSFromReader(strings.NewReader("body { color: red; }\n"), testCoverCSSFilename)
+	if err != nil {
+		t.Errorf("Error adding CSS: %s", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := e.Write(testEpubFilename); err != nil {
+			t.Errorf("Error writing EPUB the %d time: %s", i+1, err)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package epub
+
+import (
+	"fmt"
+	"html"
+	"mime"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Manifest property of the sections referencing remote resources
+const remoteResourcesProperty = "remote-resources"
+
+// Media types of the extensions of streaming audio and video files, as the
+// content of remote resources isn't retrieved to detect it
+var remoteMediaTypes = map[string]string{
+	".aac":  "audio/aac",
+	".m4a":  "audio/mp4",
+	".m4b":  "audio/mp4",
+	".mp3":  "audio/mpeg",
+	".oga":  "audio/ogg",
+	".ogg":  "audio/ogg",
+	".opus": "audio/ogg; codecs=opus",
+	".wav":  "audio/wav",
+	".m4v":  "video/mp4",
+	".mp4":  "video/mp4",
+	".ogv":  "video/ogg",
+	".webm": "video/webm",
+}
+
+// remoteResource is an audio or video file left on the web
+type remoteResource struct {
+	mediaFolderName string
+	mediaType       string
+}
+
+// AddRemoteAudio adds an audio file that stays on the web instead of being
+// stored in the EPUB, as EPUB 3 permits for streaming audio, and returns its
+// URL to be used in EPUB sections.
+//
+// The audio file is listed in the manifest with its absolute URL, and the
+// manifest items of the sections referencing it are given the
+// remote-resources property. The media type is taken from the extension of
+// the URL if empty; if it is unknown, an error will be returned.
+func (e *Epub) AddRemoteAudio(source string, mediaType string) (string, error) {
+	e.Lock()
+	defer e.Unlock()
+	return e.addRemoteResource(source, mediaType, AudioFolderName)
+}
+
+// AddRemoteVideo adds a video file that stays on the web instead of being
+// stored in the EPUB, as AddRemoteAudio does.
+func (e *Epub) AddRemoteVideo(source string, mediaType string) (string, error) {
+	e.Lock()
+	defer e.Unlock()
+	return e.addRemoteResource(source, mediaType, VideoFolderName)
+}
+
+// addRemoteResource records a resource of the folder mediaFolderName left on
+// the web
+func (e *Epub) addRemoteResource(source string, mediaType string, mediaFolderName string) (string, error) {
+	u, err := url.Parse(source)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("can't add remote resource %s: not an absolute http or https URL", source)
+	}
+	if mediaType == "" {
+		ext := normalizeExt(path.Ext(u.Path))
+		if mediaType = e.extMediaTypes[ext]; mediaType == "" {
+			detected, ok := remoteMediaTypes[ext]
+			if !ok {
+				detected = mime.TypeByExtension(ext)
+			}
+			mediaType = manifestMediaType(u.Path, detected)
+		}
+	}
+	if mediaType == "" {
+		return "", fmt.Errorf("can't add remote resource %s: unknown media type", source)
+	}
+	if e.remoteResources == nil {
+		e.remoteResources = make(map[string]remoteResource)
+	}
+	e.remoteResources[source] = remoteResource{mediaFolderName: mediaFolderName, mediaType: mediaType}
+	return source, nil
+}
+
+// addRemoteResources adds the remote resources of the folder mediaFolderName
+// to the manifest
+func (e *Epub) addRemoteResources(mediaFolderName string) error {
+	var sources []string
+	for source, r := range e.remoteResources {
+		if r.mediaFolderName == mediaFolderName {
+			sources = append(sources, source)
+		}
+	}
+	sort.Strings(sources)
+	for _, source := range sources {
+		id, err := fixXMLId(source)
+		if err != nil {
+			return fmt.Errorf("error creating xml id: %w", err)
+		}
+		e.pkg.addToManifest(id, source, e.remoteResources[source].mediaType, "")
+	}
+	return nil
+}
+
+// referencesRemoteResources returns whether the section references one of the
+// remote resources
+func (e *Epub) referencesRemoteResources(section *epubSection) bool {
+	if len(e.remoteResources) == 0 {
+		return false
+	}
+	content := section.raw
+	if content == "" {
+		content = section.xhtml.body()
+	}
+	for source := range e.remoteResources {
+		if strings.Contains(content, source) || strings.Contains(content, html.EscapeString(source)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,59 @@
+package epub
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-shiori/go-epub/internal/storage"
+)
+
+func TestAddRemoteResources(t *testing.T) {
+	e, err := NewEpub(testEpubTitle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	audioURL, err := e.AddRemoteAudio("https://example.com/podcast/episode.mp3?token=a&b=1", "")
+	if err != nil {
+		t.Fatalf("Error adding remote audio: %s", err)
+	}
+	videoURL, err := e.AddRemoteVideo("https://example.com/stream", "video/mp4")
+	if err != nil {
+		t.Fatalf("Error adding remote video: %s", err)
+	}
+	if _, err := e.AddRemoteAudio("https://example.com/stream", ""); err == nil {
+		t.Error("Expected error adding a remote resource of unknown media type")
+	}
+	if _, err := e.AddRemoteAudio(testAudioFromFileSource, "audio/wav"); err == nil {
+		t.Error("Expected error adding a local file as a remote resource")
+	}
+
+	remote, err := e.AddSection(`<audio src="`+strings.ReplaceAll(audioURL, "&", "&amp;")+`" controls="controls"></audio>`, "Remote", "remote.xhtml", "")
+	if err != nil {
+		t.Fatalf("Error adding section: %s", err)
+	}
+	local, err := e.AddSection("<p>Local</p>", "Local", "local.xhtml", "")
+	if err != nil {
+		t.Fatalf("Error adding section: %s", err)
+	}
+
+	tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+	defer cleanup(testEpubFilename, tempDir)
+
+	pkg, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, pkgFilename))
+	if err != nil {
+		t.Fatalf("Unexpected error reading package file: %s", err)
+	}
+	for _, expected := range []string{
+		`href="https://example.com/podcast/episode.mp3?token=a&amp;b=1" media-type="audio/mpeg"`,
+		`href="` + videoURL + `" media-type="video/mp4"`,
+		`href="xhtml/` + remote + `" media-type="application/xhtml+xml" properties="remote-resources"`,
+	} {
+		if !strings.Contains(string(pkg), expected) {
+			t.Errorf("Package file doesn't contain %s:\n%s", expected, pkg)
+		}
+	}
+	if strings.Contains(string(pkg), `href="xhtml/`+local+`" media-type="application/xhtml+xml" properties=`) {
+		t.Errorf("Local section has properties:\n%s", pkg)
+	}
+}
@@ -0,0 +1,98 @@
+package epub
+
+import (
+	"fmt"
+	"log"
+	"path"
+	"strings"
+	"time"
+)
+
+// Categories used in BuildReport for files that are not resources
+const (
+	ReportCategoryPackage = "package"
+	ReportCategorySection = "section"
+)
+
+// BuildReport describes what was done while writing an EPUB. A report is
+// collected on every Write or WriteTo and can be retrieved with
+// Epub.BuildReport.
+type BuildReport struct {
+	// Every file written to the EPUB, in the order it was written
+	Files []ReportFile
+	// The key is the category of the files, the value is the total number of
+	// uncompressed bytes written for that category
+	BytesByCategory map[string]int64
+	// The key is the source of a resource, the value is the time it took to
+	// retrieve it
+	FetchTimings map[string]time.Duration
+	// Non-fatal problems encountered while writing
+	Warnings []string
+	// The key is the section filename, the value is the manifest properties
+	// detected for that section (e.g. "mathml scripted")
+	SectionProperties map[string]string
+	// The key is the path of a file inside the EPUB, the value is the
+	// hexadecimal SHA-256 hash of its content, see SetContentHashes
+	ContentHashes map[string]string
+}
+
+// ReportFile is a file written to the EPUB.
+type ReportFile struct {
+	// Path of the file inside the EPUB, e.g. EPUB/images/image0001.png
+	Path string
+	// One of the resource folder names (CSSFolderName, FontFolderName,
+	// ImageFolderName, VideoFolderName, AudioFolderName),
+	// ReportCategorySection for sections or ReportCategoryPackage for
+	// everything else (mimetype, container, package and TOC files)
+	Category string
+	// Uncompressed size in bytes
+	Size int64
+	// Whether the compressed content of the previous build was reused, see
+	// SetIncrementalBuild
+	Reused bool
+}
+
+func newBuildReport() *BuildReport {
+	return &BuildReport{
+		BytesByCategory:   make(map[string]int64),
+		FetchTimings:      make(map[string]time.Duration),
+		SectionProperties: make(map[string]string),
+	}
+}
+
+// BuildReport returns the report of the last Write or WriteTo, or nil if the
+// EPUB hasn't been written yet.
+func (e *Epub) BuildReport() *BuildReport {
+	e.Lock()
+	defer e.Unlock()
+	return e.report
+}
+
+// addFile records a file written to the archive. relativePath uses forward
+// slashes and is relative to the root of the EPUB.
+func (r *BuildReport) addFile(relativePath string, size int64, reused bool) {
+	category := ReportCategoryPackage
+	if dir, ok := strings.CutPrefix(path.Dir(relativePath), contentFolderName+"/"); ok {
+		category = dir
+		if dir == xhtmlFolderName {
+			category = ReportCategorySection
+		}
+	}
+	r.Files = append(r.Files, ReportFile{
+		Path:     relativePath,
+		Category: category,
+		Size:     size,
+		Reused:   reused,
+	})
+	r.BytesByCategory[category] += size
+}
+
+// warn logs a non-fatal problem and records it in the report of the current
+// Write, if any.
+func (e *Epub) warn(format string, a ...interface{}) {
+	message := fmt.Sprintf(format, a...)
+	log.Println(message)
+	if e.report != nil {
+		e.report.Warnings = append(e.report.Warnings, message)
+	}
+}
@@ -0,0 +1,56 @@
+package epub
+
+import (
+	"os"
+	"testing"
+)
+
+func TestBuildReport(t *testing.T) {
+	e, err := NewEpub(testEpubTitle)
+	if err != nil {
+		t.Error(err)
+	}
+	if e.BuildReport() != nil {
+		t.Error("Expected no report before the EPUB is written")
+	}
+
+	_, err = e.AddImage(testImageFromFileSource, testImageFromFileFilename)
+	if err != nil {
+		t.Errorf("Error adding image: %s", err)
+	}
+	_, err = e.AddSection("<h1>Section 1</h1><p><math xmlns='http://www.w3.org/1998/Math/MathML'></math></p>", testSectionTitle, testSectionFilename, "")
+	if err != nil {
+		t.Errorf("Error adding section: %s", err)
+	}
+
+	tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+	defer cleanup(testEpubFilename, tempDir)
+
+	report := e.BuildReport()
+	if report == nil {
+		t.Fatal("Expected a report after the EPUB is written")
+	}
+
+	if len(report.Files) == 0 || report.Files[0].Path != mimetypeFilename || report.Files[0].Category != ReportCategoryPackage {
+		t.Errorf("Expected the mimetype file to be reported first, got %+v", report.Files)
+	}
+
+	imageInfo, err := os.Stat(testImageFromFileSource)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := report.BytesByCategory[ImageFolderName]; got != imageInfo.Size() {
+		t.Errorf("Expected %d bytes of images, got %d", imageInfo.Size(), got)
+	}
+	if report.BytesByCategory[ReportCategorySection] == 0 {
+		t.Error("Expected section bytes to be reported")
+	}
+
+	if _, ok := report.FetchTimings[testImageFromFileSource]; !ok {
+		t.Errorf("Expected a fetch timing for %s, got %v", testImageFromFileSource, report.FetchTimings)
+	}
+
+	if got := report.SectionProperties[testSectionFilename]; got != "mathml" {
+		t.Errorf("Expected section properties %q, got %q", "mathml", got)
+	}
+}
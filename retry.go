@@ -0,0 +1,109 @@
+package epub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// RetryPolicy configures how the fetches of remote resources are retried, so
+// that a network blip doesn't fail the whole build. The zero value tries each
+// fetch once with no timeout other than that of Client.
+//
+// A fetch is retried when the connection fails, when the attempt times out,
+// or when the server answers 408, 429 or a 5xx status code. A download
+// interrupted while being copied is started over. Other status codes, a
+// resource over MaxResourceSize and a canceled context fail at once.
+type RetryPolicy struct {
+	// Number of retries after the first attempt, 0 for none
+	MaxRetries int
+	// Wait before the first retry, doubled after each retry
+	Backoff time.Duration
+	// Maximum wait between two retries, 0 for no limit
+	MaxBackoff time.Duration
+	// Maximum duration of each attempt, including the download of the
+	// content, 0 for no limit
+	Timeout time.Duration
+}
+
+// SetRetryPolicy sets how the fetches of remote resources are retried, when
+// they are added and when the EPUB is written, see RetryPolicy.
+func (e *Epub) SetRetryPolicy(p RetryPolicy) error {
+	if p.MaxRetries < 0 || p.Backoff < 0 || p.MaxBackoff < 0 || p.Timeout < 0 {
+		return errors.New("invalid retry policy: negative value")
+	}
+	e.Lock()
+	defer e.Unlock()
+	e.retryPolicy = p
+	return nil
+}
+
+// httpStatusError is returned when a server answers with an error status code
+type httpStatusError struct {
+	StatusCode int
+}
+
+func (e *httpStatusError) Error() string {
+	return fmt.Sprintf("cannot get file, bad return code %d", e.StatusCode)
+}
+
+// retry calls attempt until it succeeds or fails with an error that isn't
+// worth retrying, as set by the retry policy of the grabber. Only the fetches
+// of URLs are retried.
+func (g grabber) retry(mediaSource string, attempt func() error) error {
+	err := attempt()
+	if detectMediaType(mediaSource) != "URL" {
+		return err
+	}
+	backoff := g.retryPolicy.Backoff
+	for i := 0; i < g.retryPolicy.MaxRetries && err != nil && g.retryable(err); i++ {
+		if max := g.retryPolicy.MaxBackoff; max > 0 && backoff > max {
+			backoff = max
+		}
+		t := time.NewTimer(backoff)
+		select {
+		case <-g.context().Done():
+			t.Stop()
+			return err
+		case <-t.C:
+		}
+		backoff *= 2
+		err = attempt()
+	}
+	return err
+}
+
+// retryable returns whether a fetch failing with err is worth retrying
+func (g grabber) retryable(err error) bool {
+	if g.context().Err() != nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var limitErr *LimitExceededError
+	if errors.As(err, &limitErr) {
+		return false
+	}
+	var statusErr *httpStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode == http.StatusRequestTimeout ||
+			statusErr.StatusCode == http.StatusTooManyRequests ||
+			statusErr.StatusCode >= 500
+	}
+	return true
+}
+
+// cancelReadCloser releases the context of a request when its body is closed
+type cancelReadCloser struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+	// Content-Type of the response
+	contentType string
+}
+
+func (c cancelReadCloser) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
+}
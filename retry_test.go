@@ -0,0 +1,114 @@
+package epub
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicy(t *testing.T) {
+	image, err := os.ReadFile(testImageFromFileSource)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var requests atomic.Int32
+	// The first two requests fail, the third one is interrupted while the
+	// body is sent
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch requests.Add(1) {
+		case 1, 2:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case 3:
+			w.Header().Set("Content-Length", "100000")
+			w.Write(image[:10])
+		default:
+			w.Write(image)
+		}
+	}))
+	defer ts.Close()
+
+	e, err := NewEpub(testEpubTitle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.AddImage(ts.URL+"/image.png", ""); err == nil {
+		t.Fatal("Expected an error without retries")
+	}
+
+	requests.Store(0)
+	if err := e.SetRetryPolicy(RetryPolicy{MaxRetries: 3, Backoff: time.Millisecond}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.AddImage(ts.URL+"/image.png", "image.png"); err != nil {
+		t.Fatalf("Unexpected error adding image: %s", err)
+	}
+
+	requests.Store(0)
+	tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+	defer cleanup(testEpubFilename, tempDir)
+	if n := requests.Load(); n != 4 {
+		t.Errorf("Expected 4 requests writing the EPUB, got %d", n)
+	}
+}
+
+func TestRetryPolicyNotRetried(t *testing.T) {
+	var requests atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer ts.Close()
+
+	e, err := NewEpub(testEpubTitle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.SetRetryPolicy(RetryPolicy{MaxRetries: 3}); err != nil {
+		t.Fatal(err)
+	}
+	_, err = e.AddImage(ts.URL+"/image.png", "")
+	var statusErr *httpStatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected a 404 error, got %v", err)
+	}
+	if n := requests.Load(); n != 1 {
+		t.Errorf("Expected 1 request, got %d", n)
+	}
+
+	if err := e.SetRetryPolicy(RetryPolicy{MaxRetries: -1}); err == nil {
+		t.Error("Expected an error for a negative number of retries")
+	}
+}
+
+func TestRetryPolicyTimeout(t *testing.T) {
+	var requests atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) == 1 {
+			select {
+			case <-r.Context().Done():
+			case <-time.After(time.Second):
+			}
+			return
+		}
+		w.Write([]byte("body { color: black; }"))
+	}))
+	defer ts.Close()
+
+	e, err := NewEpub(testEpubTitle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.SetRetryPolicy(RetryPolicy{MaxRetries: 1, Timeout: 50 * time.Millisecond}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.AddCSS(ts.URL+"/style.css", ""); err != nil {
+		t.Fatalf("Unexpected error adding CSS: %s", err)
+	}
+	if n := requests.Load(); n != 2 {
+		t.Errorf("Expected 2 requests, got %d", n)
+	}
+}
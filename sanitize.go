@@ -0,0 +1,117 @@
+package epub
+
+import (
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// SanitizeProfile defines how section bodies are cleaned up when they are added
+// to the EPUB.
+type SanitizeProfile int
+
+const (
+	// SanitizeNone leaves section bodies untouched. This is the default.
+	SanitizeNone SanitizeProfile = iota
+	// SanitizeStrict is meant for untrusted content. It removes scripts,
+	// event handler attributes, javascript: URLs, data: URLs of links and
+	// sources other than images, iframes, frames, embedded objects and form
+	// actions pointing outside of the EPUB. The body is
+	// parsed with an HTML5 parser and serialized back as well-formed XHTML.
+	SanitizeStrict
+	// SanitizeTidy only fixes the markup, keeping the content: the body is
+	// parsed with an HTML5 parser, which closes unclosed tags and decodes the
+	// HTML named entities, and serialized back as well-formed XHTML. This
+	// avoids EPUBs rejected by validators because of malformed HTML.
+	SanitizeTidy
+)
+
+// Elements removed along with their content by SanitizeStrict
+var strictRemovedElements = map[string]bool{
+	"applet":   true,
+	"base":     true,
+	"embed":    true,
+	"frame":    true,
+	"frameset": true,
+	"iframe":   true,
+	"meta":     true,
+	"object":   true,
+	"script":   true,
+}
+
+// Attributes holding a URL that may use the javascript: scheme
+var urlAttributes = map[string]bool{
+	"action":     true,
+	"background": true,
+	"formaction": true,
+	"href":       true,
+	"poster":     true,
+	"src":        true,
+}
+
+// SetSanitizeProfile sets the profile used to clean up the bodies of the
+// sections added or updated afterwards. Sections already added are not
+// affected.
+func (e *Epub) SetSanitizeProfile(profile SanitizeProfile) {
+	e.Lock()
+	defer e.Unlock()
+	e.sanitizeProfile = profile
+}
+
+// sanitize cleans up the parsed body according to profile
+func sanitize(root *html.Node, profile SanitizeProfile) {
+	if profile == SanitizeStrict {
+		sanitizeStrict(root)
+	}
+}
+
+func sanitizeStrict(root *html.Node) {
+	walk(root, func(n *html.Node) bool {
+		if strictRemovedElements[n.Data] {
+			n.Parent.RemoveChild(n)
+			return false
+		}
+
+		removeAttr(n, func(a html.Attribute) bool {
+			key := strings.ToLower(a.Key)
+			if a.Namespace == "" && strings.HasPrefix(key, "on") {
+				return true
+			}
+			if urlAttributes[key] && isScriptURL(a.Val) {
+				return true
+			}
+			if (key == "href" || key == "src") && n.DataAtom != atom.Img && isDataURL(a.Val) {
+				return true
+			}
+			if (key == "action" || key == "formaction") && isExternalURL(a.Val) {
+				return true
+			}
+			return false
+		})
+		return true
+	})
+}
+
+// isScriptURL returns true if u uses a scheme executing code
+func isScriptURL(u string) bool {
+	u = strings.ToLower(strings.Map(func(r rune) rune {
+		// Browsers ignore whitespace and control characters in the scheme
+		if r <= ' ' {
+			return -1
+		}
+		return r
+	}, u))
+	return strings.HasPrefix(u, "javascript:") || strings.HasPrefix(u, "vbscript:")
+}
+
+// isDataURL returns true if u uses the data: scheme
+func isDataURL(u string) bool {
+	return strings.HasPrefix(strings.ToLower(strings.TrimSpace(u)), "data:")
+}
+
+// isExternalURL returns true if u points outside of the EPUB
+func isExternalURL(u string) bool {
+	u = strings.ToLower(strings.TrimSpace(u))
+	return strings.HasPrefix(u, "//") || strings.Contains(u, "://") || strings.HasPrefix(u, "mailto:")
+}
@@ -0,0 +1,121 @@
+package epub
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSanitizeStrict(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		expected string
+	}{
+		{
+			"script",
+			`<p>Hello</p><script>alert(1)</script>`,
+			`<p>Hello</p>`,
+		},
+		{
+			"event handler",
+			`<p onclick="alert(1)" class="a">Hello</p>`,
+			`<p class="a">Hello</p>`,
+		},
+		{
+			"javascript URL",
+			`<a href=" JavaScript:alert(1)">link</a><a href="https://example.com">ok</a>`,
+			`<a>link</a><a href="https://example.com">ok</a>`,
+		},
+		{
+			"data URL",
+			`<a href=" DATA:text/html,<script>alert(1)</script>">link</a><img src="data:image/png;base64,AAAA"/><audio src="data:audio/mpeg;base64,AAAA"></audio>`,
+			`<a>link</a><img src="data:image/png;base64,AAAA"/><audio></audio>`,
+		},
+		{
+			"iframe and embeds",
+			`<iframe src="https://example.com"></iframe><object data="x.swf"></object><embed src="x.swf"><p>text</p>`,
+			`<p>text</p>`,
+		},
+		{
+			"external form action",
+			`<form action="https://example.com/post"><button formaction="//example.com">Go</button></form><form action="local.xhtml"></form>`,
+			`<form><button>Go</button></form><form action="local.xhtml"></form>`,
+		},
+		{
+			"svg script",
+			`<svg><script>alert(1)</script><circle r="1"></circle></svg>`,
+			`<svg xmlns="http://www.w3.org/2000/svg"><circle r="1"></circle></svg>`,
+		},
+		{
+			"well-formed output",
+			`<p>one<br>two<p>three &nbsp;`,
+			"<p>one<br/>two</p><p>three \u00a0</p>",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root, err := parseBody(tt.body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sanitize(root, SanitizeStrict)
+			got, err := renderBody(root)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.expected {
+				t.Errorf("sanitize() = %s, want %s", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestSetSanitizeProfile(t *testing.T) {
+	e, err := NewEpub(testEpubTitle)
+	if err != nil {
+		t.Error(err)
+	}
+
+	body := `<p onmouseover="steal()">Hello</p><script>steal()</script>`
+	_, err = e.AddSection(body, "Untouched", "untouched.xhtml", "")
+	if err != nil {
+		t.Errorf("Error adding section: %s", err)
+	}
+	e.SetSanitizeProfile(SanitizeStrict)
+	_, err = e.AddSection(body, "Sanitized", "sanitized.xhtml", "")
+	if err != nil {
+		t.Errorf("Error adding section: %s", err)
+	}
+
+	untouched, sanitized := e.sections[0], e.sections[1]
+	if !strings.Contains(untouched.xhtml.xml.Body.XML, "<script>") || untouched.properties != "scripted" {
+		t.Errorf("Section added before setting the profile should be untouched, got %s", untouched.xhtml.xml.Body.XML)
+	}
+	if strings.Contains(sanitized.xhtml.xml.Body.XML, "script") || strings.Contains(sanitized.xhtml.xml.Body.XML, "onmouseover") {
+		t.Errorf("Section body was not sanitized: %s", sanitized.xhtml.xml.Body.XML)
+	}
+	if sanitized.properties != "" {
+		t.Errorf("Sanitized section should not be scripted, got properties %q", sanitized.properties)
+	}
+}
+
+func TestSanitizeTidy(t *testing.T) {
+	e, err := NewEpub(testEpubTitle)
+	if err != nil {
+		t.Error(err)
+	}
+	e.SetSanitizeProfile(SanitizeTidy)
+	filename, err := e.AddSection(`<p class=a onclick="go()">one<br>two &nbsp;&copy; <b>bold<p>three & four<script>go()</script>`, testSectionTitle, "", "")
+	if err != nil {
+		t.Errorf("Error adding section: %s", err)
+	}
+
+	expected := `<p class="a" onclick="go()">one<br/>two ` + "\u00a0\u00a9" + ` <b>bold</b></p><p><b>three &amp; four<script>go()</script></b></p>`
+	s := findSection(e.sections, filename)
+	if got := strings.TrimSpace(s.xhtml.body()); got != expected {
+		t.Errorf("Got body %s, expected %s", got, expected)
+	}
+	if _, err := readBody(strings.NewReader(s.xhtml.body())); err != nil {
+		t.Errorf("Tidied body is not well-formed: %s", err)
+	}
+}
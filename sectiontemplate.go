@@ -0,0 +1,114 @@
+package epub
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+	"text/template"
+)
+
+// SectionTemplateData is the data the template set with SetSectionTemplate is
+// executed with. Only Styles, Head and Body hold markup; the other fields must
+// be escaped, e.g. with the html function of text/template.
+type SectionTemplateData struct {
+	Filename string   // Internal filename of the section
+	Title    string   // Title of the section
+	Lang     string   // Language of the section, or of the EPUB if it has none
+	Dir      string   // Base direction of the page, empty if left to the reading system
+	CSS      []string // Internal paths of the CSS files linked from the section, in order
+	Styles   []string // Stylesheets embedded by WriteSection, ready to go in <style> elements
+	Head     string   // Markup set with SetSectionHead, well-formed XHTML
+	Body     string   // Content of the <body> element, well-formed XHTML
+}
+
+// SetSectionTemplate sets a text/template generating the whole XHTML document
+// of every section, cover included, in place of the default markup, for
+// publishers with strict markup house styles. It is executed with a
+// SectionTemplateData, e.g.:
+//
+//	<?xml version="1.0" encoding="UTF-8"?>
+//	<!DOCTYPE html>
+//	<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops" lang="{{.Lang | html}}">
+//	<head>
+//	  <title>{{.Title | html}}</title>
+//	  {{range .CSS}}<link rel="stylesheet" type="text/css" href="{{. | html}}"/>{{end}}
+//	  {{range .Styles}}<style type="text/css">{{.}}</style>{{end}}
+//	  {{.Head}}
+//	</head>
+//	<body><div class="chapter">{{.Body}}</div></body>
+//	</html>
+//
+// The output is checked to be well-formed XML when the EPUB is written: if it
+// isn't for a section, Write and WriteSection return an error. An empty
+// template restores the default markup.
+func (e *Epub) SetSectionTemplate(tmpl string) error {
+	e.Lock()
+	defer e.Unlock()
+	if tmpl == "" {
+		e.sectionTemplate = nil
+		return nil
+	}
+	t, err := template.New("section").Parse(tmpl)
+	if err != nil {
+		return fmt.Errorf("invalid section template: %w", err)
+	}
+	e.sectionTemplate = t
+	return nil
+}
+
+// sectionContent returns the content of the XHTML file of a section
+func (e *Epub) sectionContent(x *xhtml, filename string) ([]byte, error) {
+	if e.sectionTemplate == nil {
+		return x.content()
+	}
+
+	data := SectionTemplateData{
+		Filename: filename,
+		Title:    x.Title(),
+		Lang:     x.xml.Lang,
+		Dir:      x.xml.Dir,
+		CSS:      x.cssPaths(),
+		Head:     strings.TrimSpace(x.xml.Head.Extra),
+		Body:     x.body(),
+	}
+	if data.Lang == "" {
+		data.Lang = e.lang
+	}
+	for _, style := range x.xml.Head.Styles {
+		data.Styles = append(data.Styles, style.CSS)
+	}
+	var b bytes.Buffer
+	if err := e.sectionTemplate.Execute(&b, data); err != nil {
+		return nil, fmt.Errorf("can't execute section template for %s: %w", filename, err)
+	}
+
+	d := xml.NewDecoder(bytes.NewReader(b.Bytes()))
+	for {
+		_, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("section template output for %s isn't well-formed: %w", filename, err)
+		}
+	}
+	return b.Bytes(), nil
+}
+
+// writeSection writes the XHTML file of a section to xhtmlFilePath
+func (e *Epub) writeSection(section *epubSection, xhtmlFilePath string) error {
+	content := []byte(section.raw)
+	if section.raw == "" {
+		var err error
+		content, err = e.sectionContent(e.sectionXhtml(section), section.filename)
+		if err != nil {
+			return err
+		}
+	}
+	if err := filesystem.WriteFile(xhtmlFilePath, content, filePermissions); err != nil {
+		return fmt.Errorf("Error writing XHTML file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package epub
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-shiori/go-epub/internal/storage"
+)
+
+const testSectionTemplate = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops" lang="{{.Lang | html}}">
+<head>
+<title>{{.Title | html}}</title>
+{{range .CSS}}<link rel="stylesheet" type="text/css" href="{{. | html}}"/>
+{{end}}{{range .Styles}}<style type="text/css">{{.}}</style>
+{{end}}</head>
+<body><div class="chapter" data-file="{{.Filename}}">{{.Body}}</div></body>
+</html>
+`
+
+func TestSetSectionTemplate(t *testing.T) {
+	e, err := NewEpub(testEpubTitle)
+	if err != nil {
+		t.Error(err)
+	}
+	cssPath, err := e.AddCSS(testCoverCSSSource, testCoverCSSFilename)
+	if err != nil {
+		t.Errorf("Error adding CSS: %s", err)
+	}
+	_, err = e.AddSection(testSectionBody, "Title & more", testSectionFilename, cssPath)
+	if err != nil {
+		t.Errorf("Error adding section: %s", err)
+	}
+
+	if err := e.SetSectionTemplate(`{{.Title`); err == nil {
+		t.Error("Expected an error for a malformed template")
+	}
+	if err := e.SetSectionTemplate(testSectionTemplate); err != nil {
+		t.Errorf("Error setting section template: %s", err)
+	}
+
+	tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+	contents, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, xhtmlFolderName, testSectionFilename))
+	if err != nil {
+		t.Errorf("Unexpected error reading section file: %s", err)
+	}
+	for _, expected := range []string{
+		`lang="` + defaultEpubLang + `"`,
+		`<title>Title &amp; more</title>`,
+		`<link rel="stylesheet" type="text/css" href="` + cssPath + `"/>`,
+		`<body><div class="chapter" data-file="` + testSectionFilename + `">` + testSectionBody + `</div></body>`,
+	} {
+		if !strings.Contains(string(contents), expected) {
+			t.Errorf("Section doesn't contain %q:\n%s", expected, contents)
+		}
+	}
+	cleanup(testEpubFilename, tempDir)
+
+	var b bytes.Buffer
+	if err := e.WriteSection(testSectionFilename, &b); err != nil {
+		t.Errorf("Error writing section: %s", err)
+	}
+	if !strings.Contains(b.String(), `<style type="text/css">`) || strings.Contains(b.String(), "<link") {
+		t.Errorf("Section template not used by WriteSection:\n%s", b.String())
+	}
+
+	// Output that isn't well-formed is reported
+	if err := e.SetSectionTemplate(`<html><body>{{.Body}}</html>`); err != nil {
+		t.Errorf("Error setting section template: %s", err)
+	}
+	if err := e.Write(filepath.Join(t.TempDir(), testEpubFilename)); err == nil {
+		t.Error("Expected an error writing an EPUB whose section template output isn't well-formed")
+	}
+	if err := e.WriteSection(testSectionFilename, &b); err == nil {
+		t.Error("Expected an error for a section template output that isn't well-formed")
+	}
+
+	if err := e.SetSectionTemplate(""); err != nil {
+		t.Errorf("Error resetting section template: %s", err)
+	}
+	b.Reset()
+	if err := e.WriteSection(testSectionFilename, &b); err != nil {
+		t.Errorf("Error writing section: %s", err)
+	}
+	if !strings.Contains(b.String(), `<body dir="auto">`) {
+		t.Errorf("Default markup not restored:\n%s", b.String())
+	}
+}
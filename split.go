@@ -0,0 +1,301 @@
+package epub
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/gofrs/uuid/v5"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Title of the volumes returned by Split, from the title of the EPUB and the
+// number of the volume
+const defaultVolumeTitleFormat = "%s, Volume %d"
+
+// SplitOptions are the thresholds of Split. A zero value means no limit.
+type SplitOptions struct {
+	// Maximum number of top level sections of a volume, the cover excluded
+	MaxSections int
+	// Maximum size in bytes of a volume before compression, estimated from
+	// the bodies of its sections and the resources they use
+	MaxSize int64
+	// Format of the titles of the volumes, with the title of the EPUB and the
+	// number of the volume as arguments, "%s, Volume %d" if empty
+	TitleFormat string
+}
+
+// Split splits the EPUB into volumes within the thresholds of opts, for very
+// large compilations that exceed the limits of reading devices. Top level
+// sections aren't split: they are kept with their subsections, and a volume
+// holds at least one of them even if it exceeds the thresholds.
+//
+// Each volume is an independent copy of the EPUB, holding its sections, the
+// cover, the stylesheets and fonts, and the images, videos and audio files its
+// sections reference; the images not referenced by any section, e.g. by a
+// stylesheet, are kept in every volume. The table of contents of a volume
+// lists its sections, the titles of the sections, notes and page labels are
+// unchanged so that the numbering continues from one volume to the next, and
+// the volumes are numbered as a series in their metadata. Each volume gets a
+// new identifier and a title formatted with opts.TitleFormat.
+//
+// The links to sections of other volumes are unlinked, their text kept, and
+// reported as warnings of the volume, see Warnings; the ones of the sections
+// added with AddRawSection are only reported.
+//
+// When opts.MaxSize is set, the resources are fetched to measure them, except
+// the ones added from a reader, which aren't counted. A single volume is
+// returned if the EPUB is within the thresholds.
+func (e *Epub) Split(opts SplitOptions) ([]*Epub, error) {
+	return e.SplitContext(context.Background(), opts)
+}
+
+// SplitContext is like Split, stopping the measure of the resources when ctx
+// is done.
+func (e *Epub) SplitContext(ctx context.Context, opts SplitOptions) ([]*Epub, error) {
+	if opts.MaxSections < 0 || opts.MaxSize < 0 {
+		return nil, fmt.Errorf("can't split EPUB: negative threshold")
+	}
+	e.Lock()
+	c := e.clone()
+	e.Unlock()
+
+	var chapters []*epubSection
+	var cover *epubSection
+	for _, s := range c.sections {
+		if s.filename == c.cover.xhtmlFilename {
+			cover = s
+		} else {
+			chapters = append(chapters, s)
+		}
+	}
+
+	// Sizes of the resources referenced by each chapter, and of the ones kept
+	// in every volume
+	var shared int64
+	refs := make([]map[string]int64, len(chapters))
+	for i := range refs {
+		refs[i] = make(map[string]int64)
+	}
+	g := c.grabber(ctx)
+	for _, folder := range []string{CSSFolderName, FontFolderName, ImageFolderName, VideoFolderName, AudioFolderName} {
+		for filename, source := range c.mediaMap(folder) {
+			// The resources added from a reader can only be read once, when
+			// the EPUB is written, so their size is left out
+			var size int64
+			if opts.MaxSize > 0 && !strings.HasPrefix(source, readerSourcePrefix) {
+				var err error
+				if size, err = g.mediaSize(source); err != nil {
+					return nil, err
+				}
+			}
+			ref := folder + "/" + filename
+			used := false
+			if folder != CSSFolderName && folder != FontFolderName {
+				for i, chapter := range chapters {
+					if referencesPath(chapter, ref) {
+						refs[i][ref] = size
+						used = true
+					}
+				}
+			}
+			if !used {
+				shared += size
+			}
+		}
+	}
+
+	// Top level sections of each volume, filled in order
+	var volumes [][]int
+	var size int64
+	var used map[string]bool
+	for i, chapter := range chapters {
+		added := sectionSize(chapter)
+		for ref, refSize := range refs[i] {
+			if !used[ref] {
+				added += refSize
+			}
+		}
+		last := len(volumes) - 1
+		if last < 0 ||
+			(opts.MaxSections > 0 && len(volumes[last]) >= opts.MaxSections) ||
+			(opts.MaxSize > 0 && size+added > opts.MaxSize) {
+			volumes = append(volumes, nil)
+			last++
+			used = make(map[string]bool)
+			size = shared + sectionSize(chapter)
+			for _, refSize := range refs[i] {
+				size += refSize
+			}
+		} else {
+			size += added
+		}
+		for ref := range refs[i] {
+			used[ref] = true
+		}
+		volumes[last] = append(volumes[last], i)
+	}
+	if len(volumes) <= 1 {
+		return []*Epub{c}, nil
+	}
+
+	format := opts.TitleFormat
+	if format == "" {
+		format = defaultVolumeTitleFormat
+	}
+	// Resources referenced by the chapters, removed from the volumes whose
+	// chapters don't reference them
+	referenced := make(map[string]bool)
+	for i := range chapters {
+		for ref := range refs[i] {
+			referenced[ref] = true
+		}
+	}
+	filenames := getFilenames(c.sections)
+	result := make([]*Epub, len(volumes))
+	for n, indexes := range volumes {
+		v := c.clone()
+		v.sections = nil
+		if cover != nil {
+			s := cloneSections([]*epubSection{cover})[0]
+			if n > 0 {
+				// The sections added under the cover stay in the first volume
+				s.children = nil
+			}
+			v.sections = append(v.sections, s)
+		}
+		keep := make(map[string]bool)
+		for _, i := range indexes {
+			v.sections = append(v.sections, cloneSections([]*epubSection{chapters[i]})...)
+			for ref := range refs[i] {
+				keep[ref] = true
+			}
+		}
+		for ref := range referenced {
+			if keep[ref] {
+				continue
+			}
+			folder, filename, _ := strings.Cut(ref, "/")
+			if folder == ImageFolderName && filename == v.cover.imageFilename {
+				continue
+			}
+			if err := v.removeResource(filename, folder, v.mediaMap(folder)); err != nil {
+				return nil, err
+			}
+		}
+		if err := v.unlinkOtherVolumes(filenames); err != nil {
+			return nil, err
+		}
+		v.SetTitle(fmt.Sprintf(format, c.title, n+1))
+		v.SetIdentifier(urnUUIDPrefix + uuid.Must(uuid.NewV4()).String())
+		v.pkg.setCollection(c.title, n+1)
+		result[n] = v
+	}
+	return result, nil
+}
+
+// unlinkOtherVolumes removes the links of the sections of the volume e to the
+// sections of the EPUB it was split from, listed in filenames, that are in
+// other volumes
+func (e *Epub) unlinkOtherVolumes(filenames map[string]int) error {
+	inVolume := getFilenames(e.sections)
+	otherVolume := func(base string, link string) bool {
+		target, _, ok := resolveLink(base, link)
+		if !ok || path.Dir(target) != xhtmlFolderName {
+			return false
+		}
+		_, inEPUB := filenames[path.Base(target)]
+		_, ok = inVolume[path.Base(target)]
+		return inEPUB && !ok
+	}
+	var err error
+	walkSections(e.sections, func(s *epubSection) {
+		base := path.Join(xhtmlFolderName, s.filename)
+		body := s.raw
+		if body == "" {
+			body = s.xhtml.body()
+		}
+		root, parseErr := parseBody(body)
+		if parseErr != nil {
+			if err == nil {
+				err = fmt.Errorf("can't split EPUB: section %s: %w", s.filename, parseErr)
+			}
+			return
+		}
+		unlinked := false
+		walk(root, func(n *html.Node) bool {
+			if n.DataAtom != atom.A || !otherVolume(base, getAttr(n, "href")) {
+				return true
+			}
+			e.addWarning(WarningCrossVolumeLink, s.filename, "link to %s points to another volume", getAttr(n, "href"))
+			if s.raw == "" {
+				removeAttr(n, func(a html.Attribute) bool {
+					return a.Namespace == "" && a.Key == "href"
+				})
+				unlinked = true
+			}
+			return true
+		})
+		if !unlinked {
+			return
+		}
+		body, renderErr := renderBody(root)
+		if renderErr != nil {
+			if err == nil {
+				err = fmt.Errorf("can't split EPUB: section %s: %w", s.filename, renderErr)
+			}
+			return
+		}
+		s.xhtml.setBody(body)
+	})
+	return err
+}
+
+// sectionSize returns the size of the markup of s and its subsections
+func sectionSize(s *epubSection) int64 {
+	size := int64(len(s.raw))
+	if s.raw == "" {
+		size = int64(len(s.xhtml.body()))
+	}
+	for _, child := range s.children {
+		size += sectionSize(child)
+	}
+	return size
+}
+
+// referencesPath returns whether s or one of its subsections references the
+// resource at ref, e.g. images/image0001.png
+func referencesPath(s *epubSection, ref string) bool {
+	if strings.Contains(s.raw, ref) || (s.raw == "" && strings.Contains(s.xhtml.body(), ref)) {
+		return true
+	}
+	for _, child := range s.children {
+		if referencesPath(child, ref) {
+			return true
+		}
+	}
+	return false
+}
+
+// mediaSize returns the size of the content of mediaSource
+func (g grabber) mediaSize(mediaSource string) (int64, error) {
+	once := g
+	once.retryPolicy.MaxRetries = 0
+	var size int64
+	err := g.retry(mediaSource, func() error {
+		source, _, _, err := once.openMedia(mediaSource)
+		if err != nil {
+			return err
+		}
+		defer source.Close()
+		size, err = io.Copy(io.Discard, source)
+		if err != nil {
+			return &FileRetrievalError{Source: mediaSource, Err: err}
+		}
+		return nil
+	})
+	return size, err
+}
@@ -0,0 +1,204 @@
+package epub
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEpubSplit(t *testing.T) {
+	e, err := NewEpub(testEpubTitle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	coverPath, err := e.AddImage(testImageFromFileSource, "cover.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.SetCover(coverPath, ""); err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 5; i++ {
+		imagePath, err := e.AddImage(testImageFromFileSource, fmt.Sprintf("image%d.png", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		filename, err := e.AddSection(fmt.Sprintf(`<h1>Chapter %d</h1><img src="%s" alt="" />`, i, imagePath), fmt.Sprintf("Chapter %d", i), fmt.Sprintf("chapter%d.xhtml", i), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := e.AddSubSection(filename, "<p>Part</p>", fmt.Sprintf("Part %d", i), "", ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	volumes, err := e.Split(SplitOptions{MaxSections: 2})
+	if err != nil {
+		t.Fatalf("Error splitting EPUB: %s", err)
+	}
+	if len(volumes) != 3 {
+		t.Fatalf("Expected 3 volumes, got %d", len(volumes))
+	}
+	identifiers := map[string]bool{e.Identifier(): true}
+	for n, v := range volumes {
+		if want := fmt.Sprintf("%s, Volume %d", testEpubTitle, n+1); v.Title() != want {
+			t.Errorf("Volume title %q, expected %q", v.Title(), want)
+		}
+		if identifiers[v.Identifier()] {
+			t.Errorf("Identifier of volume %d not unique: %s", n+1, v.Identifier())
+		}
+		identifiers[v.Identifier()] = true
+
+		sections := v.Sections()
+		if sections[0].Filename != e.cover.xhtmlFilename {
+			t.Errorf("Volume %d doesn't start with the cover", n+1)
+		}
+		var chapters []string
+		for _, s := range sections[1:] {
+			if s.Parent == "" {
+				chapters = append(chapters, s.Filename)
+			}
+		}
+		var want []string
+		for i := 2*n + 1; i <= 2*n+2 && i <= 5; i++ {
+			want = append(want, fmt.Sprintf("chapter%d.xhtml", i))
+		}
+		if strings.Join(chapters, " ") != strings.Join(want, " ") {
+			t.Errorf("Volume %d has chapters %v, expected %v", n+1, chapters, want)
+		}
+		var kept []string
+		for _, image := range v.Images() {
+			kept = append(kept, image.Filename)
+		}
+		wantImages := "cover.png"
+		for _, chapter := range want {
+			wantImages += " " + strings.Replace(strings.TrimSuffix(chapter, ".xhtml"), "chapter", "image", 1) + ".png"
+		}
+		if strings.Join(kept, " ") != wantImages {
+			t.Errorf("Volume %d has images %v, expected %s", n+1, kept, wantImages)
+		}
+
+		var b bytes.Buffer
+		if _, err := v.WriteTo(&b); err != nil {
+			t.Fatalf("Error writing volume %d: %s", n+1, err)
+		}
+		pkg, err := readOpenedFile(b.Bytes(), "EPUB/package.opf")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(pkg, fmt.Sprintf(`<meta refines="#collection" property="group-position">%d</meta>`, n+1)) {
+			t.Errorf("Series position of volume %d missing:\n%s", n+1, pkg)
+		}
+	}
+	if len(e.Sections()) != 11 {
+		t.Errorf("Split changed the EPUB: %d sections", len(e.Sections()))
+	}
+
+	// A volume holds at least one chapter, whatever its size
+	image, err := os.Stat(testImageFromFileSource)
+	if err != nil {
+		t.Fatal(err)
+	}
+	volumes, err = e.Split(SplitOptions{MaxSize: image.Size()})
+	if err != nil {
+		t.Fatalf("Error splitting EPUB: %s", err)
+	}
+	if len(volumes) != 5 {
+		t.Errorf("Expected 5 volumes, got %d", len(volumes))
+	}
+	volumes, err = e.Split(SplitOptions{})
+	if err != nil || len(volumes) != 1 || volumes[0].Title() != testEpubTitle {
+		t.Errorf("Expected the EPUB as the only volume without thresholds, got %d volumes, %v", len(volumes), err)
+	}
+}
+
+func TestEpubSplitCrossVolumeLinks(t *testing.T) {
+	e, err := NewEpub(testEpubTitle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.AddSection(`<p id="one"><a href="two.xhtml#two">Next</a> <a href="#one">Here</a></p>`, "One", "one.xhtml", ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.AddSection(`<p id="two"><a href="one.xhtml">Previous</a></p>`, "Two", "two.xhtml", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	volumes, err := e.Split(SplitOptions{MaxSections: 1})
+	if err != nil {
+		t.Fatalf("Error splitting EPUB: %s", err)
+	}
+	if len(volumes) != 2 {
+		t.Fatalf("Expected 2 volumes, got %d", len(volumes))
+	}
+	for n, want := range []string{`<p id="one"><a>Next</a> <a href="#one">Here</a></p>`, `<p id="two"><a>Previous</a></p>`} {
+		v := volumes[n]
+		body := v.sections[0].xhtml.body()
+		if body != want {
+			t.Errorf("Volume %d has body %s, expected %s", n+1, body, want)
+		}
+		warnings := v.Warnings()
+		if len(warnings) != 1 || warnings[0].Kind != WarningCrossVolumeLink {
+			t.Errorf("Expected a cross-volume link warning for volume %d, got %v", n+1, warnings)
+		}
+		findings, err := v.Validate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, f := range findings {
+			if f.Severity == SeverityError {
+				t.Errorf("Volume %d invalid: %s", n+1, f)
+			}
+		}
+	}
+	if body := e.sections[0].xhtml.body(); !strings.Contains(body, `href="two.xhtml#two"`) {
+		t.Errorf("Split changed the EPUB: %s", body)
+	}
+}
+
+func TestEpubSplitReaderResources(t *testing.T) {
+	e, err := NewEpub(testEpubTitle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(testImageFromFileSource)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	imagePath, err := e.AddImageFromReader(f, testImageFromFileFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, filename := range []string{"one.xhtml", "two.xhtml"} {
+		if _, err := e.AddSection(`<p><img src="`+imagePath+`" alt=""/></p>`, testSectionTitle, filename, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	volumes, err := e.Split(SplitOptions{MaxSize: 1 << 30})
+	if err != nil {
+		t.Fatalf("Error splitting EPUB: %s", err)
+	}
+	if len(volumes) != 1 {
+		t.Errorf("Expected 1 volume, got %d", len(volumes))
+	}
+	// Measuring the volumes doesn't read the image
+	if err := e.Write(filepath.Join(t.TempDir(), testEpubFilename)); err != nil {
+		t.Errorf("Error writing EPUB after Split: %s", err)
+	}
+}
+
+// readOpenedFile returns the content of the file at name of the EPUB held in
+// data
+func readOpenedFile(data []byte, name string) (string, error) {
+	er, err := newEpubReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return "", err
+	}
+	content, err := er.readFile(name)
+	return string(content), err
+}
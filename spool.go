@@ -0,0 +1,150 @@
+package epub
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"sync"
+
+	"github.com/go-shiori/go-epub/internal/storage/osfs"
+)
+
+// spool keeps resources bigger than a given size out of the storage layer.
+// When the memory filesystem is used, every resource fetched during Write would
+// otherwise be held in memory in its entirety, so the content of large
+// resources is written to temporary files on the local disk instead and read
+// back from there when the EPUB is zipped.
+type spool struct {
+	limit int64
+	// mu guards files, as resources are fetched concurrently
+	mu sync.Mutex
+	// The key is the path of the file in the storage, the value is the path of
+	// the temporary file on the local disk holding its content
+	files map[string]string
+}
+
+// newSpool returns a spool for resources bigger than limit bytes. It returns
+// nil if no limit is set or if the storage is already the local filesystem, in
+// which case there is nothing to gain from spooling.
+func newSpool(limit int64) *spool {
+	if limit <= 0 {
+		return nil
+	}
+	if _, ok := filesystem.(*osfs.OSFS); ok {
+		return nil
+	}
+	return &spool{
+		limit: limit,
+		files: make(map[string]string),
+	}
+}
+
+// writer returns a writer for the file at name in the storage. Content is
+// passed to dst until the limit is exceeded, at which point it is moved to a
+// temporary file. The returned writer must be closed once done.
+func (s *spool) writer(name string, dst io.Writer) io.WriteCloser {
+	return &spoolWriter{
+		spool: s,
+		name:  name,
+		dst:   dst,
+	}
+}
+
+// open opens the file at name, either from the temporary file it was spooled
+// to or from the storage.
+func (s *spool) open(name string) (fs.File, error) {
+	if s != nil {
+		s.mu.Lock()
+		tempFile, ok := s.files[name]
+		s.mu.Unlock()
+		if ok {
+			return os.Open(tempFile)
+		}
+	}
+	return filesystem.Open(name)
+}
+
+// spooled returns whether the file at name was moved to a temporary file.
+func (s *spool) spooled(name string) bool {
+	if s == nil {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.files[name]
+	return ok
+}
+
+// remove removes the temporary file the file at name was moved to, if any, so
+// that it is read from the storage again.
+func (s *spool) remove(name string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if tempFile, ok := s.files[name]; ok {
+		if err := os.Remove(tempFile); err != nil {
+			log.Printf("Error removing spooled file: %s", err)
+		}
+		delete(s.files, name)
+	}
+}
+
+// cleanup removes all the temporary files created by the spool.
+func (s *spool) cleanup() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for name, tempFile := range s.files {
+		if err := os.Remove(tempFile); err != nil {
+			log.Printf("Error removing spooled file: %s", err)
+		}
+		delete(s.files, name)
+	}
+}
+
+// spoolWriter buffers the content up to the limit of the spool and switches to
+// a temporary file once the limit is exceeded.
+type spoolWriter struct {
+	spool *spool
+	name  string
+	dst   io.Writer
+	buf   bytes.Buffer
+	temp  *os.File
+}
+
+// Write implements the io.Writer interface.
+func (w *spoolWriter) Write(p []byte) (int, error) {
+	if w.temp == nil && int64(w.buf.Len()+len(p)) > w.spool.limit {
+		temp, err := os.CreateTemp("", tempDirPrefix)
+		if err != nil {
+			return 0, err
+		}
+		w.temp = temp
+		w.spool.mu.Lock()
+		w.spool.files[w.name] = temp.Name()
+		w.spool.mu.Unlock()
+		if _, err := w.buf.WriteTo(temp); err != nil {
+			return 0, err
+		}
+	}
+	if w.temp != nil {
+		return w.temp.Write(p)
+	}
+	return w.buf.Write(p)
+}
+
+// Close flushes the buffered content to the storage if the limit was never
+// exceeded, or closes the temporary file otherwise.
+func (w *spoolWriter) Close() error {
+	if w.temp != nil {
+		return w.temp.Close()
+	}
+	_, err := w.buf.WriteTo(w.dst)
+	return err
+}
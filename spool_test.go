@@ -0,0 +1,127 @@
+package epub
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestSpool(t *testing.T) {
+	if err := Use(MemoryFS); err != nil {
+		t.Fatal(err)
+	}
+	defer Use(OsFS)
+
+	s := newSpool(4)
+	if s == nil {
+		t.Fatal("Expected a spool for the memory filesystem")
+	}
+	defer s.cleanup()
+
+	for name, content := range map[string]string{
+		"small": "abc",
+		"big":   "abcdefgh",
+	} {
+		f, err := filesystem.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := s.writer(name, f)
+		if _, err := io.WriteString(w, content); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+
+		r, err := s.open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != content {
+			t.Errorf("Spooled content of %s doesn't match\nGot: %s\nExpected: %s", name, got, content)
+		}
+	}
+	if _, ok := s.files["small"]; ok {
+		t.Error("Small file should not be spooled")
+	}
+	tempFile, ok := s.files["big"]
+	if !ok {
+		t.Fatal("Big file should be spooled")
+	}
+
+	s.cleanup()
+	if _, err := os.Stat(tempFile); !os.IsNotExist(err) {
+		t.Errorf("Spooled file %s should be removed by cleanup", tempFile)
+	}
+}
+
+func TestSpoolOSFS(t *testing.T) {
+	if err := Use(OsFS); err != nil {
+		t.Fatal(err)
+	}
+	if s := newSpool(4); s != nil {
+		t.Error("No spool expected for the local filesystem")
+	}
+}
+
+func TestSetMaxBufferSize(t *testing.T) {
+	if err := Use(MemoryFS); err != nil {
+		t.Fatal(err)
+	}
+	defer Use(OsFS)
+
+	e, err := NewEpub(testEpubTitle)
+	if err != nil {
+		t.Error(err)
+	}
+	e.SetMaxBufferSize(1024)
+	videoPath, err := e.AddVideo(testVideoFromFileSource, testVideoFromFileFilename)
+	if err != nil {
+		t.Fatalf("Error adding video: %s", err)
+	}
+
+	var b bytes.Buffer
+	if _, err := e.WriteTo(&b); err != nil {
+		t.Fatalf("Unexpected error writing EPUB: %s", err)
+	}
+
+	expected, err := os.ReadFile(testVideoFromFileSource)
+	if err != nil {
+		t.Fatal(err)
+	}
+	z, err := zip.NewReader(bytes.NewReader(b.Bytes()), int64(b.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	videoName := path.Join(contentFolderName, strings.TrimPrefix(videoPath, "../"))
+	for _, f := range z.File {
+		if f.Name != videoName {
+			continue
+		}
+		r, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, expected) {
+			t.Errorf("Video content doesn't match the source (got %d bytes, expected %d)", len(got), len(expected))
+		}
+		return
+	}
+	t.Errorf("Video %s not found in EPUB", videoName)
+}
@@ -0,0 +1,116 @@
+package epub
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+)
+
+// streamedMedia is a resource copied straight from its source into the archive
+// by Write, see SetStreamingWrite
+type streamedMedia struct {
+	source string
+	// Expected SHA-256 hash of the content, if any
+	checksum string
+}
+
+// SetStreamingWrite sets whether Write copies the resources straight from
+// their sources into the archive instead of fetching them into the storage
+// layer first, so that EPUBs with gigabytes of media can be written by a
+// server with little memory or disk, e.g. with Use(MemoryFS). Sections are
+// still kept in memory.
+//
+// Each resource is opened twice: once to detect its media type from its first
+// bytes when the files of the EPUB are prepared, and once to copy it when it
+// is zipped. Fetches are retried as set with SetRetryPolicy, but not once the
+// copy has started, and the size limits and expected hashes are checked while
+// copying. Resources that must be processed once fetched aren't streamed: the
+// images when they are optimized or converted, the fonts when they are
+// obfuscated, the audio files when a duration prober is set, the resources
+// added from readers, and all of them when SetContentHashes is enabled.
+func (e *Epub) SetStreamingWrite(enabled bool) {
+	e.Lock()
+	defer e.Unlock()
+	e.streamingWrite = enabled
+}
+
+// streamsMedia returns whether mediaSource, added to the folder
+// mediaFolderName, is copied straight into the archive by Write
+func (e *Epub) streamsMedia(mediaFolderName string, mediaSource string) bool {
+	if !e.streamingWrite || e.contentHashes || detectMediaType(mediaSource) == "Reader" {
+		return false
+	}
+	switch mediaFolderName {
+	case ImageFolderName:
+		return !e.optimizesMedia(mediaFolderName) && e.imageDecoder == nil
+	case FontFolderName:
+		return !e.obfuscatesMedia(mediaFolderName)
+	case AudioFolderName:
+		return e.durationProber == nil
+	}
+	return true
+}
+
+// sniffMedia returns the media type of mediaSource, reading only the first
+// bytes of its content
+func (g grabber) sniffMedia(mediaSource string, mediaFolderName string, mediaFilename string) (string, error) {
+	once := g
+	once.retryPolicy.MaxRetries = 0
+	var head []byte
+	var declared, served string
+	err := g.retry(mediaSource, func() error {
+		source, d, s, err := once.openMedia(mediaSource)
+		if err != nil {
+			return err
+		}
+		defer source.Close()
+		declared, served = d, s
+		head, err = io.ReadAll(io.LimitReader(source, sniffLength))
+		if err != nil {
+			return &FileRetrievalError{Source: mediaSource, Err: err}
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	if declared != "" && !g.verifyMediaTypes {
+		return declared, nil
+	}
+	return g.detectMedia(mediaSource, mediaFolderName, mediaFilename, bytes.NewReader(head), declared, served)
+}
+
+// copyMedia copies the content of a streamed resource to w, checking its size
+// and its hash, and returns the number of bytes copied
+func (g grabber) copyMedia(w io.Writer, m streamedMedia) (int64, error) {
+	once := g
+	once.retryPolicy.MaxRetries = 0
+	var source io.ReadCloser
+	err := g.retry(m.source, func() (err error) {
+		source, _, _, err = once.openMedia(m.source)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	defer source.Close()
+	var src io.Reader = source
+	if g.maxSize > 0 {
+		src = &limitedReader{r: source, max: g.maxSize, source: m.source}
+	}
+	h := sha256.New()
+	if m.checksum != "" {
+		src = io.TeeReader(src, h)
+	}
+	n, err := io.Copy(w, src)
+	if err != nil {
+		return n, &FileRetrievalError{Source: m.source, Err: err}
+	}
+	if m.checksum != "" {
+		if actual := hex.EncodeToString(h.Sum(nil)); actual != m.checksum {
+			return n, &ChecksumMismatchError{Source: m.source, Expected: m.checksum, Actual: actual}
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,133 @@
+package epub
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestSetStreamingWrite(t *testing.T) {
+	if err := Use(MemoryFS); err != nil {
+		t.Fatal(err)
+	}
+	defer Use(OsFS)
+
+	image, err := os.ReadFile(testImageFromFileSource)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var gets int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			atomic.AddInt32(&gets, 1)
+		}
+		w.Write(image)
+	}))
+	defer ts.Close()
+
+	e, err := NewEpub(testEpubTitle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.SetStreamingWrite(true)
+	imagePath, err := e.AddImage(ts.URL+"/image.png", "image.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.AddImage(testImageFromFileSource, "local.png"); err != nil {
+		t.Fatal(err)
+	}
+
+	var b bytes.Buffer
+	if _, err := e.WriteTo(&b); err != nil {
+		t.Fatalf("Error writing EPUB: %s", err)
+	}
+	if gets != 2 {
+		t.Errorf("Expected the remote image to be requested twice, got %d", gets)
+	}
+	r, err := zip.NewReader(bytes.NewReader(b.Bytes()), int64(b.Len()))
+	if err != nil {
+		t.Fatalf("Error reading EPUB: %s", err)
+	}
+	for _, name := range []string{"image.png", "local.png"} {
+		f, err := r.Open(path.Join(contentFolderName, ImageFolderName, name))
+		if err != nil {
+			t.Fatalf("Error opening %s: %s", name, err)
+		}
+		content, err := io.ReadAll(f)
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(content, image) {
+			t.Errorf("Unexpected content of streamed image %s: %d bytes", name, len(content))
+		}
+	}
+	f, err := r.Open(path.Join(contentFolderName, pkgFilename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkg, err := io.ReadAll(f)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(pkg), `href="images/image.png" media-type="image/png"`) {
+		t.Errorf("Streamed image missing from the manifest:\n%s", pkg)
+	}
+
+	// The hash is checked while copying
+	if err := e.SetExpectedSHA256(imagePath, strings.Repeat("0", 64)); err != nil {
+		t.Fatal(err)
+	}
+	_, err = e.WriteTo(io.Discard)
+	var mismatchErr *ChecksumMismatchError
+	if !errors.As(err, &mismatchErr) {
+		t.Errorf("Expected a ChecksumMismatchError, got %v", err)
+	}
+	sum := sha256.Sum256(image)
+	if err := e.SetExpectedSHA256(imagePath, hex.EncodeToString(sum[:])); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.WriteTo(io.Discard); err != nil {
+		t.Errorf("Error writing EPUB with the expected hash: %s", err)
+	}
+
+	e.SetLimits(&Limits{MaxResourceSize: 100})
+	_, err = e.WriteTo(io.Discard)
+	var limitErr *LimitExceededError
+	if !errors.As(err, &limitErr) || limitErr.Limit != "MaxResourceSize" {
+		t.Errorf("Expected MaxResourceSize to be exceeded, got %v", err)
+	}
+}
+
+func TestStreamsMedia(t *testing.T) {
+	e, err := NewEpub(testEpubTitle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.streamsMedia(ImageFolderName, testImageFromFileSource) {
+		t.Error("Image streamed without streaming write")
+	}
+	e.SetStreamingWrite(true)
+	if !e.streamsMedia(ImageFolderName, testImageFromFileSource) {
+		t.Error("Image not streamed")
+	}
+	if e.streamsMedia(ImageFolderName, readerSourcePrefix+"1") {
+		t.Error("Reader streamed")
+	}
+	e.SetContentHashes(true)
+	if e.streamsMedia(ImageFolderName, testImageFromFileSource) {
+		t.Error("Image streamed with content hashes")
+	}
+}
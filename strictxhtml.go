@@ -0,0 +1,69 @@
+package epub
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// MalformedXHTMLError is thrown by AddSection, AddSubSection, InsertSectionAt
+// or UpdateSection in strict mode if the body isn't well-formed XHTML. See
+// SetStrictXHTML.
+type MalformedXHTMLError struct {
+	Filename string // Internal filename of the section
+	// Position in the body where the first mistake was found, starting from 1
+	Line   int
+	Column int
+	Msg    string
+}
+
+func (e *MalformedXHTMLError) Error() string {
+	return fmt.Sprintf("Section %s is not well-formed XHTML at line %d, column %d: %s", e.Filename, e.Line, e.Column, e.Msg)
+}
+
+// SetStrictXHTML sets whether the bodies of the sections are checked to be
+// well-formed XHTML when they are added or updated, so that a mistake is
+// reported right away with its position instead of producing an EPUB that
+// reading systems reject. In strict mode, the methods adding sections return a
+// MalformedXHTMLError if the body isn't well-formed XML, e.g. because of an
+// unclosed element or an HTML named entity such as &nbsp;, which XHTML
+// doesn't define. Strict mode is disabled by default.
+//
+// The body is checked as given, before the transforms and the sanitize
+// profile are applied.
+func (e *Epub) SetStrictXHTML(enabled bool) {
+	e.Lock()
+	defer e.Unlock()
+	e.strictXHTML = enabled
+}
+
+// checkXHTML returns a MalformedXHTMLError if body, the body of the section
+// internalFilename, isn't well-formed XHTML
+func checkXHTML(internalFilename string, body string) error {
+	// The body may hold several root elements, wrap it to parse it as a
+	// document. The wrapper is on its own line so that it doesn't shift the
+	// columns of the first line.
+	d := xml.NewDecoder(strings.NewReader("<body>\n" + body + "\n</body>"))
+	for {
+		_, err := d.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			line, column := d.InputPos()
+			msg := err.Error()
+			var syntaxErr *xml.SyntaxError
+			if errors.As(err, &syntaxErr) {
+				msg = syntaxErr.Msg
+				line = syntaxErr.Line
+			}
+			// Don't count the line of the wrapper
+			if line > 1 {
+				line--
+			}
+			return &MalformedXHTMLError{Filename: internalFilename, Line: line, Column: column, Msg: msg}
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package epub
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSetStrictXHTML(t *testing.T) {
+	e, err := NewEpub(testEpubTitle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	malformed := "<h1>Title</h1>\n<p>One <b>two</p>"
+	if _, err := e.AddSection(malformed, "Lenient", "lenient.xhtml", ""); err != nil {
+		t.Errorf("Malformed body rejected without strict mode: %s", err)
+	}
+
+	e.SetStrictXHTML(true)
+	tests := []struct {
+		body   string
+		line   int
+		column int
+	}{
+		{malformed, 2, 18},
+		{"<p>Non&nbsp;breaking</p>", 1, 13},
+	}
+	for _, test := range tests {
+		_, err := e.AddSection(test.body, "Strict", "strict.xhtml", "")
+		var xhtmlErr *MalformedXHTMLError
+		if !errors.As(err, &xhtmlErr) {
+			t.Errorf("Expected MalformedXHTMLError for %q, got %v", test.body, err)
+			continue
+		}
+		if xhtmlErr.Filename != "strict.xhtml" || xhtmlErr.Line != test.line || xhtmlErr.Column != test.column {
+			t.Errorf("Wrong position for %q: %s", test.body, err)
+		}
+	}
+	if len(e.Sections()) != 1 {
+		t.Errorf("Malformed sections added: %d sections", len(e.Sections()))
+	}
+
+	if _, err := e.AddSection("<p>One &amp; <b>two</b></p>", "Strict", "strict.xhtml", ""); err != nil {
+		t.Errorf("Well-formed body rejected: %s", err)
+	}
+	err = e.UpdateSection("strict.xhtml", "<p>Unclosed")
+	var xhtmlErr *MalformedXHTMLError
+	if !errors.As(err, &xhtmlErr) {
+		t.Errorf("Expected MalformedXHTMLError from UpdateSection, got %v", err)
+	}
+}
@@ -4,9 +4,13 @@ import (
 	"encoding/xml"
 	"fmt"
 	"log"
+	"path"
 	"path/filepath"
 	"regexp"
 	"strconv"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
 )
 
 const (
@@ -22,13 +26,20 @@ const (
 	tocNavItemProperties = "nav"
 	tocNavEpubType       = "toc"
 
-	tocNcxFilename = "toc.ncx"
-	tocNcxItemID   = "ncx"
-	tocNcxTemplate = `
+	tocLandmarksEpubType = "landmarks"
+	tocLandmarksTitle    = "Landmarks"
+	tocPageListEpubType  = "page-list"
+	tocPageListTitle     = "Pages"
+
+	tocNcxFilename  = "toc.ncx"
+	tocNcxUIDMeta   = "dtb:uid"
+	tocNcxDepthMeta = "dtb:depth"
+	tocNcxItemID    = "ncx"
+	tocNcxTemplate  = `
 <ncx xmlns="http://www.daisy.org/z3986/2005/ncx/" version="2005-1">
   <head>
     <meta name="dtb:uid" content="" />
-    <meta name="dtb:depth" content="" />
+    <meta name="dtb:depth" content="0" />
   </head>
   <docTitle>
     <text></text>
@@ -57,13 +68,46 @@ type toc struct {
 	// Spec: http://www.idpf.org/epub/20/spec/OPF_2.0.1_draft.htm#Section2.4.1
 	ncxXML *tocNcxRoot
 
+	// This holds the landmarks nav of the EPUB v3 TOC file, nil if no landmark
+	// is set
+	landmarksXML *tocNavBody
+
+	// This holds the page-list nav of the EPUB v3 TOC file, nil if no page
+	// break is set
+	pageListXML *tocNavBody
+
+	// This holds the body the TOC file is built from, the default markup is
+	// used if empty
+	navTemplate string
+	// This holds the path of the CSS file of the TOC file, relative to it
+	navCSSPath string
+	// This holds the base direction of the TOC file, left to the reading
+	// system if empty
+	navDir string
+
 	title  string // EPUB title
 	author string // EPUB author
+
+	// Entries of the sections added so far, by relative path, so that their
+	// children can be appended without searching the whole TOC
+	sectionEntries map[string]*tocSectionEntry
+	// Number of nested levels of the TOC, entries deeper than that are added
+	// to their ancestor at the last level. 0 means no limit.
+	flattenDepth int
+}
+
+// tocSectionEntry is the entry of a section in both TOC files
+type tocSectionEntry struct {
+	nav    *tocNavItem
+	ncx    *tocNcxNavPoint
+	parent *tocSectionEntry // nil for the top level entries
+	depth  int              // 1 for the top level entries
 }
 
 type tocNavBody struct {
 	XMLName  xml.Name      `xml:"nav"`
 	EpubType string        `xml:"epub:type,attr"`
+	Hidden   string        `xml:"hidden,attr,omitempty"`
 	H1       string        `xml:"h1"`
 	Links    []*tocNavItem `xml:"ol>li"`
 }
@@ -74,18 +118,33 @@ type tocNavItem struct {
 }
 
 type tocNavLink struct {
-	XMLName xml.Name `xml:"a"`
-	Href    string   `xml:"href,attr"`
-	Data    string   `xml:",chardata"`
+	XMLName  xml.Name `xml:"a"`
+	EpubType string   `xml:"epub:type,attr,omitempty"`
+	Href     string   `xml:"href,attr"`
+	Data     string   `xml:",chardata"`
 }
 
 type tocNcxRoot struct {
-	XMLName xml.Name          `xml:"http://www.daisy.org/z3986/2005/ncx/ ncx"`
-	Version string            `xml:"version,attr"`
-	Meta    tocNcxMeta        `xml:"head>meta"`
-	Title   string            `xml:"docTitle>text"`
-	Author  string            `xml:"docAuthor>text"`
-	NavMap  []*tocNcxNavPoint `xml:"navMap>navPoint"`
+	XMLName  xml.Name          `xml:"http://www.daisy.org/z3986/2005/ncx/ ncx"`
+	Version  string            `xml:"version,attr"`
+	Meta     []tocNcxMeta      `xml:"head>meta"`
+	Title    string            `xml:"docTitle>text"`
+	Author   string            `xml:"docAuthor>text"`
+	NavMap   []*tocNcxNavPoint `xml:"navMap>navPoint"`
+	PageList *tocNcxPageList   `xml:"pageList,omitempty"`
+}
+
+type tocNcxPageList struct {
+	Text    string              `xml:"navLabel>text"`
+	Targets []*tocNcxPageTarget `xml:"pageTarget"`
+}
+
+type tocNcxPageTarget struct {
+	ID      string        `xml:"id,attr"`
+	Type    string        `xml:"type,attr"`
+	Value   string        `xml:"value,attr,omitempty"`
+	Text    string        `xml:"navLabel>text"`
+	Content tocNcxContent `xml:"content"`
 }
 
 type tocNcxContent struct {
@@ -148,61 +207,259 @@ func newTocNcxXML() (*tocNcxRoot, error) {
 	return n, nil
 }
 
+// clone returns a copy of the TOC that can be changed independently
+func (t *toc) clone() *toc {
+	c := *t
+	navXML := *t.navXML
+	c.navXML = &navXML
+	ncxXML := *t.ncxXML
+	ncxXML.Meta = append([]tocNcxMeta(nil), t.ncxXML.Meta...)
+	c.ncxXML = &ncxXML
+	c.reset()
+	return &c
+}
+
+// reset removes the entries added while writing the EPUB, so that it can be
+// written again
+func (t *toc) reset() {
+	t.navXML.Links = nil
+	t.ncxXML.NavMap = nil
+	t.ncxXML.PageList = nil
+	t.landmarksXML = nil
+	t.pageListXML = nil
+	t.sectionEntries = nil
+}
+
 // TODO: user should not add -1 as filename
 // Add a section to the TOC (navXML as well as ncxXML)
 func (t *toc) addSubSection(parent string, index int, title string, relativePath string) {
 	relativePath = filepath.ToSlash(relativePath)
-	if parent == "-1" {
+	p, err := t.parentEntry(parent)
+	if err != nil {
+		log.Println(err)
+		return
+	}
 
-		l := &tocNavItem{
-			A: tocNavLink{
-				Href: relativePath,
-				Data: title,
-			},
-			Children: nil,
-		}
+	l := &tocNavItem{
+		A: tocNavLink{
+			Href: relativePath,
+			Data: title,
+		},
+	}
+	np := &tocNcxNavPoint{
+		ID:   "navPoint-" + strconv.Itoa(index),
+		Text: title,
+		Content: tocNcxContent{
+			Src: relativePath,
+		},
+	}
+	if t.sectionEntries == nil {
+		t.sectionEntries = make(map[string]*tocSectionEntry)
+	}
+	t.sectionEntries[relativePath] = &tocSectionEntry{
+		nav:    l,
+		ncx:    np,
+		parent: p,
+		depth:  t.appendEntry(p, l, np),
+	}
+}
 
-		np := &tocNcxNavPoint{
-			ID:   "navPoint-" + strconv.Itoa(index),
-			Text: title,
-			Content: tocNcxContent{
-				Src: relativePath,
-			},
-			Children: nil,
+// addLandmark adds an entry to the landmarks nav
+func (t *toc) addLandmark(epubType string, title string, relativePath string) {
+	if t.landmarksXML == nil {
+		t.landmarksXML = &tocNavBody{
+			EpubType: tocLandmarksEpubType,
+			Hidden:   "hidden",
+			H1:       tocLandmarksTitle,
 		}
-		t.navXML.Links = append(t.navXML.Links, l)
-		t.ncxXML.NavMap = append(t.ncxXML.NavMap, np)
-	} else {
+	}
+	t.landmarksXML.Links = append(t.landmarksXML.Links, &tocNavItem{
+		A: tocNavLink{
+			EpubType: epubType,
+			Href:     filepath.ToSlash(relativePath),
+			Data:     title,
+		},
+	})
+}
 
-		parentRelativePath := filepath.Join(xhtmlFolderName, parent)
+// addPage adds an entry to the page-list nav and to the NCX page list. If
+// kind is empty, the type and value of the NCX page target are guessed from
+// the label.
+func (t *toc) addPage(label string, kind string, value int, relativePath string) {
+	relativePath = filepath.ToSlash(relativePath)
+	if t.pageListXML == nil {
+		t.pageListXML = &tocNavBody{
+			EpubType: tocPageListEpubType,
+			Hidden:   "hidden",
+			H1:       tocPageListTitle,
+		}
+		t.ncxXML.PageList = &tocNcxPageList{Text: tocPageListTitle}
+	}
+	t.pageListXML.Links = append(t.pageListXML.Links, &tocNavItem{
+		A: tocNavLink{
+			Href: relativePath,
+			Data: label,
+		},
+	})
+
+	target := &tocNcxPageTarget{
+		ID:   "pageTarget-" + strconv.Itoa(len(t.ncxXML.PageList.Targets)+1),
+		Type: "special",
+		Text: label,
+		Content: tocNcxContent{
+			Src: relativePath,
+		},
+	}
+	if kind != "" {
+		target.Type = kind
+		if value > 0 {
+			target.Value = strconv.Itoa(value)
+		}
+	} else if _, err := strconv.Atoi(label); err == nil {
+		target.Type = "normal"
+		target.Value = label
+	} else if romanNumeral.MatchString(label) {
+		target.Type = "front"
+	}
+	t.ncxXML.PageList.Targets = append(t.ncxXML.PageList.Targets, target)
+}
 
+// addFragments adds entries pointing to fragments of the section at index to
+// the TOC, as children of the parent section
+func (t *toc) addFragments(parent string, index int, entries []*TOCEntry) {
+	if len(entries) == 0 {
+		return
+	}
+	p, err := t.parentEntry(parent)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	for i, entry := range entries {
+		relativePath := path.Join(xhtmlFolderName, entry.Filename) + "#" + entry.Fragment
 		l := &tocNavItem{
 			A: tocNavLink{
 				Href: relativePath,
-				Data: title,
+				Data: entry.Title,
 			},
 		}
 		np := &tocNcxNavPoint{
-			ID:   "navPoint-" + strconv.Itoa(index),
-			Text: title,
+			ID:   fmt.Sprintf("navPoint-%d-%d", index, i+1),
+			Text: entry.Title,
 			Content: tocNcxContent{
 				Src: relativePath,
 			},
-			Children: nil,
-		}
-		err := navAppender(t.navXML.Links, parentRelativePath, l)
-		if err != nil {
-			log.Println(err)
 		}
-		err = ncxAppender(t.ncxXML.NavMap, parentRelativePath, np)
-		if err != nil {
-			log.Println(err)
+		t.appendEntry(p, l, np)
+	}
+}
+
+// addEntries adds entries to the root of the TOC. Entries pointing to sections
+// for which exists returns false are skipped, their children taking their
+// place.
+func (t *toc) addEntries(entries []*TOCEntry, exists func(filename string) bool) {
+	index := 0
+	var convert func(entries []*TOCEntry) ([]*tocNavItem, []*tocNcxNavPoint)
+	convert = func(entries []*TOCEntry) ([]*tocNavItem, []*tocNcxNavPoint) {
+		var items []*tocNavItem
+		var points []*tocNcxNavPoint
+		for _, entry := range entries {
+			if !exists(entry.Filename) {
+				childItems, childPoints := convert(entry.children)
+				items = append(items, childItems...)
+				points = append(points, childPoints...)
+				continue
+			}
+			index++
+			id := "navPoint-" + strconv.Itoa(index)
+			childItems, childPoints := convert(entry.children)
+			relativePath := path.Join(xhtmlFolderName, entry.Filename)
+			if entry.Fragment != "" {
+				relativePath += "#" + entry.Fragment
+			}
+			items = append(items, &tocNavItem{
+				A: tocNavLink{
+					Href: relativePath,
+					Data: entry.Title,
+				},
+				Children: childItems,
+			})
+			points = append(points, &tocNcxNavPoint{
+				ID:   id,
+				Text: entry.Title,
+				Content: tocNcxContent{
+					Src: relativePath,
+				},
+				Children: childPoints,
+			})
 		}
+		return items, points
 	}
+	items, points := convert(entries)
+	t.navXML.Links = append(t.navXML.Links, items...)
+	t.ncxXML.NavMap = append(t.ncxXML.NavMap, points...)
 }
 
 func (t *toc) setIdentifier(identifier string) {
-	t.ncxXML.Meta.Content = identifier
+	t.setNcxMeta(tocNcxUIDMeta, identifier)
+}
+
+// setNcxMeta sets the content of the meta element of the NCX file with the
+// given name
+func (t *toc) setNcxMeta(name string, content string) {
+	for i := range t.ncxXML.Meta {
+		if t.ncxXML.Meta[i].Name == name {
+			t.ncxXML.Meta[i].Content = content
+			return
+		}
+	}
+	t.ncxXML.Meta = append(t.ncxXML.Meta, tocNcxMeta{Name: name, Content: content})
+}
+
+// parentEntry returns the entry of the section parent to append a child
+// entry to, or nil if the child entry is at the top level. Beyond the maximum
+// depth, the entry of the ancestor at the last level is returned instead.
+func (t *toc) parentEntry(parent string) (*tocSectionEntry, error) {
+	if parent == "-1" {
+		return nil, nil
+	}
+	p, ok := t.sectionEntries[path.Join(xhtmlFolderName, parent)]
+	if !ok {
+		return nil, fmt.Errorf("parent section not found")
+	}
+	// The top level entries have no parent entry, p becomes nil past them
+	for p != nil && t.flattenDepth > 0 && p.depth >= t.flattenDepth {
+		p = p.parent
+	}
+	return p, nil
+}
+
+// appendEntry appends the entries l and np to the TOC files, as children of p
+// or at the top level if p is nil, and returns their depth
+func (t *toc) appendEntry(p *tocSectionEntry, l *tocNavItem, np *tocNcxNavPoint) int {
+	if p == nil {
+		t.navXML.Links = append(t.navXML.Links, l)
+		t.ncxXML.NavMap = append(t.ncxXML.NavMap, np)
+		return 1
+	}
+	p.nav.Children = append(p.nav.Children, l)
+	p.ncx.Children = append(p.ncx.Children, np)
+	return p.depth + 1
+}
+
+// depth returns the number of nested levels of the TOC
+func (t *toc) depth() int {
+	var f func(points []*tocNcxNavPoint) int
+	f = func(points []*tocNcxNavPoint) int {
+		depth := 0
+		for _, np := range points {
+			if d := f(np.Children) + 1; d > depth {
+				depth = d
+			}
+		}
+		return depth
+	}
+	return f(t.ncxXML.NavMap)
 }
 
 func (t *toc) setTitle(title string) {
@@ -224,9 +481,15 @@ func (t *toc) write(tempDir string) error {
 
 // Write the the EPUB v3 TOC file (nav.xhtml) to the temporary directory
 func (t *toc) writeNavDoc(tempDir string) error {
-	navBodyContent, err := xml.MarshalIndent(t.navXML, "    ", "  ")
+	var navBodyContent string
+	var err error
+	if t.navTemplate != "" {
+		navBodyContent, err = t.navTemplateBody()
+	} else {
+		navBodyContent, err = t.navDefaultBody()
+	}
 	if err != nil {
-		return fmt.Errorf("Error marshalling XML for EPUB v3 TOC file: %w\n"+"\tXML=%#v", err, t.navXML)
+		return err
 	}
 
 	// subsection without children itself left an empty tag <ol></ol>
@@ -234,7 +497,7 @@ func (t *toc) writeNavDoc(tempDir string) error {
 	// this regex will remove those line from tocnav.
 	// TODO: find a better solution
 	re := regexp.MustCompile(`\s*<ol>\s*</ol>`)
-	bodyWithoutEmptyTags := re.ReplaceAllString(string(navBodyContent), "")
+	bodyWithoutEmptyTags := re.ReplaceAllString(navBodyContent, "")
 
 	n, err := newXhtml(bodyWithoutEmptyTags)
 	if err != nil {
@@ -242,6 +505,12 @@ func (t *toc) writeNavDoc(tempDir string) error {
 	}
 	n.setXmlnsEpub(xmlnsEpub)
 	n.setTitle(t.title)
+	if t.navCSSPath != "" {
+		n.setCSS(t.navCSSPath)
+	}
+	if t.navDir != "" {
+		n.setDir(t.navDir)
+	}
 
 	navFilePath := filepath.Join(tempDir, contentFolderName, tocNavFilename)
 	err = n.write(navFilePath)
@@ -251,10 +520,86 @@ func (t *toc) writeNavDoc(tempDir string) error {
 	return nil
 }
 
+// navDefaultBody returns the body of the TOC file built from the fixed markup
+func (t *toc) navDefaultBody() (string, error) {
+	navBodyContent, err := xml.MarshalIndent(t.navXML, "    ", "  ")
+	if err != nil {
+		return "", fmt.Errorf("Error marshalling XML for EPUB v3 TOC file: %w\n"+"\tXML=%#v", err, t.navXML)
+	}
+	for _, nav := range t.extraNavs() {
+		content, err := xml.MarshalIndent(nav, "    ", "  ")
+		if err != nil {
+			return "", fmt.Errorf("Error marshalling XML for EPUB v3 %s nav: %w\n"+"\tXML=%#v", nav.EpubType, err, nav)
+		}
+		navBodyContent = append(append(navBodyContent, '\n'), content...)
+	}
+	return string(navBodyContent), nil
+}
+
+// navTemplateBody returns the body of the TOC file built from navTemplate. The
+// list of entries is appended to its toc nav element, and the other navs are
+// inserted after it. If the template has no toc nav element, the default one
+// is appended to the body.
+func (t *toc) navTemplateBody() (string, error) {
+	body, err := parseBody(t.navTemplate)
+	if err != nil {
+		return "", fmt.Errorf("can't parse TOC template: %w", err)
+	}
+	nav := findElement(body, func(n *html.Node) bool {
+		return n.DataAtom == atom.Nav && getAttr(n, "epub:type") == tocNavEpubType
+	})
+
+	var content []byte
+	if nav != nil {
+		content, err = xml.MarshalIndent(struct {
+			XMLName xml.Name      `xml:"ol"`
+			Links   []*tocNavItem `xml:"li"`
+		}{Links: t.navXML.Links}, "", "  ")
+	} else {
+		nav = body
+		content, err = xml.MarshalIndent(t.navXML, "", "  ")
+	}
+	if err != nil {
+		return "", fmt.Errorf("Error marshalling XML for EPUB v3 TOC file: %w\n"+"\tXML=%#v", err, t.navXML)
+	}
+	last, err := insertMarkup(nav, nil, string(content))
+	if err != nil {
+		return "", err
+	}
+	if nav == body {
+		nav = last
+	}
+
+	for _, extra := range t.extraNavs() {
+		content, err := xml.MarshalIndent(extra, "", "  ")
+		if err != nil {
+			return "", fmt.Errorf("Error marshalling XML for EPUB v3 %s nav: %w\n"+"\tXML=%#v", extra.EpubType, err, extra)
+		}
+		last, err := insertMarkup(nav.Parent, nav.NextSibling, string(content))
+		if err != nil {
+			return "", err
+		}
+		nav = last
+	}
+	return renderBody(body)
+}
+
+// extraNavs returns the navs of the TOC file other than the table of contents
+func (t *toc) extraNavs() []*tocNavBody {
+	var navs []*tocNavBody
+	for _, nav := range []*tocNavBody{t.pageListXML, t.landmarksXML} {
+		if nav != nil {
+			navs = append(navs, nav)
+		}
+	}
+	return navs
+}
+
 // Write the EPUB v2 TOC file (toc.ncx) to the temporary directory
 func (t *toc) writeNcxDoc(tempDir string) error {
 	t.ncxXML.Title = t.title
 	t.ncxXML.Author = t.author
+	t.setNcxMeta(tocNcxDepthMeta, strconv.Itoa(max(1, t.depth())))
 
 	ncxFileContent, err := xml.MarshalIndent(t.ncxXML, "", "  ")
 	if err != nil {
@@ -272,39 +617,3 @@ func (t *toc) writeNcxDoc(tempDir string) error {
 	}
 	return nil
 }
-
-// Append tocNcxNavPoint to parent children for toc in Epub v2
-func ncxAppender(t []*tocNcxNavPoint, parentFilename string, targetsection *tocNcxNavPoint) error {
-	// Search for the epubSection with filename equal to parentFilename
-	for _, ncx := range t {
-		if ncx.Content.Src == parentFilename {
-			// Append targetsection as children of the found section
-			ncx.Children = append(ncx.Children, targetsection)
-			return nil
-		}
-		// Recursively check all children of the current section
-		err := ncxAppender(ncx.Children, parentFilename, targetsection)
-		if err == nil {
-			return nil
-		}
-	}
-	return fmt.Errorf("parent section not found")
-}
-
-// Append tocNavItem to parent children for toc in Epub v3
-func navAppender(t []*tocNavItem, parentFilename string, targetsection *tocNavItem) error {
-	// Search for the epubSection with filename equal to parentFilename
-	for _, nav := range t {
-		if nav.A.Href == parentFilename {
-			// Append targetsection as children of the found section
-			nav.Children = append(nav.Children, targetsection)
-			return nil
-		}
-		// Recursively check all children of the current section
-		err := navAppender(nav.Children, parentFilename, targetsection)
-		if err == nil {
-			return nil
-		}
-	}
-	return fmt.Errorf("parent section not found")
-}
@@ -0,0 +1,207 @@
+package epub
+
+import (
+	"fmt"
+	"strings"
+)
+
+// TOCEntry is an entry of a table of contents built with TOC or imported with
+// ImportTOC.
+type TOCEntry struct {
+	Title    string
+	Filename string // Internal filename of the section the entry points to
+	Fragment string // Optional fragment identifier within the section
+	children []*TOCEntry
+}
+
+// Children returns the entries nested in the entry.
+func (entry *TOCEntry) Children() []*TOCEntry {
+	return append([]*TOCEntry{}, entry.children...)
+}
+
+// TOC builds a table of contents independent of the sections, so the
+// navigation structure can diverge from the reading order.
+//
+// By default the table of contents is generated from the sections; once an
+// entry is added with AddEntry, the entries of the TOC are used instead, even
+// after all of them are removed, until Reset is called.
+type TOC struct {
+	e *Epub
+}
+
+// EntryNotInTOCError is thrown by the TOC methods when an entry isn't part of
+// the table of contents.
+type EntryNotInTOCError struct {
+	Title string // The title of the entry
+}
+
+func (e *EntryNotInTOCError) Error() string {
+	return fmt.Sprintf("Entry %q is not in the table of contents", e.Title)
+}
+
+// TOC returns the builder of the table of contents of the EPUB.
+func (e *Epub) TOC() *TOC {
+	return &TOC{e: e}
+}
+
+// Entries returns the entries at the root of the table of contents.
+func (t *TOC) Entries() []*TOCEntry {
+	t.e.Lock()
+	defer t.e.Unlock()
+	return append([]*TOCEntry{}, t.e.customTOC...)
+}
+
+// AddEntry adds an entry pointing to a section, or to a fragment of a section
+// if fragment isn't empty, and returns it. The entry is added after the
+// children of parent, or at the root of the table of contents if parent is
+// nil.
+//
+// The section doesn't have to be added yet; entries pointing to sections
+// missing when the EPUB is written are left out, their children taking their
+// place.
+func (t *TOC) AddEntry(parent *TOCEntry, title string, internalFilename string, fragment string) (*TOCEntry, error) {
+	t.e.Lock()
+	defer t.e.Unlock()
+	entry := &TOCEntry{
+		Title:    title,
+		Filename: internalFilename,
+		Fragment: strings.TrimPrefix(fragment, "#"),
+	}
+	if err := t.e.checkTOCDepth(tocEntryDepth(t.e.customTOC, parent) + 1); err != nil {
+		return nil, err
+	}
+	if err := t.insert(entry, parent); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// Nest moves entry, along with its children, after the children of parent,
+// or at the end of the root of the table of contents if parent is nil.
+func (t *TOC) Nest(entry *TOCEntry, parent *TOCEntry) error {
+	t.e.Lock()
+	defer t.e.Unlock()
+	siblings := t.siblings(entry)
+	if siblings == nil {
+		return &EntryNotInTOCError{Title: entry.Title}
+	}
+	if parent == entry || (parent != nil && findTOCEntry(entry.children, parent) != nil) {
+		return fmt.Errorf("can't nest entry %q in itself", entry.Title)
+	}
+	if parent != nil && findTOCEntry(t.e.customTOC, parent) == nil {
+		return &EntryNotInTOCError{Title: parent.Title}
+	}
+	if err := t.e.checkTOCDepth(tocEntryDepth(t.e.customTOC, parent) + tocEntryHeight(entry)); err != nil {
+		return err
+	}
+	removeTOCEntry(siblings, entry)
+	return t.insert(entry, parent)
+}
+
+// Reorder moves entry to index among its siblings. index must be between 0
+// and the number of siblings minus one.
+func (t *TOC) Reorder(entry *TOCEntry, index int) error {
+	t.e.Lock()
+	defer t.e.Unlock()
+	siblings := t.siblings(entry)
+	if siblings == nil {
+		return &EntryNotInTOCError{Title: entry.Title}
+	}
+	if index < 0 || index >= len(*siblings) {
+		return fmt.Errorf("index %d out of range [0, %d)", index, len(*siblings))
+	}
+	removeTOCEntry(siblings, entry)
+	*siblings = append(*siblings, nil)
+	copy((*siblings)[index+1:], (*siblings)[index:])
+	(*siblings)[index] = entry
+	return nil
+}
+
+// Remove removes entry, along with its children, from the table of contents.
+// Removing the last entry leaves the table of contents empty; use Reset to
+// generate it from the sections again.
+func (t *TOC) Remove(entry *TOCEntry) error {
+	t.e.Lock()
+	defer t.e.Unlock()
+	siblings := t.siblings(entry)
+	if siblings == nil {
+		return &EntryNotInTOCError{Title: entry.Title}
+	}
+	removeTOCEntry(siblings, entry)
+	return nil
+}
+
+// Reset removes all the entries, so the table of contents is generated from
+// the sections again.
+func (t *TOC) Reset() {
+	t.e.Lock()
+	defer t.e.Unlock()
+	t.e.customTOC = nil
+}
+
+// insert adds entry after the children of parent, or at the end of the root
+// if parent is nil
+func (t *TOC) insert(entry *TOCEntry, parent *TOCEntry) error {
+	if parent == nil {
+		t.e.customTOC = append(t.e.customTOC, entry)
+		return nil
+	}
+	if findTOCEntry(t.e.customTOC, parent) == nil {
+		return &EntryNotInTOCError{Title: parent.Title}
+	}
+	parent.children = append(parent.children, entry)
+	return nil
+}
+
+// siblings returns the slice holding entry, or nil if it isn't in the TOC
+func (t *TOC) siblings(entry *TOCEntry) *[]*TOCEntry {
+	var find func(entries *[]*TOCEntry) *[]*TOCEntry
+	find = func(entries *[]*TOCEntry) *[]*TOCEntry {
+		for _, e := range *entries {
+			if e == entry {
+				return entries
+			}
+			if s := find(&e.children); s != nil {
+				return s
+			}
+		}
+		return nil
+	}
+	return find(&t.e.customTOC)
+}
+
+// findTOCEntry returns entry if it is in entries or their descendants, nil
+// otherwise
+func findTOCEntry(entries []*TOCEntry, entry *TOCEntry) *TOCEntry {
+	for _, e := range entries {
+		if e == entry {
+			return e
+		}
+		if found := findTOCEntry(e.children, entry); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+func removeTOCEntry(entries *[]*TOCEntry, entry *TOCEntry) {
+	for i, e := range *entries {
+		if e == entry {
+			*entries = append((*entries)[:i], (*entries)[i+1:]...)
+			return
+		}
+	}
+}
+
+func cloneTOCEntries(entries []*TOCEntry) []*TOCEntry {
+	if entries == nil {
+		return nil
+	}
+	clones := make([]*TOCEntry, len(entries))
+	for i, entry := range entries {
+		c := *entry
+		c.children = cloneTOCEntries(entry.children)
+		clones[i] = &c
+	}
+	return clones
+}
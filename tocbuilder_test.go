@@ -0,0 +1,99 @@
+package epub
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-shiori/go-epub/internal/storage"
+)
+
+func TestTOC(t *testing.T) {
+	e, err := NewEpub(testEpubTitle)
+	if err != nil {
+		t.Error(err)
+	}
+	for _, filename := range []string{"one.xhtml", "two.xhtml", "three.xhtml"} {
+		_, err = e.AddSection(testSectionBody, "Section "+filename, filename, "")
+		if err != nil {
+			t.Errorf("Error adding section: %s", err)
+		}
+	}
+
+	toc := e.TOC()
+	part, err := toc.AddEntry(nil, "Part", "three.xhtml", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	one, err := toc.AddEntry(nil, "One", "one.xhtml", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	two, err := toc.AddEntry(part, "Two", "two.xhtml", "#start")
+	if err != nil {
+		t.Fatal(err)
+	}
+	missing, err := toc.AddEntry(one, "Missing", "missing.xhtml", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := toc.AddEntry(missing, "Nested", "one.xhtml", "nested"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := toc.Nest(one, part); err != nil {
+		t.Errorf("Error nesting entry: %s", err)
+	}
+	if err := toc.Reorder(one, 0); err != nil {
+		t.Errorf("Error reordering entry: %s", err)
+	}
+	if err := toc.Nest(part, two); err == nil {
+		t.Error("Expected an error nesting an entry in its child")
+	}
+	if err := toc.Reorder(two, 2); err == nil {
+		t.Error("Expected an error reordering an entry out of range")
+	}
+	err = toc.Remove(&TOCEntry{Title: "Unknown"})
+	if _, ok := err.(*EntryNotInTOCError); !ok {
+		t.Errorf("Expected error EntryNotInTOCError not returned. Returned instead: %+v", err)
+	}
+
+	entries := toc.Entries()
+	if len(entries) != 1 || entries[0] != part || len(part.Children()) != 2 || part.Children()[0] != one {
+		t.Fatalf("Unexpected TOC structure: %+v", entries)
+	}
+
+	tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+	nav, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, tocNavFilename))
+	if err != nil {
+		t.Errorf("Unexpected error reading nav file: %s", err)
+	}
+	expectedNav := `<ol><li><ahref="xhtml/three.xhtml">Part</a><ol>` +
+		`<li><ahref="xhtml/one.xhtml">One</a><ol><li><ahref="xhtml/one.xhtml#nested">Nested</a></li></ol></li>` +
+		`<li><ahref="xhtml/two.xhtml#start">Two</a></li></ol></li></ol>`
+	if !strings.Contains(strings.Join(strings.Fields(string(nav)), ""), expectedNav) {
+		t.Errorf("Nav file doesn't contain the built TOC\nGot: %s\nExpected: %s", nav, expectedNav)
+	}
+	cleanup(testEpubFilename, tempDir)
+
+	toc.Reset()
+	if len(toc.Entries()) != 0 {
+		t.Errorf("Expected no entries after Reset, got %+v", toc.Entries())
+	}
+
+	// Removing the last entry keeps the empty TOC instead of the generated one
+	only, err := toc.AddEntry(nil, "Only", "one.xhtml", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := toc.Remove(only); err != nil {
+		t.Errorf("Error removing entry: %s", err)
+	}
+	if e.customTOC == nil || len(e.customTOC) != 0 {
+		t.Errorf("Expected an empty custom TOC after removing the last entry, got %+v", e.customTOC)
+	}
+	toc.Reset()
+	if e.customTOC != nil {
+		t.Errorf("Expected no custom TOC after Reset, got %+v", e.customTOC)
+	}
+}
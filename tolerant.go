@@ -0,0 +1,123 @@
+package epub
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Alt text of the images replaced by a placeholder
+const placeholderAlt = "Image unavailable"
+
+// Size in pixels of the placeholder image
+const (
+	placeholderWidth  = 320
+	placeholderHeight = 240
+)
+
+// SetTolerantBuild sets whether Write goes on when a resource can't be
+// retrieved, so that e.g. nightly digest jobs still deliver a readable book
+// when a site is down. The images that can't be retrieved are replaced with a
+// gray placeholder image, and the <img> elements pointing to them without alt
+// text are given "Image unavailable"; the other resources are left out. Each
+// failure is reported as a warning in the BuildReport.
+//
+// The build still fails if the context of WriteContext is done. It is
+// disabled by default.
+func (e *Epub) SetTolerantBuild(enabled bool) {
+	e.Lock()
+	defer e.Unlock()
+	e.tolerant = enabled
+}
+
+// tolerateFetchError returns whether the build goes on despite the failed
+// retrieval of a resource
+func (e *Epub) tolerateFetchError() bool {
+	return e.tolerant && (e.ctx == nil || e.ctx.Err() == nil)
+}
+
+// replaceMedia replaces the resource at mediaFilePath, which couldn't be
+// retrieved because of fetchErr, with a placeholder if it is an image, or
+// removes it otherwise. It returns the media type of the placeholder, or an
+// empty string if the resource is left out.
+func (e *Epub) replaceMedia(mediaFolderName string, mediaFilePath string, fetchErr error) (string, error) {
+	e.spool.remove(mediaFilePath)
+	mediaFilename := filepath.Base(mediaFilePath)
+	if mediaFolderName != ImageFolderName {
+		if err := filesystem.RemoveAll(mediaFilePath); err != nil {
+			return "", err
+		}
+		e.warn("%s left out: %s", mediaFilename, fetchErr)
+		return "", nil
+	}
+
+	if err := filesystem.WriteFile(mediaFilePath, placeholderImage(), filePermissions); err != nil {
+		return "", err
+	}
+	if e.placeholders == nil {
+		e.placeholders = make(map[string]bool)
+	}
+	e.placeholders[mediaFilename] = true
+	e.warn("%s replaced with a placeholder: %s", mediaFilename, fetchErr)
+	return "image/png", nil
+}
+
+// placeholderImage returns the PNG image replacing the images that can't be
+// retrieved
+func placeholderImage() []byte {
+	img := image.NewGray(image.Rect(0, 0, placeholderWidth, placeholderHeight))
+	for y := 0; y < placeholderHeight; y++ {
+		for x := 0; x < placeholderWidth; x++ {
+			c := color.Gray{Y: 0xe0}
+			if x < 2 || y < 2 || x >= placeholderWidth-2 || y >= placeholderHeight-2 {
+				c = color.Gray{Y: 0xa0}
+			}
+			img.SetGray(x, y, c)
+		}
+	}
+	var b bytes.Buffer
+	// Encoding an in-memory image can't fail
+	_ = png.Encode(&b, img)
+	return b.Bytes()
+}
+
+// setPlaceholderAlt gives an alt text to the images of x replaced by a
+// placeholder that have none
+func (e *Epub) setPlaceholderAlt(x *xhtml) {
+	if len(e.placeholders) == 0 {
+		return
+	}
+	body := x.body()
+	found := false
+	for filename := range e.placeholders {
+		if strings.Contains(body, ImageFolderName+"/"+filename) {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return
+	}
+
+	root, err := parseBody(body)
+	if err != nil {
+		return
+	}
+	walk(root, func(n *html.Node) bool {
+		if n.DataAtom == atom.Img && e.placeholders[path.Base(getAttr(n, "src"))] && strings.TrimSpace(getAttr(n, "alt")) == "" {
+			setAttr(n, "alt", placeholderAlt)
+		}
+		return true
+	})
+	// setBody would reset the direction of the body set by the caller
+	if body, err := renderBody(root); err == nil {
+		x.xml.Body.XML = "\n" + body + "\n"
+	}
+}
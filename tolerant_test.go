@@ -0,0 +1,123 @@
+package epub
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/go-shiori/go-epub/internal/storage"
+)
+
+func TestSetTolerantBuild(t *testing.T) {
+	var down atomic.Bool
+	fileServer := http.FileServer(http.Dir("./testdata/"))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if down.Load() {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		fileServer.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	e, err := NewEpub(testEpubTitle)
+	if err != nil {
+		t.Error(err)
+	}
+	imagePath, err := e.AddImage(server.URL+"/gophercolor16x16.png", "")
+	if err != nil {
+		t.Errorf("Error adding image: %s", err)
+	}
+	_, err = e.AddCSS(server.URL+"/cover.css", "")
+	if err != nil {
+		t.Errorf("Error adding CSS: %s", err)
+	}
+	_, err = e.AddSection(`<p><img src="`+imagePath+`"/><img src="`+imagePath+`" alt="Gopher"/></p>`, testSectionTitle, testSectionFilename, "")
+	if err != nil {
+		t.Errorf("Error adding section: %s", err)
+	}
+	down.Store(true)
+
+	if err := e.Write(testEpubFilename); err == nil {
+		t.Error("Expected an error writing an EPUB with resources that can't be retrieved")
+	}
+
+	e.SetTolerantBuild(true)
+	tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+	defer cleanup(testEpubFilename, tempDir)
+
+	if warnings := e.BuildReport().Warnings; len(warnings) != 2 {
+		t.Errorf("Expected a warning for each resource that can't be retrieved, got %v", warnings)
+	}
+	contents, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, xhtmlFolderName, imagePath))
+	if err != nil {
+		t.Errorf("Unexpected error reading placeholder image: %s", err)
+	}
+	if !strings.HasPrefix(string(contents), "\x89PNG") {
+		t.Error("Image not replaced with a PNG placeholder")
+	}
+	if _, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, CSSFolderName, "cover.css")); err == nil {
+		t.Error("CSS file that can't be retrieved not left out")
+	}
+
+	section, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, xhtmlFolderName, testSectionFilename))
+	if err != nil {
+		t.Errorf("Unexpected error reading section file: %s", err)
+	}
+	for _, expected := range []string{
+		`<img src="` + imagePath + `" alt="` + placeholderAlt + `"/>`,
+		`<img src="` + imagePath + `" alt="Gopher"/>`,
+	} {
+		if !strings.Contains(string(section), expected) {
+			t.Errorf("Section doesn't contain %s:\n%s", expected, section)
+		}
+	}
+	pkg, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, pkgFilename))
+	if err != nil {
+		t.Errorf("Unexpected error reading package file: %s", err)
+	}
+	if strings.Contains(string(pkg), "cover.css") {
+		t.Errorf("Package file lists the CSS file left out:\n%s", pkg)
+	}
+}
+
+func TestSetTolerantBuildRTL(t *testing.T) {
+	var down atomic.Bool
+	fileServer := http.FileServer(http.Dir("./testdata/"))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if down.Load() {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		fileServer.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	e, err := NewEpub(testEpubTitle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.SetTolerantBuild(true)
+	e.SetPpd("rtl")
+	imagePath, err := e.AddImage(server.URL+"/gophercolor16x16.png", "cover.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.SetCover(imagePath, ""); err != nil {
+		t.Fatal(err)
+	}
+	down.Store(true)
+	tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+	defer cleanup(testEpubFilename, tempDir)
+
+	cover, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, xhtmlFolderName, e.cover.xhtmlFilename))
+	if err != nil {
+		t.Fatalf("Unexpected error reading cover page: %s", err)
+	}
+	if !strings.Contains(string(cover), `<body dir="rtl">`) {
+		t.Errorf("Direction of the body of the cover page with a placeholder image not kept:\n%s", cover)
+	}
+}
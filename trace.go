@@ -0,0 +1,56 @@
+package epub
+
+// Names of the spans started by an Epub
+const (
+	// A whole Write or WriteTo
+	SpanWrite = "epub.write"
+	// A stage of Write, the "stage" attribute holds its name
+	SpanWriteStage = "epub.write.stage"
+	// The retrieval of a resource, the "source" attribute holds its source
+	SpanFetch = "epub.fetch"
+	// The serialization of a section, the "filename" attribute holds its
+	// internal filename
+	SpanSection = "epub.section"
+)
+
+// Tracer receives the spans of the operations performed by an Epub, so they
+// can be forwarded to a tracing or metrics system such as OpenTelemetry.
+type Tracer interface {
+	// Start is called when an operation starts. name is one of the Span*
+	// constants.
+	Start(name string, attributes map[string]string) Span
+}
+
+// Span is an operation started by a Tracer.
+type Span interface {
+	// End is called when the operation is done, with the error it failed
+	// with, if any.
+	End(err error)
+}
+
+type noopSpan struct{}
+
+func (noopSpan) End(error) {}
+
+// SetTracer sets the tracer notified of the operations performed while writing
+// the EPUB. A nil tracer, the default, disables tracing.
+func (e *Epub) SetTracer(tracer Tracer) {
+	e.Lock()
+	defer e.Unlock()
+	e.tracer = tracer
+}
+
+func (e *Epub) startSpan(name string, attributes map[string]string) Span {
+	if e.tracer == nil {
+		return noopSpan{}
+	}
+	return e.tracer.Start(name, attributes)
+}
+
+// traceStage runs a stage of Write in its own span
+func (e *Epub) traceStage(stage string, f func() error) error {
+	span := e.startSpan(SpanWriteStage, map[string]string{"stage": stage})
+	err := f()
+	span.End(err)
+	return err
+}
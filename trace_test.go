@@ -0,0 +1,79 @@
+package epub
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+)
+
+type testSpan struct {
+	name       string
+	attributes map[string]string
+	ended      bool
+	err        error
+}
+
+func (s *testSpan) End(err error) {
+	s.ended = true
+	s.err = err
+}
+
+type testTracer struct {
+	sync.Mutex
+	spans []*testSpan
+}
+
+func (t *testTracer) Start(name string, attributes map[string]string) Span {
+	t.Lock()
+	defer t.Unlock()
+	s := &testSpan{name: name, attributes: attributes}
+	t.spans = append(t.spans, s)
+	return s
+}
+
+func (t *testTracer) find(name string, key string, value string) *testSpan {
+	for _, s := range t.spans {
+		if s.name == name && s.attributes[key] == value {
+			return s
+		}
+	}
+	return nil
+}
+
+func TestSetTracer(t *testing.T) {
+	e, err := NewEpub(testEpubTitle)
+	if err != nil {
+		t.Error(err)
+	}
+	tracer := &testTracer{}
+	e.SetTracer(tracer)
+
+	_, err = e.AddImage(testImageFromFileSource, testImageFromFileFilename)
+	if err != nil {
+		t.Errorf("Error adding image: %s", err)
+	}
+	_, err = e.AddSection(testSectionBody, testSectionTitle, testSectionFilename, "")
+	if err != nil {
+		t.Errorf("Error adding section: %s", err)
+	}
+
+	var b bytes.Buffer
+	if _, err := e.WriteTo(&b); err != nil {
+		t.Fatal(err)
+	}
+
+	if s := tracer.find(SpanWrite, "", ""); s == nil || !s.ended || s.err != nil {
+		t.Errorf("Expected a successful %s span, got %+v", SpanWrite, s)
+	}
+	for _, stage := range []string{CSSFolderName, ImageFolderName, "sections", "toc", "package", "zip"} {
+		if s := tracer.find(SpanWriteStage, "stage", stage); s == nil || !s.ended {
+			t.Errorf("Expected an ended span for stage %s", stage)
+		}
+	}
+	if s := tracer.find(SpanFetch, "source", testImageFromFileSource); s == nil || !s.ended || s.err != nil {
+		t.Errorf("Expected a successful %s span for %s, got %+v", SpanFetch, testImageFromFileSource, s)
+	}
+	if s := tracer.find(SpanSection, "filename", testSectionFilename); s == nil || !s.ended {
+		t.Errorf("Expected an ended %s span for %s", SpanSection, testSectionFilename)
+	}
+}
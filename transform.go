@@ -0,0 +1,61 @@
+package epub
+
+import (
+	"fmt"
+
+	"golang.org/x/net/html"
+)
+
+// Transform rewrites the body of a section when it is added to the EPUB. body
+// is the content of the section, the markup inside the <body> element, and the
+// transform returns the rewritten content.
+type Transform func(body string) (string, error)
+
+// AddTransforms adds transforms applied, in order, to the bodies of the
+// sections added or updated afterwards. Transforms run before the sanitize
+// profile set with SetSanitizeProfile.
+//
+// The transforms of the package, such as EmbedPlaceholders, parse the body
+// with an HTML5 parser and serialize it back as well-formed XHTML, each one on
+// its own: chaining several of them parses the body several times.
+func (e *Epub) AddTransforms(transforms ...Transform) {
+	e.Lock()
+	defer e.Unlock()
+	e.transforms = append(e.transforms, transforms...)
+}
+
+// processBody applies the transforms and the sanitize profile to the body of
+// a section
+func (e *Epub) processBody(body string) (string, error) {
+	if len(e.transforms) == 0 && e.sanitizeProfile == SanitizeNone {
+		return body, nil
+	}
+
+	for _, transform := range e.transforms {
+		var err error
+		body, err = transform(body)
+		if err != nil {
+			return "", fmt.Errorf("can't transform body: %w", err)
+		}
+	}
+	if e.sanitizeProfile == SanitizeNone {
+		return body, nil
+	}
+	return transformNodes(body, func(root *html.Node) error {
+		sanitize(root, e.sanitizeProfile)
+		return nil
+	})
+}
+
+// transformNodes parses body, applies transform to the parsed <body> element
+// and renders it back
+func transformNodes(body string, transform func(body *html.Node) error) (string, error) {
+	root, err := parseBody(body)
+	if err != nil {
+		return "", err
+	}
+	if err := transform(root); err != nil {
+		return "", err
+	}
+	return renderBody(root)
+}
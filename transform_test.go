@@ -0,0 +1,36 @@
+package epub
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestAddTransforms(t *testing.T) {
+	e, err := NewEpub(testEpubTitle)
+	if err != nil {
+		t.Error(err)
+	}
+	e.SetSanitizeProfile(SanitizeStrict)
+	e.AddTransforms(EmbedPlaceholders)
+
+	// Transforms run before the sanitize profile, so the iframe is replaced
+	// instead of being removed
+	_, err = e.AddSection(`<p>Video:</p><iframe src="https://www.youtube.com/embed/abc"></iframe>`, testSectionTitle, testSectionFilename, "")
+	if err != nil {
+		t.Errorf("Error adding section: %s", err)
+	}
+	body := e.sections[0].xhtml.body()
+	if strings.Contains(body, "iframe") || !strings.Contains(body, embedPlaceholderClass) {
+		t.Errorf("Expected the iframe to be replaced by a placeholder, got %s", body)
+	}
+
+	transformErr := errors.New("transform error")
+	e.AddTransforms(func(body string) (string, error) {
+		return "", transformErr
+	})
+	_, err = e.AddSection(testSectionBody, testSectionTitle, "", "")
+	if !errors.Is(err, transformErr) {
+		t.Errorf("Expected the transform error to be returned, got %v", err)
+	}
+}
@@ -0,0 +1,363 @@
+package epub
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// TranslationFormat is the file format of the strings exchanged with
+// translation tools.
+type TranslationFormat string
+
+const (
+	// TranslationJSON is a JSON array of TranslationUnit.
+	TranslationJSON TranslationFormat = "json"
+	// TranslationXLIFF is an XLIFF 1.2 document. The markup of the strings is
+	// escaped.
+	TranslationXLIFF TranslationFormat = "xliff"
+)
+
+// IDs of the translation units of the metadata
+const (
+	translationIDTitle       = "title"
+	translationIDDescription = "description"
+	translationIDAuthor      = "author"
+)
+
+// Elements whose content is translated as a whole, unless they hold another
+// one of them
+var translatableBlocks = map[atom.Atom]bool{
+	atom.P:          true,
+	atom.H1:         true,
+	atom.H2:         true,
+	atom.H3:         true,
+	atom.H4:         true,
+	atom.H5:         true,
+	atom.H6:         true,
+	atom.Li:         true,
+	atom.Dt:         true,
+	atom.Dd:         true,
+	atom.Td:         true,
+	atom.Th:         true,
+	atom.Caption:    true,
+	atom.Figcaption: true,
+	atom.Blockquote: true,
+}
+
+// TranslationUnit is a user-visible string of the EPUB.
+type TranslationUnit struct {
+	// Identifies the string, e.g. "title" for the title of the EPUB or
+	// "chapter1.xhtml#text-3" for the third paragraph of a section
+	ID string `json:"id"`
+	// The string, as XHTML for the content of the sections
+	Source string `json:"source"`
+	// The translated string, empty if it isn't translated
+	Target string `json:"target,omitempty"`
+}
+
+// TranslationUnits returns the user-visible strings of the EPUB: its title,
+// description and author, the titles of the sections and of the TOC entries,
+// the alternative texts of the images and the content of the paragraphs,
+// headings, list items and table cells of the sections. Preformatted text is
+// left out.
+func (e *Epub) TranslationUnits() []TranslationUnit {
+	e.Lock()
+	defer e.Unlock()
+	var units []TranslationUnit
+	add := func(id string, source string) {
+		if strings.TrimSpace(source) != "" {
+			units = append(units, TranslationUnit{ID: id, Source: source})
+		}
+	}
+	add(translationIDTitle, e.title)
+	add(translationIDDescription, e.desc)
+	add(translationIDAuthor, e.author)
+
+	var addSections func(sections []*epubSection)
+	addSections = func(sections []*epubSection) {
+		for _, s := range sections {
+			add(s.filename+"#title", s.xhtml.Title())
+			for i, entry := range flattenTOCEntries(s.tocEntries) {
+				add(s.filename+"#toc-"+strconv.Itoa(i+1), entry.Title)
+			}
+			if root, err := parseBody(s.xhtml.body()); err == nil {
+				alts, blocks := translatableNodes(root)
+				for i, n := range alts {
+					add(s.filename+"#alt-"+strconv.Itoa(i+1), getAttr(n, "alt"))
+				}
+				for i, n := range blocks {
+					content, err := renderBody(n)
+					if err == nil {
+						add(s.filename+"#text-"+strconv.Itoa(i+1), content)
+					}
+				}
+			}
+			addSections(s.children)
+		}
+	}
+	addSections(e.sections)
+
+	for i, entry := range flattenTOCEntries(e.customTOC) {
+		add("toc-"+strconv.Itoa(i+1), entry.Title)
+	}
+	return units
+}
+
+// ExportStrings writes the strings returned by TranslationUnits to w in the
+// given format, to be translated by a localization pipeline and imported back
+// with ImportStrings.
+func (e *Epub) ExportStrings(w io.Writer, format TranslationFormat) error {
+	units := e.TranslationUnits()
+	switch format {
+	case TranslationJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(units)
+	case TranslationXLIFF:
+		e.Lock()
+		doc := newXliff(e.title, e.lang, "", units)
+		e.Unlock()
+		if _, err := io.WriteString(w, xml.Header); err != nil {
+			return err
+		}
+		enc := xml.NewEncoder(w)
+		enc.Indent("", "  ")
+		return enc.Encode(doc)
+	}
+	return fmt.Errorf("unknown translation format %q", format)
+}
+
+// ImportStrings returns a translated variant of the EPUB, leaving the EPUB
+// unchanged. The strings are read from r in the given format, as written by
+// ExportStrings, and the units with a target replace the matching strings.
+// The language of the variant is set to lang, or to the target language of an
+// XLIFF document if lang is empty.
+//
+// Units that don't match a string of the EPUB anymore, because it changed
+// since the strings were exported, are logged and skipped.
+func (e *Epub) ImportStrings(r io.Reader, format TranslationFormat, lang string) (*Epub, error) {
+	var units []TranslationUnit
+	switch format {
+	case TranslationJSON:
+		if err := json.NewDecoder(r).Decode(&units); err != nil {
+			return nil, fmt.Errorf("can't read translation: %w", err)
+		}
+	case TranslationXLIFF:
+		var doc xliff
+		if err := xml.NewDecoder(r).Decode(&doc); err != nil {
+			return nil, fmt.Errorf("can't read translation: %w", err)
+		}
+		for _, u := range doc.File.Units {
+			units = append(units, TranslationUnit{ID: u.ID, Source: u.Source, Target: u.Target})
+		}
+		if lang == "" {
+			lang = doc.File.TargetLanguage
+		}
+	default:
+		return nil, fmt.Errorf("unknown translation format %q", format)
+	}
+
+	e.Lock()
+	c := e.clone()
+	e.Unlock()
+	if lang != "" {
+		c.SetLang(lang)
+	}
+	if err := c.applyTranslation(units); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// applyTranslation replaces the strings of the EPUB with the targets of the
+// units
+func (e *Epub) applyTranslation(units []TranslationUnit) error {
+	targets := make(map[string]TranslationUnit)
+	for _, u := range units {
+		if u.Target != "" {
+			targets[u.ID] = u
+		}
+	}
+	// translate returns the target of the unit with the given id, if source
+	// is still the string it was exported from
+	translate := func(id string, source string) (string, bool) {
+		u, ok := targets[id]
+		if !ok {
+			return "", false
+		}
+		delete(targets, id)
+		if u.Source != source {
+			log.Printf("Skipping translation of %s: the string changed since it was exported", id)
+			return "", false
+		}
+		return u.Target, true
+	}
+
+	if target, ok := translate(translationIDTitle, e.title); ok {
+		e.SetTitle(target)
+	}
+	if target, ok := translate(translationIDDescription, e.desc); ok {
+		e.SetDescription(target)
+	}
+	if target, ok := translate(translationIDAuthor, e.author); ok {
+		e.SetAuthor(target)
+	}
+
+	var translateSections func(sections []*epubSection) error
+	translateSections = func(sections []*epubSection) error {
+		for _, s := range sections {
+			if target, ok := translate(s.filename+"#title", s.xhtml.Title()); ok {
+				s.xhtml.setTitle(target)
+			}
+			for i, entry := range flattenTOCEntries(s.tocEntries) {
+				if target, ok := translate(s.filename+"#toc-"+strconv.Itoa(i+1), entry.Title); ok {
+					entry.Title = target
+				}
+			}
+			if err := translateBody(s, translate); err != nil {
+				return err
+			}
+			if err := translateSections(s.children); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := translateSections(e.sections); err != nil {
+		return err
+	}
+
+	for i, entry := range flattenTOCEntries(e.customTOC) {
+		if target, ok := translate("toc-"+strconv.Itoa(i+1), entry.Title); ok {
+			entry.Title = target
+		}
+	}
+	for id := range targets {
+		log.Printf("Skipping translation of %s: no such string", id)
+	}
+	return nil
+}
+
+// translateBody replaces the alternative texts and the content of the blocks of
+// a section
+func translateBody(s *epubSection, translate func(id string, source string) (string, bool)) error {
+	root, err := parseBody(s.xhtml.body())
+	if err != nil {
+		return err
+	}
+	changed := false
+	alts, blocks := translatableNodes(root)
+	for i, n := range alts {
+		if target, ok := translate(s.filename+"#alt-"+strconv.Itoa(i+1), getAttr(n, "alt")); ok {
+			removeAttr(n, func(a html.Attribute) bool {
+				return a.Namespace == "" && a.Key == "alt"
+			})
+			n.Attr = append(n.Attr, html.Attribute{Key: "alt", Val: target})
+			changed = true
+		}
+	}
+	for i, n := range blocks {
+		source, err := renderBody(n)
+		if err != nil {
+			return err
+		}
+		target, ok := translate(s.filename+"#text-"+strconv.Itoa(i+1), source)
+		if !ok {
+			continue
+		}
+		for c := n.FirstChild; c != nil; c = n.FirstChild {
+			n.RemoveChild(c)
+		}
+		if _, err := insertMarkup(n, nil, target); err != nil {
+			return fmt.Errorf("can't translate %s: %w", s.filename, err)
+		}
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+	body, err := renderBody(root)
+	if err != nil {
+		return err
+	}
+	s.xhtml.setBody(body)
+	return nil
+}
+
+// translatableNodes returns the images with an alternative text and the
+// blocks whose content is translated, in document order
+func translatableNodes(root *html.Node) (alts []*html.Node, blocks []*html.Node) {
+	var containsBlock func(n *html.Node) bool
+	containsBlock = func(n *html.Node) bool {
+		return findElement(n, func(c *html.Node) bool {
+			return translatableBlocks[c.DataAtom]
+		}) != nil
+	}
+	walk(root, func(n *html.Node) bool {
+		switch {
+		case n.DataAtom == atom.Pre:
+			return false
+		case n.DataAtom == atom.Img && strings.TrimSpace(getAttr(n, "alt")) != "":
+			alts = append(alts, n)
+		case translatableBlocks[n.DataAtom] && !containsBlock(n) && textContent(n) != "":
+			blocks = append(blocks, n)
+		}
+		return true
+	})
+	return alts, blocks
+}
+
+// flattenTOCEntries returns the entries and their children in pre-order
+func flattenTOCEntries(entries []*TOCEntry) []*TOCEntry {
+	var flat []*TOCEntry
+	for _, entry := range entries {
+		flat = append(flat, entry)
+		flat = append(flat, flattenTOCEntries(entry.children)...)
+	}
+	return flat
+}
+
+// An XLIFF 1.2 document
+type xliff struct {
+	XMLName xml.Name  `xml:"urn:oasis:names:tc:xliff:document:1.2 xliff"`
+	Version string    `xml:"version,attr"`
+	File    xliffFile `xml:"file"`
+}
+
+type xliffFile struct {
+	Original       string      `xml:"original,attr"`
+	SourceLanguage string      `xml:"source-language,attr"`
+	TargetLanguage string      `xml:"target-language,attr,omitempty"`
+	Datatype       string      `xml:"datatype,attr"`
+	Units          []xliffUnit `xml:"body>trans-unit"`
+}
+
+type xliffUnit struct {
+	ID     string `xml:"id,attr"`
+	Source string `xml:"source"`
+	Target string `xml:"target,omitempty"`
+}
+
+func newXliff(original string, sourceLanguage string, targetLanguage string, units []TranslationUnit) *xliff {
+	doc := &xliff{
+		Version: "1.2",
+		File: xliffFile{
+			Original:       original,
+			SourceLanguage: sourceLanguage,
+			TargetLanguage: targetLanguage,
+			Datatype:       "html",
+		},
+	}
+	for _, u := range units {
+		doc.File.Units = append(doc.File.Units, xliffUnit{ID: u.ID, Source: u.Source, Target: u.Target})
+	}
+	return doc
+}
@@ -0,0 +1,129 @@
+package epub
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func newTranslatableEpub(t *testing.T) *Epub {
+	e, err := NewEpub("Title")
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.SetDescription("Description")
+	_, err = e.AddSection(`<h1 id="h">Heading</h1><p>One <em>two</em></p><pre>code</pre>`+
+		`<ul><li><p>Item</p></li></ul><img src="../images/a.png" alt="Picture"/><p> </p>`,
+		"Chapter 1", "chapter1.xhtml", "")
+	if err != nil {
+		t.Fatalf("Error adding section: %s", err)
+	}
+	if err := e.AddTOCEntry("chapter1.xhtml", "h", "Heading entry"); err != nil {
+		t.Fatalf("Error adding TOC entry: %s", err)
+	}
+	return e
+}
+
+func TestTranslationUnits(t *testing.T) {
+	e := newTranslatableEpub(t)
+	expected := []TranslationUnit{
+		{ID: "title", Source: "Title"},
+		{ID: "description", Source: "Description"},
+		{ID: "chapter1.xhtml#title", Source: "Chapter 1"},
+		{ID: "chapter1.xhtml#toc-1", Source: "Heading entry"},
+		{ID: "chapter1.xhtml#alt-1", Source: "Picture"},
+		{ID: "chapter1.xhtml#text-1", Source: "Heading"},
+		{ID: "chapter1.xhtml#text-2", Source: "One <em>two</em>"},
+		{ID: "chapter1.xhtml#text-3", Source: "Item"},
+	}
+	if units := e.TranslationUnits(); !reflect.DeepEqual(units, expected) {
+		t.Errorf("Got units\n%+v\nexpected\n%+v", units, expected)
+	}
+}
+
+func TestImportStrings(t *testing.T) {
+	e := newTranslatableEpub(t)
+	var b bytes.Buffer
+	if err := e.ExportStrings(&b, TranslationJSON); err != nil {
+		t.Fatalf("Error exporting strings: %s", err)
+	}
+	var units []TranslationUnit
+	if err := json.Unmarshal(b.Bytes(), &units); err != nil {
+		t.Fatal(err)
+	}
+	for i := range units {
+		units[i].Target = strings.ToUpper(units[i].Source)
+	}
+	// Stale and unknown units are skipped
+	units[0].Source = "Old title"
+	units = append(units, TranslationUnit{ID: "doesNotExist", Source: "x", Target: "y"})
+	translation, err := json.Marshal(units)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := e.ImportStrings(bytes.NewReader(translation), TranslationJSON, "fr")
+	if err != nil {
+		t.Fatalf("Error importing strings: %s", err)
+	}
+	if c.Title() != "Title" || c.Description() != "DESCRIPTION" || c.Lang() != "fr" {
+		t.Errorf("Metadata not translated: %q %q %q", c.Title(), c.Description(), c.Lang())
+	}
+	s := findSection(c.sections, "chapter1.xhtml")
+	expectedBody := `<h1 id="h">HEADING</h1><p>ONE <em>TWO</em></p><pre>code</pre>` +
+		`<ul><li><p>ITEM</p></li></ul><img src="../images/a.png" alt="PICTURE"/><p> </p>`
+	if s.xhtml.Title() != "CHAPTER 1" || s.tocEntries[0].Title != "HEADING ENTRY" || strings.TrimSpace(s.xhtml.body()) != expectedBody {
+		t.Errorf("Section not translated: %q %q\n%s", s.xhtml.Title(), s.tocEntries[0].Title, s.xhtml.body())
+	}
+
+	// The source EPUB is unchanged
+	if e.Description() != "Description" || e.Lang() != defaultEpubLang {
+		t.Errorf("Source EPUB metadata changed: %q %q", e.Description(), e.Lang())
+	}
+	if s := findSection(e.sections, "chapter1.xhtml"); s.xhtml.Title() != "Chapter 1" || s.tocEntries[0].Title != "Heading entry" || strings.Contains(s.xhtml.body(), "HEADING") {
+		t.Errorf("Source EPUB section changed: %+v", s)
+	}
+	if _, err := c.WriteTo(&bytes.Buffer{}); err != nil {
+		t.Errorf("Error writing translated EPUB: %s", err)
+	}
+}
+
+func TestImportStringsXLIFF(t *testing.T) {
+	e := newTranslatableEpub(t)
+	var b bytes.Buffer
+	if err := e.ExportStrings(&b, TranslationXLIFF); err != nil {
+		t.Fatalf("Error exporting strings: %s", err)
+	}
+	if !strings.Contains(b.String(), `<source>One &lt;em&gt;two&lt;/em&gt;</source>`) {
+		t.Errorf("Unexpected XLIFF document:\n%s", b.String())
+	}
+
+	var doc xliff
+	if err := xml.Unmarshal(b.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	doc.File.TargetLanguage = "de"
+	for i, u := range doc.File.Units {
+		if u.ID == "chapter1.xhtml#text-2" {
+			doc.File.Units[i].Target = "Eins <em>zwei</em>"
+		}
+	}
+	translation, err := xml.Marshal(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := e.ImportStrings(bytes.NewReader(translation), TranslationXLIFF, "")
+	if err != nil {
+		t.Fatalf("Error importing strings: %s", err)
+	}
+	if c.Lang() != "de" || !strings.Contains(findSection(c.sections, "chapter1.xhtml").xhtml.body(), "<p>Eins <em>zwei</em></p>") {
+		t.Errorf("Translation not imported: %q\n%s", c.Lang(), findSection(c.sections, "chapter1.xhtml").xhtml.body())
+	}
+
+	if _, err := e.ImportStrings(strings.NewReader("[]"), "po", ""); err == nil {
+		t.Error("Expected an error importing an unknown format")
+	}
+}
@@ -0,0 +1,326 @@
+package epub
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+)
+
+// FindingKind is the kind of a problem found by Validate.
+type FindingKind string
+
+// Kinds of the findings returned by Validate
+const (
+	// The mimetype file is missing, isn't first, compressed or wrong
+	FindingMimetype FindingKind = "mimetype"
+	// An XHTML file isn't well-formed XML
+	FindingMalformedXHTML FindingKind = "malformed-xhtml"
+	// A manifest item points to a file missing from the EPUB
+	FindingMissingFile FindingKind = "missing-file"
+	// A file of the EPUB isn't listed in the manifest
+	FindingUnlistedFile FindingKind = "unlisted-file"
+	// The spine refers to an item missing from the manifest or not readable
+	// on its own, or the navigation document is missing
+	FindingSpine FindingKind = "spine"
+	// A link or a reference to a resource points to a file or a fragment
+	// that doesn't exist
+	FindingBrokenLink FindingKind = "broken-link"
+	// An ID is used more than once in a file or in the manifest
+	FindingDuplicateID FindingKind = "duplicate-id"
+	// The cover image isn't marked as such in the manifest
+	FindingCover FindingKind = "cover"
+)
+
+// Severity is how serious a Finding is.
+type Severity int
+
+const (
+	// The EPUB is invalid and may be rejected by stores or break reading
+	// systems
+	SeverityError Severity = iota
+	// The EPUB is valid but may not work as intended
+	SeverityWarning
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityError:
+		return "error"
+	case SeverityWarning:
+		return "warning"
+	}
+	return "unknown"
+}
+
+// Finding is a problem found by Validate.
+type Finding struct {
+	Kind     FindingKind
+	Severity Severity
+	// Path of the file concerned relative to the root of the EPUB, e.g.
+	// EPUB/xhtml/section0001.xhtml, empty for the EPUB itself
+	File string
+	// Line of the problem in File, 0 if unknown
+	Line    int
+	Message string
+}
+
+func (f Finding) String() string {
+	location := f.File
+	if f.Line > 0 {
+		location = fmt.Sprintf("%s:%d", f.File, f.Line)
+	}
+	if location == "" {
+		return fmt.Sprintf("%s: %s: %s", f.Severity, f.Kind, f.Message)
+	}
+	return fmt.Sprintf("%s: %s: %s: %s", location, f.Severity, f.Kind, f.Message)
+}
+
+// Validate writes the EPUB in memory and checks the problems epubcheck
+// commonly reports, so that they are found without a Java toolchain:
+// malformed XHTML, inconsistencies between the files, the manifest and the
+// spine, broken internal links, duplicate IDs and a cover image missing its
+// manifest property. The resources are fetched as by Write, and an error is
+// returned if the EPUB can't be written. A nil slice means no problem was
+// found; it doesn't replace epubcheck for the checks it doesn't perform.
+func (e *Epub) Validate() ([]Finding, error) {
+	return e.ValidateContext(context.Background())
+}
+
+// ValidateContext is like Validate, stopping when ctx is done as
+// WriteToContext does.
+func (e *Epub) ValidateContext(ctx context.Context) ([]Finding, error) {
+	var b bytes.Buffer
+	if _, err := e.WriteToContext(ctx, &b); err != nil {
+		return nil, err
+	}
+	er, err := newEpubReader(bytes.NewReader(b.Bytes()), int64(b.Len()))
+	if err != nil {
+		return nil, err
+	}
+	return er.validate(), nil
+}
+
+// xhtmlDocument is what validate needs of an XHTML file
+type xhtmlDocument struct {
+	ids   map[string]bool
+	links []documentLink
+}
+
+// documentLink is a reference from an XHTML file to another file, e.g. the href
+// of an <a> or the src of an <img>
+type documentLink struct {
+	ref  string
+	line int
+}
+
+// validate checks the EPUB read by er
+func (er *epubReader) validate() []Finding {
+	var findings []Finding
+	add := func(kind FindingKind, severity Severity, file string, line int, format string, a ...interface{}) {
+		findings = append(findings, Finding{
+			Kind:     kind,
+			Severity: severity,
+			File:     file,
+			Line:     line,
+			Message:  fmt.Sprintf(format, a...),
+		})
+	}
+
+	files := make(map[string]bool, len(er.zip.File))
+	for i, f := range er.zip.File {
+		files[f.Name] = true
+		if f.Name != mimetypeFilename {
+			continue
+		}
+		if i != 0 || f.Method != 0 {
+			add(FindingMimetype, SeverityError, f.Name, 0, "must be the first file of the archive, stored without compression")
+		}
+		if data, err := er.readFile(f.Name); err != nil || string(data) != mediaTypeEpub {
+			add(FindingMimetype, SeverityError, f.Name, 0, "must contain %q", mediaTypeEpub)
+		}
+	}
+	if !files[mimetypeFilename] {
+		add(FindingMimetype, SeverityError, "", 0, "mimetype file missing")
+	}
+
+	// Manifest
+	items := make(map[string]pkgItem, len(er.pkg.ManifestItems))
+	// The key is the path of the item relative to the root of the EPUB
+	itemsByPath := make(map[string]pkgItem, len(er.pkg.ManifestItems))
+	navItems := 0
+	for _, item := range er.pkg.ManifestItems {
+		if _, ok := items[item.ID]; ok {
+			add(FindingDuplicateID, SeverityError, er.pkgPath, 0, "manifest item ID %q used more than once", item.ID)
+		}
+		items[item.ID] = item
+		if hasProperty(item.Properties, tocNavItemProperties) {
+			navItems++
+		}
+		if isRemote(item.Href) {
+			continue
+		}
+		p := er.itemPath(item)
+		itemsByPath[p] = item
+		if !files[p] {
+			add(FindingMissingFile, SeverityError, er.pkgPath, 0, "file %s of manifest item %q missing", p, item.ID)
+		}
+	}
+	if navItems != 1 {
+		add(FindingSpine, SeverityError, er.pkgPath, 0, "the manifest must have exactly one navigation document, found %d", navItems)
+	}
+	for _, f := range er.zip.File {
+		if f.Name == mimetypeFilename || f.Name == er.pkgPath || strings.HasPrefix(f.Name, metaInfFolderName+"/") || strings.HasSuffix(f.Name, "/") {
+			continue
+		}
+		if _, ok := itemsByPath[f.Name]; !ok {
+			add(FindingUnlistedFile, SeverityWarning, f.Name, 0, "file not listed in the manifest")
+		}
+	}
+
+	// Spine
+	inSpine := make(map[string]bool)
+	for _, ref := range er.pkg.Spine.Items {
+		item, ok := items[ref.Idref]
+		if !ok {
+			add(FindingSpine, SeverityError, er.pkgPath, 0, "spine item %q missing from the manifest", ref.Idref)
+			continue
+		}
+		if inSpine[ref.Idref] {
+			add(FindingSpine, SeverityError, er.pkgPath, 0, "spine item %q listed more than once", ref.Idref)
+		}
+		inSpine[ref.Idref] = true
+		if item.MediaType != mediaTypeXhtml && item.MediaType != "image/svg+xml" && item.Fallback == "" {
+			add(FindingSpine, SeverityError, er.pkgPath, 0, "spine item %q has media type %s without a fallback", ref.Idref, item.MediaType)
+		}
+	}
+
+	// Cover
+	coverID := ""
+	for _, m := range er.pkg.Metadata.Meta {
+		if m.Name == "cover" {
+			coverID = m.Content
+		}
+	}
+	for _, item := range er.pkg.ManifestItems {
+		if hasProperty(item.Properties, coverImageProperties) && !strings.HasPrefix(item.MediaType, "image/") {
+			add(FindingCover, SeverityError, er.pkgPath, 0, "item %q marked as cover image isn't an image", item.ID)
+		}
+		if item.ID == coverID && !hasProperty(item.Properties, coverImageProperties) {
+			add(FindingCover, SeverityWarning, er.pkgPath, 0, "cover item %q lacks the %s property", item.ID, coverImageProperties)
+		}
+	}
+
+	// XHTML files, in the order of the manifest
+	documents := make(map[string]*xhtmlDocument)
+	var paths []string
+	for _, item := range er.pkg.ManifestItems {
+		p := er.itemPath(item)
+		if item.MediaType != mediaTypeXhtml || isRemote(item.Href) || !files[p] {
+			continue
+		}
+		data, err := er.readFile(p)
+		if err != nil {
+			add(FindingMissingFile, SeverityError, p, 0, "%s", err)
+			continue
+		}
+		doc, err := parseXHTMLDocument(data, func(id string, line int) {
+			add(FindingDuplicateID, SeverityError, p, line, "ID %q used more than once", id)
+		})
+		if err != nil {
+			line := 0
+			var syntaxErr *xml.SyntaxError
+			if errors.As(err, &syntaxErr) {
+				line = syntaxErr.Line
+				err = errors.New(syntaxErr.Msg)
+			}
+			add(FindingMalformedXHTML, SeverityError, p, line, "%s", err)
+		}
+		documents[p] = doc
+		paths = append(paths, p)
+	}
+
+	// Links
+	for _, p := range paths {
+		for _, link := range documents[p].links {
+			target, fragment, ok := resolveLink(p, link.ref)
+			if !ok {
+				continue
+			}
+			if target == p && fragment == "" {
+				continue
+			}
+			if _, listed := itemsByPath[target]; !listed {
+				add(FindingBrokenLink, SeverityError, p, link.line, "%s points to %s, missing from the manifest", link.ref, target)
+				continue
+			}
+			if doc, ok := documents[target]; ok && fragment != "" && !doc.ids[fragment] {
+				add(FindingBrokenLink, SeverityError, p, link.line, "%s points to the missing fragment %q of %s", link.ref, fragment, target)
+			}
+		}
+	}
+
+	sort.SliceStable(findings, func(i, j int) bool {
+		return findings[i].Severity < findings[j].Severity
+	})
+	return findings
+}
+
+// parseXHTMLDocument reads the IDs and links of an XHTML file, calling
+// duplicate with the IDs used more than once. It returns what was read before
+// the first syntax error, if any.
+func parseXHTMLDocument(data []byte, duplicate func(id string, line int)) (*xhtmlDocument, error) {
+	doc := &xhtmlDocument{ids: make(map[string]bool)}
+	d := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		t, err := d.Token()
+		if err == io.EOF {
+			return doc, nil
+		}
+		if err != nil {
+			return doc, err
+		}
+		start, ok := t.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		line, _ := d.InputPos()
+		for _, attr := range start.Attr {
+			switch {
+			case attr.Name.Local == "id" && (attr.Name.Space == "" || attr.Name.Space == "xml"):
+				if doc.ids[attr.Value] {
+					duplicate(attr.Value, line)
+				}
+				doc.ids[attr.Value] = true
+			case attr.Name.Local == "href" || attr.Name.Local == "src" || attr.Name.Local == "poster":
+				doc.links = append(doc.links, documentLink{ref: attr.Value, line: line})
+			}
+		}
+	}
+}
+
+// resolveLink returns the path relative to the root of the EPUB of the file
+// ref points to from the file at base, and its fragment identifier. ok is
+// false for the references that aren't to a file of the EPUB, such as URLs.
+func resolveLink(base string, ref string) (target string, fragment string, ok bool) {
+	u, err := url.Parse(strings.TrimSpace(ref))
+	if err != nil || u.Scheme != "" || u.Host != "" || strings.HasPrefix(u.Path, "/") {
+		return "", "", false
+	}
+	if u.Path == "" {
+		return base, u.Fragment, true
+	}
+	return path.Join(path.Dir(base), u.Path), u.Fragment, true
+}
+
+// isRemote returns true if href is an absolute URL
+func isRemote(href string) bool {
+	u, err := url.Parse(href)
+	return err == nil && u.Scheme != ""
+}
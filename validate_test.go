@@ -0,0 +1,61 @@
+package epub
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	e, err := NewEpub(testEpubTitle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	imagePath, err := e.AddImage(testImageFromFileSource, "image.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.SetCover(imagePath, ""); err != nil {
+		t.Fatal(err)
+	}
+	body := `<h1 id="top">Title</h1><p><img src="` + imagePath + `" alt="" /></p><p><a href="section0002.xhtml#part">Next</a></p>`
+	if _, err := e.AddSection(body, "One", "section0001.xhtml", ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.AddSection(`<p id="part">Part</p><p><a href="section0001.xhtml#top">Back</a> <a href="https://example.com/">Out</a></p>`, "Two", "section0002.xhtml", ""); err != nil {
+		t.Fatal(err)
+	}
+	findings, err := e.Validate()
+	if err != nil {
+		t.Fatalf("Error validating EPUB: %s", err)
+	}
+	if len(findings) != 0 {
+		t.Errorf("Unexpected findings for a valid EPUB: %v", findings)
+	}
+
+	if _, err := e.AddSection(`<p id="x">One</p><p id="x"><a href="missing.xhtml">Missing</a> <a href="section0002.xhtml#nowhere">Nowhere</a></p><img src="../images/missing.png" alt="" />`, "Three", "section0003.xhtml", ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.AddSection(`<p>Non&nbsp;breaking</p>`, "Four", "section0004.xhtml", ""); err != nil {
+		t.Fatal(err)
+	}
+	findings, err = e.Validate()
+	if err != nil {
+		t.Fatalf("Error validating EPUB: %s", err)
+	}
+	found := make(map[FindingKind]int)
+	for _, f := range findings {
+		found[f.Kind]++
+		if f.Severity != SeverityError {
+			t.Errorf("Unexpected severity of %s", f)
+		}
+		if !strings.HasPrefix(f.File, contentFolderName+"/"+xhtmlFolderName+"/") {
+			t.Errorf("Unexpected file of %s", f)
+		}
+		if f.Line == 0 {
+			t.Errorf("Missing line of %s", f)
+		}
+	}
+	if found[FindingDuplicateID] != 1 || found[FindingBrokenLink] != 3 || found[FindingMalformedXHTML] != 1 || len(findings) != 5 {
+		t.Errorf("Unexpected findings: %v", findings)
+	}
+}
@@ -0,0 +1,193 @@
+package epub
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"io"
+	"mime"
+	"path"
+	"strings"
+
+	"github.com/gabriel-vasile/mimetype"
+)
+
+// WarningKind is the kind of a non-fatal problem found while adding content.
+type WarningKind string
+
+// Kinds of the warnings returned by Warnings
+const (
+	// The content of a resource doesn't match its extension or the Add*
+	// method used, e.g. an HTML page added with AddImage
+	WarningMediaType WarningKind = "media-type"
+	// An image is bigger than most reading systems handle well, see
+	// SetImageOptimization
+	WarningLargeImage WarningKind = "large-image"
+	// A section or the EPUB has an empty title
+	WarningEmptyTitle WarningKind = "empty-title"
+	// A source is linked by several resources, each stored in the EPUB, see
+	// SetResourceDeduplication
+	WarningDuplicateLink WarningKind = "duplicate-link"
+	// A file of an EPUB read by Open can't be kept and was left out
+	WarningSkippedFile WarningKind = "skipped-file"
+	// A declaration of a stylesheet or a section isn't supported by the
+	// reading systems the EPUB is made for, see Kindle
+	WarningUnsupportedCSS WarningKind = "unsupported-css"
+	// The cover image doesn't meet the requirements set with
+	// SetCoverRequirements, outside of strict mode
+	WarningCoverRequirements WarningKind = "cover-requirements"
+	// A link of a volume returned by Split points to a section of another
+	// volume
+	WarningCrossVolumeLink WarningKind = "cross-volume-link"
+	// An image of a web page added with AddSectionFromURL, of a section
+	// converted from Markdown or of an email can't be retrieved and is left
+	// unchanged
+	WarningMissingImage WarningKind = "missing-image"
+)
+
+// Thresholds above which an image is reported as large
+const (
+	largeImagePixels = 4096 * 4096
+	largeImageBytes  = 10 << 20
+)
+
+// Number of bytes read to detect the media type of a resource
+const sniffLength = 3072
+
+// Warning is a non-fatal problem found while adding content to an EPUB, which
+// would otherwise only show when the EPUB is validated or read.
+type Warning struct {
+	Kind WarningKind
+	// Source of the resource or internal filename of the section concerned,
+	// empty for the EPUB itself
+	Source  string
+	Message string
+}
+
+func (w Warning) String() string {
+	return fmt.Sprintf("%s: %s", w.Kind, w.Message)
+}
+
+// Warnings returns the non-fatal problems found so far while adding content,
+// in the order they were found. The problems found while writing are in the
+// BuildReport instead.
+func (e *Epub) Warnings() []Warning {
+	e.Lock()
+	defer e.Unlock()
+	return append([]Warning(nil), e.warnings...)
+}
+
+// SetWarningHandler sets a function called with each problem found while
+// adding content, as soon as it is found, so that interactive tools can show
+// it right away. The handler is called with the Epub locked and must not call
+// its methods. The warnings are still returned by Warnings. A nil handler
+// removes it.
+func (e *Epub) SetWarningHandler(handler func(Warning)) {
+	e.Lock()
+	defer e.Unlock()
+	e.warningHandler = handler
+}
+
+// addWarning records a problem found while adding content
+func (e *Epub) addWarning(kind WarningKind, source string, format string, a ...interface{}) {
+	w := Warning{Kind: kind, Source: source, Message: fmt.Sprintf(format, a...)}
+	e.warnings = append(e.warnings, w)
+	if e.warningHandler != nil {
+		e.warningHandler(w)
+	}
+}
+
+// checkTitle reports an empty title of a section, or of the EPUB if filename
+// is empty
+func (e *Epub) checkTitle(filename string, title string) {
+	if strings.TrimSpace(title) != "" {
+		return
+	}
+	if filename == "" {
+		e.addWarning(WarningEmptyTitle, "", "the EPUB has an empty title")
+		return
+	}
+	e.addWarning(WarningEmptyTitle, filename, "section %s has an empty title", filename)
+}
+
+// checkDuplicateLink reports a source already added to mediaMap
+func (e *Epub) checkDuplicateLink(source string, mediaFolderName string, mediaMap map[string]string) {
+	for filename, s := range mediaMap {
+		if s == source {
+			e.addWarning(WarningDuplicateLink, source, "%s is already added as %s", source, path.Join(mediaFolderName, filename))
+			return
+		}
+	}
+}
+
+// checkResource reports the problems of the content of a resource just added.
+// Only local files and data URLs are read, as fetching remote resources is
+// left to Write.
+func (e *Epub) checkResource(g grabber, source string, internalPath string, mediaFolderName string) {
+	if kind := detectMediaType(source); (kind != "File" && kind != "DataURL") || g.fetcher(source) != nil {
+		return
+	}
+	r, err := g.open(source)
+	if err != nil {
+		return
+	}
+	defer r.Close()
+	head := make([]byte, sniffLength)
+	n, _ := io.ReadFull(r, head)
+	head = head[:n]
+
+	detected := mimetype.Detect(head)
+	if !mediaTypeFits(detected, mediaFolderName) {
+		e.addWarning(WarningMediaType, source, "%s has media type %s, unexpected in %s", internalPath, detected.String(), mediaFolderName)
+		return
+	}
+	if mediaFolderName != ImageFolderName {
+		return
+	}
+	// Reading systems may trust the extension of images, e.g. a WebP image
+	// saved as .jpg
+	if byExt := mime.TypeByExtension(normalizeExt(path.Ext(internalPath))); byExt != "" && !detected.Is(byExt) {
+		e.addWarning(WarningMediaType, source, "%s has media type %s, but its extension is for %s", internalPath, detected.String(), byExt)
+	}
+	if config, _, err := image.DecodeConfig(bytes.NewReader(head)); err == nil && config.Width*config.Height > largeImagePixels {
+		e.addWarning(WarningLargeImage, source, "%s is %dx%d pixels", internalPath, config.Width, config.Height)
+		return
+	}
+	size := int64(n)
+	if n == sniffLength {
+		rest, _ := io.Copy(io.Discard, r)
+		size += rest
+	}
+	if size > largeImageBytes {
+		e.addWarning(WarningLargeImage, source, "%s is %d bytes", internalPath, size)
+	}
+}
+
+// mediaTypeFits returns whether the media type detected from the content of a
+// resource is expected in the folder mediaFolderName
+func mediaTypeFits(detected *mimetype.MIME, mediaFolderName string) bool {
+	var fits func(mediaType string) bool
+	switch mediaFolderName {
+	case ImageFolderName:
+		fits = func(mediaType string) bool { return strings.HasPrefix(mediaType, "image/") }
+	case FontFolderName:
+		fits = func(mediaType string) bool { return strings.Contains(mediaType, "font") }
+	case AudioFolderName, VideoFolderName:
+		// MP4 and Ogg files are detected as audio or video whatever the
+		// tracks they hold
+		fits = func(mediaType string) bool {
+			return strings.HasPrefix(mediaType, "audio/") || strings.HasPrefix(mediaType, "video/") || mediaType == "application/ogg"
+		}
+	case CSSFolderName:
+		fits = func(mediaType string) bool { return mediaType == "text/plain" || mediaType == mediaTypeCSS }
+	default:
+		return true
+	}
+	for m := detected; m != nil; m = m.Parent() {
+		mediaType, _, _ := strings.Cut(m.String(), ";")
+		if fits(mediaType) {
+			return true
+		}
+	}
+	return false
+}
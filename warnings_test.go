@@ -0,0 +1,90 @@
+package epub
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	"image/png"
+	"testing"
+)
+
+func TestWarnings(t *testing.T) {
+	e, err := NewEpub("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var handled []Warning
+	e.SetWarningHandler(func(w Warning) {
+		handled = append(handled, w)
+	})
+
+	// Resources that are fine aren't reported
+	if _, err := e.AddImage(testImageFromFileSource, ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.AddCSS(testCoverCSSSource, ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.AddFont(testFontFromFileSource, ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.AddAudio(testAudioFromFileSource, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.SetCover("../images/gophercolor16x16.png", ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.AddSection(testSectionBody, "Section 1", "", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	var b bytes.Buffer
+	if err := png.Encode(&b, image.NewGray(image.Rect(0, 0, 5000, 4000))); err != nil {
+		t.Fatal(err)
+	}
+	large := "data:image/png;base64," + base64.StdEncoding.EncodeToString(b.Bytes())
+	if _, err := e.AddImage(large, "large.png"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.AddImage(testCoverCSSSource, "style.png"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.AddImage(testImageFromFileSource, "gopher.jpg"); err != nil {
+		t.Fatal(err)
+	}
+	untitled, err := e.AddSection(testSectionBody, " ", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []Warning{
+		{Kind: WarningEmptyTitle, Source: ""},
+		{Kind: WarningLargeImage, Source: large},
+		{Kind: WarningMediaType, Source: testCoverCSSSource},
+		{Kind: WarningDuplicateLink, Source: testImageFromFileSource},
+		{Kind: WarningMediaType, Source: testImageFromFileSource},
+		{Kind: WarningEmptyTitle, Source: untitled},
+	}
+	warnings := e.Warnings()
+	if len(warnings) != len(expected) {
+		t.Fatalf("Expected %d warnings, got %d: %v", len(expected), len(warnings), warnings)
+	}
+	for i, w := range warnings {
+		if w.Kind != expected[i].Kind || w.Source != expected[i].Source || w.Message == "" {
+			t.Errorf("Warning %d: expected %s for %q, got %v for %q", i, expected[i].Kind, expected[i].Source, w, w.Source)
+		}
+	}
+	// The EPUB title was reported before the handler was set
+	if len(handled) != len(expected)-1 {
+		t.Errorf("Expected %d warnings passed to the handler, got %d", len(expected)-1, len(handled))
+	}
+
+	// Duplicated links aren't reported when they are stored once
+	e.SetResourceDeduplication(true)
+	if _, err := e.AddImage(testImageFromFileSource, ""); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(e.Warnings()); n != len(expected) {
+		t.Errorf("Expected no new warning with deduplication, got %v", e.Warnings()[len(expected):])
+	}
+}
@@ -0,0 +1,299 @@
+package epub
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+	"golang.org/x/net/html/charset"
+)
+
+// Elements dropped by the readability extraction along with their content
+var boilerplateElements = map[atom.Atom]bool{
+	atom.Aside:    true,
+	atom.Button:   true,
+	atom.Footer:   true,
+	atom.Form:     true,
+	atom.Header:   true,
+	atom.Iframe:   true,
+	atom.Nav:      true,
+	atom.Noscript: true,
+	atom.Script:   true,
+	atom.Style:    true,
+}
+
+// Classes and ids of the elements dropped by the readability extraction, unless
+// they also match contentHints
+var (
+	boilerplateHints = regexp.MustCompile(`(?i)ad-|advert|banner|breadcrumb|comment|cookie|footer|header|menu|nav|newsletter|popup|promo|related|share|sidebar|social|sponsor|subscribe`)
+	contentHints     = regexp.MustCompile(`(?i)article|body|content|entry|main|post|story|text`)
+)
+
+// URLOptions sets how AddSectionFromURL converts a web page.
+type URLOptions struct {
+	// Readability keeps only the main content of the page, dropping the
+	// navigation, headers, footers, sidebars, comments and forms around it.
+	Readability bool
+	// EmbedImages adds the images of the page to the EPUB, as AddImage does,
+	// and points the <img> elements to them. Images that can't be retrieved
+	// are left pointing to the web and reported as warnings, see Warnings.
+	EmbedImages bool
+	// Title of the section, the title of the page if empty
+	Title string
+}
+
+// AddSectionFromURL fetches the web page at pageURL with the HTTP client of
+// the EPUB and adds its content as a section, e.g. to archive articles. The
+// body is sanitized as with SanitizeStrict and serialized as well-formed
+// XHTML; the relative links and image sources are resolved against the URL of
+// the page. The internal filename and the internal CSS path are handled as in
+// AddSection.
+//
+// opts is optional; by default the whole body of the page is kept, the images
+// are left pointing to the web and the title of the section is the title of
+// the page.
+func (e *Epub) AddSectionFromURL(pageURL string, internalFilename string, internalCSSPath string, opts *URLOptions) (string, error) {
+	return e.AddSectionFromURLContext(context.Background(), pageURL, internalFilename, internalCSSPath, opts)
+}
+
+// AddSectionFromURLContext is like AddSectionFromURL, stopping the retrieval
+// of the page and of its images when ctx is done.
+func (e *Epub) AddSectionFromURLContext(ctx context.Context, pageURL string, internalFilename string, internalCSSPath string, opts *URLOptions) (string, error) {
+	if opts == nil {
+		opts = &URLOptions{}
+	}
+	doc, base, err := e.fetchPage(ctx, pageURL)
+	if err != nil {
+		return "", &FileRetrievalError{Source: pageURL, Err: err}
+	}
+	body := findElement(doc, func(n *html.Node) bool {
+		return n.DataAtom == atom.Body
+	})
+	if body == nil {
+		return "", &FileRetrievalError{Source: pageURL, Err: fmt.Errorf("page has no body")}
+	}
+	title := opts.Title
+	if title == "" {
+		title = documentTitle(doc)
+	}
+
+	if opts.Readability {
+		extractMainContent(body)
+	}
+	sanitizeStrict(body)
+	resolveURLs(body, base)
+
+	// The images and the section are added in one step, so that the images
+	// can be removed if the section can't be added
+	e.Lock()
+	defer e.Unlock()
+	var images []string
+	if opts.EmbedImages {
+		images = e.embedPageImages(ctx, body)
+	}
+	content, err := renderBody(body)
+	if err != nil {
+		e.forgetImages(images)
+		return "", err
+	}
+	filename, err := e.addSection("", content, title, internalFilename, internalCSSPath)
+	if err != nil {
+		e.forgetImages(images)
+	}
+	return filename, err
+}
+
+// fetchPage retrieves and parses the web page at pageURL, returning it along
+// with the URL its relative links are resolved against
+func (e *Epub) fetchPage(ctx context.Context, pageURL string) (*html.Node, *url.URL, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, pageURL, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	e.Lock()
+	client := e.httpClient()
+	e.Unlock()
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return nil, nil, fmt.Errorf("cannot get page, bad return code %d", resp.StatusCode)
+	}
+
+	r, err := charset.NewReader(resp.Body, resp.Header.Get("Content-Type"))
+	if err != nil {
+		return nil, nil, err
+	}
+	doc, err := html.Parse(io.LimitReader(r, 1<<26))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// The URL after the redirects
+	base := resp.Request.URL
+	if b := findElement(doc, func(n *html.Node) bool {
+		return n.DataAtom == atom.Base && getAttr(n, "href") != ""
+	}); b != nil {
+		if u, err := base.Parse(getAttr(b, "href")); err == nil {
+			base = u
+		}
+	}
+	return doc, base, nil
+}
+
+// extractMainContent replaces the content of body with its main content,
+// dropping the boilerplate around it
+func extractMainContent(body *html.Node) {
+	walk(body, func(n *html.Node) bool {
+		hints := getAttr(n, "class") + " " + getAttr(n, "id")
+		if boilerplateElements[n.DataAtom] ||
+			getAttr(n, "hidden") != "" || getAttr(n, "aria-hidden") == "true" ||
+			(boilerplateHints.MatchString(hints) && !contentHints.MatchString(hints) && n.DataAtom != atom.Article && n.DataAtom != atom.Main) {
+			n.Parent.RemoveChild(n)
+			return false
+		}
+		return true
+	})
+
+	mainContent := findElement(body, func(n *html.Node) bool {
+		return n.DataAtom == atom.Article
+	})
+	if mainContent == nil {
+		mainContent = findElement(body, func(n *html.Node) bool {
+			return n.DataAtom == atom.Main
+		})
+	}
+	if mainContent == nil {
+		mainContent = densestElement(body)
+	}
+	if mainContent == nil || mainContent == body {
+		return
+	}
+	mainContent.Parent.RemoveChild(mainContent)
+	for c := body.FirstChild; c != nil; c = body.FirstChild {
+		body.RemoveChild(c)
+	}
+	body.AppendChild(mainContent)
+}
+
+// densestElement returns the element of body whose paragraphs hold the most
+// text outside of links, or nil if there are no paragraphs
+func densestElement(body *html.Node) *html.Node {
+	scores := make(map[*html.Node]int)
+	var best *html.Node
+	walk(body, func(n *html.Node) bool {
+		if n.DataAtom != atom.P && n.DataAtom != atom.Pre && n.DataAtom != atom.Blockquote {
+			return true
+		}
+		text := len(strings.TrimSpace(textContent(n)))
+		linkText := 0
+		walk(n, func(c *html.Node) bool {
+			if c.DataAtom == atom.A {
+				linkText += len(strings.TrimSpace(textContent(c)))
+				return false
+			}
+			return true
+		})
+		if p := n.Parent; p != nil {
+			scores[p] += text - linkText
+			if best == nil || scores[p] > scores[best] {
+				best = p
+			}
+		}
+		return false
+	})
+	return best
+}
+
+// resolveURLs makes the relative links and sources of body absolute, with
+// base as the URL of the page. The links to fragments of the page are kept.
+func resolveURLs(body *html.Node, base *url.URL) {
+	walk(body, func(n *html.Node) bool {
+		if n.DataAtom == atom.Img && getAttr(n, "src") == "" {
+			// Lazy-loaded images
+			for _, key := range []string{"data-src", "data-original"} {
+				if src := getAttr(n, key); src != "" {
+					setAttr(n, "src", src)
+					break
+				}
+			}
+		}
+		for i, a := range n.Attr {
+			if a.Namespace != "" || (a.Key != "href" && a.Key != "src" && a.Key != "poster") {
+				continue
+			}
+			if strings.HasPrefix(a.Val, "#") || strings.HasPrefix(a.Val, "data:") {
+				continue
+			}
+			if u, err := base.Parse(strings.TrimSpace(a.Val)); err == nil {
+				if u.Scheme == base.Scheme && u.Host == base.Host && u.Path == base.Path && u.RawQuery == base.RawQuery && u.Fragment != "" {
+					n.Attr[i].Val = "#" + u.Fragment
+				} else {
+					n.Attr[i].Val = u.String()
+				}
+			}
+		}
+		// The sources of the images are resolved, but the alternatives would
+		// point to the web
+		removeAttr(n, func(a html.Attribute) bool {
+			return a.Key == "srcset" || a.Key == "sizes"
+		})
+		return true
+	})
+}
+
+// embedPageImages adds the images of body to the EPUB, and returns the internal
+// filenames of the ones added, the ones already in the EPUB left out
+func (e *Epub) embedPageImages(ctx context.Context, body *html.Node) []string {
+	// Images already in the EPUB, by source
+	added := make(map[string]string)
+	for filename, source := range e.images {
+		added[source] = "../" + ImageFolderName + "/" + filename
+	}
+
+	var images []string
+	walk(body, func(n *html.Node) bool {
+		if n.DataAtom != atom.Img {
+			return true
+		}
+		src := getAttr(n, "src")
+		if detectMediaType(src) != "URL" {
+			return true
+		}
+		internalPath, ok := added[src]
+		if !ok {
+			var err error
+			// The path of image URLs often lacks an extension or is followed
+			// by a query
+			extension := ""
+			if u, err := url.Parse(src); err == nil {
+				extension = strings.ToLower(path.Ext(u.Path))
+			}
+			filename := unusedFilename(imageFileFormat, extension, e.images)
+			// An image with the same content may be reused, see
+			// SetResourceDeduplication
+			count := len(e.images)
+			internalPath, err = e.addResource(ctx, src, filename, imageFileFormat, ImageFolderName, e.images)
+			if err != nil {
+				e.addWarning(WarningMissingImage, src, "can't add image to the epub: %s", err)
+				return true
+			}
+			added[src] = internalPath
+			if len(e.images) > count {
+				images = append(images, filename)
+			}
+		}
+		setAttr(n, "src", internalPath)
+		return true
+	})
+	return images
+}
@@ -0,0 +1,125 @@
+package epub
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const testWebPage = `<!DOCTYPE html>
+<html>
+<head><title>Page title</title><script>alert(1)</script></head>
+<body>
+<header><a href="/">Home</a></header>
+<nav><a href="/about">About</a></nav>
+<div class="content">
+<p>First paragraph with <a href="other.html">a link</a> and <a href="#note">a note</a>.</p>
+<p onclick="alert(1)">Second paragraph <img src="/gophercolor16x16.png" alt="Gopher"/></p>
+<p id="note">The note</p>
+</div>
+<div class="sidebar"><p>Related posts</p></div>
+<footer>Copyright</footer>
+</body>
+</html>`
+
+func TestAddSectionFromURL(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/articles/page.html", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(testWebPage))
+	})
+	mux.Handle("/gophercolor16x16.png", http.FileServer(http.Dir("./testdata/")))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	e, err := NewEpub(testEpubTitle)
+	if err != nil {
+		t.Error(err)
+	}
+	filename, err := e.AddSectionFromURL(server.URL+"/articles/page.html", "page.xhtml", "", nil)
+	if err != nil {
+		t.Errorf("Error adding section from URL: %s", err)
+	}
+	section := findSection(e.sections, filename)
+	if section == nil {
+		t.Fatalf("Section %s not added", filename)
+	}
+	if section.xhtml.Title() != "Page title" {
+		t.Errorf("Unexpected section title: %s", section.xhtml.Title())
+	}
+	body := section.xhtml.body()
+	for _, expected := range []string{
+		`<a href="` + server.URL + `/about">About</a>`,
+		`<a href="` + server.URL + `/articles/other.html">a link</a>`,
+		`<a href="#note">a note</a>`,
+		`<img src="` + server.URL + `/gophercolor16x16.png" alt="Gopher"/>`,
+	} {
+		if !strings.Contains(body, expected) {
+			t.Errorf("Section body doesn't contain %s:\n%s", expected, body)
+		}
+	}
+	if strings.Contains(body, "onclick") || strings.Contains(body, "alert") {
+		t.Errorf("Section body isn't sanitized:\n%s", body)
+	}
+
+	filename, err = e.AddSectionFromURL(server.URL+"/articles/page.html", "", "", &URLOptions{
+		Readability: true,
+		EmbedImages: true,
+		Title:       "Custom title",
+	})
+	if err != nil {
+		t.Errorf("Error adding section from URL: %s", err)
+	}
+	section = findSection(e.sections, filename)
+	if section.xhtml.Title() != "Custom title" {
+		t.Errorf("Unexpected section title: %s", section.xhtml.Title())
+	}
+	body = section.xhtml.body()
+	for _, unexpected := range []string{"Home", "About", "Related posts", "Copyright"} {
+		if strings.Contains(body, unexpected) {
+			t.Errorf("Boilerplate %q not removed:\n%s", unexpected, body)
+		}
+	}
+	if !strings.Contains(body, "First paragraph") || !strings.Contains(body, "The note") {
+		t.Errorf("Main content removed:\n%s", body)
+	}
+	if !strings.Contains(body, `src="../`+ImageFolderName+`/image0001.png"`) {
+		t.Errorf("Image not embedded:\n%s", body)
+	}
+
+	// Images that can't be retrieved are reported
+	mux.HandleFunc("/articles/missing.html", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<html><head><title>Missing</title></head><body><p><img src="/missing.png" alt="Missing"/></p></body></html>`))
+	})
+	filename, err = e.AddSectionFromURL(server.URL+"/articles/missing.html", "", "", &URLOptions{EmbedImages: true})
+	if err != nil {
+		t.Errorf("Error adding section from URL: %s", err)
+	}
+	body = findSection(e.sections, filename).xhtml.body()
+	if !strings.Contains(body, `src="`+server.URL+`/missing.png"`) {
+		t.Errorf("Missing image not left pointing to the web:\n%s", body)
+	}
+	warnings := e.Warnings()
+	if len(warnings) == 0 || warnings[len(warnings)-1].Kind != WarningMissingImage || warnings[len(warnings)-1].Source != server.URL+"/missing.png" {
+		t.Errorf("Missing image not reported: %v", warnings)
+	}
+
+	// The images are removed if the section can't be added
+	images := len(e.images)
+	mux.HandleFunc("/articles/other.html", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<html><body><p><img src="/gophercolor16x16.png?size=16" alt="Gopher"/></p></body></html>`))
+	})
+	_, err = e.AddSectionFromURL(server.URL+"/articles/other.html", filename, "", &URLOptions{EmbedImages: true})
+	if _, ok := err.(*FilenameAlreadyUsedError); !ok {
+		t.Errorf("Expected error FilenameAlreadyUsedError not returned. Returned instead: %+v", err)
+	}
+	if len(e.images) != images {
+		t.Errorf("Images of a section not added kept: %v", e.images)
+	}
+
+	_, err = e.AddSectionFromURL(server.URL+"/doesNotExist.html", "", "", nil)
+	if _, ok := err.(*FileRetrievalError); !ok {
+		t.Errorf("Expected error FileRetrievalError not returned. Returned instead: %+v", err)
+	}
+}
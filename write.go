@@ -1,13 +1,15 @@
 package epub
 
 import (
-	"archive/zip"
+	"context"
 	"fmt"
 	"io"
-	"io/fs"
 	"log"
 	"os"
+	"path"
 	"path/filepath"
+	"sort"
+	"strings"
 
 	"github.com/gofrs/uuid/v5"
 )
@@ -22,6 +24,10 @@ func (e *UnableToCreateEpubError) Error() string {
 	return fmt.Sprintf("Error creating EPUB at %q: %+v", e.Path, e.Err)
 }
 
+func (e *UnableToCreateEpubError) Unwrap() error {
+	return e.Err
+}
+
 const (
 	containerFilename     = "container.xml"
 	containerFileTemplate = `<?xml version="1.0" encoding="UTF-8"?>
@@ -48,25 +54,81 @@ const (
 	mimetypeFilename  = "mimetype"
 	pkgFilename       = "package.opf"
 	tempDirPrefix     = "go-epub"
-	xhtmlFolderName   = "xhtml"
+	// Pattern of the temporary file Write writes to, given the destination
+	// filename
+	tempFilePattern = ".%s.*.tmp"
+	xhtmlFolderName = "xhtml"
 )
 
+// Epub implements io.WriterTo
+var _ io.WriterTo = (*Epub)(nil)
+
 // WriteTo the dest io.Writer. The return value is the number of bytes written. Any error encountered during the write is also returned.
-func (e *Epub) WriteTo(dst io.Writer) (int64, error) {
+//
+// The EPUB is streamed to dst as it is zipped, e.g. into an HTTP response or
+// an upload to object storage, without creating an EPUB file. The files of the
+// EPUB are still prepared in the storage layer first: with Use(MemoryFS) and
+// no SetMaxBufferSize, nothing is written to the local filesystem.
+func (e *Epub) WriteTo(dst io.Writer) (n int64, err error) {
+	return e.WriteToContext(context.Background(), dst)
+}
+
+// WriteToContext is like WriteTo, stopping when ctx is done: the retrieval of
+// the resources is cancelled and ctx.Err() is returned, possibly wrapped in a
+// FileRetrievalError.
+func (e *Epub) WriteToContext(ctx context.Context, dst io.Writer) (n int64, err error) {
 	e.Lock()
 	defer e.Unlock()
+	return e.write(ctx, func(rootEpubDir string) (int64, error) {
+		return e.writeEpub(rootEpubDir, dst)
+	})
+}
+
+// write prepares the files of the EPUB in a temporary directory of the storage
+// and packages them with pack, which returns the number of bytes written
+func (e *Epub) write(ctx context.Context, pack func(rootEpubDir string) (int64, error)) (n int64, err error) {
+	if e.linkCheck {
+		if links := e.checkLinks(); len(links) > 0 {
+			return 0, &BrokenLinksError{Links: links}
+		}
+	}
+	e.ctx = ctx
+	defer func() {
+		e.ctx = nil
+	}()
+	span := e.startSpan(SpanWrite, nil)
+	defer func() {
+		span.End(err)
+	}()
 	tempDir := uuid.Must(uuid.NewV4()).String()
 
-	err := filesystem.Mkdir(tempDir, dirPermissions)
+	err = filesystem.Mkdir(tempDir, dirPermissions)
 	if err != nil {
 		return 0, fmt.Errorf("Error creating temp directory: %w", err)
 
 	}
 	defer func() {
 		if err := filesystem.RemoveAll(tempDir); err != nil {
-			log.Print("Error removing temp directory: %w", err)
+			log.Printf("Error removing temp directory: %s", err)
 		}
 	}()
+
+	e.report = newBuildReport()
+	e.startProgress()
+	e.placeholders = nil
+	e.streamed = nil
+	e.spool = newSpool(e.maxBufferSize)
+	defer func() {
+		e.spool.cleanup()
+		e.spool = nil
+	}()
+	e.cache.start()
+	defer func() {
+		e.cache.finish(err == nil)
+	}()
+	// The package and TOC files are built again from scratch
+	e.pkg.reset()
+	e.toc.reset()
 	err = writeMimetype(tempDir)
 	if err != nil {
 		return 0, err
@@ -85,47 +147,72 @@ func (e *Epub) WriteTo(dst io.Writer) (int64, error) {
 
 	// Must be called after:
 	// createEpubFolders()
-	err = e.writeCSSFiles(tempDir)
+	err = e.writeIBooksDisplayOptions(tempDir)
+	if err != nil {
+		return 0, err
+	}
+
+	// Must be called after:
+	// createEpubFolders()
+	err = e.traceStage(CSSFolderName, func() error {
+		return e.writeCSSFiles(tempDir)
+	})
 	if err != nil {
 		return 0, err
 	}
 
 	// Must be called after:
 	// createEpubFolders()
-	err = e.writeFonts(tempDir)
+	err = e.traceStage(FontFolderName, func() error {
+		return e.writeFonts(tempDir)
+	})
 	if err != nil {
 		return 0, err
 	}
 
 	// Must be called after:
 	// createEpubFolders()
-	err = e.writeImages(tempDir)
+	err = e.traceStage(ImageFolderName, func() error {
+		return e.writeImages(tempDir)
+	})
 	if err != nil {
 		return 0, err
 	}
 
 	// Must be called after:
 	// createEpubFolders()
-	err = e.writeVideos(tempDir)
+	err = e.traceStage(VideoFolderName, func() error {
+		return e.writeVideos(tempDir)
+	})
 	if err != nil {
 		return 0, err
 	}
 
 	// Must be called after:
 	// createEpubFolders()
-	err = e.writeAudios(tempDir)
+	err = e.traceStage(AudioFolderName, func() error {
+		return e.writeAudios(tempDir)
+	})
 	if err != nil {
 		return 0, err
 	}
 
 	// Must be called after:
 	// createEpubFolders()
-	e.writeSections(tempDir)
+	err = e.traceStage("sections", func() error {
+		return e.writeSections(tempDir)
+	})
+	if err != nil {
+		return 0, err
+	}
 
 	// Must be called after:
 	// createEpubFolders()
 	// writeSections()
-	e.writeToc(tempDir)
+	e.traceStage("toc", func() error {
+		e.writeToc(tempDir)
+		return nil
+	})
 
 	// Must be called after:
 	// createEpubFolders()
@@ -135,28 +222,178 @@ func (e *Epub) WriteTo(dst io.Writer) (int64, error) {
 	// writeAudios()
 	// writeSections()
 	// writeToc()
-	e.writePackageFile(tempDir)
+	e.traceStage("package", func() error {
+		e.writePackageFile(tempDir)
+		if err := e.writeEncryption(tempDir); err != nil {
+			e.warn("%s", err)
+		}
+		if err := e.writeContentHashes(tempDir); err != nil {
+			e.warn("%s", err)
+		}
+		return nil
+	})
 	// Must be called last
-	return e.writeEpub(tempDir, dst)
+	err = e.traceStage("zip", func() error {
+		n, err = pack(tempDir)
+		return err
+	})
+	return n, err
 }
 
 // Write writes the EPUB file. The destination path must be the full path to
 // the resulting file, including filename and extension.
 // The result is always writen to the local filesystem even if the underlying storage is in memory.
+//
+// The EPUB is written to a temporary file in the same directory, renamed to
+// the destination path once complete, so that an interrupted or failed Write
+// never leaves a partial EPUB behind and an existing file is only replaced by
+// a complete one. See SetTempFilePattern.
 func (e *Epub) Write(destFilePath string) error {
+	return e.WriteContext(context.Background(), destFilePath)
+}
 
-	f, err := os.Create(destFilePath)
+// WriteContext is like Write, stopping when ctx is done as WriteToContext
+// does.
+func (e *Epub) WriteContext(ctx context.Context, destFilePath string) (err error) {
+	e.Lock()
+	pattern := e.tempFilePattern
+	e.Unlock()
+	if pattern == "" {
+		pattern = fmt.Sprintf(tempFilePattern, filepath.Base(destFilePath))
+	}
+	f, err := os.CreateTemp(filepath.Dir(destFilePath), pattern)
 	if err != nil {
 		return &UnableToCreateEpubError{
 			Path: destFilePath,
 			Err:  err,
 		}
 	}
-	defer f.Close()
-	_, err = e.WriteTo(f)
+	// The temporary file is removed if anything goes wrong, panics included
+	done := false
+	defer func() {
+		if !done {
+			f.Close()
+			if err := os.Remove(f.Name()); err != nil {
+				log.Printf("Error removing temporary file: %s", err)
+			}
+		}
+	}()
+
+	if _, err = e.WriteToContext(ctx, f); err != nil {
+		return err
+	}
+	// os.CreateTemp creates the file readable by the owner only
+	if err = f.Chmod(filePermissions); err != nil {
+		return &UnableToCreateEpubError{Path: destFilePath, Err: err}
+	}
+	if err = f.Sync(); err != nil {
+		return &UnableToCreateEpubError{Path: destFilePath, Err: err}
+	}
+	if err = f.Close(); err != nil {
+		return &UnableToCreateEpubError{Path: destFilePath, Err: err}
+	}
+	if err = os.Rename(f.Name(), destFilePath); err != nil {
+		return &UnableToCreateEpubError{Path: destFilePath, Err: err}
+	}
+	done = true
+	return nil
+}
+
+// SetTempFilePattern sets the name of the temporary file Write writes the
+// EPUB to before renaming it, e.g. to match the ignore rules of a file watcher
+// or a sync tool. The pattern is a filename as in os.CreateTemp: the last "*"
+// is replaced by a random string, which is otherwise appended. The temporary
+// file is always created in the directory of the destination path, so that it
+// can be renamed atomically. An empty pattern restores the default,
+// ".<filename>.*.tmp".
+func (e *Epub) SetTempFilePattern(pattern string) error {
+	if strings.ContainsAny(pattern, `/\`) {
+		return fmt.Errorf("invalid temporary file pattern %q: contains a path separator", pattern)
+	}
+	e.Lock()
+	defer e.Unlock()
+	e.tempFilePattern = pattern
+	return nil
+}
+
+// WriteSection writes an already-added section to w as a standalone XHTML
+// file, as it would be written in the EPUB, so that a single chapter can be
+// previewed without building the whole EPUB. The CSS files of the section,
+// the CSS reset and font fallback stylesheets included, are embedded in
+// <style> elements; links to other files of the EPUB, such as images, are left
+// unchanged.
+func (e *Epub) WriteSection(internalFilename string, w io.Writer) error {
+	return e.WriteSectionContext(context.Background(), internalFilename, w)
+}
+
+// WriteSectionContext is like WriteSection, stopping the retrieval of the CSS
+// files when ctx is done.
+func (e *Epub) WriteSectionContext(ctx context.Context, internalFilename string, w io.Writer) error {
+	e.Lock()
+	defer e.Unlock()
+	section := findSection(e.sections, internalFilename)
+	if section == nil {
+		return &SectionDoesNotExistError{Filename: internalFilename}
+	}
+	if section.raw != "" {
+		_, err := io.WriteString(w, section.raw)
+		return err
+	}
+
+	x := e.sectionXhtml(section).copy()
+	if section.filename == e.cover.xhtmlFilename {
+		x.setTitle(e.Title())
+	}
+	css := e.cssFiles()
+	links := x.xml.Head.Links
+	x.xml.Head.Links = nil
+	for _, link := range links {
+		source, ok := css[path.Base(link.Href)]
+		if !ok {
+			x.addCSS(link.Href)
+			continue
+		}
+		r, err := e.grabber(ctx).open(source)
+		if err != nil {
+			return err
+		}
+		css, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			return &FileRetrievalError{Source: source, Err: err}
+		}
+		x.addStyle(string(css))
+	}
+
+	content, err := e.sectionContent(x, internalFilename)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(content)
 	return err
 }
 
+// sectionXhtml returns the XHTML to write for a section, a copy with its
+// language and direction set, the font fallbacks and the global CSS files
+// linked, or the direction of the generated pages set for the cover, and its
+// notes placed
+func (e *Epub) sectionXhtml(section *epubSection) *xhtml {
+	x := section.xhtml.copy()
+	if section.filename == e.cover.xhtmlFilename {
+		if dir := e.direction(); dir != "" {
+			x.setDir(dir)
+		}
+	} else {
+		x.prependCSS(e.linkedCSS(x.body()))
+	}
+	x.setLang(section.lang)
+	if section.dir != "" {
+		x.setDir(section.dir)
+	}
+	e.setPlaceholderAlt(x)
+	return e.placeNotes(section, x)
+}
+
 // Create the EPUB folder structure in a temp directory
 func createEpubFolders(rootEpubDir string) error {
 	if err := filesystem.Mkdir(
@@ -215,15 +452,30 @@ func writeContainerFile(rootEpubDir string) error {
 // Write the CSS files to the temporary directory and add them to the package
 // file
 func (e *Epub) writeCSSFiles(rootEpubDir string) error {
-	err := e.writeMedia(rootEpubDir, e.css, CSSFolderName)
-	if err != nil {
-		return err
-	}
-
-	// Clean up the cover temp file if one was created
-	os.Remove(e.cover.cssTempFile)
+	return e.writeMedia(rootEpubDir, e.cssFiles(), CSSFolderName)
+}
 
-	return nil
+// cssFiles returns the CSS files of the EPUB, the ones added with AddCSS and
+// the generated ones, the key being the filename and the value the source
+func (e *Epub) cssFiles() map[string]string {
+	css := e.css
+	stylesheets := e.fontFallbackStylesheets()
+	if filename, source := e.cssResetStylesheet(); filename != "" {
+		if stylesheets == nil {
+			stylesheets = make(map[string]string)
+		}
+		stylesheets[filename] = source
+	}
+	if len(stylesheets) > 0 {
+		css = make(map[string]string)
+		for filename, source := range e.css {
+			css[filename] = source
+		}
+		for filename, source := range stylesheets {
+			css[filename] = source
+		}
+	}
+	return css
 }
 
 // writeCounter counts the number of bytes written to it.
@@ -239,107 +491,22 @@ func (wc *writeCounter) Write(p []byte) (int, error) {
 	return n, nil
 }
 
-// Write the EPUB file itself by zipping up everything from a temp directory
-// The return value is the number of bytes written. Any error encountered during the write is also returned.
-func (e *Epub) writeEpub(rootEpubDir string, dst io.Writer) (int64, error) {
-	counter := &writeCounter{}
-	teeWriter := io.MultiWriter(counter, dst)
-
-	z := zip.NewWriter(teeWriter)
-
-	skipMimetypeFile := false
-
-	// addFileToZip adds the file present at path to the zip archive. The path is relative to the rootEpubDir
-	addFileToZip := func(path string, d fs.DirEntry, err error) error {
-		if err != nil {
-			return err
-		}
-
-		// Get the path of the file relative to the folder we're zipping
-		relativePath, err := filepath.Rel(rootEpubDir, path)
-		if err != nil {
-			// tempDir and path are both internal, so we shouldn't get here
-			return err
-		}
-		relativePath = filepath.ToSlash(relativePath)
-
-		// Only include regular files, not directories
-		info, err := d.Info()
-		if err != nil {
-			return err
-		}
-		if !info.Mode().IsRegular() {
-			return nil
-		}
-
-		var w io.Writer
-		if filepath.FromSlash(path) == filepath.Join(rootEpubDir, mimetypeFilename) {
-			// Skip the mimetype file if it's already been written
-			if skipMimetypeFile {
-				return nil
-			}
-			// The mimetype file must be uncompressed according to the EPUB spec
-			w, err = z.CreateHeader(&zip.FileHeader{
-				Name:   relativePath,
-				Method: zip.Store,
-			})
-		} else {
-			w, err = z.Create(relativePath)
-		}
-		if err != nil {
-			return fmt.Errorf("error creating zip writer: %w", err)
-		}
-
-		r, err := filesystem.Open(path)
-		if err != nil {
-			return fmt.Errorf("error opening file %v being added to EPUB: %w", path, err)
-		}
-		defer func() {
-			if err := r.Close(); err != nil {
-				log.Println(err)
-			}
-		}()
-
-		_, err = io.Copy(w, r)
-		if err != nil {
-			return fmt.Errorf("error copying contents of file being added EPUB: %w", err)
+// totalSizeChecker returns a function adding the size of a file to the size of
+// the files of the EPUB so far, and checking the MaxTotalSize limit
+func (e *Epub) totalSizeChecker() func(n int64) error {
+	var totalSize int64
+	return func(n int64) error {
+		totalSize += n
+		if e.limits != nil && e.limits.MaxTotalSize > 0 && totalSize > e.limits.MaxTotalSize {
+			return &LimitExceededError{Limit: "MaxTotalSize", Max: e.limits.MaxTotalSize}
 		}
 		return nil
 	}
-
-	// Add the mimetype file first
-	mimetypeFilePath := filepath.Join(rootEpubDir, mimetypeFilename)
-	mimetypeInfo, err := fs.Stat(filesystem, mimetypeFilePath)
-	if err != nil {
-		if err := z.Close(); err != nil {
-			log.Println(err)
-		}
-		return counter.Total, fmt.Errorf("unable to get FileInfo for mimetype file: %w", err)
-	}
-	err = addFileToZip(mimetypeFilePath, fileInfoToDirEntry(mimetypeInfo), nil)
-	if err != nil {
-		if err := z.Close(); err != nil {
-			log.Println(err)
-		}
-		return counter.Total, fmt.Errorf("unable to add mimetype file to EPUB: %w", err)
-	}
-
-	skipMimetypeFile = true
-
-	err = fs.WalkDir(filesystem, rootEpubDir, addFileToZip)
-	if err != nil {
-		if err := z.Close(); err != nil {
-			log.Println(err)
-		}
-		return counter.Total, fmt.Errorf("unable to add file to EPUB: %w", err)
-	}
-
-	err = z.Close()
-	return counter.Total, err
 }
 
 // Get fonts from their source and save them in the temporary directory
 func (e *Epub) writeFonts(rootEpubDir string) error {
+	e.obfuscatedFonts = nil
 	return e.writeMedia(rootEpubDir, e.fonts, FontFolderName)
 }
 
@@ -350,12 +517,22 @@ func (e *Epub) writeImages(rootEpubDir string) error {
 
 // Get videos from their source and save them in the temporary directory
 func (e *Epub) writeVideos(rootEpubDir string) error {
-	return e.writeMedia(rootEpubDir, e.videos, VideoFolderName)
+	if err := e.writeMedia(rootEpubDir, e.videos, VideoFolderName); err != nil {
+		return err
+	}
+	return e.addRemoteResources(VideoFolderName)
 }
 
 // Get audios from their source and save them in the temporary directory
 func (e *Epub) writeAudios(rootEpubDir string) error {
-	return e.writeMedia(rootEpubDir, e.audios, AudioFolderName)
+	if err := e.writeMedia(rootEpubDir, e.audios, AudioFolderName); err != nil {
+		return err
+	}
+	if err := e.addRemoteResources(AudioFolderName); err != nil {
+		return err
+	}
+	e.writeDurations()
+	return nil
 }
 
 // Get media from their source and save them in the temporary directory
@@ -366,10 +543,103 @@ func (e *Epub) writeMedia(rootEpubDir string, mediaMap map[string]string, mediaF
 			return fmt.Errorf("unable to create directory: %s", err)
 		}
 
-		for mediaFilename, mediaSource := range mediaMap {
-			mediaType, err := grabber{(e.Client)}.fetchMedia(mediaSource, mediaFolderPath, mediaFilename)
-			if err != nil {
-				return err
+		// The resources are processed in a stable order, and the ones that
+		// can't be reused from the previous build are fetched concurrently
+		filenames := make([]string, 0, len(mediaMap))
+		for mediaFilename := range mediaMap {
+			filenames = append(filenames, mediaFilename)
+		}
+		sort.Strings(filenames)
+		reused := make(map[string]string)
+		var fetched []string
+		streamed := make(map[string]bool)
+		for _, mediaFilename := range filenames {
+			if e.dryRun {
+				// An empty file takes the place of the resource, as if reused
+				reused[mediaFilename] = e.guessMediaType(mediaFolderName, mediaFilename)
+				continue
+			}
+			name := path.Join(contentFolderName, mediaFolderName, mediaFilename)
+			mediaType, ok := e.cache.reuseMedia(e.fsys, name, mediaMap[mediaFilename])
+			// The key of obfuscated resources and the optimization of images
+			// may have changed since, converted images are written again and
+			// the hash of resources with a checksum is checked again
+			if ok && !e.obfuscatesMedia(mediaFolderName) && !e.optimizesMedia(mediaFolderName) && !e.convertsImage(mediaFolderName, mediaType) && e.checksums[path.Join(mediaFolderName, mediaFilename)] == "" {
+				reused[mediaFilename] = mediaType
+			} else {
+				fetched = append(fetched, mediaFilename)
+				streamed[mediaFilename] = e.streamsMedia(mediaFolderName, mediaMap[mediaFilename])
+			}
+		}
+		downloads, stop := e.downloadMedia(mediaMap, fetched, streamed, mediaFolderPath)
+		defer stop()
+
+		for _, mediaFilename := range filenames {
+			mediaSource := mediaMap[mediaFilename]
+			name := path.Join(contentFolderName, mediaFolderName, mediaFilename)
+			mediaType, ok := reused[mediaFilename]
+			// Internal filename and media type of the image converted from
+			// this one, if any
+			var converted, convertedType string
+			if ok {
+				// The content of the previous build is written instead
+				if err := filesystem.WriteFile(filepath.Join(mediaFolderPath, mediaFilename), nil, filePermissions); err != nil {
+					return fmt.Errorf("unable to create file %s: %w", mediaFilename, err)
+				}
+			} else {
+				d := downloads[mediaFilename]
+				d.wait()
+				mediaType = d.mediaType
+				err := d.err
+				if err != nil {
+					if !e.tolerateFetchError() {
+						return err
+					}
+					mediaType, err = e.replaceMedia(mediaFolderName, filepath.Join(mediaFolderPath, mediaFilename), err)
+					if err != nil {
+						return err
+					}
+					if mediaType == "" {
+						e.resourceDone(mediaFolderName, mediaFilename)
+						continue
+					}
+				} else if streamed[mediaFilename] {
+					// The content is copied from the source when zipped
+					e.report.FetchTimings[mediaSource] = d.duration
+					mediaFilePath := filepath.Join(mediaFolderPath, mediaFilename)
+					if err := filesystem.WriteFile(mediaFilePath, nil, filePermissions); err != nil {
+						return fmt.Errorf("unable to create file %s: %w", mediaFilename, err)
+					}
+					if e.streamed == nil {
+						e.streamed = make(map[string]streamedMedia)
+					}
+					e.streamed[mediaFilePath] = streamedMedia{
+						source:   mediaSource,
+						checksum: e.checksums[path.Join(mediaFolderName, mediaFilename)],
+					}
+				} else {
+					e.report.FetchTimings[mediaSource] = d.duration
+					e.cache.addMedia(e.fsys, name, mediaSource, mediaType)
+					if mediaFolderName == AudioFolderName {
+						e.probeDuration(mediaFilename, filepath.Join(mediaFolderPath, mediaFilename), mediaType)
+					}
+					if e.convertsImage(mediaFolderName, mediaType) {
+						converted, convertedType, err = e.convertImage(mediaFilename, filepath.Join(mediaFolderPath, mediaFilename), mediaType)
+						if err != nil {
+							e.warn("%s", err)
+						}
+					}
+					if e.optimizesMedia(mediaFolderName) {
+						if err := e.optimizeImage(mediaFilename, filepath.Join(mediaFolderPath, mediaFilename), mediaType); err != nil {
+							e.warn("%s", err)
+						}
+					}
+					if e.obfuscatesMedia(mediaFolderName) {
+						if err := e.obfuscateFont(mediaFilename, filepath.Join(mediaFolderPath, mediaFilename)); err != nil {
+							e.warn("%s", err)
+						}
+					}
+				}
 			}
 			// The cover image has a special value for the properties attribute
 			mediaProperties := ""
@@ -382,7 +652,16 @@ func (e *Epub) writeMedia(rootEpubDir string, mediaMap map[string]string, mediaF
 			if err != nil {
 				return fmt.Errorf("error creating xml id: %w", err)
 			}
-			e.pkg.addToManifest(xmlId, filepath.Join(mediaFolderName, mediaFilename), mediaType, mediaProperties)
+			e.pkg.addToManifest(xmlId, filepath.Join(mediaFolderName, mediaFilename), e.resourceMediaType(mediaFolderName, mediaFilename, mediaType), mediaProperties)
+			if converted != "" {
+				convertedID, err := fixXMLId(converted)
+				if err != nil {
+					return fmt.Errorf("error creating xml id: %w", err)
+				}
+				e.pkg.addToManifest(convertedID, filepath.Join(mediaFolderName, converted), convertedType, "")
+				e.pkg.setFallback(xmlId, convertedID)
+			}
+			e.resourceDone(mediaFolderName, mediaFilename)
 		}
 	}
 	return nil
@@ -416,39 +695,90 @@ func writeMimetype(rootEpubDir string) error {
 func (e *Epub) writePackageFile(rootEpubDir string) {
 	err := e.pkg.write(rootEpubDir)
 	if err != nil {
-		log.Println(err)
+		e.warn("%s", err)
 	}
 }
 
 // Write the section files to the temporary directory and add the sections to
 // the TOC and package files
-func (e *Epub) writeSections(rootEpubDir string) {
+func (e *Epub) writeSections(rootEpubDir string) error {
+	e.pkg.unsetRefinedDurations()
+	overlaysDuration, hasOverlays := e.mediaOverlaysDuration()
+	if hasOverlays {
+		if err := filesystem.Mkdir(filepath.Join(rootEpubDir, contentFolderName, smilFolderName), dirPermissions); err != nil {
+			e.warn("unable to create directory: %s", err)
+		}
+	}
 	filenamelist := getFilenames(e.sections)
 	parentlist := getParents(e.sections, "-1")
 	if len(e.sections) > 0 {
-		// If a cover was set, add it to the package spine first so it shows up
-		// first in the reading order
-		if e.cover.xhtmlFilename != "" {
-			e.pkg.addToSpine(e.cover.xhtmlFilename)
-		}
-		err := writeSections(rootEpubDir, e, e.sections, parentlist, filenamelist)
+		err := writeSections(rootEpubDir, e, e.sections, parentlist, filenamelist, indexSections(e.sections))
 		if err != nil {
-			log.Println(err)
+			return err
+		}
+		// If a cover was set, add it to the package spine at its position, first
+		// by default
+		if e.cover.xhtmlFilename != "" {
+			e.pkg.insertIntoSpine(e.cover.xhtmlFilename, e.coverSpineIndex(), true)
 		}
 	}
+	if err := e.writeEndnotes(rootEpubDir, len(filenamelist)+1); err != nil {
+		e.warn("%s", err)
+	}
+	if err := e.writeAttributions(rootEpubDir); err != nil {
+		e.warn("%s", err)
+	}
+	if err := e.writeProvenancePage(rootEpubDir); err != nil {
+		e.warn("%s", err)
+	}
+	if hasOverlays {
+		// The total duration of the overlays takes the place of the one of
+		// the audio files
+		e.pkg.setDuration("", clockValue(overlaysDuration))
+	}
+	return nil
 }
 
 // Write the TOC file to the temporary directory and add the TOC entries to the
 // package file
 func (e *Epub) writeToc(rootEpubDir string) {
 	e.pkg.addToManifest(tocNavItemID, tocNavFilename, mediaTypeXhtml, tocNavItemProperties)
-	e.pkg.addToManifest(tocNcxItemID, tocNcxFilename, mediaTypeNcx, "")
+	if !e.noNcx {
+		e.pkg.addToManifest(tocNcxItemID, tocNcxFilename, mediaTypeNcx, "")
+	}
 
-	err := e.toc.write(rootEpubDir)
+	e.toc.navDir = e.direction()
+	e.writeLandmarks()
+	e.writePageList(e.sections)
+
+	if e.customTOC != nil {
+		e.toc.addEntries(e.customTOC, func(filename string) bool {
+			return filename != "" && findSection(e.sections, filename) != nil
+		})
+	}
+
+	var err error
+	if e.noNcx {
+		err = e.toc.writeNavDoc(rootEpubDir)
+	} else {
+		err = e.toc.write(rootEpubDir)
+	}
 	if err != nil {
-		log.Println(err)
+		e.warn("%s", err)
 	}
+}
 
+// tocParent returns the internal filename of the nearest ancestor of a section
+// shown in the TOC, or -1 if there is none
+func (e *Epub) tocParent(filename string, parentfilename map[string]string, sectionIndex map[string]*epubSection) string {
+	parent := parentfilename[filename]
+	for parent != "-1" {
+		if s := sectionIndex[parent]; s == nil || !s.excludeFromTOC {
+			break
+		}
+		parent = parentfilename[parent]
+	}
+	return parent
 }
 
 // Create a list of sections and their parents.
@@ -457,17 +787,16 @@ func getParents(sections []*epubSection, root string) map[string]string {
 	fileparent := make(map[string]string)
 	for _, section := range sections {
 		fileparent[section.filename] = root
-		if section.children != nil {
-			childFileparent := getParents(section.children, section.filename)
-			for filename, parent := range childFileparent {
-				fileparent[filename] = parent
-			}
-		}
 	}
+	walkSections(sections, func(section *epubSection) {
+		for _, child := range section.children {
+			fileparent[child.filename] = section.filename
+		}
+	})
 	return fileparent
 }
 
-func writeSections(rootEpubDir string, e *Epub, sections []*epubSection, parentfilename map[string]string, filenamelist map[string]int) error {
+func writeSections(rootEpubDir string, e *Epub, sections []*epubSection, parentfilename map[string]string, filenamelist map[string]int, sectionIndex map[string]*epubSection) error {
 	for _, section := range sections {
 
 		// Set the title of the cover page XHTML to the title of the EPUB
@@ -476,29 +805,36 @@ func writeSections(rootEpubDir string, e *Epub, sections []*epubSection, parentf
 		}
 
 		sectionFilePath := filepath.Join(rootEpubDir, contentFolderName, xhtmlFolderName, section.filename)
-		err := section.xhtml.write(sectionFilePath)
+		span := e.startSpan(SpanSection, map[string]string{"filename": section.filename})
+		err := e.writeSection(section, sectionFilePath)
+		span.End(err)
 		if err != nil {
-			log.Println(err)
+			return err
 		}
+		properties := e.sectionProperties(section)
+		e.report.SectionProperties[section.filename] = properties
 
 		relativePath := filepath.Join(xhtmlFolderName, section.filename)
-		if section.filename != e.cover.xhtmlFilename {
-			e.pkg.addToSpine(section.filename)
+		if section.filename != e.cover.xhtmlFilename && !section.notInSpine {
+			e.pkg.addToSpine(section.filename, !section.nonLinear)
 		}
-		e.pkg.addToManifest(section.filename, relativePath, mediaTypeXhtml, section.properties)
-		if parentfilename[section.filename] == "-1" && section.filename != e.cover.xhtmlFilename {
-			j := filenamelist[section.filename]
-			e.toc.addSubSection("-1", j, section.xhtml.Title(), relativePath)
+		e.pkg.addToManifest(section.filename, relativePath, mediaTypeXhtml, properties)
+		if err := e.writeMediaOverlay(rootEpubDir, section); err != nil {
+			e.warn("%s", err)
 		}
-		if parentfilename[section.filename] != "-1" && section.filename != e.cover.xhtmlFilename {
+		if e.customTOC == nil && section.filename != e.cover.xhtmlFilename {
 			j := filenamelist[section.filename]
-			parentfilenameis := parentfilename[section.filename]
-			e.toc.addSubSection(parentfilenameis, j, section.xhtml.Title(), relativePath)
+			tocParent := e.tocParent(section.filename, parentfilename, sectionIndex)
+			if !section.excludeFromTOC {
+				e.toc.addSubSection(tocParent, j, section.xhtml.Title(), relativePath)
+				tocParent = section.filename
+			}
+			e.toc.addFragments(tocParent, j, section.tocEntries)
 		}
 		if section.children != nil {
-			err = writeSections(rootEpubDir, e, section.children, parentfilename, filenamelist)
+			err = writeSections(rootEpubDir, e, section.children, parentfilename, filenamelist, sectionIndex)
 			if err != nil {
-				log.Println(err)
+				return err
 			}
 		}
 	}
@@ -1,10 +1,16 @@
 package epub
 
 import (
+	"archive/zip"
 	"bytes"
+	"context"
+	"errors"
 	"io"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -23,6 +29,93 @@ func TestEpubWriteTo(t *testing.T) {
 	}
 }
 
+func TestEpubWriteToMemoryFS(t *testing.T) {
+	if err := Use(MemoryFS); err != nil {
+		t.Fatal(err)
+	}
+	// Registered before the temporary directory is changed below, so that the
+	// filesystem is restored with the original one
+	t.Cleanup(func() { Use(OsFS) })
+
+	e, err := NewEpub(testEpubTitle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.AddImage(testImageFromFileSource, ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.AddSection(testSectionBody, testSectionTitle, testSectionFilename, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	// Temporary files are created in a directory of the test only, so that
+	// the ones of other processes don't count
+	tempDir := t.TempDir()
+	for _, key := range []string{"TMPDIR", "TMP", "TEMP"} {
+		t.Setenv(key, tempDir)
+	}
+	var b bytes.Buffer
+	n, err := e.WriteTo(&b)
+	if err != nil {
+		t.Fatalf("Error writing EPUB: %s", err)
+	}
+	if n != int64(b.Len()) {
+		t.Errorf("Expected %d bytes written, got %d", b.Len(), n)
+	}
+	entries, err := os.ReadDir(tempDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("Temporary files created by WriteTo with the memory filesystem: %v", entries)
+	}
+
+	r, err := zip.NewReader(bytes.NewReader(b.Bytes()), int64(b.Len()))
+	if err != nil {
+		t.Fatalf("Error reading EPUB: %s", err)
+	}
+	names := make(map[string]bool)
+	for _, f := range r.File {
+		names[f.Name] = true
+	}
+	for _, name := range []string{
+		mimetypeFilename,
+		contentFolderName + "/" + xhtmlFolderName + "/" + testSectionFilename,
+		contentFolderName + "/" + ImageFolderName + "/" + filepath.Base(testImageFromFileSource),
+	} {
+		if !names[name] {
+			t.Errorf("Expected %s in the EPUB, got %v", name, names)
+		}
+	}
+}
+
+func TestEpubWriteToTwice(t *testing.T) {
+	e, err := NewEpub(testEpubTitle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.AddImage(testImageFromFileSource, testImageFromFileFilename); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.AddSection(testSectionBody, testSectionTitle, testSectionFilename, ""); err != nil {
+		t.Fatal(err)
+	}
+	counts := func() [3]int {
+		return [3]int{len(e.pkg.xml.ManifestItems), len(e.pkg.xml.Spine.Items), len(e.toc.navXML.Links)}
+	}
+	if _, err := e.WriteTo(io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	first := counts()
+	if _, err := e.WriteTo(io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	// Manifest items, spine items and TOC entries aren't added twice
+	if second := counts(); second != first {
+		t.Errorf("Got %v manifest, spine and TOC entries on the second write, expected %v", second, first)
+	}
+}
+
 func TestWriteToErrors(t *testing.T) {
 	t.Run("CSS", func(t *testing.T) {
 		e, err := NewEpub(testEpubTitle)
@@ -92,3 +185,206 @@ func testWriteToErrors(t *testing.T, e *Epub, adder func(string, string) (string
 		t.Fatal("Expected error")
 	}
 }
+
+func TestWriteSection(t *testing.T) {
+	e, err := NewEpub(testEpubTitle)
+	if err != nil {
+		t.Error(err)
+	}
+	cssPath, err := e.AddCSS(testCoverCSSSource, testCoverCSSFilename)
+	if err != nil {
+		t.Error(err)
+	}
+	_, err = e.AddSection(`<p id="p1">Text</p>`, testSectionTitle, testSectionFilename, cssPath)
+	if err != nil {
+		t.Errorf("Error adding section: %s", err)
+	}
+	if err := e.AddFootnote(testSectionFilename, "p1", "Note"); err != nil {
+		t.Errorf("Error adding note: %s", err)
+	}
+
+	var b bytes.Buffer
+	if err := e.WriteSection(testSectionFilename, &b); err != nil {
+		t.Fatalf("Error writing section: %s", err)
+	}
+	css, err := os.ReadFile(testCoverCSSSource)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		"<title dir=\"auto\">" + testSectionTitle + "</title>",
+		"<style type=\"text/css\">/*<![CDATA[*/\n" + string(css) + "\n/*]]>*/</style>",
+		`<aside epub:type="footnote" role="doc-footnote" id="note-1">`,
+	} {
+		if !strings.Contains(b.String(), expected) {
+			t.Errorf("Section doesn't contain %q:\n%s", expected, b.String())
+		}
+	}
+	if strings.Contains(b.String(), "<link") {
+		t.Errorf("Section still links to its CSS:\n%s", b.String())
+	}
+	if s := findSection(e.sections, testSectionFilename); len(s.xhtml.cssPaths()) == 0 {
+		t.Error("Writing a section changed its CSS link")
+	}
+
+	// The generated stylesheets are embedded as well
+	if err := e.SetCSSReset(CSSResetMinimal); err != nil {
+		t.Fatal(err)
+	}
+	e.SetFontFallbacks(&FontFallbackOptions{})
+	_, err = e.AddSection(`<p>中文</p>`, testSectionTitle, "cjk.xhtml", "")
+	if err != nil {
+		t.Errorf("Error adding section: %s", err)
+	}
+	b.Reset()
+	if err := e.WriteSection("cjk.xhtml", &b); err != nil {
+		t.Fatalf("Error writing section: %s", err)
+	}
+	if strings.Contains(b.String(), "<link") || !strings.Contains(b.String(), cssResetMinimal) || !strings.Contains(b.String(), "Noto Serif CJK") {
+		t.Errorf("Section doesn't embed the generated stylesheets:\n%s", b.String())
+	}
+
+	err = e.WriteSection("doesNotExist.xhtml", &b)
+	if _, ok := err.(*SectionDoesNotExistError); !ok {
+		t.Errorf("Expected error SectionDoesNotExistError not returned. Returned instead: %+v", err)
+	}
+}
+
+func TestWriteToContext(t *testing.T) {
+	server := httptest.NewServer(http.FileServer(http.Dir("./testdata/")))
+	defer server.Close()
+
+	e, err := NewEpub(testEpubTitle)
+	if err != nil {
+		t.Error(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	if _, err := e.AddImageContext(ctx, server.URL+"/gophercolor16x16.png", ""); err != nil {
+		t.Errorf("Error adding image: %s", err)
+	}
+	if _, err := e.WriteToContext(ctx, io.Discard); err != nil {
+		t.Errorf("Error writing EPUB: %s", err)
+	}
+
+	cancel()
+	_, err = e.AddImageContext(ctx, testImageFromFileSource, "")
+	var retrievalErr *FileRetrievalError
+	if !errors.As(err, &retrievalErr) || !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected a FileRetrievalError wrapping context.Canceled, got %v", err)
+	}
+	_, err = e.WriteToContext(ctx, io.Discard)
+	if !errors.As(err, &retrievalErr) || !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected a FileRetrievalError wrapping context.Canceled, got %v", err)
+	}
+
+	// Without resources to retrieve, the zip is interrupted
+	e, err = NewEpub(testEpubTitle)
+	if err != nil {
+		t.Error(err)
+	}
+	if _, err := e.WriteToContext(ctx, io.Discard); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+}
+
+// panicReader panics when read
+type panicReader struct{}
+
+func (panicReader) Read(p []byte) (int, error) {
+	panic("read failed")
+}
+
+func TestWriteAtomic(t *testing.T) {
+	dir := t.TempDir()
+	dest := filepath.Join(dir, testEpubFilename)
+	// listDir returns the names of the files in dir
+	listDir := func() []string {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, entry := range entries {
+			names = append(names, entry.Name())
+		}
+		return names
+	}
+
+	e, err := NewEpub(testEpubTitle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(testImageFromFileSource)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.AddImageFromReader(f, testImageFromFileFilename); err != nil {
+		t.Fatalf("Error adding image: %s", err)
+	}
+	if err := e.Write(dest); err != nil {
+		t.Fatalf("Error writing EPUB: %s", err)
+	}
+	written, err := os.ReadFile(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm()&0044 == 0 {
+		t.Errorf("EPUB written with permissions %s", info.Mode().Perm())
+	}
+
+	// The reader can't be read again, so the second Write fails
+	if err := e.Write(dest); err == nil {
+		t.Fatal("Expected an error writing the EPUB again")
+	}
+	got, err := os.ReadFile(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, written) {
+		t.Error("Failed Write replaced the existing EPUB")
+	}
+	if names := listDir(); len(names) != 1 || names[0] != testEpubFilename {
+		t.Errorf("Failed Write left files behind: %v", names)
+	}
+
+	// The temporary file is removed on panic too
+	e, err = NewEpub(testEpubTitle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.AddImageFromReader(panicReader{}, testImageFromFileFilename); err != nil {
+		t.Fatalf("Error adding image: %s", err)
+	}
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("Expected Write to panic")
+			}
+		}()
+		e.Write(filepath.Join(dir, "panic.epub"))
+	}()
+	if names := listDir(); len(names) != 1 || names[0] != testEpubFilename {
+		t.Errorf("Write left files behind on panic: %v", names)
+	}
+
+	if err := e.SetTempFilePattern("tmp/*.epub"); err == nil {
+		t.Error("Expected an error setting a pattern with a path separator")
+	}
+	e, err = NewEpub(testEpubTitle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.SetTempFilePattern("partial-*"); err != nil {
+		t.Fatalf("Error setting temporary file pattern: %s", err)
+	}
+	if err := e.Write(dest); err != nil {
+		t.Fatalf("Error writing EPUB: %s", err)
+	}
+	if names := listDir(); len(names) != 1 || names[0] != testEpubFilename {
+		t.Errorf("Write left files behind: %v", names)
+	}
+}
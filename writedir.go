@@ -0,0 +1,96 @@
+package epub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// WriteDir writes the EPUB unpacked to the directory at dirPath instead of
+// zipping it: the mimetype file, the META-INF folder and the EPUB folder, as
+// they would be in the archive. It is meant for debugging, for diffing two
+// builds, and for workflows that process the files before packaging them.
+//
+// The directory is created if needed and must be empty. As with Write, the
+// files are written to a temporary directory next to it, renamed once
+// complete, so that a failed WriteDir never leaves a partial EPUB behind. See
+// SetTempFilePattern.
+func (e *Epub) WriteDir(dirPath string) error {
+	return e.WriteDirContext(context.Background(), dirPath)
+}
+
+// WriteDirContext is like WriteDir, stopping when ctx is done as
+// WriteToContext does.
+func (e *Epub) WriteDirContext(ctx context.Context, dirPath string) (err error) {
+	entries, err := os.ReadDir(dirPath)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return &UnableToCreateEpubError{Path: dirPath, Err: err}
+	}
+	if len(entries) > 0 {
+		return &UnableToCreateEpubError{Path: dirPath, Err: errors.New("directory not empty")}
+	}
+
+	e.Lock()
+	defer e.Unlock()
+	pattern := e.tempFilePattern
+	if pattern == "" {
+		pattern = fmt.Sprintf(tempFilePattern, filepath.Base(dirPath))
+	}
+	tempDirPath, err := os.MkdirTemp(filepath.Dir(dirPath), pattern)
+	if err != nil {
+		return &UnableToCreateEpubError{Path: dirPath, Err: err}
+	}
+	// The temporary directory is removed if anything goes wrong, panics
+	// included
+	done := false
+	defer func() {
+		if !done {
+			if err := os.RemoveAll(tempDirPath); err != nil {
+				log.Printf("Error removing temporary directory: %s", err)
+			}
+		}
+	}()
+
+	_, err = e.write(ctx, func(rootEpubDir string) (int64, error) {
+		return e.writeOutput(rootEpubDir, dirOutput(tempDirPath))
+	})
+	if err != nil {
+		return err
+	}
+	// os.MkdirTemp creates the directory accessible by the owner only
+	if err = os.Chmod(tempDirPath, dirPermissions); err != nil {
+		return &UnableToCreateEpubError{Path: dirPath, Err: err}
+	}
+	if err = os.Remove(dirPath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return &UnableToCreateEpubError{Path: dirPath, Err: err}
+	}
+	if err = os.Rename(tempDirPath, dirPath); err != nil {
+		return &UnableToCreateEpubError{Path: dirPath, Err: err}
+	}
+	done = true
+	return nil
+}
+
+// copyFile copies the content of the file at p in the storage, whose path in
+// the EPUB is relativePath, to w, whether it is streamed from its source,
+// reused from the previous build or in the storage
+func (e *Epub) copyFile(g grabber, w io.Writer, p string, relativePath string) (int64, error) {
+	if m, ok := e.streamed[filepath.FromSlash(p)]; ok {
+		return g.copyMedia(w, m)
+	}
+	if r, ok := e.cache.open(relativePath); ok {
+		defer r.Close()
+		return io.Copy(w, r)
+	}
+	r, err := e.spool.open(p)
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+	return io.Copy(w, r)
+}
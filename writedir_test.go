@@ -0,0 +1,67 @@
+package epub
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteDir(t *testing.T) {
+	image, err := os.ReadFile(testImageFromFileSource)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := NewEpub(testEpubTitle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.SetIncrementalBuild(true)
+	if _, err := e.AddImage(testImageFromFileSource, ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.AddSection(testSectionBody, testSectionTitle, testSectionFilename, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	parent := t.TempDir()
+	// The second build reuses the image of the first one
+	for _, name := range []string{"first", "second"} {
+		dirPath := filepath.Join(parent, name)
+		if err := e.WriteDir(dirPath); err != nil {
+			t.Fatalf("Error writing EPUB to directory: %s", err)
+		}
+		mimetype, err := os.ReadFile(filepath.Join(dirPath, mimetypeFilename))
+		if err != nil || string(mimetype) != mediaTypeEpub {
+			t.Errorf("Unexpected mimetype file: %q, %v", mimetype, err)
+		}
+		for _, p := range []string{
+			filepath.Join(metaInfFolderName, containerFilename),
+			filepath.Join(contentFolderName, pkgFilename),
+			filepath.Join(contentFolderName, xhtmlFolderName, testSectionFilename),
+		} {
+			if _, err := os.Stat(filepath.Join(dirPath, p)); err != nil {
+				t.Errorf("Expected %s in the directory: %s", p, err)
+			}
+		}
+		content, err := os.ReadFile(filepath.Join(dirPath, contentFolderName, ImageFolderName, filepath.Base(testImageFromFileSource)))
+		if err != nil || !bytes.Equal(content, image) {
+			t.Errorf("Unexpected content of the image: %d bytes, %v", len(content), err)
+		}
+	}
+
+	entries, err := os.ReadDir(parent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("Temporary directory left behind: %v", entries)
+	}
+
+	err = e.WriteDir(filepath.Join(parent, "first"))
+	var createErr *UnableToCreateEpubError
+	if !errors.As(err, &createErr) {
+		t.Errorf("Expected UnableToCreateEpubError for a non-empty directory, got %v", err)
+	}
+}
@@ -3,6 +3,8 @@ package epub
 import (
 	"encoding/xml"
 	"fmt"
+	"io"
+	"strings"
 )
 
 const (
@@ -29,13 +31,19 @@ type xhtml struct {
 type xhtmlRoot struct {
 	XMLName   xml.Name      `xml:"http://www.w3.org/1999/xhtml html"`
 	XmlnsEpub string        `xml:"xmlns:epub,attr,omitempty"`
+	Lang      string        `xml:"lang,attr,omitempty"`
+	XMLLang   string        `xml:"xml:lang,attr,omitempty"`
+	Dir       string        `xml:"dir,attr,omitempty"`
 	Head      xhtmlHead     `xml:"head"`
 	Body      xhtmlInnerxml `xml:"body"`
 }
 
 type xhtmlHead struct {
-	Title xhtmlTitle `xml:"title"`
-	Link  *xhtmlLink
+	Title  xhtmlTitle   `xml:"title"`
+	Links  []xhtmlLink  `xml:"link"`
+	Styles []xhtmlStyle `xml:"style"`
+	// Markup added with SetSectionHead
+	Extra string `xml:",innerxml"`
 }
 
 type xhtmlTitle struct {
@@ -53,6 +61,13 @@ type xhtmlLink struct {
 	Href    string   `xml:"href,attr,omitempty"`
 }
 
+// The <style> element, used to embed a stylesheet in standalone sections
+type xhtmlStyle struct {
+	XMLName xml.Name `xml:"style"`
+	Type    string   `xml:"type,attr,omitempty"`
+	CSS     string   `xml:",innerxml"`
+}
+
 // This holds the content of the XHTML document between the <body> tags. It is
 // implemented as a string because we don't know what it will contain and we
 // leave it up to the user of the package to validate the content
@@ -85,19 +100,118 @@ func newXhtmlRoot() (*xhtmlRoot, error) {
 	if err != nil {
 		return nil, fmt.Errorf("Error unmarshalling xhtmlRoot: %w\n"+"\txhtmlRoot=%#v\n"+"\txhtmlTemplate=%s", err, *r, xhtmlTemplate)
 	}
+	// Unmarshal stores the whole content of the head as extra markup
+	r.Head.Extra = ""
 	return r, nil
 }
 
+// readBody reads the content of a body from r, checking that it is well-formed
+// XML as it is read. HTML named entities are rejected, as XHTML doesn't define
+// them.
+func readBody(r io.Reader) (string, error) {
+	var b strings.Builder
+	// The body may hold several root elements, wrap it to parse it as a
+	// document
+	d := xml.NewDecoder(io.MultiReader(
+		strings.NewReader("<body>"),
+		io.TeeReader(r, &b),
+		strings.NewReader("</body>"),
+	))
+	for {
+		_, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", fmt.Errorf("invalid body: %w", err)
+		}
+	}
+	return b.String(), nil
+}
+
 func (x *xhtml) setBody(body string) {
 	x.xml.Body.XML = "\n" + body + "\n"
 	x.xml.Body.Dir = "auto"
 }
 
+// copy returns a copy of the XHTML that can be changed independently
+func (x *xhtml) copy() *xhtml {
+	root := *x.xml
+	root.Head.Links = append([]xhtmlLink(nil), root.Head.Links...)
+	root.Head.Styles = append([]xhtmlStyle(nil), root.Head.Styles...)
+	return &xhtml{xml: &root}
+}
+
+// withBody returns a copy of the XHTML with a different body
+func (x *xhtml) withBody(body string) *xhtml {
+	c := x.copy()
+	c.xml.Body.XML = "\n" + body + "\n"
+	return c
+}
+
+// body returns the body as it was set with setBody
+func (x *xhtml) body() string {
+	return strings.TrimSuffix(strings.TrimPrefix(x.xml.Body.XML, "\n"), "\n")
+}
+
+// setCSS replaces the linked stylesheets with the one at path
 func (x *xhtml) setCSS(path string) {
-	x.xml.Head.Link = &xhtmlLink{
+	x.xml.Head.Links = nil
+	x.addCSS(path)
+}
+
+// addCSS links the stylesheet at path after the ones already linked, unless it
+// is already linked
+func (x *xhtml) addCSS(path string) {
+	for _, link := range x.xml.Head.Links {
+		if link.Href == path {
+			return
+		}
+	}
+	x.xml.Head.Links = append(x.xml.Head.Links, xhtmlLink{
 		Rel:  xhtmlLinkRel,
 		Type: mediaTypeCSS,
 		Href: path,
+	})
+}
+
+// prependCSS links the stylesheets at paths before the ones already linked, so
+// that these take precedence
+func (x *xhtml) prependCSS(paths []string) {
+	links := x.xml.Head.Links
+	x.xml.Head.Links = nil
+	for _, path := range paths {
+		x.addCSS(path)
+	}
+	for _, link := range links {
+		x.addCSS(link.Href)
+	}
+}
+
+// cssPaths returns the paths of the linked stylesheets in order
+func (x *xhtml) cssPaths() []string {
+	var paths []string
+	for _, link := range x.xml.Head.Links {
+		paths = append(paths, link.Href)
+	}
+	return paths
+}
+
+// addStyle embeds a stylesheet after the ones already embedded
+func (x *xhtml) addStyle(css string) {
+	// The CDATA section is commented out so that the stylesheet works whether
+	// the file is parsed as XML or HTML
+	x.xml.Head.Styles = append(x.xml.Head.Styles, xhtmlStyle{
+		Type: mediaTypeCSS,
+		CSS:  "/*<![CDATA[*/\n" + strings.ReplaceAll(css, "]]>", "]]]]><![CDATA[>") + "\n/*]]>*/",
+	})
+}
+
+// setHead sets the extra markup of the head
+func (x *xhtml) setHead(extraHead string) {
+	x.xml.Head.Extra = ""
+	if extraHead != "" {
+		x.xml.Head.Extra = "\n" + extraHead + "\n"
 	}
 }
 
@@ -108,6 +222,20 @@ func (x *xhtml) setTitle(title string) {
 	}
 }
 
+// setLang sets the language of the document, for both HTML and XML parsers
+func (x *xhtml) setLang(lang string) {
+	x.xml.Lang = lang
+	x.xml.XMLLang = lang
+}
+
+// setDir sets the base direction of the document, e.g. "rtl". It must be
+// called after setBody and setTitle, which reset the direction to auto.
+func (x *xhtml) setDir(dir string) {
+	x.xml.Dir = dir
+	x.xml.Head.Title.Dir = dir
+	x.xml.Body.Dir = dir
+}
+
 func (x *xhtml) setXmlnsEpub(xmlns string) {
 	x.xml.XmlnsEpub = xmlns
 }
@@ -118,9 +246,22 @@ func (x *xhtml) Title() string {
 
 // Write the XHTML file to the specified path
 func (x *xhtml) write(xhtmlFilePath string) error {
+	xhtmlFileContent, err := x.content()
+	if err != nil {
+		return err
+	}
+
+	if err := filesystem.WriteFile(xhtmlFilePath, xhtmlFileContent, filePermissions); err != nil {
+		return fmt.Errorf("Error writing XHTML file: %w", err)
+	}
+	return nil
+}
+
+// content returns the XHTML file content
+func (x *xhtml) content() ([]byte, error) {
 	xhtmlFileContent, err := xml.MarshalIndent(x.xml, "", "  ")
 	if err != nil {
-		return fmt.Errorf("Error marshalling XML for XHTML file: %w\n"+"\tXML=%v", err, x.xml)
+		return nil, fmt.Errorf("Error marshalling XML for XHTML file: %w\n"+"\tXML=%v", err, x.xml)
 	}
 
 	// Add the doctype declaration to the output
@@ -129,9 +270,5 @@ func (x *xhtml) write(xhtmlFilePath string) error {
 	xhtmlFileContent = append([]byte(xml.Header), xhtmlFileContent...)
 	// It's generally nice to have files end with a newline
 	xhtmlFileContent = append(xhtmlFileContent, "\n"...)
-
-	if err := filesystem.WriteFile(xhtmlFilePath, []byte(xhtmlFileContent), filePermissions); err != nil {
-		return fmt.Errorf("Error writing XHTML file: %w", err)
-	}
-	return nil
+	return xhtmlFileContent, nil
 }